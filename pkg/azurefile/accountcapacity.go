@@ -0,0 +1,148 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	volumehelper "sigs.k8s.io/azurefile-csi-driver/pkg/util"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+const (
+	// default TTL of account usage cache, usage of an account is read at most once per TTL by CreateVolume
+	defaultAccountUsageCacheTTL = 5 * time.Minute
+	// capacity of storage accounts, premium account is limited by provisioned size of its file shares (100TiB),
+	// standard account is limited by the data stored (5PiB), large file shares only raises the maximum size of one share
+	premiumAccountCapacityGiB  = 100 * 1024
+	standardAccountCapacityGiB = 5 * 1024 * 1024
+)
+
+// accountCapacityExceededKey is the context key of the account which could not fit the requested file share,
+// CreateVolume retries with this key set and the account excluded from matching in the request
+type accountCapacityExceededKey struct{}
+
+// accountUsage is the used and total capacity of a storage account in GiB, used capacity of premium account is the
+// provisioned quota of all file shares, which is what is billed and limited, used capacity of standard account is the
+// data stored on all file shares
+type accountUsage struct {
+	usedGiB     int64
+	capacityGiB int64
+	// quota of new file shares counts as used capacity
+	provisioned bool
+}
+
+// accountHasCapacity returns false if the remaining capacity of the account could not fit a file share of requestGiB,
+// usage is cached per account for accountUsageCacheTTL to bound ARM requests, so it may not reflect recent changes
+func (d *Driver) accountHasCapacity(ctx context.Context, subsID, resourceGroup, accountName string, requestGiB int) (bool, error) {
+	usage, err := d.getAccountUsage(ctx, subsID, resourceGroup, accountName)
+	if err != nil {
+		return false, err
+	}
+	if usage.usedGiB+int64(requestGiB) > usage.capacityGiB {
+		klog.V(2).Infof("storage account(%s) has %d GiB used out of %d GiB capacity, could not fit %d GiB", accountName, usage.usedGiB, usage.capacityGiB, requestGiB)
+		return false, nil
+	}
+	return true, nil
+}
+
+// getAccountUsage returns cached usage of the account, or lists file shares on the account once to read the usage,
+// usage bytes of file shares on standard account are only returned by getting each file share with stats
+func (d *Driver) getAccountUsage(ctx context.Context, subsID, resourceGroup, accountName string) (accountUsage, error) {
+	key := getAccountUsageCacheKey(subsID, resourceGroup, accountName)
+	cache, err := d.accountUsageCache.Get(key, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return accountUsage{}, err
+	}
+	if cache != nil {
+		return cache.(accountUsage), nil
+	}
+
+	cloud := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	if cloud.StorageAccountClient == nil {
+		return accountUsage{}, fmt.Errorf("storage account client is nil")
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return accountUsage{}, fmt.Errorf("failed to get properties of account(%s): %v", accountName, rerr.Error())
+	}
+	shares, err := cloud.FileClient.WithSubscriptionID(subsID).ListFileShare(ctx, resourceGroup, accountName, "", "")
+	if err != nil {
+		return accountUsage{}, fmt.Errorf("failed to list file shares on account(%s): %v", accountName, err)
+	}
+
+	usage := accountUsage{capacityGiB: getAccountCapacityGiB(account), provisioned: account.Kind == storage.KindFileStorage}
+	var usedBytes int64
+	for _, share := range shares {
+		if share.Name == nil || share.FileShareProperties == nil || pointer.BoolDeref(share.Deleted, false) {
+			continue
+		}
+		if usage.provisioned {
+			usage.usedGiB += int64(pointer.Int32Deref(share.ShareQuota, 0))
+			continue
+		}
+		fileShare, err := cloud.FileClient.WithSubscriptionID(subsID).GetFileShare(ctx, resourceGroup, accountName, *share.Name, "")
+		if err != nil {
+			return accountUsage{}, fmt.Errorf("failed to get stats of file share(%s) on account(%s): %v", *share.Name, accountName, err)
+		}
+		if fileShare.FileShareProperties != nil {
+			usedBytes += pointer.Int64Deref(fileShare.ShareUsageBytes, 0)
+		}
+	}
+	if !usage.provisioned {
+		usage.usedGiB = volumehelper.RoundUpGiB(usedBytes)
+	}
+	klog.V(2).Infof("storage account(%s) has %d GiB used out of %d GiB capacity on %d file shares", accountName, usage.usedGiB, usage.capacityGiB, len(shares))
+	d.accountUsageCache.Set(key, usage)
+	return usage, nil
+}
+
+// addAccountUsage adds quota of the file share created to the cached usage of premium account, so that file shares
+// created within the TTL are counted, a new file share on standard account stores no data yet, the cache entry is not
+// refreshed and still expires after the TTL
+func (d *Driver) addAccountUsage(subsID, resourceGroup, accountName string, quotaGiB int) {
+	obj, exists, err := d.accountUsageCache.Store.GetByKey(getAccountUsageCacheKey(subsID, resourceGroup, accountName))
+	if err != nil || !exists {
+		return
+	}
+	entry := obj.(*azcache.AzureCacheEntry)
+	entry.Lock.Lock()
+	defer entry.Lock.Unlock()
+	if usage, ok := entry.Data.(accountUsage); ok && usage.provisioned {
+		usage.usedGiB += int64(quotaGiB)
+		entry.Data = usage
+	}
+}
+
+func getAccountUsageCacheKey(subsID, resourceGroup, accountName string) string {
+	return strings.Join([]string{subsID, resourceGroup, accountName}, "/")
+}
+
+// getAccountCapacityGiB returns the capacity limit of the account
+func getAccountCapacityGiB(account storage.Account) int64 {
+	if account.Kind == storage.KindFileStorage {
+		return premiumAccountCapacityGiB
+	}
+	return standardAccountCapacityGiB
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestGetAccountCapacityGiB(t *testing.T) {
+	tests := []struct {
+		desc     string
+		account  storage.Account
+		expected int64
+	}{
+		{
+			desc:     "premium account",
+			account:  storage.Account{Kind: storage.KindFileStorage},
+			expected: premiumAccountCapacityGiB,
+		},
+		{
+			desc:     "standard account with large file shares",
+			account:  storage.Account{Kind: storage.KindStorageV2, AccountProperties: &storage.AccountProperties{LargeFileSharesState: storage.LargeFileSharesStateEnabled}},
+			expected: standardAccountCapacityGiB,
+		},
+		{
+			desc:     "standard account without large file shares",
+			account:  storage.Account{Kind: storage.KindStorageV2},
+			expected: standardAccountCapacityGiB,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getAccountCapacityGiB(test.account), test.desc)
+	}
+}
+
+func TestAccountHasCapacity(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+
+	// premium account counts provisioned quota of live file shares
+	premiumShares := []storage.FileShareItem{
+		{Name: pointer.String("share1"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100000)}},
+		{Name: pointer.String("share2"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(2000), Deleted: pointer.Bool(true)}},
+	}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "premium").Return(storage.Account{Kind: storage.KindFileStorage}, nil).Times(1)
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "premium", "", "").Return(premiumShares, nil).Times(1)
+	hasCapacity, err := d.accountHasCapacity(context.Background(), "subsID", "rg", "premium", 2400)
+	assert.NoError(t, err)
+	assert.True(t, hasCapacity)
+	// usage is cached
+	hasCapacity, err = d.accountHasCapacity(context.Background(), "subsID", "rg", "premium", 2500)
+	assert.NoError(t, err)
+	assert.False(t, hasCapacity)
+	// quota of file share created on premium account is added to the cached usage
+	d.addAccountUsage("subsID", "rg", "premium", 100)
+	hasCapacity, err = d.accountHasCapacity(context.Background(), "subsID", "rg", "premium", 2300)
+	assert.NoError(t, err)
+	assert.True(t, hasCapacity)
+	hasCapacity, err = d.accountHasCapacity(context.Background(), "subsID", "rg", "premium", 2301)
+	assert.NoError(t, err)
+	assert.False(t, hasCapacity)
+
+	// standard account without large file shares counts data stored on file shares, not their quota,
+	// so it's still selected with more than 5TiB quota provisioned
+	standardShares := []storage.FileShareItem{
+		{Name: pointer.String("share1"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(5120)}},
+		{Name: pointer.String("share2"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(5120)}},
+		{Name: pointer.String("share3"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(5120), Deleted: pointer.Bool(true)}},
+	}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "standard").Return(storage.Account{Kind: storage.KindStorageV2}, nil).Times(1)
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "standard", "", "").Return(standardShares, nil).Times(1)
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "standard", "share1", "").Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(5120), ShareUsageBytes: pointer.Int64(3 * 1024 * 1024 * 1024 * 1024)}}, nil).Times(1)
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "standard", "share2", "").Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(5120), ShareUsageBytes: pointer.Int64(1)}}, nil).Times(1)
+	hasCapacity, err = d.accountHasCapacity(context.Background(), "subsID", "rg", "standard", 5120)
+	assert.NoError(t, err)
+	assert.True(t, hasCapacity)
+	// quota of file share created on standard account is not added to the cached usage
+	d.addAccountUsage("subsID", "rg", "standard", 5120)
+	hasCapacity, err = d.accountHasCapacity(context.Background(), "subsID", "rg", "standard", standardAccountCapacityGiB-3*1024-1)
+	assert.NoError(t, err)
+	assert.True(t, hasCapacity)
+	hasCapacity, err = d.accountHasCapacity(context.Background(), "subsID", "rg", "standard", standardAccountCapacityGiB-3*1024)
+	assert.NoError(t, err)
+	assert.False(t, hasCapacity)
+	// usage of an account not cached is not added
+	d.addAccountUsage("subsID", "rg", "uncached", 100)
+	cache, err := d.accountUsageCache.Get("subsID/rg/uncached", azcache.CacheReadTypeUnsafe)
+	assert.NoError(t, err)
+	assert.Nil(t, cache)
+
+	// usage read failure is returned and not cached
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "error").Return(storage.Account{}, &retry.Error{RawError: fmt.Errorf("test error")}).Times(2)
+	_, err = d.accountHasCapacity(context.Background(), "subsID", "rg", "error", 100)
+	assert.Error(t, err)
+	_, err = d.accountHasCapacity(context.Background(), "subsID", "rg", "error", 100)
+	assert.Error(t, err)
+}
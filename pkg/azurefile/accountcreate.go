@@ -0,0 +1,285 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+// accountCreateHookKey is the context key of the accountCreateHook of EnsureStorageAccount in CreateVolume
+type accountCreateHookKey struct{}
+
+// excludedAccountsKey is the context key of the storage accounts which are not matched when CreateVolume selects a
+// storage account again in the same request, e.g. the storage account selected could not fit the file share
+type excludedAccountsKey struct{}
+
+// withExcludedAccount returns a context in which the storage account is not matched, together with the storage
+// accounts already excluded in ctx
+func withExcludedAccount(ctx context.Context, accountName string) context.Context {
+	excluded := append([]string{accountName}, getExcludedAccounts(ctx)...)
+	return context.WithValue(ctx, excludedAccountsKey{}, excluded)
+}
+
+// getExcludedAccounts returns the storage accounts which are not matched in ctx
+func getExcludedAccounts(ctx context.Context) []string {
+	excluded, _ := ctx.Value(excludedAccountsKey{}).([]string)
+	return excluded
+}
+
+// isAccountExcluded returns whether the storage account is not matched in ctx
+func isAccountExcluded(ctx context.Context, accountName string) bool {
+	for _, excluded := range getExcludedAccounts(ctx) {
+		if strings.EqualFold(excluded, accountName) {
+			return true
+		}
+	}
+	return false
+}
+
+// accountCreateHook is called by accountCreateHookClient when EnsureStorageAccount lists storage accounts to match
+// and creates a storage account, so that CreateVolume knows whether the account is created without listing accounts
+// again, and sets the account properties which are not supported in account options of cloud provider and the ownership
+// tags in the create request
+type accountCreateHook struct {
+	d              *Driver
+	cloud          *azure.Cloud
+	accountOptions *azure.AccountOptions
+	// public network access of the storage account created
+	publicNetworkAccess storage.PublicNetworkAccess
+	// routing preference of the storage account created, existing accounts with a different routing preference are
+	// not matched
+	routingPreference *storage.RoutingPreference
+	// the private endpoint is created by the driver instead of cloud provider, only existing accounts with private
+	// endpoint connections are matched and public network access of the account created is denied
+	privateEndpointByDriver bool
+
+	mu sync.Mutex
+	// storage accounts listed to match, before they are prepared
+	accounts []storage.Account
+	// tags of the resource group to inherit, they are read once
+	rgTags     map[string]*string
+	rgTagsRead bool
+	// name of the storage account created
+	created string
+}
+
+func (d *Driver) newAccountCreateHook(cloud *azure.Cloud, accountOptions *azure.AccountOptions) *accountCreateHook {
+	return &accountCreateHook{d: d, cloud: cloud, accountOptions: accountOptions}
+}
+
+// onList is called on storage accounts listed to match, it returns the accounts to match
+func (h *accountCreateHook) onList(ctx context.Context, accounts []storage.Account) []storage.Account {
+	if len(getExcludedAccounts(ctx)) > 0 {
+		// storage accounts excluded in this request are not matched, they are still matched by other requests
+		kept := make([]storage.Account, 0, len(accounts))
+		for _, acct := range accounts {
+			if isAccountExcluded(ctx, pointer.StringDeref(acct.Name, "")) {
+				klog.V(2).Infof("storage account(%s) is excluded from matching in this request", pointer.StringDeref(acct.Name, ""))
+				continue
+			}
+			kept = append(kept, acct)
+		}
+		accounts = kept
+	}
+	h.mu.Lock()
+	h.accounts = append([]storage.Account(nil), accounts...)
+	h.mu.Unlock()
+
+	h.d.prepareV1Accounts(ctx, h.cloud, h.accountOptions, accounts)
+	if h.accountOptions.MatchTags {
+		// ownership tags are set on the account created, they are not matched
+		for i := range accounts {
+			accounts[i].Tags = removeOwnershipTags(accounts[i].Tags, h.accountOptions.Tags)
+		}
+	}
+	if h.accountOptions.MatchTags && len(h.d.inheritResourceGroupTags) > 0 {
+		// tags inherited from the resource group are only set on the account created, they are not matched
+		rgTags, err := h.getResourceGroupTags(ctx)
+		if err != nil {
+			klog.Warningf("accounts with tags inherited from resource group(%s) may not be matched: %v", h.accountOptions.ResourceGroup, err)
+		}
+		for i := range accounts {
+			accounts[i].Tags = removeInheritedTags(accounts[i].Tags, rgTags, h.d.inheritResourceGroupTags, h.accountOptions.Tags)
+		}
+	}
+	if h.routingPreference == nil && !h.privateEndpointByDriver {
+		return accounts
+	}
+	matched := make([]storage.Account, 0, len(accounts))
+	for _, acct := range accounts {
+		if !h.isRoutingPreferenceMatched(acct) {
+			continue
+		}
+		if h.privateEndpointByDriver {
+			if acct.AccountProperties == nil || acct.AccountProperties.PrivateEndpointConnections == nil || len(*acct.AccountProperties.PrivateEndpointConnections) == 0 {
+				continue
+			}
+			// cloud provider does not match an account with private endpoint connections if it does not create the private endpoint
+			properties := *acct.AccountProperties
+			properties.PrivateEndpointConnections = nil
+			acct.AccountProperties = &properties
+		}
+		matched = append(matched, acct)
+	}
+	return matched
+}
+
+// isRoutingPreferenceMatched returns whether the storage account has the routing preference to set
+func (h *accountCreateHook) isRoutingPreferenceMatched(account storage.Account) bool {
+	if h.routingPreference == nil {
+		return true
+	}
+	current := &storage.RoutingPreference{}
+	if account.AccountProperties != nil && account.AccountProperties.RoutingPreference != nil {
+		current = account.AccountProperties.RoutingPreference
+	}
+	return isRoutingPreferenceEqual(current, h.routingPreference)
+}
+
+// getResourceGroupTags returns tags of the resource group to inherit
+func (h *accountCreateHook) getResourceGroupTags(ctx context.Context) (map[string]*string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.rgTagsRead {
+		rgTags, err := h.d.getResourceGroupTagsToInherit(ctx, h.cloud, h.accountOptions.SubscriptionID, h.accountOptions.ResourceGroup)
+		if err != nil {
+			return nil, err
+		}
+		h.rgTags, h.rgTagsRead = rgTags, true
+	}
+	return h.rgTags, nil
+}
+
+// onCreate is called on the parameters of the storage account to create
+func (h *accountCreateHook) onCreate(ctx context.Context, parameters *storage.AccountCreateParameters) error {
+	if parameters.AccountPropertiesCreateParameters == nil {
+		parameters.AccountPropertiesCreateParameters = &storage.AccountPropertiesCreateParameters{}
+	}
+	if h.publicNetworkAccess != "" {
+		parameters.AccountPropertiesCreateParameters.PublicNetworkAccess = h.publicNetworkAccess
+	}
+	if h.routingPreference != nil {
+		parameters.AccountPropertiesCreateParameters.RoutingPreference = h.routingPreference
+	}
+	if h.privateEndpointByDriver {
+		parameters.AccountPropertiesCreateParameters.NetworkRuleSet = &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionDeny}
+	}
+	if len(h.d.inheritResourceGroupTags) > 0 {
+		rgTags, err := h.getResourceGroupTags(ctx)
+		if err != nil {
+			return err
+		}
+		tags := make(map[string]string, len(parameters.Tags))
+		for k, v := range parameters.Tags {
+			tags[k] = pointer.StringDeref(v, "")
+		}
+		parameters.Tags = make(map[string]*string, len(tags))
+		for k, v := range mergeResourceGroupTags(tags, rgTags, h.d.inheritResourceGroupTags) {
+			parameters.Tags[k] = pointer.String(v)
+		}
+	}
+	// only a storage account created by CreateVolume is owned by the driver, it could be deleted when it's empty
+	if parameters.Tags == nil {
+		parameters.Tags = make(map[string]*string)
+	}
+	for k, v := range getAccountOwnershipTags(h.d.shareNameNamespace) {
+		parameters.Tags[k] = v
+	}
+	return nil
+}
+
+// onCreated is called after the storage account is created
+func (h *accountCreateHook) onCreated(accountName string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.created = accountName
+}
+
+// isCreated returns whether the storage account is created by EnsureStorageAccount
+func (h *accountCreateHook) isCreated(accountName string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.created != "" && strings.EqualFold(h.created, accountName)
+}
+
+// getAccountMismatches returns the number of storage accounts listed to match per first mismatched property
+func (h *accountCreateHook) getAccountMismatches() map[string]int {
+	mismatches := getAccountMismatches(h.getListedAccounts(), h.accountOptions)
+	for _, acct := range h.getListedAccounts() {
+		if getAccountMismatch(acct, h.accountOptions) == accountMismatchOther && !h.isRoutingPreferenceMatched(acct) {
+			mismatches[accountMismatchOther]--
+			mismatches[accountMismatchRouting]++
+		}
+	}
+	return mismatches
+}
+
+// getListedAccounts returns storage accounts listed to match
+func (h *accountCreateHook) getListedAccounts() []storage.Account {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.accounts
+}
+
+// accountCreateHookClient calls the accountCreateHook in context on listing and creating storage accounts
+type accountCreateHookClient struct {
+	storageaccountclient.Interface
+}
+
+// setAccountCreateHookClient wraps the storage account client of cloud provider with accountCreateHookClient
+func setAccountCreateHookClient(az *azure.Cloud) {
+	if az == nil || az.StorageAccountClient == nil {
+		return
+	}
+	if _, ok := az.StorageAccountClient.(*accountCreateHookClient); ok {
+		return
+	}
+	az.StorageAccountClient = &accountCreateHookClient{Interface: az.StorageAccountClient}
+}
+
+func (c *accountCreateHookClient) ListByResourceGroup(ctx context.Context, subsID, resourceGroupName string) ([]storage.Account, *retry.Error) {
+	accounts, rerr := c.Interface.ListByResourceGroup(ctx, subsID, resourceGroupName)
+	hook, ok := ctx.Value(accountCreateHookKey{}).(*accountCreateHook)
+	if !ok || rerr != nil {
+		return accounts, rerr
+	}
+	return hook.onList(ctx, accounts), nil
+}
+
+func (c *accountCreateHookClient) Create(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+	hook, ok := ctx.Value(accountCreateHookKey{}).(*accountCreateHook)
+	if ok {
+		if err := hook.onCreate(ctx, &parameters); err != nil {
+			return retry.NewError(false, err)
+		}
+	}
+	rerr := c.Interface.Create(ctx, subsID, resourceGroupName, accountName, parameters)
+	if ok && rerr == nil {
+		hook.onCreated(accountName)
+	}
+	return rerr
+}
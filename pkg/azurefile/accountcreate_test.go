@@ -0,0 +1,259 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestSetAccountCreateHookClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	setAccountCreateHookClient(nil)
+	cloud := &azure.Cloud{}
+	setAccountCreateHookClient(cloud)
+	assert.Nil(t, cloud.StorageAccountClient)
+
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	cloud.StorageAccountClient = mockStorageAccountsClient
+	setAccountCreateHookClient(cloud)
+	client, ok := cloud.StorageAccountClient.(*accountCreateHookClient)
+	assert.True(t, ok)
+	setAccountCreateHookClient(cloud)
+	assert.Equal(t, client, cloud.StorageAccountClient)
+	assert.Equal(t, mockStorageAccountsClient, client.Interface)
+}
+
+func TestAccountCreateHookClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	setAccountCreateHookClient(d.cloud)
+	accounts := []storage.Account{{Name: pointer.String("existing"), Kind: storage.KindStorageV2}}
+
+	// the hook is only called in EnsureStorageAccount of CreateVolume
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(accounts, nil)
+	result, rerr := d.cloud.StorageAccountClient.ListByResourceGroup(context.Background(), "subsID", "rg")
+	assert.Nil(t, rerr)
+	assert.Equal(t, accounts, result)
+
+	hook := d.newAccountCreateHook(d.cloud, &azure.AccountOptions{Kind: string(storage.KindStorageV2)})
+	ctx := context.WithValue(context.Background(), accountCreateHookKey{}, hook)
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(accounts, nil)
+	result, rerr = d.cloud.StorageAccountClient.ListByResourceGroup(ctx, "subsID", "rg")
+	assert.Nil(t, rerr)
+	assert.Equal(t, accounts, result)
+	assert.Equal(t, accounts, hook.getListedAccounts())
+
+	mockStorageAccountsClient.EXPECT().Create(gomock.Any(), "subsID", "rg", "failed", gomock.Any()).Return(&retry.Error{RawError: fmt.Errorf("create error")})
+	assert.NotNil(t, d.cloud.StorageAccountClient.Create(ctx, "subsID", "rg", "failed", storage.AccountCreateParameters{}))
+	assert.False(t, hook.isCreated("failed"))
+
+	hook.publicNetworkAccess = storage.PublicNetworkAccessDisabled
+	expectedParams := storage.AccountCreateParameters{
+		AccountPropertiesCreateParameters: &storage.AccountPropertiesCreateParameters{PublicNetworkAccess: storage.PublicNetworkAccessDisabled},
+		Tags:                              getAccountOwnershipTags(""),
+	}
+	mockStorageAccountsClient.EXPECT().Create(gomock.Any(), "subsID", "rg", "new", expectedParams).Return(nil)
+	assert.Nil(t, d.cloud.StorageAccountClient.Create(ctx, "subsID", "rg", "new", storage.AccountCreateParameters{}))
+	assert.True(t, hook.isCreated("new"))
+	assert.False(t, hook.isCreated("existing"))
+}
+
+func TestAccountCreateHookExcludedAccounts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	setAccountCreateHookClient(d.cloud)
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+	accounts := []storage.Account{
+		{Name: pointer.String("full"), Kind: storage.KindFileStorage},
+		{Name: pointer.String("other"), Kind: storage.KindFileStorage},
+	}
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(accounts, nil).AnyTimes()
+	// the premium account has 60TiB provisioned, no tag is set on it since no AddStorageAccountTags is expected
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "full").Return(storage.Account{Kind: storage.KindFileStorage}, nil).Times(1)
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "full", "", "").Return([]storage.FileShareItem{
+		{Name: pointer.String("share1"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(60 * 1024)}},
+	}, nil).Times(1)
+
+	// a 50TiB request could not fit in the account, it's excluded when the request selects an account again
+	hasCapacity, err := d.accountHasCapacity(context.Background(), "subsID", "rg", "full", 50*1024)
+	assert.NoError(t, err)
+	assert.False(t, hasCapacity)
+	hook := d.newAccountCreateHook(d.cloud, &azure.AccountOptions{Kind: string(storage.KindFileStorage)})
+	ctx := withExcludedAccount(context.WithValue(context.Background(), accountCreateHookKey{}, hook), "full")
+	result, rerr := d.cloud.StorageAccountClient.ListByResourceGroup(ctx, "subsID", "rg")
+	assert.Nil(t, rerr)
+	assert.Equal(t, []storage.Account{accounts[1]}, result)
+	// accounts excluded before are still excluded
+	ctx = withExcludedAccount(ctx, "other")
+	assert.True(t, isAccountExcluded(ctx, "FULL"))
+	result, rerr = d.cloud.StorageAccountClient.ListByResourceGroup(ctx, "subsID", "rg")
+	assert.Nil(t, rerr)
+	assert.Empty(t, result)
+
+	// a later smaller request still selects the account
+	hook = d.newAccountCreateHook(d.cloud, &azure.AccountOptions{Kind: string(storage.KindFileStorage)})
+	ctx = context.WithValue(context.Background(), accountCreateHookKey{}, hook)
+	assert.False(t, isAccountExcluded(ctx, "full"))
+	result, rerr = d.cloud.StorageAccountClient.ListByResourceGroup(ctx, "subsID", "rg")
+	assert.Nil(t, rerr)
+	assert.Equal(t, accounts, result)
+	hasCapacity, err = d.accountHasCapacity(ctx, "subsID", "rg", "full", 100)
+	assert.NoError(t, err)
+	assert.True(t, hasCapacity)
+}
+
+func TestAccountCreateHookOnCreate(t *testing.T) {
+	d := NewFakeDriver()
+	hook := d.newAccountCreateHook(d.cloud, &azure.AccountOptions{})
+	parameters := storage.AccountCreateParameters{}
+	assert.NoError(t, hook.onCreate(context.Background(), &parameters))
+	assert.Equal(t, storage.PublicNetworkAccess(""), parameters.AccountPropertiesCreateParameters.PublicNetworkAccess)
+
+	hook.publicNetworkAccess = storage.PublicNetworkAccessDisabled
+	parameters = storage.AccountCreateParameters{AccountPropertiesCreateParameters: &storage.AccountPropertiesCreateParameters{EnableHTTPSTrafficOnly: pointer.Bool(true)}}
+	assert.NoError(t, hook.onCreate(context.Background(), &parameters))
+	assert.Equal(t, storage.PublicNetworkAccessDisabled, parameters.AccountPropertiesCreateParameters.PublicNetworkAccess)
+	assert.True(t, *parameters.AccountPropertiesCreateParameters.EnableHTTPSTrafficOnly)
+
+	// the storage account created is owned by the driver in share name namespace
+	d.shareNameNamespace = "cluster1"
+	parameters = storage.AccountCreateParameters{Tags: map[string]*string{"app": pointer.String("web")}}
+	assert.NoError(t, hook.onCreate(context.Background(), &parameters))
+	assert.Equal(t, map[string]*string{"app": pointer.String("web"), createdByTag: pointer.String(createdByDriver), shareNameNamespaceTag: pointer.String("cluster1")}, parameters.Tags)
+
+	// ownership tags are not matched
+	hook = d.newAccountCreateHook(d.cloud, &azure.AccountOptions{Tags: map[string]string{"app": "web"}, MatchTags: true})
+	matched := hook.onList(context.Background(), []storage.Account{{Name: pointer.String("owned"), Tags: parameters.Tags}})
+	assert.Equal(t, map[string]*string{"app": pointer.String("web")}, matched[0].Tags)
+}
+
+func TestAccountCreateHookRoutingPreference(t *testing.T) {
+	internetRouting := &storage.RoutingPreference{RoutingChoice: storage.RoutingChoiceInternetRouting, PublishInternetEndpoints: pointer.Bool(true)}
+	accounts := []storage.Account{
+		{Name: pointer.String("default")},
+		{Name: pointer.String("internet"), AccountProperties: &storage.AccountProperties{RoutingPreference: &storage.RoutingPreference{RoutingChoice: storage.RoutingChoiceInternetRouting, PublishInternetEndpoints: pointer.Bool(true), PublishMicrosoftEndpoints: pointer.Bool(false)}}},
+		{Name: pointer.String("microsoft"), AccountProperties: &storage.AccountProperties{RoutingPreference: &storage.RoutingPreference{RoutingChoice: storage.RoutingChoiceMicrosoftRouting}}},
+	}
+
+	d := NewFakeDriver()
+	hook := d.newAccountCreateHook(d.cloud, &azure.AccountOptions{})
+	assert.Len(t, hook.onList(context.Background(), accounts), 3)
+
+	// existing accounts are not updated, only accounts with the same routing preference are matched
+	hook.routingPreference = internetRouting
+	matched := hook.onList(context.Background(), accounts)
+	assert.Len(t, matched, 1)
+	assert.Equal(t, "internet", *matched[0].Name)
+	assert.Len(t, hook.getListedAccounts(), 3)
+	assert.Equal(t, map[string]int{accountMismatchOther: 1, accountMismatchRouting: 2}, hook.getAccountMismatches())
+
+	hook.routingPreference = &storage.RoutingPreference{RoutingChoice: storage.RoutingChoiceMicrosoftRouting}
+	assert.Len(t, hook.onList(context.Background(), accounts), 2)
+
+	hook.routingPreference = internetRouting
+	parameters := storage.AccountCreateParameters{}
+	assert.NoError(t, hook.onCreate(context.Background(), &parameters))
+	assert.Equal(t, internetRouting, parameters.AccountPropertiesCreateParameters.RoutingPreference)
+}
+
+func TestAccountCreateHookPrivateEndpointByDriver(t *testing.T) {
+	connections := &[]storage.PrivateEndpointConnection{{Name: pointer.String("connection")}}
+	accounts := []storage.Account{
+		{Name: pointer.String("no-properties")},
+		{Name: pointer.String("no-private-endpoint"), AccountProperties: &storage.AccountProperties{}},
+		{Name: pointer.String("private-endpoint"), AccountProperties: &storage.AccountProperties{PrivateEndpointConnections: connections}},
+	}
+
+	d := NewFakeDriver()
+	hook := d.newAccountCreateHook(d.cloud, &azure.AccountOptions{})
+	hook.privateEndpointByDriver = true
+	// only accounts with private endpoint are matched, cloud provider expects no private endpoint without creating it
+	matched := hook.onList(context.Background(), accounts)
+	assert.Len(t, matched, 1)
+	assert.Equal(t, "private-endpoint", *matched[0].Name)
+	assert.Nil(t, matched[0].AccountProperties.PrivateEndpointConnections)
+	assert.Equal(t, connections, accounts[2].AccountProperties.PrivateEndpointConnections)
+
+	parameters := storage.AccountCreateParameters{}
+	assert.NoError(t, hook.onCreate(context.Background(), &parameters))
+	assert.Equal(t, &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionDeny}, parameters.AccountPropertiesCreateParameters.NetworkRuleSet)
+}
+
+func TestAccountCreateHookResourceGroupTags(t *testing.T) {
+	d := NewFakeDriver()
+	d.inheritResourceGroupTags = []string{"owner"}
+	reads := 0
+	d.getResourceGroupTags = func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error) {
+		reads++
+		assert.Equal(t, "rg", resourceGroup)
+		return map[string]*string{"owner": pointer.String("team1"), "env": pointer.String("prod")}, nil
+	}
+	accounts := []storage.Account{
+		{Name: pointer.String("inherited"), Tags: map[string]*string{"app": pointer.String("web"), "owner": pointer.String("team1")}},
+		{Name: pointer.String("other-owner"), Tags: map[string]*string{"app": pointer.String("web"), "owner": pointer.String("team2")}},
+	}
+
+	// resource group tags are not read if tags are not matched
+	hook := d.newAccountCreateHook(d.cloud, &azure.AccountOptions{ResourceGroup: "rg", Tags: map[string]string{"app": "web"}})
+	hook.onList(context.Background(), []storage.Account{accounts[0]})
+	assert.Equal(t, 0, reads)
+
+	// inherited tags are not matched
+	hook = d.newAccountCreateHook(d.cloud, &azure.AccountOptions{ResourceGroup: "rg", Tags: map[string]string{"app": "web"}, MatchTags: true})
+	listed := append([]storage.Account(nil), accounts...)
+	matched := hook.onList(context.Background(), listed)
+	assert.Equal(t, map[string]*string{"app": pointer.String("web")}, matched[0].Tags)
+	assert.Equal(t, accounts[1].Tags, matched[1].Tags)
+	assert.Equal(t, accounts, hook.getListedAccounts())
+
+	// inherited tags are set on the account created, resource group tags are read once
+	parameters := storage.AccountCreateParameters{Tags: map[string]*string{"app": pointer.String("web")}}
+	assert.NoError(t, hook.onCreate(context.Background(), &parameters))
+	assert.Equal(t, map[string]*string{"app": pointer.String("web"), "owner": pointer.String("team1"), createdByTag: pointer.String(createdByDriver)}, parameters.Tags)
+	assert.Equal(t, 1, reads)
+
+	// account is not created if resource group tags could not be read
+	d.getResourceGroupTags = func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error) {
+		return nil, fmt.Errorf("timeout")
+	}
+	hook = d.newAccountCreateHook(d.cloud, &azure.AccountOptions{SubscriptionID: "subs", ResourceGroup: "rg"})
+	assert.Equal(t, fmt.Errorf("failed to get tags of resource group(rg) in subscription(subs): timeout"), hook.onCreate(context.Background(), &parameters))
+}
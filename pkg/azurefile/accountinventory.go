@@ -0,0 +1,164 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8smetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// only the holder of this lease collects account inventory, so replicas of the controller do not export duplicate gauges
+	accountInventoryLeaseName = "azurefile-csi-account-inventory"
+	// minimum interval of account inventory collection, each collection lists storage accounts in every resource group
+	// and file shares on every owned account
+	minAccountInventoryInterval = time.Minute
+)
+
+var (
+	accountShareCount = k8smetrics.NewGaugeVec(
+		&k8smetrics.GaugeOpts{
+			Subsystem:      azureFileCSIDriverName,
+			Name:           "account_share_count",
+			Help:           "Number of file shares on storage accounts owned by the driver, labeled by resource group and account",
+			StabilityLevel: k8smetrics.ALPHA,
+		},
+		[]string{"resource_group", "account"},
+	)
+	accountProvisionedGiB = k8smetrics.NewGaugeVec(
+		&k8smetrics.GaugeOpts{
+			Subsystem:      azureFileCSIDriverName,
+			Name:           "account_provisioned_gib",
+			Help:           "Total quota in GiB of file shares on storage accounts owned by the driver, labeled by resource group and account",
+			StabilityLevel: k8smetrics.ALPHA,
+		},
+		[]string{"resource_group", "account"},
+	)
+	registerAccountInventoryMetricsOnce sync.Once
+)
+
+// accountInventory is the number of file shares and their total quota on a storage account
+type accountInventory struct {
+	shareCount     int
+	provisionedGiB int64
+}
+
+func registerAccountInventoryMetrics() {
+	registerAccountInventoryMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(accountShareCount, accountProvisionedGiB)
+	})
+}
+
+// runAccountInventory exports file share count and provisioned quota of storage accounts owned by the driver in
+// resourceGroups periodically, only the replica holding the lease collects and exports the gauges
+func (d *Driver) runAccountInventory(interval time.Duration, resourceGroups []string) {
+	if d.cloud.KubeClient == nil || d.cloud.StorageAccountClient == nil {
+		klog.Warningf("KubeClient or StorageAccountClient is nil, account inventory is disabled")
+		return
+	}
+	identity := getLeaseHolderIdentity()
+	klog.V(2).Infof("start account inventory of resource groups(%v) every %v, holder identity(%s)", resourceGroups, interval, identity)
+	go wait.Forever(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		leader, err := d.acquireLease(ctx, d.leaseNamespace, accountInventoryLeaseName, identity, 2*interval)
+		if err != nil {
+			klog.Warningf("failed to acquire account inventory lease: %v", err)
+			return
+		}
+		if !leader {
+			klog.V(4).Infof("skip account inventory since lease(%s/%s) is held by another replica", d.leaseNamespace, accountInventoryLeaseName)
+			// gauges exported while this replica held the lease are out of date
+			accountShareCount.Reset()
+			accountProvisionedGiB.Reset()
+			return
+		}
+		d.collectAccountInventory(ctx, resourceGroups)
+	}, interval)
+}
+
+// collectAccountInventory updates gauges of storage accounts owned by the driver in resourceGroups, accounts
+// which are not owned by the driver are never enumerated. Gauges of an account are kept if its file shares
+// could not be listed, and removed after the account is deleted.
+func (d *Driver) collectAccountInventory(ctx context.Context, resourceGroups []string) {
+	collected := map[[2]string]bool{}
+	for _, resourceGroup := range resourceGroups {
+		accounts, rerr := d.cloud.StorageAccountClient.ListByResourceGroup(ctx, d.cloud.SubscriptionID, resourceGroup)
+		if rerr != nil {
+			klog.Warningf("failed to list storage accounts in resource group(%s) for account inventory: %v", resourceGroup, rerr.Error())
+			// keep gauges of the resource group until it could be listed again
+			d.accountInventoryCollected.Range(func(key, _ interface{}) bool {
+				if labels := key.([2]string); labels[0] == resourceGroup {
+					collected[labels] = true
+				}
+				return true
+			})
+			continue
+		}
+		for _, account := range accounts {
+			accountName := pointer.StringDeref(account.Name, "")
+			if accountName == "" || !isAccountOwned(account.Tags, d.shareNameNamespace) {
+				continue
+			}
+			labels := [2]string{resourceGroup, accountName}
+			collected[labels] = true
+			inventory, err := d.getAccountInventory(ctx, resourceGroup, accountName)
+			if err != nil {
+				klog.Warningf("failed to collect inventory of storage account(%s) in resource group(%s): %v", accountName, resourceGroup, err)
+				continue
+			}
+			accountShareCount.WithLabelValues(resourceGroup, accountName).Set(float64(inventory.shareCount))
+			accountProvisionedGiB.WithLabelValues(resourceGroup, accountName).Set(float64(inventory.provisionedGiB))
+		}
+	}
+	d.accountInventoryCollected.Range(func(key, _ interface{}) bool {
+		if labels := key.([2]string); !collected[labels] {
+			accountShareCount.Delete(map[string]string{"resource_group": labels[0], "account": labels[1]})
+			accountProvisionedGiB.Delete(map[string]string{"resource_group": labels[0], "account": labels[1]})
+			d.accountInventoryCollected.Delete(key)
+		}
+		return true
+	})
+	for labels := range collected {
+		d.accountInventoryCollected.Store(labels, true)
+	}
+}
+
+// getAccountInventory lists file shares on the account page by page, soft-deleted file shares are not counted
+func (d *Driver) getAccountInventory(ctx context.Context, resourceGroup, accountName string) (accountInventory, error) {
+	shares, err := d.cloud.FileClient.WithSubscriptionID(d.cloud.SubscriptionID).ListFileShare(ctx, resourceGroup, accountName, "", "")
+	if err != nil {
+		return accountInventory{}, fmt.Errorf("failed to list file shares: %v", err)
+	}
+	var inventory accountInventory
+	for _, share := range shares {
+		if share.Name == nil || share.FileShareProperties == nil || pointer.BoolDeref(share.Deleted, false) {
+			continue
+		}
+		inventory.shareCount++
+		inventory.provisionedGiB += int64(pointer.Int32Deref(share.ShareQuota, 0))
+	}
+	return inventory, nil
+}
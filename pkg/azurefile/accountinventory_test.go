@@ -0,0 +1,110 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+// getAccountInventoryGauge returns the value of the gauge of the account, false if it's not exported
+func getAccountInventoryGauge(t *testing.T, name, resourceGroup, accountName string) (float64, bool) {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != azureFileCSIDriverName+"_"+name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["resource_group"] == resourceGroup && labels["account"] == accountName {
+				return metric.GetGauge().GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestCollectAccountInventory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.SubscriptionID = "subsID"
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+
+	owned := storage.Account{Name: pointer.String("owned"), Tags: getAccountOwnershipTags("")}
+	broken := storage.Account{Name: pointer.String("broken"), Tags: getAccountOwnershipTags("")}
+	// file shares on accounts not owned by the driver are never listed
+	userOwned := storage.Account{Name: pointer.String("user")}
+	otherNamespace := storage.Account{Name: pointer.String("other"), Tags: getAccountOwnershipTags("cluster-b")}
+	shares := []storage.FileShareItem{
+		{Name: pointer.String("share1"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}},
+		{Name: pointer.String("share2"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(50)}},
+		{Name: pointer.String("deleted"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(1000), Deleted: pointer.Bool(true)}},
+	}
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return([]storage.Account{owned, broken, userOwned, otherNamespace}, nil).Times(1)
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "owned", "", "").Return(shares, nil).Times(1)
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "broken", "", "").Return(nil, fmt.Errorf("StatusCode=429")).Times(1)
+
+	d.collectAccountInventory(context.Background(), []string{"rg"})
+	count, ok := getAccountInventoryGauge(t, "account_share_count", "rg", "owned")
+	assert.True(t, ok)
+	assert.Equal(t, float64(2), count)
+	provisioned, ok := getAccountInventoryGauge(t, "account_provisioned_gib", "rg", "owned")
+	assert.True(t, ok)
+	assert.Equal(t, float64(150), provisioned)
+	for _, accountName := range []string{"broken", "user", "other"} {
+		_, ok := getAccountInventoryGauge(t, "account_share_count", "rg", accountName)
+		assert.False(t, ok, accountName)
+	}
+
+	// gauges are kept if the resource group could not be listed
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return(nil, &retry.Error{HTTPStatusCode: http.StatusTooManyRequests, RawError: fmt.Errorf("TooManyRequests")}).Times(1)
+	d.collectAccountInventory(context.Background(), []string{"rg"})
+	_, ok = getAccountInventoryGauge(t, "account_share_count", "rg", "owned")
+	assert.True(t, ok)
+
+	// gauges of a deleted account are removed
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return([]storage.Account{userOwned}, nil).Times(1)
+	d.collectAccountInventory(context.Background(), []string{"rg"})
+	_, ok = getAccountInventoryGauge(t, "account_share_count", "rg", "owned")
+	assert.False(t, ok)
+	_, ok = getAccountInventoryGauge(t, "account_provisioned_gib", "rg", "owned")
+	assert.False(t, ok)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	k8smetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	accountReuseReasonStorageAccount = "storage_account_specified"
+	accountReuseReasonSecrets        = "provisioner_secrets"
+	accountReuseReasonVolumeCache    = "volume_cache"
+	accountReuseReasonSearchCache    = "account_search_cache"
+	accountReuseReasonMatched        = "matching_account"
+
+	accountCreateReasonRequested     = "create_account"
+	accountCreateReasonNoMatch       = "no_matching_account"
+	accountCreateReasonLimitExceeded = "account_limit_exceeded"
+	accountCreateReasonNoCapacity    = "account_capacity_exceeded"
+
+	accountMismatchSkipMatching = "skip-matching tag"
+	accountMismatchSku          = "sku"
+	accountMismatchKind         = "kind"
+	accountMismatchLocation     = "location"
+	accountMismatchTags         = "tags"
+	accountMismatchRouting      = "routing preference"
+	accountMismatchOther        = "other properties"
+)
+
+var (
+	accountReuseTotal = k8smetrics.NewCounterVec(
+		&k8smetrics.CounterOpts{
+			Subsystem:      azureFileCSIDriverName,
+			Name:           "account_reuse_total",
+			Help:           "Number of existing storage accounts selected by CreateVolume, labeled by reason",
+			StabilityLevel: k8smetrics.ALPHA,
+		},
+		[]string{"reason"},
+	)
+	accountCreateTotal = k8smetrics.NewCounterVec(
+		&k8smetrics.CounterOpts{
+			Subsystem:      azureFileCSIDriverName,
+			Name:           "account_create_total",
+			Help:           "Number of storage accounts created by CreateVolume, labeled by reason",
+			StabilityLevel: k8smetrics.ALPHA,
+		},
+		[]string{"reason"},
+	)
+	registerAccountMetricsOnce sync.Once
+)
+
+// accountLimitExceededKey is the context key of the account which exceeded its file share limit,
+// CreateVolume retries with this key set after tagging the account with skip-matching tag
+type accountLimitExceededKey struct{}
+
+func registerAccountMetrics() {
+	registerAccountMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(accountReuseTotal, accountCreateTotal)
+	})
+}
+
+func recordAccountReuse(volName, accountName, reason string) {
+	klog.V(2).Infof("volume(%s) reuses storage account(%s), reason: %s", volName, accountName, reason)
+	accountReuseTotal.WithLabelValues(reason).Inc()
+}
+
+func recordAccountCreate(volName, accountName, reason string) {
+	klog.V(2).Infof("volume(%s) creates storage account(%s), reason: %s", volName, accountName, reason)
+	accountCreateTotal.WithLabelValues(reason).Inc()
+}
+
+// recordEnsuredAccount records whether accountName returned by EnsureStorageAccount is created or matched, and logs
+// why accounts listed by EnsureStorageAccount do not match if a new account is created. It returns true only if the
+// account is created by EnsureStorageAccount.
+func (d *Driver) recordEnsuredAccount(ctx context.Context, volName, accountName string, accountOptions *azure.AccountOptions, hook *accountCreateHook) bool {
+	if !hook.isCreated(accountName) {
+		klog.V(2).Infof("storage account(%s) matches sku(%s) kind(%s) location(%s) of volume(%s)", accountName, accountOptions.Type, accountOptions.Kind, accountOptions.Location, volName)
+		recordAccountReuse(volName, accountName, accountReuseReasonMatched)
+		return false
+	}
+	if accountOptions.CreateAccount {
+		recordAccountCreate(volName, accountName, accountCreateReasonRequested)
+		return true
+	}
+	reason := accountCreateReasonNoMatch
+	if fullAccount, ok := ctx.Value(accountLimitExceededKey{}).(string); ok {
+		klog.V(2).Infof("storage account(%s) exceeded file share limit", fullAccount)
+		reason = accountCreateReasonLimitExceeded
+	} else if fullAccount, ok := ctx.Value(accountCapacityExceededKey{}).(string); ok {
+		klog.V(2).Infof("storage account(%s) has no capacity left for the file share", fullAccount)
+		reason = accountCreateReasonNoCapacity
+	}
+	klog.V(2).Infof("no existing storage account in resource group(%s) matches volume(%s), mismatches: %v", accountOptions.ResourceGroup, volName, hook.getAccountMismatches())
+	recordAccountCreate(volName, accountName, reason)
+	return true
+}
+
+// getAccountMismatches returns the number of accounts per first mismatched property
+func getAccountMismatches(accounts []storage.Account, accountOptions *azure.AccountOptions) map[string]int {
+	mismatches := map[string]int{}
+	for _, acct := range accounts {
+		mismatches[getAccountMismatch(acct, accountOptions)]++
+	}
+	return mismatches
+}
+
+// getAccountMismatch returns the first property of account which does not match accountOptions,
+// only common properties are checked, see getStorageAccounts in cloud provider for the full matching
+func getAccountMismatch(account storage.Account, accountOptions *azure.AccountOptions) string {
+	if _, ok := account.Tags[azure.SkipMatchingTag]; ok {
+		return accountMismatchSkipMatching
+	}
+	if accountOptions.Type != "" && (account.Sku == nil || !strings.EqualFold(string(account.Sku.Name), accountOptions.Type)) {
+		return accountMismatchSku
+	}
+	if accountOptions.Kind != "" && !strings.EqualFold(string(account.Kind), accountOptions.Kind) {
+		return accountMismatchKind
+	}
+	if accountOptions.Location != "" && !strings.EqualFold(pointer.StringDeref(account.Location, ""), accountOptions.Location) {
+		return accountMismatchLocation
+	}
+	if accountOptions.MatchTags {
+		for k, v := range account.Tags {
+			if accountOptions.Tags[k] != pointer.StringDeref(v, "") {
+				return accountMismatchTags
+			}
+		}
+	}
+	return accountMismatchOther
+}
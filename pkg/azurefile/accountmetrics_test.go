@@ -0,0 +1,174 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/utils/pointer"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func getAccountCounterValue(t *testing.T, name, reason string) float64 {
+	return getCounterValue(t, name, "reason", reason)
+}
+
+func getCounterValue(t *testing.T, name, labelName, labelValue string) float64 {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != azureFileCSIDriverName+"_"+name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == labelName && label.GetValue() == labelValue {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestRecordEnsuredAccount(t *testing.T) {
+	accounts := []storage.Account{
+		{Name: pointer.String("existing"), Sku: &storage.Sku{Name: storage.SkuNameStandardLRS}, Location: pointer.String("eastus")},
+		{Name: pointer.String("full"), Sku: &storage.Sku{Name: storage.SkuNameStandardLRS}, Tags: map[string]*string{azure.SkipMatchingTag: pointer.String("")}},
+	}
+
+	tests := []struct {
+		desc          string
+		ctx           context.Context
+		accountName   string
+		created       string
+		createAccount bool
+		counter       string
+		reason        string
+	}{
+		{
+			desc:        "existing account is reused",
+			ctx:         context.Background(),
+			accountName: "existing",
+			counter:     "account_reuse_total",
+			reason:      accountReuseReasonMatched,
+		},
+		{
+			desc:        "no existing account matches",
+			ctx:         context.Background(),
+			accountName: "new",
+			created:     "new",
+			counter:     "account_create_total",
+			reason:      accountCreateReasonNoMatch,
+		},
+		{
+			desc:        "account limit exceeded",
+			ctx:         context.WithValue(context.Background(), accountLimitExceededKey{}, "full"),
+			accountName: "new",
+			created:     "new",
+			counter:     "account_create_total",
+			reason:      accountCreateReasonLimitExceeded,
+		},
+		{
+			desc:        "account capacity exceeded",
+			ctx:         context.WithValue(context.Background(), accountCapacityExceededKey{}, "full"),
+			accountName: "new",
+			created:     "new",
+			counter:     "account_create_total",
+			reason:      accountCreateReasonNoCapacity,
+		},
+		{
+			desc:          "account creation is requested",
+			ctx:           context.Background(),
+			accountName:   "new",
+			created:       "new",
+			createAccount: true,
+			counter:       "account_create_total",
+			reason:        accountCreateReasonRequested,
+		},
+	}
+
+	d := NewFakeDriver()
+	for _, test := range tests {
+		before := getAccountCounterValue(t, test.counter, test.reason)
+		accountOptions := &azure.AccountOptions{Type: string(storage.SkuNameStandardLRS), CreateAccount: test.createAccount}
+		hook := d.newAccountCreateHook(d.cloud, accountOptions)
+		hook.accounts = accounts
+		if test.created != "" {
+			hook.onCreated(test.created)
+		}
+		assert.Equal(t, test.created != "", d.recordEnsuredAccount(test.ctx, "vol", test.accountName, accountOptions, hook), test.desc)
+		assert.Equal(t, before+1, getAccountCounterValue(t, test.counter, test.reason), test.desc)
+	}
+}
+
+func TestGetAccountMismatch(t *testing.T) {
+	accountOptions := &azure.AccountOptions{
+		Type:      string(storage.SkuNamePremiumLRS),
+		Kind:      string(storage.KindFileStorage),
+		Location:  "eastus",
+		MatchTags: true,
+		Tags:      map[string]string{"team": "a"},
+	}
+	tests := []struct {
+		desc     string
+		account  storage.Account
+		expected string
+	}{
+		{
+			desc:     "account is tagged with skip-matching",
+			account:  storage.Account{Tags: map[string]*string{azure.SkipMatchingTag: nil}},
+			expected: accountMismatchSkipMatching,
+		},
+		{
+			desc:     "different sku",
+			account:  storage.Account{Sku: &storage.Sku{Name: storage.SkuNameStandardLRS}},
+			expected: accountMismatchSku,
+		},
+		{
+			desc:     "different kind",
+			account:  storage.Account{Sku: &storage.Sku{Name: storage.SkuNamePremiumLRS}, Kind: storage.KindStorageV2},
+			expected: accountMismatchKind,
+		},
+		{
+			desc:     "different location",
+			account:  storage.Account{Sku: &storage.Sku{Name: storage.SkuNamePremiumLRS}, Kind: storage.KindFileStorage, Location: pointer.String("westus")},
+			expected: accountMismatchLocation,
+		},
+		{
+			desc:     "different tags",
+			account:  storage.Account{Sku: &storage.Sku{Name: storage.SkuNamePremiumLRS}, Kind: storage.KindFileStorage, Location: pointer.String("EastUS"), Tags: map[string]*string{"team": pointer.String("b")}},
+			expected: accountMismatchTags,
+		},
+		{
+			desc:     "other properties",
+			account:  storage.Account{Sku: &storage.Sku{Name: storage.SkuNamePremiumLRS}, Kind: storage.KindFileStorage, Location: pointer.String("eastus"), Tags: map[string]*string{"team": pointer.String("a")}},
+			expected: accountMismatchOther,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getAccountMismatch(test.account, accountOptions), test.desc)
+	}
+
+	accounts := []storage.Account{tests[0].account, tests[1].account, {Sku: &storage.Sku{Name: storage.SkuNameStandardZRS}}}
+	assert.Equal(t, map[string]int{accountMismatchSkipMatching: 1, accountMismatchSku: 2}, getAccountMismatches(accounts, accountOptions))
+}
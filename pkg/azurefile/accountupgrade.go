@@ -0,0 +1,93 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// returned by ARM if another operation, e.g. an upgrade started by another request, is running on the account
+	storageAccountOperationInProgress = "StorageAccountOperationInProgress"
+)
+
+// prepareV1Accounts handles StorageV1 accounts listed before EnsureStorageAccount, which never reuses them since
+// their kind is not StorageV2. If upgradeV1Accounts is set, V1 accounts matching sku, location and tags are upgraded
+// to StorageV2 in place so that EnsureStorageAccount could reuse them, otherwise they are skipped with a logged reason.
+// Upgraded accounts are updated in accounts.
+func (d *Driver) prepareV1Accounts(ctx context.Context, cloud *azure.Cloud, accountOptions *azure.AccountOptions, accounts []storage.Account) {
+	if !strings.EqualFold(accountOptions.Kind, string(storage.KindStorageV2)) {
+		return
+	}
+	v1Options := *accountOptions
+	v1Options.Kind = string(storage.KindStorage)
+	for i := range accounts {
+		accountName := pointer.StringDeref(accounts[i].Name, "")
+		if accounts[i].Kind != storage.KindStorage || getAccountMismatch(accounts[i], &v1Options) != accountMismatchOther {
+			continue
+		}
+		if !d.upgradeV1Accounts {
+			klog.V(2).Infof("skip StorageV1 account(%s) in resource group(%s) in account selection, set --upgrade-v1-accounts to upgrade it to StorageV2 and reuse it", accountName, accountOptions.ResourceGroup)
+			continue
+		}
+		upgraded, err := upgradeV1Account(ctx, cloud, accountOptions, accountName)
+		if err != nil {
+			klog.Warningf("skip StorageV1 account(%s) in resource group(%s) in account selection since upgrading it to StorageV2 failed: %v", accountName, accountOptions.ResourceGroup, err)
+			continue
+		}
+		if !upgraded {
+			klog.V(2).Infof("skip StorageV1 account(%s) in resource group(%s) in account selection since another operation is in progress on it", accountName, accountOptions.ResourceGroup)
+			continue
+		}
+		accounts[i].Kind = storage.KindStorageV2
+	}
+}
+
+// upgradeV1Account upgrades the StorageV1 account to StorageV2 with the access tier of accountOptions (Hot by default),
+// it returns false if the account is not upgraded yet since another operation is in progress on it
+func upgradeV1Account(ctx context.Context, cloud *azure.Cloud, accountOptions *azure.AccountOptions, accountName string) (bool, error) {
+	accessTier := storage.AccessTierHot
+	if accountOptions.AccessTier != "" {
+		accessTier = storage.AccessTier(accountOptions.AccessTier)
+	}
+	klog.V(2).Infof("upgrade StorageV1 account(%s) in resource group(%s) to StorageV2 with access tier(%s)", accountName, accountOptions.ResourceGroup, accessTier)
+	updateParams := storage.AccountUpdateParameters{
+		Kind:                              storage.KindStorageV2,
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{AccessTier: accessTier},
+	}
+	rerr := cloud.StorageAccountClient.Update(ctx, accountOptions.SubscriptionID, accountOptions.ResourceGroup, accountName, updateParams)
+	if rerr == nil {
+		return true, nil
+	}
+	if rerr.HTTPStatusCode != http.StatusConflict && !strings.Contains(rerr.Error().Error(), storageAccountOperationInProgress) {
+		return false, rerr.Error()
+	}
+	// the conflicting operation may be an upgrade which has completed in the meantime
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, accountOptions.SubscriptionID, accountOptions.ResourceGroup, accountName)
+	if rerr != nil {
+		return false, rerr.Error()
+	}
+	return account.Kind == storage.KindStorageV2, nil
+}
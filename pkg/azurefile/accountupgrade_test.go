@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestPrepareV1Accounts(t *testing.T) {
+	newAccounts := func() []storage.Account {
+		return []storage.Account{
+			{Name: pointer.String("v1"), Kind: storage.KindStorage, Location: pointer.String("eastus"), Sku: &storage.Sku{Name: storage.SkuNameStandardLRS}},
+			{Name: pointer.String("v1-grs"), Kind: storage.KindStorage, Location: pointer.String("eastus"), Sku: &storage.Sku{Name: storage.SkuNameStandardGRS}},
+			{Name: pointer.String("v2"), Kind: storage.KindStorageV2, Location: pointer.String("eastus"), Sku: &storage.Sku{Name: storage.SkuNameStandardLRS}},
+		}
+	}
+	accountOptions := &azure.AccountOptions{
+		Type:           string(storage.SkuNameStandardLRS),
+		Kind:           string(storage.KindStorageV2),
+		Location:       "eastus",
+		SubscriptionID: "subsID",
+		ResourceGroup:  "rg",
+	}
+	expectedParams := storage.AccountUpdateParameters{
+		Kind:                              storage.KindStorageV2,
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{AccessTier: storage.AccessTierHot},
+	}
+
+	tests := []struct {
+		desc              string
+		upgradeV1Accounts bool
+		accountOptions    *azure.AccountOptions
+		setup             func(m *mockstorageaccountclient.MockInterface)
+		expectedKind      storage.Kind
+	}{
+		{
+			desc:         "V1 account is skipped if upgrade is disabled",
+			setup:        func(m *mockstorageaccountclient.MockInterface) {},
+			expectedKind: storage.KindStorage,
+		},
+		{
+			desc:              "V1 account is not upgraded for FileStorage",
+			upgradeV1Accounts: true,
+			accountOptions:    &azure.AccountOptions{Type: string(storage.SkuNamePremiumLRS), Kind: string(storage.KindFileStorage), Location: "eastus", SubscriptionID: "subsID", ResourceGroup: "rg"},
+			setup:             func(m *mockstorageaccountclient.MockInterface) {},
+			expectedKind:      storage.KindStorage,
+		},
+		{
+			desc:              "matched V1 account is upgraded",
+			upgradeV1Accounts: true,
+			setup: func(m *mockstorageaccountclient.MockInterface) {
+				m.EXPECT().Update(gomock.Any(), "subsID", "rg", "v1", expectedParams).Return(nil)
+			},
+			expectedKind: storage.KindStorageV2,
+		},
+		{
+			desc:              "V1 account is skipped if upgrade fails",
+			upgradeV1Accounts: true,
+			setup: func(m *mockstorageaccountclient.MockInterface) {
+				m.EXPECT().Update(gomock.Any(), "subsID", "rg", "v1", expectedParams).Return(&retry.Error{HTTPStatusCode: http.StatusForbidden, RawError: fmt.Errorf("AuthorizationFailed")})
+			},
+			expectedKind: storage.KindStorage,
+		},
+		{
+			desc:              "V1 account is skipped if upgrade is in progress",
+			upgradeV1Accounts: true,
+			setup: func(m *mockstorageaccountclient.MockInterface) {
+				m.EXPECT().Update(gomock.Any(), "subsID", "rg", "v1", expectedParams).Return(&retry.Error{HTTPStatusCode: http.StatusConflict, RawError: fmt.Errorf(storageAccountOperationInProgress)})
+				m.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "v1").Return(storage.Account{Kind: storage.KindStorage}, nil)
+			},
+			expectedKind: storage.KindStorage,
+		},
+		{
+			desc:              "V1 account upgraded by another request is reused",
+			upgradeV1Accounts: true,
+			setup: func(m *mockstorageaccountclient.MockInterface) {
+				m.EXPECT().Update(gomock.Any(), "subsID", "rg", "v1", expectedParams).Return(&retry.Error{HTTPStatusCode: http.StatusConflict, RawError: fmt.Errorf(storageAccountOperationInProgress)})
+				m.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "v1").Return(storage.Account{Kind: storage.KindStorageV2}, nil)
+			},
+			expectedKind: storage.KindStorageV2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			d := NewFakeDriver()
+			d.upgradeV1Accounts = test.upgradeV1Accounts
+			mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+			d.cloud.StorageAccountClient = mockStorageAccountsClient
+			test.setup(mockStorageAccountsClient)
+
+			options := accountOptions
+			if test.accountOptions != nil {
+				options = test.accountOptions
+			}
+			accounts := newAccounts()
+			d.prepareV1Accounts(context.Background(), d.cloud, options, accounts)
+			assert.Equal(t, test.expectedKind, accounts[0].Kind)
+			// V1 account not matching sku is never upgraded
+			assert.Equal(t, storage.KindStorage, accounts[1].Kind)
+			assert.Equal(t, storage.KindStorageV2, accounts[2].Kind)
+		})
+	}
+}
@@ -20,17 +20,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-08-01/network"
 
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
+	"sigs.k8s.io/cloud-provider-azure/pkg/consts"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 )
 
@@ -44,8 +48,85 @@ var (
 	storageService = "Microsoft.Storage"
 )
 
+// armRetryOptions overrides the retry policy of ARM requests in cloud config, zero value means not overridden
+type armRetryOptions struct {
+	maxRetries    int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+}
+
+// validateARMRetryOptions checks the ranges of ARM retry options
+func validateARMRetryOptions(maxRetries int, retryDelay, maxRetryDelay time.Duration) error {
+	if maxRetries < 0 || maxRetries > maxARMRetries {
+		return fmt.Errorf("invalid arm max retries(%d), it should be between 0 and %d", maxRetries, maxARMRetries)
+	}
+	if retryDelay != 0 && (retryDelay < time.Second || retryDelay > maxARMRetryDelay) {
+		return fmt.Errorf("invalid arm retry delay(%v), it should be between 1s and %v", retryDelay, maxARMRetryDelay)
+	}
+	if maxRetryDelay < 0 || maxRetryDelay > maxARMMaxRetryDelay {
+		return fmt.Errorf("invalid arm max retry delay(%v), it should be between 0 and %v", maxRetryDelay, maxARMMaxRetryDelay)
+	}
+	if maxRetryDelay > 0 && retryDelay > maxRetryDelay {
+		return fmt.Errorf("arm retry delay(%v) should not be larger than arm max retry delay(%v)", retryDelay, maxRetryDelay)
+	}
+	return nil
+}
+
+// applyToConfig enables cloud provider backoff in cloud config with maxRetries and retryDelay, and lowers
+// the backoff exponent so that no retry delay exceeds maxRetryDelay, cloud provider backoff is used by all ARM clients
+func (o armRetryOptions) applyToConfig(config *azure.Config) {
+	if o.maxRetries > 0 || o.retryDelay > 0 {
+		config.CloudProviderBackoff = true
+	}
+	if !config.CloudProviderBackoff {
+		return
+	}
+	if o.maxRetries > 0 {
+		config.CloudProviderBackoffRetries = o.maxRetries
+	}
+	if o.retryDelay > 0 {
+		// cloudProviderBackoffDuration is in seconds
+		config.CloudProviderBackoffDuration = int(o.retryDelay.Round(time.Second) / time.Second)
+	}
+	if o.maxRetryDelay <= 0 {
+		return
+	}
+
+	// same defaults as cloud provider
+	retries, duration, exponent := config.CloudProviderBackoffRetries, config.CloudProviderBackoffDuration, config.CloudProviderBackoffExponent
+	if retries == 0 {
+		retries = consts.BackoffRetriesDefault
+	}
+	if duration == 0 {
+		duration = consts.BackoffDurationDefault
+	}
+	if exponent == 0 {
+		exponent = consts.BackoffExponentDefault
+	}
+	maxDuration := o.maxRetryDelay.Seconds()
+	if float64(duration) >= maxDuration {
+		config.CloudProviderBackoffDuration = int(maxDuration)
+		config.CloudProviderBackoffExponent = 1
+		return
+	}
+	// the last delay is duration * exponent^(retries-2)
+	if retries > 2 && float64(duration)*math.Pow(exponent, float64(retries-2)) > maxDuration {
+		config.CloudProviderBackoffExponent = math.Pow(maxDuration/float64(duration), 1/float64(retries-2))
+	}
+}
+
+// getMaxRetryDuration returns the total delay of backoff if all retries fail
+func getMaxRetryDuration(backoff wait.Backoff) time.Duration {
+	backoff.Jitter = 0
+	var total time.Duration
+	for backoff.Steps > 1 {
+		total += backoff.Step()
+	}
+	return total
+}
+
 // getCloudProvider get Azure Cloud Provider
-func getCloudProvider(kubeconfig, nodeID, secretName, secretNamespace, userAgent string, allowEmptyCloudConfig bool, kubeAPIQPS float64, kubeAPIBurst int) (*azure.Cloud, error) {
+func getCloudProvider(kubeconfig, nodeID, secretName, secretNamespace, userAgent string, allowEmptyCloudConfig bool, kubeAPIQPS float64, kubeAPIBurst int, retryOptions armRetryOptions) (*azure.Cloud, error) {
 	var (
 		config     *azure.Config
 		kubeClient *clientset.Clientset
@@ -122,9 +203,15 @@ func getCloudProvider(kubeconfig, nodeID, secretName, secretNamespace, userAgent
 		}
 	} else {
 		config.UserAgent = userAgent
+		retryOptions.applyToConfig(config)
 		if err = az.InitializeCloudFromConfig(context.TODO(), config, fromSecret, false); err != nil {
 			klog.Warningf("InitializeCloudFromConfig failed with error: %v", err)
 		}
+		setAccountCreateHookClient(az)
+		if az.CloudProviderBackoff {
+			klog.V(2).Infof("ARM request retries(%d), retry delay(%ds), exponent(%f), total retry delay could be up to %v",
+				az.CloudProviderBackoffRetries, az.CloudProviderBackoffDuration, az.CloudProviderBackoffExponent, getMaxRetryDuration(az.ResourceRequestBackoff))
+		}
 	}
 
 	// reassign kubeClient
@@ -148,6 +235,75 @@ func getCloudProvider(kubeconfig, nodeID, secretName, secretNamespace, userAgent
 	return az, nil
 }
 
+// parseAdditionalCloudConfigs parses comma separated cloud configs in format cloudConfigName=path, e.g.
+// input: "chinacloud=/etc/kubernetes/azurechina.json,westeurope=/etc/kubernetes/azure-westeurope.json"
+func parseAdditionalCloudConfigs(cloudConfigs string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, v := range strings.Split(cloudConfigs, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid cloud config(%s), expected format: cloudConfigName=path", v)
+		}
+		name, path := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if strings.Contains(name, separator) {
+			return nil, fmt.Errorf("invalid cloud config name(%s), %s is not allowed", name, separator)
+		}
+		if _, ok := result[name]; ok {
+			return nil, fmt.Errorf("duplicate cloud config name(%s)", name)
+		}
+		result[name] = path
+	}
+	return result, nil
+}
+
+// getAdditionalCloudProviders initializes a cloud provider from each cloud config file,
+// all cloud providers share the kubeClient of the default cloud provider
+func getAdditionalCloudProviders(cloudConfigs map[string]string, nodeID, userAgent string, kubeClient clientset.Interface, retryOptions armRetryOptions) (map[string]*azure.Cloud, error) {
+	clouds := map[string]*azure.Cloud{}
+	for name, path := range cloudConfigs {
+		az, err := getCloudProviderFromFile(path, nodeID, userAgent, retryOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize cloud config(%s): %v", name, err)
+		}
+		az.KubeClient = kubeClient
+		klog.V(2).Infof("cloud config(%s) cloud: %s, location: %s, rg: %s, subscription: %s", name, az.Cloud, az.Location, az.ResourceGroup, az.SubscriptionID)
+		clouds[name] = az
+	}
+	return clouds, nil
+}
+
+// getCloudProviderFromFile initializes a cloud provider from cloud config file
+func getCloudProviderFromFile(path, nodeID, userAgent string, retryOptions armRetryOptions) (*azure.Cloud, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config, err := azure.ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse config file(%s) failed with error: %v", path, err)
+	}
+	if config == nil {
+		return nil, fmt.Errorf("config file(%s) is empty", path)
+	}
+	config.UserAgent = userAgent
+	if nodeID == "" {
+		// same as the default cloud provider, IMDS is not used by controller
+		config.UseInstanceMetadata = false
+	}
+	retryOptions.applyToConfig(config)
+	az := &azure.Cloud{}
+	if err := az.InitializeCloudFromConfig(context.TODO(), config, false, false); err != nil {
+		return nil, err
+	}
+	setAccountCreateHookClient(az)
+	return az, nil
+}
+
 func getKubeConfig(kubeconfig string) (config *rest.Config, err error) {
 	if kubeconfig != "" {
 		if config, err = clientcmd.BuildConfigFromFlags("", kubeconfig); err != nil {
@@ -23,6 +23,31 @@ import (
 	mount "k8s.io/mount-utils"
 )
 
+// checkMountHelper is a no-op since no mount helper is used on this platform
+func checkMountHelper(m *mount.SafeFormatAndMount, fsType string) error {
+	return nil
+}
+
+// checkSMBEncryptionSupport is a no-op on this platform
+func checkSMBEncryptionSupport(m *mount.SafeFormatAndMount) error {
+	return nil
+}
+
+// checkSMBCompressionSupport is a no-op on this platform
+func checkSMBCompressionSupport(m *mount.SafeFormatAndMount) error {
+	return nil
+}
+
+// checkSMBNoLeaseSupport is a no-op on this platform
+func checkSMBNoLeaseSupport(m *mount.SafeFormatAndMount) error {
+	return nil
+}
+
+// checkSMBVersionSupport is a no-op on this platform
+func checkSMBVersionSupport(m *mount.SafeFormatAndMount, version string) error {
+	return nil
+}
+
 func SMBMount(m *mount.SafeFormatAndMount, source, target, fsType string, options, sensitiveMountOptions []string) error {
 	return nil
 }
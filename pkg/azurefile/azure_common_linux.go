@@ -21,7 +21,9 @@ package azurefile
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -29,6 +31,128 @@ import (
 	mount "k8s.io/mount-utils"
 )
 
+// directories searched for mount helpers, same as mount(8) besides PATH
+var mountHelperDirs = []string{"/sbin", "/usr/sbin", "/bin", "/usr/bin"}
+
+// mount helper packages suggested in error message when mount helper is not found
+var mountHelperPackages = map[string]string{
+	cifs: "cifs-utils",
+	nfs:  "nfs-common or nfs-utils",
+}
+
+// checkMountHelper checks mount.<fsType> helper exists, which is required by mounting cifs and nfs file systems,
+// the check is skipped if the mounter is not the system mounter, e.g. in unit tests
+func checkMountHelper(m *mount.SafeFormatAndMount, fsType string) error {
+	if _, ok := m.Interface.(*mount.Mounter); !ok {
+		return nil
+	}
+	helper := "mount." + fsType
+	for _, dir := range mountHelperDirs {
+		if info, err := os.Stat(filepath.Join(dir, helper)); err == nil && !info.IsDir() {
+			return nil
+		}
+	}
+	if _, err := exec.LookPath(helper); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s not found; install %s", helper, mountHelperPackages[fsType])
+}
+
+// kernel release file read by checkSMBEncryptionSupport, checkSMBCompressionSupport and checkSMBNoLeaseSupport
+var kernelReleasePath = "/proc/sys/kernel/osrelease"
+
+// checkSMBEncryptionSupport checks the cifs client of the node kernel supports seal mount option(SMB3 encryption),
+// the check is skipped if the mounter is not the system mounter or the kernel version is unknown
+func checkSMBEncryptionSupport(m *mount.SafeFormatAndMount) error {
+	release, ok := getNodeKernelRelease(m, "SMB encryption")
+	if !ok {
+		return nil
+	}
+	return checkKernelSMBEncryptionSupport(release)
+}
+
+// checkKernelSMBEncryptionSupport returns error if kernel release is older than 4.11, which adds SMB3 encryption to cifs client
+func checkKernelSMBEncryptionSupport(release string) error {
+	return checkKernelRelease(release, "SMB encryption(seal)", 4, 11)
+}
+
+// checkSMBCompressionSupport checks the cifs client of the node kernel supports compress mount option(SMB3 compression),
+// the check is skipped if the mounter is not the system mounter or the kernel version is unknown
+func checkSMBCompressionSupport(m *mount.SafeFormatAndMount) error {
+	release, ok := getNodeKernelRelease(m, "SMB compression")
+	if !ok {
+		return nil
+	}
+	return checkKernelSMBCompressionSupport(release)
+}
+
+// checkKernelSMBCompressionSupport returns error if kernel release is older than 6.8, which adds compress mount option
+// to cifs client, older cifs clients fail the mount with an unknown mount option
+func checkKernelSMBCompressionSupport(release string) error {
+	return checkKernelRelease(release, "SMB compression(compress)", 6, 8)
+}
+
+// checkSMBNoLeaseSupport checks the cifs client of the node kernel supports nolease mount option,
+// the check is skipped if the mounter is not the system mounter or the kernel version is unknown
+func checkSMBNoLeaseSupport(m *mount.SafeFormatAndMount) error {
+	release, ok := getNodeKernelRelease(m, "disabling SMB leases")
+	if !ok {
+		return nil
+	}
+	return checkKernelSMBNoLeaseSupport(release)
+}
+
+// checkKernelSMBNoLeaseSupport returns error if kernel release is older than 5.5, which adds nolease mount option
+// to cifs client
+func checkKernelSMBNoLeaseSupport(release string) error {
+	return checkKernelRelease(release, "disabling SMB leases(nolease)", 5, 5)
+}
+
+// checkSMBVersionSupport checks the cifs client of the node kernel supports the SMB version,
+// the check is skipped if the mounter is not the system mounter or the kernel version is unknown
+func checkSMBVersionSupport(m *mount.SafeFormatAndMount, version string) error {
+	release, ok := getNodeKernelRelease(m, "SMB "+version)
+	if !ok {
+		return nil
+	}
+	return checkKernelSMBVersionSupport(release, version)
+}
+
+// checkKernelSMBVersionSupport returns error if kernel release is older than 4.17 for SMB 3.1.1, which is
+// completed by pre-authentication integrity in cifs client, SMB 3.0 and 2.1 are supported by any kernel in use
+func checkKernelSMBVersionSupport(release, version string) error {
+	if version == "3.1.1" {
+		return checkKernelRelease(release, "SMB 3.1.1", 4, 17)
+	}
+	return nil
+}
+
+// getNodeKernelRelease returns kernel release of the node, false is returned if the check of feature should be skipped
+func getNodeKernelRelease(m *mount.SafeFormatAndMount, feature string) (string, bool) {
+	if _, ok := m.Interface.(*mount.Mounter); !ok {
+		return "", false
+	}
+	release, err := os.ReadFile(kernelReleasePath)
+	if err != nil {
+		klog.Warningf("skip checking %s support since reading %s failed: %v", feature, kernelReleasePath, err)
+		return "", false
+	}
+	return strings.TrimSpace(string(release)), true
+}
+
+// checkKernelRelease returns error if kernel release is older than major.minor, the check is skipped if it could not be parsed
+func checkKernelRelease(release, feature string, major, minor int) error {
+	var releaseMajor, releaseMinor int
+	if _, err := fmt.Sscanf(release, "%d.%d", &releaseMajor, &releaseMinor); err != nil {
+		klog.Warningf("skip checking %s support since kernel release(%s) could not be parsed: %v", feature, release, err)
+		return nil
+	}
+	if releaseMajor < major || (releaseMajor == major && releaseMinor < minor) {
+		return fmt.Errorf("kernel %s does not support %s, kernel %d.%d or later is required", release, feature, major, minor)
+	}
+	return nil
+}
+
 func SMBMount(m *mount.SafeFormatAndMount, source, target, fsType string, options, sensitiveMountOptions []string) error {
 	return m.MountSensitive(source, target, fsType, options, sensitiveMountOptions)
 }
@@ -0,0 +1,161 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+)
+
+func TestEnsureMountHelper(t *testing.T) {
+	helperDir := t.TempDir()
+	defer func(dirs []string) { mountHelperDirs = dirs }(mountHelperDirs)
+	mountHelperDirs = []string{helperDir}
+	t.Setenv("PATH", "")
+
+	d := NewFakeDriver()
+	d.mounter = &mount.SafeFormatAndMount{Interface: mount.New("")}
+
+	err := d.ensureMountHelper(cifs)
+	assert.Equal(t, status.Error(codes.FailedPrecondition, "mount.cifs not found; install cifs-utils"), err)
+	err = d.ensureMountHelper(nfs)
+	assert.Equal(t, status.Error(codes.FailedPrecondition, "mount.nfs not found; install nfs-common or nfs-utils"), err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(helperDir, "mount.cifs"), nil, 0755))
+	assert.NoError(t, d.ensureMountHelper(cifs))
+
+	// found mount helper is cached
+	assert.NoError(t, os.Remove(filepath.Join(helperDir, "mount.cifs")))
+	assert.NoError(t, d.ensureMountHelper(cifs))
+
+	// check is skipped with fake mounter
+	d.mounter, _ = NewFakeMounter()
+	assert.NoError(t, d.ensureMountHelper(nfs))
+}
+
+func TestCheckSMBEncryptionSupport(t *testing.T) {
+	tests := []struct {
+		release     string
+		expectedErr bool
+	}{
+		{release: "5.15.0-1019-azure"},
+		{release: "4.11.0"},
+		{release: "4.4.0-210-generic", expectedErr: true},
+		{release: "3.10.0-1160.el7.x86_64", expectedErr: true},
+		{release: "unknown"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expectedErr, checkKernelSMBEncryptionSupport(test.release) != nil, test.release)
+	}
+
+	defer func(path string) { kernelReleasePath = path }(kernelReleasePath)
+	kernelReleasePath = filepath.Join(t.TempDir(), "osrelease")
+	assert.NoError(t, os.WriteFile(kernelReleasePath, []byte("4.4.0-210-generic\n"), 0644))
+	m := &mount.SafeFormatAndMount{Interface: mount.New("")}
+	assert.EqualError(t, checkSMBEncryptionSupport(m), "kernel 4.4.0-210-generic does not support SMB encryption(seal), kernel 4.11 or later is required")
+
+	// check is skipped with fake mounter
+	fakeMounter, _ := NewFakeMounter()
+	assert.NoError(t, checkSMBEncryptionSupport(fakeMounter))
+}
+
+func TestCheckSMBCompressionSupport(t *testing.T) {
+	tests := []struct {
+		release     string
+		expectedErr bool
+	}{
+		{release: "6.8.0-1008-azure"},
+		{release: "6.11.0"},
+		{release: "6.5.0-1025-azure", expectedErr: true},
+		{release: "5.15.0-1019-azure", expectedErr: true},
+		{release: "unknown"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expectedErr, checkKernelSMBCompressionSupport(test.release) != nil, test.release)
+	}
+
+	defer func(path string) { kernelReleasePath = path }(kernelReleasePath)
+	kernelReleasePath = filepath.Join(t.TempDir(), "osrelease")
+	assert.NoError(t, os.WriteFile(kernelReleasePath, []byte("5.15.0-1019-azure\n"), 0644))
+	m := &mount.SafeFormatAndMount{Interface: mount.New("")}
+	assert.EqualError(t, checkSMBCompressionSupport(m), "kernel 5.15.0-1019-azure does not support SMB compression(compress), kernel 6.8 or later is required")
+
+	// check is skipped with fake mounter
+	fakeMounter, _ := NewFakeMounter()
+	assert.NoError(t, checkSMBCompressionSupport(fakeMounter))
+}
+
+func TestCheckSMBNoLeaseSupport(t *testing.T) {
+	tests := []struct {
+		release     string
+		expectedErr bool
+	}{
+		{release: "5.15.0-1019-azure"},
+		{release: "5.5.0"},
+		{release: "5.4.0-1109-azure", expectedErr: true},
+		{release: "4.15.0-1113-azure", expectedErr: true},
+		{release: "unknown"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expectedErr, checkKernelSMBNoLeaseSupport(test.release) != nil, test.release)
+	}
+
+	defer func(path string) { kernelReleasePath = path }(kernelReleasePath)
+	kernelReleasePath = filepath.Join(t.TempDir(), "osrelease")
+	assert.NoError(t, os.WriteFile(kernelReleasePath, []byte("5.4.0-1109-azure\n"), 0644))
+	m := &mount.SafeFormatAndMount{Interface: mount.New("")}
+	assert.EqualError(t, checkSMBNoLeaseSupport(m), "kernel 5.4.0-1109-azure does not support disabling SMB leases(nolease), kernel 5.5 or later is required")
+
+	// check is skipped with fake mounter
+	fakeMounter, _ := NewFakeMounter()
+	assert.NoError(t, checkSMBNoLeaseSupport(fakeMounter))
+}
+
+func TestCheckSMBVersionSupport(t *testing.T) {
+	tests := []struct {
+		release     string
+		version     string
+		expectedErr bool
+	}{
+		{release: "5.15.0-1019-azure", version: "3.1.1"},
+		{release: "4.17.0", version: "3.1.1"},
+		{release: "4.15.0-1113-azure", version: "3.1.1", expectedErr: true},
+		{release: "4.15.0-1113-azure", version: "3.0"},
+		{release: "unknown", version: "3.1.1"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expectedErr, checkKernelSMBVersionSupport(test.release, test.version) != nil, "%s %s", test.release, test.version)
+	}
+
+	defer func(path string) { kernelReleasePath = path }(kernelReleasePath)
+	kernelReleasePath = filepath.Join(t.TempDir(), "osrelease")
+	assert.NoError(t, os.WriteFile(kernelReleasePath, []byte("4.15.0-1113-azure\n"), 0644))
+	d := NewFakeDriver()
+	d.mounter = &mount.SafeFormatAndMount{Interface: mount.New("")}
+	assert.EqualError(t, checkSMBVersionSupport(d.mounter, "3.1.1"), "kernel 4.15.0-1113-azure does not support SMB 3.1.1, kernel 4.17 or later is required")
+	// SMB 3.0 is selected on a kernel without SMB 3.1.1 support
+	assert.Equal(t, "3.0", d.selectSMBVersion("Premium_LRS", false))
+}
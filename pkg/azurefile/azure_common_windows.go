@@ -27,6 +27,34 @@ import (
 	"sigs.k8s.io/azurefile-csi-driver/pkg/mounter"
 )
 
+// checkMountHelper is a no-op since no mount helper is used on this platform
+func checkMountHelper(m *mount.SafeFormatAndMount, fsType string) error {
+	return nil
+}
+
+// checkSMBEncryptionSupport returns error since SMB encryption could not be enforced by mount options on Windows,
+// it's negotiated by the server, e.g. when the storage account only allows encrypted SMB channel
+func checkSMBEncryptionSupport(m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("SMB encryption could not be enforced by mount options on Windows node, restrict SMB channel encryption on the storage account instead")
+}
+
+// checkSMBCompressionSupport returns error since SMB compression could not be requested by mount options on Windows,
+// it's configured by the SMB client settings of the node
+func checkSMBCompressionSupport(m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("SMB compression could not be requested by mount options on Windows node, configure SMB client compression of the node instead")
+}
+
+// checkSMBNoLeaseSupport returns error since SMB leases could not be disabled by mount options on Windows,
+// they're configured by the SMB client settings of the node
+func checkSMBNoLeaseSupport(m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("SMB leases could not be disabled by mount options on Windows node, configure SMB client leasing of the node instead")
+}
+
+// checkSMBVersionSupport is a no-op since SMB version is negotiated by the SMB client of the node on Windows
+func checkSMBVersionSupport(m *mount.SafeFormatAndMount, version string) error {
+	return nil
+}
+
 func SMBMount(m *mount.SafeFormatAndMount, source, target, fsType string, mountOptions, sensitiveMountOptions []string) error {
 	if proxy, ok := m.Interface.(mounter.CSIProxyMounter); ok {
 		return proxy.SMBMount(source, target, fsType, mountOptions, sensitiveMountOptions)
@@ -25,10 +25,12 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-08-01/network"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"sigs.k8s.io/azurefile-csi-driver/test/utils/testutil"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/subnetclient/mocksubnetclient"
@@ -173,7 +175,7 @@ users:
 			}
 			os.Setenv(DefaultAzureCredentialFileEnv, fakeCredFile)
 		}
-		cloud, err := getCloudProvider(test.kubeconfig, "", "", "", test.userAgent, test.allowEmptyCloudConfig, 5, 10)
+		cloud, err := getCloudProvider(test.kubeconfig, "", "", "", test.userAgent, test.allowEmptyCloudConfig, 5, 10, armRetryOptions{})
 		if !testutil.AssertError(err, &test.expectedErr) && !strings.Contains(err.Error(), test.expectedErr.DefaultError.Error()) {
 			t.Errorf("desc: %s,\n input: %q, getCloudProvider err: %v, expectedErr: %v", test.desc, test.kubeconfig, err, test.expectedErr)
 		}
@@ -185,6 +187,167 @@ users:
 	}
 }
 
+func TestGetCloudProviderWithARMRetryOptions(t *testing.T) {
+	fakeCredFile := testutil.GetWorkDirPath("fake-cred-file-retry.json", t)
+	if err := ioutil.WriteFile(fakeCredFile, []byte(`{"useInstanceMetadata": true}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fakeCredFile)
+
+	originalCredFile, ok := os.LookupEnv(DefaultAzureCredentialFileEnv)
+	if ok {
+		defer os.Setenv(DefaultAzureCredentialFileEnv, originalCredFile)
+	} else {
+		defer os.Unsetenv(DefaultAzureCredentialFileEnv)
+	}
+	os.Setenv(DefaultAzureCredentialFileEnv, fakeCredFile)
+
+	retryOptions := armRetryOptions{maxRetries: 10, retryDelay: 2 * time.Second, maxRetryDelay: 30 * time.Second}
+	cloud, err := getCloudProvider("", "", "", "", "", true, 5, 10, retryOptions)
+	assert.NoError(t, err)
+	assert.True(t, cloud.CloudProviderBackoff)
+	assert.Equal(t, 10, cloud.CloudProviderBackoffRetries)
+	assert.Equal(t, 2, cloud.CloudProviderBackoffDuration)
+	assert.Equal(t, 10, cloud.RequestBackoff().Steps)
+	assert.Equal(t, 2*time.Second, cloud.RequestBackoff().Duration)
+	// the last retry delay is capped by max retry delay
+	backoff := cloud.RequestBackoff()
+	backoff.Jitter = 0
+	for backoff.Steps > 2 {
+		backoff.Step()
+	}
+	assert.LessOrEqual(t, backoff.Step(), 30*time.Second+time.Millisecond)
+}
+
+func TestApplyARMRetryOptionsToConfig(t *testing.T) {
+	tests := []struct {
+		desc             string
+		config           azureprovider.Config
+		retryOptions     armRetryOptions
+		expectedBackoff  bool
+		expectedRetries  int
+		expectedDuration int
+		expectedExponent float64
+	}{
+		{
+			desc: "no retry options",
+		},
+		{
+			desc:         "max retry delay is ignored when backoff is disabled",
+			retryOptions: armRetryOptions{maxRetryDelay: time.Minute},
+		},
+		{
+			desc:             "retries and delay are set",
+			retryOptions:     armRetryOptions{maxRetries: 3, retryDelay: 2 * time.Second},
+			expectedBackoff:  true,
+			expectedRetries:  3,
+			expectedDuration: 2,
+		},
+		{
+			desc:             "exponent is lowered by max retry delay",
+			retryOptions:     armRetryOptions{maxRetries: 4, retryDelay: time.Second, maxRetryDelay: 4 * time.Second},
+			config:           azureprovider.Config{CloudProviderBackoffExponent: 4},
+			expectedBackoff:  true,
+			expectedRetries:  4,
+			expectedDuration: 1,
+			expectedExponent: 2,
+		},
+		{
+			desc:             "delay in cloud config is larger than max retry delay",
+			retryOptions:     armRetryOptions{maxRetryDelay: 3 * time.Second},
+			config:           azureprovider.Config{CloudProviderBackoff: true},
+			expectedBackoff:  true,
+			expectedDuration: 3,
+			expectedExponent: 1,
+		},
+	}
+	for _, test := range tests {
+		config := test.config
+		test.retryOptions.applyToConfig(&config)
+		assert.Equal(t, test.expectedBackoff, config.CloudProviderBackoff, test.desc)
+		assert.Equal(t, test.expectedRetries, config.CloudProviderBackoffRetries, test.desc)
+		assert.Equal(t, test.expectedDuration, config.CloudProviderBackoffDuration, test.desc)
+		assert.InDelta(t, test.expectedExponent, config.CloudProviderBackoffExponent, 0.0001, test.desc)
+	}
+}
+
+func TestValidateARMRetryOptions(t *testing.T) {
+	tests := []struct {
+		maxRetries    int
+		retryDelay    time.Duration
+		maxRetryDelay time.Duration
+		expectedErr   error
+	}{
+		{},
+		{maxRetries: 10, retryDelay: 2 * time.Second, maxRetryDelay: time.Minute},
+		{maxRetries: -1, expectedErr: fmt.Errorf("invalid arm max retries(-1), it should be between 0 and 20")},
+		{maxRetries: 21, expectedErr: fmt.Errorf("invalid arm max retries(21), it should be between 0 and 20")},
+		{retryDelay: 500 * time.Millisecond, expectedErr: fmt.Errorf("invalid arm retry delay(500ms), it should be between 1s and 5m0s")},
+		{maxRetryDelay: time.Hour, expectedErr: fmt.Errorf("invalid arm max retry delay(1h0m0s), it should be between 0 and 30m0s")},
+		{retryDelay: time.Minute, maxRetryDelay: time.Second, expectedErr: fmt.Errorf("arm retry delay(1m0s) should not be larger than arm max retry delay(1s)")},
+	}
+	for _, test := range tests {
+		err := validateARMRetryOptions(test.maxRetries, test.retryDelay, test.maxRetryDelay)
+		assert.Equal(t, test.expectedErr, err)
+	}
+}
+
+func TestParseAdditionalCloudConfigs(t *testing.T) {
+	tests := []struct {
+		cloudConfigs string
+		expected     map[string]string
+		expectedErr  error
+	}{
+		{cloudConfigs: "", expected: map[string]string{}},
+		{
+			cloudConfigs: "china=/etc/azure-china.json, westeurope = /etc/azure-westeurope.json,",
+			expected:     map[string]string{"china": "/etc/azure-china.json", "westeurope": "/etc/azure-westeurope.json"},
+		},
+		{cloudConfigs: "/etc/azure-china.json", expectedErr: fmt.Errorf("invalid cloud config(/etc/azure-china.json), expected format: cloudConfigName=path")},
+		{cloudConfigs: "china=", expectedErr: fmt.Errorf("invalid cloud config(china=), expected format: cloudConfigName=path")},
+		{cloudConfigs: "a#b=/etc/azure.json", expectedErr: fmt.Errorf("invalid cloud config name(a#b), # is not allowed")},
+		{cloudConfigs: "china=/etc/a.json,china=/etc/b.json", expectedErr: fmt.Errorf("duplicate cloud config name(china)")},
+	}
+	for _, test := range tests {
+		result, err := parseAdditionalCloudConfigs(test.cloudConfigs)
+		assert.Equal(t, test.expectedErr, err, test.cloudConfigs)
+		if test.expectedErr == nil {
+			assert.Equal(t, test.expected, result, test.cloudConfigs)
+		}
+	}
+}
+
+func TestGetAdditionalCloudProviders(t *testing.T) {
+	fakeCredFile := testutil.GetWorkDirPath("fake-cred-file-china.json", t)
+	if err := ioutil.WriteFile(fakeCredFile, []byte(`{"cloud": "AzureChinaCloud", "subscriptionId": "subs2", "resourceGroup": "rg2", "useInstanceMetadata": true}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fakeCredFile)
+
+	retryOptions := armRetryOptions{maxRetries: 3}
+	clouds, err := getAdditionalCloudProviders(map[string]string{"china": fakeCredFile}, "", "useragent", nil, retryOptions)
+	assert.NoError(t, err)
+	assert.Len(t, clouds, 1)
+	cloud := clouds["china"]
+	assert.Equal(t, "subs2", cloud.SubscriptionID)
+	assert.Equal(t, "rg2", cloud.ResourceGroup)
+	assert.Equal(t, "core.chinacloudapi.cn", cloud.Environment.StorageEndpointSuffix)
+	assert.Equal(t, "useragent", cloud.UserAgent)
+	assert.Equal(t, 3, cloud.CloudProviderBackoffRetries)
+	// IMDS is not used by controller
+	assert.False(t, cloud.UseInstanceMetadata)
+
+	_, err = getAdditionalCloudProviders(map[string]string{"china": "/tmp/non-existing-cloud-config.json"}, "", "useragent", nil, retryOptions)
+	assert.Error(t, err)
+}
+
+func TestGetMaxRetryDuration(t *testing.T) {
+	backoff := wait.Backoff{Steps: 4, Duration: time.Second, Factor: 2, Jitter: 1}
+	// 1s + 2s + 4s
+	assert.Equal(t, 7*time.Second, getMaxRetryDuration(backoff))
+	assert.Equal(t, time.Duration(0), getMaxRetryDuration(wait.Backoff{Steps: 1}))
+}
+
 func createTestFile(path string) error {
 	f, err := os.Create(path)
 	if err != nil {
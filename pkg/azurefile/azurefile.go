@@ -19,26 +19,36 @@ package azurefile
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/binary"
 	"fmt"
+	"net"
 	"net/url"
+	"os"
+	"os/signal"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	"github.com/Azure/azure-storage-file-go/azfile"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	"github.com/pborman/uuid"
 	"github.com/rubiojr/go-vhd/vhd"
+	"google.golang.org/grpc"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume/util"
 	mount "k8s.io/mount-utils"
+	"k8s.io/utils/pointer"
 
 	csicommon "sigs.k8s.io/azurefile-csi-driver/pkg/csi-common"
 	"sigs.k8s.io/azurefile-csi-driver/pkg/mounter"
@@ -51,7 +61,6 @@ import (
 const (
 	DefaultDriverName  = "file.csi.azure.com"
 	separator          = "#"
-	volumeIDTemplate   = "%s#%s#%s#%s#%s#%s"
 	secretNameTemplate = "azure-storage-account-%s-secret"
 	serviceURLTemplate = "https://%s.file.%s"
 	fileURLTemplate    = "https://%s.file.%s/%s/%s"
@@ -60,21 +69,71 @@ const (
 	dirMode            = "dir_mode"
 	actimeo            = "actimeo"
 	mfsymlinks         = "mfsymlinks"
+	handleTimeout      = "handletimeout"
+	echoInterval       = "echo_interval"
+	vers               = "vers"
+	seal               = "seal"
+	compress           = "compress"
+	nolease            = "nolease"
+	srcaddr            = "srcaddr"
+	nosharesock        = "nosharesock"
+	nfsvers            = "nfsvers"
+	minorVersion       = "minorversion"
+	sec                = "sec"
+	uid                = "uid"
+	gid                = "gid"
+	forceUID           = "forceuid"
+	forceGID           = "forcegid"
+	defaultNFSVersion  = "4.1"
 	defaultFileMode    = "0777"
 	defaultDirMode     = "0777"
 	defaultActimeo     = "30"
 
+	// handletimeout(in milliseconds) and echo_interval(in seconds) limits of the smb client
+	maxSMBHandleTimeout    = 16 * 60 * 1000
+	minSMBEchoInterval     = 1
+	maxSMBEchoInterval     = 600
+	defaultSMBEchoInterval = 60
+	// limits of ARM retry options
+	maxARMRetries       = 20
+	maxARMRetryDelay    = 5 * time.Minute
+	maxARMMaxRetryDelay = 30 * time.Minute
+	// uid and gid mount options are 32 bits, -1 is reserved
+	maxOwnerID = 1<<32 - 2
+
+	// statfs on the mount taking longer than this timeout means the mount is hung
+	defaultVolumeStatsTimeout = 2 * defaultSMBEchoInterval * time.Second
+
 	// See https://docs.microsoft.com/en-us/rest/api/storageservices/naming-and-referencing-shares--directories--files--and-metadata#share-names
 	fileShareNameMinLength = 3
 	fileShareNameMaxLength = 63
 
-	minimumPremiumShareSize = 100 // GB
+	// See https://docs.microsoft.com/en-us/azure/azure-resource-manager/management/resource-name-rules#microsoftstorage
+	storageAccountNameMinLength = 3
+	storageAccountNameMaxLength = 24
+
+	minimumPremiumShareSize  = 100 // GB
+	minimumStandardShareSize = 1   // GB
 	// Minimum size of Azure Premium Files is 100GiB
 	// See https://docs.microsoft.com/en-us/azure/storage/files/storage-files-planning#provisioned-shares
 	defaultAzureFileQuota = 100
+	// Maximum size of Azure File share is 100TiB, standard share without large file shares enabled is limited to 5TiB
+	// See https://docs.microsoft.com/en-us/azure/storage/files/storage-files-scale-targets#azure-file-share-scale-targets
+	maximumShareSize              = 102400 // GB
+	maximumStandardShareSizeNoLFS = 5120   // GB
+
+	// CreateVolume provisions the minimum share size of the sku if the requested capacity is below it
+	belowMinimumCapacityPolicyRoundUp = "round-up"
+	// CreateVolume fails with OutOfRange if the requested capacity is below the minimum share size of the sku
+	belowMinimumCapacityPolicyReject = "reject"
 
 	// key of snapshot name in metadata
 	snapshotNameKey = "initiator"
+	// key of retentionClass parameter of VolumeSnapshotClass in snapshot metadata, which is used by external lifecycle tooling
+	retentionClassMetadataKey = "retentionclass"
+	retentionClassField       = "retentionclass"
+	// prune the oldest snapshot created by the driver when the file share reaches its snapshot limit
+	autoPruneSnapshotsField = "autoprunesnapshots"
 
 	shareNameField                    = "sharename"
 	accessTierField                   = "accesstier"
@@ -114,6 +173,7 @@ const (
 	trueValue                         = "true"
 	defaultSecretAccountName          = "azurestorageaccountname"
 	defaultSecretAccountKey           = "azurestorageaccountkey"
+	secretConnectionString            = "azurestorageconnectionstring"
 	proxyMount                        = "proxy-mount"
 	cifs                              = "cifs"
 	smb                               = "smb"
@@ -130,6 +190,38 @@ const (
 	subnetNameField                   = "subnetname"
 	shareNamePrefixField              = "sharenameprefix"
 	requireInfraEncryptionField       = "requireinfraencryption"
+	encryptionScopeField              = "encryptionscope"
+	clientIDField                     = "clientid"
+	allowSharedKeyAccessField         = "allowsharedkeyaccess"
+	onDeleteRenameField               = "ondeleterename"
+	snapshotBeforeDeleteField         = "snapshotbeforedelete"
+	enableMfsymlinksField             = "enablemfsymlinks"
+	routingPreferenceField            = "routingpreference"
+	publishMicrosoftEndpointsField    = "publishmicrosoftendpoints"
+	publishInternetEndpointsField     = "publishinternetendpoints"
+	unmanagedQuotaField               = "unmanagedquota"
+	cloudConfigNameField              = "cloudconfigname"
+	dataPlaneAuthTypeField            = "dataplaneauthtype"
+	dataPlaneAuthTypeKey              = "key"
+	dataPlaneAuthTypeOAuth            = "oauth"
+	shareReadyTimeoutField            = "sharereadytimeout"
+	encryptInTransitField             = "encryptintransit"
+	enableCompressionField            = "enablecompression"
+	disableSMBLeasesField             = "disablesmbleases"
+	sourceAddressField                = "sourceaddress"
+	mountTenantField                  = "mounttenant"
+	restoreSoftDeletedShareField      = "restoresoftdeletedshare"
+	allowedAccessModesField           = "allowedaccessmodes"
+	mountProfileField                 = "mountprofile"
+	enforcePublicAccessPolicyField    = "enforcepublicaccesspolicy"
+	publicNetworkAccessField          = "publicnetworkaccess"
+	fallbackServersField              = "fallbackservers"
+	forceCloseHandlesOnDeleteField    = "forceclosehandlesondelete"
+	maxShareQuotaGiBField             = "maxsharequotagib"
+	minShareQuotaGiBField             = "minsharequotagib"
+	privateEndpointResourceGroupField = "privateendpointresourcegroup"
+	targetPathPermissionsField        = "targetpathpermissions"
+	targetPathOwnerField              = "targetpathowner"
 	premium                           = "premium"
 
 	accountNotProvisioned = "StorageAccountIsNotProvisioned"
@@ -137,13 +229,21 @@ const (
 	tooManyRequests   = "TooManyRequests"
 	shareBeingDeleted = "The specified share is being deleted"
 	clientThrottled   = "client throttled"
+	// returned by data plane API when requests to the storage account exceed its scalability targets
+	serverBusy = "ServerBusy"
 	// accountLimitExceed returned by different API
 	accountLimitExceedManagementAPI = "TotalSharesProvisionedCapacityExceedsAccountLimit"
 	accountLimitExceedDataPlaneAPI  = "specified share does not exist"
 
-	fileShareNotFound  = "ErrorCode=ShareNotFound"
-	statusCodeNotFound = "StatusCode=404"
-	httpCodeNotFound   = "HTTPStatusCode: 404"
+	authorizationFailed  = "AuthorizationFailed"
+	statusCodeForbidden  = "StatusCode=403"
+	authenticationFailed = "AuthenticationFailed"
+	permissionDenied     = "permission denied"
+
+	fileShareNotFound     = "ErrorCode=ShareNotFound"
+	statusCodeNotFound    = "StatusCode=404"
+	httpCodeNotFound      = "HTTPStatusCode: 404"
+	resourceGroupNotFound = "ResourceGroupNotFound"
 
 	// define different sleep time when hit throttling
 	accountOpThrottlingSleepSec = 16
@@ -160,6 +260,28 @@ const (
 	pvcNamespaceMetadata = "${pvc.metadata.namespace}"
 	pvNameMetadata       = "${pv.metadata.name}"
 
+	// file share metadata used by onDeleteRename, metadata name must be a valid C# identifier
+	onDeleteMetadataKey     = "csiondelete"
+	onDeleteArchive         = "archive"
+	deletedByCSIMetadataKey = "deletedbycsi"
+
+	// file share metadata used by forceCloseHandlesOnDelete, "true" closes open handles before deletion, "false"
+	// fails deletion if there is any open handle
+	forceCloseHandlesMetadataKey = "csiforceclosehandles"
+
+	// file share metadata recording --share-name-namespace of the driver which created the share
+	shareNameNamespaceMetadataKey = "csisharenamespace"
+
+	// file share metadata recording the share is created by the driver, DeleteVolume only deletes owned file shares
+	createdByMetadataKey = "csicreatedby"
+
+	// file share metadata recording minShareQuotaGiB and maxShareQuotaGiB of the storage class, enforced on expansion
+	minShareQuotaMetadataKey = "csiminsharequotagib"
+	maxShareQuotaMetadataKey = "csimaxsharequotagib"
+
+	// PVC annotation to override sku in storage class, only skus in --allowed-performance-tiers are allowed
+	performanceTierAnnotation = "azurefile.csi/performance-tier"
+
 	defaultStorageEndPointSuffix = "core.windows.net"
 
 	VolumeID         = "volumeid"
@@ -172,11 +294,49 @@ const (
 
 var (
 	supportedFsTypeList              = []string{cifs, smb, nfs, ext4, ext3, ext2, xfs}
-	supportedProtocolList            = []string{smb, nfs}
+	supportedProtocolList            = []string{smb, nfs, blobNFS}
+	supportedDataPlaneAuthTypeList   = []string{dataPlaneAuthTypeKey, dataPlaneAuthTypeOAuth}
+	supportedNFSVersionList          = []string{"4", defaultNFSVersion}
 	supportedDiskFsTypeList          = []string{ext4, ext3, ext2, xfs}
 	supportedFSGroupChangePolicyList = []string{FSGroupChangeNone, string(v1.FSGroupChangeAlways), string(v1.FSGroupChangeOnRootMismatch)}
 
+	// access modes of PVC allowed in allowedAccessModes parameter, and CSI access modes sent by external-provisioner for each of them
+	supportedAccessModeList = []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany, v1.ReadWriteMany, v1.ReadWriteOncePod}
+	accessModeMap           = map[v1.PersistentVolumeAccessMode][]csi.VolumeCapability_AccessMode_Mode{
+		v1.ReadWriteOnce:    {csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER, csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY},
+		v1.ReadOnlyMany:     {csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+		v1.ReadWriteMany:    {csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER},
+		v1.ReadWriteOncePod: {csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER},
+	}
+	// Azure Files SMB does not support SMB1 Unix extensions or SMB3 POSIX extensions
+	unsupportedSMBMountOptionList = []string{"unix", "linux", "posix"}
+	// lower case messages of mount errors caused by an unresolvable or unreachable server, mount.cifs returns
+	// EHOSTDOWN(112), EHOSTUNREACH(113), ETIMEDOUT(110), ECONNREFUSED(111) or ENETUNREACH(101)
+	mountConnectivityErrorList = []string{"mount error(101)", "mount error(110)", "mount error(111)", "mount error(112)", "mount error(113)",
+		"could not resolve address", "name or service not known", "failed to resolve server", "network is unreachable",
+		"no route to host", "host is down", "connection timed out", "connection refused", "network path was not found"}
+	// SMB versions supported by Azure Files, from highest to lowest
+	supportedSMBVersionList = []string{"3.1.1", "3.0", "2.1"}
+	// SMB versions which could be used by SMB version fallback if --allowed-smb-versions is not set,
+	// SMB 2.1 is excluded since it does not support encryption
+	defaultSMBFallbackVersionList = []string{"3.1.1", "3.0"}
+	// mount options which are only honored by the nfs client or the cifs client, mount flags of the other
+	// protocol are rejected by CreateVolume, uid, gid, forceuid and forcegid are checked by getNFSMountOptions
+	nfsOnlyMountOptionList = []string{nfsvers, minorVersion, "proto", "lookupcache", "local_lock", "nolock", "noresvport"}
+	smbOnlyMountOptionList = []string{fileMode, dirMode, mfsymlinks, handleTimeout, echoInterval, seal, compress, nolease, srcaddr, nosharesock, "nobrl", "nostrictsync", "serverino", "noserverino"}
+	// mount propagation flags which only apply to the bind mount in NodePublishVolume
+	supportedMountPropagationList = []string{"shared", "rshared", "slave", "rslave", "private", "rprivate"}
+
 	retriableErrors = []string{accountNotProvisioned, tooManyRequests, shareBeingDeleted, clientThrottled}
+
+	// backoff of retrying the first operations on a newly created storage account which may not be propagated yet
+	accountPropagationBackoff = wait.Backoff{Steps: 6, Duration: time.Second, Factor: 2.0, Jitter: 0.1}
+
+	// interval of polling a newly created file share until it's visible
+	shareReadyPollInterval = time.Second
+
+	// overall timeout of mounting the file share in NodeStageVolume, including mounts with fallback servers
+	stageMountTimeout = 2 * time.Minute
 )
 
 // DriverOptions defines driver parameters specified in driver deployment
@@ -193,8 +353,73 @@ type DriverOptions struct {
 	EnableGetVolumeStats                   bool
 	MountPermissions                       uint64
 	FSGroupChangePolicy                    string
+	ApplyPermissionsOnRootOnly             bool
 	KubeAPIQPS                             float64
 	KubeAPIBurst                           int
+	TagSyncInterval                        time.Duration
+	LeaseNamespace                         string
+	HealthMonitorInterval                  time.Duration
+	ShutdownGracePeriod                    time.Duration
+	AllowedPerformanceTiers                string
+	DebugAddress                           string
+	EnableMountProbe                       bool
+	SMBHandleTimeout                       int
+	SMBEchoInterval                        int
+	RunStartupChecks                       bool
+	StartupChecksFatal                     bool
+	AutoEnableLargeFileShares              bool
+	ARMMaxRetries                          int
+	ARMRetryDelay                          time.Duration
+	ARMMaxRetryDelay                       time.Duration
+	PrewarmAccounts                        string
+	PrewarmAccountsFromMounts              bool
+	AdditionalCloudConfigs                 string
+	AllowedSnapshotRetentionClasses        string
+	AllowedSMBVersions                     string
+	SMBVersionFallback                     bool
+	AutoSelectSMBVersion                   bool
+	RequireSMBEncryption                   bool
+	EnableAccountCapacityCheck             bool
+	AccountUsageCacheTTL                   time.Duration
+	CreateDirectoryTimeout                 time.Duration
+	CreateDirectoryMaxRetries              int
+	EnableCapacityTags                     bool
+	MigrateSourceAccount                   string
+	MigrateTargetAccount                   string
+	MigrateShares                          string
+	AdoptShares                            string
+	MountProfilesFile                      string
+	DisableStageUnstage                    bool
+	AllowBlobPublicAccess                  bool
+	RejectPublicAccessPolicyViolation      bool
+	RejectPublicNetworkAccessViolation     bool
+	ShareNameNamespace                     string
+	EnableTracing                          bool
+	OTLPEndpoint                           string
+	UnstagePolicy                          string
+	StrictSkuValidation                    bool
+	DefaultShareQuotaGiB                   int
+	DefaultSecretNamespace                 string
+	InheritResourceGroupTags               string
+	CredentialProviderPath                 string
+	CredentialProviderTimeout              time.Duration
+	CredentialProviderCacheTTL             time.Duration
+	DeleteUnownedShares                    bool
+	DeleteEmptyAccounts                    bool
+	DNSReadinessTimeout                    time.Duration
+	AllowCrossRegionMount                  bool
+	SecretKeySyncInterval                  time.Duration
+	BelowMinimumCapacityPolicy             string
+	ReportSMBShareStats                    bool
+	UpgradeV1Accounts                      bool
+	DeleteVolumeQPSPerAccount              float64
+	DeleteVolumeBurstPerAccount            int
+	SingleWriterViolationPolicy            string
+	AccountInventoryInterval               time.Duration
+	AccountInventoryResourceGroups         string
+	DisableStoreAccountKey                 bool
+	DefaultNetworkEndpointType             string
+	DisallowNetworkEndpointTypeOverride    bool
 }
 
 // Driver implements all interfaces of CSI drivers
@@ -206,6 +431,7 @@ type Driver struct {
 	customUserAgent                        string
 	userAgentSuffix                        string
 	fsGroupChangePolicy                    string
+	applyPermissionsOnRootOnly             bool
 	allowEmptyCloudConfig                  bool
 	allowInlineVolumeKeyAccessWithIdentity bool
 	enableVHDDiskFeature                   bool
@@ -213,6 +439,33 @@ type Driver struct {
 	mountPermissions                       uint64
 	kubeAPIQPS                             float64
 	kubeAPIBurst                           int
+	tagSyncInterval                        time.Duration
+	leaseNamespace                         string
+	healthMonitorInterval                  time.Duration
+	shutdownGracePeriod                    time.Duration
+	allowedPerformanceTiers                []string
+	debugAddress                           string
+	enableMountProbe                       bool
+	smbHandleTimeout                       int
+	smbEchoInterval                        int
+	runStartupChecks                       bool
+	startupChecksFatal                     bool
+	autoEnableLargeFileShares              bool
+	armRetryOptions                        armRetryOptions
+	prewarmAccounts                        string
+	prewarmAccountsFromMounts              bool
+	additionalCloudConfigs                 map[string]string
+	allowedSnapshotRetentionClasses        []string
+	allowedSMBVersions                     []string
+	smbVersionFallback                     bool
+	autoSelectSMBVersion                   bool
+	requireSMBEncryption                   bool
+	enableAccountCapacityCheck             bool
+	enableCapacityTags                     bool
+	migrateSourceAccount                   string
+	migrateTargetAccount                   string
+	migrateShares                          string
+	adoptShares                            string
 	fileClient                             *azureFileClient
 	mounter                                *mount.SafeFormatAndMount
 	// lock per volume attach (only for vhd disk feature)
@@ -236,6 +489,126 @@ type Driver struct {
 	accountSearchCache *azcache.TimedCache
 	// a timed cache storing tag removing history (solve account update throttling issue)
 	removeTagCache *azcache.TimedCache
+	// a timed cache storing used and total capacity of storage accounts <subsID/rg/accountName, accountUsage>
+	accountUsageCache *azcache.TimedCache
+	// a map storing cloud providers authenticated with user-assigned identities <clientID, *azure.Cloud>
+	clientIDCloudMap sync.Map
+	// a map storing OAuth tokens of storage resource of data plane requests <*azure.Cloud, *adal.ServicePrincipalToken>
+	storageTokenMap sync.Map
+	// a map storing storage accounts with a pending refresh of capacity tags <subsID/rg/accountName, struct{}>
+	capacityTagsPending sync.Map
+	// delay of the refresh of capacity tags, operations on one account within the delay are batched
+	capacityTagsDelay time.Duration
+	// leases held by this replica <namespace/name, holder identity>, guarded by leaseLock, they are released on
+	// shutdown and no lease is acquired after leasesReleased
+	heldLeases     map[string]string
+	leaseLock      sync.Mutex
+	leasesReleased bool
+	// a map storing cloud providers of additional cloud configs <cloudConfigName, *azure.Cloud>, only written in Run
+	cloudConfigCloudMap map[string]*azure.Cloud
+	// a map storing the additional cloud config name bound to each storage account <accountName, cloudConfigName>
+	accountCloudConfigMap sync.Map
+	// a map storing the cloud provider used for storage accounts in each non-default subscription <subsID, *azure.Cloud>
+	subscriptionCloudMap sync.Map
+	// a map storing all volumes staged on this node <stagingPath, stagedVolume>
+	stagedVolumes sync.Map
+	// a map storing the last redacted mount command of each volume on this node <volumeID, mountCommand>
+	mountCommands sync.Map
+	// records events of the health monitor on PVs and PVCs
+	eventRecorder record.EventRecorder
+	// last time an anomaly is reported on a PV <pvName/reason, time>, only accessed by the health monitor goroutine
+	healthMonitorEvents map[string]time.Time
+	// timeout and maximum retries of creating folderName directory in CreateVolume
+	createDirectoryTimeout    time.Duration
+	createDirectoryMaxRetries int
+	// creates one directory in a file share by data plane API, replaced in unit tests
+	createDirectory func(ctx context.Context, subsID, resourceGroup, accountName, accountKey, shareName, dirPath string) error
+	// restores a soft-deleted file share, replaced in unit tests
+	restoreFileShare func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup, accountName, shareName, deletedShareVersion string) error
+	// STAGE_UNSTAGE_VOLUME capability is not advertised, volume is mounted on target path in NodePublishVolume
+	disableStageUnstage bool
+	// default allowBlobPublicAccess of storage accounts created by CreateVolume, overridden by storage class
+	allowBlobPublicAccess bool
+	// fail CreateVolume instead of logging a warning if a reused account allows blob public access against the policy
+	rejectPublicAccessPolicyViolation bool
+	// fail CreateVolume instead of logging a warning if a reused account does not follow publicNetworkAccess of storage class
+	rejectPublicNetworkAccessViolation bool
+	// prefix of generated file share names and owner of created file shares, isolates shares of clusters sharing accounts
+	shareNameNamespace string
+	// export OpenTelemetry spans of CSI RPCs and ARM/data plane operations to otlpEndpoint
+	enableTracing bool
+	otlpEndpoint  string
+	// only accept skuName in the sku list of the storage API version the driver is built with
+	strictSkuValidation bool
+	// quota of the file share created by CreateVolume without capacity range
+	defaultShareQuotaGiB int
+	// namespace of account key secret if neither secretNamespace nor PVC namespace is known
+	defaultSecretNamespace string
+	// names of resource group tags applied on created storage accounts, * for all tags
+	inheritResourceGroupTags []string
+	getResourceGroupTags     func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error)
+	// get account keys from the external credential provider plugin instead of k8s secret or ARM if it's not nil
+	credentialProvider *execCredentialProvider
+	// delete file shares which are not created or adopted by the driver in DeleteVolume, they are kept by default
+	deleteUnownedShares bool
+	// delete storage accounts created by the driver when the last file share is deleted by DeleteVolume
+	deleteEmptyAccounts bool
+	// deletion locks of storage accounts, key is the lower case account name, value is *accountDeletionLock
+	accountDeletionLocks  sync.Map
+	deletePrivateEndpoint func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup, name string) error
+	// create the private endpoint of a storage account in another resource group than the virtual network
+	createPrivateEndpoint func(ctx context.Context, cloud *azure.Cloud, options privateEndpointOptions) error
+	// snapshot handles of VolumeSnapshotContents, only share snapshots without content are pruned by CreateSnapshot
+	snapshotClient            snapshotclientset.Interface
+	getSnapshotContentHandles func(ctx context.Context) (map[string]bool, error)
+	// resolve the server address before mounting for up to this timeout, disabled if 0
+	dnsReadinessTimeout time.Duration
+	// mount file shares on accounts in another region than the node, refused by NodeStageVolume if false
+	allowCrossRegionMount bool
+	// a timed cache storing region of storage accounts mounted on this node <subsID/rg/accountName, region>
+	accountRegionCache *azcache.TimedCache
+	// region of this node, resolved on the first NodeStageVolume which checks cross region mount
+	nodeRegion     string
+	nodeRegionLock sync.Mutex
+	// interval of refreshing account keys in secrets created by the driver, disabled if 0
+	secretKeySyncInterval time.Duration
+	// how CreateVolume handles a requested capacity below the minimum share size of the sku
+	belowMinimumCapacityPolicy string
+	// report quota and usage of SMB file shares from data plane API in NodeGetVolumeStats instead of statfs
+	reportSMBShareStats bool
+	// a timed cache storing quota and usage of SMB file shares mounted on this node <accountName/shareName, shareStats>
+	shareStatsCache *azcache.TimedCache
+	// upgrade StorageV1 accounts matching the storage class to StorageV2 before account selection
+	upgradeV1Accounts bool
+	// rate limit of file share deletions per storage account in DeleteVolume, disabled if qps is 0
+	deleteVolumeQPSPerAccount   float32
+	deleteVolumeBurstPerAccount int
+	// rate limiters of file share deletions <subsID/rg/accountName, flowcontrol.RateLimiter>
+	deleteVolumeLimiters sync.Map
+	// how NodeStageVolume and NodePublishVolume handle violation of single writer access mode on this node
+	singleWriterViolationPolicy string
+	// target paths of published volumes with single writer access mode <targetPath, volumeID>
+	singleWriterTargets sync.Map
+	// interval of exporting file share count and provisioned quota of owned storage accounts, disabled if it's 0
+	accountInventoryInterval time.Duration
+	// resource groups of owned storage accounts in account inventory, resource group of cloud config if it's empty
+	accountInventoryResourceGroups []string
+	// labels of account inventory gauges exported by the last collection <[resourceGroup, accountName], bool>
+	accountInventoryCollected sync.Map
+	// store account key in secret on CreateVolume, otherwise node gets account key with cluster identity on every mount
+	storeAccountKey bool
+	// network endpoint type of volumes without networkEndpointType in storage class, public if it's empty
+	defaultNetworkEndpointType string
+	// networkEndpointType in storage class could be different from defaultNetworkEndpointType
+	allowNetworkEndpointTypeOverride bool
+	// how NodeUnstageVolume handles a staging path which is still published by pods
+	unstagePolicy string
+	// publish targets of staged volumes, only counted if unstagePolicy is not unmount
+	stageRefs *stageRefs
+	// mount options of each mount profile which could be selected by mountProfile parameter <profileName, mountOptions>
+	mountProfiles map[string][]string
+	// a map storing the mount helpers found on this node <fsType, bool>
+	mountHelpers sync.Map
 }
 
 // NewDriver Creates a NewCSIDriver object. Assumes vendor version is equal to driver version &
@@ -255,13 +628,117 @@ func NewDriver(options *DriverOptions) *Driver {
 	driver.enableGetVolumeStats = options.EnableGetVolumeStats
 	driver.mountPermissions = options.MountPermissions
 	driver.fsGroupChangePolicy = options.FSGroupChangePolicy
+	driver.applyPermissionsOnRootOnly = options.ApplyPermissionsOnRootOnly
 	driver.kubeAPIQPS = options.KubeAPIQPS
 	driver.kubeAPIBurst = options.KubeAPIBurst
+	driver.tagSyncInterval = options.TagSyncInterval
+	driver.leaseNamespace = options.LeaseNamespace
+	driver.healthMonitorInterval = options.HealthMonitorInterval
+	driver.healthMonitorEvents = map[string]time.Time{}
+	driver.shutdownGracePeriod = options.ShutdownGracePeriod
+	driver.debugAddress = options.DebugAddress
+	driver.enableMountProbe = options.EnableMountProbe
+	if options.SMBHandleTimeout < 0 || options.SMBHandleTimeout > maxSMBHandleTimeout {
+		klog.Fatalf("invalid smb handle timeout(%d), it should be between 0 and %d (milliseconds)", options.SMBHandleTimeout, maxSMBHandleTimeout)
+	}
+	if options.SMBEchoInterval != 0 && (options.SMBEchoInterval < minSMBEchoInterval || options.SMBEchoInterval > maxSMBEchoInterval) {
+		klog.Fatalf("invalid smb echo interval(%d), it should be between %d and %d (seconds)", options.SMBEchoInterval, minSMBEchoInterval, maxSMBEchoInterval)
+	}
+	driver.smbHandleTimeout = options.SMBHandleTimeout
+	driver.smbEchoInterval = options.SMBEchoInterval
+	for _, version := range strings.Split(options.AllowedSMBVersions, ",") {
+		if version = strings.TrimSpace(version); version != "" {
+			if !isSupportedSMBVersion(version) {
+				klog.Fatalf("invalid smb version(%s) in allowed smb versions, supported smb version list: %v", version, supportedSMBVersionList)
+			}
+			driver.allowedSMBVersions = append(driver.allowedSMBVersions, version)
+		}
+	}
+	driver.smbVersionFallback = options.SMBVersionFallback
+	driver.autoSelectSMBVersion = options.AutoSelectSMBVersion
+	driver.requireSMBEncryption = options.RequireSMBEncryption
+	driver.enableAccountCapacityCheck = options.EnableAccountCapacityCheck
+	driver.enableCapacityTags = options.EnableCapacityTags
+	driver.capacityTagsDelay = defaultCapacityTagsDelay
+	driver.migrateSourceAccount = options.MigrateSourceAccount
+	driver.migrateTargetAccount = options.MigrateTargetAccount
+	driver.migrateShares = options.MigrateShares
+	driver.adoptShares = options.AdoptShares
+	driver.runStartupChecks = options.RunStartupChecks
+	driver.startupChecksFatal = options.StartupChecksFatal
+	driver.prewarmAccounts = options.PrewarmAccounts
+	driver.prewarmAccountsFromMounts = options.PrewarmAccountsFromMounts
+	driver.autoEnableLargeFileShares = options.AutoEnableLargeFileShares
+	if err := validateARMRetryOptions(options.ARMMaxRetries, options.ARMRetryDelay, options.ARMMaxRetryDelay); err != nil {
+		klog.Fatalf("%v", err)
+	}
+	driver.armRetryOptions = armRetryOptions{
+		maxRetries:    options.ARMMaxRetries,
+		retryDelay:    options.ARMRetryDelay,
+		maxRetryDelay: options.ARMMaxRetryDelay,
+	}
+	for _, tier := range strings.Split(options.AllowedPerformanceTiers, ",") {
+		if tier = strings.TrimSpace(tier); tier != "" {
+			driver.allowedPerformanceTiers = append(driver.allowedPerformanceTiers, tier)
+		}
+	}
+	for _, class := range strings.Split(options.AllowedSnapshotRetentionClasses, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			driver.allowedSnapshotRetentionClasses = append(driver.allowedSnapshotRetentionClasses, class)
+		}
+	}
 	driver.volLockMap = newLockMap()
 	driver.subnetLockMap = newLockMap()
 	driver.volumeLocks = newVolumeLocks()
 
 	var err error
+	if driver.additionalCloudConfigs, err = parseAdditionalCloudConfigs(options.AdditionalCloudConfigs); err != nil {
+		klog.Fatalf("%v", err)
+	}
+	if driver.mountProfiles, err = loadMountProfiles(options.MountProfilesFile); err != nil {
+		klog.Fatalf("%v", err)
+	}
+	driver.disableStageUnstage = options.DisableStageUnstage
+	driver.allowBlobPublicAccess = options.AllowBlobPublicAccess
+	driver.rejectPublicAccessPolicyViolation = options.RejectPublicAccessPolicyViolation
+	driver.rejectPublicNetworkAccessViolation = options.RejectPublicNetworkAccessViolation
+	if driver.defaultNetworkEndpointType, err = parseNetworkEndpointType(options.DefaultNetworkEndpointType); err != nil {
+		klog.Fatalf("invalid default network endpoint type(%s), supported values are [%s privateEndpoint]", options.DefaultNetworkEndpointType, publicEndpoint)
+	}
+	driver.allowNetworkEndpointTypeOverride = !options.DisallowNetworkEndpointTypeOverride
+	if !isSupportedShareNamePrefix(options.ShareNameNamespace) {
+		klog.Fatalf("share name namespace(%s) can only contain lowercase letters, numbers, hyphens, and length should be less than 21", options.ShareNameNamespace)
+	}
+	driver.shareNameNamespace = options.ShareNameNamespace
+	driver.enableTracing = options.EnableTracing
+	driver.otlpEndpoint = options.OTLPEndpoint
+	driver.strictSkuValidation = options.StrictSkuValidation
+	if options.DefaultShareQuotaGiB < 0 || options.DefaultShareQuotaGiB > maximumShareSize {
+		klog.Fatalf("invalid default share quota(%d GiB), it should not be negative or exceed %d (GiB)", options.DefaultShareQuotaGiB, maximumShareSize)
+	}
+	driver.defaultShareQuotaGiB = options.DefaultShareQuotaGiB
+	if driver.defaultShareQuotaGiB == 0 {
+		driver.defaultShareQuotaGiB = defaultAzureFileQuota
+	}
+	driver.defaultSecretNamespace = options.DefaultSecretNamespace
+	if driver.defaultSecretNamespace == "" {
+		driver.defaultSecretNamespace = defaultNamespace
+	}
+	driver.storeAccountKey = !options.DisableStoreAccountKey
+	driver.unstagePolicy = options.UnstagePolicy
+	if driver.unstagePolicy == "" {
+		driver.unstagePolicy = unstagePolicyUnmount
+	}
+	if err := validateUnstagePolicy(driver.unstagePolicy); err != nil {
+		klog.Fatalf("%v", err)
+	}
+	if driver.disableStageUnstage && driver.unstagePolicy != unstagePolicyUnmount {
+		klog.Fatalf("unstage policy(%s) could not be used when STAGE_UNSTAGE_VOLUME is disabled", driver.unstagePolicy)
+	}
+	driver.stageRefs = newStageRefs(func(stagingPath string) ([]string, error) {
+		return driver.mounter.GetMountRefs(stagingPath)
+	})
+
 	getter := func(key string) (interface{}, error) { return nil, nil }
 
 	if driver.secretCacheMap, err = azcache.NewTimedcache(time.Minute, getter); err != nil {
@@ -284,6 +761,86 @@ func NewDriver(options *DriverOptions) *Driver {
 		klog.Fatalf("%v", err)
 	}
 
+	accountUsageCacheTTL := options.AccountUsageCacheTTL
+	if accountUsageCacheTTL <= 0 {
+		accountUsageCacheTTL = defaultAccountUsageCacheTTL
+	}
+	if driver.accountUsageCache, err = azcache.NewTimedcache(accountUsageCacheTTL, getter); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
+	if driver.accountRegionCache, err = azcache.NewTimedcache(10*time.Minute, getter); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
+	if driver.shareStatsCache, err = azcache.NewTimedcache(shareStatsCacheTTL, getter); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
+	driver.restoreFileShare = restoreFileShareByARM
+	driver.inheritResourceGroupTags = parseInheritResourceGroupTags(options.InheritResourceGroupTags)
+	driver.getResourceGroupTags = getResourceGroupTagsByARM
+	driver.deletePrivateEndpoint = deletePrivateEndpointByARM
+	driver.createPrivateEndpoint = createPrivateEndpointByARM
+	driver.getSnapshotContentHandles = driver.listSnapshotContentHandles
+	if options.CredentialProviderPath != "" {
+		driver.credentialProvider = newExecCredentialProvider(options.CredentialProviderPath, options.CredentialProviderTimeout, options.CredentialProviderCacheTTL)
+	}
+	driver.deleteUnownedShares = options.DeleteUnownedShares
+	driver.deleteEmptyAccounts = options.DeleteEmptyAccounts
+	driver.dnsReadinessTimeout = options.DNSReadinessTimeout
+	driver.allowCrossRegionMount = options.AllowCrossRegionMount
+	driver.secretKeySyncInterval = options.SecretKeySyncInterval
+	driver.belowMinimumCapacityPolicy = options.BelowMinimumCapacityPolicy
+	if driver.belowMinimumCapacityPolicy == "" {
+		driver.belowMinimumCapacityPolicy = belowMinimumCapacityPolicyRoundUp
+	}
+	if driver.belowMinimumCapacityPolicy != belowMinimumCapacityPolicyRoundUp && driver.belowMinimumCapacityPolicy != belowMinimumCapacityPolicyReject {
+		klog.Fatalf("below minimum capacity policy(%s) is not supported, supported policies: %v", driver.belowMinimumCapacityPolicy, []string{belowMinimumCapacityPolicyRoundUp, belowMinimumCapacityPolicyReject})
+	}
+	driver.reportSMBShareStats = options.ReportSMBShareStats
+	driver.upgradeV1Accounts = options.UpgradeV1Accounts
+	if options.DeleteVolumeQPSPerAccount < 0 {
+		klog.Fatalf("invalid delete volume qps per account(%v), it should not be negative", options.DeleteVolumeQPSPerAccount)
+	}
+	if options.DeleteVolumeQPSPerAccount > 0 && options.DeleteVolumeBurstPerAccount < 1 {
+		klog.Fatalf("invalid delete volume burst per account(%d), it should be at least 1", options.DeleteVolumeBurstPerAccount)
+	}
+	driver.deleteVolumeQPSPerAccount = float32(options.DeleteVolumeQPSPerAccount)
+	driver.deleteVolumeBurstPerAccount = options.DeleteVolumeBurstPerAccount
+	driver.singleWriterViolationPolicy = options.SingleWriterViolationPolicy
+	if driver.singleWriterViolationPolicy == "" {
+		driver.singleWriterViolationPolicy = singleWriterViolationPolicyIgnore
+	}
+	switch driver.singleWriterViolationPolicy {
+	case singleWriterViolationPolicyIgnore, singleWriterViolationPolicyWarn, singleWriterViolationPolicyReject:
+	default:
+		klog.Fatalf("single writer violation policy(%s) is not supported, supported policies: %v", driver.singleWriterViolationPolicy,
+			[]string{singleWriterViolationPolicyIgnore, singleWriterViolationPolicyWarn, singleWriterViolationPolicyReject})
+	}
+	if options.AccountInventoryInterval > 0 && options.AccountInventoryInterval < minAccountInventoryInterval {
+		klog.Fatalf("account inventory interval(%v) should be at least %v", options.AccountInventoryInterval, minAccountInventoryInterval)
+	}
+	driver.accountInventoryInterval = options.AccountInventoryInterval
+	for _, resourceGroup := range strings.Split(options.AccountInventoryResourceGroups, ",") {
+		if resourceGroup = strings.TrimSpace(resourceGroup); resourceGroup != "" {
+			driver.accountInventoryResourceGroups = append(driver.accountInventoryResourceGroups, resourceGroup)
+		}
+	}
+
+	if options.CreateDirectoryMaxRetries < 0 {
+		klog.Fatalf("invalid create directory max retries(%d), it should not be negative", options.CreateDirectoryMaxRetries)
+	}
+	driver.createDirectoryMaxRetries = options.CreateDirectoryMaxRetries
+	driver.createDirectoryTimeout = options.CreateDirectoryTimeout
+	if driver.createDirectoryTimeout <= 0 {
+		driver.createDirectoryTimeout = defaultCreateDirectoryTimeout
+	}
+	driver.createDirectory = driver.createDirectoryByDataPlane
+
+	registerAccountMetrics()
+	registerCapacityTagsMetrics()
+	registerAccountInventoryMetrics()
 	return &driver
 }
 
@@ -297,20 +854,78 @@ func (d *Driver) Run(endpoint, kubeconfig string, testBool bool) {
 
 	userAgent := GetUserAgent(d.Name, d.customUserAgent, d.userAgentSuffix)
 	klog.V(2).Infof("driver userAgent: %s", userAgent)
-	d.cloud, err = getCloudProvider(kubeconfig, d.NodeID, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, userAgent, d.allowEmptyCloudConfig, d.kubeAPIQPS, d.kubeAPIBurst)
+	d.cloud, err = getCloudProvider(kubeconfig, d.NodeID, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, userAgent, d.allowEmptyCloudConfig, d.kubeAPIQPS, d.kubeAPIBurst, d.armRetryOptions)
 	if err != nil {
 		klog.Fatalf("failed to get Azure Cloud Provider, error: %v", err)
 	}
 	klog.V(2).Infof("cloud: %s, location: %s, rg: %s, VnetName: %s, VnetResourceGroup: %s, SubnetName: %s", d.cloud.Cloud, d.cloud.Location, d.cloud.ResourceGroup, d.cloud.VnetName, d.cloud.VnetResourceGroup, d.cloud.SubnetName)
+	if len(d.additionalCloudConfigs) > 0 {
+		d.cloudConfigCloudMap, err = getAdditionalCloudProviders(d.additionalCloudConfigs, d.NodeID, userAgent, d.cloud.KubeClient, d.armRetryOptions)
+		if err != nil {
+			klog.Fatalf("failed to get additional Azure Cloud Providers, error: %v", err)
+		}
+	}
+
+	if kubeCfg, err := getKubeConfig(kubeconfig); err == nil && kubeCfg != nil {
+		kubeCfg.QPS = float32(d.kubeAPIQPS)
+		kubeCfg.Burst = d.kubeAPIBurst
+		if d.snapshotClient, err = snapshotclientset.NewForConfig(kubeCfg); err != nil {
+			klog.Warningf("failed to create snapshot client: %v", err)
+		}
+	}
 
 	// todo: set backoff from cloud provider config
 	d.fileClient = newAzureFileClient(&d.cloud.Environment, &retry.Backoff{Steps: 1})
 
+	if d.migrateSourceAccount != "" {
+		if err := d.runShareMigration(context.Background(), d.migrateSourceAccount, d.migrateTargetAccount, d.migrateShares); err != nil {
+			klog.Fatalf("share migration failed: %v", err)
+		}
+		klog.V(2).Infof("share migration from account(%s) to account(%s) finished", d.migrateSourceAccount, d.migrateTargetAccount)
+		return
+	}
+
+	if d.adoptShares != "" {
+		if err := d.runShareAdoption(context.Background(), d.adoptShares); err != nil {
+			klog.Fatalf("share adoption failed: %v", err)
+		}
+		klog.V(2).Infof("share adoption in share name namespace(%s) finished", d.shareNameNamespace)
+		return
+	}
+
+	if d.runStartupChecks {
+		d.runStartupCheck(d.startupChecksFatal)
+	}
+
+	if d.tagSyncInterval > 0 {
+		d.runTagSync(d.tagSyncInterval)
+	}
+
+	if d.healthMonitorInterval > 0 {
+		d.runHealthMonitor(d.healthMonitorInterval)
+	}
+
+	if d.secretKeySyncInterval > 0 {
+		d.runSecretKeySync(d.secretKeySyncInterval)
+	}
+
+	if d.accountInventoryInterval > 0 {
+		resourceGroups := d.accountInventoryResourceGroups
+		if len(resourceGroups) == 0 {
+			resourceGroups = []string{d.cloud.ResourceGroup}
+		}
+		d.runAccountInventory(d.accountInventoryInterval, resourceGroups)
+	}
+
 	d.mounter, err = mounter.NewSafeMounter()
 	if err != nil {
 		klog.Fatalf("Failed to get safe mounter. Error: %v", err)
 	}
 
+	if d.prewarmAccounts != "" || d.prewarmAccountsFromMounts {
+		d.runAccountKeyPrewarm(d.prewarmAccounts, d.prewarmAccountsFromMounts)
+	}
+
 	// Initialize default library driver
 	d.AddControllerServiceCapabilities(
 		[]csi.ControllerServiceCapability_RPC_Type{
@@ -319,6 +934,7 @@ func (d *Driver) Run(endpoint, kubeconfig string, testBool bool) {
 			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
 			//csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
 			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+			csi.ControllerServiceCapability_RPC_GET_VOLUME,
 			csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 		})
 	d.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{
@@ -332,19 +948,62 @@ func (d *Driver) Run(endpoint, kubeconfig string, testBool bool) {
 	})
 
 	nodeCap := []csi.NodeServiceCapability_RPC_Type{
-		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 		csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
 	}
+	if !d.disableStageUnstage {
+		nodeCap = append(nodeCap, csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME)
+	}
 	if d.enableGetVolumeStats {
 		nodeCap = append(nodeCap, csi.NodeServiceCapability_RPC_GET_VOLUME_STATS)
+		if d.reportSMBShareStats {
+			// volume condition tells whether the share quota or the account capacity limits available bytes
+			nodeCap = append(nodeCap, csi.NodeServiceCapability_RPC_VOLUME_CONDITION)
+		}
 	}
 	d.AddNodeServiceCapabilities(nodeCap)
 
-	s := csicommon.NewNonBlockingGRPCServer()
+	if d.debugAddress != "" {
+		if err := d.serveDebug(d.debugAddress); err != nil {
+			klog.Errorf("failed to start debug endpoint: %v", err)
+		}
+	}
+
+	var interceptors []grpc.UnaryServerInterceptor
+	if d.enableTracing {
+		shutdownTracing, err := initTracing(context.Background(), d.otlpEndpoint, d.Name)
+		if err != nil {
+			klog.Fatalf("%v", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				klog.Warningf("failed to flush spans: %v", err)
+			}
+		}()
+		interceptors = append(interceptors, traceGRPC)
+	}
+
+	s := csicommon.NewNonBlockingGRPCServer(interceptors...)
 	// Driver d act as IdentityServer, ControllerServer and NodeServer
 	s.Start(endpoint, d, d, d, testBool)
+	if !testBool {
+		go func() {
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+			sig := <-sigCh
+			// stop accepting new requests and wait for in-flight requests to finish
+			klog.Infof("received signal(%v), shutting down with grace period(%v)", sig, d.shutdownGracePeriod)
+			// another replica takes over the leases without waiting for them to expire
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			d.releaseLeases(ctx)
+			cancel()
+			s.StopWithTimeout(d.shutdownGracePeriod)
+		}()
+	}
 	s.Wait()
+	klog.Infof("driver(%s) is stopped", d.Name)
 }
 
 // getFileShareQuota return (-1, nil) means file share does not exist
@@ -369,7 +1028,7 @@ func (d *Driver) getFileShareQuota(ctx context.Context, subsID, resourceGroupNam
 		return share.Properties.Quota, nil
 	}
 
-	fileShare, err := d.cloud.GetFileShare(ctx, subsID, resourceGroupName, accountName, fileShareName)
+	fileShare, err := d.getCloudByAccount(ctx, subsID, resourceGroupName, accountName).GetFileShare(ctx, subsID, resourceGroupName, accountName, fileShareName)
 	if err != nil {
 		if strings.Contains(err.Error(), "ShareNotFound") {
 			return -1, nil
@@ -383,34 +1042,50 @@ func (d *Driver) getFileShareQuota(ctx context.Context, subsID, resourceGroupNam
 	return int(*fileShare.FileShareProperties.ShareQuota), nil
 }
 
+// waitForFileShareReady polls a newly created file share until it's visible, the wait is bounded by
+// both timeout and the deadline of ctx
+func (d *Driver) waitForFileShareReady(ctx context.Context, subsID, resourceGroupName, accountName, fileShareName string, secrets map[string]string, timeout time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "waitForFileShareReady", resourceGroupAttribute.String(resourceGroupName), accountNameAttribute.String(accountName), shareNameAttribute.String(fileShareName))
+	defer func() { endSpan(span, err) }()
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = wait.PollImmediateUntil(shareReadyPollInterval, func() (bool, error) {
+		quota, err := d.getFileShareQuota(waitCtx, subsID, resourceGroupName, accountName, fileShareName, secrets)
+		if err != nil {
+			klog.Warningf("failed to get file share(%s) on account(%s) rg(%s) while waiting for it to be ready: %v", fileShareName, accountName, resourceGroupName, err)
+			return false, nil
+		}
+		return quota != -1, nil
+	}, waitCtx.Done())
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("file share(%s) on account(%s) rg(%s) is not visible after %v", fileShareName, accountName, resourceGroupName, timeout)
+	}
+	return nil
+}
+
 // get file share info according to volume id, e.g.
 // input: "rg#f5713de20cde511e8ba4900#fileShareName#diskname.vhd#uuid#namespace#subsID"
 // output: rg, f5713de20cde511e8ba4900, fileShareName, diskname.vhd, namespace, subsID
 func GetFileShareInfo(id string) (string, string, string, string, string, string, error) {
-	segments := strings.Split(id, separator)
-	if len(segments) < 3 {
-		return "", "", "", "", "", "", fmt.Errorf("error parsing volume id: %q, should at least contain two #", id)
-	}
-	rg := segments[0]
-	var diskName, namespace, subsID string
-	if len(segments) > 3 {
-		diskName = segments[3]
+	h, err := parseVolumeHandle(id)
+	if err != nil {
+		return "", "", "", "", "", "", err
 	}
-	if rg == "" {
-		// in csi migration, rg could be empty, then the 5th element is namespace
-		// https://github.com/kubernetes/kubernetes/blob/v1.23.5/staging/src/k8s.io/csi-translation-lib/plugins/azure_file.go#L137
-		if len(segments) > 4 {
-			namespace = segments[4]
-		}
-	} else {
-		if len(segments) > 5 {
-			namespace = segments[5]
-		}
-		if len(segments) > 6 {
-			subsID = segments[6]
-		}
+	return h.resourceGroup, h.accountName, h.fileShareName, h.diskName, h.secretNamespace, h.subsID, nil
+}
+
+// get cloud config name according to volume id, e.g.
+// input: "rg#f5713de20cde511e8ba4900#fileShareName#diskname.vhd#uuid#namespace#subsID#cloudConfigName"
+// output: cloudConfigName, empty if volume is provisioned with the default cloud config
+func getCloudConfigName(id string) string {
+	h, err := parseVolumeHandle(id)
+	if err != nil {
+		return ""
 	}
-	return rg, segments[1], segments[2], diskName, namespace, subsID, nil
+	return h.cloudConfigName
 }
 
 // check whether mountOptions contains file_mode, dir_mode, vers, if not, append default mode
@@ -474,6 +1149,20 @@ func getStorageAccount(secrets map[string]string) (string, string, error) {
 		}
 	}
 
+	if connStr := getConnectionString(secrets); connStr != "" {
+		// connection string is not included in error since it contains account key
+		conn, err := parseConnectionString(connStr)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid %s field in secrets: %v", secretConnectionString, err)
+		}
+		if accountName == "" {
+			accountName = conn.accountName
+		}
+		if accountKey == "" {
+			accountKey = conn.accountKey
+		}
+	}
+
 	if accountName == "" {
 		return "", "", fmt.Errorf("could not find accountname or azurestorageaccountname field secrets(%v)", secrets)
 	}
@@ -486,6 +1175,73 @@ func getStorageAccount(secrets map[string]string) (string, string, error) {
 	return accountName, accountKey, nil
 }
 
+// storageConnectionString is the parsed Azure storage connection string
+type storageConnectionString struct {
+	accountName    string
+	accountKey     string
+	endpointSuffix string
+	// host of FileEndpoint, e.g. accountname.file.core.windows.net
+	fileEndpoint string
+}
+
+// getConnectionString returns the connection string in secrets, key is case insensitive
+func getConnectionString(secrets map[string]string) string {
+	for k, v := range secrets {
+		if strings.EqualFold(k, secretConnectionString) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// parseConnectionString parses the connection string in format:
+// DefaultEndpointsProtocol=https;AccountName=<name>;AccountKey=<key>;EndpointSuffix=core.windows.net
+// returned error never contains values of the connection string
+func parseConnectionString(connStr string) (*storageConnectionString, error) {
+	conn := &storageConnectionString{}
+	var sas string
+	for i, field := range strings.Split(connStr, ";") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("field %d is not in key=value format", i)
+		}
+		v := strings.TrimSpace(kv[1])
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "accountname":
+			conn.accountName = v
+		case "accountkey":
+			conn.accountKey = v
+		case "endpointsuffix":
+			conn.endpointSuffix = v
+		case "fileendpoint":
+			u, err := url.Parse(v)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("FileEndpoint is not a valid URL")
+			}
+			conn.fileEndpoint = u.Host
+		case "sharedaccesssignature":
+			sas = v
+		}
+	}
+
+	if conn.accountName == "" {
+		return nil, fmt.Errorf("AccountName is missing")
+	}
+	if conn.accountKey == "" {
+		if sas != "" {
+			return nil, fmt.Errorf("SharedAccessSignature is not supported since mounting file share requires AccountKey")
+		}
+		return nil, fmt.Errorf("AccountKey is missing")
+	}
+	if _, err := base64.StdEncoding.DecodeString(conn.accountKey); err != nil {
+		return nil, fmt.Errorf("AccountKey is not base64 encoded")
+	}
+	return conn, nil
+}
+
 // File share names can contain only lowercase letters, numbers, and hyphens,
 // and must begin and end with a letter or a number,
 // and must be from 3 through 63 characters long.
@@ -522,6 +1278,14 @@ func checkShareNameBeginAndEnd(fileShareName string) bool {
 // input: "rg#f5713de20cde511e8ba4900#csivolumename#diskname#2019-08-22T07:17:53.0000000Z"
 // output: 2019-08-22T07:17:53.0000000Z (last element)
 func getSnapshot(id string) (string, error) {
+	if strings.HasPrefix(id, volumeHandleV2Prefix) {
+		// snapshot name is appended to a versioned volume handle, e.g. "v2:account=account&share=share#2019-08-22T07:17:53.0000000Z"
+		i := strings.LastIndex(id, separator)
+		if i < 0 {
+			return "", fmt.Errorf("error parsing volume id: %q, should contain #", id)
+		}
+		return id[i+1:], nil
+	}
 	segments := strings.Split(id, separator)
 	if len(segments) < 5 {
 		return "", fmt.Errorf("error parsing volume id: %q, should at least contain four #", id)
@@ -596,7 +1360,7 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 		err = nil
 	}
 
-	var protocol, accountKey, secretName, pvcNamespace string
+	var protocol, fsType, accountKey, secretName, pvcNamespace, clientID, cloudConfigName string
 	// indicates whether get account key only from k8s secret
 	getAccountKeyFromSecret := false
 
@@ -618,34 +1382,68 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 			diskName = v
 		case protocolField:
 			protocol = v
+		case fsTypeField:
+			fsType = v
 		case secretNameField:
 			secretName = v
 		case secretNamespaceField:
 			secretNamespace = v
 		case pvcNamespaceKey:
 			pvcNamespace = v
+		case clientIDField:
+			clientID = v
+		case cloudConfigNameField:
+			cloudConfigName = v
 		}
 	}
 
+	if resolvedProtocol, resolveErr := resolveProtocol(fsType, protocol); resolveErr == nil {
+		// invalid fsType is reported by caller
+		protocol = resolvedProtocol
+	}
+	if clientID != "" {
+		// the user-assigned identity in volume context takes precedence over the one in volume handle
+		ctx = withClientID(ctx, clientID)
+	} else if _, ok := ctx.Value(volumeClientIDKey{}).(string); !ok {
+		ctx = withVolumeClientID(ctx, volumeID)
+	}
+	if cloudConfigName == "" {
+		cloudConfigName = getCloudConfigName(volumeID)
+	}
+	if bindErr := d.bindAccountToCloudConfig(cloudConfigName, accountName); bindErr != nil {
+		// account key could still be got from k8s secret without the cloud config
+		klog.Warningf("bind account(%s) to cloud config failed with error: %v", accountName, bindErr)
+	}
+
+	cloud := d.getCloudBySubscription(ctx, subsID, rgName, accountName)
 	if rgName == "" {
-		rgName = d.cloud.ResourceGroup
+		if subsID != "" && !strings.EqualFold(subsID, cloud.SubscriptionID) {
+			klog.Warningf("resource group of account(%s) in subscription(%s) is not specified and no cloud config is in the subscription, use resource group(%s) of cloud config", accountName, subsID, cloud.ResourceGroup)
+		}
+		rgName = cloud.ResourceGroup
 	}
 	if subsID == "" {
-		subsID = d.cloud.SubscriptionID
+		subsID = cloud.SubscriptionID
 	}
-	if protocol == nfs && fileShareName != "" {
+	if isNFSProtocol(protocol) && fileShareName != "" {
 		// nfs protocol does not need account key, return directly
 		return rgName, accountName, accountKey, fileShareName, diskName, subsID, err
 	}
 
-	if secretNamespace == "" {
-		if pvcNamespace == "" {
-			secretNamespace = defaultNamespace
-		} else {
-			secretNamespace = pvcNamespace
-		}
+	if len(secrets) == 0 && d.credentialProvider != nil {
+		// account key is only got from the credential provider, neither from k8s secret nor ARM
+		accountKey, err = d.credentialProvider.getAccountKey(ctx, credentialProviderRequest{
+			VolumeID:       volumeID,
+			SubscriptionID: subsID,
+			ResourceGroup:  rgName,
+			AccountName:    accountName,
+			FileShareName:  fileShareName,
+		})
+		return rgName, accountName, accountKey, fileShareName, diskName, subsID, err
 	}
 
+	secretNamespace, secretNamespaceSource := d.resolveSecretNamespace(secretNamespace, pvcNamespace)
+
 	if len(secrets) == 0 {
 		// read account key from cache first
 		cache, errCache := d.accountCacheMap.Get(accountName, azcache.CacheReadTypeDefault)
@@ -655,9 +1453,12 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 		if cache != nil {
 			accountKey = cache.(string)
 		} else {
-			if secretName == "" && accountName != "" {
+			// account key secret created by the driver is not read if keys are not stored in secrets, a secret
+			// specified in volume attributes is still read
+			if secretName == "" && accountName != "" && (d.storeAccountKey || getAccountKeyFromSecret) {
 				secretName = fmt.Sprintf(secretNameTemplate, accountName)
 			}
+			getKeyByIdentity := secretName == ""
 			if secretName != "" {
 				var name string
 				name, accountKey, err = d.GetStorageAccountFromSecret(ctx, secretName, secretNamespace)
@@ -665,14 +1466,17 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 					accountName = name
 				}
 				if err != nil {
+					err = fmt.Errorf("%v, namespace is resolved from %s", err, secretNamespaceSource)
 					klog.Warningf("GetStorageAccountFromSecret(%s, %s) failed with error: %v", secretName, secretNamespace, err)
-					if !getAccountKeyFromSecret && d.cloud.StorageAccountClient != nil && accountName != "" {
-						klog.V(2).Infof("use cluster identity to get account key from (%s, %s, %s)", subsID, rgName, accountName)
-						accountKey, err = d.cloud.GetStorageAccesskey(ctx, subsID, accountName, rgName)
-						if err != nil {
-							klog.Errorf("GetStorageAccesskey(%s, %s, %s) failed with error: %v", subsID, rgName, accountName, err)
-						}
-					}
+					getKeyByIdentity = true
+				}
+			}
+			if cloud = d.getCloudBySubscription(ctx, subsID, rgName, accountName); getKeyByIdentity && !getAccountKeyFromSecret && cloud.StorageAccountClient != nil && accountName != "" {
+				klog.V(2).Infof("use cluster identity to get account key from (%s, %s, %s)", subsID, rgName, accountName)
+				accountKey, err = cloud.GetStorageAccesskey(ctx, subsID, accountName, rgName)
+				if err != nil {
+					klog.Errorf("GetStorageAccesskey(%s, %s, %s) failed with error: %v", subsID, rgName, accountName, err)
+					err = fmt.Errorf("failed to get key of account(%s) in subscription(%s) rg(%s) with cluster identity: %w", accountName, subsID, rgName, err)
 				}
 			}
 		}
@@ -693,6 +1497,15 @@ func (d *Driver) GetAccountInfo(ctx context.Context, volumeID string, secrets, r
 	return rgName, accountName, accountKey, fileShareName, diskName, subsID, err
 }
 
+// getMaximumShareSize returns the maximum share size(GiB) according to account kind,
+// large file shares is only configurable on standard account
+func getMaximumShareSize(accountKind string, enableLFS *bool) int {
+	if accountKind == string(storage.KindStorageV2) && !pointer.BoolDeref(enableLFS, true) {
+		return maximumStandardShareSizeNoLFS
+	}
+	return maximumShareSize
+}
+
 func isSupportedProtocol(protocol string) bool {
 	if protocol == "" {
 		return true
@@ -705,6 +1518,52 @@ func isSupportedProtocol(protocol string) bool {
 	return false
 }
 
+func isSupportedDataPlaneAuthType(authType string) bool {
+	if authType == "" {
+		return true
+	}
+	for _, v := range supportedDataPlaneAuthTypeList {
+		if authType == v {
+			return true
+		}
+	}
+	return false
+}
+
+func isSupportedSMBVersion(version string) bool {
+	for _, v := range supportedSMBVersionList {
+		if version == v {
+			return true
+		}
+	}
+	return false
+}
+
+// isGeoRedundantSku returns true if sku is GRS, GZRS or the read-access variant
+func isGeoRedundantSku(sku string) bool {
+	sku = strings.ToLower(sku)
+	return strings.HasSuffix(sku, "grs") || strings.HasSuffix(sku, "gzrs")
+}
+
+// isKnownSku returns true if sku is in the sku list of the storage API version the driver is built with
+func isKnownSku(sku string) bool {
+	for _, v := range storage.PossibleSkuNameValues() {
+		if strings.EqualFold(sku, string(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSupportedSku returns true if strict is false or sku is a known sku, skus added by Azure after the driver is built
+// are validated by ARM if strict is false
+func isSupportedSku(sku string, strict bool) bool {
+	if sku == "" || !strict {
+		return true
+	}
+	return isKnownSku(sku)
+}
+
 func isSupportedShareAccessTier(accessTier string) bool {
 	if accessTier == "" {
 		return true
@@ -753,9 +1612,71 @@ func isSupportedFSGroupChangePolicy(policy string) bool {
 	return false
 }
 
+// isValidServerAddress checks whether server is an IPv4 address or a host name,
+// server overrides the host of mount source, e.g. private endpoint FQDN or IP
+func isValidServerAddress(server string) bool {
+	if server == "" {
+		return true
+	}
+	if ip := net.ParseIP(server); ip != nil {
+		return ip.To4() != nil
+	}
+	if len(server) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(server, ".") {
+		if len(label) == 0 || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseFallbackServers parses the comma separated server addresses tried in order when the mount fails
+// with a connectivity error, duplicated addresses are removed
+func parseFallbackServers(value string) ([]string, error) {
+	var servers []string
+	seen := map[string]bool{}
+	for _, v := range strings.Split(value, ",") {
+		server := strings.TrimSpace(v)
+		if server == "" {
+			continue
+		}
+		if !isValidServerAddress(server) {
+			return nil, fmt.Errorf("invalid %s: %s, only IPv4 address or DNS name is supported", fallbackServersField, server)
+		}
+		if !seen[strings.ToLower(server)] {
+			seen[strings.ToLower(server)] = true
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// validateStorageAccountName returns the violation if name is not a valid storage account name,
+// which must be 3-24 characters long and contain only lowercase letters and numbers
+func validateStorageAccountName(name string) error {
+	if len(name) < storageAccountNameMinLength || len(name) > storageAccountNameMaxLength {
+		return fmt.Errorf("length(%d) must be between %d and %d characters", len(name), storageAccountNameMinLength, storageAccountNameMaxLength)
+	}
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("character %q at position %d is not allowed, only lowercase letters and numbers are allowed", c, i)
+		}
+	}
+	return nil
+}
+
 // CreateFileShare creates a file share
-func (d *Driver) CreateFileShare(ctx context.Context, accountOptions *azure.AccountOptions, shareOptions *fileclient.ShareOptions, secrets map[string]string) error {
-	return wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+func (d *Driver) CreateFileShare(ctx context.Context, accountOptions *azure.AccountOptions, shareOptions *fileclient.ShareOptions, secrets map[string]string) (err error) {
+	ctx, span := startSpan(ctx, "CreateFileShare", resourceGroupAttribute.String(accountOptions.ResourceGroup), accountNameAttribute.String(accountOptions.Name), shareNameAttribute.String(shareOptions.Name))
+	defer func() { endSpan(span, err) }()
+	return wait.ExponentialBackoffWithContext(ctx, d.cloud.RequestBackoff(), func() (bool, error) {
 		var err error
 		if len(secrets) > 0 {
 			accountName, accountKey, rerr := getStorageAccount(secrets)
@@ -764,11 +1685,11 @@ func (d *Driver) CreateFileShare(ctx context.Context, accountOptions *azure.Acco
 			}
 			err = d.fileClient.CreateFileShare(accountName, accountKey, shareOptions)
 		} else {
-			_, err = d.cloud.FileClient.WithSubscriptionID(accountOptions.SubscriptionID).CreateFileShare(ctx, accountOptions.ResourceGroup, accountOptions.Name, shareOptions, "")
+			_, err = d.getCloudByAccount(ctx, accountOptions.SubscriptionID, accountOptions.ResourceGroup, accountOptions.Name).FileClient.WithSubscriptionID(accountOptions.SubscriptionID).CreateFileShare(ctx, accountOptions.ResourceGroup, accountOptions.Name, shareOptions, "")
 		}
 		if isRetriableError(err) {
 			klog.Warningf("CreateFileShare(%s) on account(%s) failed with error(%v), waiting for retrying", shareOptions.Name, accountOptions.Name, err)
-			sleepIfThrottled(err, fileOpThrottlingSleepSec)
+			sleepIfThrottled(ctx, err, fileOpThrottlingSleepSec)
 			return false, nil
 		}
 		return true, err
@@ -776,8 +1697,12 @@ func (d *Driver) CreateFileShare(ctx context.Context, accountOptions *azure.Acco
 }
 
 // DeleteFileShare deletes a file share using storage account name and key
-func (d *Driver) DeleteFileShare(ctx context.Context, subsID, resourceGroup, accountName, shareName string, secrets map[string]string) error {
-	return wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+func (d *Driver) DeleteFileShare(ctx context.Context, subsID, resourceGroup, accountName, shareName string, secrets map[string]string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteFileShare", resourceGroupAttribute.String(resourceGroup), accountNameAttribute.String(accountName), shareNameAttribute.String(shareName))
+	defer func() { endSpan(span, err) }()
+	// the last retriable error is returned if retries are exhausted, so that callers could tell throttling
+	var lastErr error
+	err = wait.ExponentialBackoffWithContext(ctx, d.cloud.RequestBackoff(), func() (bool, error) {
 		var err error
 		if len(secrets) > 0 {
 			accountName, accountKey, rerr := getStorageAccount(secrets)
@@ -786,16 +1711,12 @@ func (d *Driver) DeleteFileShare(ctx context.Context, subsID, resourceGroup, acc
 			}
 			err = d.fileClient.deleteFileShare(accountName, accountKey, shareName)
 		} else {
-			err = d.cloud.DeleteFileShare(ctx, subsID, resourceGroup, accountName, shareName)
+			err = d.getCloudByAccount(ctx, subsID, resourceGroup, accountName).DeleteFileShare(ctx, subsID, resourceGroup, accountName, shareName)
 		}
 
-		if err != nil {
-			if strings.Contains(err.Error(), statusCodeNotFound) ||
-				strings.Contains(err.Error(), httpCodeNotFound) ||
-				strings.Contains(err.Error(), fileShareNotFound) {
-				klog.Warningf("DeleteFileShare(%s) on account(%s) failed with error(%v), return as success", shareName, accountName, err)
-				return true, nil
-			}
+		if isNotFoundError(err) {
+			klog.Warningf("file share(%s) or account(%s) is already deleted, DeleteFileShare failed with error(%v), return as success", shareName, accountName, err)
+			return true, nil
 		}
 
 		if isRetriableError(err) {
@@ -805,16 +1726,23 @@ func (d *Driver) DeleteFileShare(ctx context.Context, subsID, resourceGroup, acc
 				d.dataPlaneAPIAccountCache.Set(accountName, "")
 				return true, err
 			}
+			lastErr = err
 			return false, nil
 		}
 
 		return true, err
 	})
+	if err == wait.ErrWaitTimeout && lastErr != nil {
+		return fmt.Errorf("DeleteFileShare(%s) on account(%s) failed after retries: %w", shareName, accountName, lastErr)
+	}
+	return err
 }
 
 // ResizeFileShare resizes a file share
-func (d *Driver) ResizeFileShare(ctx context.Context, subsID, resourceGroup, accountName, shareName string, sizeGiB int, secrets map[string]string) error {
-	return wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+func (d *Driver) ResizeFileShare(ctx context.Context, subsID, resourceGroup, accountName, shareName string, sizeGiB int, secrets map[string]string) (err error) {
+	ctx, span := startSpan(ctx, "ResizeFileShare", resourceGroupAttribute.String(resourceGroup), accountNameAttribute.String(accountName), shareNameAttribute.String(shareName))
+	defer func() { endSpan(span, err) }()
+	return wait.ExponentialBackoffWithContext(ctx, d.cloud.RequestBackoff(), func() (bool, error) {
 		var err error
 		if len(secrets) > 0 {
 			accountName, accountKey, rerr := getStorageAccount(secrets)
@@ -823,11 +1751,11 @@ func (d *Driver) ResizeFileShare(ctx context.Context, subsID, resourceGroup, acc
 			}
 			err = d.fileClient.resizeFileShare(accountName, accountKey, shareName, sizeGiB)
 		} else {
-			err = d.cloud.ResizeFileShare(ctx, subsID, resourceGroup, accountName, shareName, sizeGiB)
+			err = d.getCloudByAccount(ctx, subsID, resourceGroup, accountName).ResizeFileShare(ctx, subsID, resourceGroup, accountName, shareName, sizeGiB)
 		}
 		if isRetriableError(err) {
 			klog.Warningf("ResizeFileShare(%s) on account(%s) with new size(%d) failed with error(%v), waiting for retrying", shareName, accountName, sizeGiB, err)
-			sleepIfThrottled(err, fileOpThrottlingSleepSec)
+			sleepIfThrottled(ctx, err, fileOpThrottlingSleepSec)
 			return false, nil
 		}
 		return true, err
@@ -848,12 +1776,40 @@ func (d *Driver) RemoveStorageAccountTag(ctx context.Context, subsID, resourceGr
 
 	klog.V(2).Infof("remove tag(%s) on account(%s) subsID(%s), resourceGroup(%s)", key, account, subsID, resourceGroup)
 	defer d.removeTagCache.Set(account, key)
-	if rerr := d.cloud.RemoveStorageAccountTag(ctx, subsID, resourceGroup, account, key); rerr != nil {
+	if rerr := d.getCloudByAccount(ctx, subsID, resourceGroup, account).RemoveStorageAccountTag(ctx, subsID, resourceGroup, account, key); rerr != nil {
+		return rerr.Error()
+	}
+	return nil
+}
+
+// addStorageAccountTags merges tags into the tags of storage account
+func (d *Driver) addStorageAccountTags(ctx context.Context, subsID, resourceGroup, account string, tags map[string]*string) error {
+	if rerr := d.getCloudByAccount(ctx, subsID, resourceGroup, account).AddStorageAccountTags(ctx, subsID, resourceGroup, account, tags); rerr != nil {
 		return rerr.Error()
 	}
 	return nil
 }
 
+// retryOnAccountNotPropagated runs operation again if it fails with not found or forbidden error, which could be
+// returned for a while after the storage account is created since the account is not propagated to all ARM and
+// data plane endpoints yet. It must only be used on a newly created account, the last error is returned if the
+// error persists after the backoff, which means the account or share is not found or not authorized indeed.
+func retryOnAccountNotPropagated(ctx context.Context, operation string, run func() error) error {
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, accountPropagationBackoff, func() (bool, error) {
+		lastErr = run()
+		if isNotFoundError(lastErr) || isAuthorizationError(lastErr) {
+			klog.Warningf("%s failed on newly created storage account, retrying since it may not be propagated yet: %v", operation, lastErr)
+			return false, nil
+		}
+		return true, lastErr
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("%s failed after %d attempts: %w", operation, accountPropagationBackoff.Steps, lastErr)
+	}
+	return err
+}
+
 // GetStorageAccesskey get Azure storage account key from
 //  1. secrets (if not empty)
 //  2. use k8s client identity to read from k8s secret
@@ -881,7 +1837,9 @@ func (d *Driver) GetStorageAccesskey(ctx context.Context, accountOptions *azure.
 	_, accountKey, err := d.GetStorageAccountFromSecret(ctx, secretName, secretNamespace)
 	if err != nil {
 		klog.V(2).Infof("could not get account(%s) key from secret(%s), error: %v, use cluster identity to get account key instead", accountOptions.Name, secretName, err)
-		accountKey, err = d.cloud.GetStorageAccesskey(ctx, accountOptions.SubscriptionID, accountName, accountOptions.ResourceGroup)
+		keyCtx, span := startSpan(ctx, "GetStorageAccesskey", resourceGroupAttribute.String(accountOptions.ResourceGroup), accountNameAttribute.String(accountName))
+		accountKey, err = d.getCloudBySubscription(ctx, accountOptions.SubscriptionID, accountOptions.ResourceGroup, accountName).GetStorageAccesskey(keyCtx, accountOptions.SubscriptionID, accountName, accountOptions.ResourceGroup)
+		endSpan(span, err)
 	}
 
 	if err == nil && accountKey != "" {
@@ -890,23 +1848,255 @@ func (d *Driver) GetStorageAccesskey(ctx context.Context, accountOptions *azure.
 	return accountKey, err
 }
 
+// resolveSecretNamespace returns the namespace of the account key secret and where it's resolved from, secretNamespace
+// in storage class, volume handle or volume attributes takes precedence over namespace of the PVC, which is only
+// known in CreateVolume and NodeStageVolume of volumes provisioned with extra-create-metadata, then the driver default
+func (d *Driver) resolveSecretNamespace(secretNamespace, pvcNamespace string) (string, string) {
+	if secretNamespace != "" {
+		return secretNamespace, secretNamespaceField
+	}
+	if pvcNamespace != "" {
+		return pvcNamespace, "PVC namespace"
+	}
+	return d.defaultSecretNamespace, "driver default namespace"
+}
+
 // GetStorageAccountFromSecret get storage account key from k8s secret
 // return <accountName, accountKey, error>
 func (d *Driver) GetStorageAccountFromSecret(ctx context.Context, secretName, secretNamespace string) (string, string, error) {
 	if d.cloud.KubeClient == nil {
-		return "", "", fmt.Errorf("could not get account key from secret(%s): KubeClient is nil", secretName)
+		return "", "", fmt.Errorf("could not get account key from secret(%s) in namespace(%s): KubeClient is nil", secretName, secretNamespace)
 	}
 
 	secret, err := d.cloud.KubeClient.CoreV1().Secrets(secretNamespace).Get(ctx, secretName, metav1.GetOptions{})
 	if err != nil {
-		return "", "", fmt.Errorf("could not get secret(%v): %v", secretName, err)
+		return "", "", fmt.Errorf("could not get secret(%s) in namespace(%s): %v", secretName, secretNamespace, err)
 	}
 
 	accountName := strings.TrimSpace(string(secret.Data[defaultSecretAccountName][:]))
 	accountKey := strings.TrimSpace(string(secret.Data[defaultSecretAccountKey][:]))
+	if accountKey == "" {
+		return accountName, "", fmt.Errorf("secret(%s) in namespace(%s) does not contain %s", secretName, secretNamespace, defaultSecretAccountKey)
+	}
 	return accountName, accountKey, nil
 }
 
+// isInfraEncryptionEnabled checks whether infrastructure encryption is enabled on the storage account
+func (d *Driver) isInfraEncryptionEnabled(ctx context.Context, subsID, resourceGroup, accountName string) (bool, error) {
+	cloud := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	if cloud.StorageAccountClient == nil {
+		return false, fmt.Errorf("StorageAccountClient is nil")
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return false, fmt.Errorf("failed to get storage account(%s) in resource group(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	if account.AccountProperties == nil || account.AccountProperties.Encryption == nil {
+		return false, nil
+	}
+	return pointer.BoolDeref(account.AccountProperties.Encryption.RequireInfrastructureEncryption, false), nil
+}
+
+// isLargeFileSharesEnabled checks whether large file shares is enabled on the storage account
+func (d *Driver) isLargeFileSharesEnabled(ctx context.Context, subsID, resourceGroup, accountName string) (bool, error) {
+	cloud := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	if cloud.StorageAccountClient == nil {
+		return false, fmt.Errorf("StorageAccountClient is nil")
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return false, fmt.Errorf("failed to get storage account(%s) in resource group(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	return account.AccountProperties != nil && account.AccountProperties.LargeFileSharesState == storage.LargeFileSharesStateEnabled, nil
+}
+
+// isBlobPublicAccessAllowed checks whether blob public access is allowed on the storage account,
+// it's allowed if the property is not set, which is the default of accounts created before it's disabled by default
+func (d *Driver) isBlobPublicAccessAllowed(ctx context.Context, subsID, resourceGroup, accountName string) (bool, error) {
+	cloud := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	if cloud.StorageAccountClient == nil {
+		return false, fmt.Errorf("StorageAccountClient is nil")
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return false, fmt.Errorf("failed to get storage account(%s) in resource group(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	if account.AccountProperties == nil {
+		return true, nil
+	}
+	return pointer.BoolDeref(account.AccountProperties.AllowBlobPublicAccess, true), nil
+}
+
+// invalidateAccountKey removes the cached account key if err is caused by authentication failure,
+// e.g. account key is regenerated after account failover, so that next request would get the key again
+func (d *Driver) invalidateAccountKey(accountName string, err error) {
+	if accountName == "" || !isAuthenticationError(err) {
+		return
+	}
+	klog.Warningf("remove cached key of account(%s) since authentication failed with error: %v", accountName, err)
+	if err := d.accountCacheMap.Delete(accountName); err != nil {
+		klog.Warningf("failed to remove cached key of account(%s): %v", accountName, err)
+	}
+	if d.credentialProvider != nil {
+		d.credentialProvider.invalidate(accountName)
+	}
+}
+
+// getRoutingPreference returns the routing preference of storage account, returns nil if none is specified
+func getRoutingPreference(routingChoice string, publishMicrosoftEndpoints, publishInternetEndpoints *bool) (*storage.RoutingPreference, error) {
+	if routingChoice == "" && publishMicrosoftEndpoints == nil && publishInternetEndpoints == nil {
+		return nil, nil
+	}
+	routingPreference := &storage.RoutingPreference{
+		PublishMicrosoftEndpoints: publishMicrosoftEndpoints,
+		PublishInternetEndpoints:  publishInternetEndpoints,
+	}
+	if routingChoice != "" {
+		for _, v := range storage.PossibleRoutingChoiceValues() {
+			if strings.EqualFold(routingChoice, string(v)) {
+				routingPreference.RoutingChoice = v
+			}
+		}
+		if routingPreference.RoutingChoice == "" {
+			return nil, fmt.Errorf("%s(%s) is not supported, supported %s list: %v", routingPreferenceField, routingChoice, routingPreferenceField, storage.PossibleRoutingChoiceValues())
+		}
+	}
+	return routingPreference, nil
+}
+
+// isRoutingPreferenceEqual returns true if routing preferences are equal, Microsoft routing is the default routing choice
+func isRoutingPreferenceEqual(a, b *storage.RoutingPreference) bool {
+	getRoutingChoice := func(p *storage.RoutingPreference) storage.RoutingChoice {
+		if p.RoutingChoice == "" {
+			return storage.RoutingChoiceMicrosoftRouting
+		}
+		return p.RoutingChoice
+	}
+	return getRoutingChoice(a) == getRoutingChoice(b) &&
+		pointer.BoolDeref(a.PublishMicrosoftEndpoints, false) == pointer.BoolDeref(b.PublishMicrosoftEndpoints, false) &&
+		pointer.BoolDeref(a.PublishInternetEndpoints, false) == pointer.BoolDeref(b.PublishInternetEndpoints, false)
+}
+
+// getPVCPerformanceTier returns the performance tier specified in PVC annotation
+func (d *Driver) getPVCPerformanceTier(ctx context.Context, pvcNamespace, pvcName string) (string, error) {
+	if d.cloud.KubeClient == nil {
+		return "", fmt.Errorf("could not get pvc(%s/%s): KubeClient is nil", pvcNamespace, pvcName)
+	}
+	pvc, err := d.cloud.KubeClient.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get pvc(%s/%s): %v", pvcNamespace, pvcName, err)
+	}
+	return strings.TrimSpace(pvc.Annotations[performanceTierAnnotation]), nil
+}
+
+// isAllowedPerformanceTier checks whether the performance tier is in --allowed-performance-tiers
+func (d *Driver) isAllowedPerformanceTier(tier string) bool {
+	for _, v := range d.allowedPerformanceTiers {
+		if strings.EqualFold(tier, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedSnapshotRetentionClass checks whether the retention class is in --allowed-snapshot-retention-classes,
+// any retention class is allowed if the list is empty
+func (d *Driver) isAllowedSnapshotRetentionClass(class string) bool {
+	if len(d.allowedSnapshotRetentionClasses) == 0 {
+		return true
+	}
+	for _, v := range d.allowedSnapshotRetentionClasses {
+		if class == v {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedSMBVersion checks whether the SMB version is in --allowed-smb-versions,
+// any SMB version is allowed if the list is empty
+func (d *Driver) isAllowedSMBVersion(version string) bool {
+	if len(d.allowedSMBVersions) == 0 {
+		return true
+	}
+	for _, v := range d.allowedSMBVersions {
+		if version == v {
+			return true
+		}
+	}
+	return false
+}
+
+// getHighestAllowedSMBVersion returns the highest SMB version in --allowed-smb-versions, which is set on SMB mount
+// without vers option, so that the kernel could not negotiate a version which is not allowed, it returns empty
+// string if the list is empty
+func (d *Driver) getHighestAllowedSMBVersion() string {
+	for _, v := range supportedSMBVersionList {
+		for _, allowed := range d.allowedSMBVersions {
+			if v == allowed {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// getSMBFallbackVersion returns the next lower SMB version which is allowed to fall back to from version,
+// empty version means the highest supported version, returns empty string if there is no such version
+func (d *Driver) getSMBFallbackVersion(version string) string {
+	if version == "" {
+		version = supportedSMBVersionList[0]
+	}
+	allowedVersions := d.allowedSMBVersions
+	if len(allowedVersions) == 0 {
+		allowedVersions = defaultSMBFallbackVersionList
+	}
+	lower := false
+	for _, v := range supportedSMBVersionList {
+		if lower {
+			for _, allowed := range allowedVersions {
+				if v == allowed {
+					return v
+				}
+			}
+		}
+		if v == version {
+			lower = true
+		}
+	}
+	return ""
+}
+
+// selectSMBVersion returns the highest SMB version for an SMB mount without vers option, which is allowed by
+// --allowed-smb-versions (SMB 3 versions if it's not set) and supported by the node kernel. Premium file shares are
+// only mounted with SMB 3 versions since SMB multichannel of premium accounts requires SMB 3, so are file shares
+// requiring encryption. It returns empty string if there is no such version.
+func (d *Driver) selectSMBVersion(sku string, requireEncryption bool) string {
+	allowedVersions := d.allowedSMBVersions
+	if len(allowedVersions) == 0 {
+		allowedVersions = defaultSMBFallbackVersionList
+	}
+	isPremium := strings.HasPrefix(strings.ToLower(sku), premium)
+	for _, v := range supportedSMBVersionList {
+		allowed := false
+		for _, allowedVersion := range allowedVersions {
+			if v == allowedVersion {
+				allowed = true
+				break
+			}
+		}
+		if !allowed || ((isPremium || requireEncryption) && !isSMBEncryptionSupportedVersion(v)) {
+			continue
+		}
+		if err := checkSMBVersionSupport(d.mounter, v); err != nil {
+			klog.V(2).Infof("skip SMB version(%s) in SMB version selection: %v", v, err)
+			continue
+		}
+		return v
+	}
+	return ""
+}
+
 // getSubnetResourceID get default subnet resource ID from cloud provider config
 func (d *Driver) getSubnetResourceID(vnetResourceGroup, vnetName, subnetName string) string {
 	subsID := d.cloud.SubscriptionID
@@ -931,6 +2121,143 @@ func (d *Driver) getSubnetResourceID(vnetResourceGroup, vnetName, subnetName str
 	return fmt.Sprintf(subnetTemplate, subsID, vnetResourceGroup, vnetName, subnetName)
 }
 
+// getCloudByClientID returns a cloud provider authenticated with the user-assigned identity,
+// the default cloud provider is returned if clientID is empty
+func (d *Driver) getCloudByClientID(clientID string) (*azure.Cloud, error) {
+	if clientID == "" {
+		return d.cloud, nil
+	}
+	if v, ok := d.clientIDCloudMap.Load(clientID); ok {
+		return v.(*azure.Cloud), nil
+	}
+
+	config := d.cloud.Config
+	config.UseManagedIdentityExtension = true
+	config.UserAssignedIdentityID = clientID
+	az := &azure.Cloud{InitSecretConfig: d.cloud.InitSecretConfig}
+	if err := az.InitializeCloudFromConfig(context.TODO(), &config, false, false); err != nil {
+		return nil, fmt.Errorf("failed to initialize cloud provider with user-assigned identity(%s): %v", clientID, err)
+	}
+	setAccountCreateHookClient(az)
+	az.KubeClient = d.cloud.KubeClient
+	klog.V(2).Infof("initialized cloud provider with user-assigned identity(%s)", clientID)
+
+	v, _ := d.clientIDCloudMap.LoadOrStore(clientID, az)
+	return v.(*azure.Cloud), nil
+}
+
+// getCloudByConfigName returns the cloud provider of the additional cloud config,
+// the default cloud provider is returned if cloudConfigName is empty
+func (d *Driver) getCloudByConfigName(cloudConfigName string) (*azure.Cloud, error) {
+	if cloudConfigName == "" {
+		return d.cloud, nil
+	}
+	if cloud, ok := d.cloudConfigCloudMap[cloudConfigName]; ok {
+		return cloud, nil
+	}
+	names := make([]string, 0, len(d.cloudConfigCloudMap))
+	for name := range d.cloudConfigCloudMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("cloud config(%s) is not found, configured cloud configs: %v", cloudConfigName, names)
+}
+
+// bindAccountToCloudConfig binds the storage account to the additional cloud config,
+// so that following requests on the account are sent to that cloud by getCloud
+func (d *Driver) bindAccountToCloudConfig(cloudConfigName, accountName string) error {
+	if cloudConfigName == "" || accountName == "" {
+		return nil
+	}
+	if _, err := d.getCloudByConfigName(cloudConfigName); err != nil {
+		return err
+	}
+	d.accountCloudConfigMap.Store(accountName, cloudConfigName)
+	return nil
+}
+
+// getCloud returns the cloud provider of the cloud config bound to the storage account,
+// the default cloud provider is returned if there is nothing bound to the account
+func (d *Driver) getCloud(accountName string) *azure.Cloud {
+	if v, ok := d.accountCloudConfigMap.Load(accountName); ok {
+		if cloud, ok := d.cloudConfigCloudMap[v.(string)]; ok {
+			return cloud
+		}
+	}
+	return d.cloud
+}
+
+// volumeClientIDKey is the context key of the user-assigned identity of the volume in a request
+type volumeClientIDKey struct{}
+
+// withVolumeClientID returns the context of a request on the volume, the storage account of the volume is managed
+// with the user-assigned identity kept in the volume handle by getCloudByAccount, or with the default identity if
+// the handle has none. The context is not changed if the volume handle could not be parsed.
+func withVolumeClientID(ctx context.Context, volumeID string) context.Context {
+	h, err := parseVolumeHandle(volumeID)
+	if err != nil {
+		return ctx
+	}
+	return withClientID(ctx, h.clientID)
+}
+
+// withClientID returns the context of a request on a storage account managed with the user-assigned identity
+func withClientID(ctx context.Context, clientID string) context.Context {
+	return context.WithValue(ctx, volumeClientIDKey{}, clientID)
+}
+
+// getCloudByAccount returns the cloud provider of the cloud config bound to the storage account, or of the
+// user-assigned identity of the volume in ctx, the default cloud provider is returned if there is neither
+func (d *Driver) getCloudByAccount(ctx context.Context, subsID, resourceGroup, accountName string) *azure.Cloud {
+	if _, ok := d.accountCloudConfigMap.Load(accountName); ok {
+		return d.getCloud(accountName)
+	}
+	clientID, _ := ctx.Value(volumeClientIDKey{}).(string)
+	if clientID == "" {
+		return d.cloud
+	}
+	cloud, err := d.getCloudByClientID(clientID)
+	if err != nil {
+		klog.Warningf("getCloudByClientID(%s) on account(%s) failed with error: %v, use default identity instead", clientID, accountName, err)
+		return d.cloud
+	}
+	return cloud
+}
+
+// getCloudBySubscription returns the cloud provider to manage accountName in subscription subsID. The cloud bound to
+// the account takes precedence, otherwise the cloud of the additional cloud config in subsID is used for an account in
+// a non-default subscription, so that its identity and resource group apply, the default cloud is used if none matches
+func (d *Driver) getCloudBySubscription(ctx context.Context, subsID, resourceGroup, accountName string) *azure.Cloud {
+	if subsID == "" || strings.EqualFold(subsID, d.cloud.SubscriptionID) {
+		return d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	}
+	if _, ok := d.accountCloudConfigMap.Load(accountName); ok {
+		return d.getCloud(accountName)
+	}
+	if clientID, _ := ctx.Value(volumeClientIDKey{}).(string); clientID != "" {
+		return d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	}
+	key := strings.ToLower(subsID)
+	if v, ok := d.subscriptionCloudMap.Load(key); ok {
+		return v.(*azure.Cloud)
+	}
+	names := make([]string, 0, len(d.cloudConfigCloudMap))
+	for name := range d.cloudConfigCloudMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cloud := d.cloud
+	for _, name := range names {
+		if strings.EqualFold(d.cloudConfigCloudMap[name].SubscriptionID, subsID) {
+			klog.V(2).Infof("use cloud config(%s) for storage accounts in subscription(%s)", name, subsID)
+			cloud = d.cloudConfigCloudMap[name]
+			break
+		}
+	}
+	v, _ := d.subscriptionCloudMap.LoadOrStore(key, cloud)
+	return v.(*azure.Cloud)
+}
+
 func (d *Driver) useDataPlaneAPI(volumeID, accountName string) bool {
 	_, useDataPlaneAPI := d.dataPlaneAPIVolMap.Load(volumeID)
 	if useDataPlaneAPI {
@@ -962,6 +2289,8 @@ func (d *Driver) SetAzureCredentials(ctx context.Context, accountName, accountKe
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: secretNamespace,
 			Name:      secretName,
+			// account key in the secret is refreshed by secret key sync only if the secret is created by the driver
+			Labels: map[string]string{secretCreatedByLabel: createdByDriver},
 		},
 		Data: map[string][]byte{
 			defaultSecretAccountName: []byte(accountName),
@@ -61,16 +61,25 @@ func (f *azureFileClient) CreateFileShare(accountName, accountKey string, shareO
 	if shareOptions == nil {
 		return fmt.Errorf("shareOptions of account(%s) is nil", accountName)
 	}
-	return f.createFileShare(accountName, accountKey, shareOptions.Name, shareOptions.RequestGiB)
+	metadata := map[string]string{}
+	for k, v := range shareOptions.Metadata {
+		if v != nil {
+			metadata[k] = *v
+		}
+	}
+	return f.createFileShare(accountName, accountKey, shareOptions.Name, shareOptions.RequestGiB, metadata)
 }
 
-func (f *azureFileClient) createFileShare(accountName, accountKey, name string, sizeGiB int) error {
+func (f *azureFileClient) createFileShare(accountName, accountKey, name string, sizeGiB int, metadata map[string]string) error {
 	fileClient, err := f.getFileSvcClient(accountName, accountKey)
 	if err != nil {
 		return err
 	}
 	share := fileClient.GetShareReference(name)
 	share.Properties.Quota = sizeGiB
+	if len(metadata) > 0 {
+		share.Metadata = metadata
+	}
 	newlyCreated, err := share.CreateIfNotExists(nil)
 	if err != nil {
 		return fmt.Errorf("failed to create file share, err: %v", err)
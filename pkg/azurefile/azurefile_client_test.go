@@ -105,7 +105,7 @@ func TestCreateFileShare(t *testing.T) {
 				if !reflect.DeepEqual(actualErr, expectedErr) {
 					t.Errorf("actualErr: (%v), expectedErr: (%v)", actualErr, expectedErr)
 				}
-				actualErr = f.createFileShare(accountName, accountKey, "unit-test", 10)
+				actualErr = f.createFileShare(accountName, accountKey, "unit-test", 10, nil)
 				if !reflect.DeepEqual(actualErr, expectedErr) {
 					t.Errorf("actualErr: (%v), expectedErr: (%v)", actualErr, expectedErr)
 				}
@@ -21,23 +21,32 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	azure2 "github.com/Azure/go-autorest/autorest/azure"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
 
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 	auth "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
 )
 
 const (
@@ -178,6 +187,21 @@ func TestAppendDefaultMountOptions(t *testing.T) {
 	}
 }
 
+func TestGetCloudConfigName(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected string
+	}{
+		{id: "rg#account#share", expected: ""},
+		{id: "rg#account#share#diskname#uuid#namespace#subsID", expected: ""},
+		{id: "rg#account#share##uuid#namespace#subsID#china", expected: "china"},
+		{id: "#account#share##uuid#namespace#subsID#china", expected: ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getCloudConfigName(test.id), test.id)
+	}
+}
+
 func TestGetFileShareInfo(t *testing.T) {
 	tests := []struct {
 		id                string
@@ -396,6 +420,22 @@ func TestGetStorageAccount(t *testing.T) {
 			expected2: "",
 			expected3: fmt.Errorf("unexpected: getStorageAccount secrets is nil"),
 		},
+		{
+			options: map[string]string{
+				"AzureStorageConnectionString": "DefaultEndpointsProtocol=https;AccountName=testaccount;AccountKey=dGVzdGtleQ==;EndpointSuffix=core.windows.net",
+			},
+			expected1: "testaccount",
+			expected2: "dGVzdGtleQ==",
+			expected3: nil,
+		},
+		{
+			options: map[string]string{
+				secretConnectionString: "AccountName=testaccount;AccountKey=",
+			},
+			expected1: "",
+			expected2: "",
+			expected3: fmt.Errorf("invalid azurestorageconnectionstring field in secrets: AccountKey is missing"),
+		},
 	}
 
 	for _, test := range tests {
@@ -411,6 +451,73 @@ func TestGetStorageAccount(t *testing.T) {
 	}
 }
 
+func TestParseConnectionString(t *testing.T) {
+	tests := []struct {
+		desc        string
+		connStr     string
+		expected    *storageConnectionString
+		expectedErr error
+	}{
+		{
+			desc:    "valid connection string",
+			connStr: "DefaultEndpointsProtocol=https;AccountName=testaccount;AccountKey=dGVzdGtleQ==;EndpointSuffix=core.chinacloudapi.cn",
+			expected: &storageConnectionString{
+				accountName:    "testaccount",
+				accountKey:     "dGVzdGtleQ==",
+				endpointSuffix: "core.chinacloudapi.cn",
+			},
+		},
+		{
+			desc:    "valid connection string with FileEndpoint and trailing semicolon",
+			connStr: "accountname=testaccount; accountkey=dGVzdGtleQ==; FileEndpoint=https://testaccount.privatelink.file.core.windows.net/;",
+			expected: &storageConnectionString{
+				accountName:  "testaccount",
+				accountKey:   "dGVzdGtleQ==",
+				fileEndpoint: "testaccount.privatelink.file.core.windows.net",
+			},
+		},
+		{
+			desc:        "malformed field",
+			connStr:     "AccountName=testaccount;dGVzdGtleQ",
+			expectedErr: fmt.Errorf("field 1 is not in key=value format"),
+		},
+		{
+			desc:        "invalid FileEndpoint",
+			connStr:     "AccountName=testaccount;AccountKey=dGVzdGtleQ==;FileEndpoint=testaccount",
+			expectedErr: fmt.Errorf("FileEndpoint is not a valid URL"),
+		},
+		{
+			desc:        "AccountName is missing",
+			connStr:     "AccountKey=dGVzdGtleQ==;EndpointSuffix=core.windows.net",
+			expectedErr: fmt.Errorf("AccountName is missing"),
+		},
+		{
+			desc:        "AccountKey is missing",
+			connStr:     "AccountName=testaccount;EndpointSuffix=core.windows.net",
+			expectedErr: fmt.Errorf("AccountKey is missing"),
+		},
+		{
+			desc:        "SAS is not supported",
+			connStr:     "AccountName=testaccount;SharedAccessSignature=sv=2021-06-08&ss=f&sig=abc",
+			expectedErr: fmt.Errorf("SharedAccessSignature is not supported since mounting file share requires AccountKey"),
+		},
+		{
+			desc:        "AccountKey is not base64 encoded",
+			connStr:     "AccountName=testaccount;AccountKey=not-a-key",
+			expectedErr: fmt.Errorf("AccountKey is not base64 encoded"),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseConnectionString(test.connStr)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+		if err != nil {
+			assert.NotContains(t, err.Error(), "dGVzdGtleQ", test.desc)
+		}
+	}
+}
+
 func TestGetValidFileShareName(t *testing.T) {
 	tests := []struct {
 		volumeName string
@@ -777,6 +884,128 @@ func TestGetAccountInfo(t *testing.T) {
 	}
 }
 
+func TestGetAccountInfoWithNonDefaultSubscription(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	value := base64.StdEncoding.EncodeToString([]byte("acc_key"))
+	key := storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: &value}}}
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.SubscriptionID = "default-subs"
+	d.cloud.ResourceGroup = "default-rg"
+	d.cloud.KubeClient = fake.NewSimpleClientset()
+	defaultClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = defaultClient
+	otherCloud := &azure.Cloud{}
+	otherCloud.SubscriptionID = "other-subs"
+	otherCloud.ResourceGroup = "other-rg"
+	otherClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	otherCloud.StorageAccountClient = otherClient
+	d.cloudConfigCloudMap = map[string]*azure.Cloud{"other": otherCloud}
+
+	// key of account in a subscription without cloud config is fetched by the default identity in that subscription
+	defaultClient.EXPECT().ListKeys(gomock.Any(), "third-subs", "rg", "account1").Return(key, nil).Times(1)
+	rgName, accountName, accountKey, _, _, subsID, err := d.GetAccountInfo(context.Background(), "rg#account1#share####third-subs", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rg", "account1", value, "third-subs"}, []string{rgName, accountName, accountKey, subsID})
+
+	// key of account in the subscription of an additional cloud config is fetched by the identity of that cloud config
+	otherClient.EXPECT().ListKeys(gomock.Any(), "other-subs", "rg", "account2").Return(key, nil).Times(1)
+	rgName, accountName, accountKey, _, _, subsID, err = d.GetAccountInfo(context.Background(), "rg#account2#share####other-subs", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rg", "account2", value, "other-subs"}, []string{rgName, accountName, accountKey, subsID})
+	assert.Same(t, otherCloud, d.getCloudBySubscription(context.Background(), "OTHER-SUBS", "rg", "account3"))
+	assert.Same(t, d.cloud, d.getCloudBySubscription(context.Background(), "default-subs", "rg", "account3"))
+
+	// resource group of the subscription of an additional cloud config is the default
+	otherClient.EXPECT().ListKeys(gomock.Any(), "other-subs", "other-rg", "account4").Return(key, nil).Times(1)
+	rgName, _, _, _, _, _, err = d.GetAccountInfo(context.Background(), "##", nil, map[string]string{storageAccountField: "account4", subscriptionIDField: "other-subs"})
+	assert.NoError(t, err)
+	assert.Equal(t, "other-rg", rgName)
+
+	// resource group of the default cloud config is used in another subscription without cloud config
+	defaultClient.EXPECT().ListKeys(gomock.Any(), "third-subs", "default-rg", "account5").Return(key, nil).Times(1)
+	rgName, _, _, _, _, _, err = d.GetAccountInfo(context.Background(), "##", nil, map[string]string{storageAccountField: "account5", subscriptionIDField: "third-subs"})
+	assert.NoError(t, err)
+	assert.Equal(t, "default-rg", rgName)
+
+	// error of key fetch contains the subscription
+	defaultClient.EXPECT().ListKeys(gomock.Any(), "third-subs", "rg", "account6").Return(storage.AccountListKeysResult{}, &retry.Error{RawError: fmt.Errorf("AuthorizationFailed")}).Times(1)
+	_, _, _, _, _, _, err = d.GetAccountInfo(context.Background(), "rg#account6#share####third-subs", nil, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get key of account(account6) in subscription(third-subs) rg(rg) with cluster identity")
+}
+
+func TestGetAccountInfoStoreAccountKey(t *testing.T) {
+	armKey := base64.StdEncoding.EncodeToString([]byte("arm_key"))
+	keys := storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: &armKey}}}
+	secretName := fmt.Sprintf(secretNameTemplate, "account")
+
+	tests := []struct {
+		desc                   string
+		disableStoreAccountKey bool
+		reqContext             map[string]string
+		expectListKeys         bool
+		expectKey              string
+	}{
+		{
+			desc:      "account key is read from secret by default",
+			expectKey: "secret-key",
+		},
+		{
+			desc:                   "account key is fetched with cluster identity and cached if store-account-key is disabled",
+			disableStoreAccountKey: true,
+			expectListKeys:         true,
+			expectKey:              armKey,
+		},
+		{
+			desc:                   "secret in volume attributes is read if store-account-key is disabled",
+			disableStoreAccountKey: true,
+			reqContext:             map[string]string{secretNameField: secretName},
+			expectKey:              "secret-key",
+		},
+		{
+			desc:                   "secret is read with getAccountKeyFromSecret if store-account-key is disabled",
+			disableStoreAccountKey: true,
+			reqContext:             map[string]string{getAccountKeyFromSecretField: "true"},
+			expectKey:              "secret-key",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			d := NewFakeDriverCustomOptions(DriverOptions{
+				NodeID:                 fakeNodeID,
+				DriverName:             DefaultDriverName,
+				DisableStoreAccountKey: test.disableStoreAccountKey,
+			})
+			d.cloud = &azure.Cloud{}
+			d.cloud.KubeClient = fake.NewSimpleClientset(&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: defaultNamespace},
+				Data:       map[string][]byte{defaultSecretAccountName: []byte("account"), defaultSecretAccountKey: []byte("secret-key")},
+			})
+			mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+			d.cloud.StorageAccountClient = mockStorageAccountsClient
+			if test.expectListKeys {
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "rg", "account").Return(keys, nil).Times(1)
+			}
+
+			// the second call gets account key from cache
+			for i := 0; i < 2; i++ {
+				_, accountName, accountKey, _, _, _, err := d.GetAccountInfo(context.Background(), "rg#account#share", nil, test.reqContext)
+				assert.NoError(t, err)
+				assert.Equal(t, "account", accountName)
+				assert.Equal(t, test.expectKey, accountKey)
+			}
+		})
+	}
+}
+
 func TestCreateDisk(t *testing.T) {
 	skipIfTestingOnWindows(t)
 	d := NewFakeDriver()
@@ -894,6 +1123,104 @@ func TestGetFileShareQuota(t *testing.T) {
 	}
 }
 
+func TestWaitForFileShareReady(t *testing.T) {
+	defer func(interval time.Duration) { shareReadyPollInterval = interval }(shareReadyPollInterval)
+	shareReadyPollInterval = 10 * time.Millisecond
+
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+	shareQuota := int32(10)
+
+	gomock.InOrder(
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).Times(1),
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("test error")).Times(1),
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &shareQuota}}, nil).Times(1),
+	)
+	assert.NoError(t, d.waitForFileShareReady(context.Background(), "subsID", "rg", "account", "share", nil, time.Minute))
+
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).MinTimes(1)
+	err := d.waitForFileShareReady(context.Background(), "subsID", "rg", "account", "share", nil, 50*time.Millisecond)
+	assert.EqualError(t, err, "file share(share) on account(account) rg(rg) is not visible after 50ms")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = d.waitForFileShareReady(ctx, "subsID", "rg", "account", "share", nil, time.Minute)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestGetSMBFallbackVersion(t *testing.T) {
+	tests := []struct {
+		version            string
+		allowedSMBVersions []string
+		expected           string
+	}{
+		{version: "", expected: "3.0"},
+		{version: "3.1.1", expected: "3.0"},
+		{version: "3.0", expected: ""},
+		{version: "3.0", allowedSMBVersions: []string{"3.0", "2.1"}, expected: "2.1"},
+		{version: "3.1.1", allowedSMBVersions: []string{"3.1.1", "2.1"}, expected: "2.1"},
+		{version: "3.1.1", allowedSMBVersions: []string{"3.1.1"}, expected: ""},
+		{version: "3", expected: ""},
+	}
+
+	d := NewFakeDriver()
+	for _, test := range tests {
+		d.allowedSMBVersions = test.allowedSMBVersions
+		assert.Equal(t, test.expected, d.getSMBFallbackVersion(test.version), "version(%s) allowed(%v)", test.version, test.allowedSMBVersions)
+	}
+}
+
+func TestGetHighestAllowedSMBVersion(t *testing.T) {
+	tests := []struct {
+		allowedSMBVersions []string
+		expected           string
+	}{
+		{expected: ""},
+		{allowedSMBVersions: []string{"2.1", "3.0"}, expected: "3.0"},
+		{allowedSMBVersions: []string{"3.0", "3.1.1"}, expected: "3.1.1"},
+		{allowedSMBVersions: []string{"2.1"}, expected: "2.1"},
+	}
+
+	d := NewFakeDriver()
+	for _, test := range tests {
+		d.allowedSMBVersions = test.allowedSMBVersions
+		assert.Equal(t, test.expected, d.getHighestAllowedSMBVersion(), "allowed(%v)", test.allowedSMBVersions)
+	}
+}
+
+func TestSelectSMBVersion(t *testing.T) {
+	tests := []struct {
+		sku                string
+		requireEncryption  bool
+		allowedSMBVersions []string
+		expected           string
+	}{
+		{sku: "", expected: "3.1.1"},
+		{sku: "Standard_LRS", expected: "3.1.1"},
+		{sku: "Premium_LRS", expected: "3.1.1"},
+		{sku: "Standard_LRS", allowedSMBVersions: []string{"3.0", "2.1"}, expected: "3.0"},
+		{sku: "Standard_LRS", allowedSMBVersions: []string{"2.1"}, expected: "2.1"},
+		{sku: "Standard_LRS", requireEncryption: true, allowedSMBVersions: []string{"2.1"}, expected: ""},
+		{sku: "premium_zrs", allowedSMBVersions: []string{"2.1"}, expected: ""},
+		{sku: "Premium_LRS", allowedSMBVersions: []string{"3.0", "2.1"}, expected: "3.0"},
+	}
+
+	d := NewFakeDriver()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+	for _, test := range tests {
+		d.allowedSMBVersions = test.allowedSMBVersions
+		assert.Equal(t, test.expected, d.selectSMBVersion(test.sku, test.requireEncryption), "sku(%s) requireEncryption(%v) allowed(%v)", test.sku, test.requireEncryption, test.allowedSMBVersions)
+	}
+}
+
 func TestRun(t *testing.T) {
 	fakeCredFile := "fake-cred-file.json"
 	fakeCredContent := `{
@@ -999,6 +1326,39 @@ func TestIsSupportedProtocol(t *testing.T) {
 	}
 }
 
+func TestIsGeoRedundantSku(t *testing.T) {
+	tests := []struct {
+		sku            string
+		expectedResult bool
+	}{
+		{sku: "", expectedResult: false},
+		{sku: "Standard_LRS", expectedResult: false},
+		{sku: "premium_zrs", expectedResult: false},
+		{sku: "Standard_GRS", expectedResult: true},
+		{sku: "Standard_RAGRS", expectedResult: true},
+		{sku: "standard_gzrs", expectedResult: true},
+		{sku: "Standard_RAGZRS", expectedResult: true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectedResult, isGeoRedundantSku(test.sku), test.sku)
+	}
+}
+
+func TestInvalidateAccountKey(t *testing.T) {
+	d := NewFakeDriver()
+	d.accountCacheMap.Set("account", "key")
+	d.invalidateAccountKey("account", fmt.Errorf("StatusCode=409"))
+	cache, err := d.accountCacheMap.Get("account", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", cache)
+
+	d.invalidateAccountKey("account", fmt.Errorf("ServiceCode=AuthenticationFailed"))
+	cache, err = d.accountCacheMap.Get("account", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Nil(t, cache)
+}
+
 func TestIsSupportedShareAccessTier(t *testing.T) {
 	tests := []struct {
 		accessTier     string
@@ -1128,6 +1488,157 @@ func TestIsSupportedRootSquashType(t *testing.T) {
 	}
 }
 
+func TestIsValidServerAddress(t *testing.T) {
+	tests := []struct {
+		server         string
+		expectedResult bool
+	}{
+		{server: "", expectedResult: true},
+		{server: "account.privatelink.file.core.windows.net", expectedResult: true},
+		{server: "Account.File.Core.Windows.Net", expectedResult: true},
+		{server: "10.0.0.4", expectedResult: true},
+		{server: "test_servername", expectedResult: true},
+		{server: "-account.file.core.windows.net", expectedResult: false},
+		{server: "account..file.core.windows.net", expectedResult: false},
+		{server: "fe80::1", expectedResult: false},
+		{server: "account.file.core.windows.net/share", expectedResult: false},
+		{server: "account.file.core.windows.net:445", expectedResult: false},
+		{server: "//account.file.core.windows.net", expectedResult: false},
+		{server: "account file", expectedResult: false},
+	}
+
+	for _, test := range tests {
+		result := isValidServerAddress(test.server)
+		if result != test.expectedResult {
+			t.Errorf("isValidServerAddress(%s) returned with %v, not equal to %v", test.server, result, test.expectedResult)
+		}
+	}
+}
+
+func TestParseFallbackServers(t *testing.T) {
+	tests := []struct {
+		value           string
+		expectedServers []string
+		expectedErr     error
+	}{
+		{value: "", expectedServers: nil},
+		{value: "10.0.0.4", expectedServers: []string{"10.0.0.4"}},
+		{value: " account.privatelink.file.core.windows.net, 10.0.0.4,,", expectedServers: []string{"account.privatelink.file.core.windows.net", "10.0.0.4"}},
+		{value: "10.0.0.4,account.file.core.windows.net,Account.File.Core.Windows.Net", expectedServers: []string{"10.0.0.4", "account.file.core.windows.net"}},
+		{value: "10.0.0.4,account.file.core.windows.net:445", expectedErr: fmt.Errorf("invalid fallbackservers: account.file.core.windows.net:445, only IPv4 address or DNS name is supported")},
+	}
+
+	for _, test := range tests {
+		servers, err := parseFallbackServers(test.value)
+		assert.Equal(t, test.expectedErr, err, test.value)
+		assert.Equal(t, test.expectedServers, servers, test.value)
+	}
+}
+
+func TestValidateStorageAccountName(t *testing.T) {
+	tests := []struct {
+		name        string
+		expectedErr error
+	}{
+		{name: "abc"},
+		{name: "account0123456789abcdefg"},
+		{name: "ab", expectedErr: fmt.Errorf("length(2) must be between 3 and 24 characters")},
+		{name: "account0123456789abcdefgh", expectedErr: fmt.Errorf("length(25) must be between 3 and 24 characters")},
+		{name: "Account", expectedErr: fmt.Errorf("character 'A' at position 0 is not allowed, only lowercase letters and numbers are allowed")},
+		{name: "my-account", expectedErr: fmt.Errorf("character '-' at position 2 is not allowed, only lowercase letters and numbers are allowed")},
+		{name: "my_account", expectedErr: fmt.Errorf("character '_' at position 2 is not allowed, only lowercase letters and numbers are allowed")},
+	}
+
+	for _, test := range tests {
+		err := validateStorageAccountName(test.name)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("validateStorageAccountName(%s) returned with %v, not equal to %v", test.name, err, test.expectedErr)
+		}
+	}
+}
+
+// storage account name is generated by cloud provider as prefix followed by a uuid without hyphens,
+// truncated to 23 characters, so it's always valid as long as the prefix is lowercase alphanumeric
+func TestDefaultAccountNamePrefix(t *testing.T) {
+	uuid := strings.Repeat("f", 32)
+	name := (defaultAccountNamePrefix + uuid)[:storageAccountNameMaxLength-1]
+	assert.NoError(t, validateStorageAccountName(name))
+}
+
+func TestGetMaximumShareSize(t *testing.T) {
+	tests := []struct {
+		accountKind    string
+		enableLFS      *bool
+		expectedResult int
+	}{
+		{
+			accountKind:    string(storage.KindFileStorage),
+			expectedResult: maximumShareSize,
+		},
+		{
+			accountKind:    string(storage.KindFileStorage),
+			enableLFS:      pointer.Bool(false),
+			expectedResult: maximumShareSize,
+		},
+		{
+			accountKind:    string(storage.KindStorageV2),
+			expectedResult: maximumShareSize,
+		},
+		{
+			accountKind:    string(storage.KindStorageV2),
+			enableLFS:      pointer.Bool(false),
+			expectedResult: maximumStandardShareSizeNoLFS,
+		},
+	}
+
+	for _, test := range tests {
+		result := getMaximumShareSize(test.accountKind, test.enableLFS)
+		if result != test.expectedResult {
+			t.Errorf("getMaximumShareSize(%s, %v) returned with %v, not equal to %v", test.accountKind, test.enableLFS, result, test.expectedResult)
+		}
+	}
+}
+
+func TestGetRoutingPreference(t *testing.T) {
+	tests := []struct {
+		routingChoice             string
+		publishMicrosoftEndpoints *bool
+		publishInternetEndpoints  *bool
+		expected                  *storage.RoutingPreference
+		expectedErr               error
+	}{
+		{},
+		{
+			routingChoice: "internetrouting",
+			expected:      &storage.RoutingPreference{RoutingChoice: storage.RoutingChoiceInternetRouting},
+		},
+		{
+			routingChoice:             "MicrosoftRouting",
+			publishMicrosoftEndpoints: pointer.Bool(true),
+			publishInternetEndpoints:  pointer.Bool(false),
+			expected: &storage.RoutingPreference{
+				RoutingChoice:             storage.RoutingChoiceMicrosoftRouting,
+				PublishMicrosoftEndpoints: pointer.Bool(true),
+				PublishInternetEndpoints:  pointer.Bool(false),
+			},
+		},
+		{
+			publishInternetEndpoints: pointer.Bool(true),
+			expected:                 &storage.RoutingPreference{PublishInternetEndpoints: pointer.Bool(true)},
+		},
+		{
+			routingChoice: "AzureRouting",
+			expectedErr:   fmt.Errorf("routingpreference(AzureRouting) is not supported, supported routingpreference list: [InternetRouting MicrosoftRouting]"),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := getRoutingPreference(test.routingChoice, test.publishMicrosoftEndpoints, test.publishInternetEndpoints)
+		assert.Equal(t, test.expectedErr, err, test.routingChoice)
+		assert.Equal(t, test.expected, result, test.routingChoice)
+	}
+}
+
 func TestGetSubnetResourceID(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -1208,3 +1719,288 @@ func TestGetSubnetResourceID(t *testing.T) {
 		t.Run(tc.name, tc.testFunc)
 	}
 }
+
+func TestGetCloud(t *testing.T) {
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{
+			name: "return default cloud when clientID is empty",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				cloud, err := d.getCloudByClientID("")
+				assert.NoError(t, err)
+				assert.Equal(t, d.cloud, cloud)
+			},
+		},
+		{
+			name: "return default cloud when no identity is bound to account",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				assert.Equal(t, d.cloud, d.getCloud("account"))
+			},
+		},
+		{
+			name: "return cached cloud with user-assigned identity",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				cloud, err := d.getCloudByClientID("clientID")
+				assert.NoError(t, err)
+				assert.True(t, cloud.UseManagedIdentityExtension)
+				assert.Equal(t, "clientID", cloud.UserAssignedIdentityID)
+				assert.Equal(t, d.cloud.SubscriptionID, cloud.SubscriptionID)
+
+				ctx := withClientID(context.Background(), "clientID")
+				assert.Same(t, cloud, d.getCloudByAccount(ctx, d.cloud.SubscriptionID, d.cloud.ResourceGroup, "account"))
+				assert.Same(t, cloud, d.getCloudByAccount(ctx, "", "", "otheraccount"))
+				assert.Equal(t, d.cloud, d.getCloudByAccount(context.Background(), "", "", "account"))
+				assert.Equal(t, d.cloud, d.getCloudByAccount(withClientID(ctx, ""), "", "", "account"))
+				assert.Equal(t, d.cloud, d.getCloud("account"))
+			},
+		},
+		{
+			name: "resolve user-assigned identity from volume handle",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				cloud, err := d.getCloudByClientID("clientID")
+				assert.NoError(t, err)
+
+				h := &volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", clientID: "clientID"}
+				volumeID := h.String()
+				assert.Same(t, cloud, d.getCloudByAccount(withVolumeClientID(context.Background(), volumeID), "", "rg", "account"))
+				assert.Equal(t, d.cloud, d.getCloudByAccount(withVolumeClientID(context.Background(), "rg#account#share"), "", "rg", "account"))
+				assert.Equal(t, d.cloud, d.getCloudByAccount(withVolumeClientID(context.Background(), "invalid"), "", "rg", "account"))
+			},
+		},
+		{
+			name: "return cloud of additional cloud config bound to account",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				chinaCloud := &azure.Cloud{}
+				d.cloudConfigCloudMap = map[string]*azure.Cloud{"china": chinaCloud}
+
+				cloud, err := d.getCloudByConfigName("")
+				assert.NoError(t, err)
+				assert.Equal(t, d.cloud, cloud)
+				cloud, err = d.getCloudByConfigName("china")
+				assert.NoError(t, err)
+				assert.Same(t, chinaCloud, cloud)
+				_, err = d.getCloudByConfigName("germany")
+				assert.Equal(t, fmt.Errorf("cloud config(germany) is not found, configured cloud configs: [china]"), err)
+
+				assert.NoError(t, d.bindAccountToCloudConfig("", "account"))
+				assert.Equal(t, d.cloud, d.getCloud("account"))
+				assert.Error(t, d.bindAccountToCloudConfig("germany", "account"))
+				assert.Equal(t, d.cloud, d.getCloud("account"))
+				assert.NoError(t, d.bindAccountToCloudConfig("china", "account"))
+				assert.Same(t, chinaCloud, d.getCloud("account"))
+				assert.Equal(t, d.cloud, d.getCloud("otheraccount"))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, tc.testFunc)
+	}
+}
+
+func TestRetryOnAccountNotPropagated(t *testing.T) {
+	originalBackoff := accountPropagationBackoff
+	defer func() { accountPropagationBackoff = originalBackoff }()
+	accountPropagationBackoff = wait.Backoff{Steps: 3, Duration: time.Millisecond, Factor: 1.0}
+
+	notFoundErr := fmt.Errorf("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 404, RawError: ResourceNotFound")
+	forbiddenErr := fmt.Errorf("storage: service returned error: StatusCode=403, ErrorCode=AuthorizationFailure")
+	tests := []struct {
+		desc          string
+		errs          []error
+		expectedCalls int
+		expectedErr   error
+	}{
+		{
+			desc:          "operation succeeds after not found error",
+			errs:          []error{notFoundErr, nil},
+			expectedCalls: 2,
+		},
+		{
+			desc:          "operation succeeds after forbidden error",
+			errs:          []error{forbiddenErr, notFoundErr, nil},
+			expectedCalls: 3,
+		},
+		{
+			desc:          "other error is not retried",
+			errs:          []error{fmt.Errorf("test error")},
+			expectedCalls: 1,
+			expectedErr:   fmt.Errorf("test error"),
+		},
+		{
+			desc:          "retries exhausted",
+			errs:          []error{notFoundErr, notFoundErr, notFoundErr},
+			expectedCalls: 3,
+			expectedErr:   fmt.Errorf("create failed after 3 attempts: %w", notFoundErr),
+		},
+	}
+	for _, test := range tests {
+		calls := 0
+		err := retryOnAccountNotPropagated(context.Background(), "create", func() error {
+			err := test.errs[calls]
+			calls++
+			return err
+		})
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedCalls, calls, test.desc)
+	}
+}
+
+func TestIsBlobPublicAccessAllowed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	tests := []struct {
+		desc            string
+		account         storage.Account
+		rerr            *retry.Error
+		expectedAllowed bool
+		expectedErr     error
+	}{
+		{
+			desc:            "blob public access is disabled",
+			account:         storage.Account{AccountProperties: &storage.AccountProperties{AllowBlobPublicAccess: pointer.Bool(false)}},
+			expectedAllowed: false,
+		},
+		{
+			desc:            "blob public access is enabled",
+			account:         storage.Account{AccountProperties: &storage.AccountProperties{AllowBlobPublicAccess: pointer.Bool(true)}},
+			expectedAllowed: true,
+		},
+		{
+			desc:            "blob public access is not set",
+			account:         storage.Account{AccountProperties: &storage.AccountProperties{}},
+			expectedAllowed: true,
+		},
+		{
+			desc:        "GetProperties fails",
+			rerr:        &retry.Error{HTTPStatusCode: http.StatusForbidden, RawError: fmt.Errorf("AuthorizationFailed")},
+			expectedErr: fmt.Errorf("failed to get storage account(account) in resource group(rg): Retriable: false, RetryAfter: 0s, HTTPStatusCode: 403, RawError: AuthorizationFailed"),
+		},
+	}
+
+	for _, test := range tests {
+		mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(test.account, test.rerr).Times(1)
+		allowed, err := d.isBlobPublicAccessAllowed(context.Background(), "subsID", "rg", "account")
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedAllowed, allowed, test.desc)
+	}
+}
+
+func TestResolveSecretNamespace(t *testing.T) {
+	d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, DefaultSecretNamespace: "kube-system"})
+	tests := []struct {
+		desc            string
+		secretNamespace string
+		pvcNamespace    string
+		expectNamespace string
+		expectSource    string
+	}{
+		{
+			desc:            "explicit secretNamespace takes precedence over PVC namespace",
+			secretNamespace: "secret-ns",
+			pvcNamespace:    "pvc-ns",
+			expectNamespace: "secret-ns",
+			expectSource:    secretNamespaceField,
+		},
+		{
+			desc:            "PVC namespace takes precedence over driver default",
+			pvcNamespace:    "pvc-ns",
+			expectNamespace: "pvc-ns",
+			expectSource:    "PVC namespace",
+		},
+		{
+			desc:            "driver default namespace",
+			expectNamespace: "kube-system",
+			expectSource:    "driver default namespace",
+		},
+	}
+
+	for _, test := range tests {
+		namespace, source := d.resolveSecretNamespace(test.secretNamespace, test.pvcNamespace)
+		assert.Equal(t, test.expectNamespace, namespace, test.desc)
+		assert.Equal(t, test.expectSource, source, test.desc)
+	}
+
+	d = NewFakeDriver()
+	namespace, _ := d.resolveSecretNamespace("", "")
+	assert.Equal(t, defaultNamespace, namespace)
+}
+
+func TestGetAccountInfoSecretNamespace(t *testing.T) {
+	d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, DefaultSecretNamespace: "kube-system"})
+	d.cloud = &azure.Cloud{}
+	secretName := fmt.Sprintf(secretNameTemplate, "account")
+	d.cloud.KubeClient = fake.NewSimpleClientset(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "secret-ns"},
+			Data:       map[string][]byte{defaultSecretAccountName: []byte("account"), defaultSecretAccountKey: []byte("secret-ns-key")},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "pvc-ns"},
+			Data:       map[string][]byte{defaultSecretAccountName: []byte("account"), defaultSecretAccountKey: []byte("pvc-ns-key")},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "kube-system"},
+			Data:       map[string][]byte{defaultSecretAccountName: []byte("account"), defaultSecretAccountKey: []byte("kube-system-key")},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "no-key-ns"},
+			Data:       map[string][]byte{defaultSecretAccountName: []byte("account")},
+		},
+	)
+
+	tests := []struct {
+		desc        string
+		reqContext  map[string]string
+		expectKey   string
+		expectedErr error
+	}{
+		{
+			desc:       "secret in explicit secretNamespace",
+			reqContext: map[string]string{secretNamespaceField: "secret-ns", pvcNamespaceKey: "pvc-ns"},
+			expectKey:  "secret-ns-key",
+		},
+		{
+			desc:       "secret in PVC namespace",
+			reqContext: map[string]string{pvcNamespaceKey: "pvc-ns"},
+			expectKey:  "pvc-ns-key",
+		},
+		{
+			desc:      "secret in driver default namespace",
+			expectKey: "kube-system-key",
+		},
+		{
+			desc:        "secret not found in explicit secretNamespace",
+			reqContext:  map[string]string{secretNamespaceField: "other-ns"},
+			expectedErr: fmt.Errorf("could not get secret(%s) in namespace(other-ns): secrets \"%s\" not found, namespace is resolved from secretnamespace", secretName, secretName),
+		},
+		{
+			desc:        "secret not found in PVC namespace",
+			reqContext:  map[string]string{pvcNamespaceKey: "other-ns"},
+			expectedErr: fmt.Errorf("could not get secret(%s) in namespace(other-ns): secrets \"%s\" not found, namespace is resolved from PVC namespace", secretName, secretName),
+		},
+		{
+			desc:        "secret without account key",
+			reqContext:  map[string]string{secretNamespaceField: "no-key-ns"},
+			expectedErr: fmt.Errorf("secret(%s) in namespace(no-key-ns) does not contain azurestorageaccountkey, namespace is resolved from secretnamespace", secretName),
+		},
+	}
+
+	for _, test := range tests {
+		_, _, accountKey, _, _, _, err := d.GetAccountInfo(context.Background(), "rg#account#share", nil, test.reqContext)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectKey, accountKey, test.desc)
+		assert.NoError(t, d.accountCacheMap.Delete("account"))
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/volume/util"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// blobNFS mounts an existing blob container of a blob+NFS account over NFS 3.0, blob containers are
+	// provisioned by Azure Blob CSI driver(blob.csi.azure.com), this driver only mounts them
+	blobNFS            = "blobnfs"
+	blobNFSVersion     = "3"
+	blobNFSMountOption = "vers=3,sec=sys,nolock"
+)
+
+// isNFSProtocol returns true if the volume is mounted over NFS, either Azure Files NFS or blob NFS
+func isNFSProtocol(protocol string) bool {
+	return protocol == nfs || protocol == blobNFS
+}
+
+// getDefaultServerAddress returns the default server address of the account, blob NFS is served by blob endpoint of the account
+func getDefaultServerAddress(protocol, accountName, storageEndpointSuffix string) string {
+	if protocol == blobNFS {
+		return fmt.Sprintf("%s.blob.%s", accountName, storageEndpointSuffix)
+	}
+	return fmt.Sprintf("%s.file.%s", accountName, storageEndpointSuffix)
+}
+
+// getBlobNFSMountOptions returns mount options of blob NFS mount, only NFS 3.0 is supported by blob NFS endpoint
+func getBlobNFSMountOptions(mountFlags []string) ([]string, error) {
+	var mountOptions []string
+	for _, mountFlag := range mountFlags {
+		for _, option := range strings.Split(mountFlag, ",") {
+			if isOwnerMountOption(option) {
+				return nil, getNFSOwnerMountOptionError(option)
+			}
+			kv := strings.SplitN(strings.TrimSpace(option), "=", 2)
+			if len(kv) == 2 && (strings.EqualFold(kv[0], vers) || strings.EqualFold(kv[0], nfsvers)) {
+				if version := strings.TrimSpace(kv[1]); version != blobNFSVersion {
+					return nil, fmt.Errorf("nfs version(%s) is not supported by blob NFS, only nfs version(%s) is supported", version, blobNFSVersion)
+				}
+				continue
+			}
+			if option != "" {
+				mountOptions = append(mountOptions, option)
+			}
+		}
+	}
+	return util.JoinMountOptions(mountOptions, []string{blobNFSMountOption}), nil
+}
+
+// validateBlobNFSAccount returns error if the account could not serve blob NFS,
+// which requires hierarchical namespace and NFS 3.0 enabled on a StorageV2 or BlockBlobStorage account
+func validateBlobNFSAccount(account storage.Account) error {
+	if account.Kind != storage.KindStorageV2 && account.Kind != storage.KindBlockBlobStorage {
+		return fmt.Errorf("account kind(%s) does not support blob NFS, %s or %s account is required", account.Kind, storage.KindStorageV2, storage.KindBlockBlobStorage)
+	}
+	if account.AccountProperties == nil || !pointer.BoolDeref(account.IsHnsEnabled, false) {
+		return fmt.Errorf("hierarchical namespace is not enabled")
+	}
+	if !pointer.BoolDeref(account.EnableNfsV3, false) {
+		return fmt.Errorf("NFS 3.0 is not enabled")
+	}
+	return nil
+}
+
+// checkBlobNFSAccount checks the account is configured for blob NFS, the check is skipped if account
+// properties could not be read, e.g. node identity is not granted to read the account, then mount tells the result
+func (d *Driver) checkBlobNFSAccount(ctx context.Context, subsID, resourceGroup, accountName string) error {
+	cloud := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	if cloud.StorageAccountClient == nil {
+		return nil
+	}
+	if resourceGroup == "" {
+		resourceGroup = cloud.ResourceGroup
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		klog.Warningf("skip checking blob NFS configuration of account(%s) rg(%s) since GetProperties failed: %v", accountName, resourceGroup, rerr.Error())
+		return nil
+	}
+	if err := validateBlobNFSAccount(account); err != nil {
+		return fmt.Errorf("storage account(%s) is not configured for blob NFS: %v", accountName, err)
+	}
+	return nil
+}
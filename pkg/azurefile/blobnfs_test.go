@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestGetDefaultServerAddress(t *testing.T) {
+	assert.Equal(t, "account.file.core.windows.net", getDefaultServerAddress(smb, "account", "core.windows.net"))
+	assert.Equal(t, "account.file.core.windows.net", getDefaultServerAddress(nfs, "account", "core.windows.net"))
+	assert.Equal(t, "account.blob.core.chinacloudapi.cn", getDefaultServerAddress(blobNFS, "account", "core.chinacloudapi.cn"))
+}
+
+func TestGetBlobNFSMountOptions(t *testing.T) {
+	tests := []struct {
+		mountFlags           []string
+		expectedMountOptions []string
+		expectedErr          error
+	}{
+		{
+			mountFlags:           nil,
+			expectedMountOptions: []string{"vers=3,sec=sys,nolock"},
+		},
+		{
+			mountFlags:           []string{"nconnect=4,vers=3", "actimeo=30"},
+			expectedMountOptions: []string{"actimeo=30", "nconnect=4", "vers=3,sec=sys,nolock"},
+		},
+		{
+			mountFlags:  []string{"nfsvers=4.1"},
+			expectedErr: fmt.Errorf("nfs version(4.1) is not supported by blob NFS, only nfs version(3) is supported"),
+		},
+		{
+			mountFlags:  []string{"forcegid"},
+			expectedErr: fmt.Errorf("mount option(forcegid) is not supported by NFS since file ownership is stored on the server, set fsGroup in pod security context or change ownership of files in the volume instead"),
+		},
+	}
+
+	for _, test := range tests {
+		mountOptions, err := getBlobNFSMountOptions(test.mountFlags)
+		assert.Equal(t, test.expectedErr, err)
+		assert.Equal(t, test.expectedMountOptions, mountOptions)
+	}
+}
+
+func TestCheckBlobNFSAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	tests := []struct {
+		desc        string
+		account     storage.Account
+		rerr        *retry.Error
+		expectedErr error
+	}{
+		{
+			desc: "blob NFS account",
+			account: storage.Account{Kind: storage.KindBlockBlobStorage, AccountProperties: &storage.AccountProperties{
+				IsHnsEnabled: pointer.Bool(true), EnableNfsV3: pointer.Bool(true)}},
+		},
+		{
+			desc:        "file storage account",
+			account:     storage.Account{Kind: storage.KindFileStorage, AccountProperties: &storage.AccountProperties{}},
+			expectedErr: fmt.Errorf("storage account(account) is not configured for blob NFS: account kind(FileStorage) does not support blob NFS, StorageV2 or BlockBlobStorage account is required"),
+		},
+		{
+			desc:        "hierarchical namespace disabled",
+			account:     storage.Account{Kind: storage.KindStorageV2, AccountProperties: &storage.AccountProperties{EnableNfsV3: pointer.Bool(true)}},
+			expectedErr: fmt.Errorf("storage account(account) is not configured for blob NFS: hierarchical namespace is not enabled"),
+		},
+		{
+			desc:        "NFS 3.0 disabled",
+			account:     storage.Account{Kind: storage.KindStorageV2, AccountProperties: &storage.AccountProperties{IsHnsEnabled: pointer.Bool(true)}},
+			expectedErr: fmt.Errorf("storage account(account) is not configured for blob NFS: NFS 3.0 is not enabled"),
+		},
+		{
+			desc: "check is skipped if account properties could not be read",
+			rerr: &retry.Error{HTTPStatusCode: http.StatusForbidden, RawError: fmt.Errorf("AuthorizationFailed")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(test.account, test.rerr).Times(1)
+			err := d.checkBlobNFSAccount(context.Background(), "subsID", "rg", "account")
+			assert.Equal(t, test.expectedErr, err)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	k8smetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// tag of the total provisioned capacity(in GiB) of all file shares on the storage account
+	provisionedGiBTag = "csi-provisioned-gib"
+	// tag of the sku of the storage account
+	skuTag = "csi-sku"
+
+	capacityTagsOperationCreate = "create_volume"
+	capacityTagsOperationExpand = "expand_volume"
+	capacityTagsOperationDelete = "delete_volume"
+
+	// volume operations on one storage account within the delay are batched into one refresh of capacity tags
+	defaultCapacityTagsDelay = 30 * time.Second
+	// timeout of one refresh of capacity tags
+	capacityTagsRefreshTimeout = 2 * time.Minute
+)
+
+var (
+	capacityTagsUpdateFailureTotal = k8smetrics.NewCounterVec(
+		&k8smetrics.CounterOpts{
+			Subsystem:      azureFileCSIDriverName,
+			Name:           "capacity_tags_update_failure_total",
+			Help:           "Number of failed updates of capacity tracking tags on storage accounts, labeled by operation",
+			StabilityLevel: k8smetrics.ALPHA,
+		},
+		[]string{"operation"},
+	)
+	registerCapacityTagsMetricsOnce sync.Once
+)
+
+func registerCapacityTagsMetrics() {
+	registerCapacityTagsMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(capacityTagsUpdateFailureTotal)
+	})
+}
+
+// updateCapacityTags schedules a refresh of the provisioned capacity and sku tags on the storage account after a file
+// share is created, expanded or deleted if --enable-capacity-tags is set, the refresh runs in background after
+// capacityTagsDelay so that it does not delay the volume operation, and operations on the same account within the
+// delay are batched into one refresh
+func (d *Driver) updateCapacityTags(ctx context.Context, operation, subsID, resourceGroup, accountName string) {
+	if !d.enableCapacityTags {
+		return
+	}
+	key := strings.Join([]string{subsID, resourceGroup, accountName}, "/")
+	if _, pending := d.capacityTagsPending.LoadOrStore(key, struct{}{}); pending {
+		klog.V(4).Infof("capacity tags refresh of account(%s) in resource group(%s) is already pending, batch %s", accountName, resourceGroup, operation)
+		return
+	}
+	// the user-assigned identity of the volume is used to refresh tags of the account
+	clientID, _ := ctx.Value(volumeClientIDKey{}).(string)
+	go func() {
+		time.Sleep(d.capacityTagsDelay)
+		// operations after this point schedule another refresh
+		d.capacityTagsPending.Delete(key)
+		ctx, cancel := context.WithTimeout(withClientID(context.Background(), clientID), capacityTagsRefreshTimeout)
+		defer cancel()
+		d.refreshCapacityTags(ctx, operation, subsID, resourceGroup, accountName)
+	}()
+}
+
+// refreshCapacityTags sets capacity tags on the storage account, the update is best-effort, a failure is logged and
+// counted in metrics
+func (d *Driver) refreshCapacityTags(ctx context.Context, operation, subsID, resourceGroup, accountName string) {
+	if err := d.setCapacityTags(ctx, subsID, resourceGroup, accountName); err != nil {
+		klog.Warningf("failed to update capacity tags on account(%s) in resource group(%s) after %s: %v", accountName, resourceGroup, operation, err)
+		capacityTagsUpdateFailureTotal.WithLabelValues(operation).Inc()
+	}
+}
+
+// setCapacityTags tags the storage account with the sum of quota of all file shares on the account, so that shares
+// sharing one account are counted together, only account level tags are set since file shares have no tags
+func (d *Driver) setCapacityTags(ctx context.Context, subsID, resourceGroup, accountName string) error {
+	cloud := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	if cloud.StorageAccountClient == nil || cloud.FileClient == nil {
+		return fmt.Errorf("StorageAccountClient or FileClient is nil")
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get account: %v", rerr.Error())
+	}
+	shares, err := cloud.FileClient.WithSubscriptionID(subsID).ListFileShare(ctx, resourceGroup, accountName, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to list file shares: %v", err)
+	}
+	var provisionedGiB int64
+	for _, share := range shares {
+		if share.FileShareProperties == nil || pointer.BoolDeref(share.Deleted, false) {
+			continue
+		}
+		provisionedGiB += int64(pointer.Int32Deref(share.ShareQuota, 0))
+	}
+
+	tags := map[string]string{provisionedGiBTag: strconv.FormatInt(provisionedGiB, 10)}
+	if account.Sku != nil {
+		tags[skuTag] = string(account.Sku.Name)
+	}
+	newTags := getTagsToUpdate(account.Tags, tags)
+	if len(newTags) == 0 {
+		return nil
+	}
+	klog.V(2).Infof("update capacity tags(%s=%d) on account(%s) in resource group(%s)", provisionedGiBTag, provisionedGiB, accountName, resourceGroup)
+	return d.addStorageAccountTags(ctx, subsID, resourceGroup, accountName, newTags)
+}
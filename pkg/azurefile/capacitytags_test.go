@@ -0,0 +1,110 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestUpdateCapacityTags(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+
+	// capacity tags are disabled by default
+	d.updateCapacityTags(context.Background(), capacityTagsOperationCreate, "subsID", "rg", "account")
+
+	d.enableCapacityTags = true
+	account := storage.Account{Sku: &storage.Sku{Name: storage.SkuNameStandardLRS}, Tags: map[string]*string{skuTag: pointer.String("Standard_LRS")}}
+	shares := []storage.FileShareItem{
+		{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}},
+		{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(50)}},
+		{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(10), Deleted: pointer.Bool(true)}},
+	}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(account, nil).Times(2)
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", "").Return(shares, nil)
+	mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "subsID", "rg", "account", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, subsID, resourceGroup, accountName string, parameters storage.AccountUpdateParameters) *retry.Error {
+			assert.Equal(t, "150", pointer.StringDeref(parameters.Tags[provisionedGiBTag], ""))
+			assert.Equal(t, "Standard_LRS", pointer.StringDeref(parameters.Tags[skuTag], ""))
+			return nil
+		})
+	d.refreshCapacityTags(context.Background(), capacityTagsOperationCreate, "subsID", "rg", "account")
+
+	// failure is not fatal and counted in metrics
+	before := getCounterValue(t, "capacity_tags_update_failure_total", "operation", capacityTagsOperationExpand)
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(storage.Account{}, &retry.Error{RawError: fmt.Errorf("test error")})
+	d.refreshCapacityTags(context.Background(), capacityTagsOperationExpand, "subsID", "rg", "account")
+	assert.Equal(t, before+1, getCounterValue(t, "capacity_tags_update_failure_total", "operation", capacityTagsOperationExpand))
+}
+
+func TestUpdateCapacityTagsBatched(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.enableCapacityTags = true
+	d.capacityTagsDelay = 100 * time.Millisecond
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+
+	// create, expand and delete on one account within the delay are batched into one refresh
+	shares := []storage.FileShareItem{
+		{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}},
+	}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(storage.Account{}, nil).Times(2)
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", "").Return(shares, nil).Times(1)
+	refreshed := make(chan struct{})
+	mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "subsID", "rg", "account", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, subsID, resourceGroup, accountName string, parameters storage.AccountUpdateParameters) *retry.Error {
+			assert.Equal(t, "100", pointer.StringDeref(parameters.Tags[provisionedGiBTag], ""))
+			close(refreshed)
+			return nil
+		}).Times(1)
+	d.updateCapacityTags(context.Background(), capacityTagsOperationCreate, "subsID", "rg", "account")
+	d.updateCapacityTags(context.Background(), capacityTagsOperationExpand, "subsID", "rg", "account")
+	d.updateCapacityTags(context.Background(), capacityTagsOperationDelete, "subsID", "rg", "account")
+
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("capacity tags are not refreshed")
+	}
+	_, pending := d.capacityTagsPending.Load("subsID/rg/account")
+	assert.False(t, pending)
+}
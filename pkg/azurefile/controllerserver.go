@@ -19,6 +19,7 @@ package azurefile
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -26,6 +27,7 @@ import (
 
 	volumehelper "sigs.k8s.io/azurefile-csi-driver/pkg/util"
 
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	"github.com/Azure/azure-storage-file-go/azfile"
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -98,8 +100,8 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	capacityBytes := req.GetCapacityRange().GetRequiredBytes()
 	requestGiB := volumehelper.RoundUpGiB(capacityBytes)
 	if requestGiB == 0 {
-		requestGiB = defaultAzureFileQuota
-		klog.Warningf("no quota specified, set as default value(%d GiB)", defaultAzureFileQuota)
+		requestGiB = int64(d.defaultShareQuotaGiB)
+		klog.Warningf("no quota specified, set as default value(%d GiB)", requestGiB)
 	}
 
 	if acquired := d.volumeLocks.TryAcquire(volName); !acquired {
@@ -112,16 +114,25 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		parameters = make(map[string]string)
 	}
 	var sku, subsID, resourceGroup, location, account, fileShareName, diskName, fsType, secretName string
-	var secretNamespace, pvcNamespace, protocol, customTags, storageEndpointSuffix, networkEndpointType, shareAccessTier, accountAccessTier, rootSquashType string
-	var createAccount, useDataPlaneAPI, useSeretCache, matchTags bool
-	var vnetResourceGroup, vnetName, subnetName, shareNamePrefix, fsGroupChangePolicy string
-	var requireInfraEncryption, disableDeleteRetentionPolicy, enableLFS *bool
-	// set allowBlobPublicAccess as false by default
-	allowBlobPublicAccess := pointer.Bool(false)
+	var secretNamespace, pvcNamespace, protocol, customTags, storageEndpointSuffix, networkEndpointType, shareAccessTier, accountAccessTier, rootSquashType, sourceAddress, mountTenant string
+	var createAccount, useDataPlaneAPI, useSeretCache, matchTags, onDeleteRename, unmanagedQuota, encryptInTransit, enableCompression, disableSMBLeases bool
+	enableMfsymlinks := true
+	var forceCloseHandlesOnDelete *bool
+	var maxShareQuotaGiB, minShareQuotaGiB int
+	var vnetResourceGroup, privateEndpointResourceGroup, vnetName, subnetName, shareNamePrefix, fsGroupChangePolicy, clientID, pvcName, routingChoice, cloudConfigName, dataPlaneAuthType, folderName string
+	var requireInfraEncryption, disableDeleteRetentionPolicy, enableLFS, allowSharedKeyAccess *bool
+	var publishMicrosoftEndpoints, publishInternetEndpoints, restoreSoftDeletedShare *bool
+	var allowedAccessModes map[csi.VolumeCapability_AccessMode_Mode]bool
+	var allowedAccessModesValue string
+	var shareReadyTimeout time.Duration
+	var enforcePublicAccessPolicy bool
+	var publicNetworkAccess storage.PublicNetworkAccess
+	// set allowBlobPublicAccess as false by default, unless it's allowed by driver
+	allowBlobPublicAccess := pointer.Bool(d.allowBlobPublicAccess)
 
 	fileShareNameReplaceMap := map[string]string{}
 	// store account key to k8s secret by default
-	storeAccountKey := true
+	storeAccountKey := d.storeAccountKey
 
 	// Apply ProvisionerParameters (case-insensitive). We leave validation of
 	// the values to the cloud provider.
@@ -198,14 +209,37 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", allowBlobPublicAccessField, v))
 			}
 			allowBlobPublicAccess = &value
+		case enforcePublicAccessPolicyField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in storage class", enforcePublicAccessPolicyField, v)
+			}
+			enforcePublicAccessPolicy = value
+		case publicNetworkAccessField:
+			value, err := parsePublicNetworkAccess(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			}
+			publicNetworkAccess = value
 		case pvcNameKey:
+			pvcName = v
 			fileShareNameReplaceMap[pvcNameMetadata] = v
 		case pvNameKey:
 			fileShareNameReplaceMap[pvNameMetadata] = v
 		case serverNameField:
-			// no op, only used in NodeStageVolume
+			// only do validations here, used in NodeStageVolume
+			if !isValidServerAddress(v) {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s, only IPv4 address or DNS name is supported", serverNameField, v)
+			}
+		case fallbackServersField:
+			// only do validations here, used in NodeStageVolume
+			if _, err := parseFallbackServers(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v in storage class", err)
+			}
 		case folderNameField:
-			// no op, only used in NodeStageVolume
+			folderName = v
+		case mountProfileField:
+			// no op, only used in NodeStageVolume, mount profiles are defined on agent node
 		case fsGroupChangePolicyField:
 			fsGroupChangePolicy = v
 		case mountPermissionsField:
@@ -215,8 +249,15 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 					return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid mountPermissions %s in storage class", v))
 				}
 			}
+		case targetPathPermissionsField, targetPathOwnerField:
+			// only do validations here, used in NodePublishVolume
+			if _, _, _, err := getTargetPathAttributes(map[string]string{k: v}); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v in storage class", err)
+			}
 		case vnetResourceGroupField:
 			vnetResourceGroup = v
+		case privateEndpointResourceGroupField:
+			privateEndpointResourceGroup = v
 		case vnetNameField:
 			vnetName = v
 		case subnetNameField:
@@ -229,30 +270,238 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", requireInfraEncryptionField, v))
 			}
 			requireInfraEncryption = &value
+		case encryptionScopeField:
+			// encryption scopes only apply to blobs, file shares are always encrypted with the key of the storage account
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported by Azure Files, encryption scopes only apply to blob storage, use %s or a customer-managed key of the storage account instead", encryptionScopeField, requireInfraEncryptionField)
+		case snapshotBeforeDeleteField:
+			// share snapshots are deleted together with the share, a snapshot taken before deletion would not outlive it
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported by Azure Files, share snapshots are deleted with the share, use %s or share soft delete of the storage account to keep data of deleted volumes instead", snapshotBeforeDeleteField, onDeleteRenameField)
+		case clientIDField:
+			clientID = v
+		case cloudConfigNameField:
+			cloudConfigName = v
+		case dataPlaneAuthTypeField:
+			dataPlaneAuthType = strings.ToLower(v)
+		case allowSharedKeyAccessField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", allowSharedKeyAccessField, v))
+			}
+			allowSharedKeyAccess = &value
+		case onDeleteRenameField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", onDeleteRenameField, v))
+			}
+			onDeleteRename = value
+		case forceCloseHandlesOnDeleteField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", forceCloseHandlesOnDeleteField, v))
+			}
+			forceCloseHandlesOnDelete = &value
+		case maxShareQuotaGiBField:
+			value, err := strconv.Atoi(v)
+			if err != nil || value <= 0 {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", maxShareQuotaGiBField, v))
+			}
+			maxShareQuotaGiB = value
+		case minShareQuotaGiBField:
+			value, err := strconv.Atoi(v)
+			if err != nil || value <= 0 {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", minShareQuotaGiBField, v))
+			}
+			minShareQuotaGiB = value
+		case routingPreferenceField:
+			routingChoice = v
+		case publishMicrosoftEndpointsField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", publishMicrosoftEndpointsField, v))
+			}
+			publishMicrosoftEndpoints = &value
+		case publishInternetEndpointsField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", publishInternetEndpointsField, v))
+			}
+			publishInternetEndpoints = &value
+		case shareReadyTimeoutField:
+			value, err := time.ParseDuration(v)
+			if err != nil || value < 0 {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", shareReadyTimeoutField, v))
+			}
+			shareReadyTimeout = value
+		case unmanagedQuotaField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", unmanagedQuotaField, v))
+			}
+			unmanagedQuota = value
+		case enableMfsymlinksField:
+			// used in NodeStageVolume, only validate mount flags with it here
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", enableMfsymlinksField, v))
+			}
+			enableMfsymlinks = value
+		case encryptInTransitField:
+			// seal mount option is added in NodeStageVolume
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", encryptInTransitField, v))
+			}
+			encryptInTransit = value
+		case enableCompressionField:
+			// compress mount option is added in NodeStageVolume
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", enableCompressionField, v))
+			}
+			enableCompression = value
+		case disableSMBLeasesField:
+			// nolease mount option is added in NodeStageVolume
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", disableSMBLeasesField, v))
+			}
+			disableSMBLeases = value
+		case sourceAddressField:
+			// source address is resolved and added as srcaddr mount option in NodeStageVolume
+			if strings.TrimSpace(v) == "" || strings.ContainsAny(v, ", =") {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", sourceAddressField, v))
+			}
+			sourceAddress = v
+		case mountTenantField:
+			// nosharesock mount option is added in NodeStageVolume
+			if strings.TrimSpace(v) == "" || strings.ContainsAny(v, ", =") {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", mountTenantField, v))
+			}
+			mountTenant = v
+		case restoreSoftDeletedShareField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s in storage class", restoreSoftDeletedShareField, v))
+			}
+			restoreSoftDeletedShare = &value
+		case allowedAccessModesField:
+			value, err := getAllowedAccessModes(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v in storage class", err)
+			}
+			allowedAccessModes = value
+			allowedAccessModesValue = v
 		default:
 			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid parameter %q in storage class", k))
 		}
 	}
 
+	if maxShareQuotaGiB > 0 && minShareQuotaGiB > maxShareQuotaGiB {
+		return nil, status.Errorf(codes.InvalidArgument, "%s(%d) is larger than %s(%d) in storage class", minShareQuotaGiBField, minShareQuotaGiB, maxShareQuotaGiBField, maxShareQuotaGiB)
+	}
+	if err := checkShareQuotaBounds(int(requestGiB), minShareQuotaGiB, maxShareQuotaGiB); err != nil {
+		return nil, err
+	}
+
+	if len(d.allowedPerformanceTiers) > 0 && pvcName != "" && pvcNamespace != "" {
+		tier, err := d.getPVCPerformanceTier(ctx, pvcNamespace, pvcName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+		if tier != "" {
+			if !d.isAllowedPerformanceTier(tier) {
+				return nil, status.Errorf(codes.InvalidArgument, "performance tier(%s) in pvc annotation(%s) is not allowed, allowed performance tier list: %v", tier, performanceTierAnnotation, d.allowedPerformanceTiers)
+			}
+			klog.V(2).Infof("override sku(%s) with performance tier(%s) in pvc(%s/%s) annotation", sku, tier, pvcNamespace, pvcName)
+			sku = tier
+		}
+	}
+
 	if matchTags && account != "" {
 		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("matchTags must set as false when storageAccount(%s) is provided", account))
 	}
 
-	if subsID != "" && subsID != d.cloud.SubscriptionID {
-		if resourceGroup == "" {
-			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("resourceGroup must be provided in cross subscription(%s)", subsID))
+	if clientID != "" && account == "" {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("storageAccount must be provided when clientID(%s) is specified", clientID))
+	}
+
+	if clientID != "" && cloudConfigName != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "clientID(%s) is not supported with cloudConfigName(%s)", clientID, cloudConfigName)
+	}
+	if account != "" {
+		if err := validateStorageAccountName(account); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid storageAccount(%s) in storage class: %v", account, err)
 		}
 	}
 
-	if secretNamespace == "" {
-		if pvcNamespace == "" {
-			secretNamespace = defaultNamespace
-		} else {
-			secretNamespace = pvcNamespace
+	cloud, err := d.getCloudByConfigName(cloudConfigName)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
+	if !pointer.BoolDeref(allowSharedKeyAccess, true) {
+		// storage account selection and creation of cloud provider retrieve account key
+		if account == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "storageAccount must be provided when allowSharedKeyAccess is false")
+		}
+		if useDataPlaneAPI {
+			return nil, status.Errorf(codes.InvalidArgument, "useDataPlaneAPI is not supported when allowSharedKeyAccess is false")
+		}
+		if isDiskFsType(fsType) {
+			return nil, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported when allowSharedKeyAccess is false", fsType)
+		}
+		if len(req.GetSecrets()) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "secrets are not supported when allowSharedKeyAccess is false")
+		}
+		// account key is never retrieved, share and its quota are managed by management API with driver identity,
+		// folderName directory is created by data plane API with OAuth token of driver identity
+		storeAccountKey = false
+	}
+
+	if !isSupportedDataPlaneAuthType(dataPlaneAuthType) {
+		return nil, status.Errorf(codes.InvalidArgument, "dataPlaneAuthType(%s) is not supported, supported dataPlaneAuthType list: %v", dataPlaneAuthType, supportedDataPlaneAuthTypeList)
+	}
+	if dataPlaneAuthType == dataPlaneAuthTypeOAuth {
+		// file share data plane API does not accept OAuth token on share level operations, e.g. create share and set share quota,
+		// so shares are provisioned by management API with driver identity, and account key is never used in provisioning
+		if useDataPlaneAPI {
+			return nil, status.Errorf(codes.InvalidArgument, "useDataPlaneAPI is not supported when dataPlaneAuthType is oauth, file share is created by management API with driver identity")
+		}
+		if len(req.GetSecrets()) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "provisioner secrets are not supported when dataPlaneAuthType is oauth, file share is created by management API with driver identity")
+		}
+		if isDiskFsType(fsType) {
+			return nil, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported when dataPlaneAuthType is oauth, vhd disk could only be created with account key", fsType)
+		}
+	}
+
+	if onDeleteRename && (useDataPlaneAPI || len(req.GetSecrets()) > 0) {
+		return nil, status.Errorf(codes.InvalidArgument, "onDeleteRename is not supported with useDataPlaneAPI or provisioner secrets")
+	}
+	if forceCloseHandlesOnDelete != nil && (useDataPlaneAPI || len(req.GetSecrets()) > 0) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with useDataPlaneAPI or provisioner secrets", forceCloseHandlesOnDeleteField)
+	}
+
+	if allowedAccessModes != nil {
+		if err := checkAllowedAccessModes(volumeCapabilities, allowedAccessModes, allowedAccessModesValue); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	if restoreSoftDeletedShare != nil && (useDataPlaneAPI || len(req.GetSecrets()) > 0) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with useDataPlaneAPI or provisioner secrets", restoreSoftDeletedShareField)
+	}
+
+	if subsID != "" && subsID != cloud.SubscriptionID {
+		if resourceGroup == "" {
+			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("resourceGroup must be provided in cross subscription(%s)", subsID))
 		}
 	}
 
-	if !d.enableVHDDiskFeature && fsType != "" {
+	secretNamespace, secretNamespaceSource := d.resolveSecretNamespace(secretNamespace, pvcNamespace)
+	klog.V(4).Infof("secret namespace(%s) of volume(%s) is resolved from %s", secretNamespace, volName, secretNamespaceSource)
+
+	if !d.enableVHDDiskFeature && fsType != "" && !isFileShareFsType(fsType) {
 		return nil, status.Errorf(codes.InvalidArgument, "fsType storage class parameter enables experimental VDH disk feature which is currently disabled, use --enable-vhd driver option to enable it")
 	}
 
@@ -260,9 +509,40 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported, supported fsType list: %v", fsType, supportedFsTypeList)
 	}
 
+	if strings.Contains(protocol, ",") {
+		// EnabledProtocols of a file share could only be SMB or NFS
+		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, dual-protocol file share is not supported by Azure Files, create separate file shares with protocol smb and nfs", protocol)
+	}
 	if !isSupportedProtocol(protocol) {
 		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported protocol list: %v", protocol, supportedProtocolList)
 	}
+	if protocol == blobNFS {
+		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) only supports mounting an existing blob container by static provisioning, use Azure Blob CSI driver(blob.csi.azure.com) to provision blob containers", blobNFS)
+	}
+	if protocol, err = resolveProtocol(fsType, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if protocol == nfs && isDiskFsType(fsType) {
+		// vhd disk is only provisioned in SMB file share
+		return nil, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported with protocol(%s)", fsType, protocol)
+	}
+
+	if !isSupportedSku(sku, d.strictSkuValidation) {
+		return nil, status.Errorf(codes.InvalidArgument, "skuName(%s) is not supported, supported skuName list: %v", sku, storage.PossibleSkuNameValues())
+	}
+	// premium account is FileStorage kind which only supports locally and zone redundant storage
+	if strings.HasPrefix(strings.ToLower(sku), premium) && isGeoRedundantSku(sku) {
+		return nil, status.Errorf(codes.InvalidArgument, "geo-redundant sku(%s) is not supported on premium storage account, premium file share only supports LRS/ZRS sku", sku)
+	}
+
+	if isGeoRedundantSku(sku) {
+		if fsType == nfs || protocol == nfs {
+			return nil, status.Errorf(codes.InvalidArgument, "geo-redundant sku(%s) is not supported with NFS protocol, NFS file share only supports premium LRS/ZRS sku", sku)
+		}
+		if pointer.BoolDeref(enableLFS, false) {
+			return nil, status.Errorf(codes.InvalidArgument, "large file shares is not supported on geo-redundant sku(%s), set %s as false or use LRS/ZRS sku", sku, enableLargeFileSharesField)
+		}
+	}
 
 	if !isSupportedShareAccessTier(shareAccessTier) {
 		return nil, status.Errorf(codes.InvalidArgument, "shareAccessTier(%s) is not supported, supported ShareAccessTier list: %v", shareAccessTier, storage.PossibleShareAccessTierValues())
@@ -284,15 +564,79 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Errorf(codes.InvalidArgument, "shareNamePrefix(%s) can only contain lowercase letters, numbers, hyphens, and length should be less than 21", shareNamePrefix)
 	}
 
-	if protocol == nfs && fsType != "" && fsType != nfs {
-		return nil, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported with protocol(%s)", fsType, protocol)
+	if encryptInTransit && (protocol == nfs || fsType == nfs) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", encryptInTransitField)
+	}
+	if enableCompression && (protocol == nfs || fsType == nfs) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", enableCompressionField)
+	}
+	if disableSMBLeases && (protocol == nfs || fsType == nfs) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", disableSMBLeasesField)
+	}
+	if sourceAddress != "" && (protocol == nfs || fsType == nfs) {
+		// nfs client does not support binding to a source address
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", sourceAddressField)
+	}
+	if mountTenant != "" && (protocol == nfs || fsType == nfs) {
+		// nfs mounts are not authenticated by the account key, there is no SMB session to isolate
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", mountTenantField)
+	}
+	if forceCloseHandlesOnDelete != nil && (protocol == nfs || fsType == nfs) {
+		// open handles could only be listed and closed on SMB file share
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", forceCloseHandlesOnDeleteField)
+	}
+	if !isDiskFsType(fsType) {
+		// fail provisioning instead of NodeStageVolume if mount flags are not supported by the protocol
+		if err := validateMountFlags(volumeCapabilities, protocol == nfs || fsType == nfs, enableMfsymlinks); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid mount flags: %v", err)
+		}
+	}
+
+	routingPreference, err := getRoutingPreference(routingChoice, publishMicrosoftEndpoints, publishInternetEndpoints)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	if routingPreference != nil {
+		if fsType == nfs || protocol == nfs || strings.HasPrefix(strings.ToLower(sku), premium) {
+			return nil, status.Errorf(codes.InvalidArgument, "routing preference is only supported on standard storage account with SMB protocol, sku(%s) protocol(%s) is not supported", sku, protocol)
+		}
+		if account != "" || len(req.GetSecrets()) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "routing preference is only applied to storage account created by driver, storageAccount and provisioner secrets are not supported")
+		}
 	}
 
 	enableHTTPSTrafficOnly := true
 	shareProtocol := storage.EnabledProtocolsSMB
-	createPrivateEndpoint := false
-	if strings.EqualFold(networkEndpointType, privateEndpoint) {
-		createPrivateEndpoint = true
+	if networkEndpointType, err = d.getNetworkEndpointType(networkEndpointType); err != nil {
+		return nil, err
+	}
+	createPrivateEndpoint := networkEndpointType == privateEndpoint
+	if createPrivateEndpoint {
+		// the driver default is stored in volume context, so node checks that the server resolves to a private address
+		setKeyValueInMap(parameters, networkEndpointTypeField, privateEndpoint)
+	}
+	// private endpoint, private DNS zone and its virtual network link are created by cloud provider in the resource
+	// group of the virtual network, they are created by the driver if they are in another resource group
+	privateEndpointByDriver := false
+	if privateEndpointResourceGroup != "" {
+		if !createPrivateEndpoint {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with %s: %s", privateEndpointResourceGroupField, networkEndpointTypeField, privateEndpoint)
+		}
+		effectiveVnetResourceGroup := vnetResourceGroup
+		if effectiveVnetResourceGroup == "" {
+			effectiveVnetResourceGroup = cloud.ResourceGroup
+			if cloud.VnetResourceGroup != "" {
+				effectiveVnetResourceGroup = cloud.VnetResourceGroup
+			}
+		}
+		privateEndpointByDriver = !strings.EqualFold(privateEndpointResourceGroup, effectiveVnetResourceGroup)
+	}
+	if publicNetworkAccess != "" && len(req.GetSecrets()) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with provisioner secrets since the storage account could not be checked", publicNetworkAccessField)
+	}
+	if publicNetworkAccess == storage.PublicNetworkAccessDisabled && !createPrivateEndpoint {
+		// both SMB and NFS mounts go through the public endpoint of the account without private endpoint and its private DNS zone
+		return nil, status.Errorf(codes.InvalidArgument, "%s(%s) requires %s: %s, otherwise the storage account could not be mounted", publicNetworkAccessField, publicNetworkAccess, networkEndpointTypeField, privateEndpoint)
 	}
 	var vnetResourceIDs []string
 	if fsType == nfs || protocol == nfs {
@@ -322,12 +666,51 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	fileShareSize := int(requestGiB)
 	// account kind should be FileStorage for Premium File
 	accountKind := string(storage.KindStorageV2)
+	shareTier, minimumShareSize := "standard", minimumStandardShareSize
 	if strings.HasPrefix(strings.ToLower(sku), premium) {
 		accountKind = string(storage.KindFileStorage)
-		if fileShareSize < minimumPremiumShareSize {
-			fileShareSize = minimumPremiumShareSize
+		shareTier, minimumShareSize = premium, minimumPremiumShareSize
+	}
+	// default share quota is set by the operator, only an explicitly requested capacity is rejected
+	if capacityBytes > 0 && capacityBytes < volumehelper.GiBToBytes(int64(minimumShareSize)) && d.belowMinimumCapacityPolicy == belowMinimumCapacityPolicyReject {
+		return nil, status.Errorf(codes.OutOfRange, "requested capacity(%d bytes) is below the minimum %s share size(%d GiB), request at least %d GiB", capacityBytes, shareTier, minimumShareSize, minimumShareSize)
+	}
+	if fileShareSize < minimumShareSize {
+		klog.V(2).Infof("round up share size from %d GiB to minimum %s share size %d GiB", fileShareSize, shareTier, minimumShareSize)
+		fileShareSize = minimumShareSize
+	}
+	if maxShareQuotaGiB > 0 && fileShareSize > maxShareQuotaGiB {
+		return nil, status.Errorf(codes.OutOfRange, "minimum %s share size(%d GiB) exceeds %s(%d GiB) in storage class", shareTier, fileShareSize, maxShareQuotaGiBField, maxShareQuotaGiB)
+	}
+	if unmanagedQuota {
+		if accountKind == string(storage.KindFileStorage) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is only supported on standard storage account since premium file share is billed by provisioned quota, sku(%s)", unmanagedQuotaField, sku)
+		}
+		if isDiskFsType(fsType) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with fsType(%s)", unmanagedQuotaField, fsType)
+		}
+		if enableLFS == nil && account == "" && !isGeoRedundantSku(sku) {
+			enableLFS = pointer.Bool(true)
 		}
 	}
+	if accountKind == string(storage.KindStorageV2) && enableLFS == nil && account == "" && !isGeoRedundantSku(sku) &&
+		d.autoEnableLargeFileShares && fileShareSize > maximumStandardShareSizeNoLFS {
+		klog.V(2).Infof("enable large file shares on storage account since requested share size(%d GiB) exceeds %d GiB", fileShareSize, maximumStandardShareSizeNoLFS)
+		enableLFS = pointer.Bool(true)
+	}
+	// large file shares could not be enabled on geo-redundant account
+	maxShareSizeEnableLFS := enableLFS
+	if isGeoRedundantSku(sku) {
+		maxShareSizeEnableLFS = pointer.Bool(false)
+	}
+	if fileShareSize > getMaximumShareSize(accountKind, maxShareSizeEnableLFS) {
+		return nil, getShareSizeExceedError(fileShareSize, sku, accountKind, maxShareSizeEnableLFS)
+	}
+	if unmanagedQuota {
+		// standard file share is billed by used capacity, so the share could grow up to the account capacity
+		fileShareSize = getMaximumShareSize(accountKind, maxShareSizeEnableLFS)
+		klog.V(2).Infof("set share size as the maximum share size(%d GiB) since %s is true", fileShareSize, unmanagedQuotaField)
+	}
 
 	// replace pv/pvc name namespace metadata in fileShareName
 	validFileShareName := replaceWithMap(fileShareName, fileShareNameReplaceMap)
@@ -344,11 +727,32 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 				name = strings.Replace(name, "pvc", "pvcd", 1)
 			}
 		}
+		if d.shareNameNamespace != "" {
+			name = d.shareNameNamespace + "-" + name
+			if len(name) > fileShareNameMaxLength {
+				return nil, status.Errorf(codes.InvalidArgument, "file share name(%s) with share name namespace(%s) exceeds %d characters, use a shorter shareNamePrefix", name, d.shareNameNamespace, fileShareNameMaxLength)
+			}
+		}
 		validFileShareName = getValidFileShareName(name)
 	}
 
 	if resourceGroup == "" {
-		resourceGroup = d.cloud.ResourceGroup
+		resourceGroup = cloud.ResourceGroup
+	}
+	if cloudConfigName != "" && subsID == "" {
+		// subscription is always kept in volume id with cloud config name
+		subsID = cloud.SubscriptionID
+	}
+
+	if err := d.bindAccountToCloudConfig(cloudConfigName, account); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	if clientID != "" {
+		if _, err := d.getCloudByClientID(clientID); err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+		// the storage account is managed with the user-assigned identity kept in the volume handle
+		ctx = withClientID(ctx, clientID)
 	}
 
 	tags, err := ConvertTagsToMap(customTags)
@@ -357,11 +761,15 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}
 
 	if strings.TrimSpace(storageEndpointSuffix) == "" {
-		if d.cloud.Environment.StorageEndpointSuffix != "" {
-			storageEndpointSuffix = d.cloud.Environment.StorageEndpointSuffix
+		if cloud.Environment.StorageEndpointSuffix != "" {
+			storageEndpointSuffix = cloud.Environment.StorageEndpointSuffix
 		} else {
 			storageEndpointSuffix = defaultStorageEndPointSuffix
 		}
+		if cloudConfigName != "" {
+			// node could mount without the cloud config if account key is stored in k8s secret
+			setKeyValueInMap(parameters, storageEndpointSuffixField, storageEndpointSuffix)
+		}
 	}
 	if d.fileClient != nil {
 		d.fileClient.StorageEndpointSuffix = storageEndpointSuffix
@@ -379,7 +787,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		Tags:                                    tags,
 		VirtualNetworkResourceIDs:               vnetResourceIDs,
 		CreateAccount:                           createAccount,
-		CreatePrivateEndpoint:                   createPrivateEndpoint,
+		CreatePrivateEndpoint:                   createPrivateEndpoint && !privateEndpointByDriver,
 		EnableLargeFileShare:                    enableLFS,
 		DisableFileServiceDeleteRetentionPolicy: disableDeleteRetentionPolicy,
 		AllowBlobPublicAccess:                   allowBlobPublicAccess,
@@ -387,49 +795,202 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		VNetName:                                vnetName,
 		SubnetName:                              subnetName,
 		RequireInfrastructureEncryption:         requireInfraEncryption,
+		AllowSharedKeyAccess:                    allowSharedKeyAccess,
 		AccessTier:                              accountAccessTier,
 		StorageType:                             provider.StorageTypeFile,
 		StorageEndpointSuffix:                   storageEndpointSuffix,
 	}
 
+	if account != "" && pointer.BoolDeref(requireInfraEncryption, false) {
+		// infrastructure encryption could only be enabled when creating the account
+		enabled, err := d.isInfraEncryptionEnabled(ctx, subsID, resourceGroup, account)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+		if !enabled {
+			return nil, status.Errorf(codes.InvalidArgument, "infrastructure encryption is not enabled on storage account(%s), it could only be enabled when creating the account, remove storageAccount from storage class to let driver create a new account with %s", account, requireInfraEncryptionField)
+		}
+	}
+
+	if account != "" && enforcePublicAccessPolicy && !pointer.BoolDeref(allowBlobPublicAccess, false) {
+		// accounts matched or created by EnsureStorageAccount already follow allowBlobPublicAccess, only the specified account may violate it
+		allowed, err := d.isBlobPublicAccessAllowed(ctx, subsID, resourceGroup, account)
+		switch {
+		case err != nil && d.rejectPublicAccessPolicyViolation:
+			return nil, status.Errorf(codes.Internal, "failed to check blob public access policy of account(%s): %v", account, err)
+		case err != nil:
+			klog.Warningf("failed to check blob public access policy of account(%s): %v", account, err)
+		case allowed && d.rejectPublicAccessPolicyViolation:
+			return nil, status.Errorf(codes.FailedPrecondition, "storage account(%s) allows blob public access, which violates allowBlobPublicAccess(false) of storage class", account)
+		case allowed:
+			klog.Warningf("storage account(%s) allows blob public access, which violates allowBlobPublicAccess(false) of storage class", account)
+		}
+	}
+
+	if account != "" && publicNetworkAccess != "" {
+		if err := d.checkPublicNetworkAccess(ctx, subsID, resourceGroup, account, publicNetworkAccess); err != nil {
+			return nil, err
+		}
+	}
+
+	if account != "" && createPrivateEndpoint && len(req.GetSecrets()) == 0 {
+		// private endpoint is only created with storage accounts created by EnsureStorageAccount
+		if err := d.checkAccountPrivateEndpoint(ctx, subsID, resourceGroup, account); err != nil {
+			return nil, err
+		}
+	}
+
 	var accountKey, lockKey string
 	accountName := account
+	// whether the account is surely created by EnsureStorageAccount in this request
+	accountCreated := false
+	switch {
+	case accountName != "":
+		recordAccountReuse(volName, accountName, accountReuseReasonStorageAccount)
+	case len(req.GetSecrets()) > 0:
+		secretAccountName, _, _ := getStorageAccount(req.GetSecrets())
+		recordAccountReuse(volName, secretAccountName, accountReuseReasonSecrets)
+	}
+	// the selected storage account must not be deleted as an empty storage account before the file share is created in it
+	releaseAccountSelection := func() {}
+	defer func() { releaseAccountSelection() }()
 	if len(req.GetSecrets()) == 0 && accountName == "" {
 		if v, ok := d.volMap.Load(volName); ok {
 			accountName = v.(string)
+			recordAccountReuse(volName, accountName, accountReuseReasonVolumeCache)
+			if privateEndpointByDriver {
+				// CreateVolume is retried after the private endpoint of the account created failed to be created
+				if err := d.createAccountPrivateEndpoint(ctx, cloud, subsID, resourceGroup, accountName, privateEndpointResourceGroup, vnetResourceGroup, vnetName, subnetName, storageEndpointSuffix); err != nil {
+					return nil, status.Errorf(codes.Internal, err.Error())
+				}
+			}
 		} else {
-			lockKey = fmt.Sprintf("%s%s%s%s%s%s%s%v%v%v%v%v", sku, accountKind, resourceGroup, location, protocol, subsID, accountAccessTier,
+			lockKey = fmt.Sprintf("%s%s%s%s%s%s%s%v%v%v%v%v%v%s%v%v%s%s%s", sku, accountKind, resourceGroup, location, protocol, subsID, accountAccessTier,
 				createPrivateEndpoint, pointer.BoolDeref(allowBlobPublicAccess, false), pointer.BoolDeref(requireInfraEncryption, false),
-				pointer.BoolDeref(enableLFS, false), pointer.BoolDeref(disableDeleteRetentionPolicy, false))
+				pointer.BoolDeref(enableLFS, false), pointer.BoolDeref(disableDeleteRetentionPolicy, false), pointer.BoolDeref(allowSharedKeyAccess, false),
+				routingChoice, pointer.BoolDeref(publishMicrosoftEndpoints, false), pointer.BoolDeref(publishInternetEndpoints, false), cloudConfigName, publicNetworkAccess,
+				privateEndpointResourceGroup)
 			// search in cache first
 			cache, err := d.accountSearchCache.Get(lockKey, azcache.CacheReadTypeDefault)
 			if err != nil {
 				return nil, status.Errorf(codes.Internal, err.Error())
 			}
-			if cache != nil {
+			if cache != nil && !isAccountExcluded(ctx, cache.(string)) {
 				accountName = cache.(string)
+				recordAccountReuse(volName, accountName, accountReuseReasonSearchCache)
 			} else {
+				if createPrivateEndpoint {
+					if err := validatePrivateEndpointPrerequisites(cloud, vnetName, subnetName); err != nil {
+						return nil, err
+					}
+				}
 				d.volLockMap.LockEntry(lockKey)
-				err = wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+				// accounts listed by EnsureStorageAccount are prepared for matching and the created account is recorded by the hook
+				hook := d.newAccountCreateHook(cloud, accountOptions)
+				hook.publicNetworkAccess = publicNetworkAccess
+				hook.routingPreference = routingPreference
+				hook.privateEndpointByDriver = privateEndpointByDriver
+				ensureCtx, span := startSpan(context.WithValue(ctx, accountCreateHookKey{}, hook), "EnsureStorageAccount", resourceGroupAttribute.String(resourceGroup))
+				err = wait.ExponentialBackoffWithContext(ensureCtx, cloud.RequestBackoff(), func() (bool, error) {
 					var retErr error
-					accountName, accountKey, retErr = d.cloud.EnsureStorageAccount(ctx, accountOptions, defaultAccountNamePrefix)
+					accountName, accountKey, retErr = cloud.EnsureStorageAccount(ensureCtx, accountOptions, defaultAccountNamePrefix)
 					if isRetriableError(retErr) {
 						klog.Warningf("EnsureStorageAccount(%s) failed with error(%v), waiting for retrying", account, retErr)
-						sleepIfThrottled(retErr, accountOpThrottlingSleepSec)
+						sleepIfThrottled(ctx, retErr, accountOpThrottlingSleepSec)
 						return false, nil
 					}
 					return true, retErr
 				})
+				span.SetAttributes(accountNameAttribute.String(accountName))
+				endSpan(span, err)
 				d.volLockMap.UnlockEntry(lockKey)
+				if isContextError(err) {
+					return nil, status.FromContextError(err).Err()
+				}
 				if err != nil {
+					if sku != "" && !isKnownSku(sku) {
+						// the sku is not validated by the driver, ARM may reject it if it's not available in the region
+						return nil, status.Errorf(codes.Internal, "failed to ensure storage account with sku(%s) not known by the driver, check whether the sku is available in location(%s): %v", sku, location, err)
+					}
+					if createPrivateEndpoint && isForbiddenError(err) {
+						// storage account and private endpoint resources may be in different resource groups with separate role assignments
+						peResourceGroup := privateEndpointResourceGroup
+						if peResourceGroup == "" {
+							peResourceGroup = vnetResourceGroup
+						}
+						if peResourceGroup == "" {
+							peResourceGroup = cloud.ResourceGroup
+							if cloud.VnetResourceGroup != "" {
+								peResourceGroup = cloud.VnetResourceGroup
+							}
+						}
+						return nil, status.Errorf(codes.Internal, "failed to ensure storage account in resource group(%s) with private endpoint in resource group(%s), check whether the driver identity has write permission on both resource groups: %v",
+							resourceGroup, peResourceGroup, err)
+					}
 					return nil, status.Errorf(codes.Internal, "failed to ensure storage account: %v", err)
 				}
+				if err := d.bindAccountToCloudConfig(cloudConfigName, accountName); err != nil {
+					return nil, status.Errorf(codes.Internal, err.Error())
+				}
+				accountCreated = d.recordEnsuredAccount(ctx, volName, accountName, accountOptions, hook)
+				if privateEndpointByDriver && accountCreated {
+					// the account created is selected again if CreateVolume is retried, so that its private endpoint is created
+					d.volMap.Store(volName, accountName)
+					if err := d.createAccountPrivateEndpoint(ctx, cloud, subsID, resourceGroup, accountName, privateEndpointResourceGroup, vnetResourceGroup, vnetName, subnetName, storageEndpointSuffix); err != nil {
+						return nil, status.Errorf(codes.Internal, err.Error())
+					}
+				}
+				if !accountCreated && !createPrivateEndpoint {
+					// a matched account may have firewall rules set after it's created
+					for _, acct := range hook.getListedAccounts() {
+						if strings.EqualFold(pointer.StringDeref(acct.Name, ""), accountName) {
+							warnIfAccountFirewallDeniesSubnet(acct, d.getSubnetResourceID(vnetResourceGroup, vnetName, subnetName))
+						}
+					}
+				}
+				if publicNetworkAccess != "" && !accountCreated {
+					// public network access is set in the create request of the account created by this request, a matched account is checked
+					if err := d.checkPublicNetworkAccess(ctx, subsID, resourceGroup, accountName, publicNetworkAccess); err != nil {
+						return nil, err
+					}
+				}
 				d.accountSearchCache.Set(lockKey, accountName)
 				d.volMap.Store(volName, accountName)
 				if accountKey != "" {
 					d.accountCacheMap.Set(accountName, accountKey)
 				}
 			}
+			var selectable bool
+			if releaseAccountSelection, selectable = d.lockAccountSelection(accountName); !selectable {
+				klog.V(2).Infof("storage account(%s) is deleted as an empty account after it's selected for volume(%s), select another account", accountName, volName)
+				// release volume lock first to prevent deadlock
+				d.volumeLocks.Release(volName)
+				if lockKey != "" {
+					if err := d.accountSearchCache.Delete(lockKey); err != nil {
+						return nil, status.Errorf(codes.Internal, err.Error())
+					}
+				}
+				d.volMap.Delete(volName)
+				// the deleted account could still be listed for a while, or an account could be created again with the same name
+				return d.CreateVolume(withExcludedAccount(ctx, accountName), req)
+			}
+			if d.enableAccountCapacityCheck {
+				hasCapacity, err := d.accountHasCapacity(ctx, subsID, resourceGroup, accountName, fileShareSize)
+				if err != nil {
+					klog.Warningf("skip checking capacity of account(%s) for volume(%s): %v", accountName, volName, err)
+				} else if !hasCapacity {
+					klog.Warningf("storage account(%s) could not fit file share(%s) size(%d), select another account for volume(%s)", accountName, validFileShareName, fileShareSize, volName)
+					// the account is only excluded in this request, smaller file shares could still fit in it
+					// release volume lock first to prevent deadlock
+					d.volumeLocks.Release(volName)
+					releaseAccountSelection()
+					if err := d.accountSearchCache.Delete(lockKey); err != nil {
+						return nil, status.Errorf(codes.Internal, err.Error())
+					}
+					d.volMap.Delete(volName)
+					return d.CreateVolume(withExcludedAccount(context.WithValue(ctx, accountCapacityExceededKey{}, accountName), accountName), req)
+				}
+			}
 		}
 	}
 
@@ -438,7 +999,11 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}
 
 	accountOptions.Name = accountName
+	if err := d.bindAccountToCloudConfig(cloudConfigName, accountName); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
 	secret := req.GetSecrets()
+	shareExists := false
 	if len(secret) == 0 && useDataPlaneAPI {
 		if accountKey == "" {
 			if accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, secret, secretName, secretNamespace); err != nil {
@@ -450,8 +1015,52 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	} else {
 		if quota, err := d.getFileShareQuota(ctx, subsID, resourceGroup, accountName, validFileShareName, secret); err != nil {
 			return nil, status.Errorf(codes.Internal, err.Error())
-		} else if quota != -1 && quota < fileShareSize {
-			return nil, status.Errorf(codes.AlreadyExists, "request file share(%s) already exists, but its capacity %d is smaller than %d", validFileShareName, quota, fileShareSize)
+		} else if quota != -1 {
+			shareExists = true
+			if quota < fileShareSize {
+				return nil, status.Errorf(codes.AlreadyExists, "request file share(%s) already exists, but its capacity %d is smaller than %d", validFileShareName, quota, fileShareSize)
+			}
+			if len(secret) == 0 {
+				// file share archived by onDeleteRename should not be reused
+				metadata, err := d.getFileShareMetadata(ctx, "", subsID, resourceGroup, accountName, validFileShareName, nil)
+				if err != nil {
+					return nil, status.Errorf(codes.Internal, err.Error())
+				}
+				if getMetadataValue(metadata, deletedByCSIMetadataKey) != "" {
+					return nil, status.Errorf(codes.FailedPrecondition, "request file share(%s) on account(%s) is archived by onDeleteRename, use another share name", validFileShareName, accountName)
+				}
+				if namespace := getMetadataValue(metadata, shareNameNamespaceMetadataKey); namespace != "" && namespace != d.shareNameNamespace {
+					return nil, status.Errorf(codes.AlreadyExists, "request file share(%s) on account(%s) already exists in share name namespace(%s), use another share name", validFileShareName, accountName, namespace)
+				}
+			}
+		}
+	}
+
+	if restoreSoftDeletedShare != nil && !shareExists {
+		// the share name could be held by a soft-deleted share, share creation fails until the share is purged
+		deletedShare, shares, err := d.getSoftDeletedShare(ctx, subsID, resourceGroup, accountName, validFileShareName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+		if deletedShare != nil {
+			version := pointer.StringDeref(deletedShare.Version, "")
+			if *restoreSoftDeletedShare {
+				klog.V(2).Infof("restore soft-deleted file share(%s) version(%s) on account(%s) rg(%s)", validFileShareName, version, accountName, resourceGroup)
+				if err := d.restoreFileShare(ctx, d.getCloudByAccount(ctx, subsID, resourceGroup, accountName), subsID, resourceGroup, accountName, validFileShareName, version); err != nil {
+					if isContextError(err) {
+						return nil, status.FromContextError(err).Err()
+					}
+					return nil, status.Errorf(codes.Internal, "failed to restore soft-deleted file share(%s) version(%s) on account(%s) rg(%s), error: %v", validFileShareName, version, accountName, resourceGroup, err)
+				}
+				// never shrink the restored share which may hold more data than the request
+				if quota := int(pointer.Int32Deref(deletedShare.ShareQuota, 0)); quota > fileShareSize {
+					fileShareSize = quota
+				}
+			} else {
+				newName := getSoftDeletedShareRename(validFileShareName, version, shares)
+				klog.V(2).Infof("file share name(%s) on account(%s) rg(%s) is held by soft-deleted share version(%s), use file share name(%s) instead", validFileShareName, accountName, resourceGroup, version, newName)
+				validFileShareName = newName
+			}
 		}
 	}
 
@@ -461,38 +1070,110 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		RequestGiB: fileShareSize,
 		AccessTier: shareAccessTier,
 		RootSquash: rootSquashType,
+		Metadata:   map[string]*string{createdByMetadataKey: pointer.String(createdByDriver)},
+	}
+	if onDeleteRename {
+		shareOptions.Metadata[onDeleteMetadataKey] = pointer.String(onDeleteArchive)
+	}
+	if forceCloseHandlesOnDelete != nil {
+		shareOptions.Metadata[forceCloseHandlesMetadataKey] = pointer.String(strconv.FormatBool(*forceCloseHandlesOnDelete))
+	}
+	if d.shareNameNamespace != "" {
+		shareOptions.Metadata[shareNameNamespaceMetadataKey] = pointer.String(d.shareNameNamespace)
+	}
+	if minShareQuotaGiB > 0 {
+		shareOptions.Metadata[minShareQuotaMetadataKey] = pointer.String(strconv.Itoa(minShareQuotaGiB))
+	}
+	if maxShareQuotaGiB > 0 {
+		shareOptions.Metadata[maxShareQuotaMetadataKey] = pointer.String(strconv.Itoa(maxShareQuotaGiB))
 	}
 
 	var volumeID string
-	mc := metrics.NewMetricContext(azureFileCSIDriverName, "controller_create_volume", d.cloud.ResourceGroup, subsID, d.Name)
+	mc := metrics.NewMetricContext(azureFileCSIDriverName, "controller_create_volume", cloud.ResourceGroup, subsID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, VolumeID, volumeID)
 	}()
 
 	klog.V(2).Infof("begin to create file share(%s) on account(%s) type(%s) subID(%s) rg(%s) location(%s) size(%d) protocol(%s)", validFileShareName, accountName, sku, subsID, resourceGroup, location, fileShareSize, shareProtocol)
-	if err := d.CreateFileShare(ctx, accountOptions, shareOptions, secret); err != nil {
+	createFileShare := func() error { return d.CreateFileShare(ctx, accountOptions, shareOptions, secret) }
+	if accountCreated {
+		err = retryOnAccountNotPropagated(ctx, fmt.Sprintf("CreateFileShare(%s) on account(%s)", validFileShareName, accountName), createFileShare)
+	} else {
+		err = createFileShare()
+	}
+	if err != nil {
 		if strings.Contains(err.Error(), accountLimitExceedManagementAPI) || strings.Contains(err.Error(), accountLimitExceedDataPlaneAPI) {
 			klog.Warningf("create file share(%s) on account(%s) type(%s) subID(%s) rg(%s) location(%s) size(%d), error: %v, skip matching current account", validFileShareName, accountName, sku, subsID, resourceGroup, location, fileShareSize, err)
 			tags := map[string]*string{
 				azure.SkipMatchingTag: pointer.String(""),
 			}
-			if rerr := d.cloud.AddStorageAccountTags(ctx, subsID, resourceGroup, accountName, tags); rerr != nil {
-				klog.Warningf("AddStorageAccountTags(%v) on account(%s) subsID(%s) rg(%s) failed with error: %v", tags, accountName, subsID, resourceGroup, rerr.Error())
+			if err := d.addStorageAccountTags(ctx, subsID, resourceGroup, accountName, tags); err != nil {
+				klog.Warningf("AddStorageAccountTags(%v) on account(%s) subsID(%s) rg(%s) failed with error: %v", tags, accountName, subsID, resourceGroup, err)
 			}
 			// release volume lock first to prevent deadlock
 			d.volumeLocks.Release(volName)
+			releaseAccountSelection()
 			// clean search cache
 			if err := d.accountSearchCache.Delete(lockKey); err != nil {
 				return nil, status.Errorf(codes.Internal, err.Error())
 			}
 			// remove the volName from the volMap to stop it matching the same storage account
 			d.volMap.Delete(volName)
-			return d.CreateVolume(ctx, req)
+			return d.CreateVolume(context.WithValue(ctx, accountLimitExceededKey{}, accountName), req)
 		}
+		if isContextError(err) {
+			return nil, status.FromContextError(err).Err()
+		}
+		if isAuthorizationError(err) {
+			return nil, status.Errorf(codes.PermissionDenied, "identity is not authorized to create file share(%s) on account(%s) rg(%s), grant the identity a role with Microsoft.Storage/storageAccounts/fileServices/shares/write permission, error: %v", validFileShareName, accountName, resourceGroup, err)
+		}
+		d.invalidateAccountKey(accountName, err)
 		return nil, status.Errorf(codes.Internal, "failed to create file share(%s) on account(%s) type(%s) subsID(%s) rg(%s) location(%s) size(%d), error: %v", validFileShareName, account, sku, subsID, resourceGroup, location, fileShareSize, err)
 	}
 	klog.V(2).Infof("create file share %s on storage account %s successfully", validFileShareName, accountName)
+	releaseAccountSelection()
+	if d.enableAccountCapacityCheck && !shareExists {
+		d.addAccountUsage(subsID, resourceGroup, accountName, fileShareSize)
+	}
+
+	if shareReadyTimeout > 0 && !shareExists {
+		if err := d.waitForFileShareReady(ctx, subsID, resourceGroup, accountName, validFileShareName, secret, shareReadyTimeout); err != nil {
+			if isContextError(err) {
+				return nil, status.FromContextError(err).Err()
+			}
+			return nil, status.Errorf(codes.Unavailable, err.Error())
+		}
+		klog.V(2).Infof("file share %s on storage account %s is ready", validFileShareName, accountName)
+	}
+	if len(req.GetSecrets()) == 0 {
+		d.updateCapacityTags(ctx, capacityTagsOperationCreate, subsID, resourceGroup, accountName)
+	}
+
+	// folderName directory could only be created by data plane API, it's not supported on NFS share
+	if folderName != "" && !isDiskFsType(fsType) && fsType != nfs && protocol != nfs {
+		// without account key, directory is created with OAuth token of the identity managing the account
+		dirAccountKey := ""
+		useOAuth := !pointer.BoolDeref(allowSharedKeyAccess, true) || dataPlaneAuthType == dataPlaneAuthTypeOAuth
+		if !useOAuth {
+			if accountKey == "" {
+				if accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, req.GetSecrets(), secretName, secretNamespace); err != nil {
+					return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
+				}
+			}
+			dirAccountKey = accountKey
+		}
+		if err := d.createShareDirectory(ctx, subsID, resourceGroup, accountName, dirAccountKey, validFileShareName, folderName); err != nil {
+			if isContextError(err) {
+				return nil, status.FromContextError(err).Err()
+			}
+			if useOAuth && isStorageErrorStatus(err, http.StatusForbidden) {
+				return nil, status.Errorf(codes.PermissionDenied, "identity is not authorized to create directory(%s) in file share(%s) on account(%s), grant the identity %s role on the storage account, error: %v", folderName, validFileShareName, accountName, fileDataPrivilegedContributorRole, err)
+			}
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+		klog.V(2).Infof("create directory(%s) in file share(%s) on account(%s) successfully", folderName, validFileShareName, accountName)
+	}
 
 	if isDiskFsType(fsType) && !strings.HasSuffix(diskName, vhdSuffix) {
 		if accountKey == "" {
@@ -510,7 +1191,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		diskSizeBytes := volumehelper.GiBToBytes(requestGiB)
 		klog.V(2).Infof("begin to create vhd file(%s) size(%d) on share(%s) on account(%s) type(%s) rg(%s) location(%s)",
 			diskName, diskSizeBytes, validFileShareName, account, sku, resourceGroup, location)
-		if err := createDisk(ctx, accountName, accountKey, d.cloud.Environment.StorageEndpointSuffix, validFileShareName, diskName, diskSizeBytes); err != nil {
+		if err := createDisk(ctx, accountName, accountKey, cloud.Environment.StorageEndpointSuffix, validFileShareName, diskName, diskSizeBytes); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to create VHD disk: %v", err)
 		}
 		klog.V(2).Infof("create vhd file(%s) size(%d) on share(%s) on account(%s) type(%s) rg(%s) location(%s) successfully",
@@ -550,10 +1231,20 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		// not necessary for dynamic file share name creation since volumeID already contains volume name
 		uuid = volName
 	}
-	volumeID = fmt.Sprintf(volumeIDTemplate, resourceGroup, accountName, validFileShareName, diskName, uuid, secretNamespace)
-	if subsID != "" && subsID != d.cloud.SubscriptionID {
-		volumeID = volumeID + "#" + subsID
+	handle := &volumeHandle{
+		resourceGroup:   resourceGroup,
+		accountName:     accountName,
+		fileShareName:   validFileShareName,
+		diskName:        diskName,
+		uuid:            uuid,
+		clientID:        clientID,
+		secretNamespace: secretNamespace,
+		cloudConfigName: cloudConfigName,
 	}
+	if cloudConfigName != "" || subsID != d.cloud.SubscriptionID {
+		handle.subsID = subsID
+	}
+	volumeID = handle.String()
 
 	if useDataPlaneAPI {
 		d.dataPlaneAPIVolMap.Store(volumeID, "")
@@ -561,12 +1252,26 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 
 	isOperationSucceeded = true
 
+	// return the actual provisioned size, vhd disk size is the requested size
+	provisionedGiB := int64(fileShareSize)
+	if isDiskFsType(fsType) {
+		provisionedGiB = requestGiB
+	}
+
 	// reset secretNamespace field in VolumeContext
 	setKeyValueInMap(parameters, secretNamespaceField, secretNamespace)
+	if !isDiskFsType(fsType) {
+		// surface performance of the file share for monitoring, it's derived from the provisioned size on premium
+		// account, maximum performance of a standard file share depends on large file shares state of the account
+		// which could be enabled later, it's only returned by ControllerGetVolume
+		if accountKind == string(storage.KindFileStorage) {
+			setPremiumSharePerformance(parameters, fileShareSize)
+		}
+	}
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      volumeID,
-			CapacityBytes: capacityBytes,
+			CapacityBytes: volumehelper.GiBToBytes(provisionedGiB),
 			VolumeContext: parameters,
 		},
 	}, nil
@@ -575,6 +1280,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 // DeleteVolume delete an azure file
 func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
+	ctx = withVolumeClientID(ctx, volumeID)
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
@@ -594,6 +1300,9 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 		klog.Errorf("GetFileShareInfo(%s) in DeleteVolume failed with error: %v", volumeID, err)
 		return &csi.DeleteVolumeResponse{}, nil
 	}
+	if err := d.bindAccountToCloudConfig(getCloudConfigName(volumeID), accountName); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
 
 	if resourceGroupName == "" {
 		resourceGroupName = d.cloud.ResourceGroup
@@ -612,6 +1321,10 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 		// use data plane api, get account key first
 		_, _, accountKey, _, _, _, err := d.GetAccountInfo(ctx, volumeID, req.GetSecrets(), reqContext)
 		if err != nil {
+			if isNotFoundError(err) {
+				klog.Warningf("storage account(%s) under rg(%s) of volume(%s) does not exist, file share(%s) is already deleted: %v", accountName, resourceGroupName, volumeID, fileShareName, err)
+				return &csi.DeleteVolumeResponse{}, nil
+			}
 			return nil, status.Errorf(codes.NotFound, "get account info from(%s) failed with error: %v", volumeID, err)
 		}
 		secret = createStorageAccountSecret(accountName, accountKey)
@@ -623,26 +1336,135 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 		mc.ObserveOperationWithResult(isOperationSucceeded, VolumeID, volumeID)
 	}()
 
+	metadata, err := d.getFileShareMetadata(ctx, volumeID, subsID, resourceGroupName, accountName, fileShareName, secret)
+	if err != nil {
+		if isThrottlingError(err) {
+			return nil, status.Errorf(codes.Unavailable, "failed to get metadata of file share(%s) under account(%s) rg(%s) since requests are throttled, retry later: %v", fileShareName, accountName, resourceGroupName, err)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get metadata of file share(%s) under account(%s) rg(%s): %v", fileShareName, accountName, resourceGroupName, err)
+	}
+	if namespace := getMetadataValue(metadata, shareNameNamespaceMetadataKey); namespace != "" && namespace != d.shareNameNamespace {
+		return nil, status.Errorf(codes.FailedPrecondition, "file share(%s) under account(%s) rg(%s) belongs to share name namespace(%s), it could not be deleted by driver in share name namespace(%s)", fileShareName, accountName, resourceGroupName, namespace, d.shareNameNamespace)
+	}
+	if getMetadataValue(metadata, deletedByCSIMetadataKey) != "" {
+		klog.V(2).Infof("file share(%s) under account(%s) rg(%s) is already archived, skip deleting", fileShareName, accountName, resourceGroupName)
+		isOperationSucceeded = true
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if !d.deleteUnownedShares && !isShareOwned(metadata, d.shareNameNamespace) && !isLegacyDriverShare(volumeID, fileShareName) {
+		klog.V(2).Infof("file share(%s) under account(%s) rg(%s) is not created or adopted by the driver, skip deleting", fileShareName, accountName, resourceGroupName)
+		isOperationSucceeded = true
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if strings.EqualFold(getMetadataValue(metadata, onDeleteMetadataKey), onDeleteArchive) {
+		if err := d.archiveFileShare(ctx, volumeID, secret); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to archive file share(%s) under account(%s) rg(%s): %v", fileShareName, accountName, resourceGroupName, err)
+		}
+		klog.V(2).Infof("file share(%s) under account(%s) rg(%s) volume(%s) is archived instead of being deleted", fileShareName, accountName, resourceGroupName, volumeID)
+		isOperationSucceeded = true
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if v := getMetadataValue(metadata, forceCloseHandlesMetadataKey); v != "" {
+		if err := d.checkOpenHandlesOnDelete(ctx, volumeID, resourceGroupName, accountName, fileShareName, secret, strings.EqualFold(v, trueValue)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.waitForDeleteVolumeRateLimit(ctx, subsID, resourceGroupName, accountName); err != nil {
+		return nil, err
+	}
 	if err := d.DeleteFileShare(ctx, subsID, resourceGroupName, accountName, fileShareName, secret); err != nil {
+		if isContextError(err) {
+			return nil, status.FromContextError(err).Err()
+		}
+		if isThrottlingError(err) {
+			// the share is not deleted yet, external-provisioner retries DeleteVolume with backoff and keeps the PV finalizer
+			return nil, status.Errorf(codes.Unavailable, "DeleteFileShare %s under account(%s) rg(%s) is throttled, retry later: %v", fileShareName, accountName, resourceGroupName, err)
+		}
+		d.invalidateAccountKey(accountName, err)
 		return nil, status.Errorf(codes.Internal, "DeleteFileShare %s under account(%s) rg(%s) failed with error: %v", fileShareName, accountName, resourceGroupName, err)
 	}
 	klog.V(2).Infof("azure file(%s) under subsID(%s) rg(%s) account(%s) volume(%s) is deleted successfully", fileShareName, subsID, resourceGroupName, accountName, volumeID)
+	if d.deleteEmptyAccounts {
+		deleted, err := d.deleteAccountIfEmpty(ctx, subsID, resourceGroupName, accountName, fileShareName)
+		if err != nil {
+			klog.Warningf("failed to delete empty storage account(%s) under rg(%s): %v", accountName, resourceGroupName, err)
+		}
+		if deleted {
+			isOperationSucceeded = true
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+	}
+	if len(req.GetSecrets()) == 0 {
+		d.updateCapacityTags(ctx, capacityTagsOperationDelete, subsID, resourceGroupName, accountName)
+	}
 	if err := d.RemoveStorageAccountTag(ctx, subsID, resourceGroupName, accountName, azure.SkipMatchingTag); err != nil {
-		klog.Warningf("RemoveStorageAccountTag(%s) under rg(%s) account(%s) failed with %v", azure.SkipMatchingTag, resourceGroupName, accountName, err)
+		if isNotFoundError(err) {
+			klog.V(2).Infof("skip removing tag(%s) since account(%s) under rg(%s) does not exist", azure.SkipMatchingTag, accountName, resourceGroupName)
+		} else {
+			klog.Warningf("RemoveStorageAccountTag(%s) under rg(%s) account(%s) failed with %v", azure.SkipMatchingTag, resourceGroupName, accountName, err)
+		}
 	}
 
 	isOperationSucceeded = true
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
-// ControllerGetVolume get volume
-func (d *Driver) ControllerGetVolume(context.Context, *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// ControllerGetVolume returns the provisioned size of the file share, and the performance of premium file share
+// derived from the provisioned size in volume context
+func (d *Driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	ctx = withVolumeClientID(ctx, volumeID)
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_GET_VOLUME); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid get volume request: %v", req)
+	}
+
+	resourceGroupName, accountName, fileShareName, diskName, _, subsID, err := GetFileShareInfo(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "GetFileShareInfo(%s) failed with error: %v", volumeID, err)
+	}
+	if err := d.bindAccountToCloudConfig(getCloudConfigName(volumeID), accountName); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	if resourceGroupName == "" {
+		resourceGroupName = d.cloud.ResourceGroup
+	}
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+
+	fileShare, err := d.getCloudByAccount(ctx, subsID, resourceGroupName, accountName).GetFileShare(ctx, subsID, resourceGroupName, accountName, fileShareName)
+	if err != nil {
+		if strings.Contains(err.Error(), "ShareNotFound") || isNotFoundError(err) {
+			return nil, status.Errorf(codes.NotFound, "the requested volume(%s) does not exist.", volumeID)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get file share(%s) in account(%s) rg(%s): %v", fileShareName, accountName, resourceGroupName, err)
+	}
+
+	volume := &csi.Volume{VolumeId: volumeID, VolumeContext: map[string]string{}}
+	if properties := fileShare.FileShareProperties; properties != nil && properties.ShareQuota != nil && !strings.HasSuffix(diskName, vhdSuffix) {
+		volume.CapacityBytes = volumehelper.GiBToBytes(int64(*properties.ShareQuota))
+		if properties.AccessTier == storage.ShareAccessTierPremium {
+			setPremiumSharePerformance(volume.VolumeContext, int(*properties.ShareQuota))
+		} else if properties.AccessTier != "" {
+			// large file shares state of the account is not returned with the file share
+			if enableLFS, err := d.isLargeFileSharesEnabled(ctx, subsID, resourceGroupName, accountName); err != nil {
+				klog.Warningf("skip maximum performance of file share(%s) in account(%s) rg(%s): %v", fileShareName, accountName, resourceGroupName, err)
+			} else {
+				setStandardSharePerformance(volume.VolumeContext, int(*properties.ShareQuota), enableLFS)
+			}
+		}
+	}
+	return &csi.ControllerGetVolumeResponse{Volume: volume}, nil
 }
 
 // ValidateVolumeCapabilities return the capabilities of the volume
 func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 	volumeID := req.GetVolumeId()
+	ctx = withVolumeClientID(ctx, volumeID)
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
@@ -668,6 +1490,18 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 		return nil, status.Errorf(codes.NotFound, "the requested volume(%s) does not exist.", volumeID)
 	}
 
+	for k, v := range req.GetVolumeContext() {
+		if strings.EqualFold(k, allowedAccessModesField) {
+			allowedAccessModes, err := getAllowedAccessModes(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v in volume context", err)
+			}
+			if err := checkAllowedAccessModes(volCaps, allowedAccessModes, v); err != nil {
+				return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+			}
+		}
+	}
+
 	confirmed := &csi.ValidateVolumeCapabilitiesResponse_Confirmed{VolumeCapabilities: volCaps}
 	if !strings.HasSuffix(diskName, vhdSuffix) {
 		return &csi.ValidateVolumeCapabilitiesResponse{Confirmed: confirmed}, nil
@@ -700,6 +1534,7 @@ func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (
 // ControllerPublishVolume make a volume available on some required node
 func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
+	ctx = withVolumeClientID(ctx, volumeID)
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
@@ -746,7 +1581,7 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 	}
 	defer d.volumeLocks.Release(volumeID)
 
-	storageEndpointSuffix := d.cloud.Environment.StorageEndpointSuffix
+	storageEndpointSuffix := d.getCloud(accountName).Environment.StorageEndpointSuffix
 	fileURL, err := getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("getFileURL(%s,%s,%s,%s) returned with error: %v", accountName, storageEndpointSuffix, fileShareName, diskName, err))
@@ -798,7 +1633,7 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 	}
 	defer d.volumeLocks.Release(volumeID)
 
-	storageEndpointSuffix := d.cloud.Environment.StorageEndpointSuffix
+	storageEndpointSuffix := d.getCloud(accountName).Environment.StorageEndpointSuffix
 	fileURL, err := getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("getFileURL(%s,%s,%s,%s) returned with error: %v", accountName, storageEndpointSuffix, fileShareName, diskName, err))
@@ -817,6 +1652,7 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 // CreateSnapshot create a snapshot
 func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
 	sourceVolumeID := req.GetSourceVolumeId()
+	ctx = withVolumeClientID(ctx, sourceVolumeID)
 	snapshotName := req.Name
 	if len(snapshotName) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Snapshot name must be provided")
@@ -825,10 +1661,20 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot Source Volume ID must be provided")
 	}
 
+	// external-snapshotter retries with the same snapshot name, serialize the requests so that
+	// a retry could find the snapshot created by a previous request instead of creating a duplicate one
+	if acquired := d.volumeLocks.TryAcquire(snapshotName); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, snapshotName)
+	}
+	defer d.volumeLocks.Release(snapshotName)
+
 	rgName, accountName, fileShareName, _, _, subsID, err := GetFileShareInfo(sourceVolumeID) //nolint:dogsled
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("GetFileShareInfo(%s) failed with error: %v", sourceVolumeID, err))
 	}
+	if err := d.bindAccountToCloudConfig(getCloudConfigName(sourceVolumeID), accountName); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
 	if rgName == "" {
 		rgName = d.cloud.ResourceGroup
 	}
@@ -836,15 +1682,27 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		subsID = d.cloud.SubscriptionID
 	}
 
-	var useDataPlaneAPI bool
+	var useDataPlaneAPI, autoPruneSnapshots bool
+	var retentionClass string
 	for k, v := range req.GetParameters() {
 		switch strings.ToLower(k) {
 		case useDataPlaneAPIField:
 			useDataPlaneAPI = strings.EqualFold(v, trueValue)
+		case retentionClassField:
+			retentionClass = strings.TrimSpace(v)
+		case autoPruneSnapshotsField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume snapshot class", autoPruneSnapshotsField, v)
+			}
+			autoPruneSnapshots = value
 		default:
-			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid parameter %q in storage class", k))
+			klog.Warningf("ignore unknown parameter %q in volume snapshot class of snapshot(%s)", k, snapshotName)
 		}
 	}
+	if retentionClass != "" && !d.isAllowedSnapshotRetentionClass(retentionClass) {
+		return nil, status.Errorf(codes.InvalidArgument, "retentionClass(%s) is not allowed, allowed retentionClass list: %v", retentionClass, d.allowedSnapshotRetentionClasses)
+	}
 
 	mc := metrics.NewMetricContext(azureFileCSIDriverName, "controller_create_snapshot", rgName, subsID, d.Name)
 	isOperationSucceeded := false
@@ -873,38 +1731,72 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		}, nil
 	}
 
-	if len(req.GetSecrets()) > 0 || useDataPlaneAPI {
-		shareURL, err := d.getShareURL(ctx, sourceVolumeID, req.GetSecrets())
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get share url with (%s): %v", sourceVolumeID, err)
-		}
+	createSnapshot := func() error {
+		if len(req.GetSecrets()) > 0 || useDataPlaneAPI {
+			shareURL, err := d.getShareURL(ctx, sourceVolumeID, req.GetSecrets())
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to get share url with (%s): %v", sourceVolumeID, err)
+			}
 
-		snapshotShare, err := shareURL.CreateSnapshot(ctx, azfile.Metadata{snapshotNameKey: snapshotName})
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "create snapshot from(%s) failed with %v, shareURL: %q", sourceVolumeID, err, shareURL)
-		}
+			metadata := azfile.Metadata{snapshotNameKey: snapshotName}
+			if retentionClass != "" {
+				metadata[retentionClassMetadataKey] = retentionClass
+			}
+			snapshotShare, err := shareURL.CreateSnapshot(ctx, metadata)
+			if err != nil {
+				return status.Errorf(codes.Internal, "create snapshot from(%s) failed with %v, shareURL: %q", sourceVolumeID, err, shareURL)
+			}
 
-		properties, err := shareURL.GetProperties(ctx)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get snapshot properties from (%s): %v", snapshotShare.Snapshot(), err)
-		}
+			// get properties of the snapshot instead of the source share, so that creation time is the same
+			// as the one returned by snapshotExists on retry
+			properties, err := shareURL.WithSnapshot(snapshotShare.Snapshot()).GetProperties(ctx)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to get snapshot properties from (%s): %v", snapshotShare.Snapshot(), err)
+			}
 
-		itemSnapshot = snapshotShare.Snapshot()
-		itemSnapshotTime = properties.LastModified()
-		itemSnapshotQuota = properties.Quota()
-	} else {
-		snapshotShare, err := d.cloud.FileClient.WithSubscriptionID(subsID).CreateFileShare(ctx, rgName, accountName, &fileclient.ShareOptions{Name: fileShareName, RequestGiB: defaultAzureFileQuota, Metadata: map[string]*string{snapshotNameKey: &snapshotName}}, snapshotsExpand)
+			itemSnapshot = snapshotShare.Snapshot()
+			itemSnapshotTime = properties.LastModified()
+			itemSnapshotQuota = properties.Quota()
+		} else {
+			metadata := map[string]*string{snapshotNameKey: &snapshotName}
+			if retentionClass != "" {
+				metadata[retentionClassMetadataKey] = &retentionClass
+			}
+			snapshotShare, err := d.getCloudByAccount(ctx, subsID, rgName, accountName).FileClient.WithSubscriptionID(subsID).CreateFileShare(ctx, rgName, accountName, &fileclient.ShareOptions{Name: fileShareName, RequestGiB: defaultAzureFileQuota, Metadata: metadata}, snapshotsExpand)
+			if err != nil {
+				return status.Errorf(codes.Internal, "create snapshot from(%s) failed with %v, accountName: %q", sourceVolumeID, err, accountName)
+			}
+
+			if snapshotShare.SnapshotTime == nil {
+				return status.Errorf(codes.Internal, "Last modified time of snapshot is null")
+			}
+
+			itemSnapshot = snapshotShare.SnapshotTime.Format(snapshotTimeFormat)
+			itemSnapshotTime = snapshotShare.SnapshotTime.Time
+			itemSnapshotQuota = pointer.Int32Deref(snapshotShare.ShareQuota, 0)
+		}
+		return nil
+	}
+	if err := createSnapshot(); err != nil {
+		if !isSnapshotLimitError(err) {
+			return nil, err
+		}
+		if !autoPruneSnapshots {
+			return nil, status.Errorf(codes.ResourceExhausted, "file share of volume(%s) reaches its snapshot limit, delete unused snapshots or set %s to true in volume snapshot class to delete the oldest snapshot created by the driver: %s", sourceVolumeID, autoPruneSnapshotsField, status.Convert(err).Message())
+		}
+		pruned, err := d.pruneOldestSnapshot(ctx, sourceVolumeID, req.GetSecrets(), useDataPlaneAPI)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "create snapshot from(%s) failed with %v, accountName: %q", sourceVolumeID, err, accountName)
+			return nil, status.Errorf(codes.Internal, "failed to prune the oldest snapshot of volume(%s): %v", sourceVolumeID, err)
 		}
-
-		if snapshotShare.SnapshotTime == nil {
-			return nil, status.Errorf(codes.Internal, "Last modified time of snapshot is null")
+		if pruned == "" {
+			return nil, status.Errorf(codes.ResourceExhausted, "file share of volume(%s) reaches its snapshot limit and there is no snapshot created by the driver without retention class to prune", sourceVolumeID)
+		}
+		if err := createSnapshot(); err != nil {
+			if isSnapshotLimitError(err) {
+				return nil, status.Errorf(codes.ResourceExhausted, "file share of volume(%s) still reaches its snapshot limit after pruning snapshot(%s): %s", sourceVolumeID, pruned, status.Convert(err).Message())
+			}
+			return nil, err
 		}
-
-		itemSnapshot = snapshotShare.SnapshotTime.Format(snapshotTimeFormat)
-		itemSnapshotTime = snapshotShare.SnapshotTime.Time
-		itemSnapshotQuota = pointer.Int32Deref(snapshotShare.ShareQuota, 0)
 	}
 
 	klog.V(2).Infof("Created share snapshot: %s", itemSnapshot)
@@ -928,6 +1820,7 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 	if len(req.SnapshotId) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Snapshot ID must be provided")
 	}
+	ctx = withVolumeClientID(ctx, req.SnapshotId)
 	rgName, accountName, fileShareName, _, _, _, err := GetFileShareInfo(req.SnapshotId) //nolint:dogsled
 	if fileShareName == "" || err != nil {
 		// According to CSI Driver Sanity Tester, should succeed when an invalid snapshot id is used
@@ -938,11 +1831,16 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to get snapshot name with (%s): %v", req.SnapshotId, err)
 	}
+	// snapshot id is source volume id with snapshot name as suffix
+	sourceVolumeID := strings.TrimSuffix(req.SnapshotId, separator+snapshot)
+	if err := d.bindAccountToCloudConfig(getCloudConfigName(sourceVolumeID), accountName); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
 
 	if rgName == "" {
 		rgName = d.cloud.ResourceGroup
 	}
-	subsID := d.cloud.SubscriptionID
+	subsID := d.getCloud(accountName).SubscriptionID
 	mc := metrics.NewMetricContext(azureFileCSIDriverName, "controller_delete_snapshot", rgName, subsID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
@@ -960,7 +1858,7 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 
 		_, deleteErr = shareURL.WithSnapshot(snapshot).Delete(ctx, azfile.DeleteSnapshotsOptionNone)
 	} else {
-		deleteErr = d.cloud.FileClient.WithSubscriptionID(subsID).DeleteFileShare(ctx, rgName, accountName, fileShareName, snapshot)
+		deleteErr = d.getCloudByAccount(ctx, subsID, rgName, accountName).FileClient.WithSubscriptionID(subsID).DeleteFileShare(ctx, rgName, accountName, fileShareName, snapshot)
 	}
 
 	if deleteErr != nil {
@@ -984,6 +1882,7 @@ func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReques
 // ControllerExpandVolume controller expand volume
 func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
+	ctx = withVolumeClientID(ctx, volumeID)
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
@@ -992,6 +1891,9 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		return nil, status.Error(codes.InvalidArgument, "volume capacity range missing in request")
 	}
 	requestGiB := volumehelper.RoundUpGiB(capacityBytes)
+	if requestGiB > maximumShareSize {
+		return nil, status.Errorf(codes.OutOfRange, "requested share size(%d GiB) exceeds the maximum share size(%d GiB)", requestGiB, maximumShareSize)
+	}
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid expand volume request: %v", req)
 	}
@@ -1000,6 +1902,9 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("GetFileShareInfo(%s) failed with error: %v", volumeID, err))
 	}
+	if err := d.bindAccountToCloudConfig(getCloudConfigName(volumeID), accountName); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
 	if strings.HasSuffix(diskName, vhdSuffix) {
 		// todo: figure out how to support vhd disk resize
 		return nil, status.Error(codes.Unimplemented, fmt.Sprintf("vhd disk volume(%s, diskName:%s) is not supported on ControllerExpandVolume", volumeID, diskName))
@@ -1018,6 +1923,22 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	}()
 
 	secrets := req.GetSecrets()
+	if cloud := d.getCloudByAccount(ctx, subsID, resourceGroupName, accountName); len(secrets) == 0 && requestGiB > maximumStandardShareSizeNoLFS && cloud.StorageAccountClient != nil {
+		// check large file shares state of standard account to return a clear error before resizing
+		account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroupName, accountName)
+		if rerr != nil {
+			klog.Warningf("failed to get storage account(%s) in resource group(%s): %v", accountName, resourceGroupName, rerr.Error())
+		} else {
+			enableLFS := account.AccountProperties != nil && account.AccountProperties.LargeFileSharesState == storage.LargeFileSharesStateEnabled
+			if int(requestGiB) > getMaximumShareSize(string(account.Kind), &enableLFS) {
+				var sku string
+				if account.Sku != nil {
+					sku = string(account.Sku.Name)
+				}
+				return nil, getShareSizeExceedError(int(requestGiB), sku, string(account.Kind), &enableLFS)
+			}
+		}
+	}
 	if len(secrets) == 0 && d.useDataPlaneAPI(volumeID, accountName) {
 		reqContext := map[string]string{}
 		if secretNamespace != "" {
@@ -1031,13 +1952,36 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		secrets = createStorageAccountSecret(accountName, accountKey)
 	}
 
+	metadata, err := d.getFileShareMetadata(ctx, volumeID, subsID, resourceGroupName, accountName, fileShareName, secrets)
+	if err != nil {
+		if isThrottlingError(err) {
+			return nil, status.Errorf(codes.Unavailable, "failed to get metadata of file share(%s) under account(%s) rg(%s) since requests are throttled, retry later: %v", fileShareName, accountName, resourceGroupName, err)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get metadata of file share(%s) under account(%s) rg(%s): %v", fileShareName, accountName, resourceGroupName, err)
+	}
+	minShareQuotaGiB, maxShareQuotaGiB, err := getShareQuotaBounds(metadata)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v of file share(%s) under account(%s) rg(%s)", err, fileShareName, accountName, resourceGroupName)
+	}
+	if err := checkShareQuotaBounds(int(requestGiB), minShareQuotaGiB, maxShareQuotaGiB); err != nil {
+		return nil, err
+	}
+
 	if err = d.ResizeFileShare(ctx, subsID, resourceGroupName, accountName, fileShareName, int(requestGiB), secrets); err != nil {
+		if isContextError(err) {
+			return nil, status.FromContextError(err).Err()
+		}
+		d.invalidateAccountKey(accountName, err)
 		return nil, status.Errorf(codes.Internal, "expand volume error: %v", err)
 	}
 
+	if len(req.GetSecrets()) == 0 {
+		d.updateCapacityTags(ctx, capacityTagsOperationExpand, subsID, resourceGroupName, accountName)
+	}
+
 	isOperationSucceeded = true
 	klog.V(2).Infof("ControllerExpandVolume(%s) successfully, currentQuota: %d Gi", volumeID, int(requestGiB))
-	return &csi.ControllerExpandVolumeResponse{CapacityBytes: capacityBytes}, nil
+	return &csi.ControllerExpandVolumeResponse{CapacityBytes: volumehelper.GiBToBytes(requestGiB)}, nil
 }
 
 // getShareURL: sourceVolumeID is the id of source file share, returns a ShareURL of source file share.
@@ -1056,29 +2000,90 @@ func (d *Driver) getShareURL(ctx context.Context, sourceVolumeID string, secrets
 }
 
 func (d *Driver) getServiceURL(ctx context.Context, sourceVolumeID string, secrets map[string]string) (azfile.ServiceURL, string, error) {
-	_, accountName, accountKey, fileShareName, _, _, err := d.GetAccountInfo(ctx, sourceVolumeID, secrets, map[string]string{}) //nolint:dogsled
+	u, p, fileShareName, err := d.getServicePipeline(ctx, sourceVolumeID, secrets)
 	if err != nil {
 		return azfile.ServiceURL{}, "", err
 	}
+	return azfile.NewServiceURL(u, p), fileShareName, nil
+}
+
+// getServicePipeline returns the file service URL of the account of volume, and the pipeline authorized by the account key
+func (d *Driver) getServicePipeline(ctx context.Context, sourceVolumeID string, secrets map[string]string) (url.URL, pipeline.Pipeline, string, error) {
+	_, accountName, accountKey, fileShareName, _, _, err := d.GetAccountInfo(ctx, sourceVolumeID, secrets, map[string]string{}) //nolint:dogsled
+	if err != nil {
+		return url.URL{}, nil, "", err
+	}
 
 	credential, err := azfile.NewSharedKeyCredential(accountName, accountKey)
 	if err != nil {
 		klog.Errorf("NewSharedKeyCredential(%s) in CreateSnapshot failed with error: %v", accountName, err)
-		return azfile.ServiceURL{}, "", err
+		return url.URL{}, nil, "", err
 	}
 
-	u, err := url.Parse(fmt.Sprintf(serviceURLTemplate, accountName, d.cloud.Environment.StorageEndpointSuffix))
+	u, err := url.Parse(fmt.Sprintf(serviceURLTemplate, accountName, d.getCloud(accountName).Environment.StorageEndpointSuffix))
 	if err != nil {
 		klog.Errorf("parse serviceURLTemplate error: %v", err)
-		return azfile.ServiceURL{}, "", err
+		return url.URL{}, nil, "", err
 	}
 	if u == nil {
-		return azfile.ServiceURL{}, "", fmt.Errorf("url is nil")
+		return url.URL{}, nil, "", fmt.Errorf("url is nil")
 	}
 
-	serviceURL := azfile.NewServiceURL(*u, azfile.NewPipeline(credential, azfile.PipelineOptions{}))
+	return *u, azfile.NewPipeline(credential, azfile.PipelineOptions{}), fileShareName, nil
+}
 
-	return serviceURL, fileShareName, nil
+// getFileShareMetadata returns metadata of the file share, returns nil if the file share does not exist
+func (d *Driver) getFileShareMetadata(ctx context.Context, volumeID, subsID, resourceGroup, accountName, fileShareName string, secrets map[string]string) (map[string]*string, error) {
+	if len(secrets) > 0 {
+		shareURL, err := d.getShareURL(ctx, volumeID, secrets)
+		if err != nil {
+			return nil, err
+		}
+		properties, err := shareURL.GetProperties(ctx)
+		if err != nil {
+			if strings.Contains(err.Error(), "ShareNotFound") {
+				return nil, nil
+			}
+			return nil, err
+		}
+		metadata := map[string]*string{}
+		for k, v := range properties.NewMetadata() {
+			metadata[k] = pointer.String(v)
+		}
+		return metadata, nil
+	}
+
+	fileShare, err := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName).GetFileShare(ctx, subsID, resourceGroup, accountName, fileShareName)
+	if err != nil {
+		if strings.Contains(err.Error(), "ShareNotFound") || isNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if fileShare.FileShareProperties == nil {
+		return nil, nil
+	}
+	return fileShare.FileShareProperties.Metadata, nil
+}
+
+// archiveFileShare marks the file share as deleted by driver in metadata instead of deleting it, it's idempotent
+func (d *Driver) archiveFileShare(ctx context.Context, volumeID string, secrets map[string]string) error {
+	shareURL, err := d.getShareURL(ctx, volumeID, secrets)
+	if err != nil {
+		return err
+	}
+	// metadata is replaced as a whole, read it right before the update to keep metadata set by other requests
+	properties, err := shareURL.GetProperties(ctx)
+	if err != nil {
+		return err
+	}
+	metadata := properties.NewMetadata()
+	if _, ok := metadata[deletedByCSIMetadataKey]; ok {
+		return nil
+	}
+	metadata[deletedByCSIMetadataKey] = time.Now().UTC().Format(time.RFC3339)
+	_, err = shareURL.SetMetadata(ctx, metadata)
+	return err
 }
 
 // snapshotExists: sourceVolumeID is the id of source file share, returns the existence of snapshot and its detail info.
@@ -1124,7 +2129,8 @@ func (d *Driver) snapshotExists(ctx context.Context, sourceVolumeID, snapshotNam
 		}
 
 		// List share snapshots.
-		listSnapshot, err := d.cloud.FileClient.WithSubscriptionID(subsID).ListFileShare(ctx, rgName, accountName, "", snapshotsExpand)
+		fileClient := d.getCloudByAccount(ctx, subsID, rgName, accountName).FileClient
+		listSnapshot, err := fileClient.WithSubscriptionID(subsID).ListFileShare(ctx, rgName, accountName, "", snapshotsExpand)
 		if err != nil {
 			return false, "", time.Time{}, 0, err
 		}
@@ -1136,10 +2142,10 @@ func (d *Driver) snapshotExists(ctx context.Context, sourceVolumeID, snapshotNam
 				continue
 			}
 			shareSnapshotTime := share.SnapshotTime.Format(snapshotTimeFormat)
-			fileshare, err := d.cloud.FileClient.WithSubscriptionID(subsID).GetFileShare(ctx, rgName, accountName, pointer.StringDeref(share.Name, ""), shareSnapshotTime)
+			fileshare, err := fileClient.WithSubscriptionID(subsID).GetFileShare(ctx, rgName, accountName, pointer.StringDeref(share.Name, ""), shareSnapshotTime)
 			if err != nil {
-				klog.V(2).Infof("get share(%s) snapshot(%s) error(%s)", pointer.StringDeref(share.Name, ""), shareSnapshotTime, err)
-				return false, "", time.Time{}, 0, nil
+				// do not report snapshot as non-existent, otherwise a duplicate snapshot would be created
+				return false, "", time.Time{}, 0, fmt.Errorf("get share(%s) snapshot(%s) failed with %v", pointer.StringDeref(share.Name, ""), shareSnapshotTime, err)
 			}
 			if fileshare.Metadata != nil && pointer.StringDeref(fileshare.Metadata[snapshotNameKey], "") == snapshotName {
 				if pointer.StringDeref(fileshare.Name, "") == fileShareName {
@@ -1177,3 +2183,129 @@ func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) error {
 	}
 	return nil
 }
+
+// validateMountFlags validates mount flags of volume capabilities with the same checks of NodeStageVolume,
+// and rejects mount options which are only supported by the other protocol
+func validateMountFlags(volCaps []*csi.VolumeCapability, isNFS, enableMfsymlinks bool) error {
+	for _, c := range volCaps {
+		if c.GetMount() == nil {
+			continue
+		}
+		_, mountFlags, err := getMountPropagation(c.GetMount().GetMountFlags())
+		if err != nil {
+			return err
+		}
+		for _, mountFlag := range mountFlags {
+			for _, option := range strings.Split(mountFlag, ",") {
+				option = strings.TrimSpace(option)
+				key := strings.SplitN(option, "=", 2)[0]
+				if isNFS && isMountOptionInList(key, smbOnlyMountOptionList) {
+					return fmt.Errorf("mount option(%s) is only supported with SMB protocol", option)
+				}
+				if !isNFS && isMountOptionInList(key, nfsOnlyMountOptionList) {
+					return fmt.Errorf("mount option(%s) is only supported with NFS protocol", option)
+				}
+			}
+		}
+		if isNFS {
+			if _, _, err := getNFSMountOptions(mountFlags); err != nil {
+				return err
+			}
+			continue
+		}
+		mountOptions, err := getSMBMountOptions(mountFlags, enableMfsymlinks, 0, 0)
+		if err != nil {
+			return err
+		}
+		// there is no SMB 4.x, vers=4.x is meant for NFS
+		if version := getSMBVersion(mountOptions); strings.HasPrefix(version, "4") {
+			return fmt.Errorf("mount option(%s=%s) is only supported with NFS protocol", vers, version)
+		}
+	}
+	return nil
+}
+
+// getAllowedAccessModes parses comma separated Kubernetes access mode names in allowedAccessModes parameter,
+// it returns the CSI access modes allowed by the names
+func getAllowedAccessModes(value string) (map[csi.VolumeCapability_AccessMode_Mode]bool, error) {
+	allowed := map[csi.VolumeCapability_AccessMode_Mode]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		var modes []csi.VolumeCapability_AccessMode_Mode
+		for _, accessMode := range supportedAccessModeList {
+			if strings.EqualFold(name, string(accessMode)) {
+				modes = accessModeMap[accessMode]
+			}
+		}
+		if len(modes) == 0 {
+			return nil, fmt.Errorf("invalid %s: %s, supported access modes: %v", allowedAccessModesField, value, supportedAccessModeList)
+		}
+		for _, mode := range modes {
+			allowed[mode] = true
+		}
+	}
+	return allowed, nil
+}
+
+// checkAllowedAccessModes returns error if access mode of any volume capability is not allowed
+func checkAllowedAccessModes(volCaps []*csi.VolumeCapability, allowed map[csi.VolumeCapability_AccessMode_Mode]bool, value string) error {
+	for _, c := range volCaps {
+		mode := c.GetAccessMode().GetMode()
+		if allowed[mode] {
+			continue
+		}
+		name := mode.String()
+		for accessMode, modes := range accessModeMap {
+			for _, m := range modes {
+				if m == mode {
+					name = string(accessMode)
+				}
+			}
+		}
+		return fmt.Errorf("access mode %s is not allowed by %s(%s) of storage class", name, allowedAccessModesField, value)
+	}
+	return nil
+}
+
+// getShareSizeExceedError returns OutOfRange error with the maximum share size of the account
+func getShareSizeExceedError(shareSize int, sku, accountKind string, enableLFS *bool) error {
+	maxShareSize := getMaximumShareSize(accountKind, enableLFS)
+	if accountKind != string(storage.KindStorageV2) {
+		return status.Errorf(codes.OutOfRange, "requested share size(%d GiB) exceeds the maximum share size(%d GiB) of sku(%s)", shareSize, maxShareSize, sku)
+	}
+	if maxShareSize == maximumStandardShareSizeNoLFS && isGeoRedundantSku(sku) {
+		return status.Errorf(codes.OutOfRange, "requested share size(%d GiB) exceeds the maximum share size(%d GiB) of geo-redundant sku(%s), large file shares is not supported on geo-redundant account", shareSize, maxShareSize, sku)
+	}
+	if maxShareSize == maximumStandardShareSizeNoLFS {
+		return status.Errorf(codes.OutOfRange, "requested share size(%d GiB) exceeds the maximum share size(%d GiB) of sku(%s) without large file shares, set %s as true to increase the maximum share size to %d GiB", shareSize, maxShareSize, sku, enableLargeFileSharesField, maximumShareSize)
+	}
+	return status.Errorf(codes.OutOfRange, "requested share size(%d GiB) exceeds the maximum share size(%d GiB) of sku(%s) with large file shares enabled", shareSize, maxShareSize, sku)
+}
+
+// checkShareQuotaBounds returns OutOfRange error if requestGiB is out of the share quota bounds of the storage class,
+// a bound of 0 is not enforced
+func checkShareQuotaBounds(requestGiB, minShareQuotaGiB, maxShareQuotaGiB int) error {
+	if maxShareQuotaGiB > 0 && requestGiB > maxShareQuotaGiB {
+		return status.Errorf(codes.OutOfRange, "requested share size(%d GiB) exceeds %s(%d GiB) in storage class", requestGiB, maxShareQuotaGiBField, maxShareQuotaGiB)
+	}
+	if minShareQuotaGiB > 0 && requestGiB < minShareQuotaGiB {
+		return status.Errorf(codes.OutOfRange, "requested share size(%d GiB) is less than %s(%d GiB) in storage class", requestGiB, minShareQuotaGiBField, minShareQuotaGiB)
+	}
+	return nil
+}
+
+// getShareQuotaBounds returns the share quota bounds of the storage class kept in file share metadata, 0 if not set
+func getShareQuotaBounds(metadata map[string]*string) (int, int, error) {
+	var bounds [2]int
+	for i, key := range []string{minShareQuotaMetadataKey, maxShareQuotaMetadataKey} {
+		value := getMetadataValue(metadata, key)
+		if value == "" {
+			continue
+		}
+		var err error
+		if bounds[i], err = strconv.Atoi(value); err != nil {
+			return 0, 0, fmt.Errorf("invalid share quota bound(%s=%s) in metadata", key, value)
+		}
+	}
+	return bounds[0], bounds[1], nil
+}
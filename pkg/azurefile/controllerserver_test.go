@@ -35,17 +35,22 @@ import (
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-03-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	azure2 "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/utils/pointer"
 
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/vmclient/mockvmclient"
@@ -296,13 +301,94 @@ func TestCreateVolume(t *testing.T) {
 						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 					})
 
-				expectedErr := status.Errorf(codes.InvalidArgument, "protocol(test_protocol) is not supported, supported protocol list: [smb nfs]")
+				expectedErr := status.Errorf(codes.InvalidArgument, "protocol(test_protocol) is not supported, supported protocol list: [smb nfs blobnfs]")
 				_, err := d.CreateVolume(ctx, req)
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("Unexpected error: %v", err)
 				}
 			},
 		},
+		{
+			name: "Invalid storage account name",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					storageAccountField: "storageaccountnamelongerthan24",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-invalid-account",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "invalid storageAccount(storageaccountnamelongerthan24) in storage class: length(30) must be between 3 and 24 characters")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Blob NFS protocol",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					protocolField: "blobnfs",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-blob-nfs",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "protocol(blobnfs) only supports mounting an existing blob container by static provisioning, use Azure Blob CSI driver(blob.csi.azure.com) to provision blob containers")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Dual protocol",
+			testFunc: func(t *testing.T) {
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-dual-protocol",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         map[string]string{protocolField: "smb,nfs"},
+				}
+
+				d := NewFakeDriver()
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "protocol(smb,nfs) is not supported, dual-protocol file share is not supported by Azure Files, create separate file shares with protocol smb and nfs")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
 		{
 			name: "Invalid accessTier",
 			testFunc: func(t *testing.T) {
@@ -603,21 +689,11 @@ func TestCreateVolume(t *testing.T) {
 			},
 		},
 		{
-			name: "Failed to update subnet service endpoints",
+			name: "clientID without storageAccount",
 			testFunc: func(t *testing.T) {
 				allParam := map[string]string{
-					protocolField: "nfs",
-				}
-
-				fakeCloud := &azure.Cloud{
-					Config: azure.Config{
-						ResourceGroup: "rg",
-						Location:      "loc",
-						VnetName:      "fake-vnet",
-						SubnetName:    "fake-subnet",
-					},
+					clientIDField: "clientID",
 				}
-				retErr := retry.NewError(false, fmt.Errorf("the subnet does not exist"))
 
 				req := &csi.CreateVolumeRequest{
 					Name:               "random-vol-name-vol-cap-invalid",
@@ -625,304 +701,2168 @@ func TestCreateVolume(t *testing.T) {
 					VolumeCapabilities: stdVolCap,
 					Parameters:         allParam,
 				}
-				ctx := context.Background()
-				d := NewFakeDriver()
-
-				d.cloud = fakeCloud
-				ctrl := gomock.NewController(t)
-				defer ctrl.Finish()
-				mockSubnetClient := mocksubnetclient.NewMockInterface(ctrl)
-				fakeCloud.SubnetsClient = mockSubnetClient
 
-				mockSubnetClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(network.Subnet{}, retErr).Times(1)
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{
+					Config: azure.Config{},
+				}
 
 				d.AddControllerServiceCapabilities(
 					[]csi.ControllerServiceCapability_RPC_Type{
 						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 					})
 
-				expectedErr := status.Errorf(codes.Internal, "update service endpoints failed with error: failed to get the subnet fake-subnet under vnet fake-vnet: &{false 0 0001-01-01 00:00:00 +0000 UTC the subnet does not exist}")
-				_, err := d.CreateVolume(ctx, req)
+				expectedErr := status.Errorf(codes.InvalidArgument, "storageAccount must be provided when clientID(clientID) is specified")
+				_, err := d.CreateVolume(context.Background(), req)
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("Unexpected error: %v", err)
 				}
 			},
 		},
 		{
-			name: "No valid key with zero request gib",
+			name: "allowSharedKeyAccess is false without storageAccount",
 			testFunc: func(t *testing.T) {
-				name := "baz"
-				sku := "sku"
-				kind := "StorageV2"
-				location := "centralus"
-				value := ""
-				account := storage.Account{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location}
-				accounts := []storage.Account{account}
-				keys := storage.AccountListKeysResult{
-					Keys: &[]storage.AccountKey{
-						{Value: &value},
-					},
-				}
-
 				allParam := map[string]string{
-					skuNameField:                      "premium",
-					locationField:                     "loc",
-					storageAccountField:               "",
-					resourceGroupField:                "rg",
-					shareNameField:                    "",
-					diskNameField:                     "diskname.vhd",
-					fsTypeField:                       "",
-					storeAccountKeyField:              "storeaccountkey",
-					secretNamespaceField:              "secretnamespace",
-					disableDeleteRetentionPolicyField: "true",
+					allowSharedKeyAccessField: "false",
 				}
 
 				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-no-valid-key",
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      stdCapRange,
 					VolumeCapabilities: stdVolCap,
-					CapacityRange:      zeroCapRange,
 					Parameters:         allParam,
 				}
 
 				d := NewFakeDriver()
-				d.cloud = &azure.Cloud{}
-
-				ctrl := gomock.NewController(t)
-				defer ctrl.Finish()
-
-				mockFileClient := mockfileclient.NewMockInterface(ctrl)
-				d.cloud.FileClient = mockFileClient
-
-				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
-				d.cloud.StorageAccountClient = mockStorageAccountsClient
-
-				fileServiceProperties := storage.FileServiceProperties{
-					FileServicePropertiesProperties: &storage.FileServicePropertiesProperties{},
+				d.cloud = &azure.Cloud{
+					Config: azure.Config{},
 				}
 
-				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-				mockFileClient.EXPECT().GetServiceProperties(context.TODO(), gomock.Any(), gomock.Any()).Return(fileServiceProperties, nil).AnyTimes()
-				mockFileClient.EXPECT().SetServiceProperties(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any()).Return(fileServiceProperties, nil).AnyTimes()
-
 				d.AddControllerServiceCapabilities(
 					[]csi.ControllerServiceCapability_RPC_Type{
 						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 					})
 
-				ctx := context.Background()
-				expectedErr := fmt.Errorf("no valid keys")
-
-				_, err := d.CreateVolume(ctx, req)
-				if !strings.Contains(err.Error(), expectedErr.Error()) {
+				expectedErr := status.Errorf(codes.InvalidArgument, "storageAccount must be provided when allowSharedKeyAccess is false")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("Unexpected error: %v", err)
 				}
 			},
 		},
 		{
-			name: "No valid key, check all params, with less than min premium volume",
+			name: "routing preference is not supported",
 			testFunc: func(t *testing.T) {
-				name := "baz"
-				sku := "sku"
-				kind := "StorageV2"
-				location := "centralus"
-				value := ""
-				accounts := []storage.Account{
-					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
-				}
-				keys := storage.AccountListKeysResult{
-					Keys: &[]storage.AccountKey{
-						{Value: &value},
+				tests := []struct {
+					params      map[string]string
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{routingPreferenceField: "AzureRouting"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "routingpreference(AzureRouting) is not supported, supported routingpreference list: [InternetRouting MicrosoftRouting]"),
+					},
+					{
+						params:      map[string]string{publishInternetEndpointsField: "yes"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid publishinternetendpoints: yes in storage class"),
+					},
+					{
+						params:      map[string]string{skuNameField: "Premium_LRS", routingPreferenceField: "InternetRouting"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "routing preference is only supported on standard storage account with SMB protocol, sku(Premium_LRS) protocol() is not supported"),
+					},
+					{
+						params:      map[string]string{protocolField: nfs, publishMicrosoftEndpointsField: "true"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "routing preference is only supported on standard storage account with SMB protocol, sku() protocol(nfs) is not supported"),
+					},
+					{
+						params:      map[string]string{storageAccountField: "account", routingPreferenceField: "MicrosoftRouting"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "routing preference is only applied to storage account created by driver, storageAccount and provisioner secrets are not supported"),
 					},
 				}
 
-				allParam := map[string]string{
-					skuNameField:         "premium",
-					locationField:        "loc",
-					storageAccountField:  "",
-					resourceGroupField:   "rg",
-					shareNameField:       "",
-					diskNameField:        "diskname.vhd",
-					fsTypeField:          "",
-					storeAccountKeyField: "storeaccountkey",
-					secretNamespaceField: "secretnamespace",
-				}
-
-				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-no-valid-key-check-all-params",
-					VolumeCapabilities: stdVolCap,
-					CapacityRange:      lessThanPremCapRange,
-					Parameters:         allParam,
-				}
-
-				d := NewFakeDriver()
-				d.cloud = &azure.Cloud{}
-
-				ctrl := gomock.NewController(t)
-				defer ctrl.Finish()
-
-				mockFileClient := mockfileclient.NewMockInterface(ctrl)
-				d.cloud.FileClient = mockFileClient
-
-				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
-				d.cloud.StorageAccountClient = mockStorageAccountsClient
-
-				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-
-				d.AddControllerServiceCapabilities(
-					[]csi.ControllerServiceCapability_RPC_Type{
-						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-					})
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-routing-preference",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+					}
 
-				ctx := context.Background()
-				expectedErr := fmt.Errorf("no valid keys")
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
 
-				_, err := d.CreateVolume(ctx, req)
-				if !strings.Contains(err.Error(), expectedErr.Error()) {
-					t.Errorf("Unexpected error: %v", err)
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("params: %v, unexpected error: %v, expected error: %v", test.params, err, test.expectedErr)
+					}
 				}
 			},
 		},
 		{
-			name: "Get file share returns error",
+			name: "public network access is not supported",
 			testFunc: func(t *testing.T) {
-				name := "baz"
-				sku := "sku"
-				kind := "StorageV2"
-				location := "centralus"
-				value := "foo bar"
-				accounts := []storage.Account{
-					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location, AccountProperties: &storage.AccountProperties{}},
-				}
-				keys := storage.AccountListKeysResult{
-					Keys: &[]storage.AccountKey{
-						{Value: &value},
+				tests := []struct {
+					params      map[string]string
+					secrets     map[string]string
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{publicNetworkAccessField: "SecuredByPerimeter"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid publicnetworkaccess: SecuredByPerimeter in storage class, supported values are [Disabled Enabled]"),
+					},
+					{
+						params:      map[string]string{publicNetworkAccessField: "disabled"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "publicnetworkaccess(Disabled) requires networkendpointtype: privateendpoint, otherwise the storage account could not be mounted"),
+					},
+					{
+						params:      map[string]string{protocolField: nfs, publicNetworkAccessField: "Disabled"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "publicnetworkaccess(Disabled) requires networkendpointtype: privateendpoint, otherwise the storage account could not be mounted"),
+					},
+					{
+						params:      map[string]string{publicNetworkAccessField: "Enabled"},
+						secrets:     map[string]string{"accountname": "account", "accountkey": "key"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "publicnetworkaccess is not supported with provisioner secrets since the storage account could not be checked"),
 					},
 				}
 
-				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-get-file-error",
-					VolumeCapabilities: stdVolCap,
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-public-network-access",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+						Secrets:            test.secrets,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("params: %v, unexpected error: %v, expected error: %v", test.params, err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "private endpoint resource group",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					params      map[string]string
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{privateEndpointResourceGroupField: "network-rg"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "privateendpointresourcegroup is only supported with networkendpointtype: privateendpoint"),
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-pe-rg",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("params: %v, unexpected error: %v, expected error: %v", test.params, err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "cloud config name",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					params      map[string]string
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{cloudConfigNameField: "germany"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "cloud config(germany) is not found, configured cloud configs: [china]"),
+					},
+					{
+						params:      map[string]string{storageAccountField: "account", clientIDField: "clientID", cloudConfigNameField: "china"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "clientID(clientID) is not supported with cloudConfigName(china)"),
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-cloud-config",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+					}
+
+					d := NewFakeDriver()
+					d.cloudConfigCloudMap = map[string]*azure.Cloud{"china": {}}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("params: %v, unexpected error: %v, expected error: %v", test.params, err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "create volume in additional cloud",
+			testFunc: func(t *testing.T) {
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				d := NewFakeDriver()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				chinaCloud := &azure.Cloud{FileClient: mockFileClient}
+				chinaCloud.SubscriptionID = "subs2"
+				chinaCloud.ResourceGroup = "rg2"
+				chinaCloud.Environment.StorageEndpointSuffix = "core.chinacloudapi.cn"
+				d.cloudConfigCloudMap = map[string]*azure.Cloud{"china": chinaCloud}
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				mockFileClient.EXPECT().WithSubscriptionID("subs2").Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg2", "account", "share", gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).Times(1)
+				mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg2", "account", gomock.Any(), gomock.Any()).Return(storage.FileShare{}, nil).Times(1)
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-cloud-config",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters: map[string]string{
+						cloudConfigNameField: "china",
+						storageAccountField:  "account",
+						shareNameField:       "share",
+						storeAccountKeyField: "false",
+					},
+				}
+				resp, err := d.CreateVolume(context.Background(), req)
+				assert.NoError(t, err)
+				assert.Equal(t, "rg2#account#share##random-vol-name-cloud-config#default#subs2#china", resp.Volume.VolumeId)
+				assert.Equal(t, "core.chinacloudapi.cn", resp.Volume.VolumeContext[storageEndpointSuffixField])
+				assert.Same(t, chinaCloud, d.getCloud("account"))
+			},
+		},
+		{
+			name: "unmanaged quota",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					params      map[string]string
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{unmanagedQuotaField: "yes"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid unmanagedquota: yes in storage class"),
+					},
+					{
+						params:      map[string]string{skuNameField: "Premium_LRS", unmanagedQuotaField: "true"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "unmanagedquota is only supported on standard storage account since premium file share is billed by provisioned quota, sku(Premium_LRS)"),
+					},
+					{
+						params:      map[string]string{fsTypeField: "ext4", unmanagedQuotaField: "true"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "unmanagedquota is not supported with fsType(ext4)"),
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-unmanaged-quota",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.enableVHDDiskFeature = true
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("params: %v, unexpected error: %v, expected error: %v", test.params, err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "data plane auth type",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					params      map[string]string
+					secrets     map[string]string
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{dataPlaneAuthTypeField: "token"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "dataPlaneAuthType(token) is not supported, supported dataPlaneAuthType list: [key oauth]"),
+					},
+					{
+						params:      map[string]string{dataPlaneAuthTypeField: "OAuth", useDataPlaneAPIField: "true"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "useDataPlaneAPI is not supported when dataPlaneAuthType is oauth, file share is created by management API with driver identity"),
+					},
+					{
+						params:      map[string]string{dataPlaneAuthTypeField: "oauth"},
+						secrets:     map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "key"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "provisioner secrets are not supported when dataPlaneAuthType is oauth, file share is created by management API with driver identity"),
+					},
+					{
+						params:      map[string]string{dataPlaneAuthTypeField: "oauth", fsTypeField: "ext4"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "fsType(ext4) is not supported when dataPlaneAuthType is oauth, vhd disk could only be created with account key"),
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-data-plane-auth-type",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+						Secrets:            test.secrets,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.enableVHDDiskFeature = true
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("params: %v, unexpected error: %v, expected error: %v", test.params, err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "invalid share ready timeout",
+			testFunc: func(t *testing.T) {
+				for _, v := range []string{"30", "-1s"} {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-share-ready-timeout",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         map[string]string{shareReadyTimeoutField: v},
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					expectedErr := status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid sharereadytimeout: %s in storage class", v))
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, expectedErr) {
+						t.Errorf("Unexpected error: %v, expected error: %v", err, expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "invalid encrypt in transit, compression, SMB leases or encryption scope",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					parameters  map[string]string
+					expectedErr error
+				}{
+					{
+						parameters:  map[string]string{encryptInTransitField: "yes"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid encryptintransit: yes in storage class"),
+					},
+					{
+						parameters:  map[string]string{encryptInTransitField: "true", protocolField: nfs},
+						expectedErr: status.Errorf(codes.InvalidArgument, "encryptintransit is only supported with SMB protocol"),
+					},
+					{
+						parameters:  map[string]string{enableCompressionField: "yes"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid enablecompression: yes in storage class"),
+					},
+					{
+						parameters:  map[string]string{enableCompressionField: "true", protocolField: nfs},
+						expectedErr: status.Errorf(codes.InvalidArgument, "enablecompression is only supported with SMB protocol"),
+					},
+					{
+						parameters:  map[string]string{disableSMBLeasesField: "yes"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid disablesmbleases: yes in storage class"),
+					},
+					{
+						parameters:  map[string]string{disableSMBLeasesField: "true", protocolField: nfs},
+						expectedErr: status.Errorf(codes.InvalidArgument, "disablesmbleases is only supported with SMB protocol"),
+					},
+					{
+						parameters:  map[string]string{"sourceAddress": "10.0.0.4,10.0.0.5"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid sourceaddress: 10.0.0.4,10.0.0.5 in storage class"),
+					},
+					{
+						parameters:  map[string]string{sourceAddressField: "eth1", protocolField: nfs},
+						expectedErr: status.Errorf(codes.InvalidArgument, "sourceaddress is only supported with SMB protocol"),
+					},
+					{
+						parameters:  map[string]string{"mountTenant": "team a"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid mounttenant: team a in storage class"),
+					},
+					{
+						parameters:  map[string]string{mountTenantField: "team-a", protocolField: nfs},
+						expectedErr: status.Errorf(codes.InvalidArgument, "mounttenant is only supported with SMB protocol"),
+					},
+					{
+						parameters:  map[string]string{"encryptionScope": "scope1"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "encryptionscope is not supported by Azure Files, encryption scopes only apply to blob storage, use requireinfraencryption or a customer-managed key of the storage account instead"),
+					},
+					{
+						parameters:  map[string]string{"snapshotBeforeDelete": "true"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "snapshotbeforedelete is not supported by Azure Files, share snapshots are deleted with the share, use ondeleterename or share soft delete of the storage account to keep data of deleted volumes instead"),
+					},
+				}
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-encrypt-in-transit",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.parameters,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("Unexpected error: %v, expected error: %v", err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "mount flags not supported by protocol",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					parameters  map[string]string
+					mountFlags  []string
+					expectedErr error
+				}{
+					{
+						mountFlags:  []string{"nfsvers=4.1"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid mount flags: mount option(nfsvers=4.1) is only supported with NFS protocol"),
+					},
+					{
+						parameters:  map[string]string{protocolField: nfs},
+						mountFlags:  []string{"dir_mode=0777,file_mode=0777"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid mount flags: mount option(dir_mode=0777) is only supported with SMB protocol"),
+					},
+					{
+						parameters:  map[string]string{enableMfsymlinksField: "false"},
+						mountFlags:  []string{"mfsymlinks"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid mount flags: mount option(mfsymlinks) conflicts with enablemfsymlinks(false)"),
+					},
+				}
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:          "random-vol-name-mount-flags",
+						CapacityRange: stdCapRange,
+						VolumeCapabilities: []*csi.VolumeCapability{
+							{
+								AccessType: &csi.VolumeCapability_Mount{
+									Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags},
+								},
+								AccessMode: &csi.VolumeCapability_AccessMode{
+									Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+								},
+							},
+						},
+						Parameters: test.parameters,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("Unexpected error: %v, expected error: %v", err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "invalid server address",
+			testFunc: func(t *testing.T) {
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-invalid-server",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         map[string]string{serverNameField: "account.file.core.windows.net/share"},
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "invalid server: account.file.core.windows.net/share, only IPv4 address or DNS name is supported")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v, expected error: %v", err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "allowSharedKeyAccess is false with useDataPlaneAPI",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					allowSharedKeyAccessField: "false",
+					storageAccountField:       "abc",
+					useDataPlaneAPIField:      "true",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{
+					Config: azure.Config{},
+				}
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "useDataPlaneAPI is not supported when allowSharedKeyAccess is false")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "onDeleteRename with useDataPlaneAPI",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					onDeleteRenameField:  "true",
+					useDataPlaneAPIField: "true",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{
+					Config: azure.Config{},
+				}
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "onDeleteRename is not supported with useDataPlaneAPI or provisioner secrets")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "forceCloseHandlesOnDelete validation",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					params      map[string]string
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{forceCloseHandlesOnDeleteField: "yes"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid forceclosehandlesondelete: yes in storage class"),
+					},
+					{
+						params:      map[string]string{forceCloseHandlesOnDeleteField: "true", useDataPlaneAPIField: "true"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "forceclosehandlesondelete is not supported with useDataPlaneAPI or provisioner secrets"),
+					},
+					{
+						params:      map[string]string{forceCloseHandlesOnDeleteField: "false", protocolField: nfs},
+						expectedErr: status.Errorf(codes.InvalidArgument, "forceclosehandlesondelete is only supported with SMB protocol"),
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-force-close-handles",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					assert.Equal(t, test.expectedErr, err, test.params)
+				}
+			},
+		},
+		{
+			name: "fsType conflicts with protocol",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					params      map[string]string
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{fsTypeField: cifs, protocolField: nfs},
+						expectedErr: status.Errorf(codes.InvalidArgument, "fsType(cifs) is not supported with protocol(nfs)"),
+					},
+					{
+						params:      map[string]string{fsTypeField: nfs, protocolField: smb},
+						expectedErr: status.Errorf(codes.InvalidArgument, "fsType(nfs) is not supported with protocol(smb)"),
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-fstype-protocol",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+					}
+
+					// file share fsType does not require VHD disk feature
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					assert.Equal(t, test.expectedErr, err, test.params)
+				}
+			},
+		},
+		{
+			name: "share quota bounds of storage class",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					params         map[string]string
+					expectedErr    error
+					expectedHandle string
+				}{
+					{
+						params:      map[string]string{maxShareQuotaGiBField: "0"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid maxsharequotagib: 0 in storage class"),
+					},
+					{
+						params:      map[string]string{minShareQuotaGiBField: "abc"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid minsharequotagib: abc in storage class"),
+					},
+					{
+						params:      map[string]string{minShareQuotaGiBField: "10", maxShareQuotaGiBField: "8"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "minsharequotagib(10) is larger than maxsharequotagib(8) in storage class"),
+					},
+					{
+						params:      map[string]string{maxShareQuotaGiBField: "4"},
+						expectedErr: status.Errorf(codes.OutOfRange, "requested share size(5 GiB) exceeds maxsharequotagib(4 GiB) in storage class"),
+					},
+					{
+						params:      map[string]string{minShareQuotaGiBField: "6"},
+						expectedErr: status.Errorf(codes.OutOfRange, "requested share size(5 GiB) is less than minsharequotagib(6 GiB) in storage class"),
+					},
+					{
+						params:      map[string]string{skuNameField: "Premium_LRS", maxShareQuotaGiBField: "50"},
+						expectedErr: status.Errorf(codes.OutOfRange, "minimum premium share size(100 GiB) exceeds maxsharequotagib(50 GiB) in storage class"),
+					},
+					{
+						params:         map[string]string{minShareQuotaGiBField: "5", maxShareQuotaGiBField: "5"},
+						expectedHandle: "rg#stoacc#random-vol-name-quota-bounds###default",
+					},
+				}
+
+				for _, test := range tests {
+					test.params[storageAccountField] = "stoacc"
+					test.params[resourceGroupField] = "rg"
+					test.params[storeAccountKeyField] = "false"
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-quota-bounds",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: stdVolCap,
+						Parameters:         test.params,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					ctrl := gomock.NewController(t)
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud.FileClient = mockFileClient
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).AnyTimes()
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).DoAndReturn(
+						func(ctx context.Context, resourceGroup, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+							// share quota bounds are kept in share metadata
+							assert.Equal(t, "5", pointer.StringDeref(shareOptions.Metadata[minShareQuotaMetadataKey], ""))
+							assert.Equal(t, "5", pointer.StringDeref(shareOptions.Metadata[maxShareQuotaMetadataKey], ""))
+							return storage.FileShare{}, nil
+						}).AnyTimes()
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					resp, err := d.CreateVolume(context.Background(), req)
+					assert.Equal(t, test.expectedErr, err, test.params)
+					if err == nil {
+						assert.Equal(t, test.expectedHandle, resp.Volume.VolumeId)
+					}
+					ctrl.Finish()
+				}
+			},
+		},
+		{
+			name: "Failed to update subnet service endpoints",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					protocolField: "nfs",
+				}
+
+				fakeCloud := &azure.Cloud{
+					Config: azure.Config{
+						ResourceGroup: "rg",
+						Location:      "loc",
+						VnetName:      "fake-vnet",
+						SubnetName:    "fake-subnet",
+					},
+				}
+				retErr := retry.NewError(false, fmt.Errorf("the subnet does not exist"))
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-vol-cap-invalid",
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCap,
+					Parameters:         allParam,
+				}
+				ctx := context.Background()
+				d := NewFakeDriver()
+
+				d.cloud = fakeCloud
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockSubnetClient := mocksubnetclient.NewMockInterface(ctrl)
+				fakeCloud.SubnetsClient = mockSubnetClient
+
+				mockSubnetClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(network.Subnet{}, retErr).Times(1)
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.Internal, "update service endpoints failed with error: failed to get the subnet fake-subnet under vnet fake-vnet: &{false 0 0001-01-01 00:00:00 +0000 UTC the subnet does not exist}")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "No valid key with zero request gib",
+			testFunc: func(t *testing.T) {
+				name := "baz"
+				sku := "sku"
+				kind := "StorageV2"
+				location := "centralus"
+				value := ""
+				account := storage.Account{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location}
+				accounts := []storage.Account{account}
+				keys := storage.AccountListKeysResult{
+					Keys: &[]storage.AccountKey{
+						{Value: &value},
+					},
+				}
+
+				allParam := map[string]string{
+					skuNameField:                      "premium",
+					locationField:                     "loc",
+					storageAccountField:               "",
+					resourceGroupField:                "rg",
+					shareNameField:                    "",
+					diskNameField:                     "diskname.vhd",
+					fsTypeField:                       "",
+					storeAccountKeyField:              "storeaccountkey",
+					secretNamespaceField:              "secretnamespace",
+					disableDeleteRetentionPolicyField: "true",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-no-valid-key",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      zeroCapRange,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+				fileServiceProperties := storage.FileServiceProperties{
+					FileServicePropertiesProperties: &storage.FileServicePropertiesProperties{},
+				}
+
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mockFileClient.EXPECT().GetServiceProperties(context.TODO(), gomock.Any(), gomock.Any()).Return(fileServiceProperties, nil).AnyTimes()
+				mockFileClient.EXPECT().SetServiceProperties(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any()).Return(fileServiceProperties, nil).AnyTimes()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				ctx := context.Background()
+				expectedErr := fmt.Errorf("no valid keys")
+
+				_, err := d.CreateVolume(ctx, req)
+				if !strings.Contains(err.Error(), expectedErr.Error()) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "No valid key, check all params, with less than min premium volume",
+			testFunc: func(t *testing.T) {
+				name := "baz"
+				sku := "sku"
+				kind := "StorageV2"
+				location := "centralus"
+				value := ""
+				accounts := []storage.Account{
+					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
+				}
+				keys := storage.AccountListKeysResult{
+					Keys: &[]storage.AccountKey{
+						{Value: &value},
+					},
+				}
+
+				allParam := map[string]string{
+					skuNameField:         "premium",
+					locationField:        "loc",
+					storageAccountField:  "",
+					resourceGroupField:   "rg",
+					shareNameField:       "",
+					diskNameField:        "diskname.vhd",
+					fsTypeField:          "",
+					storeAccountKeyField: "storeaccountkey",
+					secretNamespaceField: "secretnamespace",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-no-valid-key-check-all-params",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      lessThanPremCapRange,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				ctx := context.Background()
+				expectedErr := fmt.Errorf("no valid keys")
+
+				_, err := d.CreateVolume(ctx, req)
+				if !strings.Contains(err.Error(), expectedErr.Error()) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Get file share returns error",
+			testFunc: func(t *testing.T) {
+				name := "baz"
+				sku := "sku"
+				kind := "StorageV2"
+				location := "centralus"
+				value := "foo bar"
+				accounts := []storage.Account{
+					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location, AccountProperties: &storage.AccountProperties{}},
+				}
+				keys := storage.AccountListKeysResult{
+					Keys: &[]storage.AccountKey{
+						{Value: &value},
+					},
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-get-file-error",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      stdCapRange,
+					Parameters:         nil,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, fmt.Errorf("test error")).AnyTimes()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				ctx := context.Background()
+				expectedErr := status.Errorf(codes.Internal, "test error")
+
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("test name: %s, Unexpected error: %v, expected error: %v", name, err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "Create file share error tests",
+			testFunc: func(t *testing.T) {
+				name := "baz"
+				sku := "sku"
+				kind := "StorageV2"
+				location := "centralus"
+				value := "foo bar"
+				accounts := []storage.Account{
+					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
+				}
+				keys := storage.AccountListKeysResult{
+					Keys: &[]storage.AccountKey{
+						{Value: &value},
+					},
+				}
+
+				allParam := map[string]string{
+					storageAccountTypeField:           "premium",
+					locationField:                     "loc",
+					storageAccountField:               "stoacc",
+					resourceGroupField:                "rg",
+					shareNameField:                    "",
+					diskNameField:                     "diskname.vhd",
+					fsTypeField:                       "",
+					storeAccountKeyField:              "storeaccountkey",
+					secretNamespaceField:              "secretnamespace",
+					disableDeleteRetentionPolicyField: "true",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-crete-file-error",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      lessThanPremCapRange,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				d.cloud.CloudProviderBackoff = true
+				d.cloud.ResourceRequestBackoff = wait.Backoff{
+					Steps: 6,
+				}
+
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				ctx := context.Background()
+
+				expectedErr := status.Errorf(codes.Internal, "FileShareProperties or FileShareProperties.ShareQuota is nil")
+
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("test name: %s, Unexpected error: %v, expected error: %v", name, err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "existing file share quota is smaller than request quota",
+			testFunc: func(t *testing.T) {
+				name := "baz"
+				sku := "sku"
+				kind := "StorageV2"
+				location := "centralus"
+				value := "foo bar"
+				accounts := []storage.Account{
+					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
+				}
+				keys := storage.AccountListKeysResult{
+					Keys: &[]storage.AccountKey{
+						{Value: &value},
+					},
+				}
+
+				allParam := map[string]string{
+					storageAccountTypeField:           "premium",
+					locationField:                     "loc",
+					storageAccountField:               "stoacc",
+					resourceGroupField:                "rg",
+					shareNameField:                    "",
+					diskNameField:                     "diskname.vhd",
+					fsTypeField:                       "",
+					storeAccountKeyField:              "storeaccountkey",
+					secretNamespaceField:              "secretnamespace",
+					disableDeleteRetentionPolicyField: "true",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-crete-file-error",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      lessThanPremCapRange,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				d.cloud.CloudProviderBackoff = true
+				d.cloud.ResourceRequestBackoff = wait.Backoff{
+					Steps: 6,
+				}
+
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(1)}}, nil).AnyTimes()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				ctx := context.Background()
+
+				expectedErr := status.Errorf(codes.AlreadyExists, "request file share(random-vol-name-crete-file-error) already exists, but its capacity 1 is smaller than 100")
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("test name: %s, Unexpected error: %v, expected error: %v", name, err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "Create disk returns error",
+			testFunc: func(t *testing.T) {
+				skipIfTestingOnWindows(t)
+				name := "baz"
+				sku := "sku"
+				kind := "StorageV2"
+				location := "centralus"
+				value := "foo bar"
+				accounts := []storage.Account{
+					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
+				}
+				keys := storage.AccountListKeysResult{
+					Keys: &[]storage.AccountKey{
+						{Value: &value},
+					},
+				}
+
+				allParam := map[string]string{
+					skuNameField:            "premium",
+					storageAccountTypeField: "stoacctype",
+					locationField:           "loc",
+					storageAccountField:     "stoacc",
+					resourceGroupField:      "rg",
+					fsTypeField:             "ext4",
+					storeAccountKeyField:    "storeaccountkey",
+					secretNamespaceField:    "default",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-create-disk-error",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      lessThanPremCapRange,
+					Parameters:         allParam,
+				}
+
+				driverOptions := DriverOptions{
+					NodeID:               fakeNodeID,
+					DriverName:           DefaultDriverName,
+					EnableVHDDiskFeature: true,
+				}
+				d := NewFakeDriverCustomOptions(driverOptions)
+
+				d.cloud = &azure.Cloud{}
+				d.cloud.KubeClient = fake.NewSimpleClientset()
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				tests := []struct {
+					desc          string
+					fileSharename string
+					expectedErr   error
+				}{
+					{
+						desc:          "File share name empty",
+						fileSharename: "",
+						expectedErr:   status.Error(codes.Internal, "failed to create VHD disk: NewSharedKeyCredential(stoacc) failed with error: illegal base64 data at input byte 0"),
+					},
+					{
+						desc:          "File share name provided",
+						fileSharename: "filesharename",
+						expectedErr:   status.Error(codes.Internal, "failed to create VHD disk: NewSharedKeyCredential(stoacc) failed with error: illegal base64 data at input byte 0"),
+					},
+				}
+				for _, test := range tests {
+					allParam[shareNameField] = test.fileSharename
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud.FileClient = mockFileClient
+
+					mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+					d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+					mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+					mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
+					mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+					mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
+
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					ctx := context.Background()
+
+					_, err := d.CreateVolume(ctx, req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("Unexpected error: %v", err)
+					}
+				}
+			},
+		},
+		{
+			name: "Valid request",
+			testFunc: func(t *testing.T) {
+				name := "baz"
+				sku := "sku"
+				kind := "StorageV2"
+				location := "centralus"
+				value := "foo bar"
+				accounts := []storage.Account{
+					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
+				}
+				keys := storage.AccountListKeysResult{
+					Keys: &[]storage.AccountKey{
+						{Value: &value},
+					},
+				}
+
+				allParam := map[string]string{
+					skuNameField:            "premium",
+					storageAccountTypeField: "stoacctype",
+					locationField:           "loc",
+					storageAccountField:     "stoacc",
+					resourceGroupField:      "rg",
+					shareNameField:          "",
+					diskNameField:           "diskname.vhd",
+					fsTypeField:             "",
+					storeAccountKeyField:    "storeaccountkey",
+					secretNamespaceField:    "default",
+					mountPermissionsField:   "0755",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-valid-request",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      lessThanPremCapRange,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				d.cloud.KubeClient = fake.NewSimpleClientset()
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				ctx := context.Background()
+
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, nil) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "premium share size is rounded up to minimum size",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					skuNameField:         "Premium_LRS",
+					storageAccountField:  "stoacc",
+					resourceGroupField:   "rg",
+					storeAccountKeyField: "false",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-premium-round-up",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      stdCapRange,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+						if shareOptions.RequestGiB != minimumPremiumShareSize {
+							t.Errorf("unexpected share size: %d", shareOptions.RequestGiB)
+						}
+						return storage.FileShare{}, nil
+					})
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				resp, err := d.CreateVolume(context.Background(), req)
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				} else if resp.Volume.CapacityBytes != int64(minimumPremiumShareSize)*1024*1024*1024 {
+					t.Errorf("Unexpected capacity: %d", resp.Volume.CapacityBytes)
+				}
+			},
+		},
+		{
+			name: "default share quota is used without capacity range",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					sku                  string
+					defaultShareQuotaGiB int
+					expectedGiB          int
+					expectedBaselineIOPS string
+				}{
+					{
+						sku:         "Standard_LRS",
+						expectedGiB: defaultAzureFileQuota,
+					},
+					{
+						sku:                  "Standard_LRS",
+						defaultShareQuotaGiB: 10,
+						expectedGiB:          10,
+					},
+					{
+						sku:                  "Premium_LRS",
+						defaultShareQuotaGiB: 10,
+						expectedGiB:          minimumPremiumShareSize,
+						expectedBaselineIOPS: "3100",
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-default-quota",
+						VolumeCapabilities: stdVolCap,
+						Parameters: map[string]string{
+							skuNameField:         test.sku,
+							storageAccountField:  "stoacc",
+							resourceGroupField:   "rg",
+							storeAccountKeyField: "false",
+						},
+					}
+
+					d := NewFakeDriverCustomOptions(DriverOptions{
+						NodeID:               fakeNodeID,
+						DriverName:           DefaultDriverName,
+						DefaultShareQuotaGiB: test.defaultShareQuotaGiB,
+					})
+					d.cloud = &azure.Cloud{}
+
+					ctrl := gomock.NewController(t)
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud.FileClient = mockFileClient
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).AnyTimes()
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).
+						DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+							if shareOptions.RequestGiB != test.expectedGiB {
+								t.Errorf("sku(%s): unexpected share size: %d, expected: %d", test.sku, shareOptions.RequestGiB, test.expectedGiB)
+							}
+							return storage.FileShare{}, nil
+						})
+
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					resp, err := d.CreateVolume(context.Background(), req)
+					if err != nil {
+						t.Errorf("sku(%s): unexpected error: %v", test.sku, err)
+					} else if resp.Volume.CapacityBytes != int64(test.expectedGiB)*1024*1024*1024 {
+						t.Errorf("sku(%s): unexpected capacity: %d", test.sku, resp.Volume.CapacityBytes)
+					} else if resp.Volume.VolumeContext[baselineIOPSKey] != test.expectedBaselineIOPS {
+						t.Errorf("sku(%s): unexpected %s in volume context: %q", test.sku, baselineIOPSKey, resp.Volume.VolumeContext[baselineIOPSKey])
+					}
+					ctrl.Finish()
+				}
+			},
+		},
+		{
+			name: "below minimum capacity policy",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					policy        string
+					sku           string
+					capacityBytes int64
+					expectedGiB   int
+					expectedErr   error
+				}{
+					{
+						policy:        belowMinimumCapacityPolicyRoundUp,
+						sku:           "Premium_LRS",
+						capacityBytes: 10 * 1024 * 1024 * 1024,
+						expectedGiB:   minimumPremiumShareSize,
+					},
+					{
+						policy:        belowMinimumCapacityPolicyRoundUp,
+						sku:           "Standard_LRS",
+						capacityBytes: 512 * 1024 * 1024,
+						expectedGiB:   minimumStandardShareSize,
+					},
+					{
+						policy:        belowMinimumCapacityPolicyReject,
+						sku:           "Premium_LRS",
+						capacityBytes: 10 * 1024 * 1024 * 1024,
+						expectedErr:   status.Errorf(codes.OutOfRange, "requested capacity(10737418240 bytes) is below the minimum premium share size(100 GiB), request at least 100 GiB"),
+					},
+					{
+						policy:        belowMinimumCapacityPolicyReject,
+						sku:           "Standard_LRS",
+						capacityBytes: 512 * 1024 * 1024,
+						expectedErr:   status.Errorf(codes.OutOfRange, "requested capacity(536870912 bytes) is below the minimum standard share size(1 GiB), request at least 1 GiB"),
+					},
+					{
+						policy:        belowMinimumCapacityPolicyReject,
+						sku:           "Premium_LRS",
+						capacityBytes: 100 * 1024 * 1024 * 1024,
+						expectedGiB:   minimumPremiumShareSize,
+					},
+					{
+						policy:        belowMinimumCapacityPolicyReject,
+						sku:           "Standard_LRS",
+						capacityBytes: 10 * 1024 * 1024 * 1024,
+						expectedGiB:   10,
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-below-minimum",
+						VolumeCapabilities: stdVolCap,
+						CapacityRange:      &csi.CapacityRange{RequiredBytes: test.capacityBytes},
+						Parameters: map[string]string{
+							skuNameField:         test.sku,
+							storageAccountField:  "stoacc",
+							resourceGroupField:   "rg",
+							storeAccountKeyField: "false",
+						},
+					}
+
+					d := NewFakeDriverCustomOptions(DriverOptions{
+						NodeID:                     fakeNodeID,
+						DriverName:                 DefaultDriverName,
+						BelowMinimumCapacityPolicy: test.policy,
+					})
+					d.cloud = &azure.Cloud{}
+
+					ctrl := gomock.NewController(t)
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud.FileClient = mockFileClient
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).AnyTimes()
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).Return(storage.FileShare{}, nil).AnyTimes()
+
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					resp, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("policy(%s) sku(%s): unexpected error: %v, expected: %v", test.policy, test.sku, err, test.expectedErr)
+					} else if err == nil && resp.Volume.CapacityBytes != int64(test.expectedGiB)*1024*1024*1024 {
+						t.Errorf("policy(%s) sku(%s): unexpected capacity: %d", test.policy, test.sku, resp.Volume.CapacityBytes)
+					}
+					ctrl.Finish()
+				}
+			},
+		},
+		{
+			name: "create file share is retried on not found error after account creation",
+			testFunc: func(t *testing.T) {
+				originalBackoff := accountPropagationBackoff
+				defer func() { accountPropagationBackoff = originalBackoff }()
+				accountPropagationBackoff = wait.Backoff{Steps: 3, Duration: time.Millisecond, Factor: 1.0}
+
+				allParam := map[string]string{
+					skuNameField:         "Standard_LRS",
+					resourceGroupField:   "rg",
+					createAccountField:   "true",
+					storeAccountKeyField: "false",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-account-not-propagated",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      stdCapRange,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				value := base64.StdEncoding.EncodeToString([]byte("acc_key"))
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				setAccountCreateHookClient(d.cloud)
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), "rg", gomock.Any(), gomock.Any()).Return(nil).Times(1)
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "rg", gomock.Any()).
+					Return(storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: &value}}}, nil).AnyTimes()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).AnyTimes()
+				gomock.InOrder(
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", gomock.Any(), gomock.Any(), gomock.Any()).
+						Return(storage.FileShare{}, fmt.Errorf("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 404, RawError: ResourceNotFound")),
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", gomock.Any(), gomock.Any(), gomock.Any()).
+						Return(storage.FileShare{}, nil),
+				)
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				if _, err := d.CreateVolume(context.Background(), req); err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "not found error of an existing account is not retried",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					skuNameField:         "Standard_LRS",
+					storageAccountField:  "stoacc",
+					resourceGroupField:   "rg",
+					storeAccountKeyField: "false",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-account-not-found",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      stdCapRange,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).
+					Return(storage.FileShare{}, fmt.Errorf("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 404, RawError: ResourceNotFound")).Times(1)
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				if _, err := d.CreateVolume(context.Background(), req); status.Code(err) != codes.Internal {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "share name namespace isolates file shares of clusters sharing an account",
+			testFunc: func(t *testing.T) {
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				// file shares on account stoacc shared by both clusters
+				shares := map[string]storage.FileShare{}
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, resourceGroupName, accountName, name, expand string) (storage.FileShare, error) {
+						if share, ok := shares[name]; ok {
+							return share, nil
+						}
+						return storage.FileShare{}, fmt.Errorf("ShareNotFound")
+					}).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+						share := storage.FileShare{FileShareProperties: &storage.FileShareProperties{
+							ShareQuota: pointer.Int32(int32(shareOptions.RequestGiB)),
+							Metadata:   shareOptions.Metadata,
+						}}
+						shares[shareOptions.Name] = share
+						return share, nil
+					}).AnyTimes()
+
+				newDriver := func(namespace string) *Driver {
+					d := NewFakeDriverCustomOptions(DriverOptions{
+						NodeID:             fakeNodeID,
+						DriverName:         DefaultDriverName,
+						ShareNameNamespace: namespace,
+					})
+					d.cloud = &azure.Cloud{}
+					d.cloud.FileClient = mockFileClient
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+					return d
+				}
+				newRequest := func(shareName string) *csi.CreateVolumeRequest {
+					allParam := map[string]string{
+						storageAccountField:  "stoacc",
+						resourceGroupField:   "rg",
+						storeAccountKeyField: "false",
+					}
+					if shareName != "" {
+						allParam[shareNameField] = shareName
+					}
+					return &csi.CreateVolumeRequest{
+						Name:               "pvc-7ec5ff6e-7ae7-41dd-8a7e-1f5d1a1a6b3c",
+						VolumeCapabilities: stdVolCap,
+						CapacityRange:      stdCapRange,
+						Parameters:         allParam,
+					}
+				}
+				clusterA, clusterB := newDriver("cluster-a"), newDriver("cluster-b")
+
+				respA, err := clusterA.CreateVolume(context.Background(), newRequest(""))
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				respB, err := clusterB.CreateVolume(context.Background(), newRequest(""))
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				expectedVolumeIDs := []string{
+					"rg#stoacc#cluster-a-pvc-7ec5ff6e-7ae7-41dd-8a7e-1f5d1a1a6b3c###default",
+					"rg#stoacc#cluster-b-pvc-7ec5ff6e-7ae7-41dd-8a7e-1f5d1a1a6b3c###default",
+				}
+				if volumeIDs := []string{respA.Volume.VolumeId, respB.Volume.VolumeId}; !reflect.DeepEqual(volumeIDs, expectedVolumeIDs) {
+					t.Errorf("Unexpected volume IDs: %v", volumeIDs)
+				}
+				for name, namespace := range map[string]string{
+					"cluster-a-pvc-7ec5ff6e-7ae7-41dd-8a7e-1f5d1a1a6b3c": "cluster-a",
+					"cluster-b-pvc-7ec5ff6e-7ae7-41dd-8a7e-1f5d1a1a6b3c": "cluster-b",
+				} {
+					if value := getMetadataValue(shares[name].Metadata, shareNameNamespaceMetadataKey); value != namespace {
+						t.Errorf("Unexpected share name namespace(%s) of file share(%s)", value, name)
+					}
+				}
+
+				// explicit share name of the other cluster is not reused
+				if _, err := clusterA.CreateVolume(context.Background(), newRequest("myshare")); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if _, err := clusterA.CreateVolume(context.Background(), newRequest("myshare")); err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				expectedErr := status.Errorf(codes.AlreadyExists, "request file share(myshare) on account(stoacc) already exists in share name namespace(cluster-a), use another share name")
+				if _, err := clusterB.CreateVolume(context.Background(), newRequest("myshare")); !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "share name namespace is too long for share name prefix",
+			testFunc: func(t *testing.T) {
+				req := &csi.CreateVolumeRequest{
+					Name:               "pvc-7ec5ff6e-7ae7-41dd-8a7e-1f5d1a1a6b3c",
+					VolumeCapabilities: stdVolCap,
 					CapacityRange:      stdCapRange,
-					Parameters:         nil,
+					Parameters:         map[string]string{shareNamePrefixField: "myprefix-for-shares"},
+				}
+
+				d := NewFakeDriverCustomOptions(DriverOptions{
+					NodeID:             fakeNodeID,
+					DriverName:         DefaultDriverName,
+					ShareNameNamespace: "cluster-long-name",
+				})
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "file share name(cluster-long-name-myprefix-for-shares-pvc-7ec5ff6e-7ae7-41dd-8a7e-1f5d1a1a6b3c) with share name namespace(cluster-long-name) exceeds 63 characters, use a shorter shareNamePrefix")
+				if _, err := d.CreateVolume(context.Background(), req); !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "file share name held by soft-deleted share",
+			testFunc: func(t *testing.T) {
+				deletedShares := []storage.FileShareItem{
+					{Name: pointer.String("myshare"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(200), Deleted: pointer.Bool(true), Version: pointer.String("01D64EB9886F00C4")}},
+					{Name: pointer.String("othershare"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}},
+				}
+				renamedShare := getSoftDeletedShareRename("myshare", "01D64EB9886F00C4", deletedShares)
+				tests := []struct {
+					desc              string
+					restore           string
+					useDataPlaneAPI   bool
+					expectedErr       error
+					expectedShareName string
+					expectedShareGiB  int
+					expectedRestore   bool
+				}{
+					{
+						desc:        "invalid value",
+						restore:     "yes",
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid restoresoftdeletedshare: yes in storage class"),
+					},
+					{
+						desc:            "not supported with data plane API",
+						restore:         "true",
+						useDataPlaneAPI: true,
+						expectedErr:     status.Errorf(codes.InvalidArgument, "restoresoftdeletedshare is not supported with useDataPlaneAPI or provisioner secrets"),
+					},
+					{
+						desc:              "restore soft-deleted share",
+						restore:           "true",
+						expectedShareName: "myshare",
+						expectedShareGiB:  200,
+						expectedRestore:   true,
+					},
+					{
+						desc:              "use a new share name",
+						restore:           "false",
+						expectedShareName: renamedShare,
+						expectedShareGiB:  100,
+					},
+				}
+				for _, test := range tests {
+					params := map[string]string{
+						storageAccountField:          "stoacc",
+						resourceGroupField:           "rg",
+						shareNameField:               "myshare",
+						storeAccountKeyField:         "false",
+						restoreSoftDeletedShareField: test.restore,
+					}
+					if test.useDataPlaneAPI {
+						params[useDataPlaneAPIField] = "true"
+					}
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-soft-deleted-share",
+						VolumeCapabilities: stdVolCap,
+						CapacityRange:      &csi.CapacityRange{RequiredBytes: 100 << 30},
+						Parameters:         params,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					restored := false
+					d.restoreFileShare = func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup, accountName, shareName, deletedShareVersion string) error {
+						if shareName != "myshare" || deletedShareVersion != "01D64EB9886F00C4" {
+							t.Errorf("%s: unexpected restore of share(%s) version(%s)", test.desc, shareName, deletedShareVersion)
+						}
+						restored = true
+						return nil
+					}
+
+					ctrl := gomock.NewController(t)
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud.FileClient = mockFileClient
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, fmt.Errorf("ShareNotFound")).AnyTimes()
+					mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "stoacc", "", deletedExpand).Return(deletedShares, nil).AnyTimes()
+					mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "stoacc", gomock.Any(), gomock.Any()).
+						DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+							if shareOptions.Name != test.expectedShareName || shareOptions.RequestGiB != test.expectedShareGiB {
+								t.Errorf("%s: unexpected share(%s) size(%d)", test.desc, shareOptions.Name, shareOptions.RequestGiB)
+							}
+							return storage.FileShare{}, nil
+						}).AnyTimes()
+
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					resp, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("%s: Unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+					}
+					if restored != test.expectedRestore {
+						t.Errorf("%s: restored: %v, expected: %v", test.desc, restored, test.expectedRestore)
+					}
+					if err == nil && !strings.Contains(resp.Volume.VolumeId, "#"+test.expectedShareName+"#") {
+						t.Errorf("%s: unexpected volume ID: %s", test.desc, resp.Volume.VolumeId)
+					}
+					ctrl.Finish()
+				}
+			},
+		},
+		{
+			name: "allowed access modes",
+			testFunc: func(t *testing.T) {
+				rwxVolCap := []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+						AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+					},
+				}
+				tests := []struct {
+					parameters  map[string]string
+					expectedErr error
+				}{
+					{
+						parameters:  map[string]string{"allowedAccessModes": "ReadWriteOnce,RWX"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid allowedaccessmodes: ReadWriteOnce,RWX, supported access modes: [ReadWriteOnce ReadOnlyMany ReadWriteMany ReadWriteOncePod] in storage class"),
+					},
+					{
+						parameters:  map[string]string{"allowedAccessModes": "ReadWriteOnce,ReadWriteOncePod"},
+						expectedErr: status.Errorf(codes.InvalidArgument, "access mode ReadWriteMany is not allowed by allowedaccessmodes(ReadWriteOnce,ReadWriteOncePod) of storage class"),
+					},
+				}
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-allowed-access-modes",
+						CapacityRange:      stdCapRange,
+						VolumeCapabilities: rwxVolCap,
+						Parameters:         test.parameters,
+					}
+
+					d := NewFakeDriver()
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("Unexpected error: %v, expected error: %v", err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "geo-redundant sku validation",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					params      map[string]string
+					requestGiB  int64
+					expectedErr error
+				}{
+					{
+						params:      map[string]string{skuNameField: "Standard_GRS", protocolField: nfs},
+						requestGiB:  100,
+						expectedErr: status.Errorf(codes.InvalidArgument, "geo-redundant sku(Standard_GRS) is not supported with NFS protocol, NFS file share only supports premium LRS/ZRS sku"),
+					},
+					{
+						params:      map[string]string{skuNameField: "Standard_GZRS", enableLargeFileSharesField: "true"},
+						requestGiB:  100,
+						expectedErr: status.Errorf(codes.InvalidArgument, "large file shares is not supported on geo-redundant sku(Standard_GZRS), set enablelargefileshares as false or use LRS/ZRS sku"),
+					},
+					{
+						params:      map[string]string{skuNameField: "Standard_RAGRS"},
+						requestGiB:  maximumStandardShareSizeNoLFS + 1,
+						expectedErr: status.Errorf(codes.OutOfRange, "requested share size(5121 GiB) exceeds the maximum share size(5120 GiB) of geo-redundant sku(Standard_RAGRS), large file shares is not supported on geo-redundant account"),
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-geo-redundant",
+						VolumeCapabilities: stdVolCap,
+						CapacityRange:      &csi.CapacityRange{RequiredBytes: test.requestGiB * 1024 * 1024 * 1024},
+						Parameters:         test.params,
+					}
+
+					d := NewFakeDriverCustomOptions(DriverOptions{
+						NodeID:                    fakeNodeID,
+						DriverName:                DefaultDriverName,
+						AutoEnableLargeFileShares: true,
+					})
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("params: %v, unexpected error: %v, expected error: %v", test.params, err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "sku name validation",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					sku                 string
+					strictSkuValidation bool
+					expectedErr         error
+				}{
+					{
+						sku:                 "premium",
+						strictSkuValidation: true,
+						expectedErr:         status.Errorf(codes.InvalidArgument, "skuName(premium) is not supported, supported skuName list: %v", storage.PossibleSkuNameValues()),
+					},
+					{
+						sku:         "Premium_GRS",
+						expectedErr: status.Errorf(codes.InvalidArgument, "geo-redundant sku(Premium_GRS) is not supported on premium storage account, premium file share only supports LRS/ZRS sku"),
+					},
+					{
+						sku:                 "StandardV2_LRS",
+						strictSkuValidation: true,
+						expectedErr:         status.Errorf(codes.InvalidArgument, "skuName(StandardV2_LRS) is not supported, supported skuName list: %v", storage.PossibleSkuNameValues()),
+					},
+				}
+
+				for _, test := range tests {
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-sku-validation",
+						VolumeCapabilities: stdVolCap,
+						CapacityRange:      stdCapRange,
+						Parameters:         map[string]string{skuNameField: test.sku},
+					}
+
+					d := NewFakeDriverCustomOptions(DriverOptions{
+						NodeID:              fakeNodeID,
+						DriverName:          DefaultDriverName,
+						StrictSkuValidation: test.strictSkuValidation,
+					})
+					d.cloud = &azure.Cloud{}
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("sku: %s, unexpected error: %v, expected error: %v", test.sku, err, test.expectedErr)
+					}
+				}
+			},
+		},
+		{
+			name: "sku not known by the driver is passed to account create request",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					skuNameField:       "StandardV2_LRS",
+					resourceGroupField: "rg",
+					locationField:      "eastus",
+					createAccountField: "true",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-novel-sku",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      stdCapRange,
+					Parameters:         allParam,
 				}
 
 				d := NewFakeDriver()
 				d.cloud = &azure.Cloud{}
-
 				ctrl := gomock.NewController(t)
 				defer ctrl.Finish()
 
-				mockFileClient := mockfileclient.NewMockInterface(ctrl)
-				d.cloud.FileClient = mockFileClient
-
 				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
 				d.cloud.StorageAccountClient = mockStorageAccountsClient
-
-				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, fmt.Errorf("test error")).AnyTimes()
+				var createParams storage.AccountCreateParameters
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), "rg", gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+						createParams = parameters
+						return retry.NewError(false, fmt.Errorf("SkuNotSupported"))
+					})
 
 				d.AddControllerServiceCapabilities(
 					[]csi.ControllerServiceCapability_RPC_Type{
 						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 					})
 
-				ctx := context.Background()
-				expectedErr := status.Errorf(codes.Internal, "test error")
+				_, err := d.CreateVolume(context.Background(), req)
+				if err == nil || !strings.Contains(err.Error(), "failed to ensure storage account with sku(StandardV2_LRS) not known by the driver, check whether the sku is available in location(eastus)") ||
+					!strings.Contains(err.Error(), "SkuNotSupported") {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				if createParams.Sku == nil || createParams.Sku.Name != storage.SkuName("StandardV2_LRS") || createParams.Kind != storage.KindStorageV2 {
+					t.Errorf("unexpected sku or kind in account create request: %+v", createParams)
+				}
+			},
+		},
+		{
+			name: "share size exceeds the maximum size of sku",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					skuNameField:               "Standard_LRS",
+					storageAccountField:        "stoacc",
+					enableLargeFileSharesField: "false",
+				}
 
-				_, err := d.CreateVolume(ctx, req)
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-exceed-max-size",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      &csi.CapacityRange{RequiredBytes: int64(maximumStandardShareSizeNoLFS+1) * 1024 * 1024 * 1024},
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.OutOfRange, "requested share size(5121 GiB) exceeds the maximum share size(5120 GiB) of sku(Standard_LRS) without large file shares, set enablelargefileshares as true to increase the maximum share size to 102400 GiB")
+				_, err := d.CreateVolume(context.Background(), req)
 				if !reflect.DeepEqual(err, expectedErr) {
-					t.Errorf("test name: %s, Unexpected error: %v, expected error: %v", name, err, expectedErr)
+					t.Errorf("Unexpected error: %v", err)
 				}
 			},
 		},
 		{
-			name: "Create file share error tests",
+			name: "performance tier in pvc annotation is not allowed",
 			testFunc: func(t *testing.T) {
-				name := "baz"
-				sku := "sku"
-				kind := "StorageV2"
-				location := "centralus"
-				value := "foo bar"
-				accounts := []storage.Account{
-					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
+				allParam := map[string]string{
+					skuNameField:    "Standard_LRS",
+					pvcNameKey:      "pvc",
+					pvcNamespaceKey: "default",
 				}
-				keys := storage.AccountListKeysResult{
-					Keys: &[]storage.AccountKey{
-						{Value: &value},
-					},
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-performance-tier",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      stdCapRange,
+					Parameters:         allParam,
 				}
 
-				allParam := map[string]string{
-					storageAccountTypeField:           "premium",
-					locationField:                     "loc",
-					storageAccountField:               "stoacc",
-					resourceGroupField:                "rg",
-					shareNameField:                    "",
-					diskNameField:                     "diskname.vhd",
-					fsTypeField:                       "",
-					storeAccountKeyField:              "storeaccountkey",
-					secretNamespaceField:              "secretnamespace",
-					disableDeleteRetentionPolicyField: "true",
+				d := NewFakeDriverCustomOptions(DriverOptions{
+					NodeID:                  fakeNodeID,
+					DriverName:              DefaultDriverName,
+					AllowedPerformanceTiers: "Premium_LRS, Premium_ZRS",
+				})
+				d.cloud.KubeClient = fake.NewSimpleClientset(&v1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "pvc",
+						Namespace:   "default",
+						Annotations: map[string]string{performanceTierAnnotation: "Standard_GRS"},
+					},
+				})
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "performance tier(Standard_GRS) in pvc annotation(azurefile.csi/performance-tier) is not allowed, allowed performance tier list: [Premium_LRS Premium_ZRS]")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "context is cancelled while retrying to ensure storage account",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					skuNameField:       "Standard_LRS",
+					resourceGroupField: "rg",
+					createAccountField: "true",
 				}
 
 				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-crete-file-error",
+					Name:               "random-vol-name-context-cancelled",
 					VolumeCapabilities: stdVolCap,
-					CapacityRange:      lessThanPremCapRange,
+					CapacityRange:      stdCapRange,
 					Parameters:         allParam,
 				}
 
 				d := NewFakeDriver()
 				d.cloud = &azure.Cloud{}
-
+				d.cloud.CloudProviderBackoff = true
+				d.cloud.ResourceRequestBackoff = wait.Backoff{Steps: 5, Duration: time.Second}
 				ctrl := gomock.NewController(t)
 				defer ctrl.Finish()
 
-				mockFileClient := mockfileclient.NewMockInterface(ctrl)
-				d.cloud.FileClient = mockFileClient
-
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
 				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
 				d.cloud.StorageAccountClient = mockStorageAccountsClient
-				d.cloud.CloudProviderBackoff = true
-				d.cloud.ResourceRequestBackoff = wait.Backoff{
-					Steps: 6,
-				}
-
-				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), "rg", gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+						cancel()
+						return retry.NewError(true, fmt.Errorf(tooManyRequests))
+					}).Times(1)
 
 				d.AddControllerServiceCapabilities(
 					[]csi.ControllerServiceCapability_RPC_Type{
 						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 					})
 
-				ctx := context.Background()
-
-				expectedErr := status.Errorf(codes.Internal, "FileShareProperties or FileShareProperties.ShareQuota is nil")
-
+				start := time.Now()
 				_, err := d.CreateVolume(ctx, req)
-				if !reflect.DeepEqual(err, expectedErr) {
-					t.Errorf("test name: %s, Unexpected error: %v, expected error: %v", name, err, expectedErr)
+				if status.Code(err) != codes.Canceled {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				if elapsed := time.Since(start); elapsed > 5*time.Second {
+					t.Errorf("CreateVolume did not stop promptly after context is cancelled, elapsed: %v", elapsed)
 				}
 			},
 		},
 		{
-			name: "existing file share quota is smaller than request quota",
+			name: "invalid mountPermissions",
 			testFunc: func(t *testing.T) {
 				name := "baz"
 				sku := "sku"
@@ -939,20 +2879,11 @@ func TestCreateVolume(t *testing.T) {
 				}
 
 				allParam := map[string]string{
-					storageAccountTypeField:           "premium",
-					locationField:                     "loc",
-					storageAccountField:               "stoacc",
-					resourceGroupField:                "rg",
-					shareNameField:                    "",
-					diskNameField:                     "diskname.vhd",
-					fsTypeField:                       "",
-					storeAccountKeyField:              "storeaccountkey",
-					secretNamespaceField:              "secretnamespace",
-					disableDeleteRetentionPolicyField: "true",
+					mountPermissionsField: "0abc",
 				}
 
 				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-crete-file-error",
+					Name:               "random-vol-name-valid-request",
 					VolumeCapabilities: stdVolCap,
 					CapacityRange:      lessThanPremCapRange,
 					Parameters:         allParam,
@@ -960,6 +2891,7 @@ func TestCreateVolume(t *testing.T) {
 
 				d := NewFakeDriver()
 				d.cloud = &azure.Cloud{}
+				d.cloud.KubeClient = fake.NewSimpleClientset()
 
 				ctrl := gomock.NewController(t)
 				defer ctrl.Finish()
@@ -969,16 +2901,13 @@ func TestCreateVolume(t *testing.T) {
 
 				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
 				d.cloud.StorageAccountClient = mockStorageAccountsClient
-				d.cloud.CloudProviderBackoff = true
-				d.cloud.ResourceRequestBackoff = wait.Backoff{
-					Steps: 6,
-				}
 
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
 				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
 				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
 				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(1)}}, nil).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
 
 				d.AddControllerServiceCapabilities(
 					[]csi.ControllerServiceCapability_RPC_Type{
@@ -987,17 +2916,16 @@ func TestCreateVolume(t *testing.T) {
 
 				ctx := context.Background()
 
-				expectedErr := status.Errorf(codes.AlreadyExists, "request file share(random-vol-name-crete-file-error) already exists, but its capacity 1 is smaller than 100")
+				expectedErr := status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s %s in storage class", "mountPermissions", "0abc"))
 				_, err := d.CreateVolume(ctx, req)
 				if !reflect.DeepEqual(err, expectedErr) {
-					t.Errorf("test name: %s, Unexpected error: %v, expected error: %v", name, err, expectedErr)
+					t.Errorf("Unexpected error: %v", err)
 				}
 			},
 		},
 		{
-			name: "Create disk returns error",
+			name: "invalid parameter",
 			testFunc: func(t *testing.T) {
-				skipIfTestingOnWindows(t)
 				name := "baz"
 				sku := "sku"
 				kind := "StorageV2"
@@ -1013,83 +2941,52 @@ func TestCreateVolume(t *testing.T) {
 				}
 
 				allParam := map[string]string{
-					skuNameField:            "premium",
-					storageAccountTypeField: "stoacctype",
-					locationField:           "loc",
-					storageAccountField:     "stoacc",
-					resourceGroupField:      "rg",
-					fsTypeField:             "ext4",
-					storeAccountKeyField:    "storeaccountkey",
-					secretNamespaceField:    "default",
+					"invalidparameter": "invalidparameter",
 				}
 
 				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-create-disk-error",
+					Name:               "random-vol-name-valid-request",
 					VolumeCapabilities: stdVolCap,
 					CapacityRange:      lessThanPremCapRange,
 					Parameters:         allParam,
 				}
 
-				driverOptions := DriverOptions{
-					NodeID:               fakeNodeID,
-					DriverName:           DefaultDriverName,
-					EnableVHDDiskFeature: true,
-				}
-				d := NewFakeDriverCustomOptions(driverOptions)
-
+				d := NewFakeDriver()
 				d.cloud = &azure.Cloud{}
 				d.cloud.KubeClient = fake.NewSimpleClientset()
 
 				ctrl := gomock.NewController(t)
 				defer ctrl.Finish()
 
-				tests := []struct {
-					desc          string
-					fileSharename string
-					expectedErr   error
-				}{
-					{
-						desc:          "File share name empty",
-						fileSharename: "",
-						expectedErr:   status.Error(codes.Internal, "failed to create VHD disk: NewSharedKeyCredential(stoacc) failed with error: illegal base64 data at input byte 0"),
-					},
-					{
-						desc:          "File share name provided",
-						fileSharename: "filesharename",
-						expectedErr:   status.Error(codes.Internal, "failed to create VHD disk: NewSharedKeyCredential(stoacc) failed with error: illegal base64 data at input byte 0"),
-					},
-				}
-				for _, test := range tests {
-					allParam[shareNameField] = test.fileSharename
-					mockFileClient := mockfileclient.NewMockInterface(ctrl)
-					d.cloud.FileClient = mockFileClient
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud.FileClient = mockFileClient
 
-					mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
-					d.cloud.StorageAccountClient = mockStorageAccountsClient
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
 
-					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-					mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
-					mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
-					mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
-					mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-					mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
 
-					d.AddControllerServiceCapabilities(
-						[]csi.ControllerServiceCapability_RPC_Type{
-							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-						})
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
 
-					ctx := context.Background()
+				ctx := context.Background()
 
-					_, err := d.CreateVolume(ctx, req)
-					if !reflect.DeepEqual(err, test.expectedErr) {
-						t.Errorf("Unexpected error: %v", err)
-					}
+				expectedErr := status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid parameter %q in storage class", "invalidparameter"))
+				_, err := d.CreateVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
 				}
 			},
 		},
 		{
-			name: "Valid request",
+			name: "Account limit exceeded",
 			testFunc: func(t *testing.T) {
 				name := "baz"
 				sku := "sku"
@@ -1104,7 +3001,6 @@ func TestCreateVolume(t *testing.T) {
 						{Value: &value},
 					},
 				}
-
 				allParam := map[string]string{
 					skuNameField:            "premium",
 					storageAccountTypeField: "stoacctype",
@@ -1116,7 +3012,6 @@ func TestCreateVolume(t *testing.T) {
 					fsTypeField:             "",
 					storeAccountKeyField:    "storeaccountkey",
 					secretNamespaceField:    "default",
-					mountPermissionsField:   "0755",
 				}
 
 				req := &csi.CreateVolumeRequest{
@@ -1129,7 +3024,6 @@ func TestCreateVolume(t *testing.T) {
 				d := NewFakeDriver()
 				d.cloud = &azure.Cloud{}
 				d.cloud.KubeClient = fake.NewSimpleClientset()
-
 				ctrl := gomock.NewController(t)
 				defer ctrl.Finish()
 
@@ -1138,12 +3032,17 @@ func TestCreateVolume(t *testing.T) {
 
 				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
 				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				tagValue := "TestTagValue"
 
 				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+				first := mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, fmt.Errorf(accountLimitExceedManagementAPI))
+				second := mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil)
+				gomock.InOrder(first, second)
 				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
 				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
 				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.Account{Tags: map[string]*string{"TestKey": &tagValue}}, nil).AnyTimes()
+				mockStorageAccountsClient.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
 				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
 
 				d.AddControllerServiceCapabilities(
@@ -1160,198 +3059,282 @@ func TestCreateVolume(t *testing.T) {
 			},
 		},
 		{
-			name: "invalid mountPermissions",
+			name: "requireInfraEncryption on existing account without infrastructure encryption",
 			testFunc: func(t *testing.T) {
-				name := "baz"
-				sku := "sku"
-				kind := "StorageV2"
-				location := "centralus"
-				value := "foo bar"
-				accounts := []storage.Account{
-					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
+				allParam := map[string]string{
+					storageAccountField:         "stoacc",
+					resourceGroupField:          "rg",
+					requireInfraEncryptionField: "true",
 				}
-				keys := storage.AccountListKeysResult{
-					Keys: &[]storage.AccountKey{
-						{Value: &value},
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-infra-encryption",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      stdCapRange,
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				account := storage.Account{
+					AccountProperties: &storage.AccountProperties{
+						Encryption: &storage.Encryption{RequireInfrastructureEncryption: pointer.Bool(false)},
 					},
 				}
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "stoacc").Return(account, nil)
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
 
+				expectedErr := status.Errorf(codes.InvalidArgument, "infrastructure encryption is not enabled on storage account(stoacc), it could only be enabled when creating the account, remove storageAccount from storage class to let driver create a new account with requireinfraencryption")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "requireInfraEncryption is set in account create request",
+			testFunc: func(t *testing.T) {
 				allParam := map[string]string{
-					mountPermissionsField: "0abc",
+					skuNameField:                "Standard_LRS",
+					resourceGroupField:          "rg",
+					createAccountField:          "true",
+					requireInfraEncryptionField: "true",
 				}
 
 				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-valid-request",
+					Name:               "random-vol-name-infra-encryption-create",
 					VolumeCapabilities: stdVolCap,
-					CapacityRange:      lessThanPremCapRange,
+					CapacityRange:      stdCapRange,
 					Parameters:         allParam,
 				}
 
 				d := NewFakeDriver()
 				d.cloud = &azure.Cloud{}
-				d.cloud.KubeClient = fake.NewSimpleClientset()
-
 				ctrl := gomock.NewController(t)
 				defer ctrl.Finish()
 
-				mockFileClient := mockfileclient.NewMockInterface(ctrl)
-				d.cloud.FileClient = mockFileClient
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				var createParams storage.AccountCreateParameters
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), "rg", gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+						createParams = parameters
+						return retry.NewError(false, fmt.Errorf("test error"))
+					})
+
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				_, err := d.CreateVolume(context.Background(), req)
+				if err == nil || !strings.Contains(err.Error(), "test error") {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				if createParams.AccountPropertiesCreateParameters == nil || createParams.Encryption == nil ||
+					!pointer.BoolDeref(createParams.Encryption.RequireInfrastructureEncryption, false) {
+					t.Errorf("RequireInfrastructureEncryption is not set in account create request: %+v", createParams)
+				}
+			},
+		},
+		{
+			name: "large file shares is enabled in account create request when share size exceeds 5TiB",
+			testFunc: func(t *testing.T) {
+				allParam := map[string]string{
+					skuNameField:       "Standard_LRS",
+					resourceGroupField: "rg",
+					createAccountField: "true",
+				}
+
+				req := &csi.CreateVolumeRequest{
+					Name:               "random-vol-name-auto-enable-lfs",
+					VolumeCapabilities: stdVolCap,
+					CapacityRange:      &csi.CapacityRange{RequiredBytes: int64(maximumStandardShareSizeNoLFS+1) * 1024 * 1024 * 1024},
+					Parameters:         allParam,
+				}
+
+				d := NewFakeDriverCustomOptions(DriverOptions{
+					NodeID:                    fakeNodeID,
+					DriverName:                DefaultDriverName,
+					AutoEnableLargeFileShares: true,
+				})
+				d.cloud = &azure.Cloud{}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
 
 				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
 				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				var createParams storage.AccountCreateParameters
+				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), "rg", gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+						createParams = parameters
+						return retry.NewError(false, fmt.Errorf("test error"))
+					})
 
-				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					})
+
+				_, err := d.CreateVolume(context.Background(), req)
+				if err == nil || !strings.Contains(err.Error(), "test error") {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				if createParams.AccountPropertiesCreateParameters == nil ||
+					createParams.LargeFileSharesState != storage.LargeFileSharesStateEnabled {
+					t.Errorf("LargeFileSharesState is not enabled in account create request: %+v", createParams)
+				}
+			},
+		},
+		{
+			name: "allowBlobPublicAccess in account create request",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					desc                          string
+					driverAllowBlobPublicAccess   bool
+					allowBlobPublicAccess         string
+					expectedAllowBlobPublicAccess bool
+				}{
+					{
+						desc:                          "disabled by default",
+						expectedAllowBlobPublicAccess: false,
+					},
+					{
+						desc:                          "enabled by driver",
+						driverAllowBlobPublicAccess:   true,
+						expectedAllowBlobPublicAccess: true,
+					},
+					{
+						desc:                          "storage class overrides driver",
+						driverAllowBlobPublicAccess:   true,
+						allowBlobPublicAccess:         "false",
+						expectedAllowBlobPublicAccess: false,
+					},
+				}
+
+				for _, test := range tests {
+					allParam := map[string]string{
+						skuNameField:       "Standard_LRS",
+						resourceGroupField: "rg",
+						createAccountField: "true",
+					}
+					if test.allowBlobPublicAccess != "" {
+						allParam[allowBlobPublicAccessField] = test.allowBlobPublicAccess
+					}
 
-				d.AddControllerServiceCapabilities(
-					[]csi.ControllerServiceCapability_RPC_Type{
-						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+					req := &csi.CreateVolumeRequest{
+						Name:               "random-vol-name-blob-public-access",
+						VolumeCapabilities: stdVolCap,
+						CapacityRange:      stdCapRange,
+						Parameters:         allParam,
+					}
+
+					d := NewFakeDriverCustomOptions(DriverOptions{
+						NodeID:                fakeNodeID,
+						DriverName:            DefaultDriverName,
+						AllowBlobPublicAccess: test.driverAllowBlobPublicAccess,
 					})
+					d.cloud = &azure.Cloud{}
+					ctrl := gomock.NewController(t)
 
-				ctx := context.Background()
+					mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+					d.cloud.StorageAccountClient = mockStorageAccountsClient
+					var createParams storage.AccountCreateParameters
+					mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), "rg", gomock.Any(), gomock.Any()).
+						DoAndReturn(func(ctx context.Context, subsID, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+							createParams = parameters
+							return retry.NewError(false, fmt.Errorf("test error"))
+						})
 
-				expectedErr := status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid %s %s in storage class", "mountPermissions", "0abc"))
-				_, err := d.CreateVolume(ctx, req)
-				if !reflect.DeepEqual(err, expectedErr) {
-					t.Errorf("Unexpected error: %v", err)
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+
+					_, err := d.CreateVolume(context.Background(), req)
+					if err == nil || !strings.Contains(err.Error(), "test error") {
+						t.Errorf("test(%s): unexpected error: %v", test.desc, err)
+					}
+					if createParams.AccountPropertiesCreateParameters == nil || createParams.AllowBlobPublicAccess == nil ||
+						*createParams.AllowBlobPublicAccess != test.expectedAllowBlobPublicAccess {
+						t.Errorf("test(%s): AllowBlobPublicAccess is not %v in account create request: %+v", test.desc, test.expectedAllowBlobPublicAccess, createParams)
+					}
+					ctrl.Finish()
 				}
 			},
 		},
 		{
-			name: "invalid parameter",
+			name: "enforcePublicAccessPolicy rejects existing account allowing blob public access",
 			testFunc: func(t *testing.T) {
-				name := "baz"
-				sku := "sku"
-				kind := "StorageV2"
-				location := "centralus"
-				value := "foo bar"
-				accounts := []storage.Account{
-					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
-				}
-				keys := storage.AccountListKeysResult{
-					Keys: &[]storage.AccountKey{
-						{Value: &value},
-					},
-				}
-
 				allParam := map[string]string{
-					"invalidparameter": "invalidparameter",
+					storageAccountField:            "stoacc",
+					resourceGroupField:             "rg",
+					enforcePublicAccessPolicyField: "true",
 				}
 
 				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-valid-request",
+					Name:               "random-vol-name-public-access-policy",
 					VolumeCapabilities: stdVolCap,
-					CapacityRange:      lessThanPremCapRange,
+					CapacityRange:      stdCapRange,
 					Parameters:         allParam,
 				}
 
-				d := NewFakeDriver()
+				d := NewFakeDriverCustomOptions(DriverOptions{
+					NodeID:                            fakeNodeID,
+					DriverName:                        DefaultDriverName,
+					RejectPublicAccessPolicyViolation: true,
+				})
 				d.cloud = &azure.Cloud{}
-				d.cloud.KubeClient = fake.NewSimpleClientset()
-
 				ctrl := gomock.NewController(t)
 				defer ctrl.Finish()
 
-				mockFileClient := mockfileclient.NewMockInterface(ctrl)
-				d.cloud.FileClient = mockFileClient
-
 				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
 				d.cloud.StorageAccountClient = mockStorageAccountsClient
-
-				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
+				account := storage.Account{
+					AccountProperties: &storage.AccountProperties{AllowBlobPublicAccess: pointer.Bool(true)},
+				}
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "stoacc").Return(account, nil)
 
 				d.AddControllerServiceCapabilities(
 					[]csi.ControllerServiceCapability_RPC_Type{
 						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 					})
 
-				ctx := context.Background()
-
-				expectedErr := status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid parameter %q in storage class", "invalidparameter"))
-				_, err := d.CreateVolume(ctx, req)
+				expectedErr := status.Errorf(codes.FailedPrecondition, "storage account(stoacc) allows blob public access, which violates allowBlobPublicAccess(false) of storage class")
+				_, err := d.CreateVolume(context.Background(), req)
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("Unexpected error: %v", err)
 				}
 			},
 		},
 		{
-			name: "Account limit exceeded",
+			name: "invalid enforcePublicAccessPolicy",
 			testFunc: func(t *testing.T) {
-				name := "baz"
-				sku := "sku"
-				kind := "StorageV2"
-				location := "centralus"
-				value := "foo bar"
-				accounts := []storage.Account{
-					{Name: &name, Sku: &storage.Sku{Name: storage.SkuName(sku)}, Kind: storage.Kind(kind), Location: &location},
-				}
-				keys := storage.AccountListKeysResult{
-					Keys: &[]storage.AccountKey{
-						{Value: &value},
-					},
-				}
-				allParam := map[string]string{
-					skuNameField:            "premium",
-					storageAccountTypeField: "stoacctype",
-					locationField:           "loc",
-					storageAccountField:     "stoacc",
-					resourceGroupField:      "rg",
-					shareNameField:          "",
-					diskNameField:           "diskname.vhd",
-					fsTypeField:             "",
-					storeAccountKeyField:    "storeaccountkey",
-					secretNamespaceField:    "default",
-				}
-
 				req := &csi.CreateVolumeRequest{
-					Name:               "random-vol-name-valid-request",
+					Name:               "random-vol-name-public-access-policy",
 					VolumeCapabilities: stdVolCap,
-					CapacityRange:      lessThanPremCapRange,
-					Parameters:         allParam,
+					CapacityRange:      stdCapRange,
+					Parameters:         map[string]string{enforcePublicAccessPolicyField: "invalid"},
 				}
 
 				d := NewFakeDriver()
-				d.cloud = &azure.Cloud{}
-				d.cloud.KubeClient = fake.NewSimpleClientset()
-				ctrl := gomock.NewController(t)
-				defer ctrl.Finish()
-
-				mockFileClient := mockfileclient.NewMockInterface(ctrl)
-				d.cloud.FileClient = mockFileClient
-
-				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
-				d.cloud.StorageAccountClient = mockStorageAccountsClient
-				tagValue := "TestTagValue"
-
-				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				first := mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, fmt.Errorf(accountLimitExceedManagementAPI))
-				second := mockFileClient.EXPECT().CreateFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil)
-				gomock.InOrder(first, second)
-				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(accounts, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.Account{Tags: map[string]*string{"TestKey": &tagValue}}, nil).AnyTimes()
-				mockStorageAccountsClient.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
-				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
-
 				d.AddControllerServiceCapabilities(
 					[]csi.ControllerServiceCapability_RPC_Type{
 						csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 					})
 
-				ctx := context.Background()
-
-				_, err := d.CreateVolume(ctx, req)
-				if !reflect.DeepEqual(err, nil) {
+				expectedErr := status.Errorf(codes.InvalidArgument, "invalid enforcepublicaccesspolicy: invalid in storage class")
+				_, err := d.CreateVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("Unexpected error: %v", err)
 				}
 			},
@@ -1403,6 +3386,31 @@ func TestDeleteVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Unknown cloud config name in volume ID",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "rg#account#share##uuid#namespace#subsID#germany",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+
+				expectedErr := status.Errorf(codes.InvalidArgument, "cloud config(germany) is not found, configured cloud configs: []")
+				_, err := d.DeleteVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
 		{
 			name: "Invalid volume ID",
 			testFunc: func(t *testing.T) {
@@ -1448,7 +3456,7 @@ func TestDeleteVolume(t *testing.T) {
 				d.dataPlaneAPIAccountCache.Set("f5713de20cde511e8ba4900", "1")
 				d.cloud = &azure.Cloud{}
 
-				expectedErr := status.Errorf(codes.NotFound, "get account info from(vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd##secret) failed with error: could not get account key from secret(azure-storage-account-f5713de20cde511e8ba4900-secret): KubeClient is nil")
+				expectedErr := status.Errorf(codes.NotFound, "get account info from(vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd##secret) failed with error: could not get account key from secret(azure-storage-account-f5713de20cde511e8ba4900-secret) in namespace(secret): KubeClient is nil, namespace is resolved from secretnamespace")
 				_, err := d.DeleteVolume(ctx, req)
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("Unexpected error: %v", err)
@@ -1478,24 +3486,229 @@ func TestDeleteVolume(t *testing.T) {
 				d.cloud = &azure.Cloud{}
 				d.cloud.FileClient = mockFileClient
 				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, nil).AnyTimes()
 				mockFileClient.EXPECT().DeleteFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("test error")).Times(1)
 
-				expectedErr := status.Errorf(codes.Internal, "DeleteFileShare fileshare under account(f5713de20cde511e8ba4900) rg() failed with error: test error")
-				_, err := d.DeleteVolume(ctx, req)
-				if !reflect.DeepEqual(err, expectedErr) {
+				expectedErr := status.Errorf(codes.Internal, "DeleteFileShare fileshare under account(f5713de20cde511e8ba4900) rg() failed with error: test error")
+				_, err := d.DeleteVolume(ctx, req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "Valid request",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
+					Secrets:  map[string]string{},
+				}
+
+				ctx := context.Background()
+				d := NewFakeDriver()
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud = &azure.Cloud{}
+				d.cloud.FileClient = mockFileClient
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, nil).AnyTimes()
+				mockFileClient.EXPECT().DeleteFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+				expectedResp := &csi.DeleteSnapshotResponse{}
+				resp, err := d.DeleteVolume(ctx, req)
+				if !(reflect.DeepEqual(err, nil) || reflect.DeepEqual(resp, expectedResp)) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "backing resource is already deleted out-of-band",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
+					Secrets:  map[string]string{},
+				}
+				tests := []struct {
+					desc        string
+					getErr      error
+					deleteErr   error
+					expectedErr error
+				}{
+					{
+						desc:      "storage account is deleted",
+						getErr:    fmt.Errorf("storage.FileSharesClient#Delete: Failure responding to request: StatusCode=404 -- Original Error: autorest/azure: Service returned an error. Status=404 Code=\"ResourceNotFound\""),
+						deleteErr: fmt.Errorf("storage.FileSharesClient#Delete: Failure responding to request: StatusCode=404 -- Original Error: autorest/azure: Service returned an error. Status=404 Code=\"ResourceNotFound\""),
+					},
+					{
+						desc:      "resource group is deleted",
+						getErr:    fmt.Errorf("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 404, RawError: Code=\"ResourceGroupNotFound\" Message=\"Resource group 'vol_1' could not be found.\""),
+						deleteErr: fmt.Errorf("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 404, RawError: Code=\"ResourceGroupNotFound\" Message=\"Resource group 'vol_1' could not be found.\""),
+					},
+					{
+						desc:      "file share is deleted",
+						getErr:    fmt.Errorf("Status=404 ErrorCode=ShareNotFound"),
+						deleteErr: fmt.Errorf("Status=404 ErrorCode=ShareNotFound"),
+					},
+					{
+						desc:        "file share deletion is throttled",
+						deleteErr:   fmt.Errorf("Retriable: true, RetryAfter: 0s, HTTPStatusCode: 429, RawError: StatusCode=429 Code=\"TooManyRequests\""),
+						expectedErr: status.Errorf(codes.Unavailable, "DeleteFileShare fileshare under account(f5713de20cde511e8ba4900) rg(vol_1) is throttled, retry later: Retriable: true, RetryAfter: 0s, HTTPStatusCode: 429, RawError: StatusCode=429 Code=\"TooManyRequests\""),
+					},
+				}
+				for _, test := range tests {
+					d := NewFakeDriver()
+					d.Cap = []*csi.ControllerServiceCapability{
+						{
+							Type: &csi.ControllerServiceCapability_Rpc{
+								Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+							},
+						},
+					}
+					// the file share is not created by the driver
+					d.deleteUnownedShares = true
+					ctrl := gomock.NewController(t)
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud = &azure.Cloud{}
+					d.cloud.FileClient = mockFileClient
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, test.getErr).Times(1)
+					mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(test.deleteErr).Times(1)
+
+					_, err := d.DeleteVolume(context.Background(), req)
+					if !reflect.DeepEqual(err, test.expectedErr) {
+						t.Errorf("desc: %s, unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+					}
+					ctrl.Finish()
+				}
+			},
+		},
+		{
+			name: "throttled deletion is retried",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
+					Secrets:  map[string]string{},
+				}
+
+				d := NewFakeDriver()
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				d.deleteVolumeQPSPerAccount = 10
+				d.deleteVolumeBurstPerAccount = 5
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockFileClient := mockfileclient.NewMockInterface(ctrl)
+				d.cloud = &azure.Cloud{}
+				d.cloud.FileClient = mockFileClient
+				fileShare := storage.FileShare{FileShareProperties: &storage.FileShareProperties{Metadata: map[string]*string{createdByMetadataKey: pointer.String(createdByDriver)}}}
+				// cloud provider rejects requests with client throttled error until Retry-After of the 429 response expires
+				throttledErr := retry.GetThrottlingError("FileShareDelete", "client throttled", time.Now().Add(time.Minute)).Error()
+				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), "vol_1", "f5713de20cde511e8ba4900", "fileshare", "").Return(fileShare, nil).Times(2)
+				gomock.InOrder(
+					mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), "vol_1", "f5713de20cde511e8ba4900", "fileshare", "").Return(throttledErr).Times(1),
+					mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), "vol_1", "f5713de20cde511e8ba4900", "fileshare", "").Return(nil).Times(1),
+				)
+
+				_, err := d.DeleteVolume(context.Background(), req)
+				if status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), "client throttled") {
+					t.Errorf("Unexpected error of throttled attempt: %v", err)
+				}
+				if _, err := d.DeleteVolume(context.Background(), req); err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "storage account is already deleted when using data plane API",
+			testFunc: func(t *testing.T) {
+				req := &csi.DeleteVolumeRequest{
+					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
+				}
+
+				d := NewFakeDriver()
+				d.Cap = []*csi.ControllerServiceCapability{
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+						},
+					},
+				}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud = &azure.Cloud{}
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				d.dataPlaneAPIAccountCache.Set("f5713de20cde511e8ba4900", "")
+				listKeysErr := &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("Code=\"ResourceNotFound\"")}
+				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "vol_1", "f5713de20cde511e8ba4900").Return(storage.AccountListKeysResult{}, listKeysErr).Times(1)
+
+				resp, err := d.DeleteVolume(context.Background(), req)
+				if err != nil || !reflect.DeepEqual(resp, &csi.DeleteVolumeResponse{}) {
 					t.Errorf("Unexpected error: %v", err)
 				}
 			},
 		},
 		{
-			name: "Valid request",
+			name: "file share of another share name namespace is not deleted",
+			testFunc: func(t *testing.T) {
+				for _, namespace := range []string{"", "cluster-b"} {
+					req := &csi.DeleteVolumeRequest{
+						VolumeId: "vol_1#f5713de20cde511e8ba4900#cluster-a-pvc-1###",
+						Secrets:  map[string]string{},
+					}
+
+					d := NewFakeDriverCustomOptions(DriverOptions{
+						NodeID:             fakeNodeID,
+						DriverName:         DefaultDriverName,
+						ShareNameNamespace: namespace,
+					})
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						})
+					ctrl := gomock.NewController(t)
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud = &azure.Cloud{}
+					d.cloud.FileClient = mockFileClient
+					fileShare := storage.FileShare{
+						FileShareProperties: &storage.FileShareProperties{
+							Metadata: map[string]*string{shareNameNamespaceMetadataKey: pointer.String("cluster-a")},
+						},
+					}
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), "vol_1", "f5713de20cde511e8ba4900", "cluster-a-pvc-1", "").Return(fileShare, nil).Times(1)
+					// DeleteFileShare must not be called
+
+					expectedErr := status.Errorf(codes.FailedPrecondition, "file share(cluster-a-pvc-1) under account(f5713de20cde511e8ba4900) rg(vol_1) belongs to share name namespace(cluster-a), it could not be deleted by driver in share name namespace(%s)", namespace)
+					if _, err := d.DeleteVolume(context.Background(), req); !reflect.DeepEqual(err, expectedErr) {
+						t.Errorf("namespace(%s): unexpected error: %v", namespace, err)
+					}
+					ctrl.Finish()
+				}
+			},
+		},
+		{
+			name: "file share is already archived by onDeleteRename",
 			testFunc: func(t *testing.T) {
 				req := &csi.DeleteVolumeRequest{
 					VolumeId: "vol_1#f5713de20cde511e8ba4900#fileshare#diskname.vhd#",
 					Secrets:  map[string]string{},
 				}
 
-				ctx := context.Background()
 				d := NewFakeDriver()
 				d.Cap = []*csi.ControllerServiceCapability{
 					{
@@ -1509,12 +3722,20 @@ func TestDeleteVolume(t *testing.T) {
 				mockFileClient := mockfileclient.NewMockInterface(ctrl)
 				d.cloud = &azure.Cloud{}
 				d.cloud.FileClient = mockFileClient
+				fileShare := storage.FileShare{
+					FileShareProperties: &storage.FileShareProperties{
+						Metadata: map[string]*string{
+							onDeleteMetadataKey:     pointer.String(onDeleteArchive),
+							deletedByCSIMetadataKey: pointer.String("2022-10-01T00:00:00Z"),
+						},
+					},
+				}
 				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
-				mockFileClient.EXPECT().DeleteFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), "vol_1", "f5713de20cde511e8ba4900", "fileshare", "").Return(fileShare, nil).Times(1)
+				// DeleteFileShare must not be called
 
-				expectedResp := &csi.DeleteSnapshotResponse{}
-				resp, err := d.DeleteVolume(ctx, req)
-				if !(reflect.DeepEqual(err, nil) || reflect.DeepEqual(resp, expectedResp)) {
+				resp, err := d.DeleteVolume(context.Background(), req)
+				if err != nil || !reflect.DeepEqual(resp, &csi.DeleteVolumeResponse{}) {
 					t.Errorf("Unexpected error: %v", err)
 				}
 			},
@@ -1526,12 +3747,106 @@ func TestDeleteVolume(t *testing.T) {
 }
 
 func TestControllerGetVolume(t *testing.T) {
-	d := NewFakeDriver()
-	req := csi.ControllerGetVolumeRequest{}
-	resp, err := d.ControllerGetVolume(context.Background(), &req)
-	assert.Nil(t, resp)
-	if !reflect.DeepEqual(err, status.Error(codes.Unimplemented, "")) {
-		t.Errorf("Unexpected error: %v", err)
+	tests := []struct {
+		desc           string
+		volumeID       string
+		fileShare      storage.FileShare
+		getShareErr    error
+		account        storage.Account
+		getAccountErr  *retry.Error
+		expectedVolume *csi.Volume
+		expectedErr    error
+	}{
+		{
+			desc:        "volume ID missing",
+			expectedErr: status.Error(codes.InvalidArgument, "Volume ID missing in request"),
+		},
+		{
+			desc:        "invalid volume ID",
+			volumeID:    "vol_1",
+			expectedErr: status.Error(codes.NotFound, "GetFileShareInfo(vol_1) failed with error: error parsing volume id: \"vol_1\", should at least contain two #"),
+		},
+		{
+			desc:        "file share not found",
+			volumeID:    "rg#account#share",
+			getShareErr: fmt.Errorf("ShareNotFound"),
+			expectedErr: status.Error(codes.NotFound, "the requested volume(rg#account#share) does not exist."),
+		},
+		{
+			desc:        "get file share error",
+			volumeID:    "rg#account#share",
+			getShareErr: fmt.Errorf("test error"),
+			expectedErr: status.Error(codes.Internal, "failed to get file share(share) in account(account) rg(rg): test error"),
+		},
+		{
+			desc:      "standard file share",
+			volumeID:  "rg#account#share",
+			fileShare: storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), AccessTier: storage.ShareAccessTierHot}},
+			expectedVolume: &csi.Volume{VolumeId: "rg#account#share", CapacityBytes: 100 * 1024 * 1024 * 1024, VolumeContext: map[string]string{
+				shareProvisionedGiBKey: "100",
+				maxIOPSKey:             "1000",
+				maxThroughputMiBpsKey:  "60",
+			}},
+		},
+		{
+			desc:      "standard file share on account with large file shares enabled",
+			volumeID:  "rg#account#share",
+			fileShare: storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), AccessTier: storage.ShareAccessTierHot}},
+			account:   storage.Account{AccountProperties: &storage.AccountProperties{LargeFileSharesState: storage.LargeFileSharesStateEnabled}},
+			expectedVolume: &csi.Volume{VolumeId: "rg#account#share", CapacityBytes: 100 * 1024 * 1024 * 1024, VolumeContext: map[string]string{
+				shareProvisionedGiBKey: "100",
+				maxIOPSKey:             "20000",
+				maxThroughputMiBpsKey:  "300",
+			}},
+		},
+		{
+			desc:           "standard file share when failed to get storage account",
+			volumeID:       "rg#account#share",
+			fileShare:      storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), AccessTier: storage.ShareAccessTierHot}},
+			getAccountErr:  retry.NewError(false, fmt.Errorf("test error")),
+			expectedVolume: &csi.Volume{VolumeId: "rg#account#share", CapacityBytes: 100 * 1024 * 1024 * 1024, VolumeContext: map[string]string{}},
+		},
+		{
+			desc:      "standard file share larger than 5 TiB",
+			volumeID:  "rg#account#share",
+			fileShare: storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(10240), AccessTier: storage.ShareAccessTierTransactionOptimized}},
+			expectedVolume: &csi.Volume{VolumeId: "rg#account#share", CapacityBytes: 10240 * 1024 * 1024 * 1024, VolumeContext: map[string]string{
+				shareProvisionedGiBKey: "10240",
+				maxIOPSKey:             "20000",
+				maxThroughputMiBpsKey:  "300",
+			}},
+		},
+		{
+			desc:      "premium file share",
+			volumeID:  "rg#account#share",
+			fileShare: storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(1024), AccessTier: storage.ShareAccessTierPremium}},
+			expectedVolume: &csi.Volume{VolumeId: "rg#account#share", CapacityBytes: 1024 * 1024 * 1024 * 1024, VolumeContext: map[string]string{
+				shareProvisionedGiBKey: "1024",
+				baselineIOPSKey:        "4024",
+				burstIOPSKey:           "10000",
+				maxBurstCreditsKey:     "21513600",
+				throughputMiBpsKey:     "203",
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		d := NewFakeDriver()
+		d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_GET_VOLUME})
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d.cloud = &azure.Cloud{FileClient: mockFileClient, StorageAccountClient: mockStorageAccountsClient}
+		mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", gomock.Any()).Return(test.fileShare, test.getShareErr).AnyTimes()
+		mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "account").Return(test.account, test.getAccountErr).AnyTimes()
+
+		resp, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: test.volumeID})
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		if test.expectedErr == nil {
+			assert.Equal(t, test.expectedVolume, resp.GetVolume(), test.desc)
+		}
+		ctrl.Finish()
 	}
 }
 
@@ -1691,6 +4006,218 @@ func TestValidateVolumeCapabilities(t *testing.T) {
 	}
 }
 
+func TestValidateVolumeCapabilitiesAllowedAccessModes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.accountCacheMap.Set("account", "key")
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+	fakeShareQuota := int32(100)
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
+
+	volCap := func(mode csi.VolumeCapability_AccessMode_Mode) []*csi.VolumeCapability {
+		return []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+			},
+		}
+	}
+	tests := []struct {
+		desc            string
+		volumeContext   map[string]string
+		volCaps         []*csi.VolumeCapability
+		expectedErr     error
+		expectedMessage string
+		confirmed       bool
+	}{
+		{
+			desc:          "no allowedAccessModes",
+			volCaps:       volCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+			volumeContext: map[string]string{},
+			confirmed:     true,
+		},
+		{
+			desc:          "access mode is allowed",
+			volCaps:       volCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER),
+			volumeContext: map[string]string{"allowedAccessModes": "ReadWriteOnce,ReadOnlyMany"},
+			confirmed:     true,
+		},
+		{
+			desc:            "access mode is not allowed",
+			volCaps:         volCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+			volumeContext:   map[string]string{"allowedAccessModes": "ReadWriteOnce,ReadOnlyMany"},
+			expectedMessage: "access mode ReadWriteMany is not allowed by allowedaccessmodes(ReadWriteOnce,ReadOnlyMany) of storage class",
+		},
+		{
+			desc:          "invalid allowedAccessModes",
+			volCaps:       volCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+			volumeContext: map[string]string{"allowedAccessModes": "RWX"},
+			expectedErr:   status.Errorf(codes.InvalidArgument, "invalid allowedaccessmodes: RWX, supported access modes: [ReadWriteOnce ReadOnlyMany ReadWriteMany ReadWriteOncePod] in volume context"),
+		},
+	}
+
+	for _, test := range tests {
+		resp, err := d.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+			VolumeId:           "rg#account#share#",
+			VolumeCapabilities: test.volCaps,
+			VolumeContext:      test.volumeContext,
+		})
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+		if err != nil {
+			continue
+		}
+		if (resp.Confirmed != nil) != test.confirmed {
+			t.Errorf("test[%s]: unexpected confirmed: %v", test.desc, resp.Confirmed)
+		}
+		if resp.Message != test.expectedMessage {
+			t.Errorf("test[%s]: unexpected message: %s, expected: %s", test.desc, resp.Message, test.expectedMessage)
+		}
+	}
+}
+
+func TestGetAllowedAccessModes(t *testing.T) {
+	tests := []struct {
+		value         string
+		expectedModes []csi.VolumeCapability_AccessMode_Mode
+		expectedErr   error
+	}{
+		{
+			value:         "ReadWriteOncePod",
+			expectedModes: []csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER},
+		},
+		{
+			value: " readwriteonce , ReadOnlyMany",
+			expectedModes: []csi.VolumeCapability_AccessMode_Mode{
+				csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+				csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+				csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			},
+		},
+		{
+			value:       "",
+			expectedErr: fmt.Errorf("invalid allowedaccessmodes: , supported access modes: [ReadWriteOnce ReadOnlyMany ReadWriteMany ReadWriteOncePod]"),
+		},
+		{
+			value:       "ReadWriteOnce,MULTI_NODE_MULTI_WRITER",
+			expectedErr: fmt.Errorf("invalid allowedaccessmodes: ReadWriteOnce,MULTI_NODE_MULTI_WRITER, supported access modes: [ReadWriteOnce ReadOnlyMany ReadWriteMany ReadWriteOncePod]"),
+		},
+	}
+	for _, test := range tests {
+		modes, err := getAllowedAccessModes(test.value)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("value(%s): unexpected error: %v, expected error: %v", test.value, err, test.expectedErr)
+		}
+		if err != nil {
+			continue
+		}
+		if len(modes) != len(test.expectedModes) {
+			t.Errorf("value(%s): unexpected modes: %v", test.value, modes)
+		}
+		for _, mode := range test.expectedModes {
+			if !modes[mode] {
+				t.Errorf("value(%s): mode %v is not allowed", test.value, mode)
+			}
+		}
+	}
+}
+
+func TestValidateMountFlags(t *testing.T) {
+	volCaps := func(mountFlags ...string) []*csi.VolumeCapability {
+		return []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: mountFlags},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		desc             string
+		volCaps          []*csi.VolumeCapability
+		isNFS            bool
+		enableMfsymlinks bool
+		expectedErr      error
+	}{
+		{
+			desc:    "no mount flags",
+			volCaps: volCaps(),
+		},
+		{
+			desc:             "smb mount flags",
+			volCaps:          volCaps("dir_mode=0777,file_mode=0777", "uid=1000", "vers=3.1.1", "rshared"),
+			enableMfsymlinks: true,
+		},
+		{
+			desc:    "nfs mount flags",
+			volCaps: volCaps("nconnect=4", "nfsvers=4.1", "actimeo=30"),
+			isNFS:   true,
+		},
+		{
+			desc:        "nfs mount flag on smb",
+			volCaps:     volCaps("nconnect=4,nfsvers=4.1"),
+			expectedErr: fmt.Errorf("mount option(nfsvers=4.1) is only supported with NFS protocol"),
+		},
+		{
+			desc:        "nfs version on smb",
+			volCaps:     volCaps("vers=4.1"),
+			expectedErr: fmt.Errorf("mount option(vers=4.1) is only supported with NFS protocol"),
+		},
+		{
+			desc:        "smb mount flag on nfs",
+			volCaps:     volCaps("nconnect=4", "file_mode=0755"),
+			isNFS:       true,
+			expectedErr: fmt.Errorf("mount option(file_mode=0755) is only supported with SMB protocol"),
+		},
+		{
+			desc:        "owner mount flag on nfs",
+			volCaps:     volCaps("gid=1000"),
+			isNFS:       true,
+			expectedErr: getNFSOwnerMountOptionError("gid=1000"),
+		},
+		{
+			desc:        "unsupported nfs version",
+			volCaps:     volCaps("vers=3"),
+			isNFS:       true,
+			expectedErr: fmt.Errorf("nfs version(3) is not supported by Azure Files, supported nfs version list: [4 4.1]"),
+		},
+		{
+			desc:        "mfsymlinks is disabled",
+			volCaps:     volCaps("mfsymlinks"),
+			expectedErr: fmt.Errorf("mount option(mfsymlinks) conflicts with enablemfsymlinks(false)"),
+		},
+		{
+			desc:             "forceuid without uid",
+			volCaps:          volCaps("forceuid"),
+			enableMfsymlinks: true,
+			expectedErr:      fmt.Errorf("mount option(forceuid) requires uid in mount options"),
+		},
+		{
+			desc:        "conflicting mount propagation",
+			volCaps:     volCaps("rshared", "rslave"),
+			expectedErr: fmt.Errorf("mount propagation(rslave) conflicts with mount propagation(rshared)"),
+		},
+		{
+			desc:    "block volume",
+			volCaps: []*csi.VolumeCapability{{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}},
+			isNFS:   true,
+		},
+	}
+	for _, test := range tests {
+		err := validateMountFlags(test.volCaps, test.isNFS, test.enableMfsymlinks)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("desc(%s): unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+	}
+}
+
 func TestControllerPublishVolume(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -1784,7 +4311,7 @@ func TestControllerPublishVolume(t *testing.T) {
 				VolumeCapability: &stdVolCap,
 				NodeId:           "vm3",
 			},
-			expectedErr: status.Error(codes.InvalidArgument, "GetAccountInfo(vol_2#f5713de20cde511e8ba4900#fileshare#diskname.vhd) failed with error: Retriable: false, RetryAfter: 0s, HTTPStatusCode: 502, RawError: instance not found"),
+			expectedErr: status.Error(codes.InvalidArgument, "GetAccountInfo(vol_2#f5713de20cde511e8ba4900#fileshare#diskname.vhd) failed with error: failed to get key of account(f5713de20cde511e8ba4900) in subscription(subscription) rg(vol_2) with cluster identity: Retriable: false, RetryAfter: 0s, HTTPStatusCode: 502, RawError: instance not found"),
 		},
 		{
 			desc: "Unsupported access mode",
@@ -1882,7 +4409,7 @@ func TestControllerUnpublishVolume(t *testing.T) {
 				NodeId:   fakeNodeID,
 				Secrets:  map[string]string{},
 			},
-			expectedErr: status.Error(codes.InvalidArgument, "GetAccountInfo(vol_2#f5713de20cde511e8ba4901#fileshare#diskname.vhd#) failed with error: Retriable: false, RetryAfter: 0s, HTTPStatusCode: 502, RawError: instance not found"),
+			expectedErr: status.Error(codes.InvalidArgument, "GetAccountInfo(vol_2#f5713de20cde511e8ba4901#fileshare#diskname.vhd#) failed with error: failed to get key of account(f5713de20cde511e8ba4901) in subscription() rg(vol_2) with cluster identity: Retriable: false, RetryAfter: 0s, HTTPStatusCode: 502, RawError: instance not found"),
 		},
 		{
 			desc: "parse fileURLTemplate error",
@@ -1947,6 +4474,50 @@ func TestCreateSnapshot(t *testing.T) {
 			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
 		}
 	}
+
+	// a retry with the same snapshot name is rejected while the previous request is in progress
+	d.volumeLocks.TryAcquire("snapname")
+	defer d.volumeLocks.Release("snapname")
+	req := &csi.CreateSnapshotRequest{SourceVolumeId: "rg#account#share#", Name: "snapname"}
+	expectedErr := status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, "snapname")
+	if _, err := d.CreateSnapshot(context.Background(), req); !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("unexpected error: %v, expected error: %v", err, expectedErr)
+	}
+}
+
+func TestCreateSnapshotRetentionClass(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.allowedSnapshotRetentionClasses = []string{"daily", "weekly"}
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+
+	req := &csi.CreateSnapshotRequest{
+		SourceVolumeId: "rg#account#share#",
+		Name:           "snapname",
+		Parameters:     map[string]string{"retentionClass": "monthly"},
+	}
+	expectedErr := status.Errorf(codes.InvalidArgument, "retentionClass(monthly) is not allowed, allowed retentionClass list: [daily weekly]")
+	_, err := d.CreateSnapshot(context.Background(), req)
+	assert.Equal(t, expectedErr, err)
+
+	// retention class is written into snapshot metadata, unknown parameters are ignored
+	snapshotTime := date.Time{Time: time.Now()}
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", snapshotsExpand).Return(nil, nil)
+	mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "account", gomock.Any(), snapshotsExpand).DoAndReturn(
+		func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+			assert.Equal(t, "snapname", pointer.StringDeref(shareOptions.Metadata[snapshotNameKey], ""))
+			assert.Equal(t, "weekly", pointer.StringDeref(shareOptions.Metadata[retentionClassMetadataKey], ""))
+			return storage.FileShare{FileShareProperties: &storage.FileShareProperties{SnapshotTime: &snapshotTime}}, nil
+		})
+	req.Parameters = map[string]string{"retentionClass": "weekly", "csi.storage.k8s.io/volumesnapshot/name": "snap"}
+	resp, err := d.CreateSnapshot(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "rg#account#share##"+snapshotTime.Format(snapshotTimeFormat), resp.GetSnapshot().GetSnapshotId())
 }
 
 func TestDeleteSnapshot(t *testing.T) {
@@ -2093,6 +4664,50 @@ func TestControllerExpandVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Share quota bounds of storage class",
+			testFunc: func(t *testing.T) {
+				tests := []struct {
+					metadata    map[string]*string
+					expectedErr error
+				}{
+					{
+						metadata:    map[string]*string{maxShareQuotaMetadataKey: pointer.String("4")},
+						expectedErr: status.Errorf(codes.OutOfRange, "requested share size(5 GiB) exceeds maxsharequotagib(4 GiB) in storage class"),
+					},
+					{
+						metadata:    map[string]*string{minShareQuotaMetadataKey: pointer.String("6")},
+						expectedErr: status.Errorf(codes.OutOfRange, "requested share size(5 GiB) is less than minsharequotagib(6 GiB) in storage class"),
+					},
+					{
+						metadata:    map[string]*string{maxShareQuotaMetadataKey: pointer.String("abc")},
+						expectedErr: status.Errorf(codes.InvalidArgument, "invalid share quota bound(csimaxsharequotagib=abc) in metadata of file share(share) under account(account) rg(rg)"),
+					},
+				}
+
+				for _, test := range tests {
+					d := NewFakeDriver()
+					d.AddControllerServiceCapabilities(
+						[]csi.ControllerServiceCapability_RPC_Type{
+							csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+						})
+					d.cloud = &azure.Cloud{}
+					ctrl := gomock.NewController(t)
+					mockFileClient := mockfileclient.NewMockInterface(ctrl)
+					d.cloud.FileClient = mockFileClient
+					mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+					mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{Metadata: test.metadata}}, nil)
+
+					req := &csi.ControllerExpandVolumeRequest{
+						VolumeId:      "rg#account#share#",
+						CapacityRange: stdCapRange,
+					}
+					_, err := d.ControllerExpandVolume(context.Background(), req)
+					assert.Equal(t, test.expectedErr, err, test.metadata)
+					ctrl.Finish()
+				}
+			},
+		},
 		{
 			name: "Disk name not empty",
 			testFunc: func(t *testing.T) {
@@ -2131,6 +4746,38 @@ func TestControllerExpandVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "share size exceeds the maximum size of standard account without large file shares",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				d.AddControllerServiceCapabilities(
+					[]csi.ControllerServiceCapability_RPC_Type{
+						csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+					})
+				d.cloud = &azure.Cloud{}
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+				d.cloud.StorageAccountClient = mockStorageAccountsClient
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "account").Return(storage.Account{
+					Kind:              storage.KindStorageV2,
+					Sku:               &storage.Sku{Name: storage.SkuNameStandardLRS},
+					AccountProperties: &storage.AccountProperties{LargeFileSharesState: storage.LargeFileSharesStateDisabled},
+				}, nil)
+
+				req := &csi.ControllerExpandVolumeRequest{
+					VolumeId:      "rg#account#share#",
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(maximumStandardShareSizeNoLFS+1) * 1024 * 1024 * 1024},
+				}
+
+				expectedErr := status.Errorf(codes.OutOfRange, "requested share size(5121 GiB) exceeds the maximum share size(5120 GiB) of sku(Standard_LRS) without large file shares, set enablelargefileshares as true to increase the maximum share size to 102400 GiB")
+				_, err := d.ControllerExpandVolume(context.Background(), req)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("Unexpected error: %v, expected error: %v", err, expectedErr)
+				}
+			},
+		},
 		{
 			name: "Resize file share returns error",
 			testFunc: func(t *testing.T) {
@@ -2163,6 +4810,7 @@ func TestControllerExpandVolume(t *testing.T) {
 				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "vol_1", gomock.Any()).Return(key, nil).AnyTimes()
 				mockFileClient := mockfileclient.NewMockInterface(ctrl)
 				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, nil).AnyTimes()
 				mockFileClient.EXPECT().ResizeFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("test error")).AnyTimes()
 				d.cloud.FileClient = mockFileClient
 
@@ -2209,7 +4857,7 @@ func TestControllerExpandVolume(t *testing.T) {
 				d.cloud.Environment = azure2.Environment{StorageEndpointSuffix: "abc"}
 				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "vol_2", gomock.Any()).Return(key, &retry.Error{HTTPStatusCode: http.StatusBadGateway, RawError: cloudprovider.InstanceNotFound}).AnyTimes()
 
-				expectErr := status.Error(codes.NotFound, "get account info from(#f5713de20cde511e8ba4900#filename##secret) failed with error: Retriable: false, RetryAfter: 0s, HTTPStatusCode: 502, RawError: instance not found")
+				expectErr := status.Error(codes.NotFound, "get account info from(#f5713de20cde511e8ba4900#filename##secret) failed with error: failed to get key of account(f5713de20cde511e8ba4900) in subscription() rg(vol_2) with cluster identity: Retriable: false, RetryAfter: 0s, HTTPStatusCode: 502, RawError: instance not found")
 				_, err := d.ControllerExpandVolume(ctx, req)
 				if !reflect.DeepEqual(err, expectErr) {
 					t.Errorf("Unexpected error: %v", err)
@@ -2248,6 +4896,7 @@ func TestControllerExpandVolume(t *testing.T) {
 				mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "capz-d18sqm", gomock.Any()).Return(key, nil).AnyTimes()
 				mockFileClient := mockfileclient.NewMockInterface(ctrl)
 				mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+				mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{}, nil).AnyTimes()
 				mockFileClient.EXPECT().ResizeFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
 				shareQuota := int32(0)
 				mockFileClient.EXPECT().GetFileShare(context.TODO(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &shareQuota}}, nil).AnyTimes()
@@ -2433,6 +5082,51 @@ func TestSnapshotExists(t *testing.T) {
 			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
 		}
 	}
+
+	snapshotTime := date.Time{Time: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)}
+	snapshot := storage.FileShareItem{
+		Name:                &[]string{"share"}[0],
+		FileShareProperties: &storage.FileShareProperties{SnapshotTime: &snapshotTime, ShareQuota: pointer.Int32(10)},
+	}
+	tests2 := []struct {
+		desc           string
+		getErr         error
+		metadata       map[string]*string
+		expectedExists bool
+		expectedErr    error
+	}{
+		{
+			desc:           "snapshot with the same name exists",
+			metadata:       map[string]*string{snapshotNameKey: pointer.String("sname")},
+			expectedExists: true,
+		},
+		{
+			desc:     "snapshot with another name exists",
+			metadata: map[string]*string{snapshotNameKey: pointer.String("another")},
+		},
+		{
+			desc:        "failed to get snapshot",
+			getErr:      fmt.Errorf("test error"),
+			expectedErr: fmt.Errorf("get share(share) snapshot(2022-10-01T00:00:00.0000000Z) failed with test error"),
+		},
+	}
+	for _, test := range tests2 {
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+		mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", snapshotsExpand).Return([]storage.FileShareItem{snapshot}, nil).Times(1)
+		fileShare := storage.FileShare{Name: pointer.String("share"), FileShareProperties: &storage.FileShareProperties{Metadata: test.metadata}}
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "2022-10-01T00:00:00.0000000Z").Return(fileShare, test.getErr).Times(1)
+
+		exists, snapshotID, creationTime, quota, err := d.snapshotExists(context.Background(), "rg#account#share#", "sname", validSecret, false)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedExists, exists, test.desc)
+		if test.expectedExists {
+			assert.Equal(t, "2022-10-01T00:00:00.0000000Z", snapshotID, test.desc)
+			assert.Equal(t, snapshotTime.Time, creationTime, test.desc)
+			assert.Equal(t, int32(10), quota, test.desc)
+		}
+	}
 }
 
 func TestGetCapacity(t *testing.T) {
@@ -2541,3 +5235,93 @@ func TestSetAzureCredentials(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateVolumeStoreAccountKey(t *testing.T) {
+	value := base64.StdEncoding.EncodeToString([]byte("acc_key"))
+	keys := storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: &value}}}
+	secretName := fmt.Sprintf(secretNameTemplate, "stoacc")
+	fakeShareQuota := int32(100)
+	stdVolCap := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+
+	tests := []struct {
+		desc                   string
+		disableStoreAccountKey bool
+		parameters             map[string]string
+		expectSecret           bool
+	}{
+		{
+			desc:         "account key is stored in secret by default",
+			expectSecret: true,
+		},
+		{
+			desc:         "account key is not stored if storeAccountKey is false in storage class",
+			parameters:   map[string]string{storeAccountKeyField: "false"},
+			expectSecret: false,
+		},
+		{
+			desc:                   "account key is not stored if store-account-key is disabled",
+			disableStoreAccountKey: true,
+			expectSecret:           false,
+		},
+		{
+			desc:                   "storeAccountKey in storage class does not override store-account-key",
+			disableStoreAccountKey: true,
+			parameters:             map[string]string{storeAccountKeyField: "true"},
+			expectSecret:           false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			d := NewFakeDriverCustomOptions(DriverOptions{
+				NodeID:                 fakeNodeID,
+				DriverName:             DefaultDriverName,
+				DisableStoreAccountKey: test.disableStoreAccountKey,
+			})
+			d.cloud = &azure.Cloud{}
+			d.cloud.KubeClient = fake.NewSimpleClientset()
+			mockFileClient := mockfileclient.NewMockInterface(ctrl)
+			d.cloud.FileClient = mockFileClient
+			mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+			d.cloud.StorageAccountClient = mockStorageAccountsClient
+			d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+
+			mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+			mockFileClient.EXPECT().CreateFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+			mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
+			mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+
+			parameters := map[string]string{
+				storageAccountField: "stoacc",
+				resourceGroupField:  "rg",
+				shareNameField:      "share",
+			}
+			for k, v := range test.parameters {
+				parameters[k] = v
+			}
+			req := &csi.CreateVolumeRequest{
+				Name:               "random-vol-name-store-account-key",
+				VolumeCapabilities: stdVolCap,
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 10 * 1024 * 1024 * 1024},
+				Parameters:         parameters,
+			}
+			_, err := d.CreateVolume(context.Background(), req)
+			assert.NoError(t, err)
+
+			_, err = d.cloud.KubeClient.CoreV1().Secrets("default").Get(context.Background(), secretName, metav1.GetOptions{})
+			if test.expectSecret {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, apierrors.IsNotFound(err), "unexpected error: %v", err)
+			}
+		})
+	}
+}
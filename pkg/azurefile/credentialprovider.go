@@ -0,0 +1,173 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	credentialProviderAPIVersion   = "azurefile.csi.azure.com/v1"
+	credentialProviderRequestKind  = "CredentialProviderRequest"
+	credentialProviderResponseKind = "CredentialProviderResponse"
+
+	defaultCredentialProviderTimeout  = 10 * time.Second
+	defaultCredentialProviderCacheTTL = 5 * time.Minute
+	// maximum length of stderr of the credential provider plugin in the error
+	maxCredentialProviderStderrLength = 512
+)
+
+// credentialProviderRequest is written to stdin of the credential provider plugin
+type credentialProviderRequest struct {
+	APIVersion     string `json:"apiVersion"`
+	Kind           string `json:"kind"`
+	VolumeID       string `json:"volumeID"`
+	SubscriptionID string `json:"subscriptionID,omitempty"`
+	ResourceGroup  string `json:"resourceGroup,omitempty"`
+	AccountName    string `json:"accountName"`
+	FileShareName  string `json:"fileShareName,omitempty"`
+}
+
+// credentialProviderResponse is read from stdout of the credential provider plugin, cacheDuration overrides
+// the cache TTL of the driver if it's not empty, e.g. "10m", "0s" disables caching of the response
+type credentialProviderResponse struct {
+	APIVersion    string `json:"apiVersion"`
+	Kind          string `json:"kind"`
+	AccountKey    string `json:"accountKey"`
+	CacheDuration string `json:"cacheDuration,omitempty"`
+}
+
+type cachedCredential struct {
+	accountKey string
+	expiresAt  time.Time
+}
+
+// execCredentialProvider gets account keys by invoking an external plugin with the request on stdin and the
+// response on stdout, responses are cached per account
+type execCredentialProvider struct {
+	path     string
+	timeout  time.Duration
+	cacheTTL time.Duration
+	// run the plugin at path with stdin, returns stdout, it's replaced in tests
+	run func(ctx context.Context, path string, stdin []byte) ([]byte, error)
+
+	lock  sync.Mutex
+	cache map[string]cachedCredential
+}
+
+func newExecCredentialProvider(path string, timeout, cacheTTL time.Duration) *execCredentialProvider {
+	if timeout <= 0 {
+		timeout = defaultCredentialProviderTimeout
+	}
+	if cacheTTL < 0 {
+		cacheTTL = defaultCredentialProviderCacheTTL
+	}
+	return &execCredentialProvider{
+		path:     path,
+		timeout:  timeout,
+		cacheTTL: cacheTTL,
+		run:      runCredentialProvider,
+		cache:    map[string]cachedCredential{},
+	}
+}
+
+// getAccountKey returns the cached account key or invokes the plugin
+func (p *execCredentialProvider) getAccountKey(ctx context.Context, req credentialProviderRequest) (string, error) {
+	if req.AccountName == "" {
+		return "", fmt.Errorf("account name is empty")
+	}
+	key := strings.ToLower(req.AccountName)
+	p.lock.Lock()
+	if cached, ok := p.cache[key]; ok && time.Now().Before(cached.expiresAt) {
+		p.lock.Unlock()
+		return cached.accountKey, nil
+	}
+	p.lock.Unlock()
+
+	req.APIVersion = credentialProviderAPIVersion
+	req.Kind = credentialProviderRequestKind
+	stdin, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	stdout, err := p.run(ctx, p.path, stdin)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("credential provider(%s) did not respond in %v", p.path, p.timeout)
+		}
+		return "", fmt.Errorf("credential provider(%s) failed: %v", p.path, err)
+	}
+
+	resp := credentialProviderResponse{}
+	if err := json.Unmarshal(stdout, &resp); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response of credential provider(%s): %v", p.path, err)
+	}
+	if resp.APIVersion != credentialProviderAPIVersion || resp.Kind != credentialProviderResponseKind {
+		return "", fmt.Errorf("unexpected apiVersion(%s) or kind(%s) in response of credential provider(%s), expected: %s, %s",
+			resp.APIVersion, resp.Kind, p.path, credentialProviderAPIVersion, credentialProviderResponseKind)
+	}
+	if resp.AccountKey == "" {
+		return "", fmt.Errorf("accountKey is empty in response of credential provider(%s)", p.path)
+	}
+	ttl := p.cacheTTL
+	if resp.CacheDuration != "" {
+		if ttl, err = time.ParseDuration(resp.CacheDuration); err != nil || ttl < 0 {
+			return "", fmt.Errorf("invalid cacheDuration(%s) in response of credential provider(%s)", resp.CacheDuration, p.path)
+		}
+	}
+	if ttl > 0 {
+		p.lock.Lock()
+		p.cache[key] = cachedCredential{accountKey: resp.AccountKey, expiresAt: time.Now().Add(ttl)}
+		p.lock.Unlock()
+	}
+	klog.V(2).Infof("got key of account(%s) from credential provider(%s), cached for %v", req.AccountName, p.path, ttl)
+	return resp.AccountKey, nil
+}
+
+// invalidate removes the cached account key
+func (p *execCredentialProvider) invalidate(accountName string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.cache, strings.ToLower(accountName))
+}
+
+func runCredentialProvider(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxCredentialProviderStderrLength {
+			msg = msg[:maxCredentialProviderStderrLength] + "..."
+		}
+		return nil, fmt.Errorf("%v, stderr: %s", err, msg)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func fakeCredentialProviderResponse(accountKey, cacheDuration string) []byte {
+	resp, _ := json.Marshal(credentialProviderResponse{
+		APIVersion:    credentialProviderAPIVersion,
+		Kind:          credentialProviderResponseKind,
+		AccountKey:    accountKey,
+		CacheDuration: cacheDuration,
+	})
+	return resp
+}
+
+func TestExecCredentialProviderGetAccountKey(t *testing.T) {
+	p := newExecCredentialProvider("/plugin", 0, -1)
+	assert.Equal(t, defaultCredentialProviderTimeout, p.timeout)
+	assert.Equal(t, defaultCredentialProviderCacheTTL, p.cacheTTL)
+
+	calls := 0
+	p.run = func(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+		calls++
+		assert.Equal(t, "/plugin", path)
+		req := credentialProviderRequest{}
+		assert.NoError(t, json.Unmarshal(stdin, &req))
+		assert.Equal(t, credentialProviderRequest{
+			APIVersion:     credentialProviderAPIVersion,
+			Kind:           credentialProviderRequestKind,
+			VolumeID:       "rg#account#share",
+			SubscriptionID: "subs",
+			ResourceGroup:  "rg",
+			AccountName:    "account",
+			FileShareName:  "share",
+		}, req)
+		return fakeCredentialProviderResponse("key", ""), nil
+	}
+	req := credentialProviderRequest{VolumeID: "rg#account#share", SubscriptionID: "subs", ResourceGroup: "rg", AccountName: "account", FileShareName: "share"}
+
+	key, err := p.getAccountKey(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", key)
+	// cached per account
+	key, err = p.getAccountKey(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", key)
+	assert.Equal(t, 1, calls)
+
+	p.invalidate("ACCOUNT")
+	_, err = p.getAccountKey(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	// cacheDuration in response overrides cache TTL
+	p.invalidate("account")
+	p.run = func(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+		calls++
+		return fakeCredentialProviderResponse("key", "0s"), nil
+	}
+	for i := 0; i < 2; i++ {
+		_, err = p.getAccountKey(context.Background(), req)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 4, calls)
+	assert.Empty(t, p.cache)
+}
+
+func TestExecCredentialProviderGetAccountKeyError(t *testing.T) {
+	tests := []struct {
+		desc        string
+		accountName string
+		run         func(ctx context.Context, path string, stdin []byte) ([]byte, error)
+		expectedErr error
+	}{
+		{
+			desc:        "empty account name",
+			expectedErr: fmt.Errorf("account name is empty"),
+		},
+		{
+			desc:        "plugin failed",
+			accountName: "account",
+			run: func(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+				return nil, fmt.Errorf("exit status 1, stderr: vault is sealed")
+			},
+			expectedErr: fmt.Errorf("credential provider(/plugin) failed: exit status 1, stderr: vault is sealed"),
+		},
+		{
+			desc:        "timeout",
+			accountName: "account",
+			run: func(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			expectedErr: fmt.Errorf("credential provider(/plugin) did not respond in 10ms"),
+		},
+		{
+			desc:        "invalid response",
+			accountName: "account",
+			run: func(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+				return []byte("key"), nil
+			},
+			expectedErr: fmt.Errorf("failed to unmarshal response of credential provider(/plugin): invalid character 'k' looking for beginning of value"),
+		},
+		{
+			desc:        "unexpected kind",
+			accountName: "account",
+			run: func(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+				return []byte(`{"apiVersion":"azurefile.csi.azure.com/v1","kind":"Secret","accountKey":"key"}`), nil
+			},
+			expectedErr: fmt.Errorf("unexpected apiVersion(azurefile.csi.azure.com/v1) or kind(Secret) in response of credential provider(/plugin), expected: azurefile.csi.azure.com/v1, CredentialProviderResponse"),
+		},
+		{
+			desc:        "empty account key",
+			accountName: "account",
+			run: func(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+				return fakeCredentialProviderResponse("", ""), nil
+			},
+			expectedErr: fmt.Errorf("accountKey is empty in response of credential provider(/plugin)"),
+		},
+		{
+			desc:        "invalid cache duration",
+			accountName: "account",
+			run: func(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+				return fakeCredentialProviderResponse("key", "forever"), nil
+			},
+			expectedErr: fmt.Errorf("invalid cacheDuration(forever) in response of credential provider(/plugin)"),
+		},
+	}
+
+	for _, test := range tests {
+		p := newExecCredentialProvider("/plugin", 10*time.Millisecond, time.Minute)
+		p.run = test.run
+		_, err := p.getAccountKey(context.Background(), credentialProviderRequest{AccountName: test.accountName})
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Empty(t, p.cache, test.desc)
+	}
+}
+
+func TestRunCredentialProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip shell script plugin on Windows")
+	}
+	dir := t.TempDir()
+	plugin := filepath.Join(dir, "plugin")
+	script := "#!/bin/sh\ngrep -q '\"accountName\":\"account\"' || { echo unknown account >&2; exit 1; }\n" +
+		"echo '{\"apiVersion\":\"azurefile.csi.azure.com/v1\",\"kind\":\"CredentialProviderResponse\",\"accountKey\":\"key\"}'\n"
+	assert.NoError(t, os.WriteFile(plugin, []byte(script), 0755))
+
+	p := newExecCredentialProvider(plugin, time.Minute, time.Minute)
+	key, err := p.getAccountKey(context.Background(), credentialProviderRequest{AccountName: "account"})
+	assert.NoError(t, err)
+	assert.Equal(t, "key", key)
+
+	_, err = p.getAccountKey(context.Background(), credentialProviderRequest{AccountName: "other"})
+	assert.Equal(t, fmt.Errorf("credential provider(%s) failed: exit status 1, stderr: unknown account", plugin), err)
+}
+
+func TestGetAccountInfoWithCredentialProvider(t *testing.T) {
+	d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, CredentialProviderPath: "/plugin", CredentialProviderCacheTTL: time.Minute})
+	d.cloud = &azure.Cloud{}
+	d.cloud.KubeClient = fake.NewSimpleClientset()
+	d.credentialProvider.run = func(ctx context.Context, path string, stdin []byte) ([]byte, error) {
+		return fakeCredentialProviderResponse("provider-key", ""), nil
+	}
+
+	// account key is got from the credential provider instead of k8s secret
+	_, accountName, accountKey, fileShareName, _, _, err := d.GetAccountInfo(context.Background(), "rg#account#share", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"account", "provider-key", "share"}, []string{accountName, accountKey, fileShareName})
+	assert.Len(t, d.credentialProvider.cache, 1)
+
+	// cached key is removed on authentication failure
+	d.invalidateAccountKey("account", fmt.Errorf("mount error(13): Permission denied"))
+	assert.Empty(t, d.credentialProvider.cache)
+
+	// account key in secrets takes precedence
+	secrets := map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "secret-key"}
+	_, _, accountKey, _, _, _, err = d.GetAccountInfo(context.Background(), "rg#account#share", secrets, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-key", accountKey)
+
+	d = NewFakeDriver()
+	assert.Nil(t, d.credentialProvider)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+// checkCrossRegionMount returns an error if the account is in another region than the node and cross region mount
+// is not allowed. The check is skipped with a warning if the region of the node is unknown, while an error is
+// returned if the region of the account could not be read since the mount may go to another region.
+func (d *Driver) checkCrossRegionMount(ctx context.Context, subsID, resourceGroup, accountName string) error {
+	if d.allowCrossRegionMount || accountName == "" {
+		return nil
+	}
+	nodeRegion := d.getNodeRegion(ctx)
+	if nodeRegion == "" {
+		klog.Warningf("skip checking region of account(%s) since region of node(%s) is unknown", accountName, d.NodeID)
+		return nil
+	}
+	accountRegion, err := d.getAccountRegion(ctx, subsID, resourceGroup, accountName)
+	if err != nil {
+		return fmt.Errorf("could not verify account(%s) is in region(%s) of the node since cross region mount is not allowed: %v", accountName, nodeRegion, err)
+	}
+	if !strings.EqualFold(normalizeRegion(accountRegion), nodeRegion) {
+		return fmt.Errorf("account(%s) is in region(%s) while node(%s) is in region(%s), cross region mount is not allowed", accountName, accountRegion, d.NodeID, nodeRegion)
+	}
+	return nil
+}
+
+// getNodeRegion returns the region of the node from its topology label, or the location in cloud config,
+// it's cached once resolved
+func (d *Driver) getNodeRegion(ctx context.Context) string {
+	d.nodeRegionLock.Lock()
+	defer d.nodeRegionLock.Unlock()
+	if d.nodeRegion != "" {
+		return d.nodeRegion
+	}
+	var region string
+	if d.cloud.KubeClient != nil && d.NodeID != "" {
+		node, err := d.cloud.KubeClient.CoreV1().Nodes().Get(ctx, d.NodeID, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("failed to get node(%s): %v", d.NodeID, err)
+		} else {
+			region = node.Labels[v1.LabelTopologyRegion]
+		}
+	}
+	if region == "" {
+		region = d.cloud.Location
+	}
+	d.nodeRegion = normalizeRegion(region)
+	return d.nodeRegion
+}
+
+// getAccountRegion returns the cached region of the account, or reads it from account properties
+func (d *Driver) getAccountRegion(ctx context.Context, subsID, resourceGroup, accountName string) (string, error) {
+	cloud := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	if resourceGroup == "" {
+		resourceGroup = cloud.ResourceGroup
+	}
+	if subsID == "" {
+		subsID = cloud.SubscriptionID
+	}
+	key := strings.Join([]string{subsID, resourceGroup, accountName}, "/")
+	cache, err := d.accountRegionCache.Get(key, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return "", err
+	}
+	if cache != nil {
+		return cache.(string), nil
+	}
+
+	if cloud.StorageAccountClient == nil {
+		return "", fmt.Errorf("storage account client is nil")
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return "", fmt.Errorf("failed to get properties of account(%s) rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	region := pointer.StringDeref(account.Location, "")
+	if region == "" {
+		return "", fmt.Errorf("location of account(%s) rg(%s) is empty", accountName, resourceGroup)
+	}
+	d.accountRegionCache.Set(key, region)
+	return region, nil
+}
+
+// normalizeRegion returns the region name in lower case without spaces, e.g. eastus for "East US"
+func normalizeRegion(region string) string {
+	return strings.ToLower(strings.ReplaceAll(region, " ", ""))
+}
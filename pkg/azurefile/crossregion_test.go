@@ -0,0 +1,130 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestCheckCrossRegionMount(t *testing.T) {
+	tests := []struct {
+		desc                  string
+		allowCrossRegionMount bool
+		accountLocation       string
+		expectedErr           error
+	}{
+		{
+			desc:                  "matching region is allowed",
+			allowCrossRegionMount: true,
+			accountLocation:       "eastus",
+		},
+		{
+			desc:                  "mismatching region is allowed",
+			allowCrossRegionMount: true,
+			accountLocation:       "westus",
+		},
+		{
+			desc:            "matching region is not refused",
+			accountLocation: "eastus",
+		},
+		{
+			desc:            "mismatching region is refused",
+			accountLocation: "westus",
+			expectedErr:     fmt.Errorf("account(account) is in region(westus) while node(fakeNodeID) is in region(eastus), cross region mount is not allowed"),
+		},
+	}
+
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		d := NewFakeDriver()
+		d.allowCrossRegionMount = test.allowCrossRegionMount
+		d.cloud.KubeClient = fake.NewSimpleClientset(&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fakeNodeID, Labels: map[string]string{v1.LabelTopologyRegion: "eastus"}},
+		})
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d.cloud.StorageAccountClient = mockStorageAccountsClient
+		mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").
+			Return(storage.Account{Location: pointer.String(test.accountLocation)}, nil).MaxTimes(1)
+
+		// region of account is cached
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, test.expectedErr, d.checkCrossRegionMount(context.Background(), "subsID", "rg", "account"), test.desc)
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestCheckCrossRegionMountUnknownRegion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.allowCrossRegionMount = false
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	// skipped if region of node is unknown
+	assert.NoError(t, d.checkCrossRegionMount(context.Background(), "subsID", "rg", "account"))
+
+	// region in cloud config is used if node has no topology label
+	d.cloud.KubeClient = fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: fakeNodeID}})
+	d.cloud.Location = "East US"
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").
+		Return(storage.Account{}, &retry.Error{HTTPStatusCode: http.StatusForbidden, RawError: fmt.Errorf("AuthorizationFailed")}).Times(1)
+	assert.Equal(t, fmt.Errorf("could not verify account(account) is in region(eastus) of the node since cross region mount is not allowed: failed to get properties of account(account) rg(rg): Retriable: false, RetryAfter: 0s, HTTPStatusCode: 403, RawError: AuthorizationFailed"),
+		d.checkCrossRegionMount(context.Background(), "subsID", "rg", "account"))
+}
+
+func TestNodeStageVolumeCrossRegionMount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.allowCrossRegionMount = false
+	d.cloud.Location = "eastus"
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "account").
+		Return(storage.Account{Location: pointer.String("westus")}, nil).Times(1)
+
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "rg#account#share",
+		StagingTargetPath: "/tmp/staging",
+		VolumeCapability:  &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}},
+		VolumeContext:     map[string]string{},
+		Secrets:           map[string]string{"accountname": "account", "accountkey": "key"},
+	}
+	_, err := d.NodeStageVolume(context.Background(), req)
+	assert.Equal(t, status.Error(codes.FailedPrecondition, "volume(rg#account#share): account(account) is in region(westus) while node(fakeNodeID) is in region(eastus), cross region mount is not allowed"), err)
+}
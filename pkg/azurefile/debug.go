@@ -0,0 +1,309 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	stagedVolumesPath = "/debug/staged-volumes"
+	mountCommandsPath = "/debug/mount-commands"
+	// value of sensitive mount options in recorded mount commands
+	maskedMountOption = "<masked>"
+	// a hung SMB/NFS mount could block stat forever, give up the mount health check after this timeout
+	stagedVolumeHealthCheckTimeout = 5 * time.Second
+
+	mountHealthy     = "healthy"
+	mountNotMounted  = "not mounted"
+	mountUnreachable = "unreachable"
+)
+
+// stagedVolume is a volume staged by NodeStageVolume on this node
+type stagedVolume struct {
+	VolumeID    string    `json:"volumeID"`
+	NodeID      string    `json:"nodeID"`
+	StagingPath string    `json:"stagingPath"`
+	Source      string    `json:"source"`
+	Protocol    string    `json:"protocol"`
+	StagedAt    time.Time `json:"stagedAt"`
+	MountHealth string    `json:"mountHealth,omitempty"`
+	// sorted mount options (without sensitive options) the volume is staged with
+	mountOptions []string
+	// statfs on the mount taking longer than probeTimeout means the mount is hung
+	probeTimeout time.Duration
+	// file share whose quota and usage are reported by NodeGetVolumeStats, nil if statfs is reported
+	shareStats *shareStatsSource
+	// file share mounted by the volume for single writer access mode check, nil for vhd disk
+	share *stagedShare
+	// volume context the volume is staged with, it's used by mount probe if the PV could not be found
+	volumeContext map[string]string
+}
+
+// mountCommand is the last mount command run by NodeStageVolume for a volume, with secrets redacted
+type mountCommand struct {
+	VolumeID  string    `json:"volumeID"`
+	Command   string    `json:"command"`
+	MountedAt time.Time `json:"mountedAt"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// sensitive keys of mount options whose values are redacted in recorded mount commands, e.g. account key,
+// SAS token signature and Kerberos credentials
+var sensitiveMountOptionKeys = []string{"password", "password2", "pass", "sig", "sas", "accountkey", "krb5ccname", "keytab"}
+
+// recordMountCommand stores the mount command of volume with sensitive options redacted,
+// it only records the command, mount behavior is not changed
+func (d *Driver) recordMountCommand(volumeID, source, target, fsType string, options, sensitiveOptions []string, mountErr error) {
+	cmd := mountCommand{
+		VolumeID:  volumeID,
+		Command:   getRedactedMountCommand(source, target, fsType, options, sensitiveOptions),
+		MountedAt: time.Now(),
+	}
+	if mountErr != nil {
+		cmd.Error = mountErr.Error()
+	}
+	klog.V(4).Infof("volume(%s) mount command: %s", volumeID, cmd.Command)
+	d.mountCommands.Store(volumeID, cmd)
+}
+
+// getRedactedMountCommand returns the mount command in the same format as mount-utils logs, every
+// sensitive option is replaced with <masked> and values of sensitive keys in options are redacted
+func getRedactedMountCommand(source, target, fsType string, options, sensitiveOptions []string) string {
+	var redacted []string
+	for _, option := range options {
+		for _, v := range strings.Split(option, ",") {
+			if kv := strings.SplitN(v, "=", 2); len(kv) == 2 && isSensitiveMountOptionKey(kv[0]) {
+				v = kv[0] + "=" + maskedMountOption
+			}
+			redacted = append(redacted, v)
+		}
+	}
+	for range sensitiveOptions {
+		redacted = append(redacted, maskedMountOption)
+	}
+	args := []string{"mount"}
+	if fsType != "" {
+		args = append(args, "-t", fsType)
+	}
+	if len(redacted) > 0 {
+		args = append(args, "-o", strings.Join(redacted, ","))
+	}
+	return strings.Join(append(args, source, target), " ")
+}
+
+func isSensitiveMountOptionKey(key string) bool {
+	key = strings.TrimSpace(key)
+	for _, v := range sensitiveMountOptionKeys {
+		if strings.EqualFold(key, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordStagedVolume stores the staged volume keyed by staging path, the same share could be
+// staged on different staging paths of one node, e.g. by PVs with different volumeHandles
+func (d *Driver) recordStagedVolume(volumeID, stagingPath, source, protocol string, mountOptions []string, probeTimeout time.Duration) {
+	if protocol == "" {
+		protocol = smb
+	}
+	d.stagedVolumes.Store(stagingPath, stagedVolume{
+		VolumeID:    volumeID,
+		NodeID:      d.NodeID,
+		StagingPath: stagingPath,
+		Source:      source,
+		Protocol:    protocol,
+		StagedAt:    time.Now(),
+
+		mountOptions: sortMountOptions(mountOptions),
+		probeTimeout: probeTimeout,
+	})
+}
+
+// getStagedVolume returns the volume staged on stagingPath, or the first volume staged with volumeID
+// if stagingPath is empty or not recorded
+func (d *Driver) getStagedVolume(volumeID, stagingPath string) (stagedVolume, bool) {
+	if stagingPath != "" {
+		if v, ok := d.stagedVolumes.Load(stagingPath); ok {
+			return v.(stagedVolume), true
+		}
+	}
+	var vol stagedVolume
+	var found bool
+	d.stagedVolumes.Range(func(key, value interface{}) bool {
+		if v := value.(stagedVolume); v.VolumeID == volumeID {
+			vol, found = v, true
+			return false
+		}
+		return true
+	})
+	return vol, found
+}
+
+// checkStagedVolume returns AlreadyExists error if stagingPath is already staged with another volume
+// or with different mount options, the existing mount is not reused in that case since
+// options of the existing mount would silently apply to the new volume
+func (d *Driver) checkStagedVolume(volumeID, stagingPath string, mountOptions []string) error {
+	v, ok := d.stagedVolumes.Load(stagingPath)
+	if !ok {
+		return nil
+	}
+	vol := v.(stagedVolume)
+	if vol.VolumeID != volumeID {
+		return status.Errorf(codes.AlreadyExists, "staging path %s is already used by volume(%s)", stagingPath, vol.VolumeID)
+	}
+	if options := sortMountOptions(mountOptions); !reflect.DeepEqual(vol.mountOptions, options) {
+		return status.Errorf(codes.AlreadyExists, "volume(%s) is already staged on %s with mount options(%v), requested mount options(%v) are different, use a distinct volumeHandle to mount the same share with different mount options on one node", volumeID, stagingPath, vol.mountOptions, options)
+	}
+	return nil
+}
+
+// listStagedVolumes returns volumes staged on this node sorted by volume ID with their mount health,
+// only returns the volume with volumeID if it's not empty
+func (d *Driver) listStagedVolumes(volumeID string) []stagedVolume {
+	volumes := []stagedVolume{}
+	d.stagedVolumes.Range(func(key, value interface{}) bool {
+		vol := value.(stagedVolume)
+		if volumeID == "" || vol.VolumeID == volumeID {
+			vol.MountHealth = d.getMountHealth(vol.StagingPath)
+			volumes = append(volumes, vol)
+		}
+		return true
+	})
+	sort.Slice(volumes, func(i, j int) bool {
+		if volumes[i].VolumeID != volumes[j].VolumeID {
+			return volumes[i].VolumeID < volumes[j].VolumeID
+		}
+		return volumes[i].StagingPath < volumes[j].StagingPath
+	})
+	return volumes
+}
+
+// getMountHealth checks whether path is still a responsive mount point
+func (d *Driver) getMountHealth(path string) string {
+	result := make(chan string, 1)
+	go func() {
+		notMnt, err := d.mounter.IsLikelyNotMountPoint(path)
+		switch {
+		case err != nil:
+			result <- err.Error()
+		case notMnt:
+			result <- mountNotMounted
+		default:
+			result <- mountHealthy
+		}
+	}()
+
+	select {
+	case health := <-result:
+		return health
+	case <-time.After(stagedVolumeHealthCheckTimeout):
+		return mountUnreachable
+	}
+}
+
+// serveDebug starts the debug endpoint on address, which must be a loopback address
+// since the endpoint is not authenticated
+func (d *Driver) serveDebug(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid debug address(%s): %v", address, err)
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("debug address(%s) must be bound to localhost", address)
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to get listener for debug endpoint: %v", err)
+	}
+
+	m := http.NewServeMux()
+	m.HandleFunc(stagedVolumesPath, d.stagedVolumesHandler)
+	m.HandleFunc(mountCommandsPath, d.mountCommandsHandler)
+	if d.enableMountProbe {
+		m.HandleFunc(mountProbePath, d.mountProbeHandler)
+	}
+	klog.V(2).Infof("set up debug server on %v", l.Addr().String())
+	go func() {
+		defer l.Close()
+		if err := http.Serve(l, m); err != nil {
+			klog.Errorf("debug server failure(%v), address(%v)", err, address)
+		}
+	}()
+	return nil
+}
+
+// stagedVolumesHandler lists volumes staged on this node, filtered by volumeID query parameter
+func (d *Driver) stagedVolumesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	volumes := d.listStagedVolumes(r.URL.Query().Get("volumeID"))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(volumes); err != nil {
+		klog.Warningf("failed to encode staged volumes: %v", err)
+	}
+}
+
+// mountCommandsHandler lists the last mount command of volumes on this node, filtered by volumeID query parameter
+func (d *Driver) mountCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	volumeID := r.URL.Query().Get("volumeID")
+	commands := []mountCommand{}
+	d.mountCommands.Range(func(key, value interface{}) bool {
+		if cmd := value.(mountCommand); volumeID == "" || cmd.VolumeID == volumeID {
+			commands = append(commands, cmd)
+		}
+		return true
+	})
+	sort.Slice(commands, func(i, j int) bool { return commands[i].VolumeID < commands[j].VolumeID })
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(commands); err != nil {
+		klog.Warningf("failed to encode mount commands: %v", err)
+	}
+}
+
+// sortMountOptions returns a sorted copy of mount options, order of mount options does not matter
+func sortMountOptions(options []string) []string {
+	sorted := append([]string{}, options...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStagedVolumesHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on Windows")
+	}
+	d := NewFakeDriver()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+
+	d.recordStagedVolume("vol_1", "/staging/false_is_likely", "//account.file.core.windows.net/share1", "", nil, defaultVolumeStatsTimeout)
+	d.recordStagedVolume("vol_2", "/staging/path", "account.file.core.windows.net:/account/share2", nfs, nil, defaultVolumeStatsTimeout)
+	d.recordStagedVolume("vol_3", "/staging/error_is_likely", "//account.file.core.windows.net/share3", smb, nil, defaultVolumeStatsTimeout)
+	d.stagedVolumes.Delete("/staging/error_is_likely")
+
+	tests := []struct {
+		desc           string
+		method         string
+		url            string
+		expectedCode   int
+		expectedHealth map[string]string
+	}{
+		{
+			desc:           "list all staged volumes",
+			method:         http.MethodGet,
+			url:            stagedVolumesPath,
+			expectedCode:   http.StatusOK,
+			expectedHealth: map[string]string{"vol_1": mountHealthy, "vol_2": mountNotMounted},
+		},
+		{
+			desc:           "filter by volumeID",
+			method:         http.MethodGet,
+			url:            stagedVolumesPath + "?volumeID=vol_2",
+			expectedCode:   http.StatusOK,
+			expectedHealth: map[string]string{"vol_2": mountNotMounted},
+		},
+		{
+			desc:           "volume is not staged",
+			method:         http.MethodGet,
+			url:            stagedVolumesPath + "?volumeID=vol_3",
+			expectedCode:   http.StatusOK,
+			expectedHealth: map[string]string{},
+		},
+		{
+			desc:         "method not allowed",
+			method:       http.MethodPost,
+			url:          stagedVolumesPath,
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		d.stagedVolumesHandler(w, httptest.NewRequest(test.method, test.url, nil))
+		assert.Equal(t, test.expectedCode, w.Code, test.desc)
+		if test.expectedCode != http.StatusOK {
+			continue
+		}
+		var volumes []stagedVolume
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &volumes), test.desc)
+		health := map[string]string{}
+		for _, vol := range volumes {
+			assert.Equal(t, fakeNodeID, vol.NodeID, test.desc)
+			health[vol.VolumeID] = vol.MountHealth
+		}
+		assert.Equal(t, test.expectedHealth, health, test.desc)
+	}
+}
+
+func TestCheckStagedVolume(t *testing.T) {
+	d := NewFakeDriver()
+	d.recordStagedVolume("vol_1", "/staging/rw", "//account.file.core.windows.net/share", smb, []string{"dir_mode=0777", "actimeo=30"}, defaultVolumeStatsTimeout)
+	d.recordStagedVolume("vol_2", "/staging/ro", "//account.file.core.windows.net/share", smb, []string{"ro", "actimeo=30"}, 10*time.Second)
+
+	tests := []struct {
+		desc         string
+		volumeID     string
+		stagingPath  string
+		mountOptions []string
+		expectedErr  error
+	}{
+		{
+			desc:        "staging path is not staged",
+			volumeID:    "vol_3",
+			stagingPath: "/staging/new",
+		},
+		{
+			desc:         "same volume and mount options in different order",
+			volumeID:     "vol_1",
+			stagingPath:  "/staging/rw",
+			mountOptions: []string{"actimeo=30", "dir_mode=0777"},
+		},
+		{
+			desc:         "staging path is used by another volume",
+			volumeID:     "vol_2",
+			stagingPath:  "/staging/rw",
+			mountOptions: []string{"dir_mode=0777", "actimeo=30"},
+			expectedErr:  status.Errorf(codes.AlreadyExists, "staging path /staging/rw is already used by volume(vol_1)"),
+		},
+		{
+			desc:         "same volume with different mount options",
+			volumeID:     "vol_1",
+			stagingPath:  "/staging/rw",
+			mountOptions: []string{"ro", "actimeo=30"},
+			expectedErr:  status.Errorf(codes.AlreadyExists, "volume(vol_1) is already staged on /staging/rw with mount options([actimeo=30 dir_mode=0777]), requested mount options([actimeo=30 ro]) are different, use a distinct volumeHandle to mount the same share with different mount options on one node"),
+		},
+	}
+	for _, test := range tests {
+		err := d.checkStagedVolume(test.volumeID, test.stagingPath, test.mountOptions)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+
+	vol, ok := d.getStagedVolume("vol_2", "")
+	assert.True(t, ok)
+	assert.Equal(t, "/staging/ro", vol.StagingPath)
+	assert.Equal(t, 10*time.Second, vol.probeTimeout)
+	vol, ok = d.getStagedVolume("vol_2", "/staging/rw")
+	assert.True(t, ok)
+	assert.Equal(t, "vol_1", vol.VolumeID)
+	_, ok = d.getStagedVolume("vol_3", "/staging/new")
+	assert.False(t, ok)
+}
+
+func TestGetRedactedMountCommand(t *testing.T) {
+	tests := []struct {
+		desc             string
+		fsType           string
+		options          []string
+		sensitiveOptions []string
+		expected         string
+	}{
+		{
+			desc:             "smb mount with account key",
+			fsType:           cifs,
+			options:          []string{"dir_mode=0777,file_mode=0777", "actimeo=30"},
+			sensitiveOptions: []string{"username=account,password=key"},
+			expected:         "mount -t cifs -o dir_mode=0777,file_mode=0777,actimeo=30,<masked> //account.file.core.windows.net/share /staging",
+		},
+		{
+			desc:     "sensitive keys in mount options",
+			fsType:   cifs,
+			options:  []string{"Password=key,sec=krb5,krb5ccname=/tmp/krb5cc_0", "sig=abc"},
+			expected: "mount -t cifs -o Password=<masked>,sec=krb5,krb5ccname=<masked>,sig=<masked> //account.file.core.windows.net/share /staging",
+		},
+		{
+			desc:     "no mount options",
+			expected: "mount //account.file.core.windows.net/share /staging",
+		},
+	}
+	for _, test := range tests {
+		result := getRedactedMountCommand("//account.file.core.windows.net/share", "/staging", test.fsType, test.options, test.sensitiveOptions)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestMountCommandsHandler(t *testing.T) {
+	d := NewFakeDriver()
+	d.recordMountCommand("vol_2", "account.file.core.windows.net:/account/share2", "/staging/vol_2", nfs, []string{"vers=4,minorversion=1,sec=sys"}, nil, nil)
+	d.recordMountCommand("vol_1", "//account.file.core.windows.net/share1", "/staging/vol_1", cifs, []string{"actimeo=30"}, []string{"username=account,password=key"}, fmt.Errorf("mount error"))
+
+	tests := []struct {
+		desc            string
+		method          string
+		url             string
+		expectedCode    int
+		expectedVolumes []string
+	}{
+		{
+			desc:            "list all mount commands",
+			method:          http.MethodGet,
+			url:             mountCommandsPath,
+			expectedCode:    http.StatusOK,
+			expectedVolumes: []string{"vol_1", "vol_2"},
+		},
+		{
+			desc:            "filter by volumeID",
+			method:          http.MethodGet,
+			url:             mountCommandsPath + "?volumeID=vol_1",
+			expectedCode:    http.StatusOK,
+			expectedVolumes: []string{"vol_1"},
+		},
+		{
+			desc:         "method not allowed",
+			method:       http.MethodDelete,
+			url:          mountCommandsPath,
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		d.mountCommandsHandler(w, httptest.NewRequest(test.method, test.url, nil))
+		assert.Equal(t, test.expectedCode, w.Code, test.desc)
+		if test.expectedCode != http.StatusOK {
+			continue
+		}
+		assert.NotContains(t, w.Body.String(), "password=key", test.desc)
+		var commands []mountCommand
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &commands), test.desc)
+		volumes := []string{}
+		for _, cmd := range commands {
+			volumes = append(volumes, cmd.VolumeID)
+			if cmd.VolumeID == "vol_1" {
+				assert.Equal(t, "mount error", cmd.Error, test.desc)
+			}
+		}
+		assert.Equal(t, test.expectedVolumes, volumes, test.desc)
+	}
+}
+
+func TestServeDebug(t *testing.T) {
+	d := NewFakeDriver()
+	tests := []struct {
+		address     string
+		expectedErr bool
+	}{
+		{address: "127.0.0.1:0"},
+		{address: "localhost:0"},
+		{address: "0.0.0.0:0", expectedErr: true},
+		{address: ":0", expectedErr: true},
+		{address: "127.0.0.1", expectedErr: true},
+	}
+	for _, test := range tests {
+		err := d.serveDebug(test.address)
+		assert.Equal(t, test.expectedErr, err != nil, "address: %s, error: %v", test.address, err)
+	}
+}
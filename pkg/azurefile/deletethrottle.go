@@ -0,0 +1,44 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// waitForDeleteVolumeRateLimit waits until a file share on the storage account could be deleted under
+// --delete-volume-qps-per-account, so that bulk deletions do not get the account throttled by Azure. It returns
+// Unavailable if the deletion could not be started before ctx is done, external-provisioner retries it with backoff.
+func (d *Driver) waitForDeleteVolumeRateLimit(ctx context.Context, subsID, resourceGroup, accountName string) error {
+	if d.deleteVolumeQPSPerAccount <= 0 {
+		return nil
+	}
+	key := strings.ToLower(subsID + "/" + resourceGroup + "/" + accountName)
+	limiter, ok := d.deleteVolumeLimiters.Load(key)
+	if !ok {
+		limiter, _ = d.deleteVolumeLimiters.LoadOrStore(key, flowcontrol.NewTokenBucketRateLimiter(d.deleteVolumeQPSPerAccount, d.deleteVolumeBurstPerAccount))
+	}
+	if err := limiter.(flowcontrol.RateLimiter).Wait(ctx); err != nil {
+		return status.Errorf(codes.Unavailable, "file share deletion on account(%s) rg(%s) is rate limited to %v per second, retry later: %v", accountName, resourceGroup, d.deleteVolumeQPSPerAccount, err)
+	}
+	return nil
+}
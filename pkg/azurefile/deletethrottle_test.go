@@ -0,0 +1,49 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWaitForDeleteVolumeRateLimit(t *testing.T) {
+	d := NewFakeDriver()
+	// rate limit is disabled
+	d.deleteVolumeQPSPerAccount = 0
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, d.waitForDeleteVolumeRateLimit(context.Background(), "subsID", "rg", "account"))
+	}
+
+	d.deleteVolumeQPSPerAccount = 0.01
+	d.deleteVolumeBurstPerAccount = 1
+	assert.NoError(t, d.waitForDeleteVolumeRateLimit(context.Background(), "subsID", "rg", "account"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := d.waitForDeleteVolumeRateLimit(ctx, "subsID", "RG", "Account")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// other accounts have their own limit
+	assert.NoError(t, d.waitForDeleteVolumeRateLimit(context.Background(), "subsID", "rg", "account2"))
+	assert.NoError(t, d.waitForDeleteVolumeRateLimit(context.Background(), "subsID", "rg2", "account"))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// interval of resolving the server address again until it's ready, overridden in unit tests
+	dnsReadinessInterval   = 2 * time.Second
+	dnsReadinessLookupHost = net.DefaultResolver.LookupHost
+	// shared address space(RFC 6598) which could be used as address space of an Azure virtual network
+	sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+)
+
+// waitForServerDNS resolves the server address before mounting until it succeeds or dnsReadinessTimeout is reached,
+// the server of a private endpoint must resolve to private addresses only, otherwise the mount would go to the
+// public endpoint and time out. It's a no-op if dnsReadinessTimeout is 0 or server is an IP address.
+func (d *Driver) waitForServerDNS(ctx context.Context, server string, privateEndpoint bool) error {
+	if d.dnsReadinessTimeout <= 0 || net.ParseIP(server) != nil {
+		return nil
+	}
+	var lastErr error
+	err := wait.PollImmediateWithContext(ctx, dnsReadinessInterval, d.dnsReadinessTimeout, func(ctx context.Context) (bool, error) {
+		addrs, err := dnsReadinessLookupHost(ctx, server)
+		if err != nil {
+			lastErr = fmt.Errorf("server(%s) does not resolve: %v", server, err)
+		} else if privateEndpoint && !isPrivateAddressList(addrs) {
+			lastErr = fmt.Errorf("server(%s) of private endpoint resolves to public address %v instead of a private address, check private DNS zone(%s) is linked to the virtual network of the node and has a record of the storage account",
+				server, addrs, getPrivateDNSZoneName(server))
+		} else {
+			klog.V(2).Infof("server(%s) resolves to %v", server, addrs)
+			return true, nil
+		}
+		klog.Warningf("%v, retrying", lastErr)
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout && lastErr != nil {
+		return fmt.Errorf("%v after %v", lastErr, d.dnsReadinessTimeout)
+	}
+	return err
+}
+
+// isPrivateAddressList returns true if addrs is not empty and all addresses are private
+func isPrivateAddressList(addrs []string) bool {
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil || !(ip.IsPrivate() || ip.IsLoopback() || sharedAddressSpace.Contains(ip)) {
+			return false
+		}
+	}
+	return len(addrs) > 0
+}
+
+// getPrivateDNSZoneName returns the private DNS zone of private endpoint for server, e.g.
+// privatelink.file.core.windows.net for account.file.core.windows.net
+func getPrivateDNSZoneName(server string) string {
+	if i := strings.Index(server, "."); i > 0 {
+		return "privatelink" + server[i:]
+	}
+	return server
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForServerDNS(t *testing.T) {
+	origInterval, origLookupHost := dnsReadinessInterval, dnsReadinessLookupHost
+	defer func() {
+		dnsReadinessInterval, dnsReadinessLookupHost = origInterval, origLookupHost
+	}()
+	dnsReadinessInterval = time.Millisecond
+
+	lookups := 0
+	dnsReadinessLookupHost = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		switch host {
+		case "unknown.file.core.windows.net":
+			return nil, fmt.Errorf("no such host")
+		case "public.file.core.windows.net":
+			return []string{"20.60.0.1"}, nil
+		case "late.file.core.windows.net":
+			// private DNS record is propagated after a few lookups
+			if lookups < 3 {
+				return []string{"20.60.0.1"}, nil
+			}
+		}
+		return []string{"10.0.0.1"}, nil
+	}
+
+	d := NewFakeDriver()
+	// disabled by default
+	assert.NoError(t, d.waitForServerDNS(context.Background(), "unknown.file.core.windows.net", true))
+	assert.Equal(t, 0, lookups)
+
+	d.dnsReadinessTimeout = 20 * time.Millisecond
+	assert.NoError(t, d.waitForServerDNS(context.Background(), "10.0.0.1", true))
+	assert.Equal(t, 0, lookups)
+	assert.NoError(t, d.waitForServerDNS(context.Background(), "account.file.core.windows.net", true))
+	assert.NoError(t, d.waitForServerDNS(context.Background(), "public.file.core.windows.net", false))
+	assert.Equal(t, fmt.Errorf("server(unknown.file.core.windows.net) does not resolve: no such host after 20ms"),
+		d.waitForServerDNS(context.Background(), "unknown.file.core.windows.net", false))
+	assert.Equal(t, fmt.Errorf("server(public.file.core.windows.net) of private endpoint resolves to public address [20.60.0.1] instead of a private address, check private DNS zone(privatelink.file.core.windows.net) is linked to the virtual network of the node and has a record of the storage account after 20ms"),
+		d.waitForServerDNS(context.Background(), "public.file.core.windows.net", true))
+
+	lookups = 0
+	d.dnsReadinessTimeout = time.Minute
+	assert.NoError(t, d.waitForServerDNS(context.Background(), "late.file.core.windows.net", true))
+	assert.Equal(t, 3, lookups)
+}
+
+func TestIsPrivateAddressList(t *testing.T) {
+	tests := []struct {
+		addrs    []string
+		expected bool
+	}{
+		{addrs: nil, expected: false},
+		{addrs: []string{"10.0.0.4"}, expected: true},
+		{addrs: []string{"172.16.1.4", "192.168.0.4", "100.64.0.4", "fd00::4"}, expected: true},
+		{addrs: []string{"10.0.0.4", "20.60.0.1"}, expected: false},
+		{addrs: []string{"52.239.0.1"}, expected: false},
+		{addrs: []string{"invalid"}, expected: false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isPrivateAddressList(test.addrs), "addrs: %v", test.addrs)
+	}
+}
+
+func TestGetPrivateDNSZoneName(t *testing.T) {
+	assert.Equal(t, "privatelink.file.core.windows.net", getPrivateDNSZoneName("account.file.core.windows.net"))
+	assert.Equal(t, "privatelink.file.core.chinacloudapi.cn", getPrivateDNSZoneName("account.file.core.chinacloudapi.cn"))
+	assert.Equal(t, "server", getPrivateDNSZoneName("server"))
+}
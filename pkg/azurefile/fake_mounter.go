@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"sync"
 
 	mount "k8s.io/mount-utils"
 	"sigs.k8s.io/azurefile-csi-driver/pkg/mounter"
@@ -27,6 +28,8 @@ import (
 
 type fakeMounter struct {
 	mount.FakeMounter
+	// protects MountPoints recorded by overridden methods, which could be called concurrently
+	mu sync.Mutex
 }
 
 // Mount overrides mount.FakeMounter.Mount.
@@ -37,6 +40,9 @@ func (f *fakeMounter) Mount(source string, target string, fstype string, options
 		return fmt.Errorf("fake Mount: target error")
 	}
 
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.MountPoints = append(f.MountPoints, mount.MountPoint{Device: source, Path: target, Type: fstype, Opts: options})
 	return nil
 }
 
@@ -46,11 +52,49 @@ func (f *fakeMounter) MountSensitive(source string, target string, fstype string
 		return fmt.Errorf("fake MountSensitive: source error")
 	} else if strings.Contains(target, "error_mount_sens") {
 		return fmt.Errorf("fake MountSensitive: target error")
+	} else if strings.Contains(source, "error_smb_negotiation") && getSMBVersion(options) != "3.0" {
+		return fmt.Errorf("fake MountSensitive: mount error(95): Operation not supported")
+	} else if strings.Contains(source, "error_unreachable") {
+		return fmt.Errorf("fake MountSensitive: mount error(113): No route to host")
 	}
 
+	// record the mount options so that tests could verify the mount command
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.MountPoints = append(f.MountPoints, mount.MountPoint{Device: source, Path: target, Type: fstype, Opts: options})
 	return nil
 }
 
+// List overrides mount.FakeMounter.List.
+func (f *fakeMounter) List() ([]mount.MountPoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]mount.MountPoint{}, f.MountPoints...), nil
+}
+
+// GetMountRefs overrides mount.FakeMounter.GetMountRefs, it returns the other mount points of the device
+// mounted on pathname, and bind mounts of pathname which are recorded with pathname as device.
+func (f *fakeMounter) GetMountRefs(pathname string) ([]string, error) {
+	mountPoints, err := f.List()
+	if err != nil {
+		return nil, err
+	}
+	var device string
+	for _, mp := range mountPoints {
+		if mp.Path == pathname {
+			device = mp.Device
+			break
+		}
+	}
+	var refs []string
+	for _, mp := range mountPoints {
+		if mp.Path != pathname && (mp.Device == pathname || (device != "" && mp.Device == device)) {
+			refs = append(refs, mp.Path)
+		}
+	}
+	return refs, nil
+}
+
 // IsLikelyNotMountPoint overrides mount.FakeMounter.IsLikelyNotMountPoint.
 func (f *fakeMounter) IsLikelyNotMountPoint(file string) (bool, error) {
 	if strings.Contains(file, "error_is_likely") {
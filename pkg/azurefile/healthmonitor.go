@@ -0,0 +1,388 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	volumehelper "sigs.k8s.io/azurefile-csi-driver/pkg/util"
+)
+
+const (
+	// only the holder of this lease checks shares, so replicas of the controller do not emit duplicate events
+	healthMonitorLeaseName = "azurefile-csi-health-monitor"
+	// a share is reported as near quota if its usage reaches this percentage of the quota
+	shareNearQuotaPercent = 90
+	// an anomaly which persists is reported again on the same volume after this interval
+	healthMonitorEventResendInterval = time.Hour
+	// maximum number of anomalies reported in one check, the rest are reported in following checks
+	maxHealthMonitorEventsPerCheck = 50
+
+	healthMonitorReasonAccountNotFound = "StorageAccountNotFound"
+	healthMonitorReasonThrottled       = "StorageRequestThrottled"
+	healthMonitorReasonShareNotFound   = "FileShareNotFound"
+	healthMonitorReasonShareNearQuota  = "FileShareNearQuota"
+)
+
+var healthMonitorReasons = []string{healthMonitorReasonAccountNotFound, healthMonitorReasonThrottled, healthMonitorReasonShareNotFound, healthMonitorReasonShareNearQuota}
+
+// shareAnomaly is an anomaly found on the share or the account of a volume
+type shareAnomaly struct {
+	reason  string
+	message string
+}
+
+// runHealthMonitor checks shares of all PVs provisioned by this driver periodically and emits warning events
+// on the PV and its PVC when the account is deleted, requests are throttled, the share is deleted or near quota
+func (d *Driver) runHealthMonitor(interval time.Duration) {
+	if d.cloud.KubeClient == nil {
+		klog.Warningf("KubeClient is nil, health monitor is disabled")
+		return
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: d.cloud.KubeClient.CoreV1().Events("")})
+	d.eventRecorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: d.Name})
+
+	identity := getLeaseHolderIdentity()
+	klog.V(2).Infof("start health monitor of file shares every %v, holder identity(%s)", interval, identity)
+	go wait.Forever(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		leader, err := d.acquireHealthMonitorLease(ctx, identity, 2*interval)
+		if err != nil {
+			klog.Warningf("failed to acquire health monitor lease: %v", err)
+			return
+		}
+		if !leader {
+			klog.V(4).Infof("skip health monitor check since lease(%s/%s) is held by another replica", d.leaseNamespace, healthMonitorLeaseName)
+			return
+		}
+		if err := d.checkShareHealth(ctx); err != nil {
+			klog.Warningf("checkShareHealth failed with error: %v", err)
+		}
+	}, interval)
+}
+
+// getLeaseHolderIdentity returns a unique identity of this replica to hold leases
+func getLeaseHolderIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		klog.Warningf("failed to get hostname: %v", err)
+	}
+	return fmt.Sprintf("%s_%s", hostname, uuid.NewUUID())
+}
+
+// acquireHealthMonitorLease acquires or renews the health monitor lease for identity
+func (d *Driver) acquireHealthMonitorLease(ctx context.Context, identity string, leaseDuration time.Duration) (bool, error) {
+	return d.acquireLease(ctx, d.leaseNamespace, healthMonitorLeaseName, identity, leaseDuration)
+}
+
+// acquireLease acquires or renews the lease for identity, it returns false if the lease is held by another
+// replica which renewed it within leaseDuration, or if leases are released on shutdown
+func (d *Driver) acquireLease(ctx context.Context, namespace, name, identity string, leaseDuration time.Duration) (bool, error) {
+	d.leaseLock.Lock()
+	defer d.leaseLock.Unlock()
+	if d.leasesReleased {
+		return false, nil
+	}
+	key := namespace + "/" + name
+	leader, err := d.tryAcquireLease(ctx, namespace, name, identity, leaseDuration)
+	if leader {
+		if d.heldLeases == nil {
+			d.heldLeases = make(map[string]string)
+		}
+		d.heldLeases[key] = identity
+	} else {
+		delete(d.heldLeases, key)
+	}
+	return leader, err
+}
+
+// holdsLease returns whether this replica acquired or renewed the lease last time
+func (d *Driver) holdsLease(namespace, name string) bool {
+	d.leaseLock.Lock()
+	defer d.leaseLock.Unlock()
+	_, ok := d.heldLeases[namespace+"/"+name]
+	return ok
+}
+
+// releaseLeases clears the holder of leases held by this replica, so that another replica takes them over in its
+// next interval instead of waiting for them to expire, no lease is acquired afterwards
+func (d *Driver) releaseLeases(ctx context.Context) {
+	d.leaseLock.Lock()
+	defer d.leaseLock.Unlock()
+	d.leasesReleased = true
+	for key, identity := range d.heldLeases {
+		namespace, name, _ := strings.Cut(key, "/")
+		leases := d.cloud.KubeClient.CoordinationV1().Leases(namespace)
+		lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("failed to release lease(%s): %v", key, err)
+			continue
+		}
+		if pointer.StringDeref(lease.Spec.HolderIdentity, "") != identity {
+			continue
+		}
+		lease.Spec.HolderIdentity = nil
+		if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+			klog.Warningf("failed to release lease(%s): %v", key, err)
+			continue
+		}
+		klog.V(2).Infof("lease(%s) is released by holder(%s)", key, identity)
+	}
+	d.heldLeases = nil
+}
+
+// tryAcquireLease acquires or renews the lease for identity
+func (d *Driver) tryAcquireLease(ctx context.Context, namespace, name, identity string, leaseDuration time.Duration) (bool, error) {
+	leases := d.cloud.KubeClient.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	leaseDurationSeconds := int32(leaseDuration.Seconds())
+
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       pointer.String(identity),
+				LeaseDurationSeconds: pointer.Int32(leaseDurationSeconds),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if _, err := leases.Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if holder := pointer.StringDeref(lease.Spec.HolderIdentity, ""); holder != identity {
+		if holder != "" && lease.Spec.RenewTime != nil &&
+			time.Since(lease.Spec.RenewTime.Time) < time.Duration(pointer.Int32Deref(lease.Spec.LeaseDurationSeconds, 0))*time.Second {
+			return false, nil
+		}
+		klog.V(2).Infof("lease(%s/%s) is taken over from holder(%s)", namespace, name, holder)
+		lease.Spec.HolderIdentity = pointer.String(identity)
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.LeaseDurationSeconds = pointer.Int32(leaseDurationSeconds)
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		if apierrors.IsConflict(err) {
+			// another replica updated the lease first
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// healthCheckVolume is a PV to check and its file share
+type healthCheckVolume struct {
+	pv            *v1.PersistentVolume
+	fileShareName string
+}
+
+// checkShareHealth checks the account and the share of all PVs provisioned by this driver, PVs are grouped by account,
+// each account is read once and its file shares are listed once
+func (d *Driver) checkShareHealth(ctx context.Context) error {
+	if d.cloud.KubeClient == nil {
+		return fmt.Errorf("KubeClient is nil")
+	}
+	pvs, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list persistent volumes: %v", err)
+	}
+
+	var accounts []accountTagSyncKey
+	accountVolumes := make(map[accountTagSyncKey][]healthCheckVolume)
+	accountClientIDs := make(map[accountTagSyncKey]string)
+	checked := make(map[string]bool)
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.Status.Phase == v1.VolumeReleased || pv.Status.Phase == v1.VolumeFailed {
+			continue
+		}
+		resourceGroup, accountName, fileShareName, _, _, subsID, err := GetFileShareInfo(pv.Spec.CSI.VolumeHandle)
+		if err != nil || accountName == "" || fileShareName == "" {
+			klog.V(4).Infof("skip health check on pv(%s): %v", pv.Name, err)
+			continue
+		}
+		if err := d.bindAccountToCloudConfig(getCloudConfigName(pv.Spec.CSI.VolumeHandle), accountName); err != nil {
+			klog.V(4).Infof("skip health check on pv(%s): %v", pv.Name, err)
+			continue
+		}
+		if resourceGroup == "" {
+			resourceGroup = d.cloud.ResourceGroup
+		}
+		if subsID == "" {
+			subsID = d.cloud.SubscriptionID
+		}
+
+		key := accountTagSyncKey{subsID: subsID, resourceGroup: resourceGroup, accountName: accountName}
+		if _, ok := accountVolumes[key]; !ok {
+			accounts = append(accounts, key)
+			if h, err := parseVolumeHandle(pv.Spec.CSI.VolumeHandle); err == nil {
+				accountClientIDs[key] = h.clientID
+			}
+		}
+		accountVolumes[key] = append(accountVolumes[key], healthCheckVolume{pv: pv, fileShareName: fileShareName})
+		checked[pv.Name] = true
+	}
+	d.pruneReportedAnomalies(checked)
+
+	reported := 0
+	for _, key := range accounts {
+		accountCtx := withClientID(ctx, accountClientIDs[key])
+		accountAnomaly := d.getAccountAnomaly(accountCtx, key)
+		var shares map[string]storage.FileShareItem
+		if accountAnomaly == nil {
+			if shares, accountAnomaly, err = d.listAccountShares(accountCtx, key); err != nil {
+				klog.Warningf("health monitor failed to list file shares on account(%s) in resource group(%s): %v", key.accountName, key.resourceGroup, err)
+				continue
+			}
+		}
+		for _, vol := range accountVolumes[key] {
+			anomaly := accountAnomaly
+			if anomaly == nil {
+				anomaly = getShareAnomaly(key, vol.fileShareName, shares)
+			}
+			if anomaly == nil {
+				d.clearReportedAnomalies(vol.pv.Name)
+				continue
+			}
+			if reported >= maxHealthMonitorEventsPerCheck {
+				klog.V(2).Infof("skip reporting %s on pv(%s) since %d anomalies are reported in this check", anomaly.reason, vol.pv.Name, reported)
+				continue
+			}
+			if d.reportAnomaly(vol.pv, anomaly) {
+				reported++
+			}
+		}
+	}
+	return nil
+}
+
+// getAccountAnomaly returns nil if the account could be read
+func (d *Driver) getAccountAnomaly(ctx context.Context, key accountTagSyncKey) *shareAnomaly {
+	cloud := d.getCloudByAccount(ctx, key.subsID, key.resourceGroup, key.accountName)
+	if cloud.StorageAccountClient == nil {
+		return nil
+	}
+	if _, rerr := cloud.StorageAccountClient.GetProperties(ctx, key.subsID, key.resourceGroup, key.accountName); rerr != nil {
+		err := rerr.Error()
+		if isNotFoundError(err) {
+			return &shareAnomaly{reason: healthMonitorReasonAccountNotFound, message: fmt.Sprintf("storage account(%s) in resource group(%s) is not found", key.accountName, key.resourceGroup)}
+		}
+		if isThrottlingError(err) {
+			return &shareAnomaly{reason: healthMonitorReasonThrottled, message: fmt.Sprintf("requests to storage account(%s) in resource group(%s) are throttled: %v", key.accountName, key.resourceGroup, err)}
+		}
+		klog.Warningf("health monitor failed to get storage account(%s) in resource group(%s): %v", key.accountName, key.resourceGroup, err)
+	}
+	return nil
+}
+
+// listAccountShares returns live file shares on the account by name, or the anomaly if requests are throttled
+func (d *Driver) listAccountShares(ctx context.Context, key accountTagSyncKey) (map[string]storage.FileShareItem, *shareAnomaly, error) {
+	items, err := d.getCloudByAccount(ctx, key.subsID, key.resourceGroup, key.accountName).FileClient.WithSubscriptionID(key.subsID).ListFileShare(ctx, key.resourceGroup, key.accountName, "", "")
+	if err != nil {
+		if isThrottlingError(err) {
+			return nil, &shareAnomaly{reason: healthMonitorReasonThrottled, message: fmt.Sprintf("requests to file shares on storage account(%s) are throttled: %v", key.accountName, err)}, nil
+		}
+		return nil, nil, err
+	}
+	shares := make(map[string]storage.FileShareItem, len(items))
+	for _, item := range items {
+		if item.Name == nil || pointer.BoolDeref(item.Deleted, false) {
+			continue
+		}
+		shares[*item.Name] = item
+	}
+	return shares, nil, nil
+}
+
+// getShareAnomaly returns nil if the share is listed on the account and its usage is below shareNearQuotaPercent of quota
+func getShareAnomaly(key accountTagSyncKey, fileShareName string, shares map[string]storage.FileShareItem) *shareAnomaly {
+	share, ok := shares[fileShareName]
+	if !ok {
+		return &shareAnomaly{reason: healthMonitorReasonShareNotFound, message: fmt.Sprintf("file share(%s) on storage account(%s) is not found", fileShareName, key.accountName)}
+	}
+	if share.FileShareProperties == nil {
+		return nil
+	}
+	quotaGiB := int64(pointer.Int32Deref(share.ShareQuota, 0))
+	usedBytes := pointer.Int64Deref(share.ShareUsageBytes, 0)
+	if quotaGiB > 0 && usedBytes*100 >= quotaGiB*volumehelper.GiB*shareNearQuotaPercent {
+		return &shareAnomaly{reason: healthMonitorReasonShareNearQuota, message: fmt.Sprintf("file share(%s) on storage account(%s) uses %d GiB of %d GiB quota", fileShareName, key.accountName, volumehelper.RoundUpGiB(usedBytes), quotaGiB)}
+	}
+	return nil
+}
+
+// reportAnomaly emits a warning event on the PV and its PVC, the same anomaly on a PV is not reported again
+// within healthMonitorEventResendInterval, it returns true if the event is emitted
+func (d *Driver) reportAnomaly(pv *v1.PersistentVolume, anomaly *shareAnomaly) bool {
+	key := pv.Name + "/" + anomaly.reason
+	if last, ok := d.healthMonitorEvents[key]; ok && time.Since(last) < healthMonitorEventResendInterval {
+		return false
+	}
+	d.healthMonitorEvents[key] = time.Now()
+	klog.Warningf("health monitor: pv(%s) %s: %s", pv.Name, anomaly.reason, anomaly.message)
+	d.eventRecorder.Event(pv, v1.EventTypeWarning, anomaly.reason, anomaly.message)
+	if pv.Spec.ClaimRef != nil {
+		d.eventRecorder.Event(pv.Spec.ClaimRef, v1.EventTypeWarning, anomaly.reason, anomaly.message)
+	}
+	return true
+}
+
+// clearReportedAnomalies forgets anomalies reported on the PV, so a recurring anomaly is reported immediately
+func (d *Driver) clearReportedAnomalies(pvName string) {
+	for _, reason := range healthMonitorReasons {
+		delete(d.healthMonitorEvents, pvName+"/"+reason)
+	}
+}
+
+// pruneReportedAnomalies forgets anomalies reported on PVs which are deleted or no longer checked
+func (d *Driver) pruneReportedAnomalies(checked map[string]bool) {
+	for key := range d.healthMonitorEvents {
+		if pvName := key[:strings.LastIndex(key, "/")]; !checked[pvName] {
+			delete(d.healthMonitorEvents, key)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func newHealthMonitorTestPV(name, volumeHandle string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: fakeDriverName, VolumeHandle: volumeHandle},
+			},
+			ClaimRef: &v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: "default", Name: "pvc-" + name},
+		},
+		Status: v1.PersistentVolumeStatus{Phase: v1.VolumeBound},
+	}
+}
+
+func TestCheckShareHealth(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	released := newHealthMonitorTestPV("pv-released", "rg#released#share#")
+	released.Status.Phase = v1.VolumeReleased
+	otherDriver := newHealthMonitorTestPV("pv-other", "rg#other#share#")
+	otherDriver.Spec.CSI.Driver = "other.csi.azure.com"
+	d.cloud.KubeClient = fake.NewSimpleClientset(
+		newHealthMonitorTestPV("pv-healthy", "rg#healthy#share#"),
+		newHealthMonitorTestPV("pv-deleted-account", "rg#deleted#share#"),
+		newHealthMonitorTestPV("pv-throttled", "rg#throttled#share#"),
+		newHealthMonitorTestPV("pv-deleted-share", "rg#healthy#deletedshare#"),
+		newHealthMonitorTestPV("pv-full-share", "rg#healthy#fullshare#"),
+		released,
+		otherDriver,
+	)
+	recorder := record.NewFakeRecorder(100)
+	d.eventRecorder = recorder
+
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+
+	// each account is read once and its file shares are listed once in one check
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "healthy").Return(storage.Account{}, nil).Times(3)
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "deleted").Return(storage.Account{}, &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("not found")}).Times(3)
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "throttled").Return(storage.Account{}, &retry.Error{HTTPStatusCode: http.StatusTooManyRequests, RawError: fmt.Errorf(tooManyRequests)}).Times(3)
+	shares := []storage.FileShareItem{
+		{Name: pointer.String("share"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), ShareUsageBytes: pointer.Int64(10 << 30)}},
+		{Name: pointer.String("deletedshare"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), Deleted: pointer.Bool(true)}},
+		{Name: pointer.String("fullshare"), FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), ShareUsageBytes: pointer.Int64(95 << 30)}},
+	}
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "healthy", "", "").Return(shares, nil).Times(3)
+
+	assert.NoError(t, d.checkShareHealth(context.Background()))
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	expected := []string{
+		"Warning FileShareNearQuota file share(fullshare) on storage account(healthy) uses 95 GiB of 100 GiB quota",
+		"Warning FileShareNearQuota file share(fullshare) on storage account(healthy) uses 95 GiB of 100 GiB quota",
+		"Warning FileShareNotFound file share(deletedshare) on storage account(healthy) is not found",
+		"Warning FileShareNotFound file share(deletedshare) on storage account(healthy) is not found",
+		"Warning StorageAccountNotFound storage account(deleted) in resource group(rg) is not found",
+		"Warning StorageAccountNotFound storage account(deleted) in resource group(rg) is not found",
+	}
+	assert.Equal(t, expected, events[:len(expected)])
+	assert.Len(t, events, len(expected)+2)
+	assert.Contains(t, events[len(expected)], "Warning StorageRequestThrottled requests to storage account(throttled) in resource group(rg) are throttled")
+
+	// anomalies are not reported again within resend interval
+	recorder = record.NewFakeRecorder(100)
+	d.eventRecorder = recorder
+	assert.NoError(t, d.checkShareHealth(context.Background()))
+	assert.Len(t, recorder.Events, 0)
+	assert.Len(t, d.healthMonitorEvents, 4)
+
+	// anomalies reported on deleted PVs are forgotten
+	assert.NoError(t, d.cloud.KubeClient.CoreV1().PersistentVolumes().Delete(context.Background(), "pv-full-share", metav1.DeleteOptions{}))
+	assert.NoError(t, d.checkShareHealth(context.Background()))
+	assert.Len(t, d.healthMonitorEvents, 3)
+	_, ok := d.healthMonitorEvents["pv-full-share/"+healthMonitorReasonShareNearQuota]
+	assert.False(t, ok)
+}
+
+func TestReportAnomaly(t *testing.T) {
+	d := NewFakeDriver()
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	pv := newHealthMonitorTestPV("pv", "rg#account#share#")
+	anomaly := &shareAnomaly{reason: healthMonitorReasonShareNotFound, message: "share is not found"}
+
+	assert.True(t, d.reportAnomaly(pv, anomaly))
+	assert.Len(t, recorder.Events, 2)
+	assert.False(t, d.reportAnomaly(pv, anomaly))
+	assert.Len(t, recorder.Events, 2)
+
+	// reported again after resend interval
+	d.healthMonitorEvents["pv/"+healthMonitorReasonShareNotFound] = time.Now().Add(-healthMonitorEventResendInterval)
+	assert.True(t, d.reportAnomaly(pv, anomaly))
+	assert.Len(t, recorder.Events, 4)
+
+	// reported immediately after the volume recovers
+	d.clearReportedAnomalies("pv")
+	assert.True(t, d.reportAnomaly(pv, anomaly))
+	assert.Len(t, recorder.Events, 6)
+}
+
+func TestAcquireHealthMonitorLease(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud.KubeClient = fake.NewSimpleClientset()
+	d.leaseNamespace = "kube-system"
+	ctx := context.Background()
+
+	leader, err := d.acquireHealthMonitorLease(ctx, "replica1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	// renewed by the holder
+	leader, err = d.acquireHealthMonitorLease(ctx, "replica1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	// another replica could not acquire a lease which is not expired
+	leader, err = d.acquireHealthMonitorLease(ctx, "replica2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, leader)
+
+	// an expired lease is taken over
+	lease, err := d.cloud.KubeClient.CoordinationV1().Leases("kube-system").Get(ctx, healthMonitorLeaseName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	expired := metav1.NewMicroTime(time.Now().Add(-2 * time.Minute))
+	lease.Spec.RenewTime = &expired
+	_, err = d.cloud.KubeClient.CoordinationV1().Leases("kube-system").Update(ctx, lease, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	leader, err = d.acquireHealthMonitorLease(ctx, "replica2", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	lease, err = d.cloud.KubeClient.CoordinationV1().Leases("kube-system").Get(ctx, healthMonitorLeaseName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "replica2", *lease.Spec.HolderIdentity)
+}
+
+func TestReleaseLeases(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud.KubeClient = fake.NewSimpleClientset()
+	d.leaseNamespace = "kube-system"
+	ctx := context.Background()
+
+	leader, err := d.acquireLease(ctx, d.leaseNamespace, healthMonitorLeaseName, "replica1", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	leader, err = d.acquireLease(ctx, d.leaseNamespace, tagSyncLeaseName, "replica1", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	assert.True(t, d.holdsLease("kube-system", tagSyncLeaseName))
+	assert.False(t, d.holdsLease("kube-system", secretKeySyncLeaseName))
+
+	// released leases are taken over by another replica before they expire
+	d.releaseLeases(ctx)
+	for _, name := range []string{healthMonitorLeaseName, tagSyncLeaseName} {
+		lease, err := d.cloud.KubeClient.CoordinationV1().Leases("kube-system").Get(ctx, name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Nil(t, lease.Spec.HolderIdentity)
+	}
+	other := NewFakeDriver()
+	other.cloud.KubeClient = d.cloud.KubeClient
+	leader, err = other.acquireLease(ctx, "kube-system", healthMonitorLeaseName, "replica2", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, leader)
+
+	assert.False(t, d.holdsLease("kube-system", tagSyncLeaseName))
+	// no lease is acquired after leases are released
+	leader, err = d.acquireLease(ctx, "kube-system", healthMonitorLeaseName, "replica1", time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, leader)
+}
@@ -18,6 +18,10 @@ package azurefile
 
 import (
 	"context"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -39,9 +43,57 @@ func (f *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoReques
 	return &csi.GetPluginInfoResponse{
 		Name:          f.Name,
 		VendorVersion: f.Version,
+		Manifest:      f.getPluginManifest(),
 	}, nil
 }
 
+// getPluginManifest returns build info, cloud environment and feature flags of the driver for fleet inventory,
+// only names and switches are reported, no identity, credential or resource ID is included
+func (f *Driver) getPluginManifest() map[string]string {
+	manifest := map[string]string{
+		"gitCommit": gitCommit,
+		"buildDate": buildDate,
+		"goVersion": runtime.Version(),
+		"platform":  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if f.cloud != nil {
+		manifest["cloud"] = f.cloud.Config.Cloud
+		manifest["cloudEnvironment"] = f.cloud.Environment.Name
+		manifest["storageEndpointSuffix"] = f.cloud.Environment.StorageEndpointSuffix
+	}
+	var cloudConfigNames []string
+	for name := range f.additionalCloudConfigs {
+		cloudConfigNames = append(cloudConfigNames, name)
+	}
+	sort.Strings(cloudConfigNames)
+	manifest["additional-cloud-configs"] = strings.Join(cloudConfigNames, ",")
+
+	// feature flags are reported with their command line flag names
+	for name, enabled := range map[string]bool{
+		"enable-vhd":                                   f.enableVHDDiskFeature,
+		"enable-get-volume-stats":                      f.enableGetVolumeStats,
+		"enable-mount-probe":                           f.enableMountProbe,
+		"auto-enable-large-file-shares":                f.autoEnableLargeFileShares,
+		"smb-version-fallback":                         f.smbVersionFallback,
+		"auto-select-smb-version":                      f.autoSelectSMBVersion,
+		"require-smb-encryption":                       f.requireSMBEncryption,
+		"enable-capacity-tags":                         f.enableCapacityTags,
+		"enable-account-capacity-check":                f.enableAccountCapacityCheck,
+		"disable-stage-unstage":                        f.disableStageUnstage,
+		"allow-blob-public-access":                     f.allowBlobPublicAccess,
+		"strict-sku-validation":                        f.strictSkuValidation,
+		"enable-tracing":                               f.enableTracing,
+		"allow-inline-volume-key-access-with-identity": f.allowInlineVolumeKeyAccessWithIdentity,
+	} {
+		manifest[name] = strconv.FormatBool(enabled)
+	}
+	manifest["allowed-smb-versions"] = strings.Join(f.allowedSMBVersions, ",")
+	manifest["unstage-policy"] = f.unstagePolicy
+	manifest["below-minimum-capacity-policy"] = f.belowMinimumCapacityPolicy
+	manifest["share-name-namespace"] = f.shareNameNamespace
+	return manifest
+}
+
 // Probe check whether the plugin is running or not.
 // This method does not need to return anything.
 // Currently the spec does not dictate what you should return either.
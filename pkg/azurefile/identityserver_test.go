@@ -18,9 +18,12 @@ package azurefile
 
 import (
 	"context"
+	"runtime"
+	"testing"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
-	"testing"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 )
 
 func TestGetPluginInfo(t *testing.T) {
@@ -32,6 +35,30 @@ func TestGetPluginInfo(t *testing.T) {
 	assert.Equal(t, resp.Name, fakeDriverName)
 	assert.Equal(t, resp.GetVendorVersion(), vendorVersion)
 
+	// manifest reports cloud environment and feature flags without identities
+	d = NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Config.Cloud = "AzureChinaCloud"
+	d.cloud.Config.AADClientSecret = "secret"
+	d.cloud.Environment.StorageEndpointSuffix = "core.chinacloudapi.cn"
+	d.additionalCloudConfigs = map[string]string{"b": "/etc/b.json", "a": "/etc/a.json"}
+	d.smbVersionFallback = true
+	d.unstagePolicy = unstagePolicyFail
+	resp, err = d.GetPluginInfo(context.Background(), &req)
+	assert.NoError(t, err)
+	manifest := resp.GetManifest()
+	assert.Equal(t, "AzureChinaCloud", manifest["cloud"])
+	assert.Equal(t, "core.chinacloudapi.cn", manifest["storageEndpointSuffix"])
+	assert.Equal(t, "a,b", manifest["additional-cloud-configs"])
+	assert.Equal(t, "true", manifest["smb-version-fallback"])
+	assert.Equal(t, "false", manifest["enable-vhd"])
+	assert.Equal(t, unstagePolicyFail, manifest["unstage-policy"])
+	assert.Equal(t, belowMinimumCapacityPolicyRoundUp, manifest["below-minimum-capacity-policy"])
+	assert.Equal(t, runtime.GOOS+"/"+runtime.GOARCH, manifest["platform"])
+	for k, v := range manifest {
+		assert.NotContains(t, v, "secret", k)
+	}
+
 	//Check error when driver name is empty
 	d = NewFakeDriver()
 	d.Name = ""
@@ -0,0 +1,329 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// tag on the target account of a share migration, value is resourceGroup/accountName of the source account
+	migrationSourceTag = "csi-migration-source"
+	// validity of the read-only SAS of source shares, copies still pending after expiry fail and are restarted by the next run
+	shareMigrationSASExpiry = 48 * time.Hour
+)
+
+// migrationAccount is the source or target storage account of a share migration
+type migrationAccount struct {
+	resourceGroup string
+	accountName   string
+}
+
+func (a migrationAccount) String() string {
+	return a.resourceGroup + "/" + a.accountName
+}
+
+// shareMigrationStats counts files of a share by the action taken in one migration run
+type shareMigrationStats struct {
+	copied  int
+	skipped int
+	pending int
+}
+
+// runShareMigration copies file shares from a storage account nearing capacity to another account with
+// server side copy, the target account and shares are created if they do not exist. Nothing in the
+// source account is modified or deleted, and no Kubernetes object is updated, a migrated share could be
+// adopted as a static PV. It is safe to run again with the same parameters, files already copied are skipped.
+func (d *Driver) runShareMigration(ctx context.Context, source, target, shares string) error {
+	sourceAccount, err := parseMigrationAccount(source, d.cloud.ResourceGroup)
+	if err != nil {
+		return fmt.Errorf("invalid source account: %v", err)
+	}
+	targetAccount, err := parseMigrationAccount(target, sourceAccount.resourceGroup)
+	if err != nil {
+		return fmt.Errorf("invalid target account: %v", err)
+	}
+	if strings.EqualFold(sourceAccount.accountName, targetAccount.accountName) {
+		return fmt.Errorf("target account(%s) must be different from source account(%s)", targetAccount, sourceAccount)
+	}
+	shareNames := parseMigrationShares(shares)
+	if len(shareNames) == 0 {
+		return fmt.Errorf("no file share to migrate")
+	}
+
+	if err := d.ensureMigrationTargetAccount(ctx, sourceAccount, targetAccount); err != nil {
+		return err
+	}
+
+	sourceKey, err := d.GetStorageAccesskey(ctx, &azure.AccountOptions{Name: sourceAccount.accountName, SubscriptionID: d.cloud.SubscriptionID, ResourceGroup: sourceAccount.resourceGroup}, nil, "", d.defaultSecretNamespace)
+	if err != nil {
+		return fmt.Errorf("failed to get key of source account(%s): %v", sourceAccount, err)
+	}
+	targetKey, err := d.GetStorageAccesskey(ctx, &azure.AccountOptions{Name: targetAccount.accountName, SubscriptionID: d.cloud.SubscriptionID, ResourceGroup: targetAccount.resourceGroup}, nil, "", d.defaultSecretNamespace)
+	if err != nil {
+		return fmt.Errorf("failed to get key of target account(%s): %v", targetAccount, err)
+	}
+	sourceServiceURL, sourceCredential, err := d.getAccountServiceURL(sourceAccount.accountName, sourceKey)
+	if err != nil {
+		return err
+	}
+	targetServiceURL, _, err := d.getAccountServiceURL(targetAccount.accountName, targetKey)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, shareName := range shareNames {
+		if err := d.ensureMigrationTargetShare(ctx, sourceAccount, targetAccount, shareName); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sas, err := azfile.FileSASSignatureValues{
+			Protocol:    azfile.SASProtocolHTTPS,
+			ExpiryTime:  time.Now().UTC().Add(shareMigrationSASExpiry),
+			Permissions: azfile.ShareSASPermissions{Read: true}.String(),
+			ShareName:   shareName,
+		}.NewSASQueryParameters(sourceCredential)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to generate SAS of file share(%s): %v", shareName, err))
+			continue
+		}
+		stats := &shareMigrationStats{}
+		sourceDir := sourceServiceURL.NewShareURL(shareName).NewRootDirectoryURL()
+		targetDir := targetServiceURL.NewShareURL(shareName).NewRootDirectoryURL()
+		if err := copyShareDirectory(ctx, sourceDir, targetDir, sas, stats); err != nil {
+			errs = append(errs, fmt.Errorf("failed to copy file share(%s): %v", shareName, err))
+			continue
+		}
+		klog.V(2).Infof("file share(%s) from account(%s) to account(%s): %d files copied, %d files skipped, %d copies pending", shareName, sourceAccount, targetAccount, stats.copied, stats.skipped, stats.pending)
+		if stats.copied > 0 || stats.pending > 0 {
+			klog.V(2).Infof("copies of file share(%s) are in progress, run the migration again to verify all files are copied", shareName)
+		} else {
+			klog.V(2).Infof("file share(%s) is migrated, it could be adopted by a static PV with volumeHandle %s", shareName, (&volumeHandle{resourceGroup: targetAccount.resourceGroup, accountName: targetAccount.accountName, fileShareName: shareName}).String())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to migrate %d out of %d file shares: %v", len(errs), len(shareNames), errs)
+	}
+	return nil
+}
+
+// ensureMigrationTargetAccount creates the target account with sku, kind, location and network rules of the source account
+// if it does not exist, an existing target account is used as is
+func (d *Driver) ensureMigrationTargetAccount(ctx context.Context, sourceAccount, targetAccount migrationAccount) error {
+	if d.cloud.StorageAccountClient == nil {
+		return fmt.Errorf("storage account client is nil")
+	}
+	_, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, d.cloud.SubscriptionID, targetAccount.resourceGroup, targetAccount.accountName)
+	if rerr == nil {
+		klog.V(2).Infof("target account(%s) already exists", targetAccount)
+		return nil
+	}
+	if !isNotFoundError(rerr.Error()) {
+		return fmt.Errorf("failed to get target account(%s): %v", targetAccount, rerr.Error())
+	}
+
+	source, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, d.cloud.SubscriptionID, sourceAccount.resourceGroup, sourceAccount.accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get source account(%s): %v", sourceAccount, rerr.Error())
+	}
+	tags := map[string]*string{}
+	for k, v := range source.Tags {
+		tags[k] = v
+	}
+	tags[migrationSourceTag] = pointer.String(sourceAccount.String())
+	parameters := storage.AccountCreateParameters{
+		Sku:                               source.Sku,
+		Kind:                              source.Kind,
+		Location:                          source.Location,
+		Tags:                              tags,
+		AccountPropertiesCreateParameters: &storage.AccountPropertiesCreateParameters{},
+	}
+	if source.AccountProperties != nil {
+		parameters.AccountPropertiesCreateParameters.EnableHTTPSTrafficOnly = source.EnableHTTPSTrafficOnly
+		parameters.AccountPropertiesCreateParameters.MinimumTLSVersion = source.MinimumTLSVersion
+		parameters.AccountPropertiesCreateParameters.LargeFileSharesState = source.LargeFileSharesState
+		parameters.AccountPropertiesCreateParameters.NetworkRuleSet = source.NetworkRuleSet
+	}
+	klog.V(2).Infof("creating target account(%s) like source account(%s)", targetAccount, sourceAccount)
+	if rerr := d.cloud.StorageAccountClient.Create(ctx, d.cloud.SubscriptionID, targetAccount.resourceGroup, targetAccount.accountName, parameters); rerr != nil {
+		return fmt.Errorf("failed to create target account(%s): %v", targetAccount, rerr.Error())
+	}
+	return nil
+}
+
+// ensureMigrationTargetShare creates the file share in target account with quota and access tier of the source share
+// if it does not exist, only SMB file shares could be copied by the file REST API
+func (d *Driver) ensureMigrationTargetShare(ctx context.Context, sourceAccount, targetAccount migrationAccount, shareName string) error {
+	source, err := d.getCloud(sourceAccount.accountName).FileClient.WithSubscriptionID(d.cloud.SubscriptionID).GetFileShare(ctx, sourceAccount.resourceGroup, sourceAccount.accountName, shareName, "")
+	if err != nil {
+		return fmt.Errorf("failed to get file share(%s) in source account(%s): %v", shareName, sourceAccount, err)
+	}
+	if source.FileShareProperties == nil {
+		return fmt.Errorf("file share(%s) in source account(%s) has no properties", shareName, sourceAccount)
+	}
+	if source.EnabledProtocols == storage.EnabledProtocolsNFS {
+		return fmt.Errorf("file share(%s) in source account(%s) is a NFS file share, which could not be copied by file REST API", shareName, sourceAccount)
+	}
+
+	quota, err := d.getFileShareQuota(ctx, d.cloud.SubscriptionID, targetAccount.resourceGroup, targetAccount.accountName, shareName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get file share(%s) in target account(%s): %v", shareName, targetAccount, err)
+	}
+	if quota != -1 {
+		klog.V(2).Infof("file share(%s) already exists in target account(%s)", shareName, targetAccount)
+		return nil
+	}
+	shareOptions := &fileclient.ShareOptions{
+		Name:       shareName,
+		Protocol:   storage.EnabledProtocolsSMB,
+		RequestGiB: int(pointer.Int32Deref(source.ShareQuota, 0)),
+		AccessTier: string(source.AccessTier),
+	}
+	accountOptions := &azure.AccountOptions{
+		Name:           targetAccount.accountName,
+		SubscriptionID: d.cloud.SubscriptionID,
+		ResourceGroup:  targetAccount.resourceGroup,
+	}
+	klog.V(2).Infof("creating file share(%s) with quota %d GiB in target account(%s)", shareName, shareOptions.RequestGiB, targetAccount)
+	if err := d.CreateFileShare(ctx, accountOptions, shareOptions, nil); err != nil {
+		return fmt.Errorf("failed to create file share(%s) in target account(%s): %v", shareName, targetAccount, err)
+	}
+	return nil
+}
+
+// copyShareDirectory walks sourceDir recursively, creates missing directories in targetDir and starts server side copy
+// of files which are not copied yet, it does not wait for copies to complete
+func copyShareDirectory(ctx context.Context, sourceDir, targetDir azfile.DirectoryURL, sas azfile.SASQueryParameters, stats *shareMigrationStats) error {
+	for marker := (azfile.Marker{}); marker.NotDone(); {
+		resp, err := sourceDir.ListFilesAndDirectoriesSegment(ctx, marker, azfile.ListFilesAndDirectoriesOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list directory(%s): %v", sourceDir.String(), err)
+		}
+		marker = resp.NextMarker
+		for _, dir := range resp.DirectoryItems {
+			targetSubDir := targetDir.NewDirectoryURL(dir.Name)
+			if _, err := targetSubDir.Create(ctx, azfile.Metadata{}, azfile.SMBProperties{}); err != nil && !isStorageErrorCode(err, azfile.ServiceCodeResourceAlreadyExists) {
+				return fmt.Errorf("failed to create directory(%s): %v", targetSubDir.String(), err)
+			}
+			if err := copyShareDirectory(ctx, sourceDir.NewDirectoryURL(dir.Name), targetSubDir, sas, stats); err != nil {
+				return err
+			}
+		}
+		for _, file := range resp.FileItems {
+			var length int64
+			if file.Properties != nil {
+				length = file.Properties.ContentLength
+			}
+			if err := copyShareFile(ctx, sourceDir.NewFileURL(file.Name), targetDir.NewFileURL(file.Name), length, sas, stats); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyShareFile starts server side copy of sourceFile to targetFile unless targetFile is already copied or being copied
+func copyShareFile(ctx context.Context, sourceFile, targetFile azfile.FileURL, length int64, sas azfile.SASQueryParameters, stats *shareMigrationStats) error {
+	props, err := targetFile.GetProperties(ctx)
+	if err != nil && !isStorageErrorCode(err, azfile.ServiceCodeResourceNotFound) {
+		return fmt.Errorf("failed to get properties of file(%s): %v", targetFile.String(), err)
+	}
+	if err == nil {
+		switch props.CopyStatus() {
+		case azfile.CopyStatusPending:
+			stats.pending++
+			return nil
+		case azfile.CopyStatusFailed, azfile.CopyStatusAborted:
+			klog.V(2).Infof("restarting copy of file(%s), previous copy status: %s", targetFile.String(), props.CopyStatus())
+		default:
+			if props.ContentLength() == length {
+				stats.skipped++
+				return nil
+			}
+		}
+	}
+
+	sourceURLParts := azfile.NewFileURLParts(sourceFile.URL())
+	sourceURLParts.SAS = sas
+	if _, err := targetFile.StartCopy(ctx, sourceURLParts.URL(), azfile.Metadata{}); err != nil {
+		return fmt.Errorf("failed to copy file(%s): %v", targetFile.String(), err)
+	}
+	stats.copied++
+	return nil
+}
+
+// getAccountServiceURL returns file service URL of the account authorized by shared key
+func (d *Driver) getAccountServiceURL(accountName, accountKey string) (azfile.ServiceURL, *azfile.SharedKeyCredential, error) {
+	credential, err := azfile.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return azfile.ServiceURL{}, nil, fmt.Errorf("NewSharedKeyCredential(%s) failed with error: %v", accountName, err)
+	}
+	u, err := url.Parse(fmt.Sprintf(serviceURLTemplate, accountName, d.getCloud(accountName).Environment.StorageEndpointSuffix))
+	if err != nil {
+		return azfile.ServiceURL{}, nil, fmt.Errorf("parse serviceURLTemplate error: %v", err)
+	}
+	return azfile.NewServiceURL(*u, azfile.NewPipeline(credential, azfile.PipelineOptions{})), credential, nil
+}
+
+// isStorageErrorCode returns true if err is a file data plane error with service code
+func isStorageErrorCode(err error, code azfile.ServiceCodeType) bool {
+	var storageErr azfile.StorageError
+	return errors.As(err, &storageErr) && storageErr.ServiceCode() == code
+}
+
+// parseMigrationAccount parses account in format accountName or resourceGroup/accountName,
+// defaultResourceGroup is used if resource group is not specified
+func parseMigrationAccount(account, defaultResourceGroup string) (migrationAccount, error) {
+	parts := strings.Split(strings.TrimSpace(account), "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return migrationAccount{resourceGroup: defaultResourceGroup, accountName: parts[0]}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return migrationAccount{resourceGroup: parts[0], accountName: parts[1]}, nil
+	default:
+		return migrationAccount{}, fmt.Errorf("invalid account(%s), expected format: accountName or resourceGroup/accountName", account)
+	}
+}
+
+// parseMigrationShares parses comma separated file share names, duplicates are removed
+func parseMigrationShares(shares string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, v := range strings.Split(shares, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		result = append(result, v)
+	}
+	return result
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestParseMigrationAccount(t *testing.T) {
+	tests := []struct {
+		account     string
+		expected    migrationAccount
+		expectedErr bool
+	}{
+		{account: "account", expected: migrationAccount{resourceGroup: "defaultrg", accountName: "account"}},
+		{account: " rg/account ", expected: migrationAccount{resourceGroup: "rg", accountName: "account"}},
+		{account: "", expectedErr: true},
+		{account: "rg/", expectedErr: true},
+		{account: "subs/rg/account", expectedErr: true},
+	}
+	for _, test := range tests {
+		result, err := parseMigrationAccount(test.account, "defaultrg")
+		assert.Equal(t, test.expectedErr, err != nil, test.account)
+		assert.Equal(t, test.expected, result, test.account)
+	}
+}
+
+func TestParseMigrationShares(t *testing.T) {
+	assert.Nil(t, parseMigrationShares(""))
+	assert.Equal(t, []string{"share1", "share2"}, parseMigrationShares("share1, share2,,share1"))
+}
+
+func TestRunShareMigrationInvalidParameters(t *testing.T) {
+	d := NewFakeDriver()
+	tests := []struct {
+		desc   string
+		source string
+		target string
+		shares string
+	}{
+		{desc: "invalid source account", source: "rg/", target: "target", shares: "share"},
+		{desc: "empty target account", source: "source", target: "", shares: "share"},
+		{desc: "same source and target account", source: "rg/source", target: "Source", shares: "share"},
+		{desc: "no file share", source: "source", target: "target", shares: " , "},
+	}
+	for _, test := range tests {
+		assert.Error(t, d.runShareMigration(context.Background(), test.source, test.target, test.shares), test.desc)
+	}
+}
+
+func TestEnsureMigrationTargetAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	sourceAccount := migrationAccount{resourceGroup: "rg", accountName: "source"}
+	targetAccount := migrationAccount{resourceGroup: "rg2", accountName: "target"}
+	notFound := &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("not found")}
+
+	// existing target account is used as is
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subscriptionID", "rg2", "target").Return(storage.Account{}, nil)
+	assert.NoError(t, d.ensureMigrationTargetAccount(context.Background(), sourceAccount, targetAccount))
+
+	// target account is created like the source account
+	source := storage.Account{
+		Sku:      &storage.Sku{Name: storage.SkuNamePremiumLRS},
+		Kind:     storage.KindFileStorage,
+		Location: pointer.String("eastus"),
+		Tags:     map[string]*string{"team": pointer.String("a")},
+		AccountProperties: &storage.AccountProperties{
+			LargeFileSharesState: storage.LargeFileSharesStateEnabled,
+			MinimumTLSVersion:    storage.MinimumTLSVersionTLS12,
+		},
+	}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subscriptionID", "rg2", "target").Return(storage.Account{}, notFound)
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subscriptionID", "rg", "source").Return(source, nil)
+	mockStorageAccountsClient.EXPECT().Create(gomock.Any(), "subscriptionID", "rg2", "target", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, subsID, resourceGroup, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+			assert.Equal(t, source.Sku, parameters.Sku)
+			assert.Equal(t, storage.Kind(storage.KindFileStorage), parameters.Kind)
+			assert.Equal(t, "eastus", pointer.StringDeref(parameters.Location, ""))
+			assert.Equal(t, "a", pointer.StringDeref(parameters.Tags["team"], ""))
+			assert.Equal(t, "rg/source", pointer.StringDeref(parameters.Tags[migrationSourceTag], ""))
+			assert.Equal(t, storage.LargeFileSharesStateEnabled, parameters.LargeFileSharesState)
+			assert.Equal(t, storage.MinimumTLSVersionTLS12, parameters.MinimumTLSVersion)
+			return nil
+		})
+	assert.NoError(t, d.ensureMigrationTargetAccount(context.Background(), sourceAccount, targetAccount))
+	assert.Nil(t, source.Tags[migrationSourceTag])
+
+	// other errors of getting target account are returned
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subscriptionID", "rg2", "target").Return(storage.Account{}, &retry.Error{RawError: fmt.Errorf("test error")})
+	assert.Error(t, d.ensureMigrationTargetAccount(context.Background(), sourceAccount, targetAccount))
+}
+
+func TestEnsureMigrationTargetShare(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+	sourceAccount := migrationAccount{resourceGroup: "rg", accountName: "source"}
+	targetAccount := migrationAccount{resourceGroup: "rg", accountName: "target"}
+	smbShare := storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), AccessTier: storage.ShareAccessTierHot}}
+	nfsShare := storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), EnabledProtocols: storage.EnabledProtocolsNFS}}
+
+	// missing target share is created with quota and access tier of source share
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "source", "share", "").Return(smbShare, nil)
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "target", "share", "").Return(storage.FileShare{}, fmt.Errorf(fileShareNotFound))
+	mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "target", gomock.Any(), "").DoAndReturn(
+		func(ctx context.Context, resourceGroup, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+			assert.Equal(t, "share", shareOptions.Name)
+			assert.Equal(t, 100, shareOptions.RequestGiB)
+			assert.Equal(t, string(storage.ShareAccessTierHot), shareOptions.AccessTier)
+			return storage.FileShare{}, nil
+		})
+	assert.NoError(t, d.ensureMigrationTargetShare(context.Background(), sourceAccount, targetAccount, "share"))
+
+	// existing target share is used as is
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "source", "share", "").Return(smbShare, nil)
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "target", "share", "").Return(smbShare, nil)
+	assert.NoError(t, d.ensureMigrationTargetShare(context.Background(), sourceAccount, targetAccount, "share"))
+
+	// NFS share could not be migrated
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "source", "nfs", "").Return(nfsShare, nil)
+	assert.Error(t, d.ensureMigrationTargetShare(context.Background(), sourceAccount, targetAccount, "nfs"))
+
+	// missing source share
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "source", "missing", "").Return(storage.FileShare{}, fmt.Errorf(fileShareNotFound))
+	assert.Error(t, d.ensureMigrationTargetShare(context.Background(), sourceAccount, targetAccount, "missing"))
+}
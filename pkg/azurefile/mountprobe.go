@@ -0,0 +1,312 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	mountProbePath = "/debug/probe-mount"
+	// a probe gives up after this timeout, a mount which returns later is still cleaned up
+	mountProbeTimeout = 30 * time.Second
+
+	mountProbeStepVolume      = "volume"
+	mountProbeStepCredentials = "credentials"
+	mountProbeStepDNS         = "dns"
+	mountProbeStepNetwork     = "network"
+	mountProbeStepMount       = "mount"
+
+	smbPort = "445"
+	nfsPort = "2049"
+)
+
+var (
+	// overridden in unit tests
+	mountProbeLookupHost = net.DefaultResolver.LookupHost
+	mountProbeDial       = (&net.Dialer{}).DialContext
+)
+
+// mountProbeResult is the result of probing whether a volume is mountable on this node,
+// steps are run in order and the probe stops at the first failed step
+type mountProbeResult struct {
+	VolumeID string           `json:"volumeID"`
+	NodeID   string           `json:"nodeID"`
+	Source   string           `json:"source,omitempty"`
+	Steps    []mountProbeStep `json:"steps"`
+	// volume, credentials, dns, network or mount, empty if the volume is mountable, a mount rejected
+	// by the server with permission denied is reported as credentials failure
+	FailedStep string `json:"failedStep,omitempty"`
+}
+
+type mountProbeStep struct {
+	Name     string `json:"name"`
+	Duration string `json:"duration"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// mountProbeHandler probes whether the volume in volumeID query parameter is mountable on this node, the volume
+// context is never taken from the request, so a probe could not be sent to an arbitrary server
+func (d *Driver) mountProbeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	volumeID := query.Get("volumeID")
+	if volumeID == "" {
+		http.Error(w, "volumeID query parameter is required", http.StatusBadRequest)
+		return
+	}
+	for k := range query {
+		if k != "volumeID" {
+			http.Error(w, fmt.Sprintf("query parameter %s is not supported, volume context is resolved from the PV or the staged volume", k), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), mountProbeTimeout)
+	defer cancel()
+	result := d.probeMount(ctx, volumeID)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.Warningf("failed to encode mount probe result: %v", err)
+	}
+}
+
+// probeMount resolves the volume context of volumeID, checks account credentials, DNS resolution and connectivity
+// of the file server, then mounts the volume read-only on a temporary directory and unmounts it, the volume is
+// never staged
+func (d *Driver) probeMount(ctx context.Context, volumeID string) mountProbeResult {
+	result := mountProbeResult{VolumeID: volumeID, NodeID: d.NodeID, Steps: []mountProbeStep{}}
+	run := func(name string, f func() error) bool {
+		start := time.Now()
+		err := f()
+		step := mountProbeStep{Name: name, Duration: time.Since(start).String()}
+		if err != nil {
+			step.Error = err.Error()
+			result.FailedStep = name
+			if name == mountProbeStepMount && isMountPermissionDeniedError(err) {
+				result.FailedStep = mountProbeStepCredentials
+			}
+		}
+		result.Steps = append(result.Steps, step)
+		return err == nil
+	}
+
+	var volumeContext map[string]string
+	if !run(mountProbeStepVolume, func() error {
+		var err error
+		volumeContext, err = d.getMountProbeVolumeContext(ctx, volumeID)
+		return err
+	}) {
+		return result
+	}
+
+	var protocol, server, storageEndpointSuffix, accountName, accountKey, fileShareName string
+	for k, v := range volumeContext {
+		switch strings.ToLower(k) {
+		case protocolField:
+			protocol = v
+		case serverNameField:
+			server = strings.TrimSpace(v)
+		case storageEndpointSuffixField:
+			storageEndpointSuffix = v
+		}
+	}
+
+	if !run(mountProbeStepCredentials, func() error {
+		var err error
+		_, accountName, accountKey, fileShareName, _, _, err = d.GetAccountInfo(ctx, volumeID, nil, volumeContext)
+		if err != nil {
+			return err
+		}
+		if fileShareName == "" || (server == "" && accountName == "") {
+			return fmt.Errorf("failed to get account name or file share name from %s", volumeID)
+		}
+		if !isNFSProtocol(protocol) && accountKey == "" {
+			return fmt.Errorf("account key of account(%s) is empty", accountName)
+		}
+		return nil
+	}) {
+		return result
+	}
+
+	if storageEndpointSuffix == "" {
+		if storageEndpointSuffix = d.getCloud(accountName).Environment.StorageEndpointSuffix; storageEndpointSuffix == "" {
+			storageEndpointSuffix = defaultStorageEndPointSuffix
+		}
+	}
+	if server == "" {
+		server = getDefaultServerAddress(protocol, accountName, storageEndpointSuffix)
+	}
+	result.Source = fmt.Sprintf("//%s/%s", server, fileShareName)
+	port := smbPort
+	if isNFSProtocol(protocol) {
+		result.Source = fmt.Sprintf("%s:/%s/%s", server, accountName, fileShareName)
+		port = nfsPort
+	}
+
+	if !run(mountProbeStepDNS, func() error {
+		if net.ParseIP(server) != nil {
+			return nil
+		}
+		addrs, err := mountProbeLookupHost(ctx, server)
+		if err != nil {
+			return err
+		}
+		klog.V(2).Infof("mount probe of volume(%s): %s resolves to %v", volumeID, server, addrs)
+		return nil
+	}) {
+		return result
+	}
+
+	if !run(mountProbeStepNetwork, func() error {
+		conn, err := mountProbeDial(ctx, "tcp", net.JoinHostPort(server, port))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}) {
+		return result
+	}
+
+	if runtime.GOOS != "linux" {
+		result.Steps = append(result.Steps, mountProbeStep{Name: mountProbeStepMount, Duration: "0s", Skipped: true, Error: fmt.Sprintf("mount probe is not supported on %s", runtime.GOOS)})
+		return result
+	}
+	run(mountProbeStepMount, func() error {
+		return d.probeMountAndUnmount(ctx, result.Source, protocol, accountName, accountKey)
+	})
+	return result
+}
+
+// getMountProbeVolumeContext returns the volume context of volumeID from the PV of the driver with the volume
+// handle, the node stage secret of the PV is used to get the account key, or from the volume staged on this node
+// if the PV could not be found
+func (d *Driver) getMountProbeVolumeContext(ctx context.Context, volumeID string) (map[string]string, error) {
+	if d.cloud != nil && d.cloud.KubeClient != nil {
+		pvs, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			klog.Warningf("failed to list persistent volumes for mount probe of volume(%s): %v", volumeID, err)
+		} else {
+			for i := range pvs.Items {
+				pv := &pvs.Items[i]
+				if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.Spec.CSI.VolumeHandle != volumeID {
+					continue
+				}
+				volumeContext := make(map[string]string, len(pv.Spec.CSI.VolumeAttributes)+2)
+				for k, v := range pv.Spec.CSI.VolumeAttributes {
+					volumeContext[k] = v
+				}
+				if ref := pv.Spec.CSI.NodeStageSecretRef; ref != nil {
+					volumeContext[secretNameField] = ref.Name
+					volumeContext[secretNamespaceField] = ref.Namespace
+				}
+				return volumeContext, nil
+			}
+		}
+	}
+	if vol, ok := d.getStagedVolume(volumeID, ""); ok && vol.volumeContext != nil {
+		return vol.volumeContext, nil
+	}
+	return nil, fmt.Errorf("volume(%s) is neither found in persistent volumes nor staged on this node", volumeID)
+}
+
+// recordStagedVolumeContext records the volume context of the volume staged on stagingPath for mount probe
+func (d *Driver) recordStagedVolumeContext(stagingPath string, volumeContext map[string]string) {
+	if v, ok := d.stagedVolumes.Load(stagingPath); ok {
+		vol := v.(stagedVolume)
+		vol.volumeContext = make(map[string]string, len(volumeContext))
+		for k, val := range volumeContext {
+			vol.volumeContext[k] = val
+		}
+		d.stagedVolumes.Store(stagingPath, vol)
+	}
+}
+
+// probeMountAndUnmount mounts source read-only on a temporary directory, the mount is always unmounted
+// and the directory removed after the mount returns, even if ctx is done before that
+func (d *Driver) probeMountAndUnmount(ctx context.Context, source, protocol, accountName, accountKey string) error {
+	fsType := cifs
+	var mountOptions, sensitiveMountOptions []string
+	var err error
+	if protocol == blobNFS {
+		fsType = nfs
+		if mountOptions, err = getBlobNFSMountOptions([]string{"ro"}); err != nil {
+			return err
+		}
+	} else if protocol == nfs {
+		fsType = nfs
+		if mountOptions, _, err = getNFSMountOptions([]string{"ro"}); err != nil {
+			return err
+		}
+	} else {
+		if mountOptions, err = getSMBMountOptions([]string{"ro"}, true, d.smbHandleTimeout, d.smbEchoInterval); err != nil {
+			return err
+		}
+		sensitiveMountOptions = []string{fmt.Sprintf("username=%s,password=%s", accountName, accountKey)}
+	}
+	if err := d.ensureMountHelper(fsType); err != nil {
+		return err
+	}
+
+	target, err := os.MkdirTemp("", "azurefile-probe-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary mount directory: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		mountErr := SMBMount(d.mounter, source, target, fsType, mountOptions, sensitiveMountOptions)
+		if mountErr == nil {
+			if err := CleanupMountPoint(d.mounter, target, true); err != nil {
+				klog.Errorf("failed to unmount probe mount %s on %s: %v", source, target, err)
+				done <- fmt.Errorf("mount succeeded, but unmounting probe mount on %s failed: %v", target, err)
+				return
+			}
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			klog.Warningf("failed to remove probe mount directory %s: %v", target, err)
+		}
+		done <- mountErr
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("mount %s did not return before probe timeout: %v", source, ctx.Err())
+	}
+}
+
+// isMountPermissionDeniedError returns true if the file server rejects the mount credentials,
+// mount.cifs returns EACCES in this case
+func isMountPermissionDeniedError(err error) bool {
+	return strings.Contains(err.Error(), "mount error(13)") || strings.Contains(strings.ToLower(err.Error()), permissionDenied)
+}
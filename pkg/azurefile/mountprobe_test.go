@@ -0,0 +1,251 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestProbeMount(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on Windows")
+	}
+	origLookupHost, origDial := mountProbeLookupHost, mountProbeDial
+	defer func() {
+		mountProbeLookupHost, mountProbeDial = origLookupHost, origDial
+	}()
+	var dialedAddress string
+	mountProbeLookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host == "unknown.file.core.windows.net" {
+			return nil, fmt.Errorf("no such host")
+		}
+		return []string{"10.0.0.1"}, nil
+	}
+	mountProbeDial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialedAddress = address
+		if address == "10.0.0.2:445" {
+			return nil, fmt.Errorf("i/o timeout")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+
+	mountSteps := []string{mountProbeStepVolume, mountProbeStepCredentials, mountProbeStepDNS, mountProbeStepNetwork, mountProbeStepMount}
+	tests := []struct {
+		desc          string
+		volumeID      string
+		volumeContext map[string]string
+		// the volume context is in the PV by default
+		staged             bool
+		notFound           bool
+		expectedSource     string
+		expectedSteps      []string
+		expectedFailedStep string
+		expectedDial       string
+	}{
+		{
+			desc:               "volume not found",
+			volumeID:           "rg#account#share",
+			notFound:           true,
+			expectedSteps:      []string{mountProbeStepVolume},
+			expectedFailedStep: mountProbeStepVolume,
+		},
+		{
+			desc:               "invalid volumeID",
+			volumeID:           "invalid",
+			expectedSteps:      []string{mountProbeStepVolume, mountProbeStepCredentials},
+			expectedFailedStep: mountProbeStepCredentials,
+		},
+		{
+			desc:               "DNS resolution failure",
+			volumeID:           "rg#unknown#share",
+			expectedSource:     "//unknown.file.core.windows.net/share",
+			expectedSteps:      []string{mountProbeStepVolume, mountProbeStepCredentials, mountProbeStepDNS},
+			expectedFailedStep: mountProbeStepDNS,
+		},
+		{
+			desc:               "connection failure",
+			volumeID:           "rg#account#share",
+			volumeContext:      map[string]string{"server": "10.0.0.2"},
+			expectedSource:     "//10.0.0.2/share",
+			expectedSteps:      []string{mountProbeStepVolume, mountProbeStepCredentials, mountProbeStepDNS, mountProbeStepNetwork},
+			expectedFailedStep: mountProbeStepNetwork,
+			expectedDial:       "10.0.0.2:445",
+		},
+		{
+			desc:               "mount failure",
+			volumeID:           "rg#account#share",
+			volumeContext:      map[string]string{"server": "error_mount_sens"},
+			expectedSource:     "//error_mount_sens/share",
+			expectedSteps:      mountSteps,
+			expectedFailedStep: mountProbeStepMount,
+			expectedDial:       "error_mount_sens:445",
+		},
+		{
+			desc:           "mountable SMB volume",
+			volumeID:       "rg#account#share",
+			expectedSource: "//account.file.core.windows.net/share",
+			expectedSteps:  mountSteps,
+			expectedDial:   "account.file.core.windows.net:445",
+		},
+		{
+			desc:           "mountable NFS volume",
+			volumeID:       "rg#nfsaccount#share",
+			volumeContext:  map[string]string{"protocol": nfs},
+			expectedSource: "nfsaccount.file.core.windows.net:/nfsaccount/share",
+			expectedSteps:  mountSteps,
+			expectedDial:   "nfsaccount.file.core.windows.net:2049",
+		},
+		{
+			desc:           "mountable NFS volume staged on this node",
+			volumeID:       "rg#nfsaccount#share",
+			volumeContext:  map[string]string{"protocol": nfs},
+			staged:         true,
+			expectedSource: "nfsaccount.file.core.windows.net:/nfsaccount/share",
+			expectedSteps:  mountSteps,
+			expectedDial:   "nfsaccount.file.core.windows.net:2049",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			d.mounter, _ = NewFakeMounter()
+			d.accountCacheMap.Set("account", "key")
+			d.accountCacheMap.Set("unknown", "key")
+			d.cloud.KubeClient = fake.NewSimpleClientset()
+			if !test.notFound && !test.staged {
+				d.cloud.KubeClient = fake.NewSimpleClientset(&v1.PersistentVolume{
+					ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+					Spec: v1.PersistentVolumeSpec{
+						PersistentVolumeSource: v1.PersistentVolumeSource{
+							CSI: &v1.CSIPersistentVolumeSource{Driver: d.Name, VolumeHandle: test.volumeID, VolumeAttributes: test.volumeContext},
+						},
+					},
+				})
+			}
+			if test.staged {
+				d.recordStagedVolume(test.volumeID, "/staging", "", nfs, nil, defaultVolumeStatsTimeout)
+				d.recordStagedVolumeContext("/staging", test.volumeContext)
+			}
+			dialedAddress = ""
+			tmpDir := t.TempDir()
+			t.Setenv("TMPDIR", tmpDir)
+
+			result := d.probeMount(context.Background(), test.volumeID)
+			assert.Equal(t, test.volumeID, result.VolumeID)
+			assert.Equal(t, test.expectedSource, result.Source)
+			assert.Equal(t, test.expectedFailedStep, result.FailedStep)
+			assert.Equal(t, test.expectedDial, dialedAddress)
+			var steps []string
+			for _, step := range result.Steps {
+				steps = append(steps, step.Name)
+				if step.Name == test.expectedFailedStep {
+					assert.NotEmpty(t, step.Error)
+				}
+			}
+			assert.Equal(t, test.expectedSteps, steps)
+			if runtime.GOOS != "linux" && len(result.Steps) == len(mountSteps) {
+				assert.True(t, result.Steps[len(mountSteps)-1].Skipped)
+			}
+			// the temporary mount directory is always removed
+			entries, err := filepath.Glob(filepath.Join(tmpDir, "azurefile-probe-*"))
+			assert.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestProbeMountPermissionDenied(t *testing.T) {
+	assert.True(t, isMountPermissionDeniedError(fmt.Errorf("mount error(13): Permission denied")))
+	assert.True(t, isMountPermissionDeniedError(fmt.Errorf("mount failed: exit status 32, output: permission denied")))
+	assert.False(t, isMountPermissionDeniedError(fmt.Errorf("mount error(112): Host is down")))
+}
+
+func TestMountProbeHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on Windows")
+	}
+	d := NewFakeDriver()
+	d.mounter, _ = NewFakeMounter()
+	d.cloud.KubeClient = fake.NewSimpleClientset()
+
+	tests := []struct {
+		desc               string
+		method             string
+		url                string
+		expectedCode       int
+		expectedFailedStep string
+	}{
+		{
+			desc:         "method not allowed",
+			method:       http.MethodGet,
+			url:          mountProbePath + "?volumeID=rg%23account%23share",
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+		{
+			desc:         "missing volumeID",
+			method:       http.MethodPost,
+			url:          mountProbePath,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			desc:         "volume context in query parameters",
+			method:       http.MethodPost,
+			url:          mountProbePath + "?volumeID=rg%23account%23share&server=10.0.0.1",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			desc:               "volume not found",
+			method:             http.MethodPost,
+			url:                mountProbePath + "?volumeID=invalid",
+			expectedCode:       http.StatusOK,
+			expectedFailedStep: mountProbeStepVolume,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, nil)
+			rec := httptest.NewRecorder()
+			d.mountProbeHandler(rec, req)
+			assert.Equal(t, test.expectedCode, rec.Code)
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var result mountProbeResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+			assert.Equal(t, test.expectedFailedStep, result.FailedStep)
+			assert.Equal(t, d.NodeID, result.NodeID)
+		})
+	}
+}
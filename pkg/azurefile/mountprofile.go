@@ -0,0 +1,101 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// loadMountProfiles reads mount profiles from a yaml or json file, which maps profile name to mount options, e.g.
+//
+//	secure:
+//	- vers=3.1.1
+//	- seal
+//	highThroughput: ["nconnect=4", "actimeo=30"]
+func loadMountProfiles(path string) (map[string][]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount profiles file(%s): %v", path, err)
+	}
+	var profiles map[string][]string
+	if err := yaml.UnmarshalStrict(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse mount profiles file(%s): %v", path, err)
+	}
+	result := map[string][]string{}
+	for name, options := range profiles {
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("empty mount profile name in file(%s)", path)
+		}
+		var mountOptions []string
+		for _, option := range options {
+			for _, v := range strings.Split(option, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					mountOptions = append(mountOptions, v)
+				}
+			}
+		}
+		if len(mountOptions) == 0 {
+			return nil, fmt.Errorf("mount profile(%s) in file(%s) has no mount options", name, path)
+		}
+		result[name] = mountOptions
+	}
+	return result, nil
+}
+
+// resolveMountProfile appends mount options of the profile to mountFlags, explicit mount options in mountFlags
+// take precedence, so a profile option is dropped if mountFlags sets an option with the same name
+func resolveMountProfile(profiles map[string][]string, name string, mountFlags []string) ([]string, error) {
+	if name == "" {
+		return mountFlags, nil
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		definedProfiles := make([]string, 0, len(profiles))
+		for k := range profiles {
+			definedProfiles = append(definedProfiles, k)
+		}
+		sort.Strings(definedProfiles)
+		return nil, fmt.Errorf("mount profile(%s) is not defined, defined mount profiles: %v", name, definedProfiles)
+	}
+
+	explicit := map[string]bool{}
+	for _, mountFlag := range mountFlags {
+		for _, option := range strings.Split(mountFlag, ",") {
+			explicit[getMountOptionName(option)] = true
+		}
+	}
+	result := append([]string{}, mountFlags...)
+	for _, option := range profile {
+		if !explicit[getMountOptionName(option)] {
+			result = append(result, option)
+		}
+	}
+	return result, nil
+}
+
+// getMountOptionName returns the lower case name of mount option in format name or name=value
+func getMountOptionName(option string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(option, "=", 2)[0]))
+}
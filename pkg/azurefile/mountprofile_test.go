@@ -0,0 +1,122 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMountProfiles(t *testing.T) {
+	tests := []struct {
+		desc             string
+		content          string
+		expectedProfiles map[string][]string
+		expectedErr      bool
+	}{
+		{
+			desc:    "yaml profiles",
+			content: "secure:\n- vers=3.1.1\n- seal\nhighThroughput: [\"nconnect=4,actimeo=30\"]\n",
+			expectedProfiles: map[string][]string{
+				"secure":         {"vers=3.1.1", "seal"},
+				"highThroughput": {"nconnect=4", "actimeo=30"},
+			},
+		},
+		{
+			desc:             "json profiles",
+			content:          `{"strictConsistency": ["cache=strict", "actimeo=0"]}`,
+			expectedProfiles: map[string][]string{"strictConsistency": {"cache=strict", "actimeo=0"}},
+		},
+		{
+			desc:        "profile without mount options",
+			content:     "secure: []\n",
+			expectedErr: true,
+		},
+		{
+			desc:        "invalid format",
+			content:     "secure: seal\n",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "profiles.yaml")
+			assert.NoError(t, os.WriteFile(path, []byte(test.content), 0600))
+			profiles, err := loadMountProfiles(path)
+			assert.Equal(t, test.expectedErr, err != nil, "unexpected error: %v", err)
+			if !test.expectedErr {
+				assert.Equal(t, test.expectedProfiles, profiles)
+			}
+		})
+	}
+
+	profiles, err := loadMountProfiles("")
+	assert.NoError(t, err)
+	assert.Nil(t, profiles)
+	_, err = loadMountProfiles(filepath.Join(t.TempDir(), "not-exist.yaml"))
+	assert.Error(t, err)
+}
+
+func TestResolveMountProfile(t *testing.T) {
+	profiles := map[string][]string{
+		"secure":         {"vers=3.1.1", "seal"},
+		"highThroughput": {"nconnect=4", "actimeo=30"},
+	}
+	tests := []struct {
+		desc               string
+		profile            string
+		mountFlags         []string
+		expectedMountFlags []string
+		expectedErr        error
+	}{
+		{
+			desc:               "no profile",
+			mountFlags:         []string{"actimeo=10"},
+			expectedMountFlags: []string{"actimeo=10"},
+		},
+		{
+			desc:               "profile is appended to mount options",
+			profile:            "secure",
+			mountFlags:         []string{"dir_mode=0777"},
+			expectedMountFlags: []string{"dir_mode=0777", "vers=3.1.1", "seal"},
+		},
+		{
+			desc:               "explicit mount options override profile",
+			profile:            "highThroughput",
+			mountFlags:         []string{"dir_mode=0777,ACTIMEO=10"},
+			expectedMountFlags: []string{"dir_mode=0777,ACTIMEO=10", "nconnect=4"},
+		},
+		{
+			desc:        "unknown profile",
+			profile:     "fast",
+			expectedErr: fmt.Errorf("mount profile(fast) is not defined, defined mount profiles: [highThroughput secure]"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			mountFlags, err := resolveMountProfile(profiles, test.profile, test.mountFlags)
+			assert.Equal(t, test.expectedErr, err)
+			assert.Equal(t, test.expectedMountFlags, mountFlags)
+		})
+	}
+}
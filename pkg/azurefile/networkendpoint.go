@@ -0,0 +1,150 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// file share is mounted through the public endpoint of the storage account
+	publicEndpoint = "public"
+)
+
+// parseNetworkEndpointType returns public or privateendpoint, case insensitive, empty value is returned as it is
+func parseNetworkEndpointType(value string) (string, error) {
+	switch {
+	case value == "":
+		return "", nil
+	case strings.EqualFold(value, publicEndpoint):
+		return publicEndpoint, nil
+	case strings.EqualFold(value, privateEndpoint):
+		return privateEndpoint, nil
+	}
+	return "", fmt.Errorf("invalid %s: %s in storage class, supported values are [%s privateEndpoint]", networkEndpointTypeField, value, publicEndpoint)
+}
+
+// getNetworkEndpointType returns the effective network endpoint type of networkEndpointType parameter, the driver
+// default is used if the parameter is not set, and the parameter could not override the default if it's disallowed
+func (d *Driver) getNetworkEndpointType(value string) (string, error) {
+	endpointType, err := parseNetworkEndpointType(value)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	if endpointType == "" {
+		endpointType = d.defaultNetworkEndpointType
+	} else if d.defaultNetworkEndpointType != "" && endpointType != d.defaultNetworkEndpointType && !d.allowNetworkEndpointTypeOverride {
+		return "", status.Errorf(codes.InvalidArgument, "%s(%s) in storage class is not allowed, the driver requires %s(%s) for all volumes", networkEndpointTypeField, value, networkEndpointTypeField, d.defaultNetworkEndpointType)
+	}
+	if endpointType == "" {
+		endpointType = publicEndpoint
+	}
+	return endpointType, nil
+}
+
+// validatePrivateEndpointPrerequisites checks that the private endpoint created with the storage account could be
+// placed in a subnet, private DNS zone and its virtual network link are created by cloud provider in the resource
+// group of the virtual network
+func validatePrivateEndpointPrerequisites(cloud *azure.Cloud, vnetName, subnetName string) error {
+	if vnetName == "" {
+		vnetName = cloud.VnetName
+	}
+	if subnetName == "" {
+		subnetName = cloud.SubnetName
+	}
+	if vnetName == "" || subnetName == "" {
+		return status.Errorf(codes.InvalidArgument, "%s: %s requires virtual network and subnet of the private endpoint, set %s and %s in storage class or vnetName and subnetName in cloud config",
+			networkEndpointTypeField, privateEndpoint, vnetNameField, subnetNameField)
+	}
+	return nil
+}
+
+// checkAccountPrivateEndpoint checks the storage account specified in storage class with private endpoint, the driver
+// does not create private endpoint on it, so it fails with FailedPrecondition if the account has no approved private
+// endpoint connection
+func (d *Driver) checkAccountPrivateEndpoint(ctx context.Context, subsID, resourceGroup, accountName string) error {
+	cloud := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	if cloud.StorageAccountClient == nil {
+		klog.Warningf("skip checking private endpoint of storage account(%s) since StorageAccountClient is nil", accountName)
+		return nil
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		klog.Warningf("failed to check private endpoint of storage account(%s) in resource group(%s): %v", accountName, resourceGroup, rerr.Error())
+		return nil
+	}
+	if !hasApprovedPrivateEndpointConnection(account) {
+		return status.Errorf(codes.FailedPrecondition, "storage account(%s) in resource group(%s) has no approved private endpoint connection, create a private endpoint on the account or remove storageAccount from storage class to let driver create one with %s: %s",
+			accountName, resourceGroup, networkEndpointTypeField, privateEndpoint)
+	}
+	return nil
+}
+
+func hasApprovedPrivateEndpointConnection(account storage.Account) bool {
+	if account.AccountProperties == nil || account.AccountProperties.PrivateEndpointConnections == nil {
+		return false
+	}
+	for _, conn := range *account.AccountProperties.PrivateEndpointConnections {
+		if conn.PrivateEndpointConnectionProperties == nil || conn.PrivateEndpointConnectionProperties.PrivateLinkServiceConnectionState == nil {
+			continue
+		}
+		if conn.PrivateEndpointConnectionProperties.PrivateLinkServiceConnectionState.Status == storage.PrivateEndpointServiceConnectionStatusApproved {
+			return true
+		}
+	}
+	return false
+}
+
+// warnIfAccountFirewallDeniesSubnet logs a warning if the file share on public endpoint of the account could not be
+// mounted from subnetID
+func warnIfAccountFirewallDeniesSubnet(account storage.Account, subnetID string) {
+	if reason := getAccountFirewallDenial(account, subnetID); reason != "" {
+		klog.Warningf("file share on storage account(%s) may not be mounted from the cluster: %s", pointer.StringDeref(account.Name, ""), reason)
+	}
+}
+
+// getAccountFirewallDenial returns why the account denies access from subnetID on its public endpoint, IP rules are
+// not evaluated since egress IP of the cluster is not known
+func getAccountFirewallDenial(account storage.Account, subnetID string) string {
+	if account.AccountProperties == nil {
+		return ""
+	}
+	if account.AccountProperties.PublicNetworkAccess == storage.PublicNetworkAccessDisabled {
+		return fmt.Sprintf("public network access is disabled, set %s: %s in storage class", networkEndpointTypeField, privateEndpoint)
+	}
+	ruleSet := account.AccountProperties.NetworkRuleSet
+	if ruleSet == nil || ruleSet.DefaultAction != storage.DefaultActionDeny {
+		return ""
+	}
+	if ruleSet.VirtualNetworkRules != nil {
+		for _, rule := range *ruleSet.VirtualNetworkRules {
+			if strings.EqualFold(pointer.StringDeref(rule.VirtualNetworkResourceID, ""), subnetID) {
+				return ""
+			}
+		}
+	}
+	return fmt.Sprintf("firewall denies access by default and subnet(%s) is not in virtual network rules, egress IP of the cluster must be allowed by IP rules", subnetID)
+}
@@ -0,0 +1,330 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestParseNetworkEndpointType(t *testing.T) {
+	tests := []struct {
+		value        string
+		expectedType string
+		expectedErr  error
+	}{
+		{value: "", expectedType: ""},
+		{value: "Public", expectedType: publicEndpoint},
+		{value: "privateEndpoint", expectedType: privateEndpoint},
+		{value: "PRIVATEENDPOINT", expectedType: privateEndpoint},
+		{value: "serviceEndpoint", expectedErr: fmt.Errorf("invalid networkendpointtype: serviceEndpoint in storage class, supported values are [public privateEndpoint]")},
+	}
+	for _, test := range tests {
+		endpointType, err := parseNetworkEndpointType(test.value)
+		assert.Equal(t, test.expectedErr, err, test.value)
+		assert.Equal(t, test.expectedType, endpointType, test.value)
+	}
+}
+
+func TestGetNetworkEndpointType(t *testing.T) {
+	tests := []struct {
+		desc             string
+		defaultType      string
+		disallowOverride bool
+		value            string
+		expectedType     string
+		expectedErr      error
+	}{
+		{
+			desc:         "public without driver default",
+			expectedType: publicEndpoint,
+		},
+		{
+			desc:         "storage class without driver default",
+			value:        "privateEndpoint",
+			expectedType: privateEndpoint,
+		},
+		{
+			desc:         "driver default",
+			defaultType:  privateEndpoint,
+			expectedType: privateEndpoint,
+		},
+		{
+			desc:         "storage class overrides driver default",
+			defaultType:  privateEndpoint,
+			value:        "public",
+			expectedType: publicEndpoint,
+		},
+		{
+			desc:             "storage class could not override driver default",
+			defaultType:      privateEndpoint,
+			disallowOverride: true,
+			value:            "public",
+			expectedErr:      status.Errorf(codes.InvalidArgument, "networkendpointtype(public) in storage class is not allowed, the driver requires networkendpointtype(privateendpoint) for all volumes"),
+		},
+		{
+			desc:             "storage class same as driver default",
+			defaultType:      privateEndpoint,
+			disallowOverride: true,
+			value:            "PrivateEndpoint",
+			expectedType:     privateEndpoint,
+		},
+		{
+			desc:        "invalid value",
+			value:       "none",
+			expectedErr: status.Errorf(codes.InvalidArgument, "invalid networkendpointtype: none in storage class, supported values are [public privateEndpoint]"),
+		},
+	}
+	for _, test := range tests {
+		d := NewFakeDriverCustomOptions(DriverOptions{
+			NodeID:                              fakeNodeID,
+			DriverName:                          DefaultDriverName,
+			DefaultNetworkEndpointType:          test.defaultType,
+			DisallowNetworkEndpointTypeOverride: test.disallowOverride,
+		})
+		endpointType, err := d.getNetworkEndpointType(test.value)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedType, endpointType, test.desc)
+	}
+}
+
+func TestValidatePrivateEndpointPrerequisites(t *testing.T) {
+	cloud := &azure.Cloud{}
+	expectedErr := status.Errorf(codes.InvalidArgument, "networkendpointtype: privateendpoint requires virtual network and subnet of the private endpoint, set vnetname and subnetname in storage class or vnetName and subnetName in cloud config")
+	assert.Equal(t, expectedErr, validatePrivateEndpointPrerequisites(cloud, "", ""))
+	assert.Equal(t, expectedErr, validatePrivateEndpointPrerequisites(cloud, "vnet", ""))
+	assert.NoError(t, validatePrivateEndpointPrerequisites(cloud, "vnet", "subnet"))
+
+	cloud.VnetName = "cloud-vnet"
+	cloud.SubnetName = "cloud-subnet"
+	assert.NoError(t, validatePrivateEndpointPrerequisites(cloud, "", ""))
+}
+
+func TestGetAccountFirewallDenial(t *testing.T) {
+	subnetID := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+	tests := []struct {
+		desc           string
+		properties     *storage.AccountProperties
+		expectedReason string
+	}{
+		{
+			desc: "no properties",
+		},
+		{
+			desc:       "no firewall",
+			properties: &storage.AccountProperties{},
+		},
+		{
+			desc:       "firewall allows by default",
+			properties: &storage.AccountProperties{NetworkRuleSet: &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionAllow}},
+		},
+		{
+			desc: "subnet is allowed",
+			properties: &storage.AccountProperties{NetworkRuleSet: &storage.NetworkRuleSet{
+				DefaultAction:       storage.DefaultActionDeny,
+				VirtualNetworkRules: &[]storage.VirtualNetworkRule{{VirtualNetworkResourceID: pointer.String(subnetID)}},
+			}},
+		},
+		{
+			desc: "subnet is not allowed",
+			properties: &storage.AccountProperties{NetworkRuleSet: &storage.NetworkRuleSet{
+				DefaultAction:       storage.DefaultActionDeny,
+				VirtualNetworkRules: &[]storage.VirtualNetworkRule{{VirtualNetworkResourceID: pointer.String(subnetID + "2")}},
+			}},
+			expectedReason: fmt.Sprintf("firewall denies access by default and subnet(%s) is not in virtual network rules, egress IP of the cluster must be allowed by IP rules", subnetID),
+		},
+		{
+			desc:           "public network access is disabled",
+			properties:     &storage.AccountProperties{PublicNetworkAccess: storage.PublicNetworkAccessDisabled},
+			expectedReason: "public network access is disabled, set networkendpointtype: privateendpoint in storage class",
+		},
+	}
+	for _, test := range tests {
+		reason := getAccountFirewallDenial(storage.Account{AccountProperties: test.properties}, subnetID)
+		assert.Equal(t, test.expectedReason, reason, test.desc)
+	}
+}
+
+func TestCheckAccountPrivateEndpoint(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	approved := storage.Account{AccountProperties: &storage.AccountProperties{PrivateEndpointConnections: &[]storage.PrivateEndpointConnection{
+		{PrivateEndpointConnectionProperties: &storage.PrivateEndpointConnectionProperties{
+			PrivateLinkServiceConnectionState: &storage.PrivateLinkServiceConnectionState{Status: storage.PrivateEndpointServiceConnectionStatusApproved},
+		}},
+	}}}
+	pending := storage.Account{AccountProperties: &storage.AccountProperties{PrivateEndpointConnections: &[]storage.PrivateEndpointConnection{
+		{PrivateEndpointConnectionProperties: &storage.PrivateEndpointConnectionProperties{
+			PrivateLinkServiceConnectionState: &storage.PrivateLinkServiceConnectionState{Status: storage.PrivateEndpointServiceConnectionStatusPending},
+		}},
+	}}}
+
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subs", "rg", "approved").Return(approved, nil).Times(1)
+	assert.NoError(t, d.checkAccountPrivateEndpoint(context.Background(), "subs", "rg", "approved"))
+
+	expectedErr := status.Errorf(codes.FailedPrecondition, "storage account(pending) in resource group(rg) has no approved private endpoint connection, create a private endpoint on the account or remove storageAccount from storage class to let driver create one with networkendpointtype: privateendpoint")
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subs", "rg", "pending").Return(pending, nil).Times(1)
+	assert.Equal(t, expectedErr, d.checkAccountPrivateEndpoint(context.Background(), "subs", "rg", "pending"))
+
+	// account which could not be read is not rejected
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subs", "rg", "unknown").Return(storage.Account{}, &retry.Error{HTTPStatusCode: http.StatusForbidden, RawError: fmt.Errorf("AuthorizationFailed")}).Times(1)
+	assert.NoError(t, d.checkAccountPrivateEndpoint(context.Background(), "subs", "rg", "unknown"))
+}
+
+func TestCreateVolumeNetworkEndpointType(t *testing.T) {
+	value := base64.StdEncoding.EncodeToString([]byte("acc_key"))
+	keys := storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: &value}}}
+	fakeShareQuota := int32(100)
+	stdVolCap := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+	approved := storage.Account{AccountProperties: &storage.AccountProperties{PrivateEndpointConnections: &[]storage.PrivateEndpointConnection{
+		{PrivateEndpointConnectionProperties: &storage.PrivateEndpointConnectionProperties{
+			PrivateLinkServiceConnectionState: &storage.PrivateLinkServiceConnectionState{Status: storage.PrivateEndpointServiceConnectionStatusApproved},
+		}},
+	}}}
+
+	tests := []struct {
+		desc             string
+		defaultType      string
+		disallowOverride bool
+		parameters       map[string]string
+		// the specified account is only read with private endpoint, nil means GetProperties is not expected
+		account          *storage.Account
+		expectServerName string
+		expectedErr      error
+	}{
+		{
+			desc:       "public endpoint of specified account",
+			parameters: map[string]string{storageAccountField: "stoacc"},
+		},
+		{
+			desc:        "storage class overrides private endpoint of driver default",
+			defaultType: privateEndpoint,
+			parameters:  map[string]string{storageAccountField: "stoacc", networkEndpointTypeField: "public"},
+		},
+		{
+			desc:             "private endpoint of specified account by driver default",
+			defaultType:      privateEndpoint,
+			parameters:       map[string]string{storageAccountField: "stoacc"},
+			account:          &approved,
+			expectServerName: "stoacc.privatelink.file.core.windows.net",
+		},
+		{
+			desc:        "specified account without private endpoint",
+			defaultType: privateEndpoint,
+			parameters:  map[string]string{storageAccountField: "stoacc"},
+			account:     &storage.Account{},
+			expectedErr: status.Errorf(codes.FailedPrecondition, "storage account(stoacc) in resource group(rg) has no approved private endpoint connection, create a private endpoint on the account or remove storageAccount from storage class to let driver create one with networkendpointtype: privateendpoint"),
+		},
+		{
+			desc:             "storage class could not override driver default",
+			defaultType:      privateEndpoint,
+			disallowOverride: true,
+			parameters:       map[string]string{storageAccountField: "stoacc", networkEndpointTypeField: "public"},
+			expectedErr:      status.Errorf(codes.InvalidArgument, "networkendpointtype(public) in storage class is not allowed, the driver requires networkendpointtype(privateendpoint) for all volumes"),
+		},
+		{
+			desc:        "private endpoint of new account requires virtual network",
+			defaultType: privateEndpoint,
+			parameters:  map[string]string{},
+			expectedErr: status.Errorf(codes.InvalidArgument, "networkendpointtype: privateendpoint requires virtual network and subnet of the private endpoint, set vnetname and subnetname in storage class or vnetName and subnetName in cloud config"),
+		},
+		{
+			desc:        "invalid network endpoint type",
+			parameters:  map[string]string{networkEndpointTypeField: "serviceEndpoint"},
+			expectedErr: status.Errorf(codes.InvalidArgument, "invalid networkendpointtype: serviceEndpoint in storage class, supported values are [public privateEndpoint]"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			d := NewFakeDriverCustomOptions(DriverOptions{
+				NodeID:                              fakeNodeID,
+				DriverName:                          DefaultDriverName,
+				DefaultNetworkEndpointType:          test.defaultType,
+				DisallowNetworkEndpointTypeOverride: test.disallowOverride,
+			})
+			d.cloud = &azure.Cloud{}
+			d.cloud.KubeClient = fake.NewSimpleClientset()
+			mockFileClient := mockfileclient.NewMockInterface(ctrl)
+			d.cloud.FileClient = mockFileClient
+			mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+			d.cloud.StorageAccountClient = mockStorageAccountsClient
+			d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+
+			mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+			mockFileClient.EXPECT().CreateFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: nil}}, nil).AnyTimes()
+			mockFileClient.EXPECT().GetFileShare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: &fakeShareQuota}}, nil).AnyTimes()
+			mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, nil).AnyTimes()
+			if test.account != nil {
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "stoacc").Return(*test.account, nil).Times(1)
+			}
+
+			parameters := map[string]string{
+				resourceGroupField: "rg",
+				shareNameField:     "share",
+			}
+			for k, v := range test.parameters {
+				parameters[k] = v
+			}
+			req := &csi.CreateVolumeRequest{
+				Name:               "random-vol-name-network-endpoint-type",
+				VolumeCapabilities: stdVolCap,
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 10 * 1024 * 1024 * 1024},
+				Parameters:         parameters,
+			}
+			resp, err := d.CreateVolume(context.Background(), req)
+			assert.Equal(t, test.expectedErr, err)
+			if err == nil {
+				assert.Equal(t, test.expectServerName, resp.Volume.VolumeContext[serverNameField])
+				if test.expectServerName != "" {
+					assert.Equal(t, privateEndpoint, resp.Volume.VolumeContext[networkEndpointTypeField])
+				}
+			}
+		})
+	}
+}
@@ -83,24 +83,78 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		}
 	}
 
+	propagation, _, err := getMountPropagation(volCap.GetMount().GetMountFlags())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	readOnlyMount, err := isReadOnlyMount(req.GetReadonly(), volCap.GetMount().GetMountFlags())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume(%s): %v", volumeID, err)
+	}
+	readOnly := readOnlyMount ||
+		volCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		volCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+	if isSharedMountPropagation(propagation) && readOnly {
+		// mounts created under a shared mount propagate to the host and to other pods using the volume
+		return nil, status.Errorf(codes.InvalidArgument, "mount propagation(%s) is not supported on read-only volume(%s), use rslave or rprivate instead", propagation, volumeID)
+	}
+
+	targetPathPerm, targetPathUID, targetPathGID, err := getTargetPathAttributes(context)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v in volume context", err)
+	}
+	setTargetPathAttributes := targetPathPerm != nil || targetPathUID != -1 || targetPathGID != -1
+	if setTargetPathAttributes && runtime.GOOS == "windows" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s and %s are not supported on Windows", targetPathPermissionsField, targetPathOwnerField)
+	}
+
+	if d.disableStageUnstage {
+		if setTargetPathAttributes {
+			// the share is mounted on target path directly, there is no mount point distinct from the share content
+			return nil, status.Errorf(codes.InvalidArgument, "%s and %s of volume(%s) are not supported when STAGE_UNSTAGE_VOLUME is disabled", targetPathPermissionsField, targetPathOwnerField, volumeID)
+		}
+		return d.publishWithoutStage(ctx, req, propagation, readOnlyMount)
+	}
+
 	source := req.GetStagingTargetPath()
 	if len(source) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Staging target not provided")
 	}
 
 	mountOptions := []string{"bind"}
-	if req.GetReadonly() {
+	if readOnlyMount {
 		mountOptions = append(mountOptions, "ro")
 	}
+	if propagation != "" {
+		mountOptions = append(mountOptions, propagation)
+	}
+
+	if err := d.checkSingleWriterPublish(volumeID, target, volCap.GetAccessMode()); err != nil {
+		return nil, err
+	}
 
-	mnt, err := d.ensureMountPoint(target, os.FileMode(mountPermissions))
+	mountPointPerm := os.FileMode(mountPermissions)
+	if targetPathPerm != nil {
+		mountPointPerm = *targetPathPerm
+	}
+	mnt, err := d.ensureMountPoint(target, mountPointPerm)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Could not mount target %s: %v", target, err)
 	}
 	if mnt {
 		klog.V(2).Infof("NodePublishVolume: %s is already mounted", target)
+		if err := d.acquireStageReference(volumeID, source, target); err != nil {
+			return nil, err
+		}
+		d.recordSingleWriterPublish(volumeID, target, volCap.GetAccessMode())
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
+	if setTargetPathAttributes {
+		// the mount point is hidden by the bind mount, so it must be set before mounting
+		if err := setMountPointAttributes(target, targetPathPerm, targetPathUID, targetPathGID); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to set permissions or owner of target %s: %v", target, err)
+		}
+	}
 
 	if err = preparePublishPath(target, d.mounter); err != nil {
 		return nil, status.Errorf(codes.Internal, "prepare publish failed for %s with error: %v", target, err)
@@ -114,10 +168,61 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		return nil, status.Errorf(codes.Internal, "Could not mount %s at %s: %v", source, target, err)
 	}
 	klog.V(2).Infof("NodePublishVolume: mount %s at %s successfully", source, target)
+	if err := d.acquireStageReference(volumeID, source, target); err != nil {
+		return nil, err
+	}
+	d.recordSingleWriterPublish(volumeID, target, volCap.GetAccessMode())
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// acquireStageReference records target as a reference of the staging path if NodeUnstageVolume honors references
+func (d *Driver) acquireStageReference(volumeID, stagingPath, target string) error {
+	if d.unstagePolicy == unstagePolicyUnmount {
+		return nil
+	}
+	if err := d.stageRefs.acquire(volumeID, stagingPath, target); err != nil {
+		return status.Errorf(codes.Internal, "volume(%s): %v", volumeID, err)
+	}
+	return nil
+}
+
+// publishWithoutStage mounts the volume on target path by NodeStageVolume when STAGE_UNSTAGE_VOLUME capability
+// is disabled, so account key is fetched and cifs/nfs mount is done in NodePublishVolume, read-only mount is
+// applied by ro mount option since there is no bind mount, it's idempotent as NodeStageVolume
+func (d *Driver) publishWithoutStage(ctx context.Context, req *csi.NodePublishVolumeRequest, propagation string, readOnly bool) (*csi.NodePublishVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if propagation != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "mount propagation(%s) of volume(%s) is not supported when STAGE_UNSTAGE_VOLUME is disabled", propagation, volumeID)
+	}
+	volCap := req.GetVolumeCapability()
+	mountFlags := volCap.GetMount().GetMountFlags()
+	if readOnly {
+		mountFlags = util.JoinMountOptions(mountFlags, []string{"ro"})
+	}
+
+	klog.V(2).Infof("NodePublishVolume: mounting volume(%s) on %s without staging", volumeID, req.GetTargetPath())
+	if _, err := d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: req.GetTargetPath(),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{
+					FsType:           volCap.GetMount().GetFsType(),
+					MountFlags:       mountFlags,
+					VolumeMountGroup: volCap.GetMount().GetVolumeMountGroup(),
+				},
+			},
+			AccessMode: volCap.GetAccessMode(),
+		},
+		Secrets:       req.GetSecrets(),
+		VolumeContext: req.GetVolumeContext(),
+	}); err != nil {
+		return nil, err
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
 // NodeUnpublishVolume unmount the volume from the target path
 func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	if len(req.GetVolumeId()) == 0 {
@@ -129,11 +234,23 @@ func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 	targetPath := req.GetTargetPath()
 	volumeID := req.GetVolumeId()
 
+	if d.disableStageUnstage {
+		// the volume is mounted on target path by NodeStageVolume in NodePublishVolume
+		if _, err := d.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: volumeID, StagingTargetPath: targetPath}); err != nil {
+			return nil, err
+		}
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+
 	klog.V(2).Infof("NodeUnpublishVolume: unmounting volume %s on %s", volumeID, targetPath)
 	if err := CleanupMountPoint(d.mounter, targetPath, true /*extensiveMountPointCheck*/); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount target %s: %v", targetPath, err)
 	}
 	klog.V(2).Infof("NodeUnpublishVolume: unmount volume %s on %s successfully", volumeID, targetPath)
+	d.singleWriterTargets.Delete(targetPath)
+	if d.unstagePolicy != unstagePolicyUnmount {
+		d.stageRefs.release(volumeID, targetPath)
+	}
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
@@ -154,11 +271,15 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 
 	volumeID := req.GetVolumeId()
 	context := req.GetVolumeContext()
-	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
+	// mount propagation only applies to the bind mount in NodePublishVolume
+	_, mountFlags, err := getMountPropagation(req.GetVolumeCapability().GetMount().GetMountFlags())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	volumeMountGroup := req.GetVolumeCapability().GetMount().GetVolumeMountGroup()
 	gidPresent := checkGidPresentInMountFlags(mountFlags)
 
-	_, accountName, accountKey, fileShareName, diskName, _, err := d.GetAccountInfo(ctx, volumeID, req.GetSecrets(), context)
+	resourceGroupName, accountName, accountKey, fileShareName, diskName, subsID, err := d.GetAccountInfo(ctx, volumeID, req.GetSecrets(), context)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("GetAccountInfo(%s) failed with error: %v", volumeID, err))
 	}
@@ -167,13 +288,19 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	}
 	// don't respect fsType from req.GetVolumeCapability().GetMount().GetFsType()
 	// since it's ext4 by default on Linux
-	var fsType, server, protocol, ephemeralVolMountOptions, storageEndpointSuffix, folderName string
-	var ephemeralVol bool
+	var fsType, server, protocol, ephemeralVolMountOptions, storageEndpointSuffix, folderName, mountProfile, sku string
+	var ephemeralVol, privateEndpointServer bool
+	var fallbackServers []string
 	fileShareNameReplaceMap := map[string]string{}
 
 	mountPermissions := d.mountPermissions
 	performChmodOp := (mountPermissions > 0)
 	fsGroupChangePolicy := d.fsGroupChangePolicy
+	enableMfsymlinks := true
+	encryptInTransit := false
+	enableCompression := false
+	disableSMBLeases := false
+	var sourceAddress, mountTenant string
 
 	for k, v := range context {
 		switch strings.ToLower(k) {
@@ -185,8 +312,16 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			diskName = v
 		case folderNameField:
 			folderName = v
+		case mountProfileField:
+			mountProfile = v
 		case serverNameField:
 			server = v
+		case networkEndpointTypeField:
+			privateEndpointServer = strings.EqualFold(v, privateEndpoint)
+		case fallbackServersField:
+			if fallbackServers, err = parseFallbackServers(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v in volume context", err)
+			}
 		case ephemeralField:
 			ephemeralVol = strings.EqualFold(v, trueValue)
 		case mountOptionsField:
@@ -195,6 +330,36 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			storageEndpointSuffix = v
 		case fsGroupChangePolicyField:
 			fsGroupChangePolicy = v
+		case enableMfsymlinksField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", enableMfsymlinksField, v)
+			}
+			enableMfsymlinks = value
+		case encryptInTransitField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", encryptInTransitField, v)
+			}
+			encryptInTransit = value
+		case enableCompressionField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", enableCompressionField, v)
+			}
+			enableCompression = value
+		case disableSMBLeasesField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", disableSMBLeasesField, v)
+			}
+			disableSMBLeases = value
+		case sourceAddressField:
+			sourceAddress = v
+		case skuNameField, storageAccountTypeField:
+			sku = v
+		case mountTenantField:
+			mountTenant = v
 		case pvcNamespaceKey:
 			fileShareNameReplaceMap[pvcNamespaceMetadata] = v
 		case pvcNameKey:
@@ -217,30 +382,85 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		}
 	}
 
-	if server == "" && accountName == "" {
-		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to get account name from %s", volumeID))
-	}
-
 	if !isSupportedFsType(fsType) {
 		return nil, status.Errorf(codes.InvalidArgument, "fsType(%s) is not supported, supported fsType list: %v", fsType, supportedFsTypeList)
 	}
-
 	if !isSupportedProtocol(protocol) {
 		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported protocol list: %v", protocol, supportedProtocolList)
 	}
+	// static PV may only set fsType: nfs without protocol
+	if protocol, err = resolveProtocol(fsType, protocol); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if mountProfile != "" {
+		if isDiskFsType(fsType) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with fsType(%s)", mountProfileField, fsType)
+		}
+		if mountFlags, err = resolveMountProfile(d.mountProfiles, mountProfile, mountFlags); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		gidPresent = checkGidPresentInMountFlags(mountFlags)
+		klog.V(2).Infof("volume(%s) mount flags(%v) after applying mount profile(%s)", volumeID, mountFlags, mountProfile)
+	}
+
+	if encryptInTransit && isNFSProtocol(protocol) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", encryptInTransitField)
+	}
+	if enableCompression && isNFSProtocol(protocol) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", enableCompressionField)
+	}
+	if disableSMBLeases && isNFSProtocol(protocol) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", disableSMBLeasesField)
+	}
+	if sourceAddress != "" && isNFSProtocol(protocol) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", sourceAddressField)
+	}
+	if mountTenant != "" && isNFSProtocol(protocol) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with SMB protocol", mountTenantField)
+	}
+
+	if server == "" && accountName == "" {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to get account name from %s", volumeID))
+	}
+
+	server = strings.TrimSpace(server)
+	if !isValidServerAddress(server) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context, only IPv4 address or DNS name is supported", serverNameField, server)
+	}
 
 	if !isSupportedFSGroupChangePolicy(fsGroupChangePolicy) {
 		return nil, status.Errorf(codes.InvalidArgument, "fsGroupChangePolicy(%s) is not supported, supported fsGroupChangePolicy list: %v", fsGroupChangePolicy, supportedFSGroupChangePolicyList)
 	}
 
+	// driver-wide --require-smb-encryption could not be overridden by encryptInTransit in volume context
+	requireEncryption := !isNFSProtocol(protocol) && (encryptInTransit || d.requireSMBEncryption)
+
 	if acquired := d.volumeLocks.TryAcquire(volumeID); !acquired {
 		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
 	}
 	defer d.volumeLocks.Release(volumeID)
 
+	if err := d.checkCrossRegionMount(ctx, subsID, resourceGroupName, accountName); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume(%s): %v", volumeID, err)
+	}
+
+	if connStr := getConnectionString(req.GetSecrets()); connStr != "" {
+		conn, err := parseConnectionString(connStr)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s field in secrets: %v", secretConnectionString, err)
+		}
+		if strings.TrimSpace(server) == "" {
+			server = conn.fileEndpoint
+		}
+		if strings.TrimSpace(storageEndpointSuffix) == "" {
+			storageEndpointSuffix = conn.endpointSuffix
+		}
+	}
+
 	if strings.TrimSpace(storageEndpointSuffix) == "" {
-		if d.cloud.Environment.StorageEndpointSuffix != "" {
-			storageEndpointSuffix = d.cloud.Environment.StorageEndpointSuffix
+		if cloud := d.getCloud(accountName); cloud.Environment.StorageEndpointSuffix != "" {
+			storageEndpointSuffix = cloud.Environment.StorageEndpointSuffix
 		} else {
 			storageEndpointSuffix = defaultStorageEndPointSuffix
 		}
@@ -251,16 +471,20 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 
 	osSeparator := string(os.PathSeparator)
 	if strings.TrimSpace(server) == "" {
-		// server address is "accountname.file.core.windows.net" by default
-		server = fmt.Sprintf("%s.file.%s", accountName, storageEndpointSuffix)
-	}
-	source := fmt.Sprintf("%s%s%s%s%s", osSeparator, osSeparator, server, osSeparator, fileShareName)
-	if protocol == nfs {
-		source = fmt.Sprintf("%s:/%s/%s", server, accountName, fileShareName)
+		// server address is "accountname.file.core.windows.net" by default, "accountname.blob.core.windows.net" for blob NFS
+		server = getDefaultServerAddress(protocol, accountName, storageEndpointSuffix)
 	}
-	if folderName != "" {
-		source = fmt.Sprintf("%s%s%s", source, osSeparator, folderName)
+	getSource := func(server string) string {
+		source := fmt.Sprintf("%s%s%s%s%s", osSeparator, osSeparator, server, osSeparator, fileShareName)
+		if isNFSProtocol(protocol) {
+			source = fmt.Sprintf("%s:/%s/%s", server, accountName, fileShareName)
+		}
+		if folderName != "" {
+			source = fmt.Sprintf("%s%s%s", source, osSeparator, folderName)
+		}
+		return source
 	}
+	source := getSource(server)
 
 	cifsMountPath := targetPath
 	cifsMountFlags := mountFlags
@@ -269,6 +493,13 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	}
 	isDiskMount := isDiskFsType(fsType)
 	if isDiskMount {
+		for _, mountFlag := range mountFlags {
+			for _, option := range strings.Split(mountFlag, ",") {
+				if isOwnerMountOption(option) {
+					return nil, status.Errorf(codes.InvalidArgument, "mount option(%s) is not supported with fsType(%s)", strings.TrimSpace(option), fsType)
+				}
+			}
+		}
 		if !strings.HasSuffix(diskName, vhdSuffix) {
 			return nil, status.Errorf(codes.Internal, "diskname could not be empty, targetPath: %s", targetPath)
 		}
@@ -277,13 +508,47 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	}
 
 	var mountOptions, sensitiveMountOptions []string
-	if protocol == nfs {
-		mountOptions = util.JoinMountOptions(mountFlags, []string{"vers=4,minorversion=1,sec=sys"})
+	probeTimeout := defaultVolumeStatsTimeout
+	if protocol == blobNFS {
+		if mountOptions, err = getBlobNFSMountOptions(mountFlags); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err := d.checkBlobNFSAccount(ctx, subsID, resourceGroupName, accountName); err != nil {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+	} else if protocol == nfs {
+		var nfsVersion string
+		if mountOptions, nfsVersion, err = getNFSMountOptions(mountFlags); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		klog.V(2).Infof("volume(%s) mount with nfs version(%s)", volumeID, nfsVersion)
 	} else {
 		if accountName == "" || accountKey == "" {
 			return nil, status.Errorf(codes.Internal, "accountName(%s) or accountKey is empty", accountName)
 		}
+		if requireEncryption {
+			if err := checkSMBEncryptionSupport(d.mounter); err != nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "volume(%s) requires SMB encryption: %v", volumeID, err)
+			}
+		}
+		if disableSMBLeases {
+			// unlike compression, the volume is not mounted with leases since the workload may rely on it
+			if err := checkSMBNoLeaseSupport(d.mounter); err != nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "volume(%s) requires SMB leases to be disabled: %v", volumeID, err)
+			}
+		}
+		if sourceAddress != "" && runtime.GOOS == "windows" {
+			// SMB global mapping on Windows does not bind to a source address
+			return nil, status.Errorf(codes.FailedPrecondition, "volume(%s) requires %s(%s) which is not supported on Windows", volumeID, sourceAddressField, sourceAddress)
+		}
+		if mountTenant != "" && runtime.GOOS == "windows" {
+			// SMB global mapping on Windows shares one SMB session per server among all mappings
+			return nil, status.Errorf(codes.FailedPrecondition, "volume(%s) requires %s(%s) which is not supported on Windows", volumeID, mountTenantField, mountTenant)
+		}
 		if runtime.GOOS == "windows" {
+			if enableCompression {
+				klog.Warningf("volume(%s) is mounted without SMB compression: %v", volumeID, checkSMBCompressionSupport(d.mounter))
+			}
 			mountOptions = []string{fmt.Sprintf("AZURE\\%s", accountName)}
 			sensitiveMountOptions = []string{accountKey}
 		} else {
@@ -295,32 +560,116 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			if ephemeralVol {
 				cifsMountFlags = util.JoinMountOptions(cifsMountFlags, strings.Split(ephemeralVolMountOptions, ","))
 			}
-			mountOptions = appendDefaultMountOptions(cifsMountFlags)
+			if mountOptions, err = getSMBMountOptions(cifsMountFlags, enableMfsymlinks, d.smbHandleTimeout, d.smbEchoInterval); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			if version := getSMBVersion(mountOptions); version != "" && !d.isAllowedSMBVersion(version) {
+				return nil, status.Errorf(codes.InvalidArgument, "SMB version(%s) in mount options is not allowed, allowed SMB version list: %v", version, d.allowedSMBVersions)
+			}
+			if d.autoSelectSMBVersion && getSMBVersion(mountOptions) == "" {
+				if version := d.selectSMBVersion(sku, requireEncryption); version != "" {
+					klog.V(2).Infof("volume(%s) is mounted with SMB version(%s) selected for sku(%s)", volumeID, version, sku)
+					mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", vers, version))
+				} else {
+					klog.Warningf("no allowed SMB version is supported for volume(%s) with sku(%s), SMB version is negotiated by the node kernel", volumeID, sku)
+				}
+			}
+			if version := d.getHighestAllowedSMBVersion(); version != "" && getSMBVersion(mountOptions) == "" {
+				// without vers option the node kernel could negotiate an SMB version which is not allowed
+				klog.V(2).Infof("volume(%s) is mounted with the highest allowed SMB version(%s)", volumeID, version)
+				mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", vers, version))
+			}
+			if requireEncryption {
+				if version := getSMBVersion(mountOptions); !isSMBEncryptionSupportedVersion(version) {
+					return nil, status.Errorf(codes.InvalidArgument, "SMB version(%s) in mount options does not support encryption, SMB 3.0 or later is required", version)
+				}
+				if !isSMBSealEnabled(mountOptions) {
+					mountOptions = append(mountOptions, seal)
+				}
+			}
+			if enableCompression && !hasMountOption(mountOptions, compress) {
+				// compression is an optimization, the volume is still mounted if the node does not support it
+				if err := checkSMBCompressionSupport(d.mounter); err != nil {
+					klog.Warningf("volume(%s) is mounted without SMB compression: %v", volumeID, err)
+				} else {
+					mountOptions = append(mountOptions, compress)
+				}
+			}
+			if disableSMBLeases && !hasMountOption(mountOptions, nolease) {
+				mountOptions = append(mountOptions, nolease)
+			}
+			if sourceAddress != "" {
+				addr, err := resolveSourceAddress(sourceAddress)
+				if err != nil {
+					return nil, status.Errorf(codes.FailedPrecondition, "volume(%s) requires %s(%s): %v", volumeID, sourceAddressField, sourceAddress, err)
+				}
+				if existing := getSourceAddressMountOption(mountOptions); existing == "" {
+					mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", srcaddr, addr))
+				} else if existing != addr {
+					return nil, status.Errorf(codes.InvalidArgument, "%s(%s) conflicts with %s=%s in mount options", sourceAddressField, sourceAddress, srcaddr, existing)
+				}
+			}
+			if mountTenant != "" && !hasMountOption(mountOptions, nosharesock) {
+				// the mount gets its own connection and SMB session instead of sharing those of other mounts to the
+				// same server, so that session options of other tenants could not apply to it
+				klog.V(2).Infof("volume(%s) of tenant(%s) is mounted with %s", volumeID, mountTenant, nosharesock)
+				mountOptions = append(mountOptions, nosharesock)
+			}
+			probeTimeout = getSMBProbeTimeout(mountOptions)
 		}
 	}
 
 	klog.V(2).Infof("cifsMountPath(%v) fstype(%v) volumeID(%v) context(%v) mountflags(%v) mountOptions(%v) volumeMountGroup(%s)", cifsMountPath, fsType, volumeID, context, mountFlags, mountOptions, volumeMountGroup)
 
+	share := newStagedShare(accountName, fileShareName, folderName, volumeCapability.GetAccessMode())
+	if !isDiskMount {
+		release, err := d.checkSingleWriterStage(volumeID, targetPath, share)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
 	isDirMounted, err := d.ensureMountPoint(cifsMountPath, os.FileMode(mountPermissions))
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Could not mount target %s: %v", cifsMountPath, err)
 	}
 	if isDirMounted {
 		klog.V(2).Infof("NodeStageVolume: volume %s is already mounted on %s", volumeID, targetPath)
+		if !isDiskMount {
+			if err := d.checkStagedVolume(volumeID, targetPath, mountOptions); err != nil {
+				return nil, err
+			}
+		}
 	} else {
+		if err := d.waitForServerDNS(ctx, server, privateEndpointServer); err != nil {
+			if len(fallbackServers) == 0 {
+				return nil, status.Errorf(codes.Unavailable, "volume(%s) is not mounted since %v", volumeID, err)
+			}
+			klog.Warningf("volume(%s) is mounted with fallback servers(%v) since %v", volumeID, fallbackServers, err)
+		}
 		mountFsType := cifs
-		if protocol == nfs {
+		if isNFSProtocol(protocol) {
 			mountFsType = nfs
 		}
+		if err := d.ensureMountHelper(mountFsType); err != nil {
+			return nil, err
+		}
 		if err := prepareStagePath(cifsMountPath, d.mounter); err != nil {
 			return nil, status.Errorf(codes.Internal, "prepare stage path failed for %s with error: %v", cifsMountPath, err)
 		}
-		if err := wait.PollImmediate(1*time.Second, 2*time.Minute, func() (bool, error) {
-			return true, SMBMount(d.mounter, source, cifsMountPath, mountFsType, mountOptions, sensitiveMountOptions)
-		}); err != nil {
+		servers := []string{server}
+		for _, fallbackServer := range fallbackServers {
+			if !strings.EqualFold(fallbackServer, server) {
+				servers = append(servers, fallbackServer)
+			}
+		}
+		source, err = d.mountWithFallbackServers(volumeID, servers, getSource, cifsMountPath, mountFsType, mountOptions, sensitiveMountOptions)
+		if err != nil {
+			d.invalidateAccountKey(accountName, err)
 			return nil, status.Error(codes.Internal, fmt.Sprintf("volume(%s) mount %s on %s failed with %v", volumeID, source, cifsMountPath, err))
 		}
-		if protocol == nfs {
+		if isNFSProtocol(protocol) {
 			if performChmodOp {
 				if err := chmodIfPermissionMismatch(targetPath, os.FileMode(mountPermissions)); err != nil {
 					return nil, status.Error(codes.Internal, err.Error())
@@ -339,6 +688,11 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		}
 		if mnt {
 			klog.V(2).Infof("NodeStageVolume: volume %s is already mounted on %s", volumeID, targetPath)
+			if err := d.checkStagedVolume(volumeID, targetPath, mountOptions); err != nil {
+				return nil, err
+			}
+			d.recordStagedVolume(volumeID, targetPath, source, protocol, mountOptions, probeTimeout)
+			d.recordStagedVolumeContext(targetPath, context)
 			return &csi.NodeStageVolumeResponse{}, nil
 		}
 
@@ -357,14 +711,34 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		klog.V(2).Infof("NodeStageVolume: volume %s format %s and mounting at %s successfully", volumeID, targetPath, diskPath)
 	}
 
-	if protocol == nfs || isDiskMount {
+	if isNFSProtocol(protocol) || isDiskMount {
 		if volumeMountGroup != "" && fsGroupChangePolicy != FSGroupChangeNone {
-			klog.V(2).Infof("set gid of volume(%s) as %s using fsGroupChangePolicy(%s)", volumeID, volumeMountGroup, fsGroupChangePolicy)
-			if err := SetVolumeOwnership(cifsMountPath, volumeMountGroup, fsGroupChangePolicy); err != nil {
-				return nil, status.Error(codes.Internal, fmt.Sprintf("SetVolumeOwnership with volume(%s) on %s failed with %v", volumeID, cifsMountPath, err))
+			if d.applyPermissionsOnRootOnly {
+				klog.V(2).Infof("set gid of volume(%s) root directory %s as %s", volumeID, cifsMountPath, volumeMountGroup)
+				if err := setVolumeRootOwnership(cifsMountPath, volumeMountGroup); err != nil {
+					return nil, status.Error(codes.Internal, fmt.Sprintf("setVolumeRootOwnership with volume(%s) on %s failed with %v", volumeID, cifsMountPath, err))
+				}
+			} else {
+				klog.V(2).Infof("set gid of volume(%s) as %s using fsGroupChangePolicy(%s)", volumeID, volumeMountGroup, fsGroupChangePolicy)
+				if err := SetVolumeOwnership(cifsMountPath, volumeMountGroup, fsGroupChangePolicy); err != nil {
+					return nil, status.Error(codes.Internal, fmt.Sprintf("SetVolumeOwnership with volume(%s) on %s failed with %v", volumeID, cifsMountPath, err))
+				}
 			}
 		}
 	}
+	d.recordStagedVolume(volumeID, targetPath, source, protocol, mountOptions, probeTimeout)
+	d.recordStagedVolumeContext(targetPath, context)
+	if !isDiskMount {
+		d.recordStagedShare(targetPath, share)
+	}
+	if d.reportSMBShareStats && !isNFSProtocol(protocol) && !isDiskMount && accountKey != "" {
+		d.recordShareStatsSource(targetPath, shareStatsSource{
+			accountName:           accountName,
+			accountKey:            accountKey,
+			fileShareName:         fileShareName,
+			storageEndpointSuffix: storageEndpointSuffix,
+		})
+	}
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -384,19 +758,39 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 	}
 	defer d.volumeLocks.Release(volumeID)
 
+	if d.unstagePolicy != unstagePolicyUnmount {
+		targets, err := d.stageRefs.references(volumeID, stagingTargetPath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "volume(%s): %v", volumeID, err)
+		}
+		if len(targets) > 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume(%s) staged on %s is still published on %v", volumeID, stagingTargetPath, targets)
+		}
+	}
+
+	if err := d.unmountStagingPath(volumeID, stagingTargetPath); err != nil {
+		return nil, err
+	}
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+// unmountStagingPath unmounts the staging path and the vhd disk proxy mount next to it
+func (d *Driver) unmountStagingPath(volumeID, stagingTargetPath string) error {
 	klog.V(2).Infof("NodeUnstageVolume: CleanupMountPoint volume %s on %s", volumeID, stagingTargetPath)
 	if err := CleanupMountPoint(d.mounter, stagingTargetPath, true /*extensiveMountPointCheck*/); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to unmount staging target %s: %v", stagingTargetPath, err)
+		return status.Errorf(codes.Internal, "failed to unmount staging target %s: %v", stagingTargetPath, err)
 	}
 
 	targetPath := filepath.Join(filepath.Dir(stagingTargetPath), proxyMount)
 	klog.V(2).Infof("NodeUnstageVolume: CleanupMountPoint volume %s on %s", volumeID, targetPath)
 	if err := CleanupMountPoint(d.mounter, targetPath, false); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to unmount staging target %s: %v", targetPath, err)
+		return status.Errorf(codes.Internal, "failed to unmount staging target %s: %v", targetPath, err)
 	}
 	klog.V(2).Infof("NodeUnstageVolume: unmount volume %s on %s successfully", volumeID, stagingTargetPath)
-
-	return &csi.NodeUnstageVolumeResponse{}, nil
+	d.stagedVolumes.Delete(stagingTargetPath)
+	d.mountCommands.Delete(volumeID)
+	d.stageRefs.forget(volumeID)
+	return nil
 }
 
 // NodeGetCapabilities return the capabilities of the Node plugin
@@ -429,9 +823,14 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 		return nil, status.Errorf(codes.Internal, "failed to stat file %s: %v", req.VolumePath, err)
 	}
 
-	volumeMetrics, err := volume.NewMetricsStatFS(req.VolumePath).GetMetrics()
+	timeout := defaultVolumeStatsTimeout
+	vol, staged := d.getStagedVolume(req.VolumeId, req.GetStagingTargetPath())
+	if staged {
+		timeout = vol.probeTimeout
+	}
+	volumeMetrics, err := getVolumeMetrics(ctx, req.VolumePath, timeout)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to get metrics: %v", err)
+		return nil, err
 	}
 
 	available, ok := volumeMetrics.Available.AsInt64()
@@ -460,14 +859,26 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 		return nil, status.Errorf(codes.Internal, "failed to transform disk inodes used(%v)", volumeMetrics.InodesUsed)
 	}
 
+	bytesUsage := &csi.VolumeUsage{
+		Unit:      csi.VolumeUsage_BYTES,
+		Available: available,
+		Total:     capacity,
+		Used:      used,
+	}
+	var condition *csi.VolumeCondition
+	if staged && vol.shareStats != nil {
+		// statfs on SMB mount could report the capacity of the storage account instead of the share quota, and
+		// usage updated with delay, report quota and usage from the file share instead
+		if stats, err := d.getCachedShareStats(ctx, *vol.shareStats); err != nil {
+			klog.Warningf("report statfs of volume(%s) since getting stats of file share failed with error: %v", req.VolumeId, err)
+		} else {
+			bytesUsage, condition = applyShareStats(stats, available)
+		}
+	}
+
 	return &csi.NodeGetVolumeStatsResponse{
 		Usage: []*csi.VolumeUsage{
-			{
-				Unit:      csi.VolumeUsage_BYTES,
-				Available: available,
-				Total:     capacity,
-				Used:      used,
-			},
+			bytesUsage,
 			{
 				Unit:      csi.VolumeUsage_INODES,
 				Available: inodesFree,
@@ -475,9 +886,36 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 				Used:      inodesUsed,
 			},
 		},
+		VolumeCondition: condition,
 	}, nil
 }
 
+// getVolumeMetrics gets metrics of path, statfs on a hung smb/nfs mount would block,
+// so it's considered hung if statfs does not return in timeout
+func getVolumeMetrics(ctx context.Context, path string, timeout time.Duration) (*volume.Metrics, error) {
+	type result struct {
+		metrics *volume.Metrics
+		err     error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		metrics, err := volume.NewMetricsStatFS(path).GetMetrics()
+		resultCh <- result{metrics: metrics, err: err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		if r.err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get metrics: %v", r.err)
+		}
+		return r.metrics, nil
+	case <-time.After(timeout):
+		return nil, status.Errorf(codes.Internal, "failed to get metrics of %s in %v, the mount may be hung", path, timeout)
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
 // NodeExpandVolume node expand volume
 // N/A for azure file
 func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
@@ -541,6 +979,69 @@ func (d *Driver) ensureMountPoint(target string, perm os.FileMode) (bool, error)
 	return !notMnt, nil
 }
 
+// getTargetPathAttributes returns permissions and owner of the bind mount point in volume context, which are
+// distinct from permissions of the share content, -1 is returned for uid or gid which is not specified
+func getTargetPathAttributes(context map[string]string) (*os.FileMode, int, int, error) {
+	var perm *os.FileMode
+	uid, gid := -1, -1
+	for k, v := range context {
+		switch strings.ToLower(k) {
+		case targetPathPermissionsField:
+			if v == "" {
+				continue
+			}
+			value, err := strconv.ParseUint(v, 8, 32)
+			if err != nil || value > 0777 {
+				return nil, -1, -1, fmt.Errorf("invalid %s: %s", targetPathPermissionsField, v)
+			}
+			mode := os.FileMode(value)
+			perm = &mode
+		case targetPathOwnerField:
+			if v == "" {
+				continue
+			}
+			var err error
+			if uid, gid, err = parseTargetPathOwner(v); err != nil {
+				return nil, -1, -1, fmt.Errorf("invalid %s: %s", targetPathOwnerField, v)
+			}
+		}
+	}
+	return perm, uid, gid, nil
+}
+
+// parseTargetPathOwner parses owner in format "uid" or "uid:gid"
+func parseTargetPathOwner(owner string) (int, int, error) {
+	uidStr, gidStr, hasGID := strings.Cut(owner, ":")
+	uid, err := strconv.ParseUint(uidStr, 10, 31)
+	if err != nil {
+		return -1, -1, err
+	}
+	gid := -1
+	if hasGID {
+		value, err := strconv.ParseUint(gidStr, 10, 31)
+		if err != nil {
+			return -1, -1, err
+		}
+		gid = int(value)
+	}
+	return int(uid), gid, nil
+}
+
+// setMountPointAttributes sets permissions and owner of the mount point before mounting, the mode of a newly
+// created directory is masked by umask, so it's always set explicitly
+func setMountPointAttributes(target string, perm *os.FileMode, uid, gid int) error {
+	if uid != -1 || gid != -1 {
+		klog.V(2).Infof("chown target path(%s) to uid(%d) gid(%d)", target, uid, gid)
+		if err := os.Lchown(target, uid, gid); err != nil {
+			return err
+		}
+	}
+	if perm != nil {
+		return chmodIfPermissionMismatch(target, *perm)
+	}
+	return nil
+}
+
 func makeDir(pathname string, perm os.FileMode) error {
 	err := os.MkdirAll(pathname, perm)
 	if err != nil {
@@ -553,9 +1054,82 @@ func makeDir(pathname string, perm os.FileMode) error {
 
 func checkGidPresentInMountFlags(mountFlags []string) bool {
 	for _, mountFlag := range mountFlags {
-		if strings.HasPrefix(mountFlag, "gid") {
-			return true
+		for _, option := range strings.Split(mountFlag, ",") {
+			if kv := strings.SplitN(strings.TrimSpace(option), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], gid) {
+				return true
+			}
 		}
 	}
 	return false
 }
+
+// ensureMountHelper returns FailedPrecondition if the mount helper of fsType is not installed on node,
+// a found helper is cached, a missing one is checked again on next mount so that installing it takes effect
+func (d *Driver) ensureMountHelper(fsType string) error {
+	if _, ok := d.mountHelpers.Load(fsType); ok {
+		return nil
+	}
+	if err := checkMountHelper(d.mounter, fsType); err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	d.mountHelpers.Store(fsType, true)
+	return nil
+}
+
+// retrySMBMountWithLowerVersion retries the failed SMB mount once with the next lower allowed SMB version if
+// --smb-version-fallback is set and the mount failed with a protocol negotiation error, it returns mount
+// options of the last mount attempt and its error
+func (d *Driver) retrySMBMountWithLowerVersion(volumeID, source, target string, mountOptions, sensitiveMountOptions []string, mountErr error) ([]string, error) {
+	if !d.smbVersionFallback || runtime.GOOS == "windows" || !isSMBNegotiationError(mountErr) {
+		return mountOptions, mountErr
+	}
+	version := getSMBVersion(mountOptions)
+	fallbackVersion := d.getSMBFallbackVersion(version)
+	if fallbackVersion != "" && isSMBSealEnabled(mountOptions) && !isSMBEncryptionSupportedVersion(fallbackVersion) {
+		klog.Warningf("volume(%s) mount %s on %s failed with SMB protocol negotiation error, could not fall back to SMB version(%s) which does not support encryption", volumeID, source, target, fallbackVersion)
+		return mountOptions, mountErr
+	}
+	if fallbackVersion == "" {
+		klog.Warningf("volume(%s) mount %s on %s failed with SMB protocol negotiation error, no lower SMB version is allowed to fall back to from version(%s)", volumeID, source, target, version)
+		return mountOptions, mountErr
+	}
+	klog.Warningf("volume(%s) mount %s on %s failed with SMB protocol negotiation error: %v, downgrade SMB version from (%s) to (%s)", volumeID, source, target, mountErr, version, fallbackVersion)
+	fallbackMountOptions := setSMBVersion(mountOptions, fallbackVersion)
+	return fallbackMountOptions, SMBMount(d.mounter, source, target, cifs, fallbackMountOptions, sensitiveMountOptions)
+}
+
+// mountWithFallbackServers mounts the source of each server in order, the next server is only tried if the mount
+// fails with a connectivity error and stageMountTimeout is not exceeded, it returns the source of the last mount
+// attempt and its error
+func (d *Driver) mountWithFallbackServers(volumeID string, servers []string, getSource func(server string) string, target, fsType string, mountOptions, sensitiveMountOptions []string) (string, error) {
+	start := time.Now()
+	var source string
+	var err error
+	for i, server := range servers {
+		if i > 0 {
+			if elapsed := time.Since(start); elapsed >= stageMountTimeout {
+				return source, fmt.Errorf("%v, fallback servers(%v) are not tried since mount timeout(%v) is exceeded", err, servers[i:], stageMountTimeout)
+			}
+			klog.Warningf("volume(%s) mount %s on %s failed with connectivity error: %v, try fallback server(%s)", volumeID, source, target, err, server)
+		}
+		source = getSource(server)
+		err = wait.PollImmediate(1*time.Second, stageMountTimeout, func() (bool, error) {
+			return true, SMBMount(d.mounter, source, target, fsType, mountOptions, sensitiveMountOptions)
+		})
+		usedMountOptions := mountOptions
+		if err != nil && fsType == cifs {
+			usedMountOptions, err = d.retrySMBMountWithLowerVersion(volumeID, source, target, mountOptions, sensitiveMountOptions, err)
+		}
+		d.recordMountCommand(volumeID, source, target, fsType, usedMountOptions, sensitiveMountOptions, err)
+		if err == nil {
+			if i > 0 {
+				klog.V(2).Infof("volume(%s) mount %s on %s succeeded with fallback server(%s)", volumeID, source, target, server)
+			}
+			return source, nil
+		}
+		if !isMountConnectivityError(err) {
+			return source, err
+		}
+	}
+	return source, err
+}
@@ -20,13 +20,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
+	"time"
 
 	"sigs.k8s.io/azurefile-csi-driver/test/utils/testutil"
 
@@ -237,6 +240,69 @@ func TestNodePublishVolume(t *testing.T) {
 				DefaultError: status.Error(codes.InvalidArgument, fmt.Sprintf("invalid mountPermissions %s", "07ab")),
 			},
 		},
+		{
+			desc: "[Success] Valid request with target path permissions",
+			req: csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest,
+				VolumeContext:     map[string]string{"targetPathPermissions": "0755", mountPermissionsField: "0777"},
+			},
+			expectedErr: testutil.TestError{
+				WindowsError: status.Error(codes.InvalidArgument, "targetpathpermissions and targetpathowner are not supported on Windows"),
+			},
+		},
+		{
+			desc: "[Error] invalid targetPathPermissions",
+			req: csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest,
+				VolumeContext:     map[string]string{"targetPathPermissions": "1777"},
+			},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "invalid targetpathpermissions: 1777 in volume context"),
+			},
+		},
+		{
+			desc: "[Error] invalid targetPathOwner",
+			req: csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest,
+				VolumeContext:     map[string]string{targetPathOwnerField: "root"},
+			},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "invalid targetpathowner: root in volume context"),
+			},
+		},
+		{
+			desc: "[Error] conflicting mount propagation",
+			req: csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap,
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"rshared", "rprivate"}}}},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest,
+			},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "mount propagation(rprivate) conflicts with mount propagation(rshared)"),
+			},
+		},
+		{
+			desc: "[Error] shared mount propagation on read-only volume",
+			req: csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap,
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"rshared"}}}},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest,
+				Readonly:          true,
+			},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "mount propagation(rshared) is not supported on read-only volume(vol_1), use rslave or rprivate instead"),
+			},
+		},
 	}
 
 	// Setup
@@ -270,6 +336,115 @@ func TestNodePublishVolume(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestNodePublishVolumeMountPropagation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on Windows")
+	}
+	d := NewFakeDriver()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+	target := testutil.GetWorkDirPath("propagation_target", t)
+	defer os.RemoveAll(target)
+
+	volumeCap := csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER}
+	req := csi.NodePublishVolumeRequest{
+		VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap,
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"dir_mode=0777", "rshared"}}}},
+		VolumeId:          "vol_1",
+		TargetPath:        target,
+		StagingTargetPath: sourceTest,
+	}
+	_, err = d.NodePublishVolume(context.Background(), &req)
+	assert.NoError(t, err)
+
+	mountPoints, err := d.mounter.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []mount.MountPoint{{Device: sourceTest, Path: target, Opts: []string{"bind", "rshared"}}}, mountPoints)
+}
+
+func TestNodePublishVolumeReadOnly(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on Windows")
+	}
+	tests := []struct {
+		desc         string
+		readonly     bool
+		mountFlags   []string
+		expectedOpts []string
+		expectedErr  error
+	}{
+		{
+			desc:         "writable volume",
+			mountFlags:   []string{"dir_mode=0777"},
+			expectedOpts: []string{"bind"},
+		},
+		{
+			desc:         "readonly flag",
+			readonly:     true,
+			expectedOpts: []string{"bind", "ro"},
+		},
+		{
+			desc:         "ro in mount options",
+			mountFlags:   []string{"dir_mode=0777,ro"},
+			expectedOpts: []string{"bind", "ro"},
+		},
+		{
+			desc:         "readonly flag and ro in mount options",
+			readonly:     true,
+			mountFlags:   []string{"ro"},
+			expectedOpts: []string{"bind", "ro"},
+		},
+		{
+			desc:         "rw in mount options",
+			mountFlags:   []string{"RW"},
+			expectedOpts: []string{"bind"},
+		},
+		{
+			desc:        "readonly flag and rw in mount options",
+			readonly:    true,
+			mountFlags:  []string{"dir_mode=0777,rw"},
+			expectedErr: status.Error(codes.InvalidArgument, "volume(vol_1): mount option(rw) conflicts with readonly flag in request, remove rw from mount options of read-only volume"),
+		},
+		{
+			desc:        "ro and rw in mount options",
+			mountFlags:  []string{"ro", "rw"},
+			expectedErr: status.Error(codes.InvalidArgument, "volume(vol_1): mount option(rw) conflicts with mount option(ro)"),
+		},
+	}
+
+	volumeCap := csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER}
+	for _, test := range tests {
+		d := NewFakeDriver()
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+		target := testutil.GetWorkDirPath("readonly_target", t)
+
+		req := csi.NodePublishVolumeRequest{
+			VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap,
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags}}},
+			VolumeId:          "vol_1",
+			TargetPath:        target,
+			StagingTargetPath: sourceTest,
+			Readonly:          test.readonly,
+		}
+		_, err = d.NodePublishVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+
+		if test.expectedErr == nil {
+			mountPoints, err := d.mounter.List()
+			assert.NoError(t, err)
+			assert.Equal(t, []mount.MountPoint{{Device: sourceTest, Path: target, Opts: test.expectedOpts}}, mountPoints, test.desc)
+		}
+		os.RemoveAll(target)
+	}
+}
+
 func TestNodeUnpublishVolume(t *testing.T) {
 	errorTarget := testutil.GetWorkDirPath("error_is_likely_target", t)
 	targetFile := testutil.GetWorkDirPath("abc.go", t)
@@ -456,7 +631,7 @@ func TestNodeStageVolume(t *testing.T) {
 					secretNameField:     "secret",
 				}},
 			expectedErr: testutil.TestError{
-				DefaultError: status.Error(codes.InvalidArgument, "GetAccountInfo(vol_1) failed with error: could not get account key from secret(secret): KubeClient is nil"),
+				DefaultError: status.Error(codes.InvalidArgument, "GetAccountInfo(vol_1) failed with error: could not get account key from secret(secret) in namespace(default): KubeClient is nil, namespace is resolved from driver default namespace"),
 			},
 		},
 		{
@@ -494,7 +669,7 @@ func TestNodeStageVolume(t *testing.T) {
 					serverNameField: "test_servername",
 				}},
 			expectedErr: testutil.TestError{
-				DefaultError: status.Error(codes.InvalidArgument, "protocol(test_protocol) is not supported, supported protocol list: [smb nfs]"),
+				DefaultError: status.Error(codes.InvalidArgument, "protocol(test_protocol) is not supported, supported protocol list: [smb nfs blobnfs]"),
 			},
 		},
 		{
@@ -522,6 +697,70 @@ func TestNodeStageVolume(t *testing.T) {
 				DefaultError: status.Errorf(codes.Internal, "accountName() or accountKey is empty"),
 			},
 		},
+		{
+			desc: "[Error] Invalid enableMfsymlinks",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					enableMfsymlinksField: "test",
+					shareNameField:        "test_sharename",
+					serverNameField:       "test_servername",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "invalid enablemfsymlinks: test in volume context"),
+			},
+		},
+		{
+			desc: "[Error] Unsupported nfs version",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							MountFlags: []string{"vers=3"},
+						},
+					},
+				},
+				VolumeContext: map[string]string{
+					protocolField:   "nfs",
+					shareNameField:  "test_sharename",
+					serverNameField: "test_servername",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "nfs version(3) is not supported by Azure Files, supported nfs version list: [4 4.1]"),
+			},
+		},
+		{
+			desc: "[Error] Unknown mount profile",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					mountProfileField: "secure",
+					shareNameField:    "test_sharename",
+					serverNameField:   "test_servername",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "mount profile(secure) is not defined, defined mount profiles: []"),
+			},
+		},
+		{
+			desc: "[Error] Unsupported blob nfs version",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							MountFlags: []string{"vers=4.1"},
+						},
+					},
+				},
+				VolumeContext: map[string]string{
+					protocolField:   "blobnfs",
+					shareNameField:  "test_container",
+					serverNameField: "test_servername",
+				}},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "nfs version(4.1) is not supported by blob NFS, only nfs version(3) is supported"),
+			},
+		},
 		{
 			desc: "[Error] Volume operation in progress",
 			setup: func() {
@@ -695,6 +934,19 @@ func TestNodeStageVolume(t *testing.T) {
 				DefaultError: status.Error(codes.InvalidArgument, fmt.Sprintf("invalid mountPermissions %s", "07ab")),
 			},
 		},
+		{
+			desc: "[Error] invalid server address",
+			req: csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					shareNameField:  "test_sharename",
+					serverNameField: "fe80::1",
+				},
+				Secrets: secrets},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "invalid server: fe80::1 in volume context, only IPv4 address or DNS name is supported"),
+			},
+		},
 	}
 
 	// Setup
@@ -752,103 +1004,1023 @@ func TestNodeStageVolume(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestNodeUnstageVolume(t *testing.T) {
-	var (
-		errorTarget = testutil.GetWorkDirPath("error_is_likely_target", t)
-		targetFile  = testutil.GetWorkDirPath("abc.go", t)
-	)
+func TestNodeStageVolumeSMBMountOptions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	sourceTest := testutil.GetWorkDirPath("source_test", t)
+	defer os.RemoveAll(sourceTest)
+
+	volCap := csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{"echo_interval=5"},
+			},
+		},
+	}
+	secrets := map[string]string{
+		"accountname": "k8s",
+		"accountkey":  "testkey",
+	}
+
 	d := NewFakeDriver()
+	d.smbHandleTimeout = 30000
+	d.smbEchoInterval = 10
+	d.cloud = &azure.Cloud{
+		Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+	}
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+
+	req := csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+		VolumeCapability: &volCap,
+		VolumeContext:    map[string]string{shareNameField: "test_sharename"},
+		Secrets:          secrets}
+	_, err = d.NodeStageVolume(context.Background(), &req)
+	assert.NoError(t, err)
+
+	mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+	assert.Len(t, mountPoints, 1)
+	assert.Contains(t, mountPoints[0].Opts, "echo_interval=5")
+	assert.Contains(t, mountPoints[0].Opts, "handletimeout=30000")
+	assert.NotContains(t, mountPoints[0].Opts, "echo_interval=10")
+
+	v, ok := d.stagedVolumes.Load(sourceTest)
+	assert.True(t, ok)
+	assert.Equal(t, 40*time.Second, v.(stagedVolume).probeTimeout)
+}
+
+func TestNodeStageVolumeSMBVersionFallback(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	secrets := map[string]string{
+		"accountname": "k8s",
+		"accountkey":  "testkey",
+	}
 
 	tests := []struct {
-		desc         string
-		setup        func()
-		req          csi.NodeUnstageVolumeRequest
-		skipOnDarwin bool
-		expectedErr  testutil.TestError
-		cleanup      func()
+		desc               string
+		mountFlags         []string
+		smbVersionFallback bool
+		allowedSMBVersions []string
+		expectedErr        error
+		expectedVersion    string
+		// version in mount options of the staged volume, version in mount flags if empty
+		expectedStagedVersion string
 	}{
 		{
-			desc: "[Error] Volume ID missing",
-			req:  csi.NodeUnstageVolumeRequest{StagingTargetPath: targetTest},
-			expectedErr: testutil.TestError{
-				DefaultError: status.Error(codes.InvalidArgument, "Volume ID missing in request"),
-			},
+			desc:        "negotiation failure without fallback",
+			expectedErr: status.Error(codes.Internal, "volume(vol_1##) mount //k8s.file.test_suffix/error_smb_negotiation on %s failed with fake MountSensitive: mount error(95): Operation not supported"),
 		},
 		{
-			desc: "[Error] Target missing",
-			req:  csi.NodeUnstageVolumeRequest{VolumeId: "vol_1"},
-			expectedErr: testutil.TestError{
-				DefaultError: status.Error(codes.InvalidArgument, "Staging target not provided"),
-			},
+			desc:               "negotiation failure then success with lower version",
+			smbVersionFallback: true,
+			expectedVersion:    "3.0",
 		},
 		{
-			desc: "[Error] Volume operation in progress",
-			setup: func() {
-				d.volumeLocks.TryAcquire("vol_1")
-			},
-			req: csi.NodeUnstageVolumeRequest{StagingTargetPath: targetFile, VolumeId: "vol_1"},
-			expectedErr: testutil.TestError{
-				DefaultError: status.Error(codes.Aborted, fmt.Sprintf(volumeOperationAlreadyExistsFmt, "vol_1")),
-			},
-			cleanup: func() {
-				d.volumeLocks.Release("vol_1")
-			},
+			desc:               "negotiation failure then success with lower allowed version",
+			mountFlags:         []string{"vers=3.1.1"},
+			smbVersionFallback: true,
+			allowedSMBVersions: []string{"3.1.1", "3.0"},
+			expectedVersion:    "3.0",
 		},
 		{
-			desc:         "[Error] CleanupMountPoint error mocked by IsLikelyNotMountPoint",
-			req:          csi.NodeUnstageVolumeRequest{StagingTargetPath: errorTarget, VolumeId: "vol_1"},
-			skipOnDarwin: true,
-			expectedErr: testutil.TestError{
-				DefaultError: status.Error(codes.Internal, fmt.Sprintf("failed to unmount staging target %v: fake IsLikelyNotMountPoint: fake error", errorTarget)),
-			},
+			desc:                  "highest allowed version is set without vers in mount options",
+			smbVersionFallback:    true,
+			allowedSMBVersions:    []string{"3.1.1", "3.0"},
+			expectedVersion:       "3.0",
+			expectedStagedVersion: "3.1.1",
 		},
 		{
-			desc:        "[Success] Valid request",
-			req:         csi.NodeUnstageVolumeRequest{StagingTargetPath: targetFile, VolumeId: "vol_1"},
-			expectedErr: testutil.TestError{},
+			desc:               "no lower version is allowed",
+			smbVersionFallback: true,
+			allowedSMBVersions: []string{"3.1.1"},
+			expectedErr:        status.Error(codes.Internal, "volume(vol_1##) mount //k8s.file.test_suffix/error_smb_negotiation on %s failed with fake MountSensitive: mount error(95): Operation not supported"),
+		},
+		{
+			desc:               "version in mount options is not allowed",
+			mountFlags:         []string{"vers=2.1"},
+			allowedSMBVersions: []string{"3.1.1", "3.0"},
+			expectedErr:        status.Error(codes.InvalidArgument, "SMB version(2.1) in mount options is not allowed, allowed SMB version list: [3.1.1 3.0]"),
 		},
 	}
 
-	// Setup
-	_ = makeDir(errorTarget, 0755)
-	mounter, err := NewFakeMounter()
-	if err != nil {
-		t.Fatalf(fmt.Sprintf("failed to get fake mounter: %v", err))
-	}
-	if runtime.GOOS != "windows" {
-		mounter.Exec = &testingexec.FakeExec{ExactOrder: true}
-	}
-	d.mounter = mounter
-
 	for _, test := range tests {
-		if test.setup != nil {
-			test.setup()
+		sourceTest := testutil.GetWorkDirPath("source_test", t)
+		d := NewFakeDriver()
+		d.smbVersionFallback = test.smbVersionFallback
+		d.allowedSMBVersions = test.allowedSMBVersions
+		d.cloud = &azure.Cloud{
+			Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
 		}
-		if test.skipOnDarwin && runtime.GOOS == "darwin" {
-			continue
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
 		}
-		_, err := d.NodeUnstageVolume(context.Background(), &test.req)
-		if !testutil.AssertError(err, &test.expectedErr) {
-			t.Errorf("Desc: %v\nUnexcpected error: %v\nExpected: %v", test.desc, err, test.expectedErr.GetExpectedError())
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags},
+				},
+			},
+			VolumeContext: map[string]string{shareNameField: "error_smb_negotiation"},
+			Secrets:       secrets}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		expectedErr := test.expectedErr
+		if expectedErr != nil && status.Code(expectedErr) == codes.Internal {
+			expectedErr = status.Errorf(codes.Internal, status.Convert(expectedErr).Message(), sourceTest)
 		}
-		if test.cleanup != nil {
-			test.cleanup()
+		assert.Equal(t, expectedErr, err, test.desc)
+
+		if test.expectedVersion != "" {
+			mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+			assert.Len(t, mountPoints, 1, test.desc)
+			assert.Equal(t, test.expectedVersion, getSMBVersion(mountPoints[0].Opts), test.desc)
+			// staged volume keeps requested mount options so that a retry of NodeStageVolume matches
+			v, ok := d.stagedVolumes.Load(sourceTest)
+			assert.True(t, ok, test.desc)
+			expectedStagedVersion := test.expectedStagedVersion
+			if expectedStagedVersion == "" {
+				expectedStagedVersion = getSMBVersion(test.mountFlags)
+			}
+			assert.Equal(t, expectedStagedVersion, getSMBVersion(v.(stagedVolume).mountOptions), test.desc)
 		}
+		os.RemoveAll(sourceTest)
 	}
-
-	// Clean up
-	err = os.RemoveAll(errorTarget)
-	assert.NoError(t, err)
 }
 
-func TestNodeGetVolumeStats(t *testing.T) {
-	nonexistedPath := "/not/a/real/directory"
-	fakePath := "/tmp/fake-volume-path"
+func TestNodeStageVolumeFallbackServers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	secrets := map[string]string{
+		"accountname": "k8s",
+		"accountkey":  "testkey",
+	}
 
 	tests := []struct {
-		desc        string
-		req         csi.NodeGetVolumeStatsRequest
-		expectedErr error
+		desc           string
+		volumeContext  map[string]string
+		mountTimeout   time.Duration
+		expectedErr    error
+		expectedSource string
+	}{
+		{
+			desc:           "primary server is mounted",
+			volumeContext:  map[string]string{shareNameField: "share", fallbackServersField: "10.0.0.4"},
+			expectedSource: "//k8s.file.test_suffix/share",
+		},
+		{
+			desc:           "fallback server is mounted on connectivity error",
+			volumeContext:  map[string]string{serverNameField: "error_unreachable", fallbackServersField: "error_unreachable.privatelink,10.0.0.4", shareNameField: "share"},
+			expectedSource: "//10.0.0.4/share",
+		},
+		{
+			desc:          "fallback server is not tried on other errors",
+			volumeContext: map[string]string{shareNameField: "error_mount_sens", fallbackServersField: "10.0.0.4"},
+			expectedErr:   status.Error(codes.Internal, "volume(rg#k8s#share#) mount //k8s.file.test_suffix/error_mount_sens on %s failed with fake MountSensitive: source error"),
+		},
+		{
+			desc:          "all servers are unreachable",
+			volumeContext: map[string]string{serverNameField: "error_unreachable", fallbackServersField: "error_unreachable.privatelink", shareNameField: "share"},
+			expectedErr:   status.Error(codes.Internal, "volume(rg#k8s#share#) mount //error_unreachable.privatelink/share on %s failed with fake MountSensitive: mount error(113): No route to host"),
+		},
+		{
+			desc:          "fallback server is not tried after mount timeout",
+			volumeContext: map[string]string{serverNameField: "error_unreachable", fallbackServersField: "10.0.0.4", shareNameField: "share"},
+			mountTimeout:  time.Nanosecond,
+			expectedErr:   status.Error(codes.Internal, "volume(rg#k8s#share#) mount //error_unreachable/share on %s failed with fake MountSensitive: mount error(113): No route to host, fallback servers([10.0.0.4]) are not tried since mount timeout(1ns) is exceeded"),
+		},
+		{
+			desc:          "invalid fallback server",
+			volumeContext: map[string]string{shareNameField: "share", fallbackServersField: "10.0.0.4/share"},
+			expectedErr:   status.Error(codes.InvalidArgument, "invalid fallbackservers: 10.0.0.4/share, only IPv4 address or DNS name is supported in volume context"),
+		},
+	}
+
+	defaultStageMountTimeout := stageMountTimeout
+	defer func() { stageMountTimeout = defaultStageMountTimeout }()
+	for _, test := range tests {
+		stageMountTimeout = defaultStageMountTimeout
+		if test.mountTimeout > 0 {
+			stageMountTimeout = test.mountTimeout
+		}
+		sourceTest := testutil.GetWorkDirPath("source_test", t)
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{
+			Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+		}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "rg#k8s#share#", StagingTargetPath: sourceTest,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeContext: test.volumeContext,
+			Secrets:       secrets}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		expectedErr := test.expectedErr
+		if expectedErr != nil && status.Code(expectedErr) == codes.Internal {
+			expectedErr = status.Errorf(codes.Internal, status.Convert(expectedErr).Message(), sourceTest)
+		}
+		assert.Equal(t, expectedErr, err, test.desc)
+
+		if test.expectedSource != "" {
+			mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+			assert.Len(t, mountPoints, 1, test.desc)
+			assert.Equal(t, test.expectedSource, mountPoints[0].Device, test.desc)
+			v, ok := d.stagedVolumes.Load(sourceTest)
+			assert.True(t, ok, test.desc)
+			assert.Equal(t, test.expectedSource, v.(stagedVolume).Source, test.desc)
+		}
+		os.RemoveAll(sourceTest)
+	}
+}
+
+func TestNodeStageVolumeOwnerMountOptions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	secrets := map[string]string{
+		"accountname": "k8s",
+		"accountkey":  "testkey",
+	}
+
+	tests := []struct {
+		desc             string
+		volumeContext    map[string]string
+		mountFlags       []string
+		volumeMountGroup string
+		expectedErr      error
+		expectedOptions  []string
+		unexpectedOption string
+	}{
+		{
+			desc:            "uid and gid are in SMB mount options",
+			volumeContext:   map[string]string{shareNameField: "share"},
+			mountFlags:      []string{"uid=1000,forceuid", "gid=2000,forcegid"},
+			expectedOptions: []string{"uid=1000,forceuid", "gid=2000,forcegid"},
+		},
+		{
+			desc:             "gid in mount options overrides fsGroup",
+			volumeContext:    map[string]string{shareNameField: "share"},
+			mountFlags:       []string{"dir_mode=0770,gid=2000"},
+			volumeMountGroup: "3000",
+			expectedOptions:  []string{"dir_mode=0770,gid=2000"},
+			unexpectedOption: "gid=3000",
+		},
+		{
+			desc:             "gid is set by fsGroup",
+			volumeContext:    map[string]string{shareNameField: "share"},
+			mountFlags:       []string{"uid=1000"},
+			volumeMountGroup: "3000",
+			expectedOptions:  []string{"uid=1000", "gid=3000"},
+		},
+		{
+			desc:          "invalid uid in SMB mount options",
+			volumeContext: map[string]string{shareNameField: "share"},
+			mountFlags:    []string{"uid=-1"},
+			expectedErr:   status.Error(codes.InvalidArgument, "invalid uid(-1) in mount options, it should be a numeric id or a user or group name"),
+		},
+		{
+			desc:          "uid is not supported by NFS",
+			volumeContext: map[string]string{shareNameField: "share", protocolField: nfs},
+			mountFlags:    []string{"uid=1000"},
+			expectedErr:   status.Error(codes.InvalidArgument, "mount option(uid=1000) is not supported by NFS since file ownership is stored on the server, set fsGroup in pod security context or change ownership of files in the volume instead"),
+		},
+		{
+			desc:          "gid is not supported by disk fsType",
+			volumeContext: map[string]string{shareNameField: "share", fsTypeField: ext4, diskNameField: "disk.vhd"},
+			mountFlags:    []string{"gid=2000"},
+			expectedErr:   status.Error(codes.InvalidArgument, "mount option(gid=2000) is not supported with fsType(ext4)"),
+		},
+	}
+
+	for _, test := range tests {
+		sourceTest := testutil.GetWorkDirPath("source_test", t)
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{
+			Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+		}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "rg#k8s#share#", StagingTargetPath: sourceTest,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags, VolumeMountGroup: test.volumeMountGroup},
+				},
+			},
+			VolumeContext: test.volumeContext,
+			Secrets:       secrets}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+
+		if test.expectedErr == nil {
+			mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+			assert.Len(t, mountPoints, 1, test.desc)
+			for _, option := range test.expectedOptions {
+				assert.Contains(t, mountPoints[0].Opts, option, test.desc)
+			}
+			if test.unexpectedOption != "" {
+				assert.NotContains(t, mountPoints[0].Opts, test.unexpectedOption, test.desc)
+			}
+		}
+		os.RemoveAll(sourceTest)
+	}
+}
+
+func TestNodeStageVolumeSMBEncryption(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	secrets := map[string]string{
+		"accountname": "k8s",
+		"accountkey":  "testkey",
+	}
+
+	tests := []struct {
+		desc                 string
+		volumeContext        map[string]string
+		mountFlags           []string
+		requireSMBEncryption bool
+		expectedErr          error
+		expectedSeal         bool
+	}{
+		{
+			desc:          "seal is not added by default",
+			volumeContext: map[string]string{shareNameField: "share"},
+		},
+		{
+			desc:          "seal is added with encryptInTransit",
+			volumeContext: map[string]string{shareNameField: "share", encryptInTransitField: "true"},
+			expectedSeal:  true,
+		},
+		{
+			desc:                 "encryptInTransit could not override driver-wide enforcement",
+			volumeContext:        map[string]string{shareNameField: "share", encryptInTransitField: "false"},
+			requireSMBEncryption: true,
+			expectedSeal:         true,
+		},
+		{
+			desc:          "seal in mount options is not duplicated",
+			volumeContext: map[string]string{shareNameField: "share", encryptInTransitField: "true"},
+			mountFlags:    []string{"seal"},
+			expectedSeal:  true,
+		},
+		{
+			desc:          "invalid encryptInTransit",
+			volumeContext: map[string]string{shareNameField: "share", encryptInTransitField: "yes"},
+			expectedErr:   status.Error(codes.InvalidArgument, "invalid encryptintransit: yes in volume context"),
+		},
+		{
+			desc:          "SMB version does not support encryption",
+			volumeContext: map[string]string{shareNameField: "share", encryptInTransitField: "true"},
+			mountFlags:    []string{"vers=2.1"},
+			expectedErr:   status.Error(codes.InvalidArgument, "SMB version(2.1) in mount options does not support encryption, SMB 3.0 or later is required"),
+		},
+		{
+			desc:          "encryptInTransit with NFS protocol",
+			volumeContext: map[string]string{shareNameField: "share", encryptInTransitField: "true", protocolField: nfs},
+			expectedErr:   status.Error(codes.InvalidArgument, "encryptintransit is only supported with SMB protocol"),
+		},
+	}
+
+	for _, test := range tests {
+		sourceTest := testutil.GetWorkDirPath("source_test", t)
+		d := NewFakeDriver()
+		d.requireSMBEncryption = test.requireSMBEncryption
+		d.cloud = &azure.Cloud{
+			Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+		}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags},
+				},
+			},
+			VolumeContext: test.volumeContext,
+			Secrets:       secrets}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+
+		if test.expectedErr == nil {
+			mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+			assert.Len(t, mountPoints, 1, test.desc)
+			sealCount := 0
+			for _, option := range mountPoints[0].Opts {
+				if option == seal {
+					sealCount++
+				}
+			}
+			assert.Equal(t, test.expectedSeal, isSMBSealEnabled(mountPoints[0].Opts), test.desc)
+			assert.LessOrEqual(t, sealCount, 1, test.desc)
+		}
+		os.RemoveAll(sourceTest)
+	}
+}
+
+func TestNodeStageVolumeSMBCompression(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	secrets := map[string]string{
+		"accountname": "k8s",
+		"accountkey":  "testkey",
+	}
+
+	tests := []struct {
+		desc             string
+		volumeContext    map[string]string
+		mountFlags       []string
+		expectedErr      error
+		expectedCompress bool
+	}{
+		{
+			desc:          "compress is not added by default",
+			volumeContext: map[string]string{shareNameField: "share"},
+		},
+		{
+			desc:             "compress is added with enableCompression",
+			volumeContext:    map[string]string{shareNameField: "share", "enableCompression": "true"},
+			expectedCompress: true,
+		},
+		{
+			desc:             "compress in mount options is not duplicated",
+			volumeContext:    map[string]string{shareNameField: "share", enableCompressionField: "true"},
+			mountFlags:       []string{"compress"},
+			expectedCompress: true,
+		},
+		{
+			desc:          "invalid enableCompression",
+			volumeContext: map[string]string{shareNameField: "share", enableCompressionField: "yes"},
+			expectedErr:   status.Error(codes.InvalidArgument, "invalid enablecompression: yes in volume context"),
+		},
+		{
+			desc:          "enableCompression with NFS protocol",
+			volumeContext: map[string]string{shareNameField: "share", enableCompressionField: "true", protocolField: nfs},
+			expectedErr:   status.Error(codes.InvalidArgument, "enablecompression is only supported with SMB protocol"),
+		},
+	}
+
+	for _, test := range tests {
+		sourceTest := testutil.GetWorkDirPath("source_test", t)
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{
+			Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+		}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags},
+				},
+			},
+			VolumeContext: test.volumeContext,
+			Secrets:       secrets}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+
+		if test.expectedErr == nil {
+			mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+			assert.Len(t, mountPoints, 1, test.desc)
+			compressCount := 0
+			for _, option := range mountPoints[0].Opts {
+				if option == compress {
+					compressCount++
+				}
+			}
+			assert.Equal(t, test.expectedCompress, hasMountOption(mountPoints[0].Opts, compress), test.desc)
+			assert.LessOrEqual(t, compressCount, 1, test.desc)
+		}
+		os.RemoveAll(sourceTest)
+	}
+}
+
+func TestNodeStageVolumeSMBNoLease(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	secrets := map[string]string{
+		"accountname": "k8s",
+		"accountkey":  "testkey",
+	}
+
+	tests := []struct {
+		desc            string
+		volumeContext   map[string]string
+		mountFlags      []string
+		expectedErr     error
+		expectedNoLease bool
+	}{
+		{
+			desc:          "nolease is not added by default",
+			volumeContext: map[string]string{shareNameField: "share"},
+		},
+		{
+			desc:            "nolease is added with disableSMBLeases",
+			volumeContext:   map[string]string{shareNameField: "share", "disableSMBLeases": "true"},
+			expectedNoLease: true,
+		},
+		{
+			desc:            "nolease in mount options is not duplicated",
+			volumeContext:   map[string]string{shareNameField: "share", disableSMBLeasesField: "true"},
+			mountFlags:      []string{"nolease"},
+			expectedNoLease: true,
+		},
+		{
+			desc:          "invalid disableSMBLeases",
+			volumeContext: map[string]string{shareNameField: "share", disableSMBLeasesField: "yes"},
+			expectedErr:   status.Error(codes.InvalidArgument, "invalid disablesmbleases: yes in volume context"),
+		},
+		{
+			desc:          "disableSMBLeases with NFS protocol",
+			volumeContext: map[string]string{shareNameField: "share", disableSMBLeasesField: "true", protocolField: nfs},
+			expectedErr:   status.Error(codes.InvalidArgument, "disablesmbleases is only supported with SMB protocol"),
+		},
+	}
+
+	for _, test := range tests {
+		sourceTest := testutil.GetWorkDirPath("source_test", t)
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{
+			Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+		}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags},
+				},
+			},
+			VolumeContext: test.volumeContext,
+			Secrets:       secrets}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+
+		if test.expectedErr == nil {
+			mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+			assert.Len(t, mountPoints, 1, test.desc)
+			noLeaseCount := 0
+			for _, option := range mountPoints[0].Opts {
+				if option == nolease {
+					noLeaseCount++
+				}
+			}
+			assert.Equal(t, test.expectedNoLease, hasMountOption(mountPoints[0].Opts, nolease), test.desc)
+			assert.LessOrEqual(t, noLeaseCount, 1, test.desc)
+		}
+		os.RemoveAll(sourceTest)
+	}
+}
+
+func TestNodeStageVolumeSourceAddress(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	origGetInterfaceAddrs := getInterfaceAddrs
+	defer func() { getInterfaceAddrs = origGetInterfaceAddrs }()
+	getInterfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{&net.IPNet{IP: net.ParseIP("10.1.0.4"), Mask: net.CIDRMask(24, 32)}}, nil
+	}
+	secrets := map[string]string{
+		"accountname": "k8s",
+		"accountkey":  "testkey",
+	}
+
+	tests := []struct {
+		desc            string
+		volumeContext   map[string]string
+		mountFlags      []string
+		expectedErr     error
+		expectedSrcAddr string
+	}{
+		{
+			desc:          "srcaddr is not added by default",
+			volumeContext: map[string]string{shareNameField: "share"},
+		},
+		{
+			desc:            "srcaddr is added with sourceAddress",
+			volumeContext:   map[string]string{shareNameField: "share", "sourceAddress": "10.1.0.4"},
+			expectedSrcAddr: "10.1.0.4",
+		},
+		{
+			desc:            "same srcaddr in mount options",
+			volumeContext:   map[string]string{shareNameField: "share", sourceAddressField: "10.1.0.4"},
+			mountFlags:      []string{"srcaddr=10.1.0.4"},
+			expectedSrcAddr: "10.1.0.4",
+		},
+		{
+			desc:          "different srcaddr in mount options",
+			volumeContext: map[string]string{shareNameField: "share", sourceAddressField: "10.1.0.4"},
+			mountFlags:    []string{"srcaddr=10.1.0.5"},
+			expectedErr:   status.Error(codes.InvalidArgument, "sourceaddress(10.1.0.4) conflicts with srcaddr=10.1.0.5 in mount options"),
+		},
+		{
+			desc:          "source address is not on the node",
+			volumeContext: map[string]string{shareNameField: "share", sourceAddressField: "10.2.0.4"},
+			expectedErr:   status.Error(codes.FailedPrecondition, "volume(vol_1##) requires sourceaddress(10.2.0.4): source address(10.2.0.4) is not assigned to any network interface on the node"),
+		},
+		{
+			desc:          "sourceAddress with NFS protocol",
+			volumeContext: map[string]string{shareNameField: "share", sourceAddressField: "10.1.0.4", protocolField: nfs},
+			expectedErr:   status.Error(codes.InvalidArgument, "sourceaddress is only supported with SMB protocol"),
+		},
+	}
+
+	for _, test := range tests {
+		sourceTest := testutil.GetWorkDirPath("source_test", t)
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{
+			Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+		}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags},
+				},
+			},
+			VolumeContext: test.volumeContext,
+			Secrets:       secrets}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+
+		if test.expectedErr == nil {
+			mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+			assert.Len(t, mountPoints, 1, test.desc)
+			srcAddrCount := 0
+			for _, option := range mountPoints[0].Opts {
+				if strings.HasPrefix(option, srcaddr+"=") {
+					srcAddrCount++
+				}
+			}
+			assert.Equal(t, test.expectedSrcAddr, getSourceAddressMountOption(mountPoints[0].Opts), test.desc)
+			assert.LessOrEqual(t, srcAddrCount, 1, test.desc)
+		}
+		os.RemoveAll(sourceTest)
+	}
+}
+
+func TestNodeStageVolumeMountTenant(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	secrets := map[string]string{
+		"accountname": "k8s",
+		"accountkey":  "testkey",
+	}
+	stage := func(d *Driver, volumeID, stagingPath string, volumeContext map[string]string, mountFlags []string) error {
+		_, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{VolumeId: volumeID, StagingTargetPath: stagingPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: mountFlags},
+				},
+			},
+			VolumeContext: volumeContext,
+			Secrets:       secrets})
+		return err
+	}
+	newDriver := func() (*Driver, *fakeMounter) {
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{
+			Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+		}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+		return d, mounter.Interface.(*fakeMounter)
+	}
+	countOption := func(opts []string, name string) int {
+		count := 0
+		for _, option := range opts {
+			if option == name {
+				count++
+			}
+		}
+		return count
+	}
+
+	// two tenants mounting the same account get their own SMB sessions
+	tenantA := testutil.GetWorkDirPath("tenant_a_test", t)
+	tenantB := testutil.GetWorkDirPath("tenant_b_test", t)
+	defer os.RemoveAll(tenantA)
+	defer os.RemoveAll(tenantB)
+	d, mounter := newDriver()
+	assert.NoError(t, stage(d, "rg#k8s#share-a#", tenantA, map[string]string{shareNameField: "share-a", "mountTenant": "team-a"}, nil))
+	assert.NoError(t, stage(d, "rg#k8s#share-b#", tenantB, map[string]string{shareNameField: "share-b", mountTenantField: "team-b"}, []string{nosharesock}))
+	assert.Len(t, mounter.MountPoints, 2)
+	for _, mountPoint := range mounter.MountPoints {
+		assert.Equal(t, 1, countOption(mountPoint.Opts, nosharesock), mountPoint.Path)
+	}
+
+	// mount without tenant shares the SMB session
+	shared := testutil.GetWorkDirPath("shared_test", t)
+	defer os.RemoveAll(shared)
+	d, mounter = newDriver()
+	assert.NoError(t, stage(d, "rg#k8s#share-a#", shared, map[string]string{shareNameField: "share-a"}, nil))
+	assert.Len(t, mounter.MountPoints, 1)
+	assert.Equal(t, 0, countOption(mounter.MountPoints[0].Opts, nosharesock))
+
+	err := stage(d, "rg#k8s#share-a#", shared, map[string]string{shareNameField: "share-a", mountTenantField: "team-a", protocolField: nfs}, nil)
+	assert.Equal(t, status.Error(codes.InvalidArgument, "mounttenant is only supported with SMB protocol"), err)
+}
+
+func TestNodeStageVolumeAutoSelectSMBVersion(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	tests := []struct {
+		desc            string
+		volumeContext   map[string]string
+		mountFlags      []string
+		expectedVersion string
+	}{
+		{
+			desc:            "SMB 3.1.1 is selected without vers mount option",
+			volumeContext:   map[string]string{shareNameField: "share", "skuName": "Premium_LRS"},
+			expectedVersion: "3.1.1",
+		},
+		{
+			desc:            "vers in mount options is kept",
+			volumeContext:   map[string]string{shareNameField: "share"},
+			mountFlags:      []string{"vers=3.0"},
+			expectedVersion: "3.0",
+		},
+	}
+
+	for _, test := range tests {
+		sourceTest := testutil.GetWorkDirPath("source_test", t)
+		d := NewFakeDriver()
+		d.autoSelectSMBVersion = true
+		d.cloud = &azure.Cloud{
+			Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+		}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags},
+				},
+			},
+			VolumeContext: test.volumeContext,
+			Secrets:       map[string]string{"accountname": "k8s", "accountkey": "testkey"}}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		assert.NoError(t, err, test.desc)
+		mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+		assert.Len(t, mountPoints, 1, test.desc)
+		assert.Equal(t, test.expectedVersion, getSMBVersion(mountPoints[0].Opts), test.desc)
+		os.RemoveAll(sourceTest)
+	}
+}
+
+func TestNodeStageVolumeConnectionString(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	sourceTest := testutil.GetWorkDirPath("source_test", t)
+	defer os.RemoveAll(sourceTest)
+
+	volCap := csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+	tests := []struct {
+		desc           string
+		connStr        string
+		expectedSource string
+		expectedErr    error
+	}{
+		{
+			desc:           "endpoint suffix in connection string",
+			connStr:        "DefaultEndpointsProtocol=https;AccountName=k8s;AccountKey=dGVzdGtleQ==;EndpointSuffix=core.chinacloudapi.cn",
+			expectedSource: "//k8s.file.core.chinacloudapi.cn/test_sharename",
+		},
+		{
+			desc:           "file endpoint in connection string",
+			connStr:        "AccountName=k8s;AccountKey=dGVzdGtleQ==;FileEndpoint=https://k8s.privatelink.file.core.windows.net",
+			expectedSource: "//k8s.privatelink.file.core.windows.net/test_sharename",
+		},
+		{
+			desc:        "malformed connection string",
+			connStr:     "AccountName=k8s;testkey",
+			expectedErr: status.Error(codes.InvalidArgument, "GetAccountInfo(vol_1##) failed with error: invalid azurestorageconnectionstring field in secrets: field 1 is not in key=value format"),
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+			VolumeCapability: &volCap,
+			VolumeContext:    map[string]string{shareNameField: "test_sharename"},
+			Secrets:          map[string]string{secretConnectionString: test.connStr}}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		if test.expectedErr == nil {
+			mountPoints := mounter.Interface.(*fakeMounter).MountPoints
+			assert.Len(t, mountPoints, 1, test.desc)
+			assert.Equal(t, test.expectedSource, mountPoints[0].Device, test.desc)
+		}
+	}
+}
+
+func TestNodeStageVolumeNoCredentialFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	accountKey := "dGVzdGtleS1ub3Qtb24tZGlzaw=="
+	volCap := csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+
+	for _, stagingDir := range []string{"staging", "error_mount_sens"} {
+		workDir, err := os.MkdirTemp("", "azurefile-stage")
+		if err != nil {
+			t.Fatalf("failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(workDir)
+
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{}
+		mounter, err := NewFakeMounter()
+		if err != nil {
+			t.Fatalf("failed to get fake mounter: %v", err)
+		}
+		d.mounter = mounter
+
+		req := csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: filepath.Join(workDir, stagingDir),
+			VolumeCapability: &volCap,
+			VolumeContext:    map[string]string{shareNameField: "test_sharename"},
+			Secrets:          map[string]string{"accountname": "k8s", "accountkey": accountKey}}
+		_, err = d.NodeStageVolume(context.Background(), &req)
+		assert.Equal(t, stagingDir == "error_mount_sens", err != nil, "staging dir: %s, error: %v", stagingDir, err)
+
+		// mount command is recorded for both successful and failed mounts, with account key redacted
+		v, ok := d.mountCommands.Load("vol_1##")
+		assert.True(t, ok, "staging dir: %s", stagingDir)
+		cmd := v.(mountCommand)
+		assert.NotContains(t, cmd.Command, accountKey)
+		assert.Contains(t, cmd.Command, maskedMountOption)
+		assert.Equal(t, stagingDir == "error_mount_sens", cmd.Error != "", "staging dir: %s", stagingDir)
+
+		// account key is passed to mount as sensitive mount option, it must not be written to any file
+		err = filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			assert.NotContains(t, string(content), accountKey, "credential is written to %s", path)
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+}
+
+func TestNodeUnstageVolume(t *testing.T) {
+	var (
+		errorTarget = testutil.GetWorkDirPath("error_is_likely_target", t)
+		targetFile  = testutil.GetWorkDirPath("abc.go", t)
+	)
+	d := NewFakeDriver()
+
+	tests := []struct {
+		desc         string
+		setup        func()
+		req          csi.NodeUnstageVolumeRequest
+		skipOnDarwin bool
+		expectedErr  testutil.TestError
+		cleanup      func()
+	}{
+		{
+			desc: "[Error] Volume ID missing",
+			req:  csi.NodeUnstageVolumeRequest{StagingTargetPath: targetTest},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "Volume ID missing in request"),
+			},
+		},
+		{
+			desc: "[Error] Target missing",
+			req:  csi.NodeUnstageVolumeRequest{VolumeId: "vol_1"},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "Staging target not provided"),
+			},
+		},
+		{
+			desc: "[Error] Volume operation in progress",
+			setup: func() {
+				d.volumeLocks.TryAcquire("vol_1")
+			},
+			req: csi.NodeUnstageVolumeRequest{StagingTargetPath: targetFile, VolumeId: "vol_1"},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.Aborted, fmt.Sprintf(volumeOperationAlreadyExistsFmt, "vol_1")),
+			},
+			cleanup: func() {
+				d.volumeLocks.Release("vol_1")
+			},
+		},
+		{
+			desc:         "[Error] CleanupMountPoint error mocked by IsLikelyNotMountPoint",
+			req:          csi.NodeUnstageVolumeRequest{StagingTargetPath: errorTarget, VolumeId: "vol_1"},
+			skipOnDarwin: true,
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.Internal, fmt.Sprintf("failed to unmount staging target %v: fake IsLikelyNotMountPoint: fake error", errorTarget)),
+			},
+		},
+		{
+			desc:        "[Success] Valid request",
+			req:         csi.NodeUnstageVolumeRequest{StagingTargetPath: targetFile, VolumeId: "vol_1"},
+			expectedErr: testutil.TestError{},
+		},
+	}
+
+	// Setup
+	_ = makeDir(errorTarget, 0755)
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf(fmt.Sprintf("failed to get fake mounter: %v", err))
+	}
+	if runtime.GOOS != "windows" {
+		mounter.Exec = &testingexec.FakeExec{ExactOrder: true}
+	}
+	d.mounter = mounter
+
+	for _, test := range tests {
+		if test.setup != nil {
+			test.setup()
+		}
+		if test.skipOnDarwin && runtime.GOOS == "darwin" {
+			continue
+		}
+		_, err := d.NodeUnstageVolume(context.Background(), &test.req)
+		if !testutil.AssertError(err, &test.expectedErr) {
+			t.Errorf("Desc: %v\nUnexcpected error: %v\nExpected: %v", test.desc, err, test.expectedErr.GetExpectedError())
+		}
+		if test.cleanup != nil {
+			test.cleanup()
+		}
+	}
+
+	// Clean up
+	err = os.RemoveAll(errorTarget)
+	assert.NoError(t, err)
+}
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	nonexistedPath := "/not/a/real/directory"
+	fakePath := "/tmp/fake-volume-path"
+
+	tests := []struct {
+		desc        string
+		req         csi.NodeGetVolumeStatsRequest
+		expectedErr error
 	}{
 		{
 			desc:        "[Error] Volume ID missing",
@@ -968,6 +2140,73 @@ func TestMakeDir(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGetTargetPathAttributes(t *testing.T) {
+	tests := []struct {
+		desc        string
+		context     map[string]string
+		expectedErr error
+		perm        *os.FileMode
+		uid         int
+		gid         int
+	}{
+		{
+			desc: "not set",
+			uid:  -1,
+			gid:  -1,
+		},
+		{
+			desc:    "permissions and owner",
+			context: map[string]string{"targetPathPermissions": "0755", "targetPathOwner": "0:1000"},
+			perm:    func() *os.FileMode { mode := os.FileMode(0755); return &mode }(),
+			uid:     0,
+			gid:     1000,
+		},
+		{
+			desc:    "owner without gid",
+			context: map[string]string{targetPathOwnerField: "1000", targetPathPermissionsField: ""},
+			uid:     1000,
+			gid:     -1,
+		},
+		{
+			desc:        "invalid permissions",
+			context:     map[string]string{targetPathPermissionsField: "0abc"},
+			expectedErr: fmt.Errorf("invalid targetpathpermissions: 0abc"),
+			uid:         -1,
+			gid:         -1,
+		},
+		{
+			desc:        "invalid gid",
+			context:     map[string]string{targetPathOwnerField: "0:-1"},
+			expectedErr: fmt.Errorf("invalid targetpathowner: 0:-1"),
+			uid:         -1,
+			gid:         -1,
+		},
+	}
+
+	for _, test := range tests {
+		perm, uid, gid, err := getTargetPathAttributes(test.context)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.perm, perm, test.desc)
+		assert.Equal(t, []int{test.uid, test.gid}, []int{uid, gid}, test.desc)
+	}
+}
+
+func TestSetMountPointAttributes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip mount point attributes on Windows")
+	}
+	target := t.TempDir()
+	perm := os.FileMode(0750)
+	assert.NoError(t, setMountPointAttributes(target, &perm, os.Getuid(), os.Getgid()))
+	info, err := os.Stat(target)
+	assert.NoError(t, err)
+	assert.Equal(t, perm, info.Mode().Perm())
+
+	// owner only
+	assert.NoError(t, setMountPointAttributes(target, nil, -1, os.Getgid()))
+	assert.Error(t, setMountPointAttributes(filepath.Join(target, "missing"), &perm, -1, -1))
+}
+
 func TestNodeExpandVolume(t *testing.T) {
 	d := NewFakeDriver()
 	req := csi.NodeExpandVolumeRequest{}
@@ -994,6 +2233,16 @@ func TestCheckGidPresentInMountFlags(t *testing.T) {
 			MountFlags: []string{},
 			result:     false,
 		},
+		{
+			desc:       "[Success] Gid present in combined mount flag",
+			MountFlags: []string{"dir_mode=0777,gid=3000"},
+			result:     true,
+		},
+		{
+			desc:       "[Success] forcegid is not gid",
+			MountFlags: []string{"forcegid"},
+			result:     false,
+		},
 	}
 
 	for _, test := range tests {
@@ -1051,6 +2300,210 @@ func TestNodePublishVolumeIdempotentMount(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestNodePublishVolumeWithoutStage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on Windows")
+	}
+	d := NewFakeDriver()
+	d.disableStageUnstage = true
+	var err error
+	d.mounter, err = NewFakeMounter()
+	assert.NoError(t, err)
+	target := filepath.Join(t.TempDir(), "mount")
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "rg#account#share",
+		TargetPath: target,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nconnect=4"}}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+		},
+		VolumeContext: map[string]string{protocolField: nfs},
+		Readonly:      true,
+	}
+	// staging target path is not required
+	_, err = d.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+	// re-publish does not mount the volume again
+	_, err = d.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+
+	mountList, err := d.mounter.List()
+	assert.NoError(t, err)
+	if assert.Len(t, mountList, 1) {
+		assert.Equal(t, "account.file.core.windows.net:/account/share", mountList[0].Device)
+		assert.Equal(t, target, mountList[0].Path)
+		assert.Equal(t, nfs, mountList[0].Type)
+		assert.Contains(t, mountList[0].Opts, "ro")
+		assert.Contains(t, mountList[0].Opts, "nconnect=4")
+	}
+	_, staged := d.stagedVolumes.Load(target)
+	assert.True(t, staged)
+
+	// mount propagation needs a bind mount
+	propagationReq := *req
+	propagationReq.VolumeCapability = &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"rslave"}}},
+	}
+	_, err = d.NodePublishVolume(context.Background(), &propagationReq)
+	assert.Equal(t, status.Errorf(codes.InvalidArgument, "mount propagation(rslave) of volume(rg#account#share) is not supported when STAGE_UNSTAGE_VOLUME is disabled"), err)
+
+	_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: req.VolumeId, TargetPath: target})
+	assert.NoError(t, err)
+	_, staged = d.stagedVolumes.Load(target)
+	assert.False(t, staged)
+	// unpublish is idempotent
+	_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: req.VolumeId, TargetPath: target})
+	assert.NoError(t, err)
+}
+
+// newUnstagePolicyTestDriver returns a fake driver with the volume staged over NFS on the returned staging path
+func newUnstagePolicyTestDriver(t *testing.T, policy string) (*Driver, *csi.VolumeCapability, string) {
+	d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, UnstagePolicy: policy})
+	var err error
+	d.mounter, err = NewFakeMounter()
+	assert.NoError(t, err)
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	stagingPath := filepath.Join(t.TempDir(), "globalmount")
+	_, err = d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "rg#account#share",
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  volCap,
+		VolumeContext:     map[string]string{protocolField: nfs},
+	})
+	assert.NoError(t, err)
+	return d, volCap, stagingPath
+}
+
+// publishPods publishes the volume to targets of pods concurrently
+func publishPods(t *testing.T, d *Driver, volCap *csi.VolumeCapability, stagingPath string, targets []string) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:          "rg#account#share",
+				StagingTargetPath: stagingPath,
+				TargetPath:        target,
+				VolumeCapability:  volCap,
+			})
+			assert.NoError(t, err)
+		}(target)
+	}
+	wg.Wait()
+}
+
+// unpublishPods unpublishes the volume from targets of pods concurrently, aborted requests are retried like kubelet does
+func unpublishPods(t *testing.T, d *Driver, targets []string) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			for {
+				_, err := d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "rg#account#share", TargetPath: target})
+				if status.Code(err) != codes.Aborted {
+					assert.NoError(t, err)
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+func TestNodeUnstageVolumeWithReferences(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on Windows")
+	}
+	tests := []struct {
+		policy                     string
+		expectedUnmounted          bool
+		expectedUnmountedAfterLast bool
+	}{
+		{
+			policy:                     unstagePolicyUnmount,
+			expectedUnmounted:          true,
+			expectedUnmountedAfterLast: true,
+		},
+		{
+			policy:                     unstagePolicyFail,
+			expectedUnmounted:          false,
+			expectedUnmountedAfterLast: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			d, volCap, stagingPath := newUnstagePolicyTestDriver(t, test.policy)
+			podsDir := t.TempDir()
+			var targets []string
+			for i := 0; i < 5; i++ {
+				targets = append(targets, filepath.Join(podsDir, fmt.Sprintf("pod-%d", i), "mount"))
+			}
+			publishPods(t, d, volCap, stagingPath, targets)
+			unpublishPods(t, d, targets[:4])
+
+			unstageReq := &csi.NodeUnstageVolumeRequest{VolumeId: "rg#account#share", StagingTargetPath: stagingPath}
+			_, err := d.NodeUnstageVolume(context.Background(), unstageReq)
+			if test.policy == unstagePolicyFail {
+				assert.Equal(t, status.Errorf(codes.FailedPrecondition, "volume(rg#account#share) staged on %s is still published on [%s]", stagingPath, targets[4]), err)
+			} else {
+				assert.NoError(t, err)
+			}
+			_, err = os.Stat(stagingPath)
+			assert.Equal(t, test.expectedUnmounted, os.IsNotExist(err), "staging path is unmounted")
+
+			unpublishPods(t, d, targets[4:])
+			_, err = os.Stat(stagingPath)
+			assert.Equal(t, test.expectedUnmountedAfterLast, os.IsNotExist(err), "staging path is unmounted after the last unpublish")
+
+			// kubelet retries the failed unstage
+			_, err = d.NodeUnstageVolume(context.Background(), unstageReq)
+			assert.NoError(t, err)
+			_, err = os.Stat(stagingPath)
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestNodeUnstageVolumeReferencesAfterRestart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on Windows")
+	}
+	stagingPath := filepath.Join(t.TempDir(), "globalmount")
+	assert.NoError(t, os.MkdirAll(stagingPath, 0750))
+	targets := []string{"/pods/pod-0/mount", "/pods/pod-1/mount"}
+	fakeMounter := &fakeMounter{}
+	fakeMounter.MountPoints = []mount.MountPoint{
+		{Device: "account.file.core.windows.net:/account/share", Path: stagingPath, Type: nfs},
+		{Device: stagingPath, Path: targets[0]},
+		{Device: "account.file.core.windows.net:/account/share", Path: targets[1], Type: nfs},
+		{Device: "account.file.core.windows.net:/account/other", Path: "/pods/pod-2/mount", Type: nfs},
+	}
+
+	// the restarted driver does not know the volume, references are derived from bind mounts of the staging path
+	d := NewFakeDriverCustomOptions(DriverOptions{NodeID: fakeNodeID, DriverName: DefaultDriverName, UnstagePolicy: unstagePolicyFail})
+	d.mounter = &mount.SafeFormatAndMount{Interface: fakeMounter}
+	unstageReq := &csi.NodeUnstageVolumeRequest{VolumeId: "rg#account#share", StagingTargetPath: stagingPath}
+	_, err := d.NodeUnstageVolume(context.Background(), unstageReq)
+	assert.Equal(t, status.Errorf(codes.FailedPrecondition, "volume(rg#account#share) staged on %s is still published on %v", stagingPath, targets), err)
+	targetsOfVolume, err := d.stageRefs.references("rg#account#share", stagingPath)
+	assert.NoError(t, err)
+	assert.Equal(t, targets, targetsOfVolume)
+
+	unpublishPods(t, d, targets)
+	_, err = d.NodeUnstageVolume(context.Background(), unstageReq)
+	assert.NoError(t, err)
+	_, err = os.Stat(stagingPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
 func makeFakeCmd(fakeCmd *testingexec.FakeCmd, cmd string, args ...string) testingexec.FakeCommandAction {
 	c := cmd
 	a := args
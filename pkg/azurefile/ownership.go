@@ -0,0 +1,205 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+const (
+	// value of createdByMetadataKey on file shares and createdByTag on storage accounts created by the driver
+	createdByDriver = "azurefile-csi"
+	// tag on storage accounts created by CreateVolume
+	createdByTag = "created-by"
+	// tag on storage accounts created by CreateVolume, value is the share name namespace of the driver
+	shareNameNamespaceTag = "csi-share-namespace"
+)
+
+var (
+	// file share named after the volume by CreateVolume, e.g. pvc-<uuid>, pvcn-<uuid> for NFS and pvcd-<uuid> for vhd
+	// disk, or <prefix>-<uuid> with --volume-name-prefix of external-provisioner, with optional shareNamePrefix and
+	// share name namespace
+	legacyShareNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	// volume name <prefix>-<uuid> generated by external-provisioner, pvc-<uuid> by default, it's set in the volume
+	// handle by CreateVolume
+	legacyVolumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.-]*-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// isShareOwned returns whether the file share is created or adopted by the driver in share name namespace, an
+// adopted file share only has the share name namespace in its metadata
+func isShareOwned(metadata map[string]*string, namespace string) bool {
+	if getMetadataValue(metadata, createdByMetadataKey) == createdByDriver {
+		return true
+	}
+	return namespace != "" && getMetadataValue(metadata, shareNameNamespaceMetadataKey) == namespace
+}
+
+// isLegacyDriverShare returns whether the file share of the volume is provisioned by CreateVolume of a driver version
+// which does not record ownership in file share metadata: the volume handle contains a volume name generated by
+// external-provisioner, which is only set by CreateVolume, or the file share is named after such a volume
+func isLegacyDriverShare(volumeID, fileShareName string) bool {
+	if h, err := parseVolumeHandle(volumeID); err == nil && legacyVolumeNameRegexp.MatchString(h.uuid) {
+		return true
+	}
+	return legacyShareNameRegexp.MatchString(fileShareName)
+}
+
+// isAccountOwned returns whether the storage account is created by CreateVolume of the driver in share name namespace
+func isAccountOwned(tags map[string]*string, namespace string) bool {
+	return getMetadataValue(tags, createdByTag) == createdByDriver && getMetadataValue(tags, shareNameNamespaceTag) == namespace
+}
+
+// getAccountOwnershipTags returns tags recording the storage account is created by the driver in share name namespace
+func getAccountOwnershipTags(namespace string) map[string]*string {
+	tags := map[string]*string{createdByTag: pointer.String(createdByDriver)}
+	if namespace != "" {
+		tags[shareNameNamespaceTag] = pointer.String(namespace)
+	}
+	return tags
+}
+
+// removeOwnershipTags returns tags of a storage account without the ownership tags, so that they are not matched
+// against tags of the storage class, tags in classTags are kept
+func removeOwnershipTags(tags map[string]*string, classTags map[string]string) map[string]*string {
+	result := make(map[string]*string, len(tags))
+	for k, v := range tags {
+		if _, ok := classTags[k]; !ok && (k == createdByTag || k == shareNameNamespaceTag) {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// accountDeletionLock is held for read by CreateVolume after selecting the storage account until the file share is created
+// in it, and for write by DeleteVolume deleting the storage account when it's empty
+type accountDeletionLock struct {
+	sync.RWMutex
+	// the storage account is deleted, it must not be selected any more
+	deleted bool
+}
+
+// getAccountDeletionLock returns the deletion lock of the storage account
+func (d *Driver) getAccountDeletionLock(accountName string) *accountDeletionLock {
+	v, _ := d.accountDeletionLocks.LoadOrStore(strings.ToLower(accountName), &accountDeletionLock{})
+	return v.(*accountDeletionLock)
+}
+
+// lockAccountSelection prevents the selected storage account from being deleted by DeleteVolume until the returned
+// function is called, CreateVolume holds it until the file share is created in the account. The returned function
+// could be called more than once. It returns false if the storage account is already deleted as an empty account, the
+// account must be selected again. It's a no-op if empty storage accounts are not deleted.
+func (d *Driver) lockAccountSelection(accountName string) (func(), bool) {
+	if !d.deleteEmptyAccounts {
+		return func() {}, true
+	}
+	lock := d.getAccountDeletionLock(accountName)
+	lock.RLock()
+	if lock.deleted {
+		lock.RUnlock()
+		return func() {}, false
+	}
+	locked := true
+	return func() {
+		if locked {
+			locked = false
+			lock.RUnlock()
+		}
+	}, true
+}
+
+// deleteAccountIfEmpty deletes the storage account if it's created by the driver in share name namespace and there
+// is no file share in it, soft-deleted file shares included except the file share just deleted by DeleteVolume,
+// returns whether the storage account is deleted. The private endpoint created with the storage account is deleted
+// first, together with its private DNS zone group and network interface.
+func (d *Driver) deleteAccountIfEmpty(ctx context.Context, subsID, resourceGroup, accountName, deletedShareName string) (bool, error) {
+	cloud := d.getCloudBySubscription(ctx, subsID, resourceGroup, accountName)
+	if cloud.StorageAccountClient == nil {
+		return false, fmt.Errorf("storage account client is nil")
+	}
+	// the storage account could not be selected by CreateVolume in the meantime
+	lock := d.getAccountDeletionLock(accountName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		if isNotFoundError(rerr.Error()) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	if !isAccountOwned(account.Tags, d.shareNameNamespace) {
+		klog.V(4).Infof("storage account(%s) under rg(%s) is not created by the driver in share name namespace(%s), keep it", accountName, resourceGroup, d.shareNameNamespace)
+		return false, nil
+	}
+	shares, err := cloud.FileClient.WithSubscriptionID(subsID).ListFileShare(ctx, resourceGroup, accountName, "", "deleted")
+	if err != nil {
+		return false, fmt.Errorf("failed to list file shares of storage account(%s) under rg(%s): %v", accountName, resourceGroup, err)
+	}
+	remaining := 0
+	for _, share := range shares {
+		if share.FileShareProperties != nil && pointer.BoolDeref(share.FileShareProperties.Deleted, false) &&
+			strings.EqualFold(pointer.StringDeref(share.Name, ""), deletedShareName) {
+			continue
+		}
+		remaining++
+	}
+	if remaining > 0 {
+		klog.V(4).Infof("storage account(%s) under rg(%s) still has %d file shares, keep it", accountName, resourceGroup, remaining)
+		return false, nil
+	}
+
+	for _, endpoint := range getDriverPrivateEndpoints(account) {
+		klog.V(2).Infof("deleting private endpoint(%s) under rg(%s) of empty storage account(%s)", endpoint.ResourceName, endpoint.ResourceGroup, accountName)
+		if err := d.deletePrivateEndpoint(ctx, cloud, endpoint.SubscriptionID, endpoint.ResourceGroup, endpoint.ResourceName); err != nil {
+			return false, fmt.Errorf("failed to delete private endpoint(%s) under rg(%s) of storage account(%s): %v", endpoint.ResourceName, endpoint.ResourceGroup, accountName, err)
+		}
+	}
+	klog.V(2).Infof("deleting empty storage account(%s) under rg(%s) created by the driver", accountName, resourceGroup)
+	if rerr := cloud.StorageAccountClient.Delete(ctx, subsID, resourceGroup, accountName); rerr != nil {
+		return false, fmt.Errorf("failed to delete storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	lock.deleted = true
+	// the deleted storage account must not be selected from caches by CreateVolume
+	for _, obj := range d.accountSearchCache.Store.List() {
+		if entry, ok := obj.(*azcache.AzureCacheEntry); ok && entry.Data == accountName {
+			if err := d.accountSearchCache.Delete(entry.Key); err != nil {
+				klog.Warningf("failed to delete %s from account search cache: %v", entry.Key, err)
+			}
+		}
+	}
+	d.volMap.Range(func(key, value interface{}) bool {
+		if value == accountName {
+			d.volMap.Delete(key)
+		}
+		return true
+	})
+	if err := d.accountCacheMap.Delete(accountName); err != nil {
+		klog.Warningf("failed to remove cached key of account(%s): %v", accountName, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,386 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestIsShareOwned(t *testing.T) {
+	tests := []struct {
+		desc      string
+		metadata  map[string]*string
+		namespace string
+		expected  bool
+	}{
+		{
+			desc: "no metadata",
+		},
+		{
+			desc:     "created by driver",
+			metadata: map[string]*string{createdByMetadataKey: pointer.String(createdByDriver)},
+			expected: true,
+		},
+		{
+			desc:     "created by someone else",
+			metadata: map[string]*string{createdByMetadataKey: pointer.String("terraform")},
+		},
+		{
+			desc:      "adopted in share name namespace",
+			metadata:  map[string]*string{shareNameNamespaceMetadataKey: pointer.String("cluster1")},
+			namespace: "cluster1",
+			expected:  true,
+		},
+		{
+			desc:      "adopted in another share name namespace",
+			metadata:  map[string]*string{shareNameNamespaceMetadataKey: pointer.String("cluster2")},
+			namespace: "cluster1",
+		},
+		{
+			desc:     "share name namespace is not set",
+			metadata: map[string]*string{shareNameNamespaceMetadataKey: pointer.String("")},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isShareOwned(test.metadata, test.namespace), test.desc)
+	}
+}
+
+func TestIsLegacyDriverShare(t *testing.T) {
+	tests := []struct {
+		volumeID      string
+		fileShareName string
+		expected      bool
+	}{
+		{volumeID: "rg#account#share", fileShareName: "share"},
+		{volumeID: "rg#account#share#diskname.vhd#", fileShareName: "share"},
+		{volumeID: "rg#account#share##pvc-name", fileShareName: "share"},
+		{volumeID: "rg#account#share##pvc-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", fileShareName: "share", expected: true},
+		{volumeID: "v2:account=account&rg=rg&share=share&uuid=pvc-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", fileShareName: "share", expected: true},
+		{volumeID: "rg#account#share#diskname.vhd#8c9d0e1f-2a3b-4c5d-0a1b-2c3d4e5f6a7b", fileShareName: "share"},
+		{volumeID: "rg#account#pvc-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", fileShareName: "pvc-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", expected: true},
+		{volumeID: "rg#account#pvcn-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", fileShareName: "pvcn-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", expected: true},
+		{volumeID: "rg#account#cluster1-prefix-pvcd-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", fileShareName: "cluster1-prefix-pvcd-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", expected: true},
+		{volumeID: "rg#account#share##myprefix-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", fileShareName: "share", expected: true},
+		{volumeID: "rg#account#myprefix-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", fileShareName: "myprefix-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", expected: true},
+		{volumeID: "rg#account#0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", fileShareName: "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"},
+		{volumeID: "rg#account#pvc-data", fileShareName: "pvc-data"},
+		{volumeID: "rg#account#pvc-0a1b2c3d-backup", fileShareName: "pvc-0a1b2c3d-backup"},
+		{volumeID: "rg#account#pvc-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d-copy", fileShareName: "pvc-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d-copy"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isLegacyDriverShare(test.volumeID, test.fileShareName), test.volumeID)
+	}
+}
+
+func TestIsAccountOwned(t *testing.T) {
+	assert.False(t, isAccountOwned(nil, ""))
+	assert.False(t, isAccountOwned(map[string]*string{createdByTag: pointer.String("terraform")}, ""))
+	assert.True(t, isAccountOwned(getAccountOwnershipTags(""), ""))
+	assert.True(t, isAccountOwned(getAccountOwnershipTags("cluster1"), "cluster1"))
+	assert.False(t, isAccountOwned(getAccountOwnershipTags("cluster1"), "cluster2"))
+	assert.False(t, isAccountOwned(getAccountOwnershipTags("cluster1"), ""))
+	assert.False(t, isAccountOwned(getAccountOwnershipTags(""), "cluster1"))
+}
+
+func TestRemoveOwnershipTags(t *testing.T) {
+	tags := getAccountOwnershipTags("cluster1")
+	tags["app"] = pointer.String("web")
+	assert.Equal(t, map[string]*string{"app": pointer.String("web")}, removeOwnershipTags(tags, map[string]string{"app": "web"}))
+	assert.Equal(t, map[string]*string{"app": pointer.String("web"), createdByTag: pointer.String(createdByDriver)},
+		removeOwnershipTags(tags, map[string]string{"app": "web", createdByTag: createdByDriver}))
+	assert.Equal(t, map[string]*string{}, removeOwnershipTags(nil, nil))
+}
+
+func TestLockAccountSelection(t *testing.T) {
+	d := NewFakeDriver()
+	d.deleteEmptyAccounts = true
+	release, selectable := d.lockAccountSelection("Account1")
+	assert.True(t, selectable)
+	assert.False(t, d.getAccountDeletionLock("account1").TryLock())
+	// other storage accounts are not locked
+	assert.True(t, d.getAccountDeletionLock("account2").TryLock())
+	release()
+	// released only once
+	release()
+	lock := d.getAccountDeletionLock("account1")
+	assert.True(t, lock.TryLock())
+	lock.deleted = true
+	lock.Unlock()
+	_, selectable = d.lockAccountSelection("account1")
+	assert.False(t, selectable)
+	assert.True(t, lock.TryLock())
+	lock.Unlock()
+
+	d.deleteEmptyAccounts = false
+	_, selectable = d.lockAccountSelection("account3")
+	assert.True(t, selectable)
+	assert.True(t, d.getAccountDeletionLock("account3").TryLock())
+}
+
+func TestCreateVolumeExcludesDeletedAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.deleteEmptyAccounts = true
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	setAccountCreateHookClient(d.cloud)
+	d.getAccountDeletionLock("deleted").deleted = true
+
+	// the deleted account is still listed, it's selected once and excluded when the account is selected again
+	accounts := []storage.Account{
+		{Name: pointer.String("deleted"), Sku: &storage.Sku{Name: storage.SkuNameStandardLRS}, Kind: storage.KindStorageV2, Location: pointer.String("eastus"),
+			AccountProperties: &storage.AccountProperties{EnableHTTPSTrafficOnly: pointer.Bool(true), MinimumTLSVersion: storage.MinimumTLSVersionTLS12}},
+	}
+	mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), "rg").Return(accounts, nil).Times(2)
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "rg", "deleted").Return(storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: pointer.String("key")}}}, nil).AnyTimes()
+	mockStorageAccountsClient.EXPECT().Create(gomock.Any(), gomock.Any(), "rg", gomock.Any(), gomock.Any()).Return(&retry.Error{RawError: fmt.Errorf("create error")}).Times(1)
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d",
+		VolumeCapabilities: []*csi.VolumeCapability{{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}, AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER}}},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 10 * 1024 * 1024 * 1024},
+		Parameters:         map[string]string{skuNameField: string(storage.SkuNameStandardLRS), locationField: "eastus", resourceGroupField: "rg"},
+	}
+	_, err := d.CreateVolume(context.Background(), req)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "create error")
+}
+
+func TestDeleteAccountIfEmpty(t *testing.T) {
+	ownedAccount := storage.Account{Name: pointer.String("account"), Tags: getAccountOwnershipTags("cluster1")}
+	peConnection := func(id string) storage.PrivateEndpointConnection {
+		return storage.PrivateEndpointConnection{PrivateEndpointConnectionProperties: &storage.PrivateEndpointConnectionProperties{
+			PrivateEndpoint: &storage.PrivateEndpoint{ID: pointer.String(id)},
+		}}
+	}
+	accountWithPE := ownedAccount
+	accountWithPE.AccountProperties = &storage.AccountProperties{PrivateEndpointConnections: &[]storage.PrivateEndpointConnection{
+		peConnection("/subscriptions/subsID/resourceGroups/pe-rg/providers/Microsoft.Network/privateEndpoints/account-pvtendpoint"),
+		peConnection("/subscriptions/subsID/resourceGroups/pe-rg/providers/Microsoft.Network/privateEndpoints/other"),
+	}}
+	tests := []struct {
+		desc              string
+		account           storage.Account
+		getErr            *retry.Error
+		shares            []storage.FileShareItem
+		deletePEErr       error
+		deleteErr         *retry.Error
+		expectedDeleted   bool
+		expectedEndpoints []string
+		expectedErr       error
+	}{
+		{
+			desc:            "empty account created by driver",
+			account:         ownedAccount,
+			expectedDeleted: true,
+		},
+		{
+			desc:    "account is not found",
+			getErr:  &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("ResourceNotFound")},
+			account: ownedAccount,
+		},
+		{
+			desc:    "account created by someone else",
+			account: storage.Account{},
+		},
+		{
+			desc:    "account created by driver in another share name namespace",
+			account: storage.Account{Tags: getAccountOwnershipTags("cluster2")},
+		},
+		{
+			desc:    "account has file shares",
+			account: ownedAccount,
+			shares:  []storage.FileShareItem{{Name: pointer.String("share")}},
+		},
+		{
+			desc:    "account has soft-deleted file shares",
+			account: ownedAccount,
+			shares:  []storage.FileShareItem{{Name: pointer.String("share"), FileShareProperties: &storage.FileShareProperties{Deleted: pointer.Bool(true)}}},
+		},
+		{
+			desc:            "file share just deleted is not counted",
+			account:         ownedAccount,
+			shares:          []storage.FileShareItem{{Name: pointer.String("deleted-share"), FileShareProperties: &storage.FileShareProperties{Deleted: pointer.Bool(true)}}},
+			expectedDeleted: true,
+		},
+		{
+			desc:              "private endpoint created with the account is deleted",
+			account:           accountWithPE,
+			expectedDeleted:   true,
+			expectedEndpoints: []string{"subsID/pe-rg/account-pvtendpoint"},
+		},
+		{
+			desc:              "account is kept if its private endpoint could not be deleted",
+			account:           accountWithPE,
+			deletePEErr:       fmt.Errorf("forbidden"),
+			expectedEndpoints: []string{"subsID/pe-rg/account-pvtendpoint"},
+			expectedErr:       fmt.Errorf("failed to delete private endpoint(account-pvtendpoint) under rg(pe-rg) of storage account(account): forbidden"),
+		},
+		{
+			desc:        "delete failure",
+			account:     ownedAccount,
+			deleteErr:   &retry.Error{HTTPStatusCode: http.StatusConflict, RawError: fmt.Errorf("conflict")},
+			expectedErr: fmt.Errorf("failed to delete storage account(account) under rg(rg): Retriable: false, RetryAfter: 0s, HTTPStatusCode: 409, RawError: conflict"),
+		},
+	}
+
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		d := NewFakeDriver()
+		d.shareNameNamespace = "cluster1"
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d.cloud.StorageAccountClient = mockStorageAccountsClient
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+		var endpoints []string
+		d.deletePrivateEndpoint = func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup, name string) error {
+			endpoints = append(endpoints, subsID+"/"+resourceGroup+"/"+name)
+			return test.deletePEErr
+		}
+
+		mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").Return(test.account, test.getErr)
+		owned := test.getErr == nil && isAccountOwned(test.account.Tags, d.shareNameNamespace)
+		if owned {
+			mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", "deleted").Return(test.shares, nil)
+		}
+		if owned && test.expectedDeleted || test.deleteErr != nil {
+			mockStorageAccountsClient.EXPECT().Delete(gomock.Any(), "subsID", "rg", "account").Return(test.deleteErr)
+		}
+		d.accountSearchCache.Set("lockKey", "account")
+		d.volMap.Store("vol", "account")
+
+		deleted, err := d.deleteAccountIfEmpty(context.Background(), "subsID", "rg", "account", "deleted-share")
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedDeleted, deleted, test.desc)
+		assert.Equal(t, test.expectedEndpoints, endpoints, test.desc)
+		assert.Equal(t, test.expectedDeleted, d.getAccountDeletionLock("account").deleted, test.desc)
+		_, cached := d.volMap.Load("vol")
+		assert.Equal(t, !test.expectedDeleted, cached, test.desc)
+		ctrl.Finish()
+	}
+}
+
+func TestDeleteVolumeOwnership(t *testing.T) {
+	tests := []struct {
+		desc                string
+		volumeID            string
+		fileShareName       string
+		metadata            map[string]*string
+		deleteUnownedShares bool
+		deleteEmptyAccounts bool
+		expectedShareDelete bool
+	}{
+		{
+			desc: "unowned file share is kept by default",
+		},
+		{
+			desc:                "unowned file share is deleted with delete-unowned-shares",
+			deleteUnownedShares: true,
+			expectedShareDelete: true,
+		},
+		{
+			desc:                "file share created by driver is deleted by default",
+			metadata:            map[string]*string{createdByMetadataKey: pointer.String(createdByDriver)},
+			expectedShareDelete: true,
+		},
+		{
+			desc:                "file share adopted by driver is deleted by default",
+			metadata:            map[string]*string{shareNameNamespaceMetadataKey: pointer.String("cluster1")},
+			expectedShareDelete: true,
+		},
+		{
+			desc:                "file share named after the volume by an older driver is deleted by default",
+			fileShareName:       "pvc-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d",
+			expectedShareDelete: true,
+		},
+		{
+			desc:                "file share of volume handle with volume name by an older driver is deleted by default",
+			volumeID:            "rg#account#share##pvc-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d",
+			expectedShareDelete: true,
+		},
+		{
+			desc:                "empty account is deleted with the last file share",
+			metadata:            map[string]*string{createdByMetadataKey: pointer.String(createdByDriver)},
+			deleteEmptyAccounts: true,
+			expectedShareDelete: true,
+		},
+		{
+			desc:                "empty account is not deleted with an unowned file share",
+			deleteEmptyAccounts: true,
+		},
+	}
+
+	for _, test := range tests {
+		fileShareName := "share"
+		if test.fileShareName != "" {
+			fileShareName = test.fileShareName
+		}
+		volumeID := "rg#account#" + fileShareName
+		if test.volumeID != "" {
+			volumeID = test.volumeID
+		}
+		ctrl := gomock.NewController(t)
+		d := NewFakeDriver()
+		d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+		d.shareNameNamespace = "cluster1"
+		d.deleteUnownedShares = test.deleteUnownedShares
+		d.deleteEmptyAccounts = test.deleteEmptyAccounts
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d.cloud.StorageAccountClient = mockStorageAccountsClient
+		mockFileClient := mockfileclient.NewMockInterface(ctrl)
+		d.cloud.FileClient = mockFileClient
+		mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+
+		fileShare := storage.FileShare{FileShareProperties: &storage.FileShareProperties{Metadata: test.metadata}}
+		mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", fileShareName, "").Return(fileShare, nil)
+		if test.expectedShareDelete {
+			mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), "rg", "account", fileShareName, gomock.Any()).Return(nil)
+			if test.deleteEmptyAccounts {
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "account").Return(storage.Account{Tags: getAccountOwnershipTags("cluster1")}, nil)
+				mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", "deleted").Return(nil, nil)
+				mockStorageAccountsClient.EXPECT().Delete(gomock.Any(), gomock.Any(), "rg", "account").Return(nil)
+			} else {
+				// skip matching tag is removed from the storage account which is kept
+				mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), gomock.Any(), "rg", "account").Return(storage.Account{}, nil)
+			}
+		}
+
+		_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: map[string]string{}})
+		assert.NoError(t, err, test.desc)
+		ctrl.Finish()
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"strconv"
+)
+
+// performance of a premium file share is derived from its provisioned size, burst is not configurable, see
+// https://learn.microsoft.com/en-us/azure/storage/files/understanding-billing#provisioned-model
+const (
+	premiumShareBaseIOPS            = 3000
+	premiumShareMinBurstIOPS        = 10000
+	premiumShareBurstIOPSPerGiB     = 3
+	premiumShareMaxIOPS             = 100000
+	premiumShareBaseThroughputMiBps = 100
+	premiumShareMaxThroughputMiBps  = 10340
+	// burst credits are accumulated up to the IOPS above baseline for one hour of burst
+	premiumShareBurstSeconds = 3600
+
+	// volume context keys of premium share performance
+	baselineIOPSKey        = "baselineIOPS"
+	burstIOPSKey           = "burstIOPS"
+	maxBurstCreditsKey     = "maxBurstCredits"
+	throughputMiBpsKey     = "throughputMiBps"
+	shareProvisionedGiBKey = "provisionedGiB"
+)
+
+// premiumSharePerformance is the performance of a premium file share with the provisioned size
+type premiumSharePerformance struct {
+	baselineIOPS    int
+	burstIOPS       int
+	maxBurstCredits int
+	throughputMiBps int
+}
+
+// getPremiumSharePerformance returns the baseline and burst performance of a premium file share with shareSizeGiB quota
+func getPremiumSharePerformance(shareSizeGiB int) premiumSharePerformance {
+	baselineIOPS := minInt(premiumShareBaseIOPS+shareSizeGiB, premiumShareMaxIOPS)
+	burstIOPS := minInt(maxInt(premiumShareMinBurstIOPS, premiumShareBurstIOPSPerGiB*shareSizeGiB), premiumShareMaxIOPS)
+	// ingress and egress throughput are 0.04 and 0.06 MiB/s per provisioned GiB, both rounded up
+	throughputMiBps := minInt(premiumShareBaseThroughputMiBps+(4*shareSizeGiB+99)/100+(6*shareSizeGiB+99)/100, premiumShareMaxThroughputMiBps)
+	return premiumSharePerformance{
+		baselineIOPS:    baselineIOPS,
+		burstIOPS:       burstIOPS,
+		maxBurstCredits: maxInt(burstIOPS-baselineIOPS, 0) * premiumShareBurstSeconds,
+		throughputMiBps: throughputMiBps,
+	}
+}
+
+// setPremiumSharePerformance sets performance of the premium file share with shareSizeGiB quota in volume context
+func setPremiumSharePerformance(volumeContext map[string]string, shareSizeGiB int) {
+	perf := getPremiumSharePerformance(shareSizeGiB)
+	volumeContext[shareProvisionedGiBKey] = strconv.Itoa(shareSizeGiB)
+	volumeContext[baselineIOPSKey] = strconv.Itoa(perf.baselineIOPS)
+	volumeContext[burstIOPSKey] = strconv.Itoa(perf.burstIOPS)
+	volumeContext[maxBurstCreditsKey] = strconv.Itoa(perf.maxBurstCredits)
+	volumeContext[throughputMiBpsKey] = strconv.Itoa(perf.throughputMiBps)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
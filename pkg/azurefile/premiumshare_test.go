@@ -0,0 +1,64 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPremiumSharePerformance(t *testing.T) {
+	tests := []struct {
+		shareSizeGiB int
+		expected     premiumSharePerformance
+	}{
+		{
+			shareSizeGiB: 100,
+			expected:     premiumSharePerformance{baselineIOPS: 3100, burstIOPS: 10000, maxBurstCredits: 24840000, throughputMiBps: 110},
+		},
+		{
+			shareSizeGiB: 1024,
+			expected:     premiumSharePerformance{baselineIOPS: 4024, burstIOPS: 10000, maxBurstCredits: 21513600, throughputMiBps: 203},
+		},
+		{
+			shareSizeGiB: 10240,
+			expected:     premiumSharePerformance{baselineIOPS: 13240, burstIOPS: 30720, maxBurstCredits: 62928000, throughputMiBps: 1125},
+		},
+		{
+			shareSizeGiB: 102400,
+			expected:     premiumSharePerformance{baselineIOPS: 100000, burstIOPS: 100000, maxBurstCredits: 0, throughputMiBps: 10340},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getPremiumSharePerformance(test.shareSizeGiB), "shareSizeGiB: %d", test.shareSizeGiB)
+	}
+}
+
+func TestSetPremiumSharePerformance(t *testing.T) {
+	volumeContext := map[string]string{skuNameField: "Premium_LRS"}
+	setPremiumSharePerformance(volumeContext, 100)
+	assert.Equal(t, map[string]string{
+		skuNameField:           "Premium_LRS",
+		shareProvisionedGiBKey: "100",
+		baselineIOPSKey:        "3100",
+		burstIOPSKey:           "10000",
+		maxBurstCreditsKey:     "24840000",
+		throughputMiBpsKey:     "110",
+	}, volumeContext)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// timeout of pre-warming all account keys on start
+	prewarmAccountKeysTimeout = 2 * time.Minute
+)
+
+// prewarmAccount is a storage account whose key is cached on start
+type prewarmAccount struct {
+	resourceGroup string
+	accountName   string
+}
+
+// runAccountKeyPrewarm caches keys of accounts in the background on start, so that pods rescheduled
+// to this node do not compete on listKeys, failures are logged and never block the driver
+func (d *Driver) runAccountKeyPrewarm(accounts string, fromMounts bool) {
+	prewarmAccounts, err := parsePrewarmAccounts(accounts)
+	if err != nil {
+		klog.Warningf("skip pre-warming account keys: %v", err)
+		return
+	}
+	if fromMounts {
+		mountPoints, err := d.mounter.List()
+		if err != nil {
+			klog.Warningf("failed to list mounts for pre-warming account keys: %v", err)
+		}
+		for _, accountName := range getAccountsFromMounts(mountPoints) {
+			prewarmAccounts = append(prewarmAccounts, prewarmAccount{accountName: accountName})
+		}
+	}
+	if len(prewarmAccounts) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), prewarmAccountKeysTimeout)
+		defer cancel()
+		cached := d.prewarmAccountKeys(ctx, prewarmAccounts)
+		klog.V(2).Infof("pre-warmed keys of %d out of %d storage accounts", cached, len(prewarmAccounts))
+	}()
+}
+
+// prewarmAccountKeys gets account keys one by one to avoid ARM throttling, keys are stored in
+// accountCacheMap by GetStorageAccesskey with the same TTL as keys cached on demand
+func (d *Driver) prewarmAccountKeys(ctx context.Context, accounts []prewarmAccount) int {
+	cached := 0
+	seen := map[prewarmAccount]bool{}
+	for _, account := range accounts {
+		if account.resourceGroup == "" {
+			account.resourceGroup = d.cloud.ResourceGroup
+		}
+		if seen[account] {
+			continue
+		}
+		seen[account] = true
+		if ctx.Err() != nil {
+			klog.Warningf("stop pre-warming account keys: %v", ctx.Err())
+			break
+		}
+		accountOptions := &azure.AccountOptions{
+			Name:           account.accountName,
+			SubscriptionID: d.cloud.SubscriptionID,
+			ResourceGroup:  account.resourceGroup,
+		}
+		if _, err := d.GetStorageAccesskey(ctx, accountOptions, nil, "", d.defaultSecretNamespace); err != nil {
+			klog.Warningf("failed to pre-warm key of account(%s) rg(%s): %v", account.accountName, account.resourceGroup, err)
+			continue
+		}
+		cached++
+	}
+	return cached
+}
+
+// parsePrewarmAccounts parses comma separated accounts in format accountName or resourceGroup/accountName
+func parsePrewarmAccounts(accounts string) ([]prewarmAccount, error) {
+	var result []prewarmAccount
+	for _, v := range strings.Split(accounts, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		parts := strings.Split(v, "/")
+		switch {
+		case len(parts) == 1:
+			result = append(result, prewarmAccount{accountName: parts[0]})
+		case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+			result = append(result, prewarmAccount{resourceGroup: parts[0], accountName: parts[1]})
+		default:
+			return nil, fmt.Errorf("invalid account(%s), expected format: accountName or resourceGroup/accountName", v)
+		}
+	}
+	return result, nil
+}
+
+// getAccountsFromMounts returns account names of SMB mounts, e.g. account of //account.file.core.windows.net/share,
+// mounts with a custom server address which could not be mapped to an account are skipped
+func getAccountsFromMounts(mountPoints []mount.MountPoint) []string {
+	var accounts []string
+	seen := map[string]bool{}
+	for _, mp := range mountPoints {
+		if !strings.HasPrefix(mp.Device, "//") {
+			continue
+		}
+		host := strings.SplitN(strings.TrimPrefix(mp.Device, "//"), "/", 2)[0]
+		if !strings.Contains(host, ".file.") {
+			continue
+		}
+		accountName := host[:strings.Index(host, ".")]
+		if !seen[accountName] {
+			seen[accountName] = true
+			accounts = append(accounts, accountName)
+		}
+	}
+	return accounts
+}
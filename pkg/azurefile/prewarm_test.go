@@ -0,0 +1,98 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	mount "k8s.io/mount-utils"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestParsePrewarmAccounts(t *testing.T) {
+	tests := []struct {
+		accounts    string
+		expected    []prewarmAccount
+		expectedErr bool
+	}{
+		{accounts: ""},
+		{
+			accounts: "account1, rg/account2,",
+			expected: []prewarmAccount{{accountName: "account1"}, {resourceGroup: "rg", accountName: "account2"}},
+		},
+		{accounts: "rg/", expectedErr: true},
+		{accounts: "subs/rg/account", expectedErr: true},
+	}
+	for _, test := range tests {
+		result, err := parsePrewarmAccounts(test.accounts)
+		assert.Equal(t, test.expectedErr, err != nil, test.accounts)
+		assert.Equal(t, test.expected, result, test.accounts)
+	}
+}
+
+func TestGetAccountsFromMounts(t *testing.T) {
+	mountPoints := []mount.MountPoint{
+		{Device: "//account1.file.core.windows.net/share1", Type: "cifs"},
+		{Device: "//account1.file.core.windows.net/share2", Type: "cifs"},
+		{Device: "//account2.privatelink.file.core.chinacloudapi.cn/share", Type: "cifs"},
+		{Device: "//10.0.0.4/share", Type: "cifs"},
+		{Device: "account3.file.core.windows.net:/account3/share", Type: "nfs4"},
+		{Device: "/dev/sda1", Type: "ext4"},
+	}
+	assert.Equal(t, []string{"account1", "account2"}, getAccountsFromMounts(mountPoints))
+}
+
+func TestPrewarmAccountKeys(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.cloud.ResourceGroup = "rg"
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	keys := storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: pointer.String("key")}}}
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), "subscriptionID", "rg", "account1").Return(keys, nil).Times(1)
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), "subscriptionID", "rg2", "account2").Return(storage.AccountListKeysResult{}, &retry.Error{RawError: fmt.Errorf("test error")}).Times(1)
+
+	accounts := []prewarmAccount{
+		{accountName: "account1"},
+		{resourceGroup: "rg", accountName: "account1"},
+		{resourceGroup: "rg2", accountName: "account2"},
+	}
+	assert.Equal(t, 1, d.prewarmAccountKeys(context.Background(), accounts))
+
+	cache, err := d.accountCacheMap.Get("account1", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", cache)
+	cache, err = d.accountCacheMap.Get("account2", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Nil(t, cache)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, 0, d.prewarmAccountKeys(ctx, []prewarmAccount{{accountName: "account3"}}))
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-08-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	azureresource "github.com/Azure/go-autorest/autorest/azure"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	ratelimitconfig "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
+)
+
+const (
+	// suffix of the private endpoint name of a storage account created by EnsureStorageAccount
+	privateEndpointNameSuffix = "-pvtendpoint"
+	// suffix of the private DNS zone group name of the private endpoint
+	privateDNSZoneGroupNameSuffix = "-dnszonegroup"
+	// suffix of the private link service connection name of the private endpoint
+	privateLinkServiceConnectionNameSuffix = "-pvtsvcconn"
+	// suffix of the virtual network link name of the private DNS zone
+	virtualNetworkLinkNameSuffix = "-vnetlink"
+	// location of private DNS zones and their virtual network links
+	privateDNSZoneLocation = "global"
+)
+
+// privateEndpointOptions are the options of the private endpoint of a storage account, which is created by the driver
+// if the private endpoint resource group is different from the resource group of the virtual network, the cloud
+// provider only creates private endpoint resources in the resource group of the virtual network
+type privateEndpointOptions struct {
+	accountName string
+	accountID   string
+	location    string
+	// resource group of the private endpoint, the private DNS zone and its virtual network link
+	resourceGroup     string
+	vnetResourceGroup string
+	vnetName          string
+	subnetName        string
+	// private DNS zone, e.g. privatelink.file.core.windows.net
+	privateDNSZoneName string
+}
+
+// setARMClientAuthorizer sets the authorizer and user agent of the cloud provider on an ARM client which is not
+// provided by the cloud provider
+func setARMClientAuthorizer(client *autorest.Client, cloud *azure.Cloud) error {
+	token, err := ratelimitconfig.GetServicePrincipalToken(&cloud.AzureAuthConfig, &cloud.Environment, cloud.Environment.ServiceManagementEndpoint)
+	if err != nil {
+		return fmt.Errorf("failed to get service principal token: %v", err)
+	}
+	client.Authorizer = autorest.NewBearerAuthorizer(token)
+	if cloud.UserAgent != "" {
+		if err := client.AddToUserAgent(cloud.UserAgent); err != nil {
+			klog.Warningf("failed to add user agent(%s): %v", cloud.UserAgent, err)
+		}
+	}
+	return nil
+}
+
+// createPrivateEndpointByARM creates the private DNS zone and its virtual network link if they don't exist, disables
+// private endpoint network policies of the subnet, and creates the private endpoint of the storage account with its
+// private DNS zone group. Network resources are in the subscription of the cloud provider, as EnsureStorageAccount does.
+func createPrivateEndpointByARM(ctx context.Context, cloud *azure.Cloud, options privateEndpointOptions) error {
+	subsID := cloud.SubscriptionID
+	zonesClient := privatedns.NewPrivateZonesClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	if err := setARMClientAuthorizer(&zonesClient.Client, cloud); err != nil {
+		return err
+	}
+	if _, err := zonesClient.Get(ctx, options.resourceGroup, options.privateDNSZoneName); err != nil {
+		klog.V(2).Infof("creating private DNS zone(%s) in resource group(%s) since get returned: %v", options.privateDNSZoneName, options.resourceGroup, err)
+		future, err := zonesClient.CreateOrUpdate(ctx, options.resourceGroup, options.privateDNSZoneName, privatedns.PrivateZone{Location: pointer.String(privateDNSZoneLocation)}, "", "*")
+		if err == nil {
+			err = future.WaitForCompletionRef(ctx, zonesClient.Client)
+		}
+		if err != nil && !strings.Contains(err.Error(), "exists already") {
+			return fmt.Errorf("failed to create private DNS zone(%s) in resource group(%s): %w", options.privateDNSZoneName, options.resourceGroup, err)
+		}
+	}
+
+	linksClient := privatedns.NewVirtualNetworkLinksClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	if err := setARMClientAuthorizer(&linksClient.Client, cloud); err != nil {
+		return err
+	}
+	linkName := options.vnetName + virtualNetworkLinkNameSuffix
+	if _, err := linksClient.Get(ctx, options.resourceGroup, options.privateDNSZoneName, linkName); err != nil {
+		klog.V(2).Infof("creating virtual network link(%s) of private DNS zone(%s) in resource group(%s) since get returned: %v", linkName, options.privateDNSZoneName, options.resourceGroup, err)
+		vnetID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s", subsID, options.vnetResourceGroup, options.vnetName)
+		link := privatedns.VirtualNetworkLink{
+			Location: pointer.String(privateDNSZoneLocation),
+			VirtualNetworkLinkProperties: &privatedns.VirtualNetworkLinkProperties{
+				VirtualNetwork:      &privatedns.SubResource{ID: &vnetID},
+				RegistrationEnabled: pointer.Bool(false),
+			},
+		}
+		future, err := linksClient.CreateOrUpdate(ctx, options.resourceGroup, options.privateDNSZoneName, linkName, link, "", "")
+		if err == nil {
+			err = future.WaitForCompletionRef(ctx, linksClient.Client)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create virtual network link of virtual network(%s) in resource group(%s) and private DNS zone(%s) in resource group(%s): %w",
+				options.vnetName, options.vnetResourceGroup, options.privateDNSZoneName, options.resourceGroup, err)
+		}
+	}
+
+	if cloud.SubnetsClient == nil {
+		return fmt.Errorf("SubnetsClient is nil")
+	}
+	subnet, rerr := cloud.SubnetsClient.Get(ctx, options.vnetResourceGroup, options.vnetName, options.subnetName, "")
+	if rerr != nil {
+		return fmt.Errorf("failed to get subnet(%s) of virtual network(%s) in resource group(%s): %v", options.subnetName, options.vnetName, options.vnetResourceGroup, rerr.Error())
+	}
+	if subnet.SubnetPropertiesFormat != nil && subnet.SubnetPropertiesFormat.PrivateEndpointNetworkPolicies != network.VirtualNetworkPrivateEndpointNetworkPoliciesDisabled {
+		subnet.SubnetPropertiesFormat.PrivateEndpointNetworkPolicies = network.VirtualNetworkPrivateEndpointNetworkPoliciesDisabled
+		if rerr := cloud.SubnetsClient.CreateOrUpdate(ctx, options.vnetResourceGroup, options.vnetName, options.subnetName, subnet); rerr != nil {
+			return fmt.Errorf("failed to disable private endpoint network policies of subnet(%s) of virtual network(%s) in resource group(%s): %v", options.subnetName, options.vnetName, options.vnetResourceGroup, rerr.Error())
+		}
+	}
+
+	endpointsClient := network.NewPrivateEndpointsClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	if err := setARMClientAuthorizer(&endpointsClient.Client, cloud); err != nil {
+		return err
+	}
+	endpointName := options.accountName + privateEndpointNameSuffix
+	klog.V(2).Infof("creating private endpoint(%s) of storage account(%s) in resource group(%s)", endpointName, options.accountName, options.resourceGroup)
+	endpoint := network.PrivateEndpoint{
+		Location: pointer.String(options.location),
+		PrivateEndpointProperties: &network.PrivateEndpointProperties{
+			Subnet: &subnet,
+			PrivateLinkServiceConnections: &[]network.PrivateLinkServiceConnection{{
+				Name: pointer.String(options.accountName + privateLinkServiceConnectionNameSuffix),
+				PrivateLinkServiceConnectionProperties: &network.PrivateLinkServiceConnectionProperties{
+					GroupIds:             &[]string{string(azure.StorageTypeFile)},
+					PrivateLinkServiceID: pointer.String(options.accountID),
+				},
+			}},
+		},
+	}
+	endpointFuture, err := endpointsClient.CreateOrUpdate(ctx, options.resourceGroup, endpointName, endpoint)
+	if err == nil {
+		err = endpointFuture.WaitForCompletionRef(ctx, endpointsClient.Client)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create private endpoint(%s) in resource group(%s) on subnet(%s) of virtual network(%s) in resource group(%s): %w",
+			endpointName, options.resourceGroup, options.subnetName, options.vnetName, options.vnetResourceGroup, err)
+	}
+
+	zoneGroupsClient := network.NewPrivateDNSZoneGroupsClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	if err := setARMClientAuthorizer(&zoneGroupsClient.Client, cloud); err != nil {
+		return err
+	}
+	zoneID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/privateDnsZones/%s", subsID, options.resourceGroup, options.privateDNSZoneName)
+	zoneGroup := network.PrivateDNSZoneGroup{
+		PrivateDNSZoneGroupPropertiesFormat: &network.PrivateDNSZoneGroupPropertiesFormat{
+			PrivateDNSZoneConfigs: &[]network.PrivateDNSZoneConfig{{
+				Name:                           pointer.String(options.privateDNSZoneName),
+				PrivateDNSZonePropertiesFormat: &network.PrivateDNSZonePropertiesFormat{PrivateDNSZoneID: &zoneID},
+			}},
+		},
+	}
+	zoneGroupFuture, err := zoneGroupsClient.CreateOrUpdate(ctx, options.resourceGroup, endpointName, options.accountName+privateDNSZoneGroupNameSuffix, zoneGroup)
+	if err == nil {
+		err = zoneGroupFuture.WaitForCompletionRef(ctx, zoneGroupsClient.Client)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create private DNS zone group of private endpoint(%s) in resource group(%s): %w", endpointName, options.resourceGroup, err)
+	}
+	return nil
+}
+
+// createAccountPrivateEndpoint creates the private endpoint of the storage account in privateEndpointResourceGroup on
+// the subnet of the virtual network in vnetResourceGroup, it's called for the storage account created by
+// EnsureStorageAccount without private endpoint
+func (d *Driver) createAccountPrivateEndpoint(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup, accountName, privateEndpointResourceGroup, vnetResourceGroup, vnetName, subnetName, storageEndpointSuffix string) error {
+	if cloud.StorageAccountClient == nil {
+		return fmt.Errorf("storage account client is nil")
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get storage account(%s) under rg(%s) to create its private endpoint: %v", accountName, resourceGroup, rerr.Error())
+	}
+	options := privateEndpointOptions{
+		accountName:        accountName,
+		accountID:          pointer.StringDeref(account.ID, ""),
+		location:           pointer.StringDeref(account.Location, ""),
+		resourceGroup:      privateEndpointResourceGroup,
+		vnetResourceGroup:  vnetResourceGroup,
+		vnetName:           vnetName,
+		subnetName:         subnetName,
+		privateDNSZoneName: fmt.Sprintf("privatelink.file.%s", storageEndpointSuffix),
+	}
+	if options.vnetResourceGroup == "" {
+		options.vnetResourceGroup = cloud.ResourceGroup
+		if cloud.VnetResourceGroup != "" {
+			options.vnetResourceGroup = cloud.VnetResourceGroup
+		}
+	}
+	if options.vnetName == "" {
+		options.vnetName = cloud.VnetName
+	}
+	if options.subnetName == "" {
+		options.subnetName = cloud.SubnetName
+	}
+	if err := d.createPrivateEndpoint(ctx, cloud, options); err != nil {
+		return fmt.Errorf("failed to create private endpoint of storage account(%s) under rg(%s) in resource group(%s): %v", accountName, resourceGroup, privateEndpointResourceGroup, err)
+	}
+	return nil
+}
+
+// deletePrivateEndpointByARM deletes the private endpoint and waits until it's deleted, its private DNS zone group and
+// network interface are deleted with it. The private endpoint client of the cloud provider could not delete.
+func deletePrivateEndpointByARM(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup, name string) error {
+	client := network.NewPrivateEndpointsClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	if err := setARMClientAuthorizer(&client.Client, cloud); err != nil {
+		return err
+	}
+	future, err := client.Delete(ctx, resourceGroup, name)
+	if err != nil {
+		return err
+	}
+	return future.WaitForCompletionRef(ctx, client.Client)
+}
+
+// getDriverPrivateEndpoints returns the private endpoints of the storage account which are created with the account by
+// EnsureStorageAccount, private endpoints created by others are not returned
+func getDriverPrivateEndpoints(account storage.Account) []azureresource.Resource {
+	if account.AccountProperties == nil || account.AccountProperties.PrivateEndpointConnections == nil || account.Name == nil {
+		return nil
+	}
+	var endpoints []azureresource.Resource
+	for _, conn := range *account.AccountProperties.PrivateEndpointConnections {
+		if conn.PrivateEndpointConnectionProperties == nil || conn.PrivateEndpointConnectionProperties.PrivateEndpoint == nil ||
+			conn.PrivateEndpointConnectionProperties.PrivateEndpoint.ID == nil {
+			continue
+		}
+		endpoint, err := azureresource.ParseResourceID(*conn.PrivateEndpointConnectionProperties.PrivateEndpoint.ID)
+		if err != nil {
+			klog.Warningf("failed to parse private endpoint of storage account(%s): %v", *account.Name, err)
+			continue
+		}
+		if strings.EqualFold(endpoint.ResourceName, *account.Name+privateEndpointNameSuffix) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestGetDriverPrivateEndpoints(t *testing.T) {
+	connection := func(id string) storage.PrivateEndpointConnection {
+		return storage.PrivateEndpointConnection{PrivateEndpointConnectionProperties: &storage.PrivateEndpointConnectionProperties{
+			PrivateEndpoint: &storage.PrivateEndpoint{ID: pointer.String(id)},
+		}}
+	}
+	account := storage.Account{
+		Name: pointer.String("account"),
+		AccountProperties: &storage.AccountProperties{PrivateEndpointConnections: &[]storage.PrivateEndpointConnection{
+			connection("/subscriptions/subs/resourceGroups/pe-rg/providers/Microsoft.Network/privateEndpoints/account-pvtendpoint"),
+			connection("/subscriptions/subs/resourceGroups/pe-rg/providers/Microsoft.Network/privateEndpoints/manual"),
+			connection("invalid"),
+			{},
+		}},
+	}
+
+	endpoints := getDriverPrivateEndpoints(account)
+	assert.Len(t, endpoints, 1)
+	assert.Equal(t, "subs", endpoints[0].SubscriptionID)
+	assert.Equal(t, "pe-rg", endpoints[0].ResourceGroup)
+	assert.Equal(t, "account-pvtendpoint", endpoints[0].ResourceName)
+	assert.Empty(t, getDriverPrivateEndpoints(storage.Account{Name: pointer.String("account")}))
+}
+
+func TestCreateAccountPrivateEndpoint(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.ResourceGroup = "rg"
+	d.cloud.VnetResourceGroup = "vnet-rg"
+	d.cloud.VnetName = "vnet"
+	d.cloud.SubnetName = "subnet"
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	var created []privateEndpointOptions
+	d.createPrivateEndpoint = func(ctx context.Context, cloud *azure.Cloud, options privateEndpointOptions) error {
+		created = append(created, options)
+		return nil
+	}
+
+	account := storage.Account{ID: pointer.String("accountID"), Location: pointer.String("westus")}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subs", "rg", "account").Return(account, nil).Times(2)
+	assert.NoError(t, d.createAccountPrivateEndpoint(context.Background(), d.cloud, "subs", "rg", "account", "pe-rg", "", "", "", "core.windows.net"))
+	assert.NoError(t, d.createAccountPrivateEndpoint(context.Background(), d.cloud, "subs", "rg", "account", "pe-rg", "other-vnet-rg", "vnet2", "subnet2", "core.windows.net"))
+	assert.Equal(t, []privateEndpointOptions{
+		{
+			accountName:        "account",
+			accountID:          "accountID",
+			location:           "westus",
+			resourceGroup:      "pe-rg",
+			vnetResourceGroup:  "vnet-rg",
+			vnetName:           "vnet",
+			subnetName:         "subnet",
+			privateDNSZoneName: "privatelink.file.core.windows.net",
+		},
+		{
+			accountName:        "account",
+			accountID:          "accountID",
+			location:           "westus",
+			resourceGroup:      "pe-rg",
+			vnetResourceGroup:  "other-vnet-rg",
+			vnetName:           "vnet2",
+			subnetName:         "subnet2",
+			privateDNSZoneName: "privatelink.file.core.windows.net",
+		},
+	}, created)
+
+	d.createPrivateEndpoint = func(ctx context.Context, cloud *azure.Cloud, options privateEndpointOptions) error {
+		return fmt.Errorf("forbidden")
+	}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subs", "rg", "account").Return(account, nil)
+	assert.Equal(t, fmt.Errorf("failed to create private endpoint of storage account(account) under rg(rg) in resource group(pe-rg): forbidden"),
+		d.createAccountPrivateEndpoint(context.Background(), d.cloud, "subs", "rg", "account", "pe-rg", "", "", "", "core.windows.net"))
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// parsePublicNetworkAccess returns the public network access of publicNetworkAccess parameter, case insensitive
+func parsePublicNetworkAccess(value string) (storage.PublicNetworkAccess, error) {
+	for _, v := range storage.PossiblePublicNetworkAccessValues() {
+		if strings.EqualFold(value, string(v)) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid %s: %s in storage class, supported values are %v", publicNetworkAccessField, value, storage.PossiblePublicNetworkAccessValues())
+}
+
+// checkPublicNetworkAccess checks public network access of the storage account provided by storageAccount or matched by
+// CreateVolume, the account is not changed. Violation is logged as a warning, or fails with FailedPrecondition if
+// rejectPublicNetworkAccessViolation is set. Public network access of the account created by CreateVolume is set in
+// the account create request.
+func (d *Driver) checkPublicNetworkAccess(ctx context.Context, subsID, resourceGroup, accountName string, publicNetworkAccess storage.PublicNetworkAccess) error {
+	cloud := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName)
+	if cloud.StorageAccountClient == nil {
+		return status.Errorf(codes.Internal, "StorageAccountClient is nil")
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		if d.rejectPublicNetworkAccessViolation {
+			return status.Errorf(codes.Internal, "failed to check public network access of storage account(%s) in resource group(%s): %v", accountName, resourceGroup, rerr.Error())
+		}
+		klog.Warningf("failed to check public network access of storage account(%s) in resource group(%s): %v", accountName, resourceGroup, rerr.Error())
+		return nil
+	}
+	// public network access is enabled if the property is not set
+	current := storage.PublicNetworkAccessEnabled
+	if account.AccountProperties != nil && account.AccountProperties.PublicNetworkAccess != "" {
+		current = account.AccountProperties.PublicNetworkAccess
+	}
+	if strings.EqualFold(string(current), string(publicNetworkAccess)) {
+		return nil
+	}
+
+	msg := fmt.Sprintf("storage account(%s) in resource group(%s) has public network access(%s), which violates %s(%s) of storage class", accountName, resourceGroup, current, publicNetworkAccessField, publicNetworkAccess)
+	if d.rejectPublicNetworkAccessViolation {
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+	klog.Warning(msg)
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestParsePublicNetworkAccess(t *testing.T) {
+	value, err := parsePublicNetworkAccess("disabled")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.PublicNetworkAccessDisabled, value)
+	value, err = parsePublicNetworkAccess("Enabled")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.PublicNetworkAccessEnabled, value)
+	_, err = parsePublicNetworkAccess("")
+	assert.Error(t, err)
+}
+
+func TestCheckPublicNetworkAccess(t *testing.T) {
+	tests := []struct {
+		desc         string
+		current      storage.PublicNetworkAccess
+		getErr       *retry.Error
+		reject       bool
+		expectedCode codes.Code
+	}{
+		{
+			desc:    "account with the same setting",
+			current: storage.PublicNetworkAccessDisabled,
+		},
+		{
+			desc:    "violation of reused account is logged",
+			current: storage.PublicNetworkAccessEnabled,
+		},
+		{
+			desc:         "violation of reused account is rejected",
+			current:      storage.PublicNetworkAccessEnabled,
+			reject:       true,
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:         "account without the property is considered enabled",
+			reject:       true,
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:   "reused account could not be read",
+			getErr: &retry.Error{HTTPStatusCode: http.StatusForbidden, RawError: fmt.Errorf("AuthorizationFailed")},
+		},
+		{
+			desc:         "reused account could not be read and violation is rejected",
+			getErr:       &retry.Error{HTTPStatusCode: http.StatusForbidden, RawError: fmt.Errorf("AuthorizationFailed")},
+			reject:       true,
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			d := NewFakeDriver()
+			d.cloud = &azure.Cloud{}
+			d.rejectPublicNetworkAccessViolation = test.reject
+			mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+			d.cloud.StorageAccountClient = mockStorageAccountsClient
+			// the account is never updated
+			mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account").
+				Return(storage.Account{AccountProperties: &storage.AccountProperties{PublicNetworkAccess: test.current}}, test.getErr).Times(1)
+
+			err := d.checkPublicNetworkAccess(context.Background(), "subsID", "rg", "account", storage.PublicNetworkAccessDisabled)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"k8s.io/klog/v2"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// inherit all tags of the resource group
+const inheritAllResourceGroupTags = "*"
+
+// parseInheritResourceGroupTags parses the comma separated tag names of --inherit-resource-group-tags
+func parseInheritResourceGroupTags(value string) []string {
+	var keys []string
+	for _, k := range strings.Split(value, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// getResourceGroupTagsByARM returns tags of the resource group, the cloud provider does not have a resource group client
+func getResourceGroupTagsByARM(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error) {
+	client := resources.NewGroupsClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	if err := setARMClientAuthorizer(&client.Client, cloud); err != nil {
+		return nil, err
+	}
+	group, err := client.Get(ctx, resourceGroup)
+	if err != nil {
+		return nil, err
+	}
+	return group.Tags, nil
+}
+
+// getResourceGroupTagsToInherit reads tags of the resource group, which are inherited by the storage account created
+// in it, it returns nil if no tag is inherited or reading tags is forbidden by RBAC
+func (d *Driver) getResourceGroupTagsToInherit(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error) {
+	if len(d.inheritResourceGroupTags) == 0 {
+		return nil, nil
+	}
+	if subsID == "" {
+		subsID = cloud.SubscriptionID
+	}
+	rgTags, err := d.getResourceGroupTags(ctx, cloud, subsID, resourceGroup)
+	if err != nil {
+		if isForbiddenError(err) {
+			klog.Warningf("skip inheriting tags of resource group(%s) in subscription(%s) since reading them is forbidden: %v", resourceGroup, subsID, err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get tags of resource group(%s) in subscription(%s): %v", resourceGroup, subsID, err)
+	}
+	return rgTags, nil
+}
+
+// mergeResourceGroupTags returns tags with the resource group tags in inheritKeys added, tags take precedence over
+// resource group tags with the same case insensitive name, resource group tags exceeding maxAccountTagNum are skipped
+func mergeResourceGroupTags(tags map[string]string, rgTags map[string]*string, inheritKeys []string) map[string]string {
+	inheritAll := false
+	inherit := make(map[string]bool)
+	for _, k := range inheritKeys {
+		if k == inheritAllResourceGroupTags {
+			inheritAll = true
+		}
+		inherit[strings.ToLower(k)] = true
+	}
+
+	result := make(map[string]string, len(tags))
+	existing := make(map[string]bool, len(tags))
+	for k, v := range tags {
+		result[k] = v
+		existing[strings.ToLower(k)] = true
+	}
+
+	keys := make([]string, 0, len(rgTags))
+	for k := range rgTags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if rgTags[k] == nil || existing[strings.ToLower(k)] || (!inheritAll && !inherit[strings.ToLower(k)]) {
+			continue
+		}
+		if len(result) >= maxAccountTagNum {
+			klog.Warningf("skip inheriting tag(%s) of resource group since the number of tags would exceed the limit(%d)", k, maxAccountTagNum)
+			continue
+		}
+		result[k] = *rgTags[k]
+	}
+	return result
+}
+
+// removeInheritedTags returns tags of a storage account without the tags which could be inherited from the resource
+// group, so that they are not matched against tags of the storage class, tags in classTags are kept
+func removeInheritedTags(tags map[string]*string, rgTags map[string]*string, inheritKeys []string, classTags map[string]string) map[string]*string {
+	inherited := mergeResourceGroupTags(nil, rgTags, inheritKeys)
+	if len(inherited) == 0 {
+		return tags
+	}
+	inheritedByName := make(map[string]string, len(inherited))
+	for k, v := range inherited {
+		inheritedByName[strings.ToLower(k)] = v
+	}
+	result := make(map[string]*string, len(tags))
+	for k, v := range tags {
+		if _, ok := classTags[k]; !ok && v != nil {
+			if value, ok := inheritedByName[strings.ToLower(k)]; ok && value == *v {
+				continue
+			}
+		}
+		result[k] = v
+	}
+	return result
+}
+
+func isForbiddenError(err error) bool {
+	var detailedErr autorest.DetailedError
+	if errors.As(err, &detailedErr) && detailedErr.Response != nil && detailedErr.Response.StatusCode == http.StatusForbidden {
+		return true
+	}
+	return strings.Contains(err.Error(), authorizationFailed)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestParseInheritResourceGroupTags(t *testing.T) {
+	assert.Nil(t, parseInheritResourceGroupTags(""))
+	assert.Equal(t, []string{"costcenter", "owner"}, parseInheritResourceGroupTags(" costcenter, ,owner,"))
+	assert.Equal(t, []string{"*"}, parseInheritResourceGroupTags("*"))
+}
+
+func TestMergeResourceGroupTags(t *testing.T) {
+	fullTags := make(map[string]string)
+	for i := 0; i < maxAccountTagNum-1; i++ {
+		fullTags[fmt.Sprintf("key%d", i)] = "value"
+	}
+	rgTags := map[string]*string{
+		"CostCenter": pointer.String("cc1"),
+		"owner":      pointer.String("team1"),
+		"env":        pointer.String("prod"),
+		"empty":      nil,
+	}
+
+	tests := []struct {
+		desc        string
+		tags        map[string]string
+		inheritKeys []string
+		expected    map[string]string
+	}{
+		{
+			desc:        "inherit a subset of tags with case insensitive names",
+			tags:        map[string]string{},
+			inheritKeys: []string{"costcenter", "owner", "notexist"},
+			expected:    map[string]string{"CostCenter": "cc1", "owner": "team1"},
+		},
+		{
+			desc:        "inherit all tags",
+			tags:        map[string]string{"app": "web"},
+			inheritKeys: []string{inheritAllResourceGroupTags},
+			expected:    map[string]string{"app": "web", "CostCenter": "cc1", "owner": "team1", "env": "prod"},
+		},
+		{
+			desc:        "custom tags take precedence over resource group tags",
+			tags:        map[string]string{"Owner": "team2"},
+			inheritKeys: []string{"owner", "env"},
+			expected:    map[string]string{"Owner": "team2", "env": "prod"},
+		},
+		{
+			desc:        "resource group tags exceeding the limit are skipped in name order",
+			tags:        fullTags,
+			inheritKeys: []string{inheritAllResourceGroupTags},
+			expected: func() map[string]string {
+				m := map[string]string{"CostCenter": "cc1"}
+				for k, v := range fullTags {
+					m[k] = v
+				}
+				return m
+			}(),
+		},
+	}
+
+	for _, test := range tests {
+		result := mergeResourceGroupTags(test.tags, rgTags, test.inheritKeys)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("test[%s]: unexpected result: %v, expected: %v", test.desc, result, test.expected)
+		}
+	}
+	assert.Len(t, fullTags, maxAccountTagNum-1, "input tags should not be changed")
+}
+
+func TestGetResourceGroupTagsToInherit(t *testing.T) {
+	d := NewFakeDriver()
+	cloud := &azure.Cloud{}
+	cloud.SubscriptionID = "default-subs"
+
+	// disabled by default
+	d.getResourceGroupTags = func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error) {
+		t.Errorf("resource group tags should not be read")
+		return nil, nil
+	}
+	result, err := d.getResourceGroupTagsToInherit(context.Background(), cloud, "", "rg")
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+
+	d.inheritResourceGroupTags = []string{"owner"}
+	rgTags := map[string]*string{"owner": pointer.String("team1"), "env": pointer.String("prod")}
+	d.getResourceGroupTags = func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error) {
+		assert.Equal(t, "default-subs", subsID)
+		assert.Equal(t, "rg", resourceGroup)
+		return rgTags, nil
+	}
+	result, err = d.getResourceGroupTagsToInherit(context.Background(), cloud, "", "rg")
+	assert.NoError(t, err)
+	assert.Equal(t, rgTags, result)
+
+	// forbidden by RBAC
+	d.getResourceGroupTags = func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error) {
+		return nil, autorest.DetailedError{Response: &http.Response{StatusCode: http.StatusForbidden}, Message: "forbidden"}
+	}
+	result, err = d.getResourceGroupTagsToInherit(context.Background(), cloud, "subs", "rg")
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+
+	d.getResourceGroupTags = func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error) {
+		return nil, fmt.Errorf("Code=\"AuthorizationFailed\"")
+	}
+	result, err = d.getResourceGroupTagsToInherit(context.Background(), cloud, "subs", "rg")
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+
+	d.getResourceGroupTags = func(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup string) (map[string]*string, error) {
+		return nil, fmt.Errorf("timeout")
+	}
+	_, err = d.getResourceGroupTagsToInherit(context.Background(), cloud, "subs", "rg")
+	assert.Equal(t, fmt.Errorf("failed to get tags of resource group(rg) in subscription(subs): timeout"), err)
+}
+
+func TestRemoveInheritedTags(t *testing.T) {
+	rgTags := map[string]*string{"Owner": pointer.String("team1"), "env": pointer.String("prod")}
+	tags := map[string]*string{"app": pointer.String("web"), "owner": pointer.String("team1"), "env": pointer.String("prod")}
+
+	assert.Equal(t, tags, removeInheritedTags(tags, nil, []string{"owner"}, nil))
+	assert.Equal(t, map[string]*string{"app": pointer.String("web"), "env": pointer.String("prod")}, removeInheritedTags(tags, rgTags, []string{"owner"}, nil))
+	assert.Equal(t, map[string]*string{"app": pointer.String("web")}, removeInheritedTags(tags, rgTags, []string{inheritAllResourceGroupTags}, nil))
+	// tags of storage class are matched
+	assert.Equal(t, map[string]*string{"app": pointer.String("web"), "owner": pointer.String("team1")}, removeInheritedTags(tags, rgTags, []string{inheritAllResourceGroupTags}, map[string]string{"owner": "team2"}))
+	// tags with a different value are not inherited
+	assert.Equal(t, map[string]*string{"owner": pointer.String("team2")}, removeInheritedTags(map[string]*string{"owner": pointer.String("team2")}, rgTags, []string{"owner"}, nil))
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// label on account key secrets created by the driver, only these secrets are refreshed by secret key sync
+	secretCreatedByLabel = "file.csi.azure.com/created-by"
+	// only the holder of this lease refreshes secrets
+	secretKeySyncLeaseName = "azurefile-csi-secret-key-sync"
+	// minimum interval between two syncs triggered by mount failure events
+	secretKeySyncMinInterval = time.Minute
+	// reason of the event emitted by kubelet when NodeStageVolume or NodePublishVolume fails
+	failedMountEventReason = "FailedMount"
+)
+
+// secretKeySyncKey identifies an account key secret and the storage account it's used for
+type secretKeySyncKey struct {
+	secretNamespace string
+	secretName      string
+	account         accountTagSyncKey
+	// user-assigned identity managing the storage account
+	clientID string
+}
+
+// runSecretKeySync refreshes account keys in secrets created by the driver periodically, and when kubelet reports a
+// mount failure with permission denied, so that static PVs keep working after account keys are regenerated
+func (d *Driver) runSecretKeySync(interval time.Duration) {
+	if d.cloud.KubeClient == nil {
+		klog.Warningf("KubeClient is nil, secret key sync is disabled")
+		return
+	}
+	identity := getLeaseHolderIdentity()
+	trigger := make(chan struct{}, 1)
+	// only the lease holder watches events, the watch is restarted every interval to stop it after the lease is lost
+	go wait.Forever(func() {
+		if !d.holdsLease(d.leaseNamespace, secretKeySyncLeaseName) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		d.watchMountPermissionDeniedEvents(ctx, trigger)
+	}, 10*time.Second)
+
+	klog.V(2).Infof("start secret key sync every %v, holder identity(%s)", interval, identity)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var lastSync time.Time
+		for {
+			select {
+			case <-ticker.C:
+			case <-trigger:
+				if time.Since(lastSync) < secretKeySyncMinInterval {
+					continue
+				}
+				klog.V(2).Infof("sync account keys in secrets since a mount failed with permission denied")
+			}
+			lastSync = time.Now()
+			d.syncSecretKeysIfLeader(identity, interval)
+		}
+	}()
+}
+
+// syncSecretKeysIfLeader syncs account keys in secrets if this replica holds the secret key sync lease
+func (d *Driver) syncSecretKeysIfLeader(identity string, interval time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), interval)
+	defer cancel()
+	leader, err := d.acquireLease(ctx, d.leaseNamespace, secretKeySyncLeaseName, identity, 2*interval)
+	if err != nil {
+		klog.Warningf("failed to acquire secret key sync lease: %v", err)
+		return
+	}
+	if !leader {
+		klog.V(4).Infof("skip secret key sync since lease(%s/%s) is held by another replica", d.leaseNamespace, secretKeySyncLeaseName)
+		return
+	}
+	if err := d.syncSecretKeys(ctx); err != nil {
+		klog.Warningf("syncSecretKeys failed with error: %v", err)
+	}
+}
+
+// watchMountPermissionDeniedEvents sends on trigger when a mount failure event with permission denied is watched,
+// it returns when the watch is closed or ctx is done
+func (d *Driver) watchMountPermissionDeniedEvents(ctx context.Context, trigger chan<- struct{}) {
+	w, err := d.cloud.KubeClient.CoreV1().Events("").Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("reason", failedMountEventReason).String(),
+	})
+	if err != nil {
+		klog.Warningf("failed to watch %s events: %v", failedMountEventReason, err)
+		return
+	}
+	defer w.Stop()
+	for {
+		var e watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok = <-w.ResultChan():
+			if !ok {
+				return
+			}
+		}
+		if e.Type != watch.Added && e.Type != watch.Modified {
+			continue
+		}
+		event, ok := e.Object.(*v1.Event)
+		if !ok || !isMountPermissionDeniedEvent(event) {
+			continue
+		}
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// isMountPermissionDeniedEvent returns true if the event reports a mount failure caused by rejected credentials
+func isMountPermissionDeniedEvent(event *v1.Event) bool {
+	return event.Reason == failedMountEventReason && isMountPermissionDeniedError(errors.New(event.Message))
+}
+
+// syncSecretKeys updates the account key in secrets created by the driver and referenced by PVs of this driver, if
+// the key is not a current key of the storage account, a failure on one secret would not block other secrets
+func (d *Driver) syncSecretKeys(ctx context.Context) error {
+	if d.cloud.KubeClient == nil {
+		return fmt.Errorf("KubeClient is nil")
+	}
+	pvs, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list persistent volumes: %v", err)
+	}
+
+	synced := make(map[secretKeySyncKey]bool)
+	var errs []error
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name {
+			continue
+		}
+		key, ok := d.getSecretKeySyncKey(pv)
+		if !ok || synced[key] {
+			continue
+		}
+		synced[key] = true
+		if err := d.syncSecretKey(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// getSecretKeySyncKey returns the account key secret of the PV, it's resolved in the same order as NodeStageVolume,
+// returns false if the PV does not use an account key secret
+func (d *Driver) getSecretKeySyncKey(pv *v1.PersistentVolume) (secretKeySyncKey, bool) {
+	resourceGroup, accountName, _, _, secretNamespace, subsID, err := GetFileShareInfo(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		klog.V(4).Infof("parsing volume handle of pv(%s) failed with error: %v", pv.Name, err)
+	}
+	var secretName, pvcNamespace, protocol, clientID string
+	if h, err := parseVolumeHandle(pv.Spec.CSI.VolumeHandle); err == nil {
+		clientID = h.clientID
+	}
+	for k, v := range pv.Spec.CSI.VolumeAttributes {
+		switch strings.ToLower(k) {
+		case subscriptionIDField:
+			subsID = v
+		case resourceGroupField:
+			resourceGroup = v
+		case storageAccountField:
+			accountName = v
+		case protocolField:
+			protocol = v
+		case secretNameField:
+			secretName = v
+		case secretNamespaceField:
+			secretNamespace = v
+		case pvcNamespaceKey:
+			pvcNamespace = v
+		case clientIDField:
+			clientID = v
+		}
+	}
+	if accountName == "" || isNFSProtocol(protocol) {
+		return secretKeySyncKey{}, false
+	}
+	if err := d.bindAccountToCloudConfig(getCloudConfigName(pv.Spec.CSI.VolumeHandle), accountName); err != nil {
+		klog.V(4).Infof("skip secret key sync on pv(%s): %v", pv.Name, err)
+		return secretKeySyncKey{}, false
+	}
+	cloud := d.getCloudBySubscription(withClientID(context.Background(), clientID), subsID, resourceGroup, accountName)
+	if resourceGroup == "" {
+		resourceGroup = cloud.ResourceGroup
+	}
+	if subsID == "" {
+		subsID = cloud.SubscriptionID
+	}
+
+	if ref := pv.Spec.CSI.NodeStageSecretRef; ref != nil {
+		secretNamespace, secretName = ref.Namespace, ref.Name
+	} else {
+		secretNamespace, _ = d.resolveSecretNamespace(secretNamespace, pvcNamespace)
+		if secretName == "" {
+			secretName = fmt.Sprintf(secretNameTemplate, accountName)
+		}
+	}
+	return secretKeySyncKey{
+		secretNamespace: secretNamespace,
+		secretName:      secretName,
+		account:         accountTagSyncKey{subsID: subsID, resourceGroup: resourceGroup, accountName: accountName},
+		clientID:        clientID,
+	}, true
+}
+
+// syncSecretKey updates the account key in the secret to the current key of the storage account, a secret which is
+// not created by the driver, or is for another storage account, is not touched
+func (d *Driver) syncSecretKey(ctx context.Context, key secretKeySyncKey) error {
+	secrets := d.cloud.KubeClient.CoreV1().Secrets(key.secretNamespace)
+	secret, err := secrets.Get(ctx, key.secretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get secret(%s/%s): %v", key.secretNamespace, key.secretName, err)
+	}
+	if secret.Labels[secretCreatedByLabel] != createdByDriver {
+		klog.V(2).Infof("skip secret key sync on secret(%s/%s) since it does not have label %s=%s, add the label to sync it", key.secretNamespace, key.secretName, secretCreatedByLabel, createdByDriver)
+		return nil
+	}
+	if accountName := string(secret.Data[defaultSecretAccountName]); accountName != key.account.accountName {
+		klog.V(4).Infof("skip secret key sync on secret(%s/%s) since it's for account(%s) instead of account(%s)", key.secretNamespace, key.secretName, accountName, key.account.accountName)
+		return nil
+	}
+
+	cloud := d.getCloudBySubscription(withClientID(ctx, key.clientID), key.account.subsID, key.account.resourceGroup, key.account.accountName)
+	if cloud.StorageAccountClient == nil {
+		return fmt.Errorf("StorageAccountClient is nil")
+	}
+	result, rerr := cloud.StorageAccountClient.ListKeys(ctx, key.account.subsID, key.account.resourceGroup, key.account.accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to list keys of account(%s) in resource group(%s): %v", key.account.accountName, key.account.resourceGroup, rerr.Error())
+	}
+	var accountKeys []string
+	if result.Keys != nil {
+		for _, k := range *result.Keys {
+			if k.Value != nil && *k.Value != "" {
+				accountKeys = append(accountKeys, *k.Value)
+			}
+		}
+	}
+	if len(accountKeys) == 0 {
+		return fmt.Errorf("no valid keys of account(%s) in resource group(%s)", key.account.accountName, key.account.resourceGroup)
+	}
+	currentKey := string(secret.Data[defaultSecretAccountKey])
+	for _, k := range accountKeys {
+		if k == currentKey {
+			return nil
+		}
+	}
+
+	secret.Data[defaultSecretAccountKey] = []byte(accountKeys[0])
+	if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update account key in secret(%s/%s): %v", key.secretNamespace, key.secretName, err)
+	}
+	klog.V(2).Infof("updated key of account(%s) in secret(%s/%s) since it's not a current key of the account", key.account.accountName, key.secretNamespace, key.secretName)
+	if err := d.accountCacheMap.Delete(key.account.accountName); err != nil {
+		klog.Warningf("failed to remove cached key of account(%s): %v", key.account.accountName, err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+func newSecretKeySyncTestSecret(name, accountName, accountKey string, owned bool) *v1.Secret {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Data: map[string][]byte{
+			defaultSecretAccountName: []byte(accountName),
+			defaultSecretAccountKey:  []byte(accountKey),
+		},
+	}
+	if owned {
+		secret.Labels = map[string]string{secretCreatedByLabel: createdByDriver}
+	}
+	return secret
+}
+
+func TestSyncSecretKeys(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.cloud.ResourceGroup = "rg"
+	// static PVs referring to the secret by node stage secret ref and by volume attributes
+	staticPV := newHealthMonitorTestPV("pv-static", "static-handle")
+	staticPV.Spec.CSI.VolumeAttributes = map[string]string{storageAccountField: "rotated", shareNameField: "share"}
+	staticPV.Spec.CSI.NodeStageSecretRef = &v1.SecretReference{Namespace: "default", Name: "rotated-secret"}
+	sameSecretPV := newHealthMonitorTestPV("pv-same-secret", "rg#rotated#share2#")
+	sameSecretPV.Spec.CSI.VolumeAttributes = map[string]string{secretNameField: "rotated-secret", secretNamespaceField: "default"}
+	unownedPV := newHealthMonitorTestPV("pv-unowned", "rg#unowned#share#default")
+	otherAccountPV := newHealthMonitorTestPV("pv-other-account", "rg#other#share#default")
+	otherAccountPV.Spec.CSI.VolumeAttributes = map[string]string{secretNameField: "rotated-secret"}
+	currentPV := newHealthMonitorTestPV("pv-current", "rg#current#share#default")
+	nfsPV := newHealthMonitorTestPV("pv-nfs", "rg#nfs#share#default")
+	nfsPV.Spec.CSI.VolumeAttributes = map[string]string{protocolField: nfs}
+
+	d.cloud.KubeClient = fake.NewSimpleClientset(
+		staticPV, sameSecretPV, unownedPV, otherAccountPV, currentPV, nfsPV,
+		newSecretKeySyncTestSecret("rotated-secret", "rotated", "oldkey", true),
+		newSecretKeySyncTestSecret("azure-storage-account-unowned-secret", "unowned", "oldkey", false),
+		newSecretKeySyncTestSecret("azure-storage-account-current-secret", "current", "key2", true),
+	)
+	d.accountCacheMap.Set("rotated", "oldkey")
+
+	keys := storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: pointer.String("key1")}, {Value: pointer.String("key2")}}}
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "rg", "rotated").Return(keys, nil).Times(1)
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), "rg", "current").Return(keys, nil).Times(1)
+
+	assert.NoError(t, d.syncSecretKeys(context.Background()))
+
+	getAccountKey := func(name string) string {
+		secret, err := d.cloud.KubeClient.CoreV1().Secrets("default").Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return string(secret.Data[defaultSecretAccountKey])
+	}
+	// stale key is replaced by the first key of the account, cached key is removed
+	assert.Equal(t, "key1", getAccountKey("rotated-secret"))
+	cache, err := d.accountCacheMap.Get("rotated", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Nil(t, cache)
+	// secret not created by the driver is not touched
+	assert.Equal(t, "oldkey", getAccountKey("azure-storage-account-unowned-secret"))
+	// secondary key is still valid
+	assert.Equal(t, "key2", getAccountKey("azure-storage-account-current-secret"))
+}
+
+func TestIsMountPermissionDeniedEvent(t *testing.T) {
+	assert.True(t, isMountPermissionDeniedEvent(&v1.Event{
+		Reason:  failedMountEventReason,
+		Message: "MountVolume.MountDevice failed for volume \"pv\" : rpc error: code = Internal desc = volume(rg#account#share#) mount //account.file.core.windows.net/share failed with mount failed: exit status 32\nOutput: mount error(13): Permission denied",
+	}))
+	assert.False(t, isMountPermissionDeniedEvent(&v1.Event{
+		Reason:  failedMountEventReason,
+		Message: "MountVolume.MountDevice failed for volume \"pv\" : rpc error: code = Internal desc = mount error(113): No route to host",
+	}))
+	assert.False(t, isMountPermissionDeniedEvent(&v1.Event{Reason: "FailedAttachVolume", Message: "Permission denied"}))
+}
+
+func TestWatchMountPermissionDeniedEvents(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud.KubeClient = fake.NewSimpleClientset()
+	trigger := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.watchMountPermissionDeniedEvents(ctx, trigger)
+		close(done)
+	}()
+
+	// the watch may not be started yet, so the event is created until the sync is triggered
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+		Reason:     failedMountEventReason,
+		Message:    "MountVolume.MountDevice failed for volume \"pv\" : mount error(13): Permission denied",
+	}
+	triggered := false
+	for i := 0; i < 50 && !triggered; i++ {
+		event.Name = fmt.Sprintf("event%d", i)
+		_, err := d.cloud.KubeClient.CoreV1().Events("default").Create(context.Background(), event, metav1.CreateOptions{})
+		assert.NoError(t, err)
+		select {
+		case <-trigger:
+			triggered = true
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	assert.True(t, triggered)
+
+	// the watch stops when ctx is done
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watch is not stopped")
+	}
+}
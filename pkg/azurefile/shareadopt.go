@@ -0,0 +1,144 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+)
+
+// runShareAdoption adopts existing file shares as managed by the driver in its share name namespace, so they are
+// handled like file shares created by CreateVolume and could not be reused or deleted by drivers in other namespaces.
+// shares is a comma separated list of volume handles, e.g. "rg#account#share". It is safe to run again with the
+// same parameters, file shares already adopted are skipped.
+func (d *Driver) runShareAdoption(ctx context.Context, shares string) error {
+	if d.shareNameNamespace == "" {
+		return fmt.Errorf("share-name-namespace must be set to adopt file shares")
+	}
+	volumeIDs := parseMigrationShares(shares)
+	if len(volumeIDs) == 0 {
+		return fmt.Errorf("no file share to adopt")
+	}
+
+	var errs []error
+	for _, volumeID := range volumeIDs {
+		if err := d.adoptFileShare(ctx, volumeID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to adopt file share(%s): %v", volumeID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to adopt %d out of %d file shares: %v", len(errs), len(volumeIDs), errs)
+	}
+	return nil
+}
+
+// adoptFileShare validates the file share and records the share name namespace of the driver in its metadata
+func (d *Driver) adoptFileShare(ctx context.Context, volumeID string) error {
+	resourceGroup, accountName, fileShareName, diskName, _, subsID, err := GetFileShareInfo(volumeID)
+	if err != nil {
+		return err
+	}
+	if accountName == "" || fileShareName == "" {
+		return fmt.Errorf("account name or file share name is empty in volume handle")
+	}
+	if diskName != "" {
+		return fmt.Errorf("vhd disk(%s) in file share could not be adopted", diskName)
+	}
+	if resourceGroup == "" {
+		resourceGroup = d.cloud.ResourceGroup
+	}
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+
+	fileShare, err := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName).FileClient.WithSubscriptionID(subsID).GetFileShare(ctx, resourceGroup, accountName, fileShareName, "")
+	if err != nil {
+		return fmt.Errorf("failed to get file share(%s) under account(%s) rg(%s): %v", fileShareName, accountName, resourceGroup, err)
+	}
+	if err := validateAdoptedFileShare(fileShare, d.shareNameNamespace); err != nil {
+		return err
+	}
+	if getMetadataValue(fileShare.Metadata, shareNameNamespaceMetadataKey) == d.shareNameNamespace {
+		klog.V(2).Infof("file share(%s) under account(%s) rg(%s) is already adopted in share name namespace(%s)", fileShareName, accountName, resourceGroup, d.shareNameNamespace)
+		return nil
+	}
+
+	shareURL, err := d.getShareURL(ctx, volumeID, nil)
+	if err != nil {
+		return err
+	}
+	// metadata is replaced as a whole, read it right before the update to keep metadata set by others
+	properties, err := shareURL.GetProperties(ctx)
+	if err != nil {
+		return err
+	}
+	metadata := properties.NewMetadata()
+	changed, err := adoptShareMetadata(metadata, d.shareNameNamespace)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if _, err := shareURL.SetMetadata(ctx, metadata); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("file share(%s) under account(%s) rg(%s) is adopted in share name namespace(%s), it could be used by a static PV with volumeHandle %s",
+		fileShareName, accountName, resourceGroup, d.shareNameNamespace, volumeID)
+	return nil
+}
+
+// validateAdoptedFileShare returns an error if the file share could not be managed by the driver in share name namespace
+func validateAdoptedFileShare(fileShare storage.FileShare, namespace string) error {
+	if fileShare.FileShareProperties == nil {
+		return fmt.Errorf("file share has no properties")
+	}
+	if pointer.BoolDeref(fileShare.Deleted, false) {
+		return fmt.Errorf("file share is soft deleted")
+	}
+	if pointer.Int32Deref(fileShare.ShareQuota, 0) <= 0 {
+		return fmt.Errorf("file share has no quota")
+	}
+	if getMetadataValue(fileShare.Metadata, deletedByCSIMetadataKey) != "" {
+		return fmt.Errorf("file share is archived by onDeleteRename")
+	}
+	if owner := getMetadataValue(fileShare.Metadata, shareNameNamespaceMetadataKey); owner != "" && owner != namespace {
+		return fmt.Errorf("file share belongs to share name namespace(%s), it could not be adopted in share name namespace(%s)", owner, namespace)
+	}
+	return nil
+}
+
+// adoptShareMetadata sets the share name namespace in metadata, returns whether metadata is changed, it's checked
+// again on the latest metadata since the file share could be archived or adopted by another driver in the meantime
+func adoptShareMetadata(metadata azfile.Metadata, namespace string) (bool, error) {
+	if _, ok := metadata[deletedByCSIMetadataKey]; ok {
+		return false, fmt.Errorf("file share is archived by onDeleteRename")
+	}
+	switch owner := metadata[shareNameNamespaceMetadataKey]; owner {
+	case namespace:
+		return false, nil
+	case "":
+		metadata[shareNameNamespaceMetadataKey] = namespace
+		return true, nil
+	default:
+		return false, fmt.Errorf("file share belongs to share name namespace(%s), it could not be adopted in share name namespace(%s)", owner, namespace)
+	}
+}
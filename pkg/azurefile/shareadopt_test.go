@@ -0,0 +1,134 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+)
+
+func TestRunShareAdoptionInvalidParameters(t *testing.T) {
+	d := NewFakeDriver()
+	assert.Equal(t, fmt.Errorf("share-name-namespace must be set to adopt file shares"), d.runShareAdoption(context.Background(), "rg#account#share"))
+
+	d.shareNameNamespace = "cluster1"
+	assert.Equal(t, fmt.Errorf("no file share to adopt"), d.runShareAdoption(context.Background(), " , "))
+	assert.Equal(t, fmt.Errorf("failed to adopt 2 out of 2 file shares: [failed to adopt file share(rg#account): error parsing volume id: \"rg#account\", should at least contain two # failed to adopt file share(rg#account#share#disk.vhd): vhd disk(disk.vhd) in file share could not be adopted]"),
+		d.runShareAdoption(context.Background(), "rg#account,rg#account#share#disk.vhd"))
+}
+
+func TestValidateAdoptedFileShare(t *testing.T) {
+	tests := []struct {
+		desc        string
+		fileShare   storage.FileShare
+		expectedErr error
+	}{
+		{
+			desc:        "no properties",
+			expectedErr: fmt.Errorf("file share has no properties"),
+		},
+		{
+			desc:        "soft deleted",
+			fileShare:   storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), Deleted: pointer.Bool(true)}},
+			expectedErr: fmt.Errorf("file share is soft deleted"),
+		},
+		{
+			desc:        "no quota",
+			fileShare:   storage.FileShare{FileShareProperties: &storage.FileShareProperties{}},
+			expectedErr: fmt.Errorf("file share has no quota"),
+		},
+		{
+			desc:        "archived",
+			fileShare:   storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), Metadata: map[string]*string{deletedByCSIMetadataKey: pointer.String("2022-01-01T00:00:00Z")}}},
+			expectedErr: fmt.Errorf("file share is archived by onDeleteRename"),
+		},
+		{
+			desc:        "owned by another namespace",
+			fileShare:   storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), Metadata: map[string]*string{shareNameNamespaceMetadataKey: pointer.String("cluster2")}}},
+			expectedErr: fmt.Errorf("file share belongs to share name namespace(cluster2), it could not be adopted in share name namespace(cluster1)"),
+		},
+		{
+			desc:      "already adopted",
+			fileShare: storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), Metadata: map[string]*string{shareNameNamespaceMetadataKey: pointer.String("cluster1")}}},
+		},
+		{
+			desc:      "unmanaged",
+			fileShare: storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100)}},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectedErr, validateAdoptedFileShare(test.fileShare, "cluster1"), test.desc)
+	}
+}
+
+func TestAdoptShareMetadata(t *testing.T) {
+	metadata := azfile.Metadata{"team": "a"}
+	changed, err := adoptShareMetadata(metadata, "cluster1")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, azfile.Metadata{"team": "a", shareNameNamespaceMetadataKey: "cluster1"}, metadata)
+
+	// idempotent
+	changed, err = adoptShareMetadata(metadata, "cluster1")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = adoptShareMetadata(metadata, "cluster2")
+	assert.Equal(t, fmt.Errorf("file share belongs to share name namespace(cluster1), it could not be adopted in share name namespace(cluster2)"), err)
+
+	_, err = adoptShareMetadata(azfile.Metadata{deletedByCSIMetadataKey: "2022-01-01T00:00:00Z"}, "cluster1")
+	assert.Equal(t, fmt.Errorf("file share is archived by onDeleteRename"), err)
+}
+
+func TestAdoptFileShare(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.shareNameNamespace = "cluster1"
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID("subscriptionID").Return(mockFileClient).AnyTimes()
+
+	// file share already adopted is skipped
+	adopted := storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), Metadata: map[string]*string{shareNameNamespaceMetadataKey: pointer.String("cluster1")}}}
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "").Return(adopted, nil)
+	assert.NoError(t, d.adoptFileShare(context.Background(), "rg#account#share"))
+
+	// resource group of cloud config is used if not specified
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), d.cloud.ResourceGroup, "account", "share", "").Return(adopted, nil)
+	assert.NoError(t, d.adoptFileShare(context.Background(), "#account#share"))
+
+	// file share owned by another namespace
+	owned := storage.FileShare{FileShareProperties: &storage.FileShareProperties{ShareQuota: pointer.Int32(100), Metadata: map[string]*string{shareNameNamespaceMetadataKey: pointer.String("cluster2")}}}
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", "").Return(owned, nil)
+	assert.Equal(t, fmt.Errorf("file share belongs to share name namespace(cluster2), it could not be adopted in share name namespace(cluster1)"), d.adoptFileShare(context.Background(), "rg#account#share"))
+
+	// missing file share
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "missing", "").Return(storage.FileShare{}, fmt.Errorf(fileShareNotFound))
+	assert.Equal(t, fmt.Errorf("failed to get file share(missing) under account(account) rg(rg): %s", fileShareNotFound), d.adoptFileShare(context.Background(), "rg#account#missing"))
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	ratelimitconfig "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
+)
+
+const (
+	defaultCreateDirectoryTimeout = time.Minute
+
+	// file data plane API accepts OAuth token since this version, only on file and directory level operations
+	fileOAuthServiceVersion = "2022-11-02"
+	// requests with OAuth token must declare backup intent, access is granted by data actions of the identity's role
+	fileRequestIntentBackup = "backup"
+	// built-in role granting the data actions required by directory creation with OAuth token
+	fileDataPrivilegedContributorRole = "Storage File Data Privileged Contributor"
+)
+
+var (
+	// Steps is replaced by maximum retries of the driver
+	createDirectoryBackoff = wait.Backoff{Duration: time.Second, Factor: 2.0, Jitter: 0.1, Cap: 10 * time.Second}
+)
+
+// createShareDirectory creates dirPath and its parent directories in the file share by data plane API, existing
+// directories are accepted, so it's safe to run again on retried requests. Each directory creation is retried with
+// backoff on transient failures, the whole creation is bounded by createDirectoryTimeout and the deadline of ctx.
+// Directories are created with OAuth token of the identity managing the account if accountKey is empty
+func (d *Driver) createShareDirectory(ctx context.Context, subsID, resourceGroup, accountName, accountKey, shareName, dirPath string) (err error) {
+	ctx, span := startSpan(ctx, "createShareDirectory", accountNameAttribute.String(accountName), shareNameAttribute.String(shareName))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, d.createDirectoryTimeout)
+	defer cancel()
+
+	backoff := createDirectoryBackoff
+	backoff.Steps = d.createDirectoryMaxRetries + 1
+	var dir string
+	for _, segment := range strings.Split(dirPath, "/") {
+		if segment == "" {
+			continue
+		}
+		dir = path.Join(dir, segment)
+		var lastErr error
+		err = wait.ExponentialBackoffWithContext(ctx, backoff, func() (bool, error) {
+			lastErr = d.createDirectory(ctx, subsID, resourceGroup, accountName, accountKey, shareName, dir)
+			if lastErr == nil || isStorageErrorCode(lastErr, azfile.ServiceCodeResourceAlreadyExists) {
+				return true, nil
+			}
+			if !isTransientStorageError(lastErr) {
+				return false, lastErr
+			}
+			klog.Warningf("failed to create directory(%s) in file share(%s) on account(%s), retrying: %v", dir, shareName, accountName, lastErr)
+			return false, nil
+		})
+		if err != nil {
+			if lastErr == nil || isContextError(err) {
+				lastErr = err
+			}
+			return fmt.Errorf("failed to create directory(%s) in file share(%s) on account(%s): %w", dir, shareName, accountName, lastErr)
+		}
+	}
+	return nil
+}
+
+// createDirectoryByDataPlane creates one directory in the file share, parent directory must exist
+func (d *Driver) createDirectoryByDataPlane(ctx context.Context, subsID, resourceGroup, accountName, accountKey, shareName, dirPath string) error {
+	var serviceURL azfile.ServiceURL
+	var err error
+	if accountKey == "" {
+		serviceURL, err = d.getAccountServiceURLByOAuth(ctx, subsID, resourceGroup, accountName)
+	} else {
+		serviceURL, _, err = d.getAccountServiceURL(accountName, accountKey)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = serviceURL.NewShareURL(shareName).NewDirectoryURL(dirPath).Create(ctx, azfile.Metadata{}, azfile.SMBProperties{})
+	return err
+}
+
+// getAccountServiceURLByOAuth returns file service URL of the account whose requests are authorized by OAuth token of
+// the identity managing the account, i.e. the cloud config in its subscription or the user-assigned identity of the
+// volume in ctx, account key is never retrieved
+func (d *Driver) getAccountServiceURLByOAuth(ctx context.Context, subsID, resourceGroup, accountName string) (azfile.ServiceURL, error) {
+	cloud := d.getCloudBySubscription(ctx, subsID, resourceGroup, accountName)
+	token, err := d.getStorageToken(cloud)
+	if err != nil {
+		return azfile.ServiceURL{}, err
+	}
+	u, err := url.Parse(fmt.Sprintf(serviceURLTemplate, accountName, cloud.Environment.StorageEndpointSuffix))
+	if err != nil {
+		return azfile.ServiceURL{}, fmt.Errorf("parse serviceURLTemplate error: %v", err)
+	}
+	getToken := func(ctx context.Context) (string, error) {
+		if err := token.EnsureFreshWithContext(ctx); err != nil {
+			return "", err
+		}
+		return token.OAuthToken(), nil
+	}
+	return azfile.NewServiceURL(*u, newOAuthPipeline(getToken)), nil
+}
+
+// getStorageToken returns the OAuth token of storage resource of the cloud identity, the token is cached per cloud and
+// refreshed by the pipeline before it expires
+func (d *Driver) getStorageToken(cloud *azure.Cloud) (*adal.ServicePrincipalToken, error) {
+	if v, ok := d.storageTokenMap.Load(cloud); ok {
+		return v.(*adal.ServicePrincipalToken), nil
+	}
+	token, err := ratelimitconfig.GetServicePrincipalToken(&cloud.AzureAuthConfig, &cloud.Environment, cloud.Environment.ResourceIdentifiers.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service principal token of storage: %v", err)
+	}
+	v, _ := d.storageTokenMap.LoadOrStore(cloud, token)
+	return v.(*adal.ServicePrincipalToken), nil
+}
+
+// newOAuthPipeline returns the pipeline of azfile.NewPipeline with the credential replaced by OAuth token policy,
+// azfile only supports shared key and anonymous credentials
+func newOAuthPipeline(getToken func(ctx context.Context) (string, error)) pipeline.Pipeline {
+	return pipeline.NewPipeline([]pipeline.Factory{
+		azfile.NewTelemetryPolicyFactory(azfile.TelemetryOptions{}),
+		azfile.NewUniqueRequestIDPolicyFactory(),
+		azfile.NewRetryPolicyFactory(azfile.RetryOptions{}),
+		newOAuthPolicyFactory(getToken),
+		azfile.NewRequestLogPolicyFactory(azfile.RequestLogOptions{}),
+		pipeline.MethodFactoryMarker(),
+	}, pipeline.Options{})
+}
+
+// newOAuthPolicyFactory authorizes requests by bearer token, the service version set by azfile is raised to the
+// version accepting OAuth token
+func newOAuthPolicyFactory(getToken func(ctx context.Context) (string, error)) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			token, err := getToken(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to refresh OAuth token: %v", err)
+			}
+			request.Header.Set("Authorization", "Bearer "+token)
+			request.Header.Set("x-ms-version", fileOAuthServiceVersion)
+			request.Header.Set("x-ms-file-request-intent", fileRequestIntentBackup)
+			return next.Do(ctx, request)
+		}
+	})
+}
+
+// isStorageErrorStatus returns true if err is a file data plane error with HTTP status code
+func isStorageErrorStatus(err error, statusCode int) bool {
+	var storageErr azfile.StorageError
+	return errors.As(err, &storageErr) && storageErr.Response() != nil && storageErr.Response().StatusCode == statusCode
+}
+
+// isTransientStorageError returns true if the data plane request could succeed on retry,
+// errors without a response, e.g. connection reset, are treated as transient
+func isTransientStorageError(err error) bool {
+	var storageErr azfile.StorageError
+	if !errors.As(err, &storageErr) || storageErr.Response() == nil {
+		return true
+	}
+	statusCode := storageErr.Response().StatusCode
+	return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
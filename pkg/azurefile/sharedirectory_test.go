@@ -0,0 +1,243 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// fakeStorageError implements azfile.StorageError
+type fakeStorageError struct {
+	statusCode  int
+	serviceCode azfile.ServiceCodeType
+}
+
+func (e *fakeStorageError) Error() string {
+	return fmt.Sprintf("StatusCode=%d, ErrorCode=%s", e.statusCode, e.serviceCode)
+}
+func (e *fakeStorageError) Timeout() bool                       { return false }
+func (e *fakeStorageError) Temporary() bool                     { return false }
+func (e *fakeStorageError) Response() *http.Response            { return &http.Response{StatusCode: e.statusCode} }
+func (e *fakeStorageError) ServiceCode() azfile.ServiceCodeType { return e.serviceCode }
+
+// fakeShareDirectories records directories created in a file share, creation fails on the first failures calls
+type fakeShareDirectories struct {
+	created  []string
+	calls    int
+	failures []error
+}
+
+func (f *fakeShareDirectories) createDirectory(ctx context.Context, subsID, resourceGroup, accountName, accountKey, shareName, dirPath string) error {
+	f.calls++
+	if len(f.failures) > 0 {
+		err := f.failures[0]
+		f.failures = f.failures[1:]
+		return err
+	}
+	for _, dir := range f.created {
+		if dir == dirPath {
+			return &fakeStorageError{statusCode: http.StatusConflict, serviceCode: azfile.ServiceCodeResourceAlreadyExists}
+		}
+	}
+	f.created = append(f.created, dirPath)
+	return nil
+}
+
+func TestCreateShareDirectory(t *testing.T) {
+	origBackoff := createDirectoryBackoff
+	defer func() { createDirectoryBackoff = origBackoff }()
+	createDirectoryBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1.0}
+
+	transientErr := &fakeStorageError{statusCode: http.StatusServiceUnavailable, serviceCode: azfile.ServiceCodeServerBusy}
+	tests := []struct {
+		desc            string
+		dirPath         string
+		existing        []string
+		failures        []error
+		maxRetries      int
+		expectedCreated []string
+		expectedCalls   int
+		expectedErr     string
+	}{
+		{
+			desc:            "nested directory is created with parents",
+			dirPath:         "/a/b/",
+			maxRetries:      2,
+			expectedCreated: []string{"a", "a/b"},
+			expectedCalls:   2,
+		},
+		{
+			desc:            "transient failure followed by success",
+			dirPath:         "a/b",
+			failures:        []error{transientErr, fmt.Errorf("connection reset by peer")},
+			maxRetries:      2,
+			expectedCreated: []string{"a", "a/b"},
+			expectedCalls:   4,
+		},
+		{
+			desc:            "existing directory is accepted",
+			dirPath:         "a/b",
+			existing:        []string{"a", "a/b"},
+			maxRetries:      2,
+			expectedCreated: []string{"a", "a/b"},
+			expectedCalls:   2,
+		},
+		{
+			desc:            "retries are exhausted",
+			dirPath:         "a",
+			failures:        []error{transientErr, transientErr, transientErr},
+			maxRetries:      2,
+			expectedCreated: nil,
+			expectedCalls:   3,
+			expectedErr:     "failed to create directory(a) in file share(share) on account(account): StatusCode=503, ErrorCode=ServerBusy",
+		},
+		{
+			desc:            "non-transient failure is not retried",
+			dirPath:         "a",
+			failures:        []error{&fakeStorageError{statusCode: http.StatusForbidden, serviceCode: azfile.ServiceCodeAuthenticationFailed}},
+			maxRetries:      2,
+			expectedCreated: nil,
+			expectedCalls:   1,
+			expectedErr:     "failed to create directory(a) in file share(share) on account(account): StatusCode=403, ErrorCode=AuthenticationFailed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			fake := &fakeShareDirectories{created: test.existing, failures: test.failures}
+			d.createDirectory = fake.createDirectory
+			d.createDirectoryMaxRetries = test.maxRetries
+
+			err := d.createShareDirectory(context.Background(), "subs", "rg", "account", "key", "share", test.dirPath)
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectedErr)
+			}
+			assert.Equal(t, test.expectedCreated, fake.created)
+			assert.Equal(t, test.expectedCalls, fake.calls)
+		})
+	}
+}
+
+func TestCreateShareDirectoryContextDeadline(t *testing.T) {
+	origBackoff := createDirectoryBackoff
+	defer func() { createDirectoryBackoff = origBackoff }()
+	createDirectoryBackoff = wait.Backoff{Duration: time.Hour, Factor: 1.0}
+
+	d := NewFakeDriver()
+	fake := &fakeShareDirectories{failures: []error{fmt.Errorf("connection reset by peer")}}
+	d.createDirectory = fake.createDirectory
+	d.createDirectoryMaxRetries = 5
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := d.createShareDirectory(ctx, "subs", "rg", "account", "key", "share", "a")
+	assert.True(t, isContextError(err))
+	assert.Equal(t, 1, fake.calls)
+}
+
+func TestNewOAuthPipeline(t *testing.T) {
+	tests := []struct {
+		desc              string
+		statusCode        int
+		tokenErr          error
+		expectedForbidden bool
+		expectedErr       bool
+	}{
+		{
+			desc:       "directory is created with bearer token",
+			statusCode: http.StatusCreated,
+		},
+		{
+			desc:              "identity without data action is forbidden",
+			statusCode:        http.StatusForbidden,
+			expectedForbidden: true,
+			expectedErr:       true,
+		},
+		{
+			desc:        "token refresh failure",
+			tokenErr:    fmt.Errorf("token refresh failed"),
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var header http.Header
+			var path string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				path = r.URL.Path
+				w.WriteHeader(test.statusCode)
+			}))
+			defer server.Close()
+
+			getToken := func(ctx context.Context) (string, error) {
+				return "token", test.tokenErr
+			}
+			u, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+			serviceURL := azfile.NewServiceURL(*u, newOAuthPipeline(getToken))
+			_, err = serviceURL.NewShareURL("share").NewDirectoryURL("a/b").Create(context.Background(), azfile.Metadata{}, azfile.SMBProperties{})
+			assert.Equal(t, test.expectedErr, err != nil)
+			assert.Equal(t, test.expectedForbidden, isStorageErrorStatus(err, http.StatusForbidden))
+			if test.tokenErr != nil {
+				assert.Nil(t, header)
+				return
+			}
+			assert.Equal(t, "/share/a/b", path)
+			assert.Equal(t, "Bearer token", header.Get("Authorization"))
+			assert.Equal(t, fileOAuthServiceVersion, header.Get("x-ms-version"))
+			assert.Equal(t, fileRequestIntentBackup, header.Get("x-ms-file-request-intent"))
+		})
+	}
+}
+
+func TestGetStorageToken(t *testing.T) {
+	d := NewFakeDriver()
+	cloud, err := d.getCloudByClientID("clientID")
+	assert.NoError(t, err)
+
+	token, err := d.getStorageToken(cloud)
+	assert.NoError(t, err)
+	cached, err := d.getStorageToken(cloud)
+	assert.NoError(t, err)
+	assert.Same(t, token, cached)
+
+	// token of the user-assigned identity of the volume is used
+	ctx := withClientID(context.Background(), "clientID")
+	_, err = d.getAccountServiceURLByOAuth(ctx, "", "rg", "account")
+	assert.NoError(t, err)
+	tokens := 0
+	d.storageTokenMap.Range(func(_, _ interface{}) bool {
+		tokens++
+		return true
+	})
+	assert.Equal(t, 1, tokens)
+}
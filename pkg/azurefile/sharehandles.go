@@ -0,0 +1,206 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maximum number of open handles listed in the error of DeleteVolume
+	maxReportedOpenHandles = 5
+	// close all handles of the share
+	allHandlesID = "*"
+)
+
+// shareHandleClient lists and force closes open handles of all files and directories in a file share, every call
+// returns one page and the marker of the next page, which is empty on the last page
+type shareHandleClient interface {
+	listHandles(ctx context.Context, marker string) ([]azfile.HandleItem, string, error)
+	forceCloseHandles(ctx context.Context, marker string) (int, string, error)
+}
+
+// restShareHandleClient calls handle APIs of the root directory of a file share, azfile does not export them
+type restShareHandleClient struct {
+	url url.URL
+	p   pipeline.Pipeline
+}
+
+func (c *restShareHandleClient) listHandles(ctx context.Context, marker string) ([]azfile.HandleItem, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "listhandles", marker, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	result := azfile.ListHandlesResponse{}
+	if err := xml.Unmarshal(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), &result); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal list handles response: %v", err)
+	}
+	return result.HandleList, result.NextMarker, nil
+}
+
+func (c *restShareHandleClient) forceCloseHandles(ctx context.Context, marker string) (int, string, error) {
+	resp, err := c.do(ctx, http.MethodPut, "forceclosehandles", marker, map[string]string{"x-ms-handle-id": allHandlesID})
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	closed, _ := strconv.Atoi(resp.Header.Get("x-ms-number-of-handles-closed"))
+	return closed, resp.Header.Get("x-ms-marker"), nil
+}
+
+func (c *restShareHandleClient) do(ctx context.Context, method, comp, marker string, headers map[string]string) (*http.Response, error) {
+	req, err := pipeline.NewRequest(method, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	params := req.URL.Query()
+	params.Set("comp", comp)
+	if marker != "" {
+		params.Set("marker", marker)
+	}
+	req.URL.RawQuery = params.Encode()
+	req.Header.Set("x-ms-recursive", "true")
+	req.Header.Set("x-ms-version", azfile.ServiceVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.p.Do(ctx, nil, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Response().StatusCode != http.StatusOK {
+		defer resp.Response().Body.Close()
+		body, _ := ioutil.ReadAll(resp.Response().Body)
+		return nil, fmt.Errorf("%s on %s failed with %s: %s", comp, c.url.Path, resp.Response().Status, string(body))
+	}
+	return resp.Response(), nil
+}
+
+// getShareHandleClient returns the handle client of the file share of volumeID with the account key
+func (d *Driver) getShareHandleClient(ctx context.Context, volumeID string, secrets map[string]string) (shareHandleClient, error) {
+	u, p, fileShareName, err := d.getServicePipeline(ctx, volumeID, secrets)
+	if err != nil {
+		return nil, err
+	}
+	if fileShareName == "" {
+		return nil, fmt.Errorf("failed to get file share from %s", volumeID)
+	}
+	return &restShareHandleClient{url: azfile.NewServiceURL(u, p).NewShareURL(fileShareName).URL(), p: p}, nil
+}
+
+// listOpenHandles enumerates all pages of open handles, it returns the number of open handles and the first
+// maxReportedOpenHandles of them
+func listOpenHandles(ctx context.Context, client shareHandleClient) (int, []azfile.HandleItem, error) {
+	var count int
+	var reported []azfile.HandleItem
+	marker := ""
+	for {
+		handles, nextMarker, err := client.listHandles(ctx, marker)
+		if err != nil {
+			return count, reported, err
+		}
+		count += len(handles)
+		for i := 0; i < len(handles) && len(reported) < maxReportedOpenHandles; i++ {
+			reported = append(reported, handles[i])
+		}
+		if nextMarker == "" {
+			return count, reported, nil
+		}
+		marker = nextMarker
+	}
+}
+
+// closeOpenHandles force closes open handles page by page and returns the number of closed handles, it's idempotent
+// since closing handles of a share without open handles succeeds
+func closeOpenHandles(ctx context.Context, client shareHandleClient) (int, error) {
+	var closed int
+	marker := ""
+	for {
+		n, nextMarker, err := client.forceCloseHandles(ctx, marker)
+		if err != nil {
+			return closed, err
+		}
+		closed += n
+		if nextMarker == "" {
+			return closed, nil
+		}
+		marker = nextMarker
+	}
+}
+
+// checkOpenHandlesOnDelete closes open handles of the file share before deletion if forceClose is true, otherwise
+// it returns FailedPrecondition error if there is any open handle
+func (d *Driver) checkOpenHandlesOnDelete(ctx context.Context, volumeID, resourceGroupName, accountName, fileShareName string, secrets map[string]string, forceClose bool) error {
+	client, err := d.getShareHandleClient(ctx, volumeID, secrets)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get handle client of file share(%s) under account(%s) rg(%s): %v", fileShareName, accountName, resourceGroupName, err)
+	}
+	return checkOpenHandles(ctx, client, resourceGroupName, accountName, fileShareName, forceClose)
+}
+
+func checkOpenHandles(ctx context.Context, client shareHandleClient, resourceGroupName, accountName, fileShareName string, forceClose bool) error {
+	if forceClose {
+		closed, err := closeOpenHandles(ctx, client)
+		if err != nil && !isShareNotFoundError(err) {
+			return status.Errorf(codes.Internal, "failed to close open handles of file share(%s) under account(%s) rg(%s) after closing %d handles: %v", fileShareName, accountName, resourceGroupName, closed, err)
+		}
+		klog.V(2).Infof("closed %d open handles of file share(%s) under account(%s) rg(%s) before deletion", closed, fileShareName, accountName, resourceGroupName)
+		return nil
+	}
+
+	count, handles, err := listOpenHandles(ctx, client)
+	if err != nil {
+		if isShareNotFoundError(err) {
+			return nil
+		}
+		return status.Errorf(codes.Internal, "failed to list open handles of file share(%s) under account(%s) rg(%s): %v", fileShareName, accountName, resourceGroupName, err)
+	}
+	if count > 0 {
+		var reported []string
+		for _, h := range handles {
+			reported = append(reported, fmt.Sprintf("path(%s) client(%s)", h.Path, h.ClientIP))
+		}
+		return status.Errorf(codes.FailedPrecondition, "file share(%s) under account(%s) rg(%s) has %d open handles, e.g. %s, unmount it from all clients before deletion",
+			fileShareName, accountName, resourceGroupName, count, strings.Join(reported, ", "))
+	}
+	return nil
+}
+
+func isShareNotFoundError(err error) bool {
+	return strings.Contains(err.Error(), "ShareNotFound")
+}
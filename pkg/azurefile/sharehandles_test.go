@@ -0,0 +1,203 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeShareHandleClient returns handles in pages, closed handles are removed from following pages
+type fakeShareHandleClient struct {
+	pages      [][]azfile.HandleItem
+	closed     bool
+	closeCalls int
+	err        error
+}
+
+func (c *fakeShareHandleClient) listHandles(_ context.Context, marker string) ([]azfile.HandleItem, string, error) {
+	if c.err != nil {
+		return nil, "", c.err
+	}
+	if c.closed || len(c.pages) == 0 {
+		return nil, "", nil
+	}
+	page := 0
+	if marker != "" {
+		fmt.Sscanf(marker, "page-%d", &page)
+	}
+	nextMarker := ""
+	if page+1 < len(c.pages) {
+		nextMarker = fmt.Sprintf("page-%d", page+1)
+	}
+	return c.pages[page], nextMarker, nil
+}
+
+func (c *fakeShareHandleClient) forceCloseHandles(_ context.Context, marker string) (int, string, error) {
+	c.closeCalls++
+	if c.err != nil {
+		return 0, "", c.err
+	}
+	if c.closed || len(c.pages) == 0 {
+		return 0, "", nil
+	}
+	page := 0
+	if marker != "" {
+		fmt.Sscanf(marker, "page-%d", &page)
+	}
+	if page+1 < len(c.pages) {
+		return len(c.pages[page]), fmt.Sprintf("page-%d", page+1), nil
+	}
+	c.closed = true
+	return len(c.pages[page]), "", nil
+}
+
+func newHandles(n int, prefix string) []azfile.HandleItem {
+	var handles []azfile.HandleItem
+	for i := 0; i < n; i++ {
+		handles = append(handles, azfile.HandleItem{Path: fmt.Sprintf("%s/file%d", prefix, i), ClientIP: "10.0.0.4:445"})
+	}
+	return handles
+}
+
+func TestListOpenHandles(t *testing.T) {
+	client := &fakeShareHandleClient{pages: [][]azfile.HandleItem{newHandles(3, "a"), newHandles(4, "b")}}
+	count, handles, err := listOpenHandles(context.Background(), client)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, count)
+	assert.Len(t, handles, maxReportedOpenHandles)
+	assert.Equal(t, "b/file1", handles[4].Path)
+
+	count, handles, err = listOpenHandles(context.Background(), &fakeShareHandleClient{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Empty(t, handles)
+}
+
+func TestCloseOpenHandles(t *testing.T) {
+	client := &fakeShareHandleClient{pages: [][]azfile.HandleItem{newHandles(3, "a"), newHandles(4, "b")}}
+	closed, err := closeOpenHandles(context.Background(), client)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, closed)
+	assert.Equal(t, 2, client.closeCalls)
+
+	// closing handles again succeeds without any open handle
+	closed, err = closeOpenHandles(context.Background(), client)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, closed)
+}
+
+func TestCheckOpenHandles(t *testing.T) {
+	tests := []struct {
+		desc        string
+		client      *fakeShareHandleClient
+		forceClose  bool
+		expectedErr error
+	}{
+		{
+			desc:   "no open handles",
+			client: &fakeShareHandleClient{},
+		},
+		{
+			desc:        "open handles fail deletion",
+			client:      &fakeShareHandleClient{pages: [][]azfile.HandleItem{newHandles(1, "a"), newHandles(1, "b")}},
+			expectedErr: status.Error(codes.FailedPrecondition, "file share(share) under account(account) rg(rg) has 2 open handles, e.g. path(a/file0) client(10.0.0.4:445), path(b/file0) client(10.0.0.4:445), unmount it from all clients before deletion"),
+		},
+		{
+			desc:       "open handles are closed",
+			client:     &fakeShareHandleClient{pages: [][]azfile.HandleItem{newHandles(2, "a")}},
+			forceClose: true,
+		},
+		{
+			desc:   "file share not found",
+			client: &fakeShareHandleClient{err: fmt.Errorf("listhandles on /share failed with 404 The specified share does not exist: <Code>ShareNotFound</Code>")},
+		},
+		{
+			desc:        "list handles error",
+			client:      &fakeShareHandleClient{err: fmt.Errorf("test error")},
+			expectedErr: status.Error(codes.Internal, "failed to list open handles of file share(share) under account(account) rg(rg): test error"),
+		},
+		{
+			desc:        "close handles error",
+			client:      &fakeShareHandleClient{err: fmt.Errorf("test error")},
+			forceClose:  true,
+			expectedErr: status.Error(codes.Internal, "failed to close open handles of file share(share) under account(account) rg(rg) after closing 0 handles: test error"),
+		},
+	}
+
+	for _, test := range tests {
+		err := checkOpenHandles(context.Background(), test.client, "rg", "account", "share", test.forceClose)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		if test.forceClose && test.expectedErr == nil {
+			count, _, err := listOpenHandles(context.Background(), test.client)
+			assert.NoError(t, err, test.desc)
+			assert.Equal(t, 0, count, test.desc)
+		}
+	}
+}
+
+func TestRestShareHandleClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/share", r.URL.Path)
+		assert.Equal(t, "true", r.Header.Get("x-ms-recursive"))
+		switch r.URL.Query().Get("comp") {
+		case "listhandles":
+			assert.Equal(t, http.MethodGet, r.Method)
+			if r.URL.Query().Get("marker") == "" {
+				fmt.Fprint(w, "\xef\xbb\xbf<?xml version=\"1.0\" encoding=\"utf-8\"?><EnumerationResults><Entries><Handle><HandleId>1</HandleId><Path>dir/file</Path><FileId>0</FileId><SessionId>0</SessionId><ClientIp>10.0.0.4:445</ClientIp><OpenTime>Mon, 01 Aug 2022 00:00:00 GMT</OpenTime></Handle></Entries><NextMarker>next</NextMarker></EnumerationResults>")
+				return
+			}
+			fmt.Fprint(w, "<EnumerationResults><Entries></Entries><NextMarker /></EnumerationResults>")
+		case "forceclosehandles":
+			assert.Equal(t, http.MethodPut, r.Method)
+			assert.Equal(t, allHandlesID, r.Header.Get("x-ms-handle-id"))
+			w.Header().Set("x-ms-number-of-handles-closed", "2")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "ShareNotFound")
+		}
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL + "/share")
+	assert.NoError(t, err)
+	client := &restShareHandleClient{url: *u, p: azfile.NewPipeline(azfile.NewAnonymousCredential(), azfile.PipelineOptions{})}
+
+	handles, nextMarker, err := client.listHandles(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, "next", nextMarker)
+	assert.Len(t, handles, 1)
+	assert.Equal(t, "dir/file", handles[0].Path)
+	assert.Equal(t, "10.0.0.4:445", handles[0].ClientIP)
+
+	count, _, err := listOpenHandles(context.Background(), client)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	closed, err := closeOpenHandles(context.Background(), client)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, closed)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	ratelimitconfig "sigs.k8s.io/cloud-provider-azure/pkg/provider/config"
+)
+
+const (
+	deletedExpand = "deleted"
+	// suffix appended to the share name held by a soft-deleted share is "-" and 8 hex digits
+	softDeletedShareRenameSuffixLength = 9
+)
+
+// getSoftDeletedShare returns the most recently deleted soft-deleted share named shareName on the account,
+// it returns nil if there is no such share, all live and soft-deleted shares on the account are returned as well
+func (d *Driver) getSoftDeletedShare(ctx context.Context, subsID, resourceGroup, accountName, shareName string) (*storage.FileShareItem, []storage.FileShareItem, error) {
+	shares, err := d.getCloudByAccount(ctx, subsID, resourceGroup, accountName).FileClient.WithSubscriptionID(subsID).ListFileShare(ctx, resourceGroup, accountName, "", deletedExpand)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list deleted file shares on account(%s): %v", accountName, err)
+	}
+	var deletedShare *storage.FileShareItem
+	for i := range shares {
+		share := shares[i]
+		if !strings.EqualFold(pointer.StringDeref(share.Name, ""), shareName) || share.FileShareProperties == nil || !pointer.BoolDeref(share.Deleted, false) {
+			continue
+		}
+		if deletedShare == nil || (share.DeletedTime != nil && (deletedShare.DeletedTime == nil || share.DeletedTime.After(deletedShare.DeletedTime.Time))) {
+			deletedShare = &share
+		}
+	}
+	return deletedShare, shares, nil
+}
+
+// getSoftDeletedShareRename returns a new share name for shareName held by a soft-deleted share, the name is derived
+// from the version of the deleted share, so a retried CreateVolume gets the same name, names of other soft-deleted shares are skipped
+func getSoftDeletedShareRename(shareName, deletedShareVersion string, shares []storage.FileShareItem) string {
+	prefix := shareName
+	if len(prefix) > fileShareNameMaxLength-softDeletedShareRenameSuffixLength {
+		prefix = prefix[:fileShareNameMaxLength-softDeletedShareRenameSuffixLength]
+	}
+	prefix = strings.TrimRight(prefix, "-")
+
+	used := map[string]bool{}
+	for _, share := range shares {
+		if share.FileShareProperties != nil && pointer.BoolDeref(share.Deleted, false) {
+			used[strings.ToLower(pointer.StringDeref(share.Name, ""))] = true
+		}
+	}
+	for i := 0; ; i++ {
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%s/%s/%d", shareName, deletedShareVersion, i)
+		name := fmt.Sprintf("%s-%08x", prefix, h.Sum32())
+		if !used[name] {
+			return name
+		}
+	}
+}
+
+// restoreFileShareByARM restores the soft-deleted share, the file share client of cloud provider does not support restore
+func restoreFileShareByARM(ctx context.Context, cloud *azure.Cloud, subsID, resourceGroup, accountName, shareName, deletedShareVersion string) error {
+	token, err := ratelimitconfig.GetServicePrincipalToken(&cloud.AzureAuthConfig, &cloud.Environment, cloud.Environment.ServiceManagementEndpoint)
+	if err != nil {
+		return fmt.Errorf("failed to get service principal token: %v", err)
+	}
+	client := storage.NewFileSharesClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	client.Authorizer = autorest.NewBearerAuthorizer(token)
+	if cloud.UserAgent != "" {
+		if err := client.AddToUserAgent(cloud.UserAgent); err != nil {
+			klog.Warningf("failed to add user agent(%s): %v", cloud.UserAgent, err)
+		}
+	}
+	_, err = client.Restore(ctx, resourceGroup, accountName, shareName, storage.DeletedShare{
+		DeletedShareName:    pointer.String(shareName),
+		DeletedShareVersion: pointer.String(deletedShareVersion),
+	})
+	return err
+}
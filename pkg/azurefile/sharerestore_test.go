@@ -0,0 +1,90 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+)
+
+func TestGetSoftDeletedShare(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID("subsID").Return(mockFileClient).AnyTimes()
+
+	now := time.Now()
+	shares := []storage.FileShareItem{
+		{Name: pointer.String("share"), FileShareProperties: &storage.FileShareProperties{}},
+		{Name: pointer.String("share"), FileShareProperties: &storage.FileShareProperties{Deleted: pointer.Bool(true), Version: pointer.String("v1"), DeletedTime: &date.Time{Time: now.Add(-time.Hour)}}},
+		{Name: pointer.String("share"), FileShareProperties: &storage.FileShareProperties{Deleted: pointer.Bool(true), Version: pointer.String("v2"), DeletedTime: &date.Time{Time: now}}},
+		{Name: pointer.String("other"), FileShareProperties: &storage.FileShareProperties{Deleted: pointer.Bool(true), Version: pointer.String("v3")}},
+	}
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", deletedExpand).Return(shares, nil).Times(1)
+	deletedShare, allShares, err := d.getSoftDeletedShare(context.Background(), "subsID", "rg", "account", "share")
+	assert.NoError(t, err)
+	assert.Len(t, allShares, len(shares))
+	if assert.NotNil(t, deletedShare) {
+		assert.Equal(t, "v2", *deletedShare.Version)
+	}
+
+	// a live share is not soft-deleted
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", deletedExpand).Return(shares[:1], nil).Times(1)
+	deletedShare, _, err = d.getSoftDeletedShare(context.Background(), "subsID", "rg", "account", "share")
+	assert.NoError(t, err)
+	assert.Nil(t, deletedShare)
+
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", "", deletedExpand).Return(nil, fmt.Errorf("list error")).Times(1)
+	_, _, err = d.getSoftDeletedShare(context.Background(), "subsID", "rg", "account", "share")
+	assert.EqualError(t, err, "failed to list deleted file shares on account(account): list error")
+}
+
+func TestGetSoftDeletedShareRename(t *testing.T) {
+	name := getSoftDeletedShareRename("share", "v1", nil)
+	assert.True(t, strings.HasPrefix(name, "share-"))
+	assert.Len(t, name, len("share")+softDeletedShareRenameSuffixLength)
+	// the name is stable across retries, even if the renamed share is created already
+	live := []storage.FileShareItem{{Name: pointer.String(name), FileShareProperties: &storage.FileShareProperties{}}}
+	assert.Equal(t, name, getSoftDeletedShareRename("share", "v1", live))
+	assert.NotEqual(t, name, getSoftDeletedShareRename("share", "v2", nil))
+
+	// names of other soft-deleted shares are skipped
+	deleted := []storage.FileShareItem{{Name: pointer.String(name), FileShareProperties: &storage.FileShareProperties{Deleted: pointer.Bool(true)}}}
+	renamed := getSoftDeletedShareRename("share", "v1", deleted)
+	assert.NotEqual(t, name, renamed)
+	assert.True(t, strings.HasPrefix(renamed, "share-"))
+
+	// long names are truncated to the maximum share name length without double hyphens
+	longName := strings.Repeat("a", fileShareNameMaxLength-softDeletedShareRenameSuffixLength-1) + "-" + strings.Repeat("b", 10)
+	renamed = getSoftDeletedShareRename(longName, "v1", nil)
+	assert.LessOrEqual(t, len(renamed), fileShareNameMaxLength)
+	assert.NotContains(t, renamed, "--")
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	volumehelper "sigs.k8s.io/azurefile-csi-driver/pkg/util"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+const (
+	// quota and usage of a file share are cached to avoid data plane requests on each NodeGetVolumeStats
+	shareStatsCacheTTL = time.Minute
+)
+
+// shareStatsSource is the SMB file share of a staged volume, whose quota and usage are read by data plane API
+// with the account key the volume is mounted with
+type shareStatsSource struct {
+	accountName           string
+	accountKey            string
+	fileShareName         string
+	storageEndpointSuffix string
+}
+
+// shareStats is the quota and usage of a file share
+type shareStats struct {
+	quotaBytes int64
+	usedBytes  int64
+}
+
+// shareStatsResponse is the response of Get Share Stats API, it's not parsed by azfile.ShareStats whose
+// ShareUsageBytes could not hold a usage larger than 2GiB
+type shareStatsResponse struct {
+	XMLName         xml.Name `xml:"ShareStats"`
+	ShareUsageBytes int64    `xml:"ShareUsageBytes"`
+}
+
+// getShareStats returns the quota and usage of the file share by data plane API, overridden in unit tests
+var getShareStats = func(ctx context.Context, src shareStatsSource) (shareStats, error) {
+	credential, err := azfile.NewSharedKeyCredential(src.accountName, src.accountKey)
+	if err != nil {
+		return shareStats{}, err
+	}
+	u, err := url.Parse(fmt.Sprintf(serviceURLTemplate, src.accountName, src.storageEndpointSuffix))
+	if err != nil {
+		return shareStats{}, err
+	}
+	p := azfile.NewPipeline(credential, azfile.PipelineOptions{})
+	shareURL := azfile.NewServiceURL(*u, p).NewShareURL(src.fileShareName)
+	properties, err := shareURL.GetProperties(ctx)
+	if err != nil {
+		return shareStats{}, fmt.Errorf("failed to get properties of file share(%s) on account(%s): %v", src.fileShareName, src.accountName, err)
+	}
+
+	statsURL := shareURL.URL()
+	params := statsURL.Query()
+	params.Set("restype", "share")
+	params.Set("comp", "stats")
+	statsURL.RawQuery = params.Encode()
+	req, err := pipeline.NewRequest(http.MethodGet, statsURL, nil)
+	if err != nil {
+		return shareStats{}, err
+	}
+	req.Header.Set("x-ms-version", azfile.ServiceVersion)
+	resp, err := p.Do(ctx, nil, req)
+	if err != nil {
+		return shareStats{}, fmt.Errorf("failed to get stats of file share(%s) on account(%s): %v", src.fileShareName, src.accountName, err)
+	}
+	defer resp.Response().Body.Close()
+	if resp.Response().StatusCode != http.StatusOK {
+		return shareStats{}, fmt.Errorf("failed to get stats of file share(%s) on account(%s): status code %d", src.fileShareName, src.accountName, resp.Response().StatusCode)
+	}
+	var stats shareStatsResponse
+	if err := xml.NewDecoder(resp.Response().Body).Decode(&stats); err != nil {
+		return shareStats{}, fmt.Errorf("failed to parse stats of file share(%s) on account(%s): %v", src.fileShareName, src.accountName, err)
+	}
+	return shareStats{quotaBytes: volumehelper.GiBToBytes(int64(properties.Quota())), usedBytes: stats.ShareUsageBytes}, nil
+}
+
+// getCachedShareStats returns the quota and usage of the file share, cached for shareStatsCacheTTL
+func (d *Driver) getCachedShareStats(ctx context.Context, src shareStatsSource) (shareStats, error) {
+	key := src.accountName + "/" + src.fileShareName
+	cache, err := d.shareStatsCache.Get(key, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return shareStats{}, err
+	}
+	if cache != nil {
+		return cache.(shareStats), nil
+	}
+	stats, err := getShareStats(ctx, src)
+	if err != nil {
+		return shareStats{}, err
+	}
+	d.shareStatsCache.Set(key, stats)
+	return stats, nil
+}
+
+// recordShareStatsSource records the file share of the volume staged on stagingPath, so that NodeGetVolumeStats
+// reports its quota and usage
+func (d *Driver) recordShareStatsSource(stagingPath string, src shareStatsSource) {
+	if v, ok := d.stagedVolumes.Load(stagingPath); ok {
+		vol := v.(stagedVolume)
+		vol.shareStats = &src
+		d.stagedVolumes.Store(stagingPath, vol)
+	}
+}
+
+// applyShareStats returns the bytes usage of the volume with the share quota as total and the share usage as used,
+// available bytes are also limited by statfsAvailable since the storage account could run out of capacity before
+// the share reaches its quota, the volume condition tells which one is the binding constraint
+func applyShareStats(stats shareStats, statfsAvailable int64) (*csi.VolumeUsage, *csi.VolumeCondition) {
+	available := stats.quotaBytes - stats.usedBytes
+	if available < 0 {
+		available = 0
+	}
+	condition := &csi.VolumeCondition{Message: fmt.Sprintf("available bytes are limited by share quota(%d bytes)", stats.quotaBytes)}
+	if statfsAvailable >= 0 && statfsAvailable < available {
+		condition.Message = fmt.Sprintf("available bytes are limited by storage account capacity, share quota(%d bytes) allows %d more bytes", stats.quotaBytes, available)
+		available = statfsAvailable
+	}
+	return &csi.VolumeUsage{
+		Unit:      csi.VolumeUsage_BYTES,
+		Available: available,
+		Total:     stats.quotaBytes,
+		Used:      stats.usedBytes,
+	}, condition
+}
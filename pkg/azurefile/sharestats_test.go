@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyShareStats(t *testing.T) {
+	tests := []struct {
+		desc              string
+		stats             shareStats
+		statfsAvailable   int64
+		expectedUsage     *csi.VolumeUsage
+		expectedCondition string
+	}{
+		{
+			desc:              "share quota is the binding constraint",
+			stats:             shareStats{quotaBytes: 100 << 30, usedBytes: 30 << 30},
+			statfsAvailable:   5 << 40,
+			expectedUsage:     &csi.VolumeUsage{Unit: csi.VolumeUsage_BYTES, Total: 100 << 30, Used: 30 << 30, Available: 70 << 30},
+			expectedCondition: "available bytes are limited by share quota(107374182400 bytes)",
+		},
+		{
+			desc:              "account capacity is the binding constraint",
+			stats:             shareStats{quotaBytes: 100 << 30, usedBytes: 30 << 30},
+			statfsAvailable:   10 << 30,
+			expectedUsage:     &csi.VolumeUsage{Unit: csi.VolumeUsage_BYTES, Total: 100 << 30, Used: 30 << 30, Available: 10 << 30},
+			expectedCondition: "available bytes are limited by storage account capacity, share quota(107374182400 bytes) allows 75161927680 more bytes",
+		},
+		{
+			desc:              "usage exceeds quota",
+			stats:             shareStats{quotaBytes: 100 << 30, usedBytes: 101 << 30},
+			statfsAvailable:   0,
+			expectedUsage:     &csi.VolumeUsage{Unit: csi.VolumeUsage_BYTES, Total: 100 << 30, Used: 101 << 30, Available: 0},
+			expectedCondition: "available bytes are limited by share quota(107374182400 bytes)",
+		},
+	}
+	for _, test := range tests {
+		usage, condition := applyShareStats(test.stats, test.statfsAvailable)
+		assert.Equal(t, test.expectedUsage, usage, test.desc)
+		assert.False(t, condition.GetAbnormal(), test.desc)
+		assert.Equal(t, test.expectedCondition, condition.GetMessage(), test.desc)
+	}
+}
+
+func TestGetCachedShareStats(t *testing.T) {
+	origGetShareStats := getShareStats
+	defer func() { getShareStats = origGetShareStats }()
+	var calls int
+	getShareStats = func(ctx context.Context, src shareStatsSource) (shareStats, error) {
+		calls++
+		if src.fileShareName == "broken" {
+			return shareStats{}, fmt.Errorf("AuthenticationFailed")
+		}
+		return shareStats{quotaBytes: 100 << 30, usedBytes: int64(calls)}, nil
+	}
+
+	d := NewFakeDriver()
+	src := shareStatsSource{accountName: "account", accountKey: "key", fileShareName: "share"}
+	stats, err := d.getCachedShareStats(context.Background(), src)
+	assert.NoError(t, err)
+	assert.Equal(t, shareStats{quotaBytes: 100 << 30, usedBytes: 1}, stats)
+	// cached stats are returned without data plane request
+	stats, err = d.getCachedShareStats(context.Background(), src)
+	assert.NoError(t, err)
+	assert.Equal(t, shareStats{quotaBytes: 100 << 30, usedBytes: 1}, stats)
+	assert.Equal(t, 1, calls)
+
+	// failure is not cached
+	src.fileShareName = "broken"
+	_, err = d.getCachedShareStats(context.Background(), src)
+	assert.Error(t, err)
+	_, err = d.getCachedShareStats(context.Background(), src)
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestNodeGetVolumeStatsWithShareStats(t *testing.T) {
+	origGetShareStats := getShareStats
+	defer func() { getShareStats = origGetShareStats }()
+	getShareStats = func(ctx context.Context, src shareStatsSource) (shareStats, error) {
+		if src.fileShareName == "broken" {
+			return shareStats{}, fmt.Errorf("AuthenticationFailed")
+		}
+		return shareStats{quotaBytes: 100 << 30, usedBytes: 1 << 30}, nil
+	}
+
+	stagingPath := t.TempDir()
+	d := NewFakeDriver()
+	d.recordStagedVolume("rg#account#share#", stagingPath, "//account.file.core.windows.net/share", smb, nil, defaultVolumeStatsTimeout)
+	d.recordShareStatsSource(stagingPath, shareStatsSource{accountName: "account", accountKey: "key", fileShareName: "share"})
+
+	resp, err := d.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "rg#account#share#", VolumePath: stagingPath, StagingTargetPath: stagingPath})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100<<30), resp.Usage[0].Total)
+	assert.Equal(t, int64(1<<30), resp.Usage[0].Used)
+	assert.NotNil(t, resp.VolumeCondition)
+	assert.Equal(t, csi.VolumeUsage_INODES, resp.Usage[1].Unit)
+
+	// statfs is reported if share stats could not be got
+	d.recordShareStatsSource(stagingPath, shareStatsSource{accountName: "account", accountKey: "key", fileShareName: "broken"})
+	resp, err = d.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "rg#account#share#", VolumePath: stagingPath, StagingTargetPath: stagingPath})
+	assert.NoError(t, err)
+	assert.NotEqual(t, int64(1<<30), resp.Usage[0].Used)
+	assert.Nil(t, resp.VolumeCondition)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// single writer access mode of volumes is not checked on the node
+	singleWriterViolationPolicyIgnore = "ignore"
+	// violation of single writer access mode is logged as a warning, the volume is still mounted
+	singleWriterViolationPolicyWarn = "warn"
+	// NodeStageVolume and NodePublishVolume fail with FailedPrecondition on violation of single writer access mode
+	singleWriterViolationPolicyReject = "reject"
+
+	// prefix of the lock key of a file share staged on this node in volumeLocks, it never matches a volume handle
+	stagedShareLockPrefix = "stagedshare/"
+)
+
+// stagedShare is the file share (and the folder in it) mounted by a staged volume
+type stagedShare struct {
+	// accountName/fileShareName in lower case
+	key string
+	// folder in the file share in lower case, the whole file share is mounted if it's empty
+	folder string
+	// the volume is staged with SINGLE_NODE_SINGLE_WRITER(ReadWriteOncePod) access mode
+	singleWriter bool
+}
+
+func newStagedShare(accountName, fileShareName, folderName string, accessMode *csi.VolumeCapability_AccessMode) stagedShare {
+	return stagedShare{
+		key:          strings.ToLower(accountName + "/" + fileShareName),
+		folder:       strings.ToLower(strings.Trim(folderName, "/")),
+		singleWriter: accessMode.GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+	}
+}
+
+// overlaps returns true if both mount the same file share and one folder contains the other
+func (s stagedShare) overlaps(other stagedShare) bool {
+	if s.key != other.key {
+		return false
+	}
+	return s.folder == "" || other.folder == "" || s.folder == other.folder ||
+		strings.HasPrefix(s.folder, other.folder+"/") || strings.HasPrefix(other.folder, s.folder+"/")
+}
+
+// recordStagedShare records the file share mounted by the volume staged on stagingPath
+func (d *Driver) recordStagedShare(stagingPath string, share stagedShare) {
+	if v, ok := d.stagedVolumes.Load(stagingPath); ok {
+		vol := v.(stagedVolume)
+		vol.share = &share
+		d.stagedVolumes.Store(stagingPath, vol)
+	}
+}
+
+// checkSingleWriterStage checks single writer access mode across volumes staged on this node, e.g. a PV with
+// ReadWriteOncePod referencing a file share which is also referenced by a ReadWriteMany PV: a single writer
+// volume is not staged if the same share is already staged on another staging path, and no volume is staged
+// on the share while it's staged by a single writer volume. Volumes staged on other nodes are not known.
+// The file share is reserved by a share-keyed lock until release is called after the volume is staged and
+// recorded, so that volumes staging the same share concurrently could not both pass the check.
+func (d *Driver) checkSingleWriterStage(volumeID, stagingPath string, share stagedShare) (release func(), err error) {
+	if d.singleWriterViolationPolicy == singleWriterViolationPolicyIgnore {
+		return func() {}, nil
+	}
+	lockKey := stagedShareLockPrefix + share.key
+	if acquired := d.volumeLocks.TryAcquire(lockKey); !acquired {
+		return nil, status.Errorf(codes.Aborted, "file share(%s) of volume(%s) is being staged by another volume on this node", share.key, volumeID)
+	}
+	release = func() { d.volumeLocks.Release(lockKey) }
+	if err := d.checkStagedShareConflict(volumeID, stagingPath, share); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// checkStagedShareConflict returns the violation of single writer access mode of staging share on stagingPath
+func (d *Driver) checkStagedShareConflict(volumeID, stagingPath string, share stagedShare) error {
+	var conflict *stagedVolume
+	d.stagedVolumes.Range(func(_, value interface{}) bool {
+		vol := value.(stagedVolume)
+		if vol.StagingPath == stagingPath || vol.share == nil || !vol.share.overlaps(share) || !(share.singleWriter || vol.share.singleWriter) {
+			return true
+		}
+		conflict = &vol
+		return false
+	})
+	if conflict == nil {
+		return nil
+	}
+	var msg string
+	if share.singleWriter {
+		msg = fmt.Sprintf("volume(%s) with single writer access mode could not be staged on %s since file share(%s) is already staged by volume(%s) on %s", volumeID, stagingPath, share.key, conflict.VolumeID, conflict.StagingPath)
+	} else {
+		msg = fmt.Sprintf("volume(%s) could not be staged on %s since file share(%s) is already staged by volume(%s) with single writer access mode on %s", volumeID, stagingPath, share.key, conflict.VolumeID, conflict.StagingPath)
+	}
+	return d.handleSingleWriterViolation(msg)
+}
+
+// checkSingleWriterPublish returns error if the volume with single writer access mode is already published on
+// another target path of this node, targets published before the driver restarts are not known
+func (d *Driver) checkSingleWriterPublish(volumeID, target string, accessMode *csi.VolumeCapability_AccessMode) error {
+	if d.singleWriterViolationPolicy == singleWriterViolationPolicyIgnore || accessMode.GetMode() != csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER {
+		return nil
+	}
+	var publishedTarget string
+	d.singleWriterTargets.Range(func(key, value interface{}) bool {
+		if value.(string) == volumeID && key.(string) != target {
+			publishedTarget = key.(string)
+			return false
+		}
+		return true
+	})
+	if publishedTarget == "" {
+		return nil
+	}
+	return d.handleSingleWriterViolation(fmt.Sprintf("volume(%s) with single writer access mode could not be published on %s since it's already published on %s", volumeID, target, publishedTarget))
+}
+
+// recordSingleWriterPublish records the target path of the volume with single writer access mode
+func (d *Driver) recordSingleWriterPublish(volumeID, target string, accessMode *csi.VolumeCapability_AccessMode) {
+	if d.singleWriterViolationPolicy != singleWriterViolationPolicyIgnore && accessMode.GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER {
+		d.singleWriterTargets.Store(target, volumeID)
+	}
+}
+
+func (d *Driver) handleSingleWriterViolation(msg string) error {
+	if d.singleWriterViolationPolicy == singleWriterViolationPolicyReject {
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+	klog.Warning(msg)
+	return nil
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"testing"
+
+	azure2 "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/azurefile-csi-driver/test/utils/testutil"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+var (
+	singleWriterAccessMode = &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER}
+	multiWriterAccessMode  = &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER}
+)
+
+func TestStagedShareOverlaps(t *testing.T) {
+	tests := []struct {
+		desc     string
+		a        stagedShare
+		b        stagedShare
+		expected bool
+	}{
+		{
+			desc:     "same share",
+			a:        newStagedShare("Account", "share", "", nil),
+			b:        newStagedShare("account", "SHARE", "", nil),
+			expected: true,
+		},
+		{
+			desc:     "different shares",
+			a:        newStagedShare("account", "share", "", nil),
+			b:        newStagedShare("account", "share2", "", nil),
+			expected: false,
+		},
+		{
+			desc:     "whole share and its folder",
+			a:        newStagedShare("account", "share", "", nil),
+			b:        newStagedShare("account", "share", "data", nil),
+			expected: true,
+		},
+		{
+			desc:     "nested folders",
+			a:        newStagedShare("account", "share", "/data/", nil),
+			b:        newStagedShare("account", "share", "data/app", nil),
+			expected: true,
+		},
+		{
+			desc:     "sibling folders",
+			a:        newStagedShare("account", "share", "data", nil),
+			b:        newStagedShare("account", "share", "data2", nil),
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.a.overlaps(test.b), test.desc)
+		assert.Equal(t, test.expected, test.b.overlaps(test.a), test.desc)
+	}
+}
+
+func TestCheckSingleWriterStage(t *testing.T) {
+	tests := []struct {
+		desc         string
+		policy       string
+		staged       stagedShare
+		share        stagedShare
+		expectedCode codes.Code
+	}{
+		{
+			desc:   "violation is ignored",
+			policy: singleWriterViolationPolicyIgnore,
+			staged: newStagedShare("account", "share", "", multiWriterAccessMode),
+			share:  newStagedShare("account", "share", "", singleWriterAccessMode),
+		},
+		{
+			desc:   "violation is logged",
+			policy: singleWriterViolationPolicyWarn,
+			staged: newStagedShare("account", "share", "", multiWriterAccessMode),
+			share:  newStagedShare("account", "share", "", singleWriterAccessMode),
+		},
+		{
+			desc:         "single writer volume of a staged share is rejected",
+			policy:       singleWriterViolationPolicyReject,
+			staged:       newStagedShare("account", "share", "", multiWriterAccessMode),
+			share:        newStagedShare("account", "share", "", singleWriterAccessMode),
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:         "share of a single writer volume is rejected",
+			policy:       singleWriterViolationPolicyReject,
+			staged:       newStagedShare("account", "share", "data", singleWriterAccessMode),
+			share:        newStagedShare("account", "share", "", multiWriterAccessMode),
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:   "multi writer volumes of a share",
+			policy: singleWriterViolationPolicyReject,
+			staged: newStagedShare("account", "share", "", multiWriterAccessMode),
+			share:  newStagedShare("account", "share", "", multiWriterAccessMode),
+		},
+		{
+			desc:   "single writer volumes on different folders",
+			policy: singleWriterViolationPolicyReject,
+			staged: newStagedShare("account", "share", "a", singleWriterAccessMode),
+			share:  newStagedShare("account", "share", "b", singleWriterAccessMode),
+		},
+	}
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.singleWriterViolationPolicy = test.policy
+		d.recordStagedVolume("rg#account#share#", "/staging1", "//account.file.core.windows.net/share", smb, nil, defaultVolumeStatsTimeout)
+		d.recordStagedShare("/staging1", test.staged)
+
+		release, err := d.checkSingleWriterStage("rg#account#share#pv2", "/staging2", test.share)
+		assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+		if err == nil {
+			release()
+		}
+		// restaging on the same staging path is not a violation
+		release, err = d.checkSingleWriterStage("rg#account#share#", "/staging1", test.staged)
+		assert.NoError(t, err, test.desc)
+		release()
+	}
+}
+
+func TestCheckSingleWriterStageReservesShare(t *testing.T) {
+	d := NewFakeDriver()
+	d.singleWriterViolationPolicy = singleWriterViolationPolicyReject
+
+	release, err := d.checkSingleWriterStage("rg#account#share#pv1", "/staging1", newStagedShare("account", "share", "", singleWriterAccessMode))
+	assert.NoError(t, err)
+	// the share is reserved until the first volume is staged and recorded
+	_, err = d.checkSingleWriterStage("rg#account#share#pv2", "/staging2", newStagedShare("account", "share", "", multiWriterAccessMode))
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	// another share is not reserved
+	otherRelease, err := d.checkSingleWriterStage("rg#account#other#pv3", "/staging3", newStagedShare("account", "other", "", singleWriterAccessMode))
+	assert.NoError(t, err)
+	otherRelease()
+
+	d.recordStagedVolume("rg#account#share#pv1", "/staging1", "//account.file.core.windows.net/share", smb, nil, defaultVolumeStatsTimeout)
+	d.recordStagedShare("/staging1", newStagedShare("account", "share", "", singleWriterAccessMode))
+	release()
+	_, err = d.checkSingleWriterStage("rg#account#share#pv2", "/staging2", newStagedShare("account", "share", "", multiWriterAccessMode))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestCheckSingleWriterPublish(t *testing.T) {
+	d := NewFakeDriver()
+	d.singleWriterViolationPolicy = singleWriterViolationPolicyReject
+	assert.NoError(t, d.checkSingleWriterPublish("vol", "/target1", singleWriterAccessMode))
+	d.recordSingleWriterPublish("vol", "/target1", singleWriterAccessMode)
+	// republish on the same target path
+	assert.NoError(t, d.checkSingleWriterPublish("vol", "/target1", singleWriterAccessMode))
+
+	err := d.checkSingleWriterPublish("vol", "/target2", singleWriterAccessMode)
+	assert.Equal(t, status.Error(codes.FailedPrecondition, "volume(vol) with single writer access mode could not be published on /target2 since it's already published on /target1"), err)
+	// other volumes and access modes are not restricted
+	assert.NoError(t, d.checkSingleWriterPublish("vol2", "/target2", singleWriterAccessMode))
+	assert.NoError(t, d.checkSingleWriterPublish("vol", "/target2", multiWriterAccessMode))
+
+	d.singleWriterTargets.Delete("/target1")
+	assert.NoError(t, d.checkSingleWriterPublish("vol", "/target2", singleWriterAccessMode))
+}
+
+func TestNodeStageVolumeSingleWriter(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on non-Linux platform")
+	}
+	stage := func(d *Driver, volumeID, stagingPath string, accessMode *csi.VolumeCapability_AccessMode) error {
+		_, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{VolumeId: volumeID, StagingTargetPath: stagingPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: accessMode,
+			},
+			VolumeContext: map[string]string{shareNameField: "share"},
+			Secrets:       map[string]string{"accountname": "k8s", "accountkey": "testkey"}})
+		return err
+	}
+
+	d := NewFakeDriver()
+	d.singleWriterViolationPolicy = singleWriterViolationPolicyReject
+	d.cloud = &azure.Cloud{
+		Environment: azure2.Environment{StorageEndpointSuffix: "test_suffix"},
+	}
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+
+	// a ReadWriteMany PV and a ReadWriteOncePod PV reference the same share
+	rwxPath := testutil.GetWorkDirPath("single_writer_rwx_test", t)
+	rwopPath := testutil.GetWorkDirPath("single_writer_rwop_test", t)
+	defer os.RemoveAll(rwxPath)
+	defer os.RemoveAll(rwopPath)
+	assert.NoError(t, stage(d, "rg#k8s#share#rwx", rwxPath, multiWriterAccessMode))
+	err = stage(d, "rg#k8s#share#rwop", rwopPath, singleWriterAccessMode)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), err)
+	assert.Len(t, mounter.Interface.(*fakeMounter).MountPoints, 1)
+
+	// the single writer volume is staged after the other volume is unstaged
+	_, err = d.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "rg#k8s#share#rwx", StagingTargetPath: rwxPath})
+	assert.NoError(t, err)
+	assert.NoError(t, stage(d, "rg#k8s#share#rwop", rwopPath, singleWriterAccessMode))
+	// restaging the single writer volume is idempotent
+	assert.NoError(t, stage(d, "rg#k8s#share#rwop", rwopPath, singleWriterAccessMode))
+	err = stage(d, "rg#k8s#share#rwx", rwxPath, multiWriterAccessMode)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), err)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/azure-storage-file-go/azfile"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// error code of creating a share snapshot when the file share already has the maximum number of snapshots
+	shareSnapshotCountExceeded = "ShareSnapshotCountExceeded"
+	shareSnapshotNotFound      = "ShareSnapshotNotFound"
+)
+
+// isSnapshotLimitError returns true if the snapshot is not created since the file share has too many snapshots
+func isSnapshotLimitError(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), strings.ToLower(shareSnapshotCountExceeded))
+}
+
+// isPrunableSnapshot returns true if the snapshot is created by CreateSnapshot, and it has no retention class
+// which is managed by external lifecycle tooling
+func isPrunableSnapshot(snapshotName, retentionClass string) bool {
+	return snapshotName != "" && retentionClass == ""
+}
+
+// listSnapshotContentHandles returns snapshot handles of the VolumeSnapshotContents of the driver, a share snapshot
+// whose handle is not in the list is not referenced by any VolumeSnapshot
+func (d *Driver) listSnapshotContentHandles(ctx context.Context) (map[string]bool, error) {
+	if d.snapshotClient == nil {
+		return nil, fmt.Errorf("snapshot client is nil")
+	}
+	contents, err := d.snapshotClient.SnapshotV1().VolumeSnapshotContents().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	handles := make(map[string]bool)
+	for _, content := range contents.Items {
+		if content.Spec.Driver != d.Name {
+			continue
+		}
+		if content.Spec.Source.SnapshotHandle != nil {
+			handles[*content.Spec.Source.SnapshotHandle] = true
+		}
+		if content.Status != nil && content.Status.SnapshotHandle != nil {
+			handles[*content.Status.SnapshotHandle] = true
+		}
+	}
+	return handles, nil
+}
+
+// pruneOldestSnapshot deletes the oldest prunable snapshot of the source volume whose VolumeSnapshotContent no longer
+// exists to make room for a new snapshot, returns the deleted snapshot, or empty if there is no prunable snapshot.
+// A snapshot already deleted by another request is skipped, so it's safe to run again after a failure.
+func (d *Driver) pruneOldestSnapshot(ctx context.Context, sourceVolumeID string, secrets map[string]string, useDataPlaneAPI bool) (string, error) {
+	contentHandles, err := d.getSnapshotContentHandles(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list volume snapshot contents: %v", err)
+	}
+	if len(secrets) > 0 || useDataPlaneAPI {
+		return d.pruneOldestSnapshotByDataPlane(ctx, sourceVolumeID, secrets, contentHandles)
+	}
+
+	rgName, accountName, fileShareName, _, _, subsID, err := GetFileShareInfo(sourceVolumeID) //nolint:dogsled
+	if err != nil {
+		return "", err
+	}
+	if rgName == "" {
+		rgName = d.cloud.ResourceGroup
+	}
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	fileClient := d.getCloudByAccount(ctx, subsID, rgName, accountName).FileClient.WithSubscriptionID(subsID)
+	// only shares whose names start with the file share name are listed
+	shares, err := fileClient.ListFileShare(ctx, rgName, accountName, fileShareName, snapshotsExpand)
+	if err != nil {
+		return "", fmt.Errorf("failed to list snapshots of file share(%s) under account(%s) rg(%s): %v", fileShareName, accountName, rgName, err)
+	}
+	var snapshots []storage.FileShareItem
+	for _, share := range shares {
+		if share.SnapshotTime != nil && pointer.StringDeref(share.Name, "") == fileShareName &&
+			!contentHandles[sourceVolumeID+separator+share.SnapshotTime.Format(snapshotTimeFormat)] {
+			snapshots = append(snapshots, share)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].SnapshotTime.Before(snapshots[j].SnapshotTime.Time) })
+
+	// metadata is not returned by listing snapshots, get them from the oldest snapshot without VolumeSnapshotContent
+	// until a prunable snapshot is found
+	for _, item := range snapshots {
+		snapshot := item.SnapshotTime.Format(snapshotTimeFormat)
+		fileShare, err := fileClient.GetFileShare(ctx, rgName, accountName, fileShareName, snapshot)
+		if err != nil {
+			if strings.Contains(err.Error(), shareSnapshotNotFound) || isNotFoundError(err) {
+				continue
+			}
+			return "", fmt.Errorf("failed to get snapshot(%s) of file share(%s) under account(%s) rg(%s): %v", snapshot, fileShareName, accountName, rgName, err)
+		}
+		if fileShare.FileShareProperties == nil ||
+			!isPrunableSnapshot(getMetadataValue(fileShare.Metadata, snapshotNameKey), getMetadataValue(fileShare.Metadata, retentionClassMetadataKey)) {
+			continue
+		}
+		if err := fileClient.DeleteFileShare(ctx, rgName, accountName, fileShareName, snapshot); err != nil && !strings.Contains(err.Error(), shareSnapshotNotFound) {
+			return "", fmt.Errorf("failed to delete snapshot(%s) of file share(%s) under account(%s) rg(%s): %v", snapshot, fileShareName, accountName, rgName, err)
+		}
+		klog.V(2).Infof("pruned snapshot(%s) of VolumeSnapshot(%s) from file share(%s) under account(%s) rg(%s)", snapshot, getMetadataValue(fileShare.Metadata, snapshotNameKey), fileShareName, accountName, rgName)
+		return snapshot, nil
+	}
+	return "", nil
+}
+
+// pruneOldestSnapshotByDataPlane is pruneOldestSnapshot with data plane API
+func (d *Driver) pruneOldestSnapshotByDataPlane(ctx context.Context, sourceVolumeID string, secrets map[string]string, contentHandles map[string]bool) (string, error) {
+	serviceURL, fileShareName, err := d.getServiceURL(ctx, sourceVolumeID, secrets)
+	if err != nil {
+		return "", err
+	}
+	if fileShareName == "" {
+		return "", fmt.Errorf("file share is empty after parsing sourceVolumeID: %s", sourceVolumeID)
+	}
+
+	var snapshots []azfile.ShareItem
+	for marker := (azfile.Marker{}); marker.NotDone(); {
+		list, err := serviceURL.ListSharesSegment(ctx, marker, azfile.ListSharesOptions{Prefix: fileShareName, Detail: azfile.ListSharesDetail{Metadata: true, Snapshots: true}})
+		if err != nil {
+			return "", fmt.Errorf("failed to list snapshots of file share(%s): %v", fileShareName, err)
+		}
+		for _, share := range list.ShareItems {
+			if share.Name == fileShareName && share.Snapshot != nil && !contentHandles[sourceVolumeID+separator+*share.Snapshot] &&
+				isPrunableSnapshot(share.Metadata[snapshotNameKey], share.Metadata[retentionClassMetadataKey]) {
+				snapshots = append(snapshots, share)
+			}
+		}
+		marker = list.NextMarker
+	}
+	if len(snapshots) == 0 {
+		return "", nil
+	}
+	// snapshot is a timestamp in ISO 8601 format with fixed precision, it sorts by time
+	sort.Slice(snapshots, func(i, j int) bool { return *snapshots[i].Snapshot < *snapshots[j].Snapshot })
+
+	snapshot := *snapshots[0].Snapshot
+	if _, err := serviceURL.NewShareURL(fileShareName).WithSnapshot(snapshot).Delete(ctx, azfile.DeleteSnapshotsOptionNone); err != nil && !strings.Contains(err.Error(), shareSnapshotNotFound) {
+		return "", fmt.Errorf("failed to delete snapshot(%s) of file share(%s): %v", snapshot, fileShareName, err)
+	}
+	klog.V(2).Infof("pruned snapshot(%s) of VolumeSnapshot(%s) from file share(%s)", snapshot, snapshots[0].Metadata[snapshotNameKey], fileShareName)
+	return snapshot, nil
+}
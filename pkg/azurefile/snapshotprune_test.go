@@ -0,0 +1,231 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const snapshotLimitErr = "Retriable: false, RetryAfter: 0s, HTTPStatusCode: 409, RawError: Code=\"ShareSnapshotCountExceeded\" Message=\"The total number of snapshots for the share is over the limit.\""
+
+func TestIsSnapshotLimitError(t *testing.T) {
+	assert.False(t, isSnapshotLimitError(nil))
+	assert.False(t, isSnapshotLimitError(fmt.Errorf("ShareNotFound")))
+	assert.True(t, isSnapshotLimitError(fmt.Errorf(snapshotLimitErr)))
+	assert.True(t, isSnapshotLimitError(status.Errorf(codes.Internal, "create snapshot failed with %s", snapshotLimitErr)))
+}
+
+func TestIsPrunableSnapshot(t *testing.T) {
+	assert.True(t, isPrunableSnapshot("snapshot-1", ""))
+	assert.False(t, isPrunableSnapshot("", ""))
+	assert.False(t, isPrunableSnapshot("snapshot-1", "weekly"))
+}
+
+// fakeSnapshots mocks snapshots of file share "share" under account "account" rg "rg", metadata of a snapshot is
+// returned by GetFileShare, a deleted snapshot is removed, getSnapshotContentHandles returns contents
+type fakeSnapshots struct {
+	items    []storage.FileShareItem
+	metadata map[string]map[string]*string
+	deleted  []string
+	// snapshot handles of VolumeSnapshotContents
+	contents map[string]bool
+}
+
+func newFakeSnapshots(ctrl *gomock.Controller, d *Driver) *fakeSnapshots {
+	f := &fakeSnapshots{metadata: map[string]map[string]*string{}, contents: map[string]bool{}}
+	d.getSnapshotContentHandles = func(ctx context.Context) (map[string]bool, error) {
+		return f.contents, nil
+	}
+	mockFileClient := mockfileclient.NewMockInterface(ctrl)
+	d.cloud = &azure.Cloud{}
+	d.cloud.FileClient = mockFileClient
+	mockFileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(mockFileClient).AnyTimes()
+	mockFileClient.EXPECT().ListFileShare(gomock.Any(), "rg", "account", gomock.Any(), snapshotsExpand).DoAndReturn(
+		func(ctx context.Context, resourceGroupName, accountName, filter, expand string) ([]storage.FileShareItem, error) {
+			var items []storage.FileShareItem
+			for _, item := range f.items {
+				if strings.HasPrefix(pointer.StringDeref(item.Name, ""), filter) {
+					items = append(items, item)
+				}
+			}
+			return items, nil
+		}).AnyTimes()
+	mockFileClient.EXPECT().GetFileShare(gomock.Any(), "rg", "account", "share", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, resourceGroupName, accountName, name, expand string) (storage.FileShare, error) {
+			metadata, ok := f.metadata[expand]
+			if !ok {
+				return storage.FileShare{}, fmt.Errorf(shareSnapshotNotFound)
+			}
+			return storage.FileShare{Name: pointer.String(name), FileShareProperties: &storage.FileShareProperties{Metadata: metadata}}, nil
+		}).AnyTimes()
+	mockFileClient.EXPECT().DeleteFileShare(gomock.Any(), "rg", "account", "share", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, resourceGroupName, accountName, name, snapshot string) error {
+			f.deleted = append(f.deleted, snapshot)
+			for i, item := range f.items {
+				if item.SnapshotTime.Format(snapshotTimeFormat) == snapshot {
+					f.items = append(f.items[:i], f.items[i+1:]...)
+					delete(f.metadata, snapshot)
+					return nil
+				}
+			}
+			return fmt.Errorf(shareSnapshotNotFound)
+		}).AnyTimes()
+	return f
+}
+
+func (f *fakeSnapshots) add(name string, snapshotTime time.Time, metadata map[string]*string) string {
+	t := date.Time{Time: snapshotTime}
+	f.items = append(f.items, storage.FileShareItem{Name: pointer.String(name), FileShareProperties: &storage.FileShareProperties{SnapshotTime: &t}})
+	snapshot := t.Format(snapshotTimeFormat)
+	if name == "share" {
+		f.metadata[snapshot] = metadata
+	}
+	return snapshot
+}
+
+func TestPruneOldestSnapshot(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	f := newFakeSnapshots(ctrl, d)
+	now := time.Now()
+	// snapshots not created by the driver, with retention class, with VolumeSnapshotContent, or of another share are
+	// never pruned
+	f.add("share", now.Add(-5*time.Hour), nil)
+	referenced := f.add("share", now.Add(-5*time.Hour), map[string]*string{snapshotNameKey: pointer.String("snap-0")})
+	f.contents["rg#account#share#"+separator+referenced] = true
+	f.add("share", now.Add(-4*time.Hour), map[string]*string{snapshotNameKey: pointer.String("snap-retained"), retentionClassMetadataKey: pointer.String("weekly")})
+	f.add("other", now.Add(-4*time.Hour), map[string]*string{snapshotNameKey: pointer.String("snap-other")})
+	newer := f.add("share", now.Add(-1*time.Hour), map[string]*string{snapshotNameKey: pointer.String("snap-2")})
+	older := f.add("share", now.Add(-3*time.Hour), map[string]*string{snapshotNameKey: pointer.String("snap-1")})
+
+	pruned, err := d.pruneOldestSnapshot(context.Background(), "rg#account#share#", nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, older, pruned)
+	pruned, err = d.pruneOldestSnapshot(context.Background(), "rg#account#share#", nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, newer, pruned)
+	pruned, err = d.pruneOldestSnapshot(context.Background(), "rg#account#share#", nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "", pruned)
+	assert.Equal(t, []string{older, newer}, f.deleted)
+}
+
+func TestPruneOldestSnapshotContentError(t *testing.T) {
+	d := NewFakeDriver()
+	d.snapshotClient = nil
+	d.getSnapshotContentHandles = d.listSnapshotContentHandles
+	_, err := d.pruneOldestSnapshot(context.Background(), "rg#account#share#", nil, false)
+	assert.Equal(t, fmt.Errorf("failed to list volume snapshot contents: snapshot client is nil"), err)
+}
+
+func TestCreateSnapshotAutoPrune(t *testing.T) {
+	req := &csi.CreateSnapshotRequest{
+		SourceVolumeId: "rg#account#share#",
+		Name:           "snap-new",
+	}
+
+	d := NewFakeDriver()
+	req.Parameters = map[string]string{"autoPruneSnapshots": "yes"}
+	_, err := d.CreateSnapshot(context.Background(), req)
+	assert.Equal(t, status.Errorf(codes.InvalidArgument, "invalid autoprunesnapshots: yes in volume snapshot class"), err)
+
+	tests := []struct {
+		desc            string
+		autoPrune       string
+		prunable        bool
+		referenced      bool
+		expectedErr     error
+		expectedDeleted int
+	}{
+		{
+			desc:        "snapshot limit is reached",
+			prunable:    true,
+			expectedErr: status.Errorf(codes.ResourceExhausted, "file share of volume(rg#account#share#) reaches its snapshot limit, delete unused snapshots or set autoprunesnapshots to true in volume snapshot class to delete the oldest snapshot created by the driver: create snapshot from(rg#account#share#) failed with %s, accountName: \"account\"", snapshotLimitErr),
+		},
+		{
+			desc:            "oldest snapshot is pruned",
+			autoPrune:       "true",
+			prunable:        true,
+			expectedDeleted: 1,
+		},
+		{
+			desc:        "snapshot with VolumeSnapshotContent is not pruned",
+			autoPrune:   "true",
+			prunable:    true,
+			referenced:  true,
+			expectedErr: status.Errorf(codes.ResourceExhausted, "file share of volume(rg#account#share#) reaches its snapshot limit and there is no snapshot created by the driver without retention class to prune"),
+		},
+		{
+			desc:        "no snapshot could be pruned",
+			autoPrune:   "true",
+			expectedErr: status.Errorf(codes.ResourceExhausted, "file share of volume(rg#account#share#) reaches its snapshot limit and there is no snapshot created by the driver without retention class to prune"),
+		},
+	}
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		d := NewFakeDriver()
+		f := newFakeSnapshots(ctrl, d)
+		metadata := map[string]*string{snapshotNameKey: pointer.String("snap-old")}
+		if !test.prunable {
+			metadata[retentionClassMetadataKey] = pointer.String("weekly")
+		}
+		snapshot := f.add("share", time.Now().Add(-time.Hour), metadata)
+		f.contents[req.SourceVolumeId+separator+snapshot] = test.referenced
+
+		// the snapshot could only be created after a snapshot is deleted
+		snapshotTime := date.Time{Time: time.Now()}
+		mockFileClient := d.cloud.FileClient.(*mockfileclient.MockInterface)
+		mockFileClient.EXPECT().CreateFileShare(gomock.Any(), "rg", "account", gomock.Any(), snapshotsExpand).DoAndReturn(
+			func(ctx context.Context, resourceGroupName, accountName string, shareOptions *fileclient.ShareOptions, expand string) (storage.FileShare, error) {
+				if len(f.deleted) == 0 {
+					return storage.FileShare{}, fmt.Errorf(snapshotLimitErr)
+				}
+				return storage.FileShare{FileShareProperties: &storage.FileShareProperties{SnapshotTime: &snapshotTime}}, nil
+			}).AnyTimes()
+
+		req.Parameters = map[string]string{"autoPruneSnapshots": test.autoPrune}
+		if test.autoPrune == "" {
+			req.Parameters = nil
+		}
+		resp, err := d.CreateSnapshot(context.Background(), req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedDeleted, len(f.deleted), test.desc)
+		if err == nil {
+			assert.Equal(t, "rg#account#share##"+snapshotTime.Format(snapshotTimeFormat), resp.GetSnapshot().GetSnapshotId(), test.desc)
+		}
+		ctrl.Finish()
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	// addresses of network interfaces on the node, overridden in unit tests
+	getInterfaceAddrs       = net.InterfaceAddrs
+	getInterfaceAddrsByName = func(name string) ([]net.Addr, error) {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		return iface.Addrs()
+	}
+)
+
+// resolveSourceAddress returns the local address which SMB mount binds to, sourceAddress is either an IP address
+// which must be assigned to a network interface on the node, or the name of a network interface whose first
+// IPv4 address (or IPv6 address if it has no IPv4 address) is used
+func resolveSourceAddress(sourceAddress string) (string, error) {
+	if ip := net.ParseIP(sourceAddress); ip != nil {
+		addrs, err := getInterfaceAddrs()
+		if err != nil {
+			return "", fmt.Errorf("failed to list addresses of network interfaces: %v", err)
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return ip.String(), nil
+			}
+		}
+		return "", fmt.Errorf("source address(%s) is not assigned to any network interface on the node", sourceAddress)
+	}
+
+	addrs, err := getInterfaceAddrsByName(sourceAddress)
+	if err != nil {
+		return "", fmt.Errorf("network interface(%s) is not found on the node: %v", sourceAddress, err)
+	}
+	var ipv6 string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+		if ipv6 == "" {
+			ipv6 = ipNet.IP.String()
+		}
+	}
+	if ipv6 == "" {
+		return "", fmt.Errorf("network interface(%s) has no unicast address on the node", sourceAddress)
+	}
+	return ipv6, nil
+}
+
+// getSourceAddressMountOption returns the value of srcaddr in mount options, or empty if it's not set
+func getSourceAddressMountOption(mountOptions []string) string {
+	for _, mountOption := range mountOptions {
+		for _, option := range strings.Split(mountOption, ",") {
+			if kv := strings.SplitN(strings.TrimSpace(option), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], srcaddr) {
+				return kv[1]
+			}
+		}
+	}
+	return ""
+}
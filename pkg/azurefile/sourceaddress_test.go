@@ -0,0 +1,84 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSourceAddress(t *testing.T) {
+	origGetInterfaceAddrs, origGetInterfaceAddrsByName := getInterfaceAddrs, getInterfaceAddrsByName
+	defer func() {
+		getInterfaceAddrs, getInterfaceAddrsByName = origGetInterfaceAddrs, origGetInterfaceAddrsByName
+	}()
+
+	eth0 := []net.Addr{&net.IPNet{IP: net.ParseIP("10.0.0.4"), Mask: net.CIDRMask(24, 32)}}
+	eth1 := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("fd00::4"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("10.1.0.4"), Mask: net.CIDRMask(24, 32)},
+	}
+	eth2 := []net.Addr{&net.IPNet{IP: net.ParseIP("fd00::5"), Mask: net.CIDRMask(64, 128)}}
+	getInterfaceAddrs = func() ([]net.Addr, error) {
+		return append(append(append([]net.Addr{}, eth0...), eth1...), eth2...), nil
+	}
+	getInterfaceAddrsByName = func(name string) ([]net.Addr, error) {
+		switch name {
+		case "eth0":
+			return eth0, nil
+		case "eth1":
+			return eth1, nil
+		case "eth2":
+			return eth2, nil
+		case "lo":
+			return []net.Addr{&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)}}, nil
+		}
+		return nil, fmt.Errorf("no such network interface")
+	}
+
+	tests := []struct {
+		sourceAddress string
+		expected      string
+		expectedErr   error
+	}{
+		{sourceAddress: "10.1.0.4", expected: "10.1.0.4"},
+		{sourceAddress: "fd00::5", expected: "fd00::5"},
+		{sourceAddress: "10.2.0.4", expectedErr: fmt.Errorf("source address(10.2.0.4) is not assigned to any network interface on the node")},
+		{sourceAddress: "eth0", expected: "10.0.0.4"},
+		// IPv4 address is preferred, link local address is skipped
+		{sourceAddress: "eth1", expected: "10.1.0.4"},
+		{sourceAddress: "eth2", expected: "fd00::5"},
+		{sourceAddress: "lo", expectedErr: fmt.Errorf("network interface(lo) has no unicast address on the node")},
+		{sourceAddress: "eth3", expectedErr: fmt.Errorf("network interface(eth3) is not found on the node: no such network interface")},
+	}
+	for _, test := range tests {
+		addr, err := resolveSourceAddress(test.sourceAddress)
+		assert.Equal(t, test.expectedErr, err, test.sourceAddress)
+		assert.Equal(t, test.expected, addr, test.sourceAddress)
+	}
+}
+
+func TestGetSourceAddressMountOption(t *testing.T) {
+	assert.Equal(t, "", getSourceAddressMountOption(nil))
+	assert.Equal(t, "", getSourceAddressMountOption([]string{"srcaddr", "nosharesock"}))
+	assert.Equal(t, "10.0.0.4", getSourceAddressMountOption([]string{"dir_mode=0777,srcaddr=10.0.0.4", "nosharesock"}))
+	assert.Equal(t, "10.0.0.5", getSourceAddressMountOption([]string{"SRCADDR=10.0.0.5"}))
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+const (
+	// NodeUnstageVolume unmounts the staging path even if it's still published, which is the CSI behavior
+	unstagePolicyUnmount = "unmount"
+	// NodeUnstageVolume fails with FailedPrecondition until all publish targets are released, kubelet retries it
+	unstagePolicyFail = "fail"
+)
+
+// validateUnstagePolicy returns error if policy is not supported on this platform
+func validateUnstagePolicy(policy string) error {
+	switch policy {
+	case unstagePolicyUnmount:
+		return nil
+	case unstagePolicyFail:
+		if runtime.GOOS == "windows" {
+			return fmt.Errorf("unstage policy(%s) is not supported on Windows since mount references could not be listed", policy)
+		}
+		return nil
+	}
+	return fmt.Errorf("unstage policy(%s) is not supported, supported policies: %v", policy, []string{unstagePolicyUnmount, unstagePolicyFail})
+}
+
+// volumeRefs is the publish targets of a staged volume
+type volumeRefs struct {
+	stagingPath string
+	targets     map[string]bool
+}
+
+// stageRefs counts publish targets of staged volumes by volumeID, a volume not known yet, e.g. after
+// driver restart, is derived from the bind mounts of its staging path
+type stageRefs struct {
+	mu      sync.Mutex
+	volumes map[string]*volumeRefs
+	// getMountRefs returns the mount points referencing the same mount as the staging path
+	getMountRefs func(stagingPath string) ([]string, error)
+}
+
+func newStageRefs(getMountRefs func(stagingPath string) ([]string, error)) *stageRefs {
+	return &stageRefs{volumes: map[string]*volumeRefs{}, getMountRefs: getMountRefs}
+}
+
+// get returns references of the volume, derives them from mounts if the volume is not known
+func (r *stageRefs) get(volumeID, stagingPath string) (*volumeRefs, error) {
+	if refs, ok := r.volumes[volumeID]; ok && refs.stagingPath == stagingPath {
+		return refs, nil
+	}
+	mountRefs, err := r.getMountRefs(stagingPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mount references of staging path(%s): %v", stagingPath, err)
+	}
+	refs := &volumeRefs{stagingPath: stagingPath, targets: map[string]bool{}}
+	for _, target := range mountRefs {
+		refs.targets[target] = true
+	}
+	r.volumes[volumeID] = refs
+	return refs, nil
+}
+
+// acquire adds target as a reference of the volume staged on stagingPath
+func (r *stageRefs) acquire(volumeID, stagingPath, target string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	refs, err := r.get(volumeID, stagingPath)
+	if err != nil {
+		return err
+	}
+	refs.targets[target] = true
+	return nil
+}
+
+// release removes target from references of the volume
+func (r *stageRefs) release(volumeID, target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if refs, ok := r.volumes[volumeID]; ok {
+		delete(refs.targets, target)
+	}
+}
+
+// references returns the publish targets of the volume staged on stagingPath
+func (r *stageRefs) references(volumeID, stagingPath string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	refs, err := r.get(volumeID, stagingPath)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(refs.targets))
+	for target := range refs.targets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// forget removes the volume after the staging path is unmounted
+func (r *stageRefs) forget(volumeID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.volumes, volumeID)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUnstagePolicy(t *testing.T) {
+	assert.NoError(t, validateUnstagePolicy(unstagePolicyUnmount))
+	if runtime.GOOS != "windows" {
+		assert.NoError(t, validateUnstagePolicy(unstagePolicyFail))
+	}
+	assert.Equal(t, fmt.Errorf("unstage policy(keep) is not supported, supported policies: [unmount fail]"), validateUnstagePolicy("keep"))
+	assert.Equal(t, fmt.Errorf("unstage policy(defer) is not supported, supported policies: [unmount fail]"), validateUnstagePolicy("defer"))
+}
+
+func TestStageRefs(t *testing.T) {
+	derived := 0
+	refs := newStageRefs(func(stagingPath string) ([]string, error) {
+		derived++
+		if stagingPath == "/error" {
+			return nil, fmt.Errorf("mountinfo is not readable")
+		}
+		return []string{"/pods/pod-0/mount"}, nil
+	})
+
+	// references of an unknown volume are derived once
+	assert.NoError(t, refs.acquire("vol", "/staging", "/pods/pod-1/mount"))
+	targets, err := refs.references("vol", "/staging")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/pods/pod-0/mount", "/pods/pod-1/mount"}, targets)
+	assert.Equal(t, 1, derived)
+
+	refs.release("vol", "/pods/pod-0/mount")
+	targets, err = refs.references("vol", "/staging")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/pods/pod-1/mount"}, targets)
+	// release is idempotent so that unpublish could be retried
+	refs.release("vol", "/pods/pod-1/mount")
+	refs.release("vol", "/pods/pod-1/mount")
+	targets, err = refs.references("vol", "/staging")
+	assert.NoError(t, err)
+	assert.Empty(t, targets)
+	assert.Equal(t, 1, derived)
+
+	// references of a forgotten volume are derived again
+	refs.forget("vol")
+	refs.release("vol", "/pods/pod-1/mount")
+	targets, err = refs.references("vol", "/staging")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/pods/pod-0/mount"}, targets)
+	assert.Equal(t, 2, derived)
+
+	_, err = refs.references("vol", "/error")
+	assert.Equal(t, fmt.Errorf("failed to get mount references of staging path(/error): mountinfo is not readable"), err)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"strconv"
+)
+
+// performance of a standard file share is not provisioned, it's up to the share limit which depends on whether
+// large file shares is enabled on the account, see
+// https://learn.microsoft.com/en-us/azure/storage/files/storage-files-scale-targets#azure-file-share-scale-targets
+const (
+	standardShareMaxIOPSNoLFS            = 1000
+	standardShareMaxThroughputMiBpsNoLFS = 60
+	standardShareMaxIOPS                 = 20000
+	standardShareMaxThroughputMiBps      = 300
+)
+
+// volume context keys of the maximum performance of a standard file share, they are not baselineIOPSKey and
+// throughputMiBpsKey of a premium file share since the performance is not guaranteed
+const (
+	maxIOPSKey            = "maxIOPS"
+	maxThroughputMiBpsKey = "maxThroughputMiBps"
+)
+
+// standardSharePerformance is the maximum performance of a standard file share
+type standardSharePerformance struct {
+	maxIOPS            int
+	maxThroughputMiBps int
+}
+
+// getStandardSharePerformance returns the performance of a standard file share with shareSizeGiB quota, a share
+// larger than 5 TiB is always on an account with large file shares enabled
+func getStandardSharePerformance(shareSizeGiB int, enableLFS bool) standardSharePerformance {
+	if !enableLFS && shareSizeGiB <= maximumStandardShareSizeNoLFS {
+		return standardSharePerformance{
+			maxIOPS:            standardShareMaxIOPSNoLFS,
+			maxThroughputMiBps: standardShareMaxThroughputMiBpsNoLFS,
+		}
+	}
+	return standardSharePerformance{
+		maxIOPS:            standardShareMaxIOPS,
+		maxThroughputMiBps: standardShareMaxThroughputMiBps,
+	}
+}
+
+// setStandardSharePerformance sets maximum performance of the standard file share with shareSizeGiB quota in volume
+// context, enableLFS is whether large file shares is enabled on the account
+func setStandardSharePerformance(volumeContext map[string]string, shareSizeGiB int, enableLFS bool) {
+	perf := getStandardSharePerformance(shareSizeGiB, enableLFS)
+	volumeContext[shareProvisionedGiBKey] = strconv.Itoa(shareSizeGiB)
+	volumeContext[maxIOPSKey] = strconv.Itoa(perf.maxIOPS)
+	volumeContext[maxThroughputMiBpsKey] = strconv.Itoa(perf.maxThroughputMiBps)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStandardSharePerformance(t *testing.T) {
+	tests := []struct {
+		shareSizeGiB int
+		enableLFS    bool
+		expected     standardSharePerformance
+	}{
+		{
+			shareSizeGiB: 100,
+			expected:     standardSharePerformance{maxIOPS: 1000, maxThroughputMiBps: 60},
+		},
+		{
+			shareSizeGiB: 5120,
+			expected:     standardSharePerformance{maxIOPS: 1000, maxThroughputMiBps: 60},
+		},
+		{
+			shareSizeGiB: 100,
+			enableLFS:    true,
+			expected:     standardSharePerformance{maxIOPS: 20000, maxThroughputMiBps: 300},
+		},
+		{
+			shareSizeGiB: 5121,
+			expected:     standardSharePerformance{maxIOPS: 20000, maxThroughputMiBps: 300},
+		},
+		{
+			shareSizeGiB: 102400,
+			enableLFS:    true,
+			expected:     standardSharePerformance{maxIOPS: 20000, maxThroughputMiBps: 300},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getStandardSharePerformance(test.shareSizeGiB, test.enableLFS), "shareSizeGiB: %d, enableLFS: %v", test.shareSizeGiB, test.enableLFS)
+	}
+}
+
+func TestSetStandardSharePerformance(t *testing.T) {
+	volumeContext := map[string]string{skuNameField: "Standard_LRS"}
+	setStandardSharePerformance(volumeContext, 100, false)
+	assert.Equal(t, map[string]string{
+		skuNameField:           "Standard_LRS",
+		shareProvisionedGiBKey: "100",
+		maxIOPSKey:             "1000",
+		maxThroughputMiBpsKey:  "60",
+	}, volumeContext)
+}
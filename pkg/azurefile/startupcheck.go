@@ -0,0 +1,77 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	startupCheckTimeout = 1 * time.Minute
+	// permission required by listing storage accounts in startup check
+	storageAccountReadPermission = "Microsoft.Storage/storageAccounts/read"
+)
+
+// runStartupCheck validates the driver identity by a harmless ARM read on start,
+// driver exits on failure only if fatal is true
+func (d *Driver) runStartupCheck(fatal bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), startupCheckTimeout)
+	defer cancel()
+	if err := d.checkARMPermissions(ctx); err != nil {
+		if fatal {
+			klog.Fatalf("startup check failed: %v", err)
+		}
+		klog.Errorf("startup check failed: %v", err)
+		return
+	}
+	klog.V(2).Infof("startup check passed")
+}
+
+// checkARMPermissions lists storage accounts in the resource group of cloud config
+func (d *Driver) checkARMPermissions(ctx context.Context) error {
+	if d.cloud == nil || d.cloud.StorageAccountClient == nil {
+		return fmt.Errorf("storage account client is not initialized, check cloud config")
+	}
+	identity := getIdentityDescription(d.cloud.AADClientID, d.cloud.UseManagedIdentityExtension, d.cloud.UserAssignedIdentityID)
+	accounts, rerr := d.cloud.StorageAccountClient.ListByResourceGroup(ctx, d.cloud.SubscriptionID, d.cloud.ResourceGroup)
+	if rerr != nil {
+		if isAuthorizationError(rerr.Error()) {
+			return fmt.Errorf("%s does not have permission(%s) on resource group(%s) in subscription(%s): %v", identity, storageAccountReadPermission, d.cloud.ResourceGroup, d.cloud.SubscriptionID, rerr.Error())
+		}
+		return fmt.Errorf("failed to list storage accounts in resource group(%s) in subscription(%s) with %s: %v", d.cloud.ResourceGroup, d.cloud.SubscriptionID, identity, rerr.Error())
+	}
+	klog.V(2).Infof("%s listed %d storage accounts in resource group(%s) in subscription(%s)", identity, len(accounts), d.cloud.ResourceGroup, d.cloud.SubscriptionID)
+	return nil
+}
+
+// getIdentityDescription returns the identity configured in cloud config which is used in log messages
+func getIdentityDescription(aadClientID string, useManagedIdentity bool, userAssignedIdentityID string) string {
+	switch {
+	case useManagedIdentity && userAssignedIdentityID != "":
+		return fmt.Sprintf("user-assigned identity(%s)", userAssignedIdentityID)
+	case useManagedIdentity:
+		return "system-assigned identity"
+	case aadClientID != "":
+		return fmt.Sprintf("service principal(%s)", aadClientID)
+	default:
+		return "identity"
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestCheckARMPermissions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		desc        string
+		listErr     *retry.Error
+		expectedErr string
+	}{
+		{
+			desc: "storage accounts are listed",
+		},
+		{
+			desc:        "identity is not authorized",
+			listErr:     &retry.Error{RawError: fmt.Errorf("StatusCode=403 Code=\"AuthorizationFailed\"")},
+			expectedErr: "user-assigned identity(clientID) does not have permission(Microsoft.Storage/storageAccounts/read) on resource group(rg) in subscription(subsID)",
+		},
+		{
+			desc:        "other error",
+			listErr:     &retry.Error{RawError: fmt.Errorf("test error")},
+			expectedErr: "failed to list storage accounts in resource group(rg) in subscription(subsID) with user-assigned identity(clientID)",
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.cloud.SubscriptionID = "subsID"
+		d.cloud.ResourceGroup = "rg"
+		d.cloud.UseManagedIdentityExtension = true
+		d.cloud.UserAssignedIdentityID = "clientID"
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d.cloud.StorageAccountClient = mockStorageAccountsClient
+		mockStorageAccountsClient.EXPECT().ListByResourceGroup(gomock.Any(), "subsID", "rg").Return([]storage.Account{{}}, test.listErr)
+
+		err := d.checkARMPermissions(context.Background())
+		if test.expectedErr == "" {
+			assert.NoError(t, err, test.desc)
+		} else {
+			assert.Error(t, err, test.desc)
+			assert.Contains(t, err.Error(), test.expectedErr, test.desc)
+		}
+	}
+
+	d := NewFakeDriver()
+	d.cloud.StorageAccountClient = nil
+	assert.Error(t, d.checkARMPermissions(context.Background()))
+}
+
+func TestGetIdentityDescription(t *testing.T) {
+	tests := []struct {
+		aadClientID            string
+		useManagedIdentity     bool
+		userAssignedIdentityID string
+		expected               string
+	}{
+		{expected: "identity"},
+		{aadClientID: "spID", expected: "service principal(spID)"},
+		{useManagedIdentity: true, expected: "system-assigned identity"},
+		{useManagedIdentity: true, userAssignedIdentityID: "clientID", expected: "user-assigned identity(clientID)"},
+	}
+	for _, test := range tests {
+		result := getIdentityDescription(test.aadClientID, test.useManagedIdentity, test.userAssignedIdentityID)
+		assert.Equal(t, test.expected, result)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maximum number of tags on one storage account
+	maxAccountTagNum = 50
+	// characters which are not allowed in a tag name
+	invalidTagKeyChars = "<>%&\\?/"
+	// only the holder of this lease syncs tags
+	tagSyncLeaseName = "azurefile-csi-tag-sync"
+)
+
+// accountTagSyncKey identifies a storage account in tag sync
+type accountTagSyncKey struct {
+	subsID        string
+	resourceGroup string
+	accountName   string
+}
+
+// runTagSync syncs PVC labels to storage account tags periodically, only in the replica holding the tag sync lease
+// since tags are updated by read-modify-write
+func (d *Driver) runTagSync(interval time.Duration) {
+	if d.cloud.KubeClient == nil {
+		klog.Warningf("KubeClient is nil, tag sync is disabled")
+		return
+	}
+	identity := getLeaseHolderIdentity()
+	klog.V(2).Infof("start syncing PVC labels to storage account tags every %v, holder identity(%s)", interval, identity)
+	go wait.Forever(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		leader, err := d.acquireLease(ctx, d.leaseNamespace, tagSyncLeaseName, identity, 2*interval)
+		if err != nil {
+			klog.Warningf("failed to acquire tag sync lease: %v", err)
+			return
+		}
+		if !leader {
+			klog.V(4).Infof("skip tag sync since lease(%s/%s) is held by another replica", d.leaseNamespace, tagSyncLeaseName)
+			return
+		}
+		if err := d.syncAccountTags(ctx); err != nil {
+			klog.Warningf("syncAccountTags failed with error: %v", err)
+		}
+	}, interval)
+}
+
+// syncAccountTags reads PVC labels of all PVs provisioned by this driver and adds them as tags on the storage
+// accounts owned by the driver, PVs and PVCs are listed once, a failure on one account would not block other accounts
+func (d *Driver) syncAccountTags(ctx context.Context) error {
+	if d.cloud.KubeClient == nil {
+		return fmt.Errorf("KubeClient is nil")
+	}
+	pvs, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list persistent volumes: %v", err)
+	}
+	pvcs, err := d.cloud.KubeClient.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list persistent volume claims: %v", err)
+	}
+	pvcLabels := make(map[string]map[string]string, len(pvcs.Items))
+	for _, pvc := range pvcs.Items {
+		pvcLabels[pvc.Namespace+"/"+pvc.Name] = pvc.Labels
+	}
+
+	accountTags := make(map[accountTagSyncKey]map[string]string)
+	conflictTags := make(map[accountTagSyncKey]map[string]bool)
+	// user-assigned identities managing the storage accounts
+	accountClientIDs := make(map[accountTagSyncKey]string)
+	var errs []error
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		resourceGroup, accountName, _, _, _, subsID, err := GetFileShareInfo(pv.Spec.CSI.VolumeHandle)
+		if err != nil || accountName == "" {
+			klog.V(4).Infof("skip tag sync on pv(%s): %v", pv.Name, err)
+			continue
+		}
+		if err := d.bindAccountToCloudConfig(getCloudConfigName(pv.Spec.CSI.VolumeHandle), accountName); err != nil {
+			klog.V(4).Infof("skip tag sync on pv(%s): %v", pv.Name, err)
+			continue
+		}
+		if resourceGroup == "" {
+			resourceGroup = d.cloud.ResourceGroup
+		}
+		if subsID == "" {
+			subsID = d.cloud.SubscriptionID
+		}
+
+		claim := pv.Spec.ClaimRef.Namespace + "/" + pv.Spec.ClaimRef.Name
+		labels, ok := pvcLabels[claim]
+		if !ok {
+			klog.V(4).Infof("skip tag sync on pv(%s) since pvc(%s) is not found", pv.Name, claim)
+			continue
+		}
+
+		key := accountTagSyncKey{subsID: subsID, resourceGroup: resourceGroup, accountName: accountName}
+		if accountTags[key] == nil {
+			accountTags[key] = make(map[string]string)
+			conflictTags[key] = make(map[string]bool)
+			if h, err := parseVolumeHandle(pv.Spec.CSI.VolumeHandle); err == nil {
+				accountClientIDs[key] = h.clientID
+			}
+		}
+		for k, v := range labels {
+			if strings.ContainsAny(k, invalidTagKeyChars) {
+				klog.V(4).Infof("skip label(%s) on pvc(%s) since it's not a valid tag name", k, claim)
+				continue
+			}
+			if existing, ok := accountTags[key][k]; ok && existing != v {
+				conflictTags[key][k] = true
+			}
+			accountTags[key][k] = v
+		}
+	}
+
+	for key, tags := range accountTags {
+		for k := range conflictTags[key] {
+			klog.Warningf("skip tag(%s) on account(%s) since PVCs sharing this account have different label values", k, key.accountName)
+			delete(tags, k)
+		}
+		if err := d.updateAccountTags(withClientID(ctx, accountClientIDs[key]), key, tags); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// updateAccountTags adds the missing or changed tags on the storage account, accounts which are not owned by the
+// driver are skipped, so tags of accounts specified in storage classes or static PVs are never changed
+func (d *Driver) updateAccountTags(ctx context.Context, key accountTagSyncKey, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	cloud := d.getCloudByAccount(ctx, key.subsID, key.resourceGroup, key.accountName)
+	if cloud.StorageAccountClient == nil {
+		return fmt.Errorf("StorageAccountClient is nil")
+	}
+	account, rerr := cloud.StorageAccountClient.GetProperties(ctx, key.subsID, key.resourceGroup, key.accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get account(%s) in resource group(%s): %v", key.accountName, key.resourceGroup, rerr.Error())
+	}
+	if !isAccountOwned(account.Tags, d.shareNameNamespace) {
+		klog.V(4).Infof("skip tag sync on account(%s) in resource group(%s) since it's not owned by the driver", key.accountName, key.resourceGroup)
+		return nil
+	}
+
+	newTags := getTagsToUpdate(account.Tags, tags)
+	if len(newTags) == 0 {
+		return nil
+	}
+	klog.V(2).Infof("update tags(%v) on account(%s) in resource group(%s)", newTags, key.accountName, key.resourceGroup)
+	if err := d.addStorageAccountTags(ctx, key.subsID, key.resourceGroup, key.accountName, newTags); err != nil {
+		return fmt.Errorf("failed to update tags on account(%s) in resource group(%s): %v", key.accountName, key.resourceGroup, err)
+	}
+	return nil
+}
+
+// getTagsToUpdate returns the tags which are missing or different in existing tags,
+// new tags exceeding maxAccountTagNum are skipped
+func getTagsToUpdate(existingTags map[string]*string, tags map[string]string) map[string]*string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tagNum := len(existingTags)
+	result := make(map[string]*string)
+	for _, k := range keys {
+		v := tags[k]
+		existing, ok := existingTags[k]
+		if ok && existing != nil && *existing == v {
+			continue
+		}
+		if !ok {
+			if tagNum >= maxAccountTagNum {
+				klog.Warningf("skip tag(%s) since the number of tags would exceed the limit(%d)", k, maxAccountTagNum)
+				continue
+			}
+			tagNum++
+		}
+		value := v
+		result[k] = &value
+	}
+	return result
+}
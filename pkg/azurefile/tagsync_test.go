@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestGetTagsToUpdate(t *testing.T) {
+	value1 := "value1"
+	value2 := "value2"
+	fullTags := make(map[string]*string)
+	for i := 0; i < maxAccountTagNum; i++ {
+		fullTags[fmt.Sprintf("key%d", i)] = &value1
+	}
+
+	tests := []struct {
+		desc         string
+		existingTags map[string]*string
+		tags         map[string]string
+		expected     map[string]*string
+	}{
+		{
+			desc:     "no tags",
+			expected: map[string]*string{},
+		},
+		{
+			desc:         "tags are already in sync",
+			existingTags: map[string]*string{"key1": &value1},
+			tags:         map[string]string{"key1": "value1"},
+			expected:     map[string]*string{},
+		},
+		{
+			desc:         "new and changed tags",
+			existingTags: map[string]*string{"key1": &value1, "key2": &value1},
+			tags:         map[string]string{"key1": "value1", "key2": "value2", "key3": "value1"},
+			expected:     map[string]*string{"key2": &value2, "key3": &value1},
+		},
+		{
+			desc:         "new tags exceeding the limit are skipped, changed tags are still updated",
+			existingTags: fullTags,
+			tags:         map[string]string{"key0": "value2", "newkey": "value1"},
+			expected:     map[string]*string{"key0": &value2},
+		},
+	}
+
+	for _, test := range tests {
+		result := getTagsToUpdate(test.existingTags, test.tags)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("test[%s]: unexpected output: %v, expected result: %v", test.desc, result, test.expected)
+		}
+	}
+}
+
+func TestSyncAccountTags(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newPV := func(name, volumeHandle, pvcName string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{Driver: fakeDriverName, VolumeHandle: volumeHandle},
+				},
+				ClaimRef: &v1.ObjectReference{Namespace: "default", Name: pvcName},
+			},
+		}
+	}
+	newPVC := func(name string, labels map[string]string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: labels},
+		}
+	}
+
+	d := NewFakeDriver()
+	d.cloud.KubeClient = fake.NewSimpleClientset(
+		newPV("pv1", "rg#account1#share1###default#subsID", "pvc1"),
+		newPV("pv2", "rg#account1#share2###default#subsID", "pvc2"),
+		newPV("pv3", "rg#account2#share3###default#subsID", "pvc3"),
+		newPV("pv4", "rg#account3#share4###default#subsID", "pvc-missing"),
+		newPV("pv5", "rg#unowned#share5###default#subsID", "pvc5"),
+		newPVC("pvc1", map[string]string{"owner": "team1", "costcenter": "a", "app.kubernetes.io/name": "app"}),
+		newPVC("pvc2", map[string]string{"owner": "team1", "costcenter": "b"}),
+		newPVC("pvc3", map[string]string{"owner": "team2"}),
+		newPVC("pvc5", map[string]string{"owner": "team3"}),
+	)
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	// only accounts owned by the driver are tagged, pv of a missing pvc is skipped
+	owned := storage.Account{Tags: getAccountOwnershipTags(d.shareNameNamespace)}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account1").Return(owned, nil).Times(2)
+	mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "subsID", "rg", "account1", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, subsID, resourceGroup, accountName string, parameters storage.AccountUpdateParameters) *retry.Error {
+			assert.Equal(t, "team1", pointer.StringDeref(parameters.Tags["owner"], ""))
+			_, ok := parameters.Tags["costcenter"]
+			assert.False(t, ok)
+			return nil
+		})
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "account2").Return(storage.Account{}, &retry.Error{RawError: fmt.Errorf("test error")})
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "subsID", "rg", "unowned").Return(storage.Account{}, nil)
+
+	err := d.syncAccountTags(context.Background())
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "pvc-missing")
+	assert.Contains(t, err.Error(), "failed to get account(account2) in resource group(rg)")
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const tracerName = "sigs.k8s.io/azurefile-csi-driver"
+
+// span attributes, only identifiers of Azure resources are recorded, never secrets, mount options or volume context
+var (
+	volumeIDAttribute      = attribute.Key("csi.volume.id")
+	requestNameAttribute   = attribute.Key("csi.name")
+	accountNameAttribute   = attribute.Key("azure.storage.account")
+	resourceGroupAttribute = attribute.Key("azure.resource_group")
+	shareNameAttribute     = attribute.Key("azure.storage.file_share")
+)
+
+// initTracing exports spans of the driver to OTLP gRPC endpoint, OTEL_EXPORTER_OTLP_* environment variables
+// (e.g. OTEL_EXPORTER_OTLP_INSECURE) are respected, endpoint overrides OTEL_EXPORTER_OTLP_ENDPOINT if not empty.
+// The returned function flushes pending spans and stops the exporter.
+func initTracing(ctx context.Context, endpoint, serviceName string) (func(context.Context) error, error) {
+	var opts []otlptracegrpc.Option
+	if endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(endpoint))
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// metadataCarrier adapts gRPC metadata to propagation.TextMapCarrier
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// traceGRPC creates a server span for each CSI RPC as a child of the trace context sent by the caller,
+// e.g. external-provisioner. Requests and error messages are not recorded since they may contain secrets.
+func traceGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	}
+	service, method := splitFullMethod(info.FullMethod)
+	attrs := []attribute.KeyValue{semconv.RPCSystemGRPC, semconv.RPCServiceKey.String(service), semconv.RPCMethodKey.String(method)}
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		attrs = append(attrs, volumeIDAttribute.String(r.GetVolumeId()))
+	}
+	if r, ok := req.(interface{ GetName() string }); ok && r.GetName() != "" {
+		attrs = append(attrs, requestNameAttribute.String(r.GetName()))
+	}
+	ctx, span := otel.Tracer(tracerName).Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+	defer span.End()
+
+	resp, err := handler(ctx, req)
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	if err != nil {
+		span.SetStatus(otelcodes.Error, code.String())
+	}
+	return resp, err
+}
+
+// splitFullMethod splits gRPC method name, e.g. /csi.v1.Controller/CreateVolume, into service and method
+func splitFullMethod(fullMethod string) (string, string) {
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return strings.TrimPrefix(fullMethod[:i], "/"), fullMethod[i+1:]
+	}
+	return "", fullMethod
+}
+
+// startSpan starts a child span of the span in ctx for an ARM or data plane operation,
+// the span is not recorded if tracing is not enabled
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span started by startSpan, error message is not recorded since it may contain secrets
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(otelcodes.Error, "")
+	}
+	span.End()
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// spanRecorder records ended spans
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (r *spanRecorder) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {}
+func (r *spanRecorder) OnEnd(s sdktrace.ReadOnlySpan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
+func (r *spanRecorder) Shutdown(ctx context.Context) error   { return nil }
+func (r *spanRecorder) ForceFlush(ctx context.Context) error { return nil }
+
+// setupTestTracing records spans of the global tracer provider until the returned function is called
+func setupTestTracing() (*spanRecorder, func()) {
+	origProvider, origPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	recorder := &spanRecorder{}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder, func() {
+		otel.SetTracerProvider(origProvider)
+		otel.SetTextMapPropagator(origPropagator)
+	}
+}
+
+func TestTraceGRPC(t *testing.T) {
+	recorder, cleanup := setupTestTracing()
+	defer cleanup()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	parentSpanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId: "rg#account#share#",
+		Secrets:  map[string]string{"azurestorageaccountkey": "secret-key"},
+		VolumeContext: map[string]string{
+			"storageaccountkey": "secret-key",
+		},
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+
+	var handlerSpan trace.SpanContext
+	_, err := traceGRPC(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		_, span := startSpan(ctx, "GetStorageAccesskey", accountNameAttribute.String("account"))
+		endSpan(span, fmt.Errorf("failed with key secret-key"))
+		return nil, status.Errorf(codes.Internal, "mount failed with key secret-key")
+	})
+	assert.Error(t, err)
+
+	assert.Len(t, recorder.spans, 2)
+	child, server := recorder.spans[0], recorder.spans[1]
+	assert.Equal(t, "/csi.v1.Node/NodeStageVolume", server.Name())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, traceID, server.SpanContext().TraceID())
+	assert.Equal(t, parentSpanID, server.Parent().SpanID())
+	assert.Equal(t, handlerSpan.SpanID(), server.SpanContext().SpanID())
+	assert.Equal(t, otelcodes.Error, server.Status().Code)
+	assert.Contains(t, server.Attributes(), attribute.String("rpc.method", "NodeStageVolume"))
+	assert.Contains(t, server.Attributes(), attribute.String("csi.volume.id", "rg#account#share#"))
+	assert.Contains(t, server.Attributes(), attribute.Int("rpc.grpc.status_code", int(codes.Internal)))
+
+	assert.Equal(t, "GetStorageAccesskey", child.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), child.Parent().SpanID())
+	assert.Equal(t, otelcodes.Error, child.Status().Code)
+
+	// secrets must never be recorded
+	for _, span := range recorder.spans {
+		assert.False(t, strings.Contains(span.Status().Description, "secret-key"), "span(%s) status: %s", span.Name(), span.Status().Description)
+		for _, attr := range span.Attributes() {
+			assert.False(t, strings.Contains(attr.Value.Emit(), "secret-key"), "span(%s) attribute: %s", span.Name(), attr.Key)
+		}
+		assert.Empty(t, span.Events(), "span(%s) events", span.Name())
+	}
+}
+
+func TestSplitFullMethod(t *testing.T) {
+	service, method := splitFullMethod("/csi.v1.Controller/CreateVolume")
+	assert.Equal(t, "csi.v1.Controller", service)
+	assert.Equal(t, "CreateVolume", method)
+	service, method = splitFullMethod("CreateVolume")
+	assert.Equal(t, "", service)
+	assert.Equal(t, "CreateVolume", method)
+}
@@ -17,8 +17,12 @@ limitations under the License.
 package azurefile
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,6 +31,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume"
+	"k8s.io/kubernetes/pkg/volume/util"
 )
 
 const (
@@ -34,6 +39,9 @@ const (
 	tagKeyValueDelimiter = "="
 )
 
+// user or group name in uid or gid mount option, see NAME_REGEX in adduser.conf
+var mountOwnerNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]{0,31}\$?$`)
+
 // lockMap used to lock on entries
 type lockMap struct {
 	sync.Mutex
@@ -123,6 +131,41 @@ func isSupportedFsType(fsType string) bool {
 	return false
 }
 
+// isFileShareFsType returns true if fsType is mounted as a file share instead of a vhd disk
+func isFileShareFsType(fsType string) bool {
+	return fsType == cifs || fsType == smb || fsType == nfs
+}
+
+// resolveProtocol returns the protocol of the volume from fsType and protocol, protocol takes precedence and fsType
+// must be compatible with it, fsType is only used to infer the protocol if protocol is empty:
+// cifs and smb are SMB, nfs is NFS, and disk fsTypes(ext4, xfs etc.) are formatted on a vhd disk stored in a file
+// share, which is SMB unless protocol is nfs. Empty protocol is returned for SMB if protocol is empty.
+func resolveProtocol(fsType, protocol string) (string, error) {
+	switch {
+	case fsType == "":
+		return protocol, nil
+	case fsType == cifs || fsType == smb:
+		if protocol == "" || protocol == smb {
+			return protocol, nil
+		}
+	case isDiskFsType(fsType):
+		// vhd disk could not be stored in a blob container
+		if protocol != blobNFS {
+			return protocol, nil
+		}
+	case fsType == nfs:
+		if protocol == "" {
+			return nfs, nil
+		}
+		if isNFSProtocol(protocol) {
+			return protocol, nil
+		}
+	default:
+		return "", fmt.Errorf("fsType(%s) is not supported, supported fsType list: %v", fsType, supportedFsTypeList)
+	}
+	return "", fmt.Errorf("fsType(%s) is not supported with protocol(%s)", fsType, protocol)
+}
+
 func isRetriableError(err error) bool {
 	if err != nil {
 		for _, v := range retriableErrors {
@@ -134,13 +177,60 @@ func isRetriableError(err error) bool {
 	return false
 }
 
-func sleepIfThrottled(err error, sleepSec int) {
-	if strings.Contains(strings.ToLower(err.Error()), strings.ToLower(tooManyRequests)) || strings.Contains(strings.ToLower(err.Error()), clientThrottled) {
+// isAuthorizationError returns true if the identity is not authorized to perform the operation
+func isAuthorizationError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(err.Error(), authorizationFailed) || strings.Contains(err.Error(), statusCodeForbidden)
+}
+
+// isAuthenticationError returns true if account key is rejected by data plane API or SMB mount
+func isAuthenticationError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(err.Error(), authenticationFailed) || strings.Contains(strings.ToLower(err.Error()), permissionDenied)
+}
+
+// isNotFoundError returns true if the file share, storage account or resource group does not exist
+func isNotFoundError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, v := range []string{statusCodeNotFound, httpCodeNotFound, fileShareNotFound, resourceGroupNotFound} {
+		if strings.Contains(err.Error(), v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isThrottlingError returns true if the request is throttled by ARM, by data plane API or by client side rate limiter
+func isThrottlingError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errMsg := strings.ToLower(err.Error())
+	return strings.Contains(errMsg, strings.ToLower(tooManyRequests)) || strings.Contains(errMsg, clientThrottled) || strings.Contains(errMsg, strings.ToLower(serverBusy))
+}
+
+// sleepIfThrottled sleeps sleepSec seconds if err is a throttling error, returns early if ctx is done
+func sleepIfThrottled(ctx context.Context, err error, sleepSec int) {
+	if isThrottlingError(err) {
 		klog.Warningf("sleep %d more seconds, waiting for throttling complete", sleepSec)
-		time.Sleep(time.Duration(sleepSec) * time.Second)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(sleepSec) * time.Second):
+		}
 	}
 }
 
+// isContextError returns true if err is caused by context cancellation or deadline
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 func useDataPlaneAPI(volContext map[string]string) bool {
 	useDataPlaneAPI := false
 	for k, v := range volContext {
@@ -241,6 +331,33 @@ func SetVolumeOwnership(path, gid, policy string) error {
 	return volume.SetVolumeOwnership(&VolumeMounter{path: path}, &gidInt64, &fsGroupChangePolicy, nil)
 }
 
+// setVolumeRootOwnership sets gid and group rw permissions with setgid bit only on the root directory in path,
+// the same as SetVolumeOwnership applies to each file, files and directories under path are not changed
+func setVolumeRootOwnership(path, gid string) error {
+	id, err := strconv.Atoi(gid)
+	if err != nil {
+		return fmt.Errorf("convert %s to int failed with %v", gid, err)
+	}
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Lchown(path, -1, id); err != nil {
+		return err
+	}
+	mode := info.Mode() | 0660
+	if info.IsDir() {
+		mode |= os.ModeSetgid | 0110
+	}
+	if mode == info.Mode() {
+		return nil
+	}
+	return os.Chmod(path, mode)
+}
+
 // setKeyValueInMap set key/value pair in map
 // key in the map is case insensitive, if key already exists, overwrite existing value
 func setKeyValueInMap(m map[string]string, key, value string) {
@@ -265,3 +382,397 @@ func replaceWithMap(str string, m map[string]string) string {
 	}
 	return str
 }
+
+// getNFSMountOptions validates nfs version specified by vers or nfsvers and minorversion in mount options, vers=4 is
+// mounted with minorversion=1 since Azure Files only supports NFS 4.1, return mount options with the default nfs mount
+// options which are not set in mount options and the effective nfs version
+func getNFSMountOptions(mountFlags []string) ([]string, string, error) {
+	var version, minor string
+	var secSet bool
+	var mountOptions []string
+	for _, mountFlag := range mountFlags {
+		for _, option := range strings.Split(mountFlag, ",") {
+			if isOwnerMountOption(option) {
+				return nil, "", getNFSOwnerMountOptionError(option)
+			}
+			kv := strings.SplitN(strings.TrimSpace(option), "=", 2)
+			if len(kv) == 2 {
+				switch strings.ToLower(kv[0]) {
+				case vers, nfsvers:
+					version = strings.TrimSpace(kv[1])
+					continue
+				case minorVersion:
+					minor = strings.TrimSpace(kv[1])
+					continue
+				case sec:
+					secSet = true
+				}
+			}
+			if option != "" {
+				mountOptions = append(mountOptions, option)
+			}
+		}
+	}
+
+	if version == "" {
+		version = defaultNFSVersion
+	}
+	supported := false
+	for _, v := range supportedNFSVersionList {
+		if version == v {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, "", fmt.Errorf("nfs version(%s) is not supported by Azure Files, supported nfs version list: %v", version, supportedNFSVersionList)
+	}
+	if minor != "" {
+		if version != "4" && version != "4."+minor {
+			return nil, "", fmt.Errorf("%s=%s conflicts with nfs version(%s) in mount options", minorVersion, minor, version)
+		}
+		version = "4." + minor
+		if version != defaultNFSVersion {
+			return nil, "", fmt.Errorf("nfs version(%s) is not supported by Azure Files, supported nfs version list: %v", version, supportedNFSVersionList)
+		}
+	}
+	if version == "4" {
+		version = defaultNFSVersion
+	}
+
+	defaultOptions := "vers=4,minorversion=1"
+	if !secSet {
+		defaultOptions += ",sec=sys"
+	}
+	return util.JoinMountOptions(mountOptions, []string{defaultOptions}), version, nil
+}
+
+// parseMountOwnerID validates the value of uid or gid mount option, which is either a numeric id or a user or
+// group name resolved by mount.cifs on the node
+func parseMountOwnerID(option, value string) error {
+	if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+		if id > maxOwnerID {
+			return fmt.Errorf("invalid %s(%s) in mount options, numeric id should be between 0 and %d", strings.ToLower(option), value, uint64(maxOwnerID))
+		}
+		return nil
+	}
+	if !mountOwnerNameRegexp.MatchString(value) {
+		return fmt.Errorf("invalid %s(%s) in mount options, it should be a numeric id or a user or group name", strings.ToLower(option), value)
+	}
+	return nil
+}
+
+// isOwnerMountOption returns true if option is uid, gid, forceuid or forcegid, which are only honored by SMB mount
+func isOwnerMountOption(option string) bool {
+	key := strings.SplitN(strings.TrimSpace(option), "=", 2)[0]
+	for _, v := range []string{uid, gid, forceUID, forceGID} {
+		if strings.EqualFold(key, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// getNFSOwnerMountOptionError returns the error of uid or gid mount option on NFS mount,
+// file ownership of NFS is stored on the server and could not be overridden by the client
+func getNFSOwnerMountOptionError(option string) error {
+	return fmt.Errorf("mount option(%s) is not supported by NFS since file ownership is stored on the server, set fsGroup in pod security context or change ownership of files in the volume instead", strings.TrimSpace(option))
+}
+
+// getMountPropagation splits mount propagation flag(e.g. rshared) from mount flags, returns error
+// if more than one propagation mode is specified
+func getMountPropagation(mountFlags []string) (string, []string, error) {
+	var propagation string
+	var mountOptions []string
+	for _, mountFlag := range mountFlags {
+		var options []string
+		for _, option := range strings.Split(mountFlag, ",") {
+			option = strings.TrimSpace(option)
+			if option == "" {
+				continue
+			}
+			if !isMountPropagation(option) {
+				options = append(options, option)
+				continue
+			}
+			option = strings.ToLower(option)
+			if propagation != "" && propagation != option {
+				return "", nil, fmt.Errorf("mount propagation(%s) conflicts with mount propagation(%s)", option, propagation)
+			}
+			propagation = option
+		}
+		if len(options) > 0 {
+			mountOptions = append(mountOptions, strings.Join(options, ","))
+		}
+	}
+	return propagation, mountOptions, nil
+}
+
+func isMountPropagation(option string) bool {
+	for _, v := range supportedMountPropagationList {
+		if strings.EqualFold(option, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSharedMountPropagation returns true if mounts under the volume would propagate back to the host
+func isSharedMountPropagation(propagation string) bool {
+	return propagation == "shared" || propagation == "rshared"
+}
+
+// isReadOnlyMount returns whether the bind mount in NodePublishVolume should be read-only: readonly flag in
+// CSI request implies ro, ro in mount flags makes the bind mount read-only as well, rw in mount flags conflicts
+// with ro in mount flags or readonly flag in CSI request
+func isReadOnlyMount(readonly bool, mountFlags []string) (bool, error) {
+	var ro, rw bool
+	for _, mountFlag := range mountFlags {
+		for _, option := range strings.Split(mountFlag, ",") {
+			switch strings.ToLower(strings.TrimSpace(option)) {
+			case "ro":
+				ro = true
+			case "rw":
+				rw = true
+			}
+		}
+	}
+	if ro && rw {
+		return false, fmt.Errorf("mount option(rw) conflicts with mount option(ro)")
+	}
+	if readonly && rw {
+		return false, fmt.Errorf("mount option(rw) conflicts with readonly flag in request, remove rw from mount options of read-only volume")
+	}
+	return readonly || ro, nil
+}
+
+// getSMBMountOptions removes the POSIX mount options which are not supported by Azure Files SMB,
+// validates handletimeout and echo_interval, return mount options with the default smb mount options,
+// mfsymlinks is appended by default unless enableMfsymlinks is false, handletimeout and echo_interval
+// are appended with driver defaults if they are not set and driver defaults are not 0
+func getSMBMountOptions(mountFlags []string, enableMfsymlinks bool, defaultHandleTimeout, defaultEchoInterval int) ([]string, error) {
+	var mountOptions []string
+	var handleTimeoutSet, echoIntervalSet, uidSet, gidSet, forceUIDSet, forceGIDSet bool
+	for _, mountFlag := range mountFlags {
+		var options []string
+		for _, option := range strings.Split(mountFlag, ",") {
+			option = strings.TrimSpace(option)
+			if option == "" {
+				continue
+			}
+			if isUnsupportedSMBMountOption(option) {
+				klog.Warningf("mount option(%s) is not supported by Azure Files SMB, use %s to create symlinks instead, skip it", option, mfsymlinks)
+				continue
+			}
+			if !enableMfsymlinks && strings.EqualFold(option, mfsymlinks) {
+				return nil, fmt.Errorf("mount option(%s) conflicts with %s(false)", mfsymlinks, enableMfsymlinksField)
+			}
+			if kv := strings.SplitN(option, "=", 2); len(kv) == 2 {
+				switch strings.ToLower(kv[0]) {
+				case handleTimeout:
+					if _, err := parseSMBHandleTimeout(kv[1]); err != nil {
+						return nil, err
+					}
+					handleTimeoutSet = true
+				case echoInterval:
+					if _, err := parseSMBEchoInterval(kv[1]); err != nil {
+						return nil, err
+					}
+					echoIntervalSet = true
+				case uid, gid:
+					if err := parseMountOwnerID(kv[0], kv[1]); err != nil {
+						return nil, err
+					}
+					uidSet = uidSet || strings.EqualFold(kv[0], uid)
+					gidSet = gidSet || strings.EqualFold(kv[0], gid)
+				}
+			}
+			forceUIDSet = forceUIDSet || strings.EqualFold(option, forceUID)
+			forceGIDSet = forceGIDSet || strings.EqualFold(option, forceGID)
+			options = append(options, option)
+		}
+		if len(options) > 0 {
+			mountOptions = append(mountOptions, strings.Join(options, ","))
+		}
+	}
+
+	// forceuid and forcegid without uid and gid would make all files owned by root
+	if forceUIDSet && !uidSet {
+		return nil, fmt.Errorf("mount option(%s) requires %s in mount options", forceUID, uid)
+	}
+	if forceGIDSet && !gidSet {
+		return nil, fmt.Errorf("mount option(%s) requires %s in mount options", forceGID, gid)
+	}
+
+	if !handleTimeoutSet && defaultHandleTimeout > 0 {
+		mountOptions = append(mountOptions, fmt.Sprintf("%s=%d", handleTimeout, defaultHandleTimeout))
+	}
+	if !echoIntervalSet && defaultEchoInterval > 0 {
+		mountOptions = append(mountOptions, fmt.Sprintf("%s=%d", echoInterval, defaultEchoInterval))
+	}
+
+	mountOptions = appendDefaultMountOptions(mountOptions)
+	if !enableMfsymlinks {
+		var result []string
+		for _, option := range mountOptions {
+			if option != mfsymlinks {
+				result = append(result, option)
+			}
+		}
+		mountOptions = result
+	}
+	return mountOptions, nil
+}
+
+// parseSMBHandleTimeout parses handletimeout(in milliseconds) mount option
+func parseSMBHandleTimeout(value string) (int, error) {
+	timeout, err := strconv.Atoi(value)
+	if err != nil || timeout < 0 || timeout > maxSMBHandleTimeout {
+		return 0, fmt.Errorf("invalid %s(%s) in mount options, it should be between 0 and %d (milliseconds)", handleTimeout, value, maxSMBHandleTimeout)
+	}
+	return timeout, nil
+}
+
+// parseSMBEchoInterval parses echo_interval(in seconds) mount option
+func parseSMBEchoInterval(value string) (int, error) {
+	interval, err := strconv.Atoi(value)
+	if err != nil || interval < minSMBEchoInterval || interval > maxSMBEchoInterval {
+		return 0, fmt.Errorf("invalid %s(%s) in mount options, it should be between %d and %d (seconds)", echoInterval, value, minSMBEchoInterval, maxSMBEchoInterval)
+	}
+	return interval, nil
+}
+
+// getSMBProbeTimeout returns how long a stat on the smb mount could take before the mount is considered hung,
+// the smb client marks the server unresponsive after two echo intervals, and durable handles are kept
+// for handletimeout when reconnecting
+func getSMBProbeTimeout(mountOptions []string) time.Duration {
+	interval := defaultSMBEchoInterval
+	timeout := 0
+	for _, mountOption := range mountOptions {
+		for _, option := range strings.Split(mountOption, ",") {
+			kv := strings.SplitN(strings.TrimSpace(option), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch strings.ToLower(kv[0]) {
+			case handleTimeout:
+				if v, err := parseSMBHandleTimeout(kv[1]); err == nil {
+					timeout = v
+				}
+			case echoInterval:
+				if v, err := parseSMBEchoInterval(kv[1]); err == nil {
+					interval = v
+				}
+			}
+		}
+	}
+	return time.Duration(2*interval)*time.Second + time.Duration(timeout)*time.Millisecond
+}
+
+// getSMBVersion returns the value of vers option in SMB mount options, empty if not set
+func getSMBVersion(mountOptions []string) string {
+	var version string
+	for _, mountOption := range mountOptions {
+		for _, option := range strings.Split(mountOption, ",") {
+			if kv := strings.SplitN(strings.TrimSpace(option), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], vers) {
+				version = kv[1]
+			}
+		}
+	}
+	return version
+}
+
+// setSMBVersion replaces vers option in SMB mount options with version
+func setSMBVersion(mountOptions []string, version string) []string {
+	var result []string
+	for _, mountOption := range mountOptions {
+		var options []string
+		for _, option := range strings.Split(mountOption, ",") {
+			if kv := strings.SplitN(strings.TrimSpace(option), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], vers) {
+				continue
+			}
+			options = append(options, option)
+		}
+		if len(options) > 0 {
+			result = append(result, strings.Join(options, ","))
+		}
+	}
+	return append(result, fmt.Sprintf("%s=%s", vers, version))
+}
+
+// isSMBSealEnabled returns true if seal option is in SMB mount options
+func isSMBSealEnabled(mountOptions []string) bool {
+	return hasMountOption(mountOptions, seal)
+}
+
+// hasMountOption returns true if the option without value is in mount options, case insensitive
+func hasMountOption(mountOptions []string, name string) bool {
+	for _, mountOption := range mountOptions {
+		for _, option := range strings.Split(mountOption, ",") {
+			if strings.EqualFold(strings.TrimSpace(option), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isSMBEncryptionSupportedVersion returns true if SMB version supports encryption, which is only available
+// since SMB 3.0, empty version means the highest version supported by client and server is negotiated
+func isSMBEncryptionSupportedVersion(version string) bool {
+	return version == "" || strings.EqualFold(version, "default") || strings.HasPrefix(version, "3")
+}
+
+// isSMBNegotiationError returns true if SMB mount fails since client and server could not agree on a dialect,
+// mount.cifs returns EOPNOTSUPP in this case
+func isSMBNegotiationError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errMsg := strings.ToLower(err.Error())
+	return strings.Contains(errMsg, "mount error(95)") || strings.Contains(errMsg, "dialect not supported")
+}
+
+// isMountConnectivityError returns true if the mount fails since the server could not be resolved or reached,
+// authentication failures are not connectivity errors
+func isMountConnectivityError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errMsg := strings.ToLower(err.Error())
+	for _, v := range mountConnectivityErrorList {
+		if strings.Contains(errMsg, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMountOptionInList returns true if the mount option name is in list, case-insensitive
+func isMountOptionInList(name string, list []string) bool {
+	for _, v := range list {
+		if strings.EqualFold(name, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func isUnsupportedSMBMountOption(option string) bool {
+	for _, v := range unsupportedSMBMountOptionList {
+		if strings.EqualFold(option, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// getMetadataValue returns the value of key in metadata, metadata name is case-insensitive
+func getMetadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
@@ -17,10 +17,15 @@ limitations under the License.
 package azurefile
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -237,6 +242,38 @@ func TestIsSupportedFsType(t *testing.T) {
 	}
 }
 
+func TestResolveProtocol(t *testing.T) {
+	tests := []struct {
+		fsType           string
+		protocol         string
+		expectedProtocol string
+		expectedErr      error
+	}{
+		{fsType: "", protocol: "", expectedProtocol: ""},
+		{fsType: "", protocol: nfs, expectedProtocol: nfs},
+		{fsType: cifs, protocol: "", expectedProtocol: ""},
+		{fsType: smb, protocol: smb, expectedProtocol: smb},
+		{fsType: cifs, protocol: nfs, expectedErr: fmt.Errorf("fsType(cifs) is not supported with protocol(nfs)")},
+		{fsType: smb, protocol: blobNFS, expectedErr: fmt.Errorf("fsType(smb) is not supported with protocol(blobnfs)")},
+		{fsType: nfs, protocol: "", expectedProtocol: nfs},
+		{fsType: nfs, protocol: nfs, expectedProtocol: nfs},
+		{fsType: nfs, protocol: blobNFS, expectedProtocol: blobNFS},
+		{fsType: nfs, protocol: smb, expectedErr: fmt.Errorf("fsType(nfs) is not supported with protocol(smb)")},
+		{fsType: ext4, protocol: "", expectedProtocol: ""},
+		{fsType: xfs, protocol: nfs, expectedProtocol: nfs},
+		{fsType: ext3, protocol: blobNFS, expectedErr: fmt.Errorf("fsType(ext3) is not supported with protocol(blobnfs)")},
+		{fsType: "NFS", protocol: "", expectedErr: fmt.Errorf("fsType(NFS) is not supported, supported fsType list: [cifs smb nfs ext4 ext3 ext2 xfs]")},
+		{fsType: "btrfs", protocol: smb, expectedErr: fmt.Errorf("fsType(btrfs) is not supported, supported fsType list: [cifs smb nfs ext4 ext3 ext2 xfs]")},
+	}
+
+	for _, test := range tests {
+		protocol, err := resolveProtocol(test.fsType, test.protocol)
+		if !reflect.DeepEqual(err, test.expectedErr) || protocol != test.expectedProtocol {
+			t.Errorf("resolveProtocol(%s, %s) returned with (%s, %v), not equal to (%s, %v)", test.fsType, test.protocol, protocol, err, test.expectedProtocol, test.expectedErr)
+		}
+	}
+}
+
 func TestIsSupportedFSGroupChangePolicy(t *testing.T) {
 	tests := []struct {
 		policy         string
@@ -318,9 +355,144 @@ func TestIsRetriableError(t *testing.T) {
 	}
 }
 
+func TestIsAuthorizationError(t *testing.T) {
+	tests := []struct {
+		desc         string
+		err          error
+		expectedBool bool
+	}{
+		{
+			desc:         "nil error",
+			err:          nil,
+			expectedBool: false,
+		},
+		{
+			desc:         "authorization failed",
+			err:          errors.New("storage.FileSharesClient#Create: Failure responding to request: StatusCode=403 -- Original Error: autorest/azure: Service returned an error. Status=403 Code=\"AuthorizationFailed\""),
+			expectedBool: true,
+		},
+		{
+			desc:         "other error",
+			err:          errors.New("StatusCode=404"),
+			expectedBool: false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isAuthorizationError(test.err)
+		if result != test.expectedBool {
+			t.Errorf("desc: (%s), input: err(%v), isAuthorizationError returned with bool(%v), not equal to expectedBool(%v)",
+				test.desc, test.err, result, test.expectedBool)
+		}
+	}
+}
+
+func TestIsAuthenticationError(t *testing.T) {
+	tests := []struct {
+		err          error
+		expectedBool bool
+	}{
+		{err: nil, expectedBool: false},
+		{err: errors.New("===== RESPONSE ERROR (ServiceCode=AuthenticationFailed) ====="), expectedBool: true},
+		{err: errors.New("mount error(13): Permission denied"), expectedBool: true},
+		{err: errors.New("StatusCode=404"), expectedBool: false},
+	}
+
+	for _, test := range tests {
+		result := isAuthenticationError(test.err)
+		if result != test.expectedBool {
+			t.Errorf("input: err(%v), isAuthenticationError returned with bool(%v), not equal to expectedBool(%v)", test.err, result, test.expectedBool)
+		}
+	}
+}
+
+func TestIsNotFoundError(t *testing.T) {
+	tests := []struct {
+		desc         string
+		err          error
+		expectedBool bool
+	}{
+		{
+			desc:         "nil error",
+			err:          nil,
+			expectedBool: false,
+		},
+		{
+			desc:         "storage account not found",
+			err:          errors.New("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 404, RawError: storage.AccountsClient#ListKeys: Failure responding to request: Code=\"ResourceNotFound\""),
+			expectedBool: true,
+		},
+		{
+			desc:         "resource group not found",
+			err:          errors.New("Code=\"ResourceGroupNotFound\" Message=\"Resource group 'rg' could not be found.\""),
+			expectedBool: true,
+		},
+		{
+			desc:         "file share not found",
+			err:          errors.New("storage: service returned error: StatusCode=404, ErrorCode=ShareNotFound"),
+			expectedBool: true,
+		},
+		{
+			desc:         "throttled",
+			err:          errors.New("HTTPStatusCode: 429, RawError: StatusCode=429 Code=\"TooManyRequests\""),
+			expectedBool: false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isNotFoundError(test.err)
+		if result != test.expectedBool {
+			t.Errorf("desc: (%s), input: err(%v), isNotFoundError returned with bool(%v), not equal to expectedBool(%v)",
+				test.desc, test.err, result, test.expectedBool)
+		}
+	}
+}
+
+func TestIsThrottlingError(t *testing.T) {
+	tests := []struct {
+		desc         string
+		err          error
+		expectedBool bool
+	}{
+		{
+			desc:         "nil error",
+			err:          nil,
+			expectedBool: false,
+		},
+		{
+			desc:         "too many requests",
+			err:          errors.New("HTTPStatusCode: 429, RawError: StatusCode=429 Code=\"TooManyRequests\""),
+			expectedBool: true,
+		},
+		{
+			desc:         "client throttled",
+			err:          errors.New("azure cloud provider throttled for operation FileShareDelete with reason \"client throttled\""),
+			expectedBool: true,
+		},
+		{
+			desc:         "server busy",
+			err:          errors.New("storage: service returned error: StatusCode=503, ErrorCode=ServerBusy"),
+			expectedBool: true,
+		},
+		{
+			desc:         "not found",
+			err:          errors.New("storage: service returned error: StatusCode=404, ErrorCode=ShareNotFound"),
+			expectedBool: false,
+		},
+	}
+
+	for _, test := range tests {
+		result := isThrottlingError(test.err)
+		if result != test.expectedBool {
+			t.Errorf("desc: (%s), input: err(%v), isThrottlingError returned with bool(%v), not equal to expectedBool(%v)",
+				test.desc, test.err, result, test.expectedBool)
+		}
+	}
+}
+
 func TestSleepIfThrottled(t *testing.T) {
 	start := time.Now()
-	sleepIfThrottled(errors.New("tooManyRequests"), 10)
+	sleepIfThrottled(context.Background(), errors.New("tooManyRequests"), 10)
 	elapsed := time.Since(start)
 	if elapsed.Seconds() < 10 {
 		t.Errorf("Expected sleep time(%d), Actual sleep time(%f)", 10, elapsed.Seconds())
@@ -484,6 +656,65 @@ func TestSetVolumeOwnership(t *testing.T) {
 	}
 }
 
+func TestSetVolumeRootOwnership(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on Windows")
+	}
+	tmpVDir := t.TempDir()
+	if err := os.Chmod(tmpVDir, 0700); err != nil {
+		t.Fatalf("failed to chmod %s: %v", tmpVDir, err)
+	}
+	file := filepath.Join(tmpVDir, "file")
+	if err := os.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", file, err)
+	}
+	gid := strconv.Itoa(os.Getgid())
+
+	tests := []struct {
+		path          string
+		gid           string
+		expectedError error
+	}{
+		{
+			path:          tmpVDir,
+			gid:           "alpha",
+			expectedError: fmt.Errorf("convert %s to int failed with %v", "alpha", `strconv.Atoi: parsing "alpha": invalid syntax`),
+		},
+		{
+			path:          filepath.Join(tmpVDir, "not-exists"),
+			gid:           gid,
+			expectedError: fmt.Errorf("lstat %s: no such file or directory", filepath.Join(tmpVDir, "not-exists")),
+		},
+		{
+			path: tmpVDir,
+			gid:  gid,
+		},
+	}
+
+	for _, test := range tests {
+		err := setVolumeRootOwnership(test.path, test.gid)
+		if !reflect.DeepEqual(fmt.Sprint(err), fmt.Sprint(test.expectedError)) {
+			t.Errorf("unexpected error: %v, expected error: %v", err, test.expectedError)
+		}
+	}
+
+	info, err := os.Stat(tmpVDir)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", tmpVDir, err)
+	}
+	if info.Mode()&os.ModeSetgid == 0 || info.Mode().Perm() != 0770 {
+		t.Errorf("unexpected mode of root directory: %v", info.Mode())
+	}
+	// files under the root directory are not changed
+	info, err = os.Stat(file)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", file, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode of file under root directory: %v", info.Mode())
+	}
+}
+
 func TestSetKeyValueInMap(t *testing.T) {
 	tests := []struct {
 		desc     string
@@ -589,3 +820,460 @@ func TestReplaceWithMap(t *testing.T) {
 		}
 	}
 }
+
+func TestGetNFSMountOptions(t *testing.T) {
+	tests := []struct {
+		desc            string
+		mountFlags      []string
+		expected        []string
+		expectedVersion string
+		expectedErr     error
+	}{
+		{
+			desc:            "no mount flags",
+			expected:        []string{"vers=4,minorversion=1,sec=sys"},
+			expectedVersion: "4.1",
+		},
+		{
+			desc:            "vers=4.1 is supported",
+			mountFlags:      []string{"vers=4.1", "nconnect=4"},
+			expected:        []string{"nconnect=4", "vers=4,minorversion=1,sec=sys"},
+			expectedVersion: "4.1",
+		},
+		{
+			desc:            "nfsvers=4.1 in combined mount flag is supported",
+			mountFlags:      []string{"nfsvers=4.1,actimeo=30"},
+			expected:        []string{"actimeo=30", "vers=4,minorversion=1,sec=sys"},
+			expectedVersion: "4.1",
+		},
+		{
+			desc:            "vers=4 is mounted with minorversion=1",
+			mountFlags:      []string{"vers=4"},
+			expected:        []string{"vers=4,minorversion=1,sec=sys"},
+			expectedVersion: "4.1",
+		},
+		{
+			desc:            "minorversion and sec in mount options are not duplicated",
+			mountFlags:      []string{"nfsvers=4,minorversion=1", "sec=sys"},
+			expected:        []string{"sec=sys", "vers=4,minorversion=1"},
+			expectedVersion: "4.1",
+		},
+		{
+			desc:        "minorversion=0 is not supported",
+			mountFlags:  []string{"vers=4,minorversion=0"},
+			expectedErr: fmt.Errorf("nfs version(4.0) is not supported by Azure Files, supported nfs version list: [4 4.1]"),
+		},
+		{
+			desc:        "minorversion conflicts with vers",
+			mountFlags:  []string{"vers=4.1,minorversion=2"},
+			expectedErr: fmt.Errorf("minorversion=2 conflicts with nfs version(4.1) in mount options"),
+		},
+		{
+			desc:        "vers=3 is not supported",
+			mountFlags:  []string{"vers=3"},
+			expectedErr: fmt.Errorf("nfs version(3) is not supported by Azure Files, supported nfs version list: [4 4.1]"),
+		},
+		{
+			desc:        "nfsvers=4.2 is not supported",
+			mountFlags:  []string{"nfsvers=4.2"},
+			expectedErr: fmt.Errorf("nfs version(4.2) is not supported by Azure Files, supported nfs version list: [4 4.1]"),
+		},
+		{
+			desc:        "uid is not supported",
+			mountFlags:  []string{"nconnect=4,uid=1000"},
+			expectedErr: fmt.Errorf("mount option(uid=1000) is not supported by NFS since file ownership is stored on the server, set fsGroup in pod security context or change ownership of files in the volume instead"),
+		},
+	}
+
+	for _, test := range tests {
+		result, version, err := getNFSMountOptions(test.mountFlags)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("test[%s]: unexpected output: %v, expected result: %v", test.desc, result, test.expected)
+		}
+		if version != test.expectedVersion {
+			t.Errorf("test[%s]: unexpected version: %v, expected version: %v", test.desc, version, test.expectedVersion)
+		}
+	}
+}
+
+func TestIsContextError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{
+			err:      nil,
+			expected: false,
+		},
+		{
+			err:      errors.New("test error"),
+			expected: false,
+		},
+		{
+			err:      context.Canceled,
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("wrapped: %w", context.DeadlineExceeded),
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		result := isContextError(test.err)
+		if result != test.expected {
+			t.Errorf("isContextError(%v) returned with %v, not equal to %v", test.err, result, test.expected)
+		}
+	}
+}
+
+func TestGetMetadataValue(t *testing.T) {
+	value := "archive"
+	tests := []struct {
+		metadata map[string]*string
+		key      string
+		expected string
+	}{
+		{
+			metadata: nil,
+			key:      onDeleteMetadataKey,
+			expected: "",
+		},
+		{
+			metadata: map[string]*string{onDeleteMetadataKey: nil},
+			key:      onDeleteMetadataKey,
+			expected: "",
+		},
+		{
+			metadata: map[string]*string{"CSIOnDelete": &value},
+			key:      onDeleteMetadataKey,
+			expected: "archive",
+		},
+	}
+
+	for _, test := range tests {
+		result := getMetadataValue(test.metadata, test.key)
+		if result != test.expected {
+			t.Errorf("getMetadataValue(%v, %s) returned with %v, not equal to %v", test.metadata, test.key, result, test.expected)
+		}
+	}
+}
+
+func TestGetMountPropagation(t *testing.T) {
+	tests := []struct {
+		desc                 string
+		mountFlags           []string
+		expectedPropagation  string
+		expectedMountOptions []string
+		expectedErr          error
+	}{
+		{
+			desc:                 "no mount propagation",
+			mountFlags:           []string{"dir_mode=0777", "actimeo=30"},
+			expectedMountOptions: []string{"dir_mode=0777", "actimeo=30"},
+		},
+		{
+			desc:                 "mount propagation in mount flags",
+			mountFlags:           []string{"dir_mode=0777,RShared", "rshared"},
+			expectedPropagation:  "rshared",
+			expectedMountOptions: []string{"dir_mode=0777"},
+		},
+		{
+			desc:        "conflicting mount propagation",
+			mountFlags:  []string{"rshared", "rslave"},
+			expectedErr: fmt.Errorf("mount propagation(rslave) conflicts with mount propagation(rshared)"),
+		},
+	}
+
+	for _, test := range tests {
+		propagation, mountOptions, err := getMountPropagation(test.mountFlags)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+		if propagation != test.expectedPropagation || !reflect.DeepEqual(mountOptions, test.expectedMountOptions) {
+			t.Errorf("test[%s]: unexpected propagation: %s, mount options: %v", test.desc, propagation, mountOptions)
+		}
+	}
+}
+
+func TestGetSMBMountOptions(t *testing.T) {
+	defaultOptions := []string{
+		fmt.Sprintf("%s=%s", fileMode, defaultFileMode),
+		fmt.Sprintf("%s=%s", dirMode, defaultDirMode),
+		fmt.Sprintf("%s=%s", actimeo, defaultActimeo),
+	}
+	tests := []struct {
+		desc             string
+		mountFlags       []string
+		enableMfsymlinks bool
+		handleTimeout    int
+		echoInterval     int
+		expected         []string
+		expectedErr      error
+	}{
+		{
+			desc:             "mfsymlinks is appended by default",
+			enableMfsymlinks: true,
+			expected:         append(defaultOptions, mfsymlinks),
+		},
+		{
+			desc:             "mfsymlinks is not appended when enableMfsymlinks is false",
+			mountFlags:       []string{"nconnect=4"},
+			enableMfsymlinks: false,
+			expected:         append([]string{"nconnect=4"}, defaultOptions...),
+		},
+		{
+			desc:             "mfsymlinks in mount flags is not duplicated",
+			mountFlags:       []string{"mfsymlinks,nconnect=4"},
+			enableMfsymlinks: true,
+			expected:         append([]string{"mfsymlinks,nconnect=4"}, defaultOptions...),
+		},
+		{
+			desc:             "unsupported unix extension options are removed",
+			mountFlags:       []string{"unix", "posix,nconnect=4", "linux"},
+			enableMfsymlinks: true,
+			expected:         append(append([]string{"nconnect=4"}, defaultOptions...), mfsymlinks),
+		},
+		{
+			desc:             "mfsymlinks conflicts with enableMfsymlinks(false)",
+			mountFlags:       []string{"nconnect=4,mfsymlinks"},
+			enableMfsymlinks: false,
+			expectedErr:      fmt.Errorf("mount option(mfsymlinks) conflicts with enablemfsymlinks(false)"),
+		},
+		{
+			desc:             "driver default handletimeout and echo_interval are appended",
+			enableMfsymlinks: true,
+			handleTimeout:    30000,
+			echoInterval:     10,
+			expected:         append(append([]string{"handletimeout=30000", "echo_interval=10"}, defaultOptions...), mfsymlinks),
+		},
+		{
+			desc:             "handletimeout and echo_interval in mount flags override driver defaults",
+			mountFlags:       []string{"handletimeout=60000,echo_interval=5"},
+			enableMfsymlinks: true,
+			handleTimeout:    30000,
+			echoInterval:     10,
+			expected:         append(append([]string{"handletimeout=60000,echo_interval=5"}, defaultOptions...), mfsymlinks),
+		},
+		{
+			desc:             "invalid handletimeout",
+			mountFlags:       []string{"handletimeout=960001"},
+			enableMfsymlinks: true,
+			expectedErr:      fmt.Errorf("invalid handletimeout(960001) in mount options, it should be between 0 and 960000 (milliseconds)"),
+		},
+		{
+			desc:             "invalid echo_interval",
+			mountFlags:       []string{"echo_interval=0"},
+			enableMfsymlinks: true,
+			expectedErr:      fmt.Errorf("invalid echo_interval(0) in mount options, it should be between 1 and 600 (seconds)"),
+		},
+		{
+			desc:             "uid, gid, forceuid and forcegid are passed through",
+			mountFlags:       []string{"uid=1000,forceuid", "GID=2000", "forcegid"},
+			enableMfsymlinks: true,
+			expected:         append(append([]string{"uid=1000,forceuid", "GID=2000", "forcegid"}, defaultOptions...), mfsymlinks),
+		},
+		{
+			desc:             "user and group names",
+			mountFlags:       []string{"uid=www-data,forceuid,gid=app_group"},
+			enableMfsymlinks: true,
+			expected:         append(append([]string{"uid=www-data,forceuid,gid=app_group"}, defaultOptions...), mfsymlinks),
+		},
+		{
+			desc:             "invalid user name",
+			mountFlags:       []string{"uid=-app"},
+			enableMfsymlinks: true,
+			expectedErr:      fmt.Errorf("invalid uid(-app) in mount options, it should be a numeric id or a user or group name"),
+		},
+		{
+			desc:             "reserved gid",
+			mountFlags:       []string{"gid=4294967295"},
+			enableMfsymlinks: true,
+			expectedErr:      fmt.Errorf("invalid gid(4294967295) in mount options, numeric id should be between 0 and 4294967294"),
+		},
+		{
+			desc:             "forceuid without uid",
+			mountFlags:       []string{"forceuid,gid=2000"},
+			enableMfsymlinks: true,
+			expectedErr:      fmt.Errorf("mount option(forceuid) requires uid in mount options"),
+		},
+		{
+			desc:             "forcegid without gid",
+			mountFlags:       []string{"forcegid"},
+			enableMfsymlinks: true,
+			expectedErr:      fmt.Errorf("mount option(forcegid) requires gid in mount options"),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := getSMBMountOptions(test.mountFlags, test.enableMfsymlinks, test.handleTimeout, test.echoInterval)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("test[%s]: unexpected error: %v, expected error: %v", test.desc, err, test.expectedErr)
+		}
+		sort.Strings(result)
+		sort.Strings(test.expected)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("test[%s]: unexpected output: %v, expected result: %v", test.desc, result, test.expected)
+		}
+	}
+}
+
+func TestGetSMBProbeTimeout(t *testing.T) {
+	tests := []struct {
+		mountOptions []string
+		expected     time.Duration
+	}{
+		{
+			expected: 120 * time.Second,
+		},
+		{
+			mountOptions: []string{"dir_mode=0777", "echo_interval=10"},
+			expected:     20 * time.Second,
+		},
+		{
+			mountOptions: []string{"echo_interval=10,handletimeout=30000"},
+			expected:     50 * time.Second,
+		},
+		{
+			mountOptions: []string{"echo_interval=invalid"},
+			expected:     120 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		result := getSMBProbeTimeout(test.mountOptions)
+		if result != test.expected {
+			t.Errorf("mountOptions(%v): unexpected output: %v, expected result: %v", test.mountOptions, result, test.expected)
+		}
+	}
+}
+
+func TestSMBVersion(t *testing.T) {
+	tests := []struct {
+		mountOptions    []string
+		version         string
+		expectedOptions []string
+	}{
+		{
+			mountOptions:    []string{"dir_mode=0777"},
+			expectedOptions: []string{"dir_mode=0777", "vers=3.0"},
+		},
+		{
+			mountOptions:    []string{"dir_mode=0777,vers=3.1.1", "actimeo=30"},
+			version:         "3.1.1",
+			expectedOptions: []string{"dir_mode=0777", "actimeo=30", "vers=3.0"},
+		},
+		{
+			mountOptions:    []string{"VERS=2.1"},
+			version:         "2.1",
+			expectedOptions: []string{"vers=3.0"},
+		},
+	}
+
+	for _, test := range tests {
+		if version := getSMBVersion(test.mountOptions); version != test.version {
+			t.Errorf("mountOptions(%v): unexpected version: %s, expected version: %s", test.mountOptions, version, test.version)
+		}
+		if options := setSMBVersion(test.mountOptions, "3.0"); !reflect.DeepEqual(options, test.expectedOptions) {
+			t.Errorf("mountOptions(%v): unexpected output: %v, expected result: %v", test.mountOptions, options, test.expectedOptions)
+		}
+	}
+}
+
+func TestSMBEncryption(t *testing.T) {
+	tests := []struct {
+		mountOptions []string
+		expected     bool
+	}{
+		{mountOptions: nil},
+		{mountOptions: []string{"dir_mode=0777,actimeo=30"}},
+		{mountOptions: []string{"dir_mode=0777,seal", "actimeo=30"}, expected: true},
+		{mountOptions: []string{"SEAL"}, expected: true},
+	}
+	for _, test := range tests {
+		if result := isSMBSealEnabled(test.mountOptions); result != test.expected {
+			t.Errorf("mountOptions(%v): unexpected result: %t, expected result: %t", test.mountOptions, result, test.expected)
+		}
+	}
+
+	for version, expected := range map[string]bool{"": true, "default": true, "3": true, "3.0": true, "3.1.1": true, "2.1": false, "2.0": false, "1.0": false} {
+		if result := isSMBEncryptionSupportedVersion(version); result != expected {
+			t.Errorf("version(%s): unexpected result: %t, expected result: %t", version, result, expected)
+		}
+	}
+}
+
+func TestIsSMBNegotiationError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{
+			err:      nil,
+			expected: false,
+		},
+		{
+			err:      fmt.Errorf("mount error(13): Permission denied"),
+			expected: false,
+		},
+		{
+			err:      fmt.Errorf("exit status 32, output: mount error(95): Operation not supported"),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("CIFS: VFS: Dialect not supported by server"),
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		if result := isSMBNegotiationError(test.err); result != test.expected {
+			t.Errorf("err(%v): unexpected output: %v, expected result: %v", test.err, result, test.expected)
+		}
+	}
+}
+
+func TestIsMountConnectivityError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{
+			err:      nil,
+			expected: false,
+		},
+		{
+			err:      fmt.Errorf("exit status 32, output: mount error(13): Permission denied"),
+			expected: false,
+		},
+		{
+			err:      fmt.Errorf("exit status 32, output: mount error(113): No route to host"),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("exit status 32, output: mount error(112): Host is down"),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("mount error: could not resolve address for account.file.core.windows.net: Unknown error"),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("mount.nfs: Failed to resolve server account.file.core.windows.net: Name or service not known"),
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("mount.nfs: access denied by server while mounting account.file.core.windows.net:/account/share"),
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		if result := isMountConnectivityError(test.err); result != test.expected {
+			t.Errorf("err(%v): unexpected output: %v, expected result: %v", test.err, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// prefix of versioned volume handles, it could not be confused with a legacy handle since a resource group name
+	// could not contain ':'
+	volumeHandleV2Prefix = "v2:"
+
+	// keys of fields in versioned volume handles
+	volumeHandleResourceGroupKey   = "rg"
+	volumeHandleAccountKey         = "account"
+	volumeHandleShareKey           = "share"
+	volumeHandleDiskKey            = "disk"
+	volumeHandleUUIDKey            = "uuid"
+	volumeHandleSecretNamespaceKey = "ns"
+	volumeHandleSubscriptionKey    = "subs"
+	volumeHandleCloudConfigKey     = "cloud"
+	volumeHandleClientIDKey        = "clientid"
+)
+
+var volumeHandleVersionRegexp = regexp.MustCompile(`^v[0-9]+:`)
+
+// reserved fields of versioned handles which are not supported, a handle with them is rejected instead of being
+// mounted without them, e.g. a handle with subdir would otherwise mount the root of the share
+var unsupportedVolumeHandleKeys = []string{"protocol", "subdir"}
+
+// volumeHandle is the parsed volume id, it's either a legacy handle delimited by '#', e.g.
+// "rg#account#share#diskname.vhd#uuid#namespace#subsID#cloudConfigName", "#account#share#diskname.vhd#namespace"
+// in csi migration, or a versioned handle, e.g. "v2:account=account&clientid=clientID&rg=rg&share=share", which is
+// self-describing, so fields could be added without ambiguity
+type volumeHandle struct {
+	resourceGroup   string
+	accountName     string
+	fileShareName   string
+	diskName        string
+	uuid            string
+	secretNamespace string
+	subsID          string
+	cloudConfigName string
+	// fields which could only be kept in versioned handles
+	// client ID of the user-assigned identity managing the storage account of the volume
+	clientID string
+
+	// versioned handles are always formatted as versioned handles, even if the fields could be kept in a legacy one
+	versioned bool
+	// segments of a parsed legacy handle, the fields which are not known are kept on formatting
+	legacySegments []string
+	// fields of a parsed versioned handle which are not known, they are kept on formatting
+	unknownFields url.Values
+}
+
+// parseVolumeHandle parses a legacy or versioned volume handle, the snapshot name appended to a versioned handle
+// is ignored
+func parseVolumeHandle(id string) (*volumeHandle, error) {
+	if strings.HasPrefix(id, volumeHandleV2Prefix) {
+		query := strings.TrimPrefix(id, volumeHandleV2Prefix)
+		if i := strings.Index(query, separator); i >= 0 {
+			query = query[:i]
+		}
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing volume id: %q, %v", id, err)
+		}
+		for _, key := range unsupportedVolumeHandleKeys {
+			if values.Has(key) {
+				return nil, fmt.Errorf("error parsing volume id: %q, field %s is not supported", id, key)
+			}
+		}
+		h := &volumeHandle{versioned: true}
+		for key, field := range h.versionedFields() {
+			*field = values.Get(key)
+			values.Del(key)
+		}
+		if len(values) > 0 {
+			h.unknownFields = values
+		}
+		return h, nil
+	}
+	if version := volumeHandleVersionRegexp.FindString(id); version != "" {
+		return nil, fmt.Errorf("error parsing volume id: %q, volume handle version %s is not supported", id, strings.TrimSuffix(version, ":"))
+	}
+
+	segments := strings.Split(id, separator)
+	if len(segments) < 3 {
+		return nil, fmt.Errorf("error parsing volume id: %q, should at least contain two #", id)
+	}
+	h := &volumeHandle{legacySegments: segments}
+	for i, field := range h.legacyFields(segments[0] == "") {
+		if i < len(segments) {
+			*field = segments[i]
+		}
+	}
+	return h, nil
+}
+
+// String formats the volume handle, a new handle is formatted as a legacy handle if all fields could be kept in it,
+// so it could be parsed by older driver versions
+func (h *volumeHandle) String() string {
+	if h.versioned || !h.isLegacyCompatible() {
+		values := url.Values{}
+		for key, vs := range h.unknownFields {
+			values[key] = vs
+		}
+		for key, field := range h.versionedFields() {
+			if *field != "" {
+				values.Set(key, *field)
+			}
+		}
+		return volumeHandleV2Prefix + values.Encode()
+	}
+
+	migration := h.resourceGroup == ""
+	fields := h.legacyFields(migration)
+	// new handles contain all fields up to secret namespace, like CreateVolume always did
+	n := len(fields) - 2
+	if migration {
+		n = len(fields)
+	}
+	if h.legacySegments != nil {
+		n = len(h.legacySegments)
+	}
+	for i, field := range fields {
+		if *field != "" && i >= n {
+			n = i + 1
+		}
+	}
+	segments := make([]string, n)
+	copy(segments, h.legacySegments)
+	for i, field := range fields {
+		if i < n {
+			segments[i] = *field
+		}
+	}
+	return strings.Join(segments, separator)
+}
+
+// isLegacyCompatible returns whether all fields could be kept in a legacy handle
+func (h *volumeHandle) isLegacyCompatible() bool {
+	if h.clientID != "" || len(h.unknownFields) > 0 {
+		return false
+	}
+	// a legacy handle without resource group does not contain uuid, subscription and cloud config
+	if h.resourceGroup == "" && (h.uuid != "" || h.subsID != "" || h.cloudConfigName != "") {
+		return false
+	}
+	for _, field := range h.versionedFields() {
+		if strings.Contains(*field, separator) {
+			return false
+		}
+	}
+	return true
+}
+
+// legacyFields returns fields in the order of segments of a legacy handle
+func (h *volumeHandle) legacyFields(migration bool) []*string {
+	if migration {
+		// in csi migration, rg is empty, then the 5th element is namespace
+		// https://github.com/kubernetes/kubernetes/blob/v1.23.5/staging/src/k8s.io/csi-translation-lib/plugins/azure_file.go#L137
+		return []*string{&h.resourceGroup, &h.accountName, &h.fileShareName, &h.diskName, &h.secretNamespace}
+	}
+	return []*string{&h.resourceGroup, &h.accountName, &h.fileShareName, &h.diskName, &h.uuid, &h.secretNamespace, &h.subsID, &h.cloudConfigName}
+}
+
+// versionedFields returns fields of a versioned handle by key
+func (h *volumeHandle) versionedFields() map[string]*string {
+	return map[string]*string{
+		volumeHandleResourceGroupKey:   &h.resourceGroup,
+		volumeHandleAccountKey:         &h.accountName,
+		volumeHandleShareKey:           &h.fileShareName,
+		volumeHandleDiskKey:            &h.diskName,
+		volumeHandleUUIDKey:            &h.uuid,
+		volumeHandleSecretNamespaceKey: &h.secretNamespace,
+		volumeHandleSubscriptionKey:    &h.subsID,
+		volumeHandleCloudConfigKey:     &h.cloudConfigName,
+		volumeHandleClientIDKey:        &h.clientID,
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVolumeHandleRoundTrip(t *testing.T) {
+	tests := []struct {
+		desc     string
+		id       string
+		expected volumeHandle
+	}{
+		{
+			desc:     "static PV",
+			id:       "rg#account#share",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share"},
+		},
+		{
+			desc:     "empty fields",
+			id:       "##",
+			expected: volumeHandle{},
+		},
+		{
+			desc:     "disk",
+			id:       "rg#account#share#diskname.vhd",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", diskName: "diskname.vhd"},
+		},
+		{
+			desc:     "uuid",
+			id:       "rg#account#share#diskname.vhd#1620118846",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", diskName: "diskname.vhd", uuid: "1620118846"},
+		},
+		{
+			desc:     "trailing empty uuid",
+			id:       "rg#account#share#diskname.vhd#",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", diskName: "diskname.vhd"},
+		},
+		{
+			desc:     "secret namespace",
+			id:       "rg#account#share#diskname.vhd#uuid#namespace",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", diskName: "diskname.vhd", uuid: "uuid", secretNamespace: "namespace"},
+		},
+		{
+			desc:     "dynamic provisioning without disk and uuid",
+			id:       "rg#account#share###",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share"},
+		},
+		{
+			desc:     "subscription",
+			id:       "rg#account#share###namespace#subsID",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", secretNamespace: "namespace", subsID: "subsID"},
+		},
+		{
+			desc:     "cloud config",
+			id:       "rg#account#share##uuid#namespace#subsID#china",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", uuid: "uuid", secretNamespace: "namespace", subsID: "subsID", cloudConfigName: "china"},
+		},
+		{
+			desc:     "csi migration",
+			id:       "#account#share##namespace",
+			expected: volumeHandle{accountName: "account", fileShareName: "share", secretNamespace: "namespace"},
+		},
+		{
+			desc:     "csi migration with more segments",
+			id:       "#account#share##uuid#namespace#subsID#china",
+			expected: volumeHandle{accountName: "account", fileShareName: "share", secretNamespace: "uuid"},
+		},
+		{
+			desc:     "versioned",
+			id:       "v2:account=account&clientid=clientID&cloud=china&disk=diskname.vhd&ns=name%23space&rg=rg&share=share&subs=subsID&uuid=uuid",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", diskName: "diskname.vhd", uuid: "uuid", secretNamespace: "name#space", subsID: "subsID", cloudConfigName: "china", clientID: "clientID"},
+		},
+		{
+			desc:     "versioned with legacy compatible fields",
+			id:       "v2:account=account&rg=rg&share=share",
+			expected: volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share"},
+		},
+		{
+			desc:     "versioned with unknown fields",
+			id:       "v2:account=account&future=value&share=share",
+			expected: volumeHandle{accountName: "account", fileShareName: "share"},
+		},
+	}
+
+	for _, test := range tests {
+		h, err := parseVolumeHandle(test.id)
+		if !assert.NoError(t, err, test.desc) {
+			continue
+		}
+		assert.Equal(t, test.expected.resourceGroup, h.resourceGroup, test.desc)
+		assert.Equal(t, test.expected.accountName, h.accountName, test.desc)
+		assert.Equal(t, test.expected.fileShareName, h.fileShareName, test.desc)
+		assert.Equal(t, test.expected.diskName, h.diskName, test.desc)
+		assert.Equal(t, test.expected.uuid, h.uuid, test.desc)
+		assert.Equal(t, test.expected.secretNamespace, h.secretNamespace, test.desc)
+		assert.Equal(t, test.expected.subsID, h.subsID, test.desc)
+		assert.Equal(t, test.expected.cloudConfigName, h.cloudConfigName, test.desc)
+		assert.Equal(t, test.expected.clientID, h.clientID, test.desc)
+		assert.Equal(t, test.id, h.String(), test.desc)
+	}
+}
+
+func TestParseVolumeHandleError(t *testing.T) {
+	tests := []struct {
+		id          string
+		expectedErr error
+	}{
+		{
+			id:          "",
+			expectedErr: fmt.Errorf("error parsing volume id: \"\", should at least contain two #"),
+		},
+		{
+			id:          "rg#account",
+			expectedErr: fmt.Errorf("error parsing volume id: \"rg#account\", should at least contain two #"),
+		},
+		{
+			id:          "v3:account=account&share=share",
+			expectedErr: fmt.Errorf("error parsing volume id: \"v3:account=account&share=share\", volume handle version v3 is not supported"),
+		},
+		{
+			id:          "v2:account=%zz",
+			expectedErr: fmt.Errorf("error parsing volume id: \"v2:account=%%zz\", invalid URL escape \"%%zz\""),
+		},
+		{
+			id:          "v2:account=account&share=share&subdir=dir",
+			expectedErr: fmt.Errorf("error parsing volume id: \"v2:account=account&share=share&subdir=dir\", field subdir is not supported"),
+		},
+		{
+			id:          "v2:account=account&protocol=nfs&share=share",
+			expectedErr: fmt.Errorf("error parsing volume id: \"v2:account=account&protocol=nfs&share=share\", field protocol is not supported"),
+		},
+	}
+
+	for _, test := range tests {
+		_, err := parseVolumeHandle(test.id)
+		assert.Equal(t, test.expectedErr, err, test.id)
+	}
+}
+
+func TestVolumeHandleString(t *testing.T) {
+	tests := []struct {
+		desc     string
+		handle   volumeHandle
+		expected string
+	}{
+		{
+			desc:     "new handle contains all fields up to secret namespace",
+			handle:   volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share"},
+			expected: "rg#account#share###",
+		},
+		{
+			desc:     "new handle with subscription",
+			handle:   volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", uuid: "pvc-name", secretNamespace: "default", subsID: "subsID"},
+			expected: "rg#account#share##pvc-name#default#subsID",
+		},
+		{
+			desc:     "new handle with cloud config",
+			handle:   volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", cloudConfigName: "china"},
+			expected: "rg#account#share#####china",
+		},
+		{
+			desc:     "new handle without resource group is in csi migration format",
+			handle:   volumeHandle{accountName: "account", fileShareName: "share", secretNamespace: "default"},
+			expected: "#account#share##default",
+		},
+		{
+			desc:     "new handle without resource group but with uuid is versioned",
+			handle:   volumeHandle{accountName: "account", fileShareName: "share", uuid: "pvc-name"},
+			expected: "v2:account=account&share=share&uuid=pvc-name",
+		},
+		{
+			desc:     "new handle with user-assigned identity is versioned",
+			handle:   volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", clientID: "clientID"},
+			expected: "v2:account=account&clientid=clientID&rg=rg&share=share",
+		},
+		{
+			desc:     "new handle with separator in fields is versioned",
+			handle:   volumeHandle{resourceGroup: "rg", accountName: "account", fileShareName: "share", secretNamespace: "a#b"},
+			expected: "v2:account=account&ns=a%23b&rg=rg&share=share",
+		},
+	}
+
+	for _, test := range tests {
+		id := test.handle.String()
+		assert.Equal(t, test.expected, id, test.desc)
+		h, err := parseVolumeHandle(id)
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.handle.secretNamespace, h.secretNamespace, test.desc)
+		assert.Equal(t, test.handle.cloudConfigName, h.cloudConfigName, test.desc)
+		assert.Equal(t, test.handle.clientID, h.clientID, test.desc)
+	}
+}
+
+func TestGetFileShareInfoVersioned(t *testing.T) {
+	rg, account, share, disk, namespace, subsID, err := GetFileShareInfo("v2:account=account&ns=namespace&rg=rg&share=share&subs=subsID#2019-08-22T07:17:53.0000000Z")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rg", "account", "share", "", "namespace", "subsID"}, []string{rg, account, share, disk, namespace, subsID})
+	assert.Equal(t, "china", getCloudConfigName("v2:account=account&cloud=china&share=share"))
+	assert.Equal(t, "", getCloudConfigName("v3:cloud=china"))
+
+	snapshot, err := getSnapshot("v2:account=account&rg=rg&share=share#2019-08-22T07:17:53.0000000Z")
+	assert.NoError(t, err)
+	assert.Equal(t, "2019-08-22T07:17:53.0000000Z", snapshot)
+	_, err = getSnapshot("v2:account=account&rg=rg&share=share")
+	assert.Equal(t, fmt.Errorf("error parsing volume id: \"v2:account=account&rg=rg&share=share\", should contain #"), err)
+}
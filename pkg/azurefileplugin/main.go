@@ -24,6 +24,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"sigs.k8s.io/azurefile-csi-driver/pkg/azurefile"
 
@@ -51,9 +52,74 @@ var (
 	mountPermissions                       = flag.Uint64("mount-permissions", 0777, "mounted folder permissions")
 	allowInlineVolumeKeyAccessWithIdentity = flag.Bool("allow-inline-volume-key-access-with-identity", false, "allow accessing storage account key using cluster identity for inline volume")
 	fsGroupChangePolicy                    = flag.String("fsgroup-change-policy", "", "indicates how the volume's ownership will be changed by the driver, OnRootMismatch is the default value")
+	applyPermissionsOnRootOnly             = flag.Bool("apply-permissions-on-root-only", false, "set volume group ownership and permissions only on the mount root directory instead of recursively, fsGroupChangePolicy None still skips it")
 	enableVHDDiskFeature                   = flag.Bool("enable-vhd", true, "enable VHD disk feature (experimental)")
 	kubeAPIQPS                             = flag.Float64("kube-api-qps", 25.0, "QPS to use while communicating with the kubernetes apiserver.")
 	kubeAPIBurst                           = flag.Int("kube-api-burst", 50, "Burst to use while communicating with the kubernetes apiserver.")
+	tagSyncInterval                        = flag.Duration("tag-sync-interval", 0, "interval of syncing PVC labels to storage account tags, 0 means disabled")
+	leaseNamespace                         = flag.String("lease-namespace", "kube-system", "namespace of the leases held by the controller replica which runs tag sync, health monitor, secret key sync and account inventory")
+	healthMonitorInterval                  = flag.Duration("health-monitor-interval", 0, "interval of checking file shares of PVs and emitting events on anomalies, 0 means disabled")
+	accountInventoryInterval               = flag.Duration("account-inventory-interval", 0, "interval of exporting file share count and provisioned quota of storage accounts created by the driver as metrics, at least 1m, 0 means disabled")
+	accountInventoryResourceGroups         = flag.String("account-inventory-resource-groups", "", "comma separated resource groups of storage accounts in account inventory metrics, resource group of cloud config is used if empty")
+	shutdownGracePeriod                    = flag.Duration("shutdown-grace-period", 20*time.Second, "maximum time to wait for in-flight requests to finish after receiving SIGTERM, should be less than terminationGracePeriodSeconds of the pod")
+	allowedPerformanceTiers                = flag.String("allowed-performance-tiers", "", "comma separated skus which could be used in PVC annotation azurefile.csi/performance-tier to override skuName in storage class, e.g. Premium_LRS,Standard_LRS, empty means disabled")
+	debugAddress                           = flag.String("debug-address", "", "address of node debug endpoint which lists staged volumes, must be bound to localhost, e.g. 127.0.0.1:29615, empty means disabled")
+	enableMountProbe                       = flag.Bool("enable-mount-probe", false, "serve mount probe on node debug endpoint(--debug-address), which mounts a volume on a temporary directory and unmounts it to check credentials, DNS and connectivity")
+	smbHandleTimeout                       = flag.Int("smb-handle-timeout", 0, "default handletimeout(in milliseconds) mount option of SMB mount, which is used when handletimeout is not set in mount options, 0 means using the kernel default")
+	smbEchoInterval                        = flag.Int("smb-echo-interval", 0, "default echo_interval(in seconds) mount option of SMB mount, which is used when echo_interval is not set in mount options, 0 means using the kernel default")
+	runStartupChecks                       = flag.Bool("run-startup-checks", false, "validate driver identity by listing storage accounts in the resource group of cloud config on start")
+	startupChecksFatal                     = flag.Bool("startup-checks-fatal", false, "exit driver if startup checks fail, only takes effect when run-startup-checks is true")
+	autoEnableLargeFileShares              = flag.Bool("auto-enable-large-file-shares", true, "enable large file shares on new standard storage account when requested share size exceeds 5TiB and enableLargeFileShares is not set in storage class")
+	armMaxRetries                          = flag.Int("arm-max-retries", 0, "maximum retries of ARM requests, overrides cloudProviderBackoffRetries in cloud config, 0 means not overridden")
+	armRetryDelay                          = flag.Duration("arm-retry-delay", 0, "initial delay between retries of ARM requests, e.g. 5s, overrides cloudProviderBackoffDuration in cloud config, 0 means not overridden")
+	armMaxRetryDelay                       = flag.Duration("arm-max-retry-delay", 0, "maximum delay between retries of ARM requests, backoff exponent is lowered to keep every retry delay under this value, 0 means no limit")
+	prewarmAccounts                        = flag.String("prewarm-accounts", "", "comma separated storage accounts whose keys are cached on start to avoid listKeys burst in mass pod reschedule, in format accountName or resourceGroup/accountName, resource group of cloud config is used if not specified")
+	prewarmAccountsFromMounts              = flag.Bool("prewarm-accounts-from-mounts", false, "cache keys of storage accounts of existing SMB mounts on node on start")
+	allowedSnapshotRetentionClasses        = flag.String("allowed-snapshot-retention-classes", "", "comma separated retention classes which could be used in retentionClass parameter of VolumeSnapshotClass, empty means any retention class is allowed")
+	allowedSMBVersions                     = flag.String("allowed-smb-versions", "", "comma separated SMB versions allowed in vers mount option of SMB mount and used by SMB version fallback, e.g. 3.1.1,3.0, empty means any version is allowed in mount option and only SMB 3 versions are used by fallback")
+	requireSMBEncryption                   = flag.Bool("require-smb-encryption", false, "mount all SMB volumes with seal(SMB3 encryption) option on this node, which could not be overridden by encryptInTransit parameter")
+	smbVersionFallback                     = flag.Bool("smb-version-fallback", false, "retry SMB mount once with the next lower allowed SMB version if the mount fails with a protocol negotiation error")
+	autoSelectSMBVersion                   = flag.Bool("auto-select-smb-version", false, "select SMB version of SMB mount without vers mount option by account tier and node kernel within allowed-smb-versions, SMB 3.1.1 is preferred where supported")
+	enableCapacityTags                     = flag.Bool("enable-capacity-tags", false, "tag storage account with total provisioned capacity(csi-provisioned-gib) of file shares and sku(csi-sku) after CreateVolume and ControllerExpandVolume")
+	enableAccountCapacityCheck             = flag.Bool("enable-account-capacity-check", false, "skip storage account selected by CreateVolume if its remaining capacity could not fit the requested file share, the account is tagged with skip-matching tag")
+	accountUsageCacheTTL                   = flag.Duration("account-usage-cache-ttl", 5*time.Minute, "how long used capacity of a storage account read by account capacity check is cached")
+	createDirectoryTimeout                 = flag.Duration("create-directory-timeout", time.Minute, "timeout of creating folderName directory in file share in CreateVolume, including all retries")
+	createDirectoryMaxRetries              = flag.Int("create-directory-max-retries", 5, "maximum retries of creating each directory of folderName in file share on transient failures, 0 means no retry")
+	migrateSourceAccount                   = flag.String("migrate-source-account", "", "storage account(accountName or resourceGroup/accountName) to copy file shares from, the driver runs a one-shot share migration and exits instead of serving CSI requests if set")
+	migrateTargetAccount                   = flag.String("migrate-target-account", "", "storage account(accountName or resourceGroup/accountName) to copy file shares to, created like the source account if it does not exist")
+	mountProfilesFile                      = flag.String("mount-profiles-file", "", "yaml or json file(e.g. mounted from a configmap) mapping mount profile name to mount options, the profile is selected by mountProfile parameter in storage class, the file is read on driver start")
+	disableStageUnstage                    = flag.Bool("disable-stage-unstage", false, "do not advertise STAGE_UNSTAGE_VOLUME node capability, the volume is mounted on target path in NodePublishVolume and unmounted in NodeUnpublishVolume, a volume used by multiple pods on one node is mounted once per pod")
+	allowBlobPublicAccess                  = flag.Bool("allow-blob-public-access", false, "default allowBlobPublicAccess of storage accounts created by CreateVolume, overridden by allowBlobPublicAccess parameter in storage class")
+	rejectPublicAccessPolicyViolation      = flag.Bool("reject-public-access-policy-violation", false, "fail CreateVolume instead of logging a warning if a reused storage account allows blob public access while the storage class disallows it and sets enforcePublicAccessPolicy")
+	rejectPublicNetworkAccessViolation     = flag.Bool("reject-public-network-access-violation", false, "fail CreateVolume instead of logging a warning if a reused storage account does not follow publicNetworkAccess parameter in storage class")
+	defaultNetworkEndpointType             = flag.String("default-network-endpoint-type", "", "network endpoint type of volumes without networkEndpointType in storage class, supported values are public and privateEndpoint, public if empty")
+	allowNetworkEndpointTypeOverride       = flag.Bool("allow-network-endpoint-type-override", true, "allow networkEndpointType in storage class to override --default-network-endpoint-type")
+	shareNameNamespace                     = flag.String("share-name-namespace", "", "namespace(e.g. cluster name) prepended to generated file share names and recorded in file share metadata, file shares of other namespaces are never reused or deleted, so clusters could share storage accounts")
+	enableTracing                          = flag.Bool("enable-tracing", false, "export OpenTelemetry spans of CSI RPCs and ARM/data plane operations over OTLP gRPC, trace context sent by the CSI sidecar is propagated")
+	otlpEndpoint                           = flag.String("otlp-endpoint", "", "OTLP gRPC endpoint(host:port) to export spans to when tracing is enabled, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317 is used if empty")
+	unstagePolicy                          = flag.String("unstage-policy", "unmount", "how NodeUnstageVolume handles a staging path still published by pods on the node: unmount(unmount anyway) or fail(fail until all pods unpublish the volume)")
+	strictSkuValidation                    = flag.Bool("strict-sku-validation", false, "only accept skuName in the sku list known by the driver, otherwise skuName is validated by Azure")
+	defaultShareQuotaGiB                   = flag.Int("default-share-quota-gib", 100, "quota(GiB) of the file share created by CreateVolume without capacity range, premium file share is rounded up to 100 GiB")
+	defaultSecretNamespace                 = flag.String("default-secret-namespace", "default", "namespace of account key secret if secretNamespace is not specified and the PVC namespace is not known, e.g. for static PVs")
+	storeAccountKey                        = flag.Bool("store-account-key", true, "store account key of storage account in secret on CreateVolume, if disabled, node gets account key with cluster identity instead of secret")
+	inheritResourceGroupTags               = flag.String("inherit-resource-group-tags", "", "comma separated names of resource group tags applied on storage accounts created by CreateVolume, * for all tags, disabled if empty")
+	credentialProviderPath                 = flag.String("credential-provider-path", "", "path of the external credential provider plugin which returns account keys instead of k8s secret or ARM, disabled if empty")
+	credentialProviderTimeout              = flag.Duration("credential-provider-timeout", 10*time.Second, "timeout of invoking the credential provider plugin")
+	credentialProviderCacheTTL             = flag.Duration("credential-provider-cache-ttl", 5*time.Minute, "TTL of account keys returned by the credential provider plugin, 0 disables caching")
+	deleteUnownedShares                    = flag.Bool("delete-unowned-shares", false, "delete file shares which are not created or adopted by the driver in DeleteVolume, they are kept by default")
+	deleteEmptyAccounts                    = flag.Bool("delete-empty-accounts", false, "delete storage accounts created by the driver when the last file share in them is deleted by DeleteVolume")
+	dnsReadinessTimeout                    = flag.Duration("dns-readiness-timeout", 0, "resolve the file server address in NodeStageVolume for up to this timeout before mounting, a private endpoint server must resolve to private addresses, 0 disables the check")
+	allowCrossRegionMount                  = flag.Bool("allow-cross-region-mount", true, "allow NodeStageVolume to mount file shares on storage accounts in another region than the node, the region of the node is read from topology.kubernetes.io/region label of the node or the cloud config")
+	secretKeySyncInterval                  = flag.Duration("secret-key-sync-interval", 0, "interval of refreshing account keys in secrets created by the driver from storage accounts, also triggered by mount failures with permission denied, 0 means disabled")
+	belowMinimumCapacityPolicy             = flag.String("below-minimum-capacity-policy", "round-up", "how CreateVolume handles a requested capacity below the minimum share size of the sku(100 GiB on premium): round-up(provision the minimum share size) or reject(fail with OutOfRange)")
+	reportSMBShareStats                    = flag.Bool("report-smb-share-stats", false, "report quota and usage of SMB file shares mounted with account key from data plane API in NodeGetVolumeStats instead of statfs, results are cached for 1 minute")
+	upgradeV1Accounts                      = flag.Bool("upgrade-v1-accounts", false, "upgrade StorageV1 accounts matching the storage class to StorageV2 before CreateVolume selects an account, StorageV1 accounts are skipped in selection if not set")
+	deleteVolumeQPSPerAccount              = flag.Float64("delete-volume-qps-per-account", 1, "maximum file share deletions per second on one storage account in DeleteVolume, DeleteVolume returns Unavailable if it could not be started before its deadline, 0 means no limit")
+	deleteVolumeBurstPerAccount            = flag.Int("delete-volume-burst-per-account", 5, "maximum burst of file share deletions on one storage account in DeleteVolume")
+	singleWriterViolationPolicy            = flag.String("single-writer-violation-policy", "ignore", "how NodeStageVolume and NodePublishVolume handle a ReadWriteOncePod volume whose file share is mounted by another volume or pod on the node: ignore, warn(log a warning) or reject(fail with FailedPrecondition)")
+	migrateShares                          = flag.String("migrate-shares", "", "comma separated file shares to copy from migrate-source-account to migrate-target-account")
+	adoptShares                            = flag.String("adopt-shares", "", "comma separated volume handles of existing file shares to adopt in share-name-namespace, the driver exits after adoption if set")
+	additionalCloudConfigs                 = flag.String("additional-cloud-configs", "", "comma separated cloud config files of other Azure clouds or regions in format cloudConfigName=path, selected by cloudConfigName parameter in storage class")
 )
 
 func main() {
@@ -90,9 +156,74 @@ func handle() {
 		MountPermissions:                       *mountPermissions,
 		AllowInlineVolumeKeyAccessWithIdentity: *allowInlineVolumeKeyAccessWithIdentity,
 		FSGroupChangePolicy:                    *fsGroupChangePolicy,
+		ApplyPermissionsOnRootOnly:             *applyPermissionsOnRootOnly,
 		EnableVHDDiskFeature:                   *enableVHDDiskFeature,
 		KubeAPIQPS:                             *kubeAPIQPS,
 		KubeAPIBurst:                           *kubeAPIBurst,
+		TagSyncInterval:                        *tagSyncInterval,
+		LeaseNamespace:                         *leaseNamespace,
+		HealthMonitorInterval:                  *healthMonitorInterval,
+		ShutdownGracePeriod:                    *shutdownGracePeriod,
+		AllowedPerformanceTiers:                *allowedPerformanceTiers,
+		DebugAddress:                           *debugAddress,
+		EnableMountProbe:                       *enableMountProbe,
+		SMBHandleTimeout:                       *smbHandleTimeout,
+		SMBEchoInterval:                        *smbEchoInterval,
+		RunStartupChecks:                       *runStartupChecks,
+		StartupChecksFatal:                     *startupChecksFatal,
+		AutoEnableLargeFileShares:              *autoEnableLargeFileShares,
+		ARMMaxRetries:                          *armMaxRetries,
+		ARMRetryDelay:                          *armRetryDelay,
+		ARMMaxRetryDelay:                       *armMaxRetryDelay,
+		PrewarmAccounts:                        *prewarmAccounts,
+		PrewarmAccountsFromMounts:              *prewarmAccountsFromMounts,
+		AdditionalCloudConfigs:                 *additionalCloudConfigs,
+		AllowedSnapshotRetentionClasses:        *allowedSnapshotRetentionClasses,
+		AllowedSMBVersions:                     *allowedSMBVersions,
+		SMBVersionFallback:                     *smbVersionFallback,
+		AutoSelectSMBVersion:                   *autoSelectSMBVersion,
+		RequireSMBEncryption:                   *requireSMBEncryption,
+		EnableCapacityTags:                     *enableCapacityTags,
+		EnableAccountCapacityCheck:             *enableAccountCapacityCheck,
+		AccountUsageCacheTTL:                   *accountUsageCacheTTL,
+		CreateDirectoryTimeout:                 *createDirectoryTimeout,
+		CreateDirectoryMaxRetries:              *createDirectoryMaxRetries,
+		MigrateSourceAccount:                   *migrateSourceAccount,
+		MigrateTargetAccount:                   *migrateTargetAccount,
+		MigrateShares:                          *migrateShares,
+		AdoptShares:                            *adoptShares,
+		MountProfilesFile:                      *mountProfilesFile,
+		DisableStageUnstage:                    *disableStageUnstage,
+		AllowBlobPublicAccess:                  *allowBlobPublicAccess,
+		RejectPublicAccessPolicyViolation:      *rejectPublicAccessPolicyViolation,
+		RejectPublicNetworkAccessViolation:     *rejectPublicNetworkAccessViolation,
+		DefaultNetworkEndpointType:             *defaultNetworkEndpointType,
+		DisallowNetworkEndpointTypeOverride:    !*allowNetworkEndpointTypeOverride,
+		ShareNameNamespace:                     *shareNameNamespace,
+		EnableTracing:                          *enableTracing,
+		OTLPEndpoint:                           *otlpEndpoint,
+		UnstagePolicy:                          *unstagePolicy,
+		StrictSkuValidation:                    *strictSkuValidation,
+		DefaultShareQuotaGiB:                   *defaultShareQuotaGiB,
+		DefaultSecretNamespace:                 *defaultSecretNamespace,
+		DisableStoreAccountKey:                 !*storeAccountKey,
+		InheritResourceGroupTags:               *inheritResourceGroupTags,
+		CredentialProviderPath:                 *credentialProviderPath,
+		CredentialProviderTimeout:              *credentialProviderTimeout,
+		CredentialProviderCacheTTL:             *credentialProviderCacheTTL,
+		DeleteUnownedShares:                    *deleteUnownedShares,
+		DeleteEmptyAccounts:                    *deleteEmptyAccounts,
+		DNSReadinessTimeout:                    *dnsReadinessTimeout,
+		AllowCrossRegionMount:                  *allowCrossRegionMount,
+		SecretKeySyncInterval:                  *secretKeySyncInterval,
+		BelowMinimumCapacityPolicy:             *belowMinimumCapacityPolicy,
+		ReportSMBShareStats:                    *reportSMBShareStats,
+		UpgradeV1Accounts:                      *upgradeV1Accounts,
+		DeleteVolumeQPSPerAccount:              *deleteVolumeQPSPerAccount,
+		DeleteVolumeBurstPerAccount:            *deleteVolumeBurstPerAccount,
+		SingleWriterViolationPolicy:            *singleWriterViolationPolicy,
+		AccountInventoryInterval:               *accountInventoryInterval,
+		AccountInventoryResourceGroups:         *accountInventoryResourceGroups,
 	}
 	driver := azurefile.NewDriver(&driverOptions)
 	if driver == nil {
@@ -39,21 +39,41 @@ type NonBlockingGRPCServer interface {
 	Stop()
 	// Stops the service forcefully
 	ForceStop()
+	// Stops the service gracefully, stops forcefully if in-flight requests are not finished within timeout
+	StopWithTimeout(timeout time.Duration)
 }
 
-func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
-	return &nonBlockingGRPCServer{}
+// NewNonBlockingGRPCServer returns a server running interceptors before logging each request
+func NewNonBlockingGRPCServer(interceptors ...grpc.UnaryServerInterceptor) NonBlockingGRPCServer {
+	return &nonBlockingGRPCServer{interceptors: interceptors}
 }
 
 // NonBlocking server
 type nonBlockingGRPCServer struct {
-	wg     sync.WaitGroup
-	server *grpc.Server
+	wg           sync.WaitGroup
+	server       *grpc.Server
+	interceptors []grpc.UnaryServerInterceptor
 }
 
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) {
+	// server is created before serving in background, so that it could be stopped right after Start returns
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(append(s.interceptors, logGRPC)...),
+	}
+	s.server = grpc.NewServer(opts...)
+
+	if ids != nil {
+		csi.RegisterIdentityServer(s.server, ids)
+	}
+	if cs != nil {
+		csi.RegisterControllerServer(s.server, cs)
+	}
+	if ns != nil {
+		csi.RegisterNodeServer(s.server, ns)
+	}
+
 	s.wg.Add(1)
-	go s.serve(endpoint, ids, cs, ns, testMode)
+	go s.serve(endpoint, testMode)
 }
 
 func (s *nonBlockingGRPCServer) Wait() {
@@ -68,7 +88,22 @@ func (s *nonBlockingGRPCServer) ForceStop() {
 	s.server.Stop()
 }
 
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) {
+func (s *nonBlockingGRPCServer) StopWithTimeout(timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		klog.Warningf("in-flight requests are not finished within %v, stop forcefully", timeout)
+		s.server.Stop()
+	}
+}
+
+func (s *nonBlockingGRPCServer) serve(endpoint string, testMode bool) {
 
 	proto, addr, err := ParseEndpoint(endpoint)
 	if err != nil {
@@ -89,21 +124,6 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 		klog.Fatalf("Failed to listen: %v", err)
 	}
 
-	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
-	}
-	server := grpc.NewServer(opts...)
-	s.server = server
-
-	if ids != nil {
-		csi.RegisterIdentityServer(server, ids)
-	}
-	if cs != nil {
-		csi.RegisterControllerServer(server, cs)
-	}
-	if ns != nil {
-		csi.RegisterNodeServer(server, ns)
-	}
 	// Used to stop the server while running tests
 	if testMode {
 		s.wg.Done()
@@ -115,8 +135,13 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 		}()
 	}
 
+	if !testMode {
+		// let Wait() return after server is stopped
+		defer s.wg.Done()
+	}
+
 	klog.Infof("Listening for connections on address: %#v", listener.Addr())
-	if err := server.Serve(listener); err != nil {
+	if err := s.server.Serve(listener); err != nil {
 		klog.Errorf("Listening for connections on address: %#v, error: %v", listener.Addr(), err)
 	}
 }
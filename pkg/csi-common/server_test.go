@@ -17,6 +17,7 @@ limitations under the License.
 package csicommon
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -28,6 +29,12 @@ import (
 func TestNewNonBlockingGRPCServer(t *testing.T) {
 	s := NewNonBlockingGRPCServer()
 	assert.NotNil(t, s)
+
+	interceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(ctx, req)
+	}
+	s = NewNonBlockingGRPCServer(interceptor)
+	assert.Len(t, s.(*nonBlockingGRPCServer).interceptors, 1)
 }
 
 func TestStart(t *testing.T) {
@@ -44,7 +51,7 @@ func TestServe(t *testing.T) {
 	s.wg = sync.WaitGroup{}
 	//need to add one here as the actual also requires one.
 	s.wg.Add(1)
-	s.serve("tcp://127.0.0.1:0", nil, nil, nil, true)
+	s.serve("tcp://127.0.0.1:0", true)
 }
 
 func TestWait(t *testing.T) {
@@ -65,3 +72,26 @@ func TestForceStop(t *testing.T) {
 	s.server = grpc.NewServer()
 	s.ForceStop()
 }
+
+func TestStopWithTimeout(t *testing.T) {
+	s := nonBlockingGRPCServer{}
+	s.server = grpc.NewServer()
+	s.StopWithTimeout(time.Second)
+}
+
+func TestStopWithTimeoutRightAfterStart(t *testing.T) {
+	s := NewNonBlockingGRPCServer()
+	s.Start("tcp://127.0.0.1:0", nil, nil, nil, false)
+	s.StopWithTimeout(time.Second)
+
+	waited := make(chan struct{})
+	go func() {
+		s.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server is not stopped")
+	}
+}